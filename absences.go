@@ -0,0 +1,189 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+/* ===================
+   Absences / Leave
+   =================== */
+
+const (
+    absenceRequested = "requested"
+    absenceApproved  = "approved"
+    absenceDenied    = "denied"
+)
+
+type Absence struct {
+    ID        string    `json:"id"`
+    Tenant    string    `json:"tenant,omitempty"` // empty on absences kept before tenants
+    Employee  string    `json:"employee"`         // employee name as written on the card
+    Kind      string    `json:"kind"`             // vacation, sick, leave, ...
+    StartDate string    `json:"start_date"`       // YYYY-MM-DD, inclusive
+    EndDate   string    `json:"end_date"`         // YYYY-MM-DD, inclusive
+    Status    string    `json:"status"`           // requested, approved, denied
+    Note      string    `json:"note,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+    UpdatedAt time.Time `json:"updated_at"`
+}
+
+var absences = openCollection[Absence]("absences")
+
+// tenant is the absence's tenant; older ones belong to the default tenant
+func (a Absence) tenant() string {
+    if a.Tenant == "" {
+        return defaultTenantID
+    }
+    return a.Tenant
+}
+
+// covers reports whether the absence spans the given YYYY-MM-DD date
+func (a Absence) covers(date string) bool {
+    return a.StartDate <= date && date <= a.EndDate
+}
+
+// approvedAbsenceOn returns the tenant's approved absence covering date for
+// employee, if any
+func approvedAbsenceOn(tenant, employee, date string) (Absence, bool) {
+    for _, a := range absences.List() {
+        if a.tenant() == tenant && a.Status == absenceApproved && strings.EqualFold(a.Employee, employee) && a.covers(date) {
+            return a, true
+        }
+    }
+    return Absence{}, false
+}
+
+// absencesHandler serves:
+//   GET    /api/absences?employee=&from=&to=&status=
+//   POST   /api/absences
+//   POST   /api/absences/{id}/approve
+//   POST   /api/absences/{id}/deny
+//   DELETE /api/absences/{id}
+func absencesHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/absences"), "/")
+    parts := strings.Split(rest, "/")
+    if a, ok := absences.Get(parts[0]); ok && rest != "" && a.tenant() != tenantFor(r).ID {
+        httpError(w, r, "absence not found", http.StatusNotFound)
+        return
+    }
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        listAbsences(w, r)
+    case rest == "" && r.Method == http.MethodPost:
+        createAbsence(w, r)
+    case len(parts) == 1 && r.Method == http.MethodDelete:
+        if _, ok := absences.Get(parts[0]); !ok {
+            httpError(w, r, "absence not found", http.StatusNotFound)
+            return
+        }
+        if err := absences.Delete(parts[0]); err != nil {
+            httpError(w, r, fmt.Sprintf("error deleting absence: %v", err), http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    case len(parts) == 2 && r.Method == http.MethodPost && (parts[1] == "approve" || parts[1] == "deny"):
+        status := absenceApproved
+        if parts[1] == "deny" {
+            status = absenceDenied
+        }
+        setAbsenceStatus(w, r, parts[0], status)
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func listAbsences(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    employee, from, to, status := q.Get("employee"), q.Get("from"), q.Get("to"), q.Get("status")
+
+    tenant := tenantFor(r).ID
+
+    out := []Absence{}
+    for _, a := range absences.List() {
+        if a.tenant() != tenant {
+            continue
+        }
+        if employee != "" && !strings.EqualFold(a.Employee, employee) {
+            continue
+        }
+        if status != "" && a.Status != status {
+            continue
+        }
+        if from != "" && a.EndDate < from {
+            continue
+        }
+        if to != "" && a.StartDate > to {
+            continue
+        }
+        out = append(out, a)
+    }
+    writeJSON(w, http.StatusOK, out)
+}
+
+func createAbsence(w http.ResponseWriter, r *http.Request) {
+    var a Absence
+    if err := json.NewDecoder(r.Body).Decode(&a); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if a.Employee == "" {
+        httpError(w, r, "invalid request: employee is required", http.StatusBadRequest)
+        return
+    }
+    if a.EndDate == "" {
+        a.EndDate = a.StartDate
+    }
+    for _, d := range []string{a.StartDate, a.EndDate} {
+        if _, err := time.Parse("2006-01-02", d); err != nil {
+            httpError(w, r, fmt.Sprintf("invalid request: bad date %q (want YYYY-MM-DD)", d), http.StatusBadRequest)
+            return
+        }
+    }
+    if a.EndDate < a.StartDate {
+        httpError(w, r, "invalid request: end_date is before start_date", http.StatusBadRequest)
+        return
+    }
+    if a.Kind == "" {
+        a.Kind = "vacation"
+    }
+    switch a.Status {
+    case "":
+        a.Status = absenceRequested
+    case absenceRequested, absenceApproved, absenceDenied:
+    default:
+        httpError(w, r, fmt.Sprintf("invalid request: unknown status %q", a.Status), http.StatusBadRequest)
+        return
+    }
+
+    a.ID = newID()
+    a.Tenant = tenantFor(r).ID
+    a.CreatedAt = now().UTC()
+    a.UpdatedAt = a.CreatedAt
+    if err := absences.Put(a.ID, a); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving absence: %v", err), http.StatusInternalServerError)
+        return
+    }
+    loggerFrom(r.Context()).Printf("Absence %s recorded for %s: %s %s..%s (%s)", a.ID, a.Employee, a.Kind, a.StartDate, a.EndDate, a.Status)
+    writeJSON(w, http.StatusCreated, a)
+}
+
+func setAbsenceStatus(w http.ResponseWriter, r *http.Request, id, status string) {
+    a, ok := absences.Get(id)
+    if !ok {
+        httpError(w, r, "absence not found", http.StatusNotFound)
+        return
+    }
+    a.Status = status
+    a.UpdatedAt = now().UTC()
+    if err := absences.Put(a.ID, a); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving absence: %v", err), http.StatusInternalServerError)
+        return
+    }
+    loggerFrom(r.Context()).Printf("Absence %s for %s marked %s", a.ID, a.Employee, status)
+    writeJSON(w, http.StatusOK, a)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ==========
+   Access log
+   ========== */
+
+// Access log lines are JSON objects written to ACCESS_LOG ("stdout" by
+// default, "stderr", "off", or a file path), apart from the application log
+// which stays on stderr via the log package.
+
+type accessEntry struct {
+    Time          time.Time `json:"time"`
+    Method        string    `json:"method"`
+    Path          string    `json:"path"`
+    Status        int       `json:"status"`
+    LatencyMS     float64   `json:"latency_ms"`
+    RequestBytes  int64     `json:"request_bytes"`
+    ResponseBytes int       `json:"response_bytes"`
+    ClientIP      string    `json:"client_ip"`
+    UserAgent     string    `json:"user_agent,omitempty"`
+    Tenant        string    `json:"tenant,omitempty"`
+    Identity      string    `json:"identity,omitempty"` // authenticated principal, set by auth
+    RequestID     string    `json:"request_id,omitempty"`
+
+    mu sync.Mutex
+}
+
+type accessEntryKey struct{}
+
+// setAccessIdentity lets downstream middleware (auth) name the caller, in
+// the access log and the audit log
+func setAccessIdentity(ctx context.Context, identity string) {
+    setAuditActor(ctx, identity)
+    if e, ok := ctx.Value(accessEntryKey{}).(*accessEntry); ok {
+        e.mu.Lock()
+        e.Identity = identity
+        e.mu.Unlock()
+    }
+}
+
+// setAccessTenant records the tenant auth settled on, which may differ from
+// the X-Tenant-ID header (or stand in for a missing one)
+func setAccessTenant(ctx context.Context, tenant string) {
+    setAuditTenant(ctx, tenant)
+    if e, ok := ctx.Value(accessEntryKey{}).(*accessEntry); ok {
+        e.mu.Lock()
+        e.Tenant = tenant
+        e.mu.Unlock()
+    }
+}
+
+var (
+    accessLogOnce sync.Once
+    accessLogOut  io.Writer
+    accessLogMu   sync.Mutex
+)
+
+func accessLogWriter() io.Writer {
+    accessLogOnce.Do(func() {
+        switch dest := envOr("ACCESS_LOG", "stdout"); dest {
+        case "off":
+        case "stdout":
+            accessLogOut = os.Stdout
+        case "stderr":
+            accessLogOut = os.Stderr
+        default:
+            f, err := os.OpenFile(dest, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+            if err != nil {
+                log.Printf("Warning: cannot open access log %s, using stdout: %v", dest, err)
+                accessLogOut = os.Stdout
+                return
+            }
+            accessLogOut = f
+        }
+    })
+    return accessLogOut
+}
+
+// accessLogMiddleware wraps the whole mux so every route is recorded
+func accessLogMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        out := accessLogWriter()
+        if out == nil {
+            next.ServeHTTP(w, r)
+            return
+        }
+
+        entry := &accessEntry{
+            Time:         time.Now().UTC(),
+            Method:       r.Method,
+            Path:         r.URL.Path,
+            RequestBytes: r.ContentLength,
+            ClientIP:     clientIP(r),
+            UserAgent:    r.UserAgent(),
+            Tenant:       r.Header.Get("X-Tenant-ID"),
+        }
+        rec := &statusRecorder{ResponseWriter: w}
+        start := time.Now()
+        next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), accessEntryKey{}, entry)))
+
+        entry.mu.Lock()
+        entry.Status = rec.status
+        if entry.Status == 0 {
+            entry.Status = http.StatusOK
+        }
+        entry.LatencyMS = float64(time.Since(start).Microseconds()) / 1000
+        entry.ResponseBytes = rec.bytes
+        entry.RequestID = rec.Header().Get("X-Request-ID")
+        line, err := json.Marshal(entry)
+        entry.mu.Unlock()
+        if err != nil {
+            return
+        }
+        accessLogMu.Lock()
+        _, _ = out.Write(append(line, '\n'))
+        accessLogMu.Unlock()
+    })
+}
+
+// clientIP prefers the first X-Forwarded-For hop (Render and most proxies
+// set it) and falls back to the socket address.
+func clientIP(r *http.Request) string {
+    if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+        first, _, _ := strings.Cut(fwd, ",")
+        return strings.TrimSpace(first)
+    }
+    host, _, err := net.SplitHostPort(r.RemoteAddr)
+    if err != nil {
+        return r.RemoteAddr
+    }
+    return host
+}
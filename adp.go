@@ -0,0 +1,142 @@
+package main
+
+import (
+    "bytes"
+    "encoding/csv"
+    "fmt"
+    "sort"
+)
+
+/* ==========
+   ADP export
+   ========== */
+
+// The "adp" export writes the pay period's hours in ADP's EPI paydata
+// import layout (EPI<company code><batch id>.csv), one row per employee:
+//
+//   Co Code, Batch ID, File #, Reg Hours, O/T Hours,
+//   Hours 3 Code, Hours 3 Amount, Hours 4 Code, Hours 4 Amount
+//
+// Night-shift hours are taken out of Reg/O/T and reported under their own
+// earnings codes in the Hours 3 (regular) and Hours 4 (overtime) columns.
+// The body is one card or a JSON array of cards for the same pay period;
+// an employee's cards are added together. The company's codes and each
+// employee's file number are tenant config:
+//
+//   "adp": {"company_code": "XYZ", "batch_id": "TC",
+//           "night_code": "N", "night_overtime_code": "NOT",
+//           "file_numbers": {"Bob Smith": "001234"}}
+
+// ADPSettings is how a tenant's cards map onto its ADP company
+type ADPSettings struct {
+    CompanyCode       string            `json:"company_code"`
+    BatchID           string            `json:"batch_id,omitempty"`
+    NightCode         string            `json:"night_code,omitempty"`
+    NightOvertimeCode string            `json:"night_overtime_code,omitempty"`
+    FileNumbers       map[string]string `json:"file_numbers,omitempty"` // employee name → ADP file #
+}
+
+// adpRow is one employee's hours for the period
+type adpRow struct {
+    fileNumber                        string
+    regular, overtime, night, nightOT float64
+}
+
+func init() {
+    registerExporter(adpExporter{})
+}
+
+type adpExporter struct{}
+
+func (adpExporter) Name() string        { return "adp" }
+func (adpExporter) ContentType() string { return "text/csv; charset=utf-8" }
+
+// FileName is the EPI<company code><batch id>.csv name ADP's import expects
+func (adpExporter) FileName(t *Tenant, reqs []TimecardRequest) string {
+    if t.ADP == nil {
+        return "EPI.csv"
+    }
+    return fmt.Sprintf("EPI%s%s.csv", pathSegment(t.ADP.CompanyCode), pathSegment(t.ADP.BatchID))
+}
+
+func (adpExporter) Export(t *Tenant, reqs []TimecardRequest) ([]byte, error) {
+    s := t.ADP
+    var v validationResult
+    if s == nil || s.CompanyCode == "" {
+        v.errorf("ADP export is not configured for this tenant")
+        return nil, v
+    }
+    for i, req := range reqs {
+        if s.FileNumbers[req.EmployeeName] == "" {
+            v.errorf("%s: no ADP file number for this employee", cardLabel(i, req))
+        }
+        if req.PayPeriodNum != reqs[0].PayPeriodNum || req.Year != reqs[0].Year {
+            v.errorf("%s: all timecards must be for the same pay period", cardLabel(i, req))
+        }
+    }
+    if len(v.Errors) > 0 {
+        return nil, v
+    }
+
+    byFile := map[string]*adpRow{}
+    for _, req := range reqs {
+        file := s.FileNumbers[req.EmployeeName]
+        row := byFile[file]
+        if row == nil {
+            row = &adpRow{fileNumber: file}
+            byFile[file] = row
+        }
+        for _, l := range hourLines(req, t.location()) {
+            switch {
+            case l.Night && l.Overtime:
+                row.nightOT += l.Hours
+            case l.Night:
+                row.night += l.Hours
+            case l.Overtime:
+                row.overtime += l.Hours
+            default:
+                row.regular += l.Hours
+            }
+        }
+    }
+    rows := make([]*adpRow, 0, len(byFile))
+    for _, row := range byFile {
+        rows = append(rows, row)
+    }
+    sort.Slice(rows, func(i, j int) bool { return rows[i].fileNumber < rows[j].fileNumber })
+    return adpEPI(s, rows), nil
+}
+
+// adpEPI renders the rows as an EPI CSV
+func adpEPI(s *ADPSettings, rows []*adpRow) []byte {
+    nightCode, nightOTCode := s.NightCode, s.NightOvertimeCode
+    if nightCode == "" {
+        nightCode = "N"
+    }
+    if nightOTCode == "" {
+        nightOTCode = "NOT"
+    }
+    var b bytes.Buffer
+    cw := csv.NewWriter(&b)
+    _ = cw.Write([]string{"Co Code", "Batch ID", "File #", "Reg Hours", "O/T Hours", "Hours 3 Code", "Hours 3 Amount", "Hours 4 Code", "Hours 4 Amount"})
+    for _, row := range rows {
+        rec := []string{s.CompanyCode, s.BatchID, row.fileNumber, adpHours(row.regular), adpHours(row.overtime), "", "", "", ""}
+        if row.night != 0 {
+            rec[5], rec[6] = nightCode, adpHours(row.night)
+        }
+        if row.nightOT != 0 {
+            rec[7], rec[8] = nightOTCode, adpHours(row.nightOT)
+        }
+        _ = cw.Write(rec)
+    }
+    cw.Flush()
+    return b.Bytes()
+}
+
+// adpHours formats hours to two decimals, blank for none
+func adpHours(h float64) string {
+    if h == 0 {
+        return ""
+    }
+    return fmt.Sprintf("%.2f", h)
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "testing"
+)
+
+// withTenants loads the test's tenants from config, as TENANTS_FILE would
+func withTenants(t *testing.T, config string) {
+    t.Helper()
+    path := filepath.Join(t.TempDir(), "tenants.json")
+    if err := os.WriteFile(path, []byte(config), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("TENANTS_FILE", path)
+    tenantsOnce, tenants = sync.Once{}, nil
+    t.Cleanup(func() { tenantsOnce, tenants = sync.Once{}, nil })
+}
+
+// postCards POSTs cards as JSON to handler for tenant
+func postCards(t *testing.T, handler http.HandlerFunc, path, tenant string, cards interface{}) *httptest.ResponseRecorder {
+    t.Helper()
+    body, err := json.Marshal(cards)
+    if err != nil {
+        t.Fatal(err)
+    }
+    r := httptest.NewRequest(http.MethodPost, path, strings.NewReader(string(body)))
+    r.Header.Set("X-Tenant-ID", tenant)
+    w := httptest.NewRecorder()
+    handler(w, r)
+    return w
+}
+
+const adpTestTenants = `{
+  "acme": {"adp": {"company_code": "XYZ", "batch_id": "TC", "night_overtime_code": "NO",
+                   "file_numbers": {"Bob Smith": "001234", "Ann Lee": "000777"}}},
+  "plain": {}
+}`
+
+func TestADPExport(t *testing.T) {
+    withTenants(t, adpTestTenants)
+    bob := exportTestCard()
+    bob.Entries = bob.Entries[:5] // without the undated entry
+    second := exportTestCard()
+    second.Entries = []Entry{{Date: "2025-01-08T00:00:00Z", JobCode: "29699", Hours: 2, Overtime: true, IsNightShift: true}}
+    ann := exportTestCard()
+    ann.EmployeeName = "Ann Lee"
+    ann.Entries = []Entry{{Date: "2025-01-08T00:00:00Z", JobCode: "29699", Hours: 10}}
+
+    w := postCards(t, exportHandler, "/api/export/adp", "acme", []TimecardRequest{bob, ann, second})
+    if w.Code != http.StatusOK {
+        t.Fatalf("%d %s", w.Code, w.Body)
+    }
+    if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="EPIXYZTC.csv"` {
+        t.Errorf("Content-Disposition = %s", got)
+    }
+    // sorted by file number, Bob's two cards added up
+    assertLines(t, "EPI", strings.Split(strings.TrimSpace(w.Body.String()), "\n"), []string{
+        "Co Code,Batch ID,File #,Reg Hours,O/T Hours,Hours 3 Code,Hours 3 Amount,Hours 4 Code,Hours 4 Amount",
+        "XYZ,TC,000777,10.00,,,,,",
+        "XYZ,TC,001234,8.50,1.50,N,7.75,NO,2.00",
+    })
+}
+
+func TestADPExportRefuses(t *testing.T) {
+    withTenants(t, adpTestTenants)
+    other := exportTestCard()
+    other.PayPeriodNum = 4
+    stranger := exportTestCard()
+    stranger.EmployeeName = "Raj Patel"
+
+    tests := []struct {
+        name   string
+        tenant string
+        body   interface{}
+        want   string
+    }{
+        {"not configured", "plain", exportTestCard(), "ADP export is not configured"},
+        {"no file number", "acme", stranger, "timecard 1 (Raj Patel): no ADP file number"},
+        {"mixed pay periods", "acme", []TimecardRequest{exportTestCard(), other}, "timecard 2 (Bob Smith): all timecards must be for the same pay period"},
+        {"empty batch", "acme", []TimecardRequest{}, "no timecards"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            w := postCards(t, exportHandler, "/api/export/adp", tt.tenant, tt.body)
+            if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+                t.Errorf("%d %s, want 400 with %q", w.Code, w.Body, tt.want)
+            }
+        })
+    }
+}
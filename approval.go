@@ -0,0 +1,400 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "html/template"
+    "net/http"
+    "net/mail"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* =================
+   Manager approvals
+   ================= */
+
+// A card goes to a manager for approval when it is sent with a
+// manager_email (to any endpoint that keeps a record), or later with
+//
+//   POST /api/timecards/{id}/submit {"manager_email": "..."}
+//
+// The "manager-notify" job then emails the manager the hours summary with
+// the workbook attached and a link to /approve/{id}?token=..., a page
+// showing the summary with Approve and Reject buttons. A rejection needs a
+// comment, which is kept on the record (GET /api/timecards/{id}) for the
+// employee to act on; the corrected card comes back with
+//
+//   POST /api/timecards/{id}/resubmit   the whole corrected card
+//
+// which bumps the record's revision (printed on the sheet as "Rev. N") and
+// puts it in front of the manager again. Past decisions stay in Reviews.
+//
+// The token is an HMAC of the record id and revision under APPROVAL_SECRET,
+// so a link dies with its revision; links need that and PUBLIC_BASE_URL.
+// Opening a link changes nothing, only the buttons' POSTs do, since mail
+// scanners follow links.
+
+const (
+    approvalPending  = "pending"
+    approvalApproved = "approved"
+    approvalRejected = "rejected"
+)
+
+// Approval is where a record stands with its manager
+type Approval struct {
+    Status      string     `json:"status"` // pending, approved or rejected
+    Manager     string     `json:"manager"`
+    Revision    int        `json:"revision"`
+    Comment     string     `json:"comment,omitempty"` // the manager's reason for rejecting
+    SubmittedAt time.Time  `json:"submitted_at"`
+    NotifiedAt  *time.Time `json:"notified_at,omitempty"`
+    DecidedAt   *time.Time `json:"decided_at,omitempty"`
+}
+
+// approvalsMu serializes approval changes to records
+var approvalsMu sync.Mutex
+
+func init() {
+    registerJobKind("manager-notify", runManagerNotifyJob)
+}
+
+// newApproval is a fresh request for manager's approval of a revision
+func newApproval(manager string, revision int) *Approval {
+    return &Approval{Status: approvalPending, Manager: manager, Revision: revision, SubmittedAt: now().UTC()}
+}
+
+// notifyManager queues the approval request email for a saved record
+func notifyManager(ctx context.Context, id string) {
+    owner := identityFrom(ctx)
+    if owner == "" {
+        owner = "system"
+    }
+    if _, err := enqueueJob("manager-notify", owner, 0, map[string]string{"timecard_id": id}); err != nil {
+        loggerFrom(ctx).Printf("Warning: could not queue manager notification for %s: %v", id, err)
+    }
+}
+
+func runManagerNotifyJob(ctx context.Context, job *BackgroundJob) (interface{}, error) {
+    var p struct {
+        TimecardID string `json:"timecard_id"`
+    }
+    if err := json.Unmarshal(job.Params, &p); err != nil {
+        return nil, fmt.Errorf("invalid params: %w", err)
+    }
+    rec, ok := timecards.Get(p.TimecardID)
+    if !ok {
+        return nil, fmt.Errorf("timecard %s not found", p.TimecardID)
+    }
+    auditCardTouched(ctx, rec.ID, rec.Employee, rec.PayPeriodNum, rec.Year)
+    if rec.Approval == nil || rec.Approval.Status != approvalPending {
+        return map[string]string{"skipped": "not awaiting approval"}, nil
+    }
+    link, err := approvalLink(rec.ID, rec.revision())
+    if err != nil {
+        return nil, err
+    }
+    req, err := rec.request()
+    if err != nil {
+        return nil, err
+    }
+    tenant := lookupTenant(rec.Tenant)
+    ctx = withTenant(ctx, tenant)
+    applyTimecardDefaults(&req, tenant)
+    _, data, err := renderRecord(ctx, rec, "xlsx")
+    if err != nil {
+        return nil, err
+    }
+
+    manager := rec.Approval.Manager
+    subject := fmt.Sprintf("Timecard for approval: %s, PP%02d %d", req.EmployeeName, req.PayPeriodNum, req.Year)
+    intro := fmt.Sprintf("%s submitted a timecard for pay period %d, %d.", req.EmployeeName, req.PayPeriodNum, req.Year)
+    if rev := rec.revision(); rev > 1 {
+        subject += fmt.Sprintf(" (rev. %d)", rev)
+        intro = fmt.Sprintf("%s resubmitted their timecard for pay period %d, %d (revision %d).", req.EmployeeName, req.PayPeriodNum, req.Year, rev)
+    }
+    body := fmt.Sprintf("%s\r\n\r\n%s\r\nReview and approve it here:\r\n%s\r\n", intro, approvalSummary(req, tenant), link)
+    if err := sendEmail(ctx, manager, nil, subject, body, data, emailAttachmentName(req.fileEmployee(), tenant.location()), nil); err != nil {
+        return nil, err
+    }
+
+    approvalsMu.Lock()
+    if cur, ok := timecards.Get(rec.ID); ok && cur.Approval != nil {
+        t := now().UTC()
+        a := *cur.Approval
+        a.NotifiedAt = &t
+        cur.Approval = &a
+        _ = timecards.Put(cur.ID, cur)
+    }
+    approvalsMu.Unlock()
+    return map[string]string{"to": manager}, nil
+}
+
+// approvalSummary is the hours table of the notification and the page
+func approvalSummary(req TimecardRequest, t *Tenant) string {
+    regular, overtime := hourTotals(req)
+    var b strings.Builder
+    fmt.Fprintf(&b, "  Regular    %8s\r\n", clockDuration(regular))
+    fmt.Fprintf(&b, "  Overtime   %8s\r\n", clockDuration(overtime))
+    fmt.Fprintf(&b, "  Total      %8s\r\n", clockDuration(regular+overtime))
+
+    byJob := map[string]float64{}
+    labels := map[string]string{}
+    for _, l := range hourLines(req, t.location()) {
+        label := l.JobNumber
+        if l.LabourCode != "" {
+            label += " (" + l.LabourCode + ")"
+        }
+        byJob[l.JobNumber] += l.Hours
+        labels[l.JobNumber] = label
+    }
+    if len(byJob) > 0 {
+        jobs := make([]string, 0, len(byJob))
+        for j := range byJob {
+            jobs = append(jobs, j)
+        }
+        sort.Strings(jobs)
+        b.WriteString("\r\n  By job:\r\n")
+        for _, j := range jobs {
+            fmt.Fprintf(&b, "  %-20s %8s\r\n", labels[j], clockDuration(byJob[j]))
+        }
+    }
+    return b.String()
+}
+
+// approvalToken authorizes deciding on a revision of record id
+func approvalToken(id string, revision int) string {
+    mac := hmac.New(sha256.New, []byte(os.Getenv("APPROVAL_SECRET")))
+    fmt.Fprintf(mac, "approval\x00%s\x00%d", id, revision)
+    return hex.EncodeToString(mac.Sum(nil))
+}
+
+func approvalLink(id string, revision int) (string, error) {
+    base := os.Getenv("PUBLIC_BASE_URL")
+    if base == "" || os.Getenv("APPROVAL_SECRET") == "" {
+        return "", fmt.Errorf("approval links need PUBLIC_BASE_URL and APPROVAL_SECRET")
+    }
+    return fmt.Sprintf("%s/approve/%s?token=%s", strings.TrimRight(base, "/"), url.PathEscape(id), approvalToken(id, revision)), nil
+}
+
+// submitTimecard serves POST /api/timecards/{id}/submit
+func submitTimecard(w http.ResponseWriter, r *http.Request, id string) {
+    var body struct {
+        ManagerEmail string `json:"manager_email"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if _, err := mail.ParseAddress(body.ManagerEmail); err != nil {
+        httpError(w, r, "invalid request: manager_email must be an email address", http.StatusBadRequest)
+        return
+    }
+
+    approvalsMu.Lock()
+    rec, ok := timecards.Get(id)
+    if !ok {
+        approvalsMu.Unlock()
+        httpError(w, r, "timecard not found", http.StatusNotFound)
+        return
+    }
+    if rec.Approval != nil && rec.Approval.Status != approvalPending {
+        approvalsMu.Unlock()
+        httpError(w, r, fmt.Sprintf("timecard is already %s", rec.Approval.Status), http.StatusConflict)
+        return
+    }
+    rec.Approval = newApproval(body.ManagerEmail, rec.revision())
+    err := timecards.Put(rec.ID, rec)
+    approvalsMu.Unlock()
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("error saving timecard: %v", err), http.StatusInternalServerError)
+        return
+    }
+    notifyManager(r.Context(), rec.ID)
+    loggerFrom(r.Context()).Printf("Timecard %s submitted to %s", rec.ID, body.ManagerEmail)
+    writeJSON(w, http.StatusAccepted, rec.Approval)
+}
+
+// resubmitTimecard serves POST /api/timecards/{id}/resubmit: the body is
+// the corrected card, with manager_email optional (the same manager again)
+func resubmitTimecard(w http.ResponseWriter, r *http.Request, id string) {
+    lg := loggerFrom(r.Context())
+    var req TimecardRequest
+    payload, err := readPayload(r, &req)
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    rec, ok := timecards.Get(id)
+    if !ok {
+        httpError(w, r, "timecard not found", http.StatusNotFound)
+        return
+    }
+    tenant := lookupTenant(rec.Tenant)
+    applyTimecardDefaults(&req, tenant)
+    if v := validateTimecard(req, tenant); len(v.Errors) > 0 {
+        writeJSON(w, http.StatusBadRequest, v)
+        return
+    }
+
+    approvalsMu.Lock()
+    defer approvalsMu.Unlock()
+    rec, _ = timecards.Get(id)
+    if rec.Approval == nil || rec.Approval.Status != approvalRejected {
+        httpError(w, r, "only a rejected timecard can be resubmitted", http.StatusConflict)
+        return
+    }
+    manager := req.ManagerEmail
+    if manager == "" {
+        manager = rec.Approval.Manager
+    }
+    revision := rec.revision() + 1
+    if latest, ok := latestRevision(rec.Tenant, rec.Employee, rec.employeeRef(), rec.Year, rec.PayPeriodNum); ok && latest.revision() >= revision {
+        revision = latest.revision() + 1
+    }
+
+    // The payload is kept as sent, plus the revision the sheet shows
+    var p map[string]interface{}
+    if err := json.Unmarshal(payload, &p); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    p["revision"] = revision
+    p["manager_email"] = manager
+    raw, _ := json.Marshal(p)
+    upgraded, err := migratePayload(raw, 1)
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+
+    rec.Payload, rec.SchemaVersion = upgraded, currentPayloadVersion
+    rec.Employee, rec.PayPeriodNum, rec.Year = req.EmployeeName, req.PayPeriodNum, req.Year
+    rec.EmployeeID, rec.PayrollNumber = req.EmployeeID, req.PayrollNumber
+    rec.Revision = revision
+    rec.Reviews = append(rec.Reviews, *rec.Approval)
+    rec.Approval = newApproval(manager, revision)
+    if err := timecards.Put(rec.ID, rec); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving timecard: %v", err), http.StatusInternalServerError)
+        return
+    }
+    notifyManager(r.Context(), rec.ID)
+    lg.Printf("Timecard %s resubmitted as revision %d to %s", rec.ID, revision, manager)
+    writeJSON(w, http.StatusAccepted, map[string]interface{}{
+        "timecard_id": rec.ID,
+        "revision":    revision,
+        "approval":    rec.Approval,
+    })
+}
+
+var approvalPage = template.Must(template.New("approval").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width">
+<title>Timecard approval</title>
+<style>body{font-family:sans-serif;max-width:36em;margin:2em auto;padding:0 1em}pre{background:#f4f4f4;padding:1em}button{font-size:1.1em;padding:.5em 1.5em}</style>
+</head><body>
+<h1>{{.Employee}}, pay period {{.PayPeriodNum}} / {{.Year}}{{if gt .Revision 1}} (rev. {{.Revision}}){{end}}</h1>
+<pre>{{.Summary}}</pre>
+{{if eq .Status "pending"}}<form method="post"><input type="hidden" name="token" value="{{.Token}}"><button type="submit" name="decision" value="approve">Approve</button></form>
+<form method="post"><input type="hidden" name="token" value="{{.Token}}">
+<p><label for="comment">Or send it back with a comment:</label><br><textarea id="comment" name="comment" rows="4" cols="40" required></textarea></p>
+<button type="submit" name="decision" value="reject">Reject</button></form>
+{{else}}<p>This timecard was {{.Status}}{{with .DecidedAt}} on {{.Format "Jan 2, 2006 15:04 MST"}}{{end}}.</p>
+{{with .Comment}}<p>Comment: {{.}}</p>{{end}}{{end}}
+</body></html>
+`))
+
+// approveHandler serves the page behind an approval link:
+//   GET  /approve/{id}?token=   the summary with Approve and Reject buttons
+//   POST /approve/{id}          token=...&decision=approve|reject&comment=...
+func approveHandler(w http.ResponseWriter, r *http.Request) {
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/approve"), "/")
+    if r.Method != http.MethodGet && r.Method != http.MethodPost {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    token := r.FormValue("token")
+    rec, ok := timecards.Get(id)
+    if ok {
+        auditCardTouched(r.Context(), rec.ID, rec.Employee, rec.PayPeriodNum, rec.Year)
+    }
+    if os.Getenv("APPROVAL_SECRET") == "" || !ok || rec.Approval == nil ||
+        !hmac.Equal([]byte(token), []byte(approvalToken(id, rec.revision()))) {
+        httpError(w, r, "this approval link is not valid", http.StatusForbidden)
+        return
+    }
+    if r.Method == http.MethodPost {
+        status, comment := approvalApproved, ""
+        if r.FormValue("decision") == "reject" {
+            status, comment = approvalRejected, strings.TrimSpace(r.FormValue("comment"))
+            if comment == "" {
+                httpError(w, r, "a rejection needs a comment", http.StatusBadRequest)
+                return
+            }
+        }
+        var err error
+        if rec, err = decideApproval(r.Context(), id, status, comment); err != nil {
+            httpError(w, r, fmt.Sprintf("error saving decision: %v", err), http.StatusInternalServerError)
+            return
+        }
+    }
+
+    req, err := rec.request()
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("stored payload unusable: %v", err), http.StatusInternalServerError)
+        return
+    }
+    tenant := lookupTenant(rec.Tenant)
+    applyTimecardDefaults(&req, tenant)
+    w.Header().Set("Content-Type", "text/html; charset=utf-8")
+    w.Header().Set("Cache-Control", "no-store")
+    w.Header().Set("Referrer-Policy", "no-referrer")
+    _ = approvalPage.Execute(w, map[string]interface{}{
+        "Employee":     rec.Employee,
+        "PayPeriodNum": rec.PayPeriodNum,
+        "Year":         rec.Year,
+        "Summary":      approvalSummary(req, tenant),
+        "Revision":     rec.revision(),
+        "Status":       rec.Approval.Status,
+        "DecidedAt":    rec.Approval.DecidedAt,
+        "Comment":      rec.Approval.Comment,
+        "Token":        token,
+    })
+}
+
+// decideApproval records the manager's decision on a pending record; a
+// record already decided is returned unchanged
+func decideApproval(ctx context.Context, id, status, comment string) (TimecardRecord, error) {
+    approvalsMu.Lock()
+    defer approvalsMu.Unlock()
+    rec, ok := timecards.Get(id)
+    if !ok || rec.Approval == nil {
+        return rec, errNotFound
+    }
+    if rec.Approval.Status != approvalPending {
+        return rec, nil
+    }
+    t := now().UTC()
+    a := *rec.Approval
+    a.Status, a.Comment, a.DecidedAt = status, comment, &t
+    rec.Approval = &a
+    if err := timecards.Put(rec.ID, rec); err != nil {
+        return rec, err
+    }
+    loggerFrom(ctx).Printf("Timecard %s %s by %s", rec.ID, status, a.Manager)
+    if req, err := rec.request(); err == nil {
+        data := timecardEventData(rec.ID, req, rec.Kind, 0)
+        if comment != "" {
+            data["comment"] = comment
+        }
+        emitEvent(ctx, "timecard."+status, rec.Tenant, data)
+    }
+    return rec, nil
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "net/url"
+    "sort"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+// withTestData points DATA_DIR and the timecards collection at a fresh
+// directory for the test
+func withTestData(t *testing.T) {
+    t.Helper()
+    t.Setenv("DATA_DIR", t.TempDir())
+    saved := timecards
+    timecards = openCollection[TimecardRecord]("timecards")
+    t.Cleanup(func() { timecards = saved })
+}
+
+// withApprovals stores records and queued jobs for the test, with approval
+// links turned on
+func withApprovals(t *testing.T) {
+    t.Helper()
+    withTestData(t)
+    t.Setenv("APPROVAL_SECRET", "approval-test")
+    t.Setenv("PUBLIC_BASE_URL", "https://timecards.example.com/")
+    saved := backgroundJobs
+    backgroundJobs = openCollection[BackgroundJob]("jobs")
+    t.Cleanup(func() { backgroundJobs = saved })
+}
+
+// putApprovalCard stores Bob Smith's PP3 card as id, with approval a
+func putApprovalCard(t *testing.T, id string, a *Approval) {
+    t.Helper()
+    rec := TimecardRecord{ID: id, Tenant: defaultTenantID, Kind: "xlsx", Employee: "Bob Smith", PayPeriodNum: 3, Year: 2025,
+        SchemaVersion: currentPayloadVersion, Approval: a,
+        Payload: json.RawMessage(`{"employee_name": "Bob Smith", "pay_period_num": 3, "year": 2025}`)}
+    if err := timecards.Put(id, rec); err != nil {
+        t.Fatal(err)
+    }
+}
+
+func TestApprovalLink(t *testing.T) {
+    t.Setenv("APPROVAL_SECRET", "")
+    if _, err := approvalLink("abc", 1); err == nil {
+        t.Error("a link without APPROVAL_SECRET")
+    }
+    t.Setenv("APPROVAL_SECRET", "approval-test")
+    t.Setenv("PUBLIC_BASE_URL", "https://timecards.example.com/")
+    link, err := approvalLink("a b", 2)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := "https://timecards.example.com/approve/a%20b?token=" + approvalToken("a b", 2); link != want {
+        t.Errorf("link = %s, want %s", link, want)
+    }
+    // a token is for one revision of one card
+    if tok := approvalToken("abc", 1); tok == approvalToken("abd", 1) || tok == approvalToken("abc", 2) || len(tok) != 64 {
+        t.Errorf("token = %s", tok)
+    }
+}
+
+func TestSubmitTimecard(t *testing.T) {
+    withApprovals(t)
+    putApprovalCard(t, "pending", nil)
+    putApprovalCard(t, "approved", &Approval{Status: approvalApproved, Manager: "boss@example.com"})
+
+    tests := []struct {
+        name, id, body string
+        want           int
+    }{
+        {"not JSON", "pending", `manager`, http.StatusBadRequest},
+        {"not an address", "pending", `{"manager_email": "boss"}`, http.StatusBadRequest},
+        {"no such card", "missing", `{"manager_email": "boss@example.com"}`, http.StatusNotFound},
+        {"already approved", "approved", `{"manager_email": "boss@example.com"}`, http.StatusConflict},
+        {"submitted", "pending", `{"manager_email": "boss@example.com"}`, http.StatusAccepted},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest(http.MethodPost, "/api/timecards/"+tt.id+"/submit", strings.NewReader(tt.body))
+        w := httptest.NewRecorder()
+        timecardsHandler(w, r)
+        if w.Code != tt.want {
+            t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+        }
+    }
+
+    rec, _ := timecards.Get("pending")
+    if a := rec.Approval; a == nil || a.Status != approvalPending || a.Manager != "boss@example.com" {
+        t.Errorf("approval = %+v", rec.Approval)
+    }
+    jobs := backgroundJobs.List()
+    if len(jobs) != 1 || jobs[0].Kind != "manager-notify" || !strings.Contains(string(jobs[0].Params), `"pending"`) {
+        t.Errorf("queued %+v, want one manager-notify for the card", jobs)
+    }
+}
+
+// approve sends the approval page form to /approve/{id}
+func approve(method, id string, form url.Values) *httptest.ResponseRecorder {
+    var r *http.Request
+    if method == http.MethodGet {
+        r = httptest.NewRequest(method, "/approve/"+id+"?"+form.Encode(), nil)
+    } else {
+        r = httptest.NewRequest(method, "/approve/"+id, strings.NewReader(form.Encode()))
+        r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    }
+    w := httptest.NewRecorder()
+    approveHandler(w, r)
+    return w
+}
+
+func TestApproveHandler(t *testing.T) {
+    withApprovals(t)
+    putApprovalCard(t, "abc", newApproval("boss@example.com", 1))
+    putApprovalCard(t, "plain", nil)
+    token := func(id string) url.Values { return url.Values{"token": {approvalToken(id, 1)}} }
+
+    tests := []struct {
+        name, method, id string
+        form             url.Values
+        want             int
+        body             string
+    }{
+        {"no token", http.MethodGet, "abc", nil, http.StatusForbidden, "not valid"},
+        {"another card's token", http.MethodPost, "abc", token("plain"), http.StatusForbidden, "not valid"},
+        {"a later revision's token", http.MethodPost, "abc", url.Values{"token": {approvalToken("abc", 2)}}, http.StatusForbidden, "not valid"},
+        {"not for approval", http.MethodGet, "plain", token("plain"), http.StatusForbidden, "not valid"},
+        {"wrong method", http.MethodPut, "abc", token("abc"), http.StatusMethodNotAllowed, ""},
+        {"rejecting without a comment", http.MethodPost, "abc", url.Values{"token": {approvalToken("abc", 1)}, "decision": {"reject"}, "comment": {" "}},
+            http.StatusBadRequest, "needs a comment"},
+        {"opening the link", http.MethodGet, "abc", token("abc"), http.StatusOK, "Approve</button>"},
+        {"approving", http.MethodPost, "abc", token("abc"), http.StatusOK, "This timecard was approved"},
+        {"rejecting after", http.MethodPost, "abc", url.Values{"token": {approvalToken("abc", 1)}, "decision": {"reject"}, "comment": {"too late"}},
+            http.StatusOK, "This timecard was approved"},
+    }
+    for _, tt := range tests {
+        w := approve(tt.method, tt.id, tt.form)
+        if w.Code != tt.want || !strings.Contains(w.Body.String(), tt.body) {
+            t.Errorf("%s: %d %s, want %d with %q", tt.name, w.Code, w.Body, tt.want, tt.body)
+        }
+        if tt.name == "opening the link" {
+            if rec, _ := timecards.Get("abc"); rec.Approval.Status != approvalPending {
+                t.Errorf("opening the link changed the card to %s", rec.Approval.Status)
+            }
+        }
+    }
+
+    rec, _ := timecards.Get("abc")
+    if a := rec.Approval; a.Status != approvalApproved || a.DecidedAt == nil || a.Comment != "" {
+        t.Errorf("approval = %+v", a)
+    }
+}
+
+// resubmit posts card as the corrected version of record id
+func resubmit(id, card string) *httptest.ResponseRecorder {
+    r := httptest.NewRequest(http.MethodPost, "/api/timecards/"+id+"/resubmit", strings.NewReader(card))
+    w := httptest.NewRecorder()
+    timecardsHandler(w, r)
+    return w
+}
+
+func TestRejectAndResubmit(t *testing.T) {
+    withApprovals(t)
+    putApprovalCard(t, "abc", newApproval("boss@example.com", 1))
+    putApprovalCard(t, "pending", newApproval("boss@example.com", 1))
+
+    w := approve(http.MethodPost, "abc", url.Values{"token": {approvalToken("abc", 1)}, "decision": {"reject"}, "comment": {"Tuesday is missing"}})
+    if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "Comment: Tuesday is missing") {
+        t.Fatalf("reject: %d %s", w.Code, w.Body)
+    }
+
+    const corrected = `{"employee_name": "Bob Smith", "pay_period_num": 3, "year": 2025}`
+    tests := []struct {
+        name, id, card string
+        want           int
+    }{
+        {"not JSON", "abc", `{`, http.StatusBadRequest},
+        {"no such card", "missing", corrected, http.StatusNotFound},
+        {"not rejected", "pending", corrected, http.StatusConflict},
+        {"invalid card", "abc", `{"employee_name": "Bob Smith", "pay_period_num": 3, "year": 2025, "manager_email": "boss"}`, http.StatusBadRequest},
+        {"resubmitted", "abc", corrected, http.StatusAccepted},
+        {"resubmitted twice", "abc", corrected, http.StatusConflict},
+    }
+    for _, tt := range tests {
+        if w := resubmit(tt.id, tt.card); w.Code != tt.want {
+            t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+        }
+    }
+
+    rec, _ := timecards.Get("abc")
+    if rec.revision() != 2 || rec.Approval.Status != approvalPending || rec.Approval.Revision != 2 || rec.Approval.Manager != "boss@example.com" {
+        t.Errorf("resubmitted record: revision %d, approval %+v", rec.revision(), rec.Approval)
+    }
+    if len(rec.Reviews) != 1 || rec.Reviews[0].Status != approvalRejected || rec.Reviews[0].Comment != "Tuesday is missing" {
+        t.Errorf("reviews = %+v", rec.Reviews)
+    }
+    if req, err := rec.request(); err != nil || req.Revision != 2 || req.ManagerEmail != "boss@example.com" {
+        t.Errorf("stored card %+v, %v", req, err)
+    }
+
+    // the old link is dead, the new revision's works
+    if w := approve(http.MethodGet, "abc", url.Values{"token": {approvalToken("abc", 1)}}); w.Code != http.StatusForbidden {
+        t.Errorf("revision 1's link: %d", w.Code)
+    }
+    if w := approve(http.MethodGet, "abc", url.Values{"token": {approvalToken("abc", 2)}}); w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "(rev. 2)") {
+        t.Errorf("revision 2's link: %d %s", w.Code, w.Body)
+    }
+}
+
+func TestRevisionsTellNamesakesApart(t *testing.T) {
+    withTestData(t)
+    put := func(id, name, employeeID, payroll string) {
+        rec := TimecardRecord{ID: id, Tenant: defaultTenantID, Employee: name, EmployeeID: employeeID, PayrollNumber: payroll,
+            PayPeriodNum: 3, Year: 2025}
+        if err := timecards.Put(id, rec); err != nil {
+            t.Fatal(err)
+        }
+    }
+    put("old", "Bob Smith", "", "")
+    put("a", "Bob Smith", "1042", "")
+    put("b", "Bob Smith", "", "P-77")
+
+    for _, tt := range []struct {
+        name string
+        req  TimecardRequest
+        want []string
+    }{
+        {"by name", TimecardRequest{EmployeeName: "bob smith"}, []string{"a", "b", "old"}},
+        {"by employee ID", TimecardRequest{EmployeeName: "Bob Smith", EmployeeID: "1042"}, []string{"a", "old"}},
+        {"by payroll number", TimecardRequest{EmployeeName: "Bob Smith", PayrollNumber: "P-77"}, []string{"b", "old"}},
+        {"someone else", TimecardRequest{EmployeeName: "Bob Smith", EmployeeID: "2001"}, []string{"old"}},
+    } {
+        var got []string
+        for _, rec := range revisionsOf(defaultTenantID, tt.req.EmployeeName, tt.req.employeeRef(), 2025, 3) {
+            got = append(got, rec.ID)
+        }
+        sort.Strings(got)
+        if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+            t.Errorf("%s: revisions %v, want %v", tt.name, got, tt.want)
+        }
+    }
+
+    req := TimecardRequest{EmployeeName: "Bob Smith", EmployeeID: "1042", PayPeriodNum: 3, Year: 2025}
+    if got := artifactPath("acme", req, "r1", "xlsx"); got != "acme/2025/PP03/1042/timecard_1042_r1.xlsx" {
+        t.Errorf("artifact path = %s", got)
+    }
+    req.EmployeeID = ""
+    if got := artifactPath("acme", req, "r1", "xlsx"); got != "acme/2025/PP03/Bob_Smith/timecard_Bob_Smith_r1.xlsx" {
+        t.Errorf("artifact path without an ID = %s", got)
+    }
+}
+
+func TestApprovalBlock(t *testing.T) {
+    withApprovals(t)
+    putApprovalCard(t, "c1", newApproval("boss@example.com", 1))
+    if _, err := decideApproval(context.Background(), "c1", approvalApproved, ""); err != nil {
+        t.Fatal(err)
+    }
+    rec, _ := timecards.Get("c1")
+    req, err := rec.request()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if req.ApprovedBy != "boss@example.com" || req.ApprovedAt != rec.Approval.DecidedAt.Format(time.RFC3339) {
+        t.Fatalf("approved by %q at %q", req.ApprovedBy, req.ApprovedAt)
+    }
+    req.Supervisor = "Ann Lee"
+    at := rec.Approval.DecidedAt.Format("2006-01-02 15:04")
+
+    render := func(cm CellMap) *excelize.File {
+        f := excelize.NewFile()
+        gc := newGenContext(context.Background(), req, &Tenant{ID: "approval-test"}, loggerFrom(context.Background()))
+        gc.tpl = &templateDef{CellMap: cm}
+        placeSignatures(gc, f, "Sheet1")
+        return f
+    }
+    cm := defaultCellMap()
+    if v, _ := render(cm).GetCellValue("Sheet1", "M24"); v != "Supervisor: Ann Lee, approved "+at {
+        t.Errorf("approval block = %q", v)
+    }
+    cm.Supervisor, cm.ApprovedAt = "M23", "W23"
+    f := render(cm)
+    name, _ := f.GetCellValue("Sheet1", "M23")
+    when, _ := f.GetCellValue("Sheet1", "W23")
+    text, _ := f.GetCellValue("Sheet1", "M24")
+    if name != "Ann Lee" || when != at || text != "" {
+        t.Errorf("mapped block: name %q, at %q, signature text %q", name, when, text)
+    }
+}
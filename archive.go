@@ -0,0 +1,121 @@
+package main
+
+import (
+    "archive/zip"
+    "context"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "strings"
+)
+
+/* ===============
+   Archive exports
+   =============== */
+
+// The "archive" job regenerates stored timecard records into one ZIP under
+// DATA_DIR/exports, e.g. for a year-end records request:
+//
+//   POST /admin/jobs {"kind": "archive",
+//                     "params": {"tenant": "acme", "employee": "Bob Smith", "year": 2025, "format": "pdf"}}
+//
+// Without a tenant the archive spans every tenant's cards.
+
+type archiveParams struct {
+    Tenant   string `json:"tenant,omitempty"`
+    Employee string `json:"employee,omitempty"`
+    Year     int    `json:"year,omitempty"`
+    Format   string `json:"format,omitempty"` // xlsx (default) or pdf
+}
+
+type archiveResult struct {
+    File     string   `json:"file"`
+    Count    int      `json:"count"`
+    Failures []string `json:"failures,omitempty"`
+}
+
+func init() {
+    registerJobKind("archive", runArchiveJob)
+}
+
+func runArchiveJob(ctx context.Context, job *BackgroundJob) (interface{}, error) {
+    var p archiveParams
+    if err := json.Unmarshal(job.Params, &p); err != nil {
+        return nil, fmt.Errorf("invalid params: %w", err)
+    }
+    if p.Format == "" {
+        p.Format = "xlsx"
+    }
+    if p.Format != "xlsx" && p.Format != "pdf" {
+        return nil, fmt.Errorf("format must be xlsx or pdf")
+    }
+
+    var recs []TimecardRecord
+    for _, rec := range timecards.List() {
+        if p.Tenant != "" && rec.Tenant != p.Tenant {
+            continue
+        }
+        if p.Employee != "" && !strings.EqualFold(rec.Employee, p.Employee) {
+            continue
+        }
+        if p.Year != 0 && rec.Year != p.Year {
+            continue
+        }
+        recs = append(recs, rec)
+    }
+
+    dir := filepath.Join(dataDir(), "exports")
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        return nil, fmt.Errorf("create exports dir: %w", err)
+    }
+    path := filepath.Join(dir, job.ID+".zip")
+    out, err := os.Create(path)
+    if err != nil {
+        return nil, fmt.Errorf("create archive: %w", err)
+    }
+    defer out.Close()
+    zw := zip.NewWriter(out)
+
+    res := archiveResult{File: path}
+    for i, rec := range recs {
+        if err := ctx.Err(); err != nil {
+            _ = os.Remove(path)
+            return nil, err
+        }
+        name, data, err := renderRecord(ctx, rec, p.Format)
+        if err != nil {
+            res.Failures = append(res.Failures, fmt.Sprintf("%s: %v", rec.ID, err))
+        } else if fw, err := zw.Create(name); err != nil {
+            return nil, fmt.Errorf("write archive: %w", err)
+        } else if _, err := fw.Write(data); err != nil {
+            return nil, fmt.Errorf("write archive: %w", err)
+        } else {
+            res.Count++
+        }
+        reportJobProgress(job, i+1, len(recs))
+    }
+    if err := zw.Close(); err != nil {
+        return nil, fmt.Errorf("finish archive: %w", err)
+    }
+    return res, nil
+}
+
+// renderRecord regenerates one stored card, returning its name in the archive
+func renderRecord(ctx context.Context, rec TimecardRecord, format string) (string, []byte, error) {
+    req, err := rec.request()
+    if err != nil {
+        return "", nil, err
+    }
+    tenant := lookupTenant(rec.Tenant)
+    applyTimecardDefaults(&req, tenant)
+
+    base := fmt.Sprintf("%d/%s_PP%02d_%s",
+        req.Year, strings.ReplaceAll(req.fileEmployee(), " ", "_"), req.PayPeriodNum, rec.ID)
+    data, err := generateExcelFile(newGenContext(ctx, req, tenant, nil))
+    if err != nil || format == "xlsx" {
+        return base + ".xlsx", data, err
+    }
+    data, err = generatePDFFromExcel(ctx, data, base+".xlsx", req.pdfOptions(tenant), nil)
+    return base + ".pdf", data, err
+}
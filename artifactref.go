@@ -0,0 +1,160 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "path"
+    "strings"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==================
+   Artifact reference
+   ================== */
+
+// Every generated file carries the request and trace IDs that produced it:
+// in the workbook's document properties, and in the right-hand section of
+// each sheet's print footer, which LibreOffice renders into the PDF. A
+// user forwarding a file lets us jump straight to its logs and trace.
+
+// artifactRef is the short reference printed in the footer
+func artifactRef(requestID, traceID string) string {
+    ref := "ref " + requestID
+    if traceID != "" {
+        ref += " trace " + traceID
+    }
+    return ref
+}
+
+// stampArtifactRef writes the reference into f. footers holds each sheet's
+// header/footer as it was in the template, since excelize can only replace
+// a footer wholesale.
+func stampArtifactRef(gc *genContext, f *excelize.File, footers map[string]excelize.HeaderFooterOptions) {
+    lg := gc.lg
+    requestID, traceID := lg.requestID(), traceIDFrom(gc.ctx)
+    if requestID == "" {
+        return
+    }
+
+    props, err := f.GetDocProps()
+    if err != nil {
+        props = &excelize.DocProperties{}
+    }
+    props.Identifier = requestID
+    props.Description = "request_id=" + requestID
+    if traceID != "" {
+        props.Description += " trace_id=" + traceID
+    }
+    if err := f.SetDocProps(props); err != nil {
+        lg.Printf("Warning: could not set document properties: %v", err)
+    }
+
+    for _, sheet := range f.GetSheetList() {
+        hf := footers[sheet]
+        footer := hf.OddFooter
+        if i := strings.Index(footer, "&R"); i >= 0 {
+            footer = footer[:i] // the right section is ours
+        }
+        for _, ref := range []string{artifactRef(requestID, traceID), artifactRef(requestID, "")} {
+            hf.OddFooter = footer + "&R&6" + ref
+            if err = f.SetHeaderFooter(sheet, &hf); err == nil {
+                break
+            }
+        }
+        if err != nil {
+            lg.Printf("Warning: no room for reference in %s footer: %v", sheet, err)
+        }
+    }
+}
+
+// readHeaderFooters extracts each sheet's headerFooter from raw xlsx bytes
+func readHeaderFooters(data []byte) (map[string]excelize.HeaderFooterOptions, error) {
+    zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil {
+        return nil, err
+    }
+    parts := make(map[string]*zip.File, len(zr.File))
+    for _, zf := range zr.File {
+        parts[zf.Name] = zf
+    }
+    decode := func(name string, v interface{}) error {
+        zf, ok := parts[name]
+        if !ok {
+            return fmt.Errorf("%s missing", name)
+        }
+        rc, err := zf.Open()
+        if err != nil {
+            return err
+        }
+        defer rc.Close()
+        return xml.NewDecoder(io.LimitReader(rc, 64<<20)).Decode(v)
+    }
+
+    var wb struct {
+        Sheets []struct {
+            Name string `xml:"name,attr"`
+            RID  string `xml:"http://schemas.openxmlformats.org/officeDocument/2006/relationships id,attr"`
+        } `xml:"sheets>sheet"`
+    }
+    if err := decode("xl/workbook.xml", &wb); err != nil {
+        return nil, err
+    }
+    var rels struct {
+        Rels []struct {
+            ID     string `xml:"Id,attr"`
+            Target string `xml:"Target,attr"`
+        } `xml:"Relationship"`
+    }
+    if err := decode("xl/_rels/workbook.xml.rels", &rels); err != nil {
+        return nil, err
+    }
+    targets := make(map[string]string, len(rels.Rels))
+    for _, r := range rels.Rels {
+        t := strings.TrimPrefix(r.Target, "/")
+        if !strings.HasPrefix(t, "xl/") {
+            t = path.Join("xl", t)
+        }
+        targets[r.ID] = t
+    }
+
+    out := make(map[string]excelize.HeaderFooterOptions, len(wb.Sheets))
+    for _, s := range wb.Sheets {
+        var ws struct {
+            HF *struct {
+                AlignWithMargins *bool  `xml:"alignWithMargins,attr"`
+                DifferentFirst   bool   `xml:"differentFirst,attr"`
+                DifferentOddEven bool   `xml:"differentOddEven,attr"`
+                ScaleWithDoc     *bool  `xml:"scaleWithDoc,attr"`
+                OddHeader        string `xml:"oddHeader"`
+                OddFooter        string `xml:"oddFooter"`
+                EvenHeader       string `xml:"evenHeader"`
+                EvenFooter       string `xml:"evenFooter"`
+                FirstHeader      string `xml:"firstHeader"`
+                FirstFooter      string `xml:"firstFooter"`
+            } `xml:"headerFooter"`
+        }
+        if err := decode(targets[s.RID], &ws); err != nil {
+            return nil, fmt.Errorf("sheet %s: %w", s.Name, err)
+        }
+        // Both flags default to true in SpreadsheetML when absent
+        hf := excelize.HeaderFooterOptions{AlignWithMargins: true, ScaleWithDoc: true}
+        if h := ws.HF; h != nil {
+            if h.AlignWithMargins != nil {
+                hf.AlignWithMargins = *h.AlignWithMargins
+            }
+            if h.ScaleWithDoc != nil {
+                hf.ScaleWithDoc = *h.ScaleWithDoc
+            }
+            hf.DifferentFirst, hf.DifferentOddEven = h.DifferentFirst, h.DifferentOddEven
+            hf.OddHeader, hf.OddFooter = h.OddHeader, h.OddFooter
+            hf.EvenHeader, hf.EvenFooter = h.EvenHeader, h.EvenFooter
+            hf.FirstHeader, hf.FirstFooter = h.FirstHeader, h.FirstFooter
+        }
+        out[s.Name] = hf
+    }
+    return out, nil
+}
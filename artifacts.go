@@ -0,0 +1,244 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "net/http"
+    "os"
+    "path"
+    "path/filepath"
+    "regexp"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* =========
+   Artifacts
+   ========= */
+
+// Generated files are kept under ARTIFACTS_DIR (default DATA_DIR/artifacts)
+// laid out the way payroll browses them:
+//
+//   <tenant>/<year>/PP<nn>/<employee>/timecard_<employee>_<record>.<ext>
+//
+// where <employee> is the card's employee ID or payroll number, so two
+// employees with the same name keep apart, and the name without either.
+// Set STORE_ARTIFACTS=0 to keep only the payload records. The same file
+// also goes to Drive, SharePoint, Dropbox and the archive bucket when those
+// are configured (drive.go, sharepoint.go, dropbox.go, objectstore.go);
+// each store gets STORAGE_TIMEOUT (default 30s).
+
+func artifactsDir() string {
+    return envOr("ARTIFACTS_DIR", filepath.Join(dataDir(), "artifacts"))
+}
+
+var unsafePathChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// pathSegment makes s safe to use as one path element
+func pathSegment(s string) string {
+    s = unsafePathChars.ReplaceAllString(s, "_")
+    if s == "" || s == "." || s == ".." {
+        return "_"
+    }
+    return s
+}
+
+// artifactPath is where a record's file of the given extension lives,
+// relative to artifactsDir()
+func artifactPath(tenant string, req TimecardRequest, recordID, ext string) string {
+    employee := pathSegment(req.fileEmployee())
+    return filepath.Join(
+        pathSegment(tenant),
+        fmt.Sprint(req.Year),
+        fmt.Sprintf("PP%02d", req.PayPeriodNum),
+        employee,
+        fmt.Sprintf("timecard_%s_%s.%s", employee, recordID, ext),
+    )
+}
+
+// expandLayout fills in a path template for one of rec's files: {tenant},
+// {year}, {pp} (two digits), {employee}, {id}, {ext} and {file}. Values
+// are made path-safe, so only the template's own slashes make folders.
+func expandLayout(tpl string, rec TimecardRecord, fileName string) string {
+    return strings.NewReplacer(
+        "{tenant}", pathSegment(rec.Tenant),
+        "{year}", fmt.Sprint(rec.Year),
+        "{pp}", fmt.Sprintf("%02d", rec.PayPeriodNum),
+        "{employee}", pathSegment(rec.Employee),
+        "{id}", rec.ID,
+        "{ext}", strings.TrimPrefix(path.Ext(fileName), "."),
+        "{file}", pathSegment(fileName),
+    ).Replace(tpl)
+}
+
+/* ----- stores ----- */
+
+// Storage is somewhere generated files are kept: the local tree, Drive,
+// an archive bucket. Put must never replace an existing object.
+type Storage interface {
+    Name() string
+    // Put stores obj and says where it went. A store that doesn't apply to
+    // obj's tenant returns a zero StoredCopy and no error.
+    Put(ctx context.Context, obj StoredObject) (StoredCopy, error)
+}
+
+// StoredObject is one generated file on its way to the stores
+type StoredObject struct {
+    Tenant      *Tenant
+    Key         string   // artifactPath, slash-separated
+    Folders     []string // year, PP<nn>, employee name: the same place for stores people browse
+    Name        string
+    Record      TimecardRecord // just the identifying fields
+    ContentType string
+    Data        []byte
+    Meta        map[string]string
+}
+
+// StoredCopy records where a store put an object
+type StoredCopy struct {
+    Store    string    `json:"store"`
+    Location string    `json:"location"`       // path, s3://… or gs://… URL, file id
+    Link     string    `json:"link,omitempty"` // for people, when the store has one
+    At       time.Time `json:"at"`
+}
+
+var (
+    storesOnce sync.Once
+    theStores  []Storage
+)
+
+// stores lists every configured store, local tree first
+func stores() []Storage {
+    storesOnce.Do(func() {
+        if os.Getenv("STORE_ARTIFACTS") != "0" {
+            theStores = append(theStores, localStore{dir: artifactsDir()})
+        }
+        if d := drive(); d != nil {
+            theStores = append(theStores, d)
+        }
+        if sp := sharePoint(); sp != nil {
+            theStores = append(theStores, sp)
+        }
+        if db := dropbox(); db != nil {
+            theStores = append(theStores, db)
+        }
+        if a := archiveStore(); a != nil {
+            theStores = append(theStores, a)
+        }
+    })
+    return theStores
+}
+
+// artifactMeta is the metadata kept with every copy of a record's file
+func artifactMeta(ctx context.Context, tenant, recordID string, req TimecardRequest, data []byte) map[string]string {
+    sum := sha256.Sum256(data)
+    return map[string]string{
+        "tenant":         tenant,
+        "timecard-id":    recordID,
+        "employee":       req.EmployeeName,
+        "year":           fmt.Sprint(req.Year),
+        "pay-period":     fmt.Sprint(req.PayPeriodNum),
+        "request-id":     requestIDFrom(ctx),
+        "schema-version": fmt.Sprint(currentPayloadVersion),
+        "sha256":         hex.EncodeToString(sum[:]),
+        "created-at":     now().UTC().Format(time.RFC3339),
+    }
+}
+
+// storeArtifact puts a generated file in every store and notes the copies
+// on the record, returning the first link for people (Drive, then
+// SharePoint). Like the record itself, failing to store never fails the
+// request.
+func storeArtifact(r *http.Request, recordID string, req TimecardRequest, ext string, data []byte) (link string) {
+    return keepArtifact(r.Context(), tenantFor(r), recordID, req, ext, data)
+}
+
+// keepArtifact is storeArtifact outside a request, for jobs
+func keepArtifact(ctx context.Context, tenant *Tenant, recordID string, req TimecardRequest, ext string, data []byte) (link string) {
+    if recordID == "" || len(stores()) == 0 {
+        return ""
+    }
+    lg := loggerFrom(ctx)
+    key := filepath.ToSlash(artifactPath(tenant.ID, req, recordID, ext))
+    obj := StoredObject{
+        Tenant:      tenant,
+        Key:         key,
+        Folders:     []string{fmt.Sprint(req.Year), fmt.Sprintf("PP%02d", req.PayPeriodNum), req.EmployeeName},
+        Name:        path.Base(key),
+        ContentType: attachmentContentType(key),
+        Data:        data,
+        Meta:        artifactMeta(ctx, tenant.ID, recordID, req, data),
+        Record: TimecardRecord{
+            ID:           recordID,
+            Tenant:       tenant.ID,
+            Employee:     req.EmployeeName,
+            PayPeriodNum: req.PayPeriodNum,
+            Year:         req.Year,
+        },
+    }
+
+    // A client hanging up must not cut an upload short
+    ctx = context.WithoutCancel(ctx)
+    var local []string
+    var copies []StoredCopy
+    for _, s := range stores() {
+        putCtx, cancel := context.WithTimeout(ctx, envDuration("STORAGE_TIMEOUT", 30*time.Second))
+        c, err := s.Put(putCtx, obj)
+        cancel()
+        if err != nil {
+            statStorageFailures.Add(1)
+            lg.Printf("Warning: could not store %s in %s: %v", obj.Name, s.Name(), err)
+            continue
+        }
+        if c.Store == "" {
+            continue
+        }
+        if c.Store == "local" {
+            local = append(local, c.Location)
+        } else {
+            lg.Printf("Stored %s in %s", obj.Name, c.Location)
+            copies = append(copies, c)
+        }
+        if link == "" {
+            link = c.Link
+        }
+    }
+
+    if rec, ok := timecards.Get(recordID); ok && len(local)+len(copies) > 0 {
+        rec.Artifacts = append(rec.Artifacts, local...)
+        rec.Copies = append(rec.Copies, copies...)
+        if err := timecards.Put(rec.ID, rec); err != nil {
+            lg.Printf("Warning: could not update timecard record: %v", err)
+        }
+    }
+    return link
+}
+
+// localStore is the tree under artifactsDir() that WebDAV serves
+type localStore struct {
+    dir string
+}
+
+func (localStore) Name() string { return "local" }
+
+func (l localStore) Put(ctx context.Context, obj StoredObject) (StoredCopy, error) {
+    p := filepath.Join(l.dir, filepath.FromSlash(obj.Key))
+    if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+        return StoredCopy{}, err
+    }
+    f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    if _, err := f.Write(obj.Data); err != nil {
+        f.Close()
+        return StoredCopy{}, err
+    }
+    if err := f.Close(); err != nil {
+        return StoredCopy{}, err
+    }
+    return StoredCopy{Store: "local", Location: obj.Key, At: now().UTC()}, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "mime"
+    "path/filepath"
+    "strings"
+)
+
+/* =================
+   Email attachments
+   ================= */
+
+// Site photos and receipts can go out in the same email as the card:
+//
+//   POST /api/email-timecard
+//   {..., "attachments": [{"filename": "pour.jpg", "content_type": "image/jpeg", "data": "<base64>"},
+//                         {"filename": "fuel.pdf", "data": "<base64>"}]}
+//
+// Each is attached after the card under its own name. content_type, when
+// left out, is guessed from the file name. An email takes at most
+// maxEmailAttachments files of maxEmailAttachmentBytes between them
+// (base64 adds a third on the wire, and most mail servers stop at 25 MB).
+
+const (
+    maxEmailAttachments     = 10
+    maxEmailAttachmentBytes = 15 << 20
+)
+
+// EmailAttachment is a file sent along with the card
+type EmailAttachment struct {
+    FileName    string `json:"filename"`
+    ContentType string `json:"content_type,omitempty"`
+    Data        []byte `json:"data"` // base64 in JSON
+}
+
+// checkAttachments rejects attachments the email can't carry
+func checkAttachments(atts []EmailAttachment) error {
+    if len(atts) > maxEmailAttachments {
+        return fmt.Errorf("attachments: at most %d files", maxEmailAttachments)
+    }
+    var total int
+    for i, a := range atts {
+        switch {
+        case strings.TrimSpace(a.FileName) == "":
+            return fmt.Errorf("attachments[%d]: filename is required", i)
+        case strings.ContainsAny(a.FileName, "\"\\/\r\n"):
+            return fmt.Errorf("attachments[%d]: filename %q must not contain quotes, slashes or line breaks", i, a.FileName)
+        case len(a.Data) == 0:
+            return fmt.Errorf("attachments[%d]: %s is empty", i, a.FileName)
+        }
+        if a.ContentType != "" {
+            if _, _, err := mime.ParseMediaType(a.ContentType); err != nil {
+                return fmt.Errorf("attachments[%d]: content_type %q: %v", i, a.ContentType, err)
+            }
+        }
+        total += len(a.Data)
+    }
+    if total > maxEmailAttachmentBytes {
+        return fmt.Errorf("attachments: %d bytes in all, over the %d byte limit", total, maxEmailAttachmentBytes)
+    }
+    return nil
+}
+
+// withoutAttachments drops an emailed card's attachments from the payload
+// kept with its record: they went out with the email, and the record store
+// rewrites every record on each save
+func withoutAttachments(payload json.RawMessage) json.RawMessage {
+    return withoutPayloadField(payload, "attachments")
+}
+
+// contentType is the attachment's type, as sent or by its extension
+func (a EmailAttachment) contentType() string {
+    if a.ContentType != "" {
+        return a.ContentType
+    }
+    if t := attachmentContentType(a.FileName); t != "application/octet-stream" {
+        return t
+    }
+    if t := mime.TypeByExtension(strings.ToLower(filepath.Ext(a.FileName))); t != "" {
+        return t
+    }
+    return "application/octet-stream"
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/base64"
+    "fmt"
+    "io"
+    "mime"
+    "mime/multipart"
+    "net"
+    "net/http"
+    "net/http/httptest"
+    "net/mail"
+    "strings"
+    "testing"
+)
+
+func TestCheckAttachments(t *testing.T) {
+    photo := EmailAttachment{FileName: "pour.jpg", Data: []byte("jpeg")}
+    tests := []struct {
+        name string
+        atts []EmailAttachment
+        want string
+    }{
+        {"none", nil, ""},
+        {"photo", []EmailAttachment{photo}, ""},
+        {"no name", []EmailAttachment{{Data: []byte("x")}}, "filename is required"},
+        {"path", []EmailAttachment{{FileName: "../pour.jpg", Data: []byte("x")}}, "must not contain"},
+        {"empty", []EmailAttachment{{FileName: "pour.jpg"}}, "pour.jpg is empty"},
+        {"bad type", []EmailAttachment{{FileName: "pour.jpg", ContentType: "image/", Data: []byte("x")}}, "content_type"},
+        {"too many", make([]EmailAttachment, maxEmailAttachments+1), "at most"},
+        {"too large", []EmailAttachment{{FileName: "big.bin", Data: make([]byte, maxEmailAttachmentBytes+1)}}, "byte limit"},
+    }
+    for _, tt := range tests {
+        err := checkAttachments(tt.atts)
+        if tt.want == "" && err != nil || tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+            t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+        }
+    }
+}
+
+func TestWriteEmailMessageAttachments(t *testing.T) {
+    var buf bytes.Buffer
+    extra := []EmailAttachment{
+        {FileName: "pour.jpg", Data: []byte("jpeg bytes")},
+        {FileName: "fuel", ContentType: "application/pdf", Data: []byte("pdf bytes")},
+    }
+    err := writeEmailMessage(&buf, "a@example.com", []string{"b@example.com"}, nil, "Timecard", "hi",
+        strings.NewReader("xlsx bytes"), "timecard.xlsx", "", extra...)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    msg, err := mail.ReadMessage(&buf)
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    mr := multipart.NewReader(msg.Body, params["boundary"])
+    var got []string
+    for {
+        part, err := mr.NextPart()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            t.Fatal(err)
+        }
+        if part.FileName() == "" {
+            continue // the body
+        }
+        data, _ := io.ReadAll(base64.NewDecoder(base64.StdEncoding, part))
+        got = append(got, part.FileName()+" "+part.Header.Get("Content-Type")+" "+string(data))
+    }
+    want := []string{
+        "timecard.xlsx application/vnd.openxmlformats-officedocument.spreadsheetml.sheet xlsx bytes",
+        "pour.jpg image/jpeg jpeg bytes",
+        "fuel application/pdf pdf bytes",
+    }
+    if strings.Join(got, "\n") != strings.Join(want, "\n") {
+        t.Errorf("attachments:\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+    }
+}
+
+// startFakeSMTP is a mail server on localhost that accepts any login and
+// message, returning its address
+func startFakeSMTP(t *testing.T) (host, port string) {
+    t.Helper()
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { ln.Close() })
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            go func() {
+                defer conn.Close()
+                rd := bufio.NewReader(conn)
+                fmt.Fprint(conn, "220 localhost\r\n")
+                for {
+                    line, err := rd.ReadString('\n')
+                    if err != nil {
+                        return
+                    }
+                    switch cmd := strings.ToUpper(strings.Fields(line + " ")[0]); cmd {
+                    case "EHLO":
+                        fmt.Fprint(conn, "250-localhost\r\n250 AUTH PLAIN\r\n")
+                    case "AUTH":
+                        fmt.Fprint(conn, "235 ok\r\n")
+                    case "DATA":
+                        fmt.Fprint(conn, "354 go on\r\n")
+                        for line != ".\r\n" {
+                            if line, err = rd.ReadString('\n'); err != nil {
+                                return
+                            }
+                        }
+                        fmt.Fprint(conn, "250 queued\r\n")
+                    case "QUIT":
+                        fmt.Fprint(conn, "221 bye\r\n")
+                        return
+                    default:
+                        fmt.Fprint(conn, "250 ok\r\n")
+                    }
+                }
+            }()
+        }
+    }()
+    host, port, _ = net.SplitHostPort(ln.Addr().String())
+    return host, port
+}
+
+func TestEmailedCardRecordWithoutAttachments(t *testing.T) {
+    withTestData(t)
+    host, port := startFakeSMTP(t)
+    t.Setenv("SMTP_HOST", host)
+    t.Setenv("SMTP_PORT", port)
+    t.Setenv("SMTP_USER", "cards@example.com")
+    t.Setenv("SMTP_PASS", "p")
+
+    photo := base64.StdEncoding.EncodeToString([]byte("site photo jpeg bytes"))
+    body := `{"to": "payroll@example.com", "subject": "Timecard", "body": "attached",
+        "employee_name": "Bob Smith", "pay_period_num": 1, "year": 2025,
+        "weeks": [{"week_start_date": "2025-01-05T00:00:00Z", "entries": [{"date": "2025-01-06T00:00:00Z", "job_code": "29699", "hours": 8}]}],
+        "attachments": [{"filename": "pour.jpg", "data": "` + photo + `"}]}`
+    r := httptest.NewRequest(http.MethodPost, "/api/email-timecard", strings.NewReader(body))
+    w := httptest.NewRecorder()
+    emailTimecardHandler(w, r)
+    if w.Code != http.StatusOK {
+        t.Fatalf("email: %d %s", w.Code, w.Body)
+    }
+
+    recs := timecards.List()
+    if len(recs) != 1 {
+        t.Fatalf("%d records kept", len(recs))
+    }
+    if p := string(recs[0].Payload); strings.Contains(p, "attachments") || strings.Contains(p, photo) || !strings.Contains(p, "Bob Smith") {
+        t.Errorf("kept payload %s", p)
+    }
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* =========
+   Audit log
+   ========= */
+
+// Emailed payroll documents need a defensible trail, so every request that
+// changes something (anything but GET, HEAD and OPTIONS) and every
+// background job run appends one entry to DATA_DIR/audit.jsonl: who, when,
+// which endpoint, the cards involved (employee and pay period), the
+// recipients of any email sent and the outcome. The file is only ever
+// appended to. Entries are read back, newest first, with
+//
+//   GET /api/audit?from=&to=&tenant=&employee=&actor=&endpoint=&outcome=&limit=
+//
+// (admin key; from and to are YYYY-MM-DD, limit defaults to 100).
+
+// AuditEntry is one line of the audit log
+type AuditEntry struct {
+    Time       time.Time   `json:"time"`
+    Actor      string      `json:"actor"` // authenticated identity, client IP, or "job:<owner>"
+    ClientIP   string      `json:"client_ip,omitempty"`
+    RequestID  string      `json:"request_id,omitempty"`
+    Method     string      `json:"method"` // HTTP method, or JOB
+    Endpoint   string      `json:"endpoint"`
+    Tenant     string      `json:"tenant,omitempty"`
+    Cards      []auditCard `json:"cards,omitempty"`
+    Recipients []string    `json:"recipients,omitempty"`
+    Status     int         `json:"status,omitempty"`
+    Outcome    string      `json:"outcome"` // success or failure
+    Error      string      `json:"error,omitempty"`
+
+    mu sync.Mutex
+}
+
+// auditCard is a card an audited action touched
+type auditCard struct {
+    TimecardID   string `json:"timecard_id,omitempty"`
+    Employee     string `json:"employee"`
+    PayPeriodNum int    `json:"pay_period_num"`
+    Year         int    `json:"year"`
+}
+
+type auditEntryKey struct{}
+
+func auditFrom(ctx context.Context) *AuditEntry {
+    a, _ := ctx.Value(auditEntryKey{}).(*AuditEntry)
+    return a
+}
+
+// auditCardTouched notes a card on ctx's audit entry; a later note with the
+// record id fills in the one noted before the card was saved
+func auditCardTouched(ctx context.Context, id, employee string, period, year int) {
+    a := auditFrom(ctx)
+    if a == nil {
+        return
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for i, c := range a.Cards {
+        if c.Employee == employee && c.PayPeriodNum == period && c.Year == year && (c.TimecardID == "" || c.TimecardID == id) {
+            if id != "" {
+                a.Cards[i].TimecardID = id
+            }
+            return
+        }
+    }
+    a.Cards = append(a.Cards, auditCard{TimecardID: id, Employee: employee, PayPeriodNum: period, Year: year})
+}
+
+// auditRecipients notes the addresses an email went to
+func auditRecipients(ctx context.Context, addrs ...string) {
+    a := auditFrom(ctx)
+    if a == nil {
+        return
+    }
+    a.mu.Lock()
+    defer a.mu.Unlock()
+    for _, addr := range addrs {
+        if addr = strings.TrimSpace(addr); addr != "" {
+            a.Recipients = append(a.Recipients, addr)
+        }
+    }
+}
+
+// auditError notes why the audited action failed
+func auditError(ctx context.Context, msg string) {
+    if a := auditFrom(ctx); a != nil {
+        a.mu.Lock()
+        a.Error = msg
+        a.mu.Unlock()
+    }
+}
+
+func setAuditActor(ctx context.Context, actor string) {
+    if a := auditFrom(ctx); a != nil {
+        a.mu.Lock()
+        a.Actor = actor
+        a.mu.Unlock()
+    }
+}
+
+func setAuditTenant(ctx context.Context, tenant string) {
+    if a := auditFrom(ctx); a != nil {
+        a.mu.Lock()
+        a.Tenant = tenant
+        a.mu.Unlock()
+    }
+}
+
+// auditMiddleware records every request that changes something
+func auditMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        switch {
+        case r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions,
+            strings.HasPrefix(r.URL.Path, "/api/audit"):
+            next.ServeHTTP(w, r)
+            return
+        }
+        tenant := strings.TrimSpace(r.Header.Get("X-Tenant-ID"))
+        if tenant == "" {
+            tenant = defaultTenantID
+        }
+        a := &AuditEntry{
+            Time:     now().UTC(),
+            Actor:    clientIP(r),
+            ClientIP: clientIP(r),
+            Method:   r.Method,
+            Endpoint: r.URL.Path,
+            Tenant:   tenant,
+        }
+        rec := &statusRecorder{ResponseWriter: w}
+        next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), auditEntryKey{}, a)))
+
+        a.mu.Lock()
+        a.Status = rec.status
+        if a.Status == 0 {
+            a.Status = http.StatusOK
+        }
+        a.RequestID = rec.Header().Get("X-Request-ID")
+        a.Outcome = "success"
+        if a.Status >= 400 {
+            a.Outcome = "failure"
+        }
+        a.mu.Unlock()
+        appendAudit(a)
+    })
+}
+
+// auditJob wraps a background job run in an audit entry
+func auditJob(ctx context.Context, job BackgroundJob) (context.Context, func(error)) {
+    a := &AuditEntry{
+        Time:     now().UTC(),
+        Actor:    "job:" + job.Owner,
+        Method:   "JOB",
+        Endpoint: job.Kind,
+    }
+    var p struct {
+        Tenant string `json:"tenant"`
+    }
+    if json.Unmarshal(job.Params, &p) == nil {
+        a.Tenant = p.Tenant
+    }
+    return context.WithValue(ctx, auditEntryKey{}, a), func(err error) {
+        a.mu.Lock()
+        a.Outcome = "success"
+        if err != nil {
+            a.Outcome, a.Error = "failure", err.Error()
+        }
+        a.mu.Unlock()
+        appendAudit(a)
+    }
+}
+
+var auditMu sync.Mutex
+
+func auditPath() string {
+    return filepath.Join(dataDir(), "audit.jsonl")
+}
+
+func appendAudit(a *AuditEntry) {
+    a.mu.Lock()
+    line, err := json.Marshal(a)
+    a.mu.Unlock()
+    if err != nil {
+        return
+    }
+    auditMu.Lock()
+    defer auditMu.Unlock()
+    if err := os.MkdirAll(dataDir(), 0o755); err != nil {
+        log.Printf("Warning: audit log: %v", err)
+        return
+    }
+    f, err := os.OpenFile(auditPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+    if err != nil {
+        log.Printf("Warning: audit log: %v", err)
+        return
+    }
+    defer f.Close()
+    if _, err := f.Write(append(line, '\n')); err != nil {
+        log.Printf("Warning: audit log: %v", err)
+        return
+    }
+    if err := f.Sync(); err != nil {
+        log.Printf("Warning: audit log: %v", err)
+    }
+}
+
+// auditHandler serves GET /api/audit (see the top of this file)
+func auditHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    q := r.URL.Query()
+    var from, to time.Time
+    for _, b := range []struct {
+        name string
+        dst  *time.Time
+    }{{"from", &from}, {"to", &to}} {
+        if v := q.Get(b.name); v != "" {
+            d, err := time.Parse("2006-01-02", v)
+            if err != nil {
+                httpError(w, r, fmt.Sprintf("invalid request: %s must be YYYY-MM-DD", b.name), http.StatusBadRequest)
+                return
+            }
+            *b.dst = d
+        }
+    }
+    if !to.IsZero() {
+        to = to.AddDate(0, 0, 1)
+    }
+    limit := 100
+    if v := q.Get("limit"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            httpError(w, r, "invalid request: limit must be a positive number", http.StatusBadRequest)
+            return
+        }
+        limit = n
+    }
+    tenant, employee, actor, endpoint, outcome := q.Get("tenant"), q.Get("employee"), q.Get("actor"), q.Get("endpoint"), q.Get("outcome")
+
+    match := func(a *AuditEntry) bool {
+        switch {
+        case !from.IsZero() && a.Time.Before(from),
+            !to.IsZero() && !a.Time.Before(to),
+            tenant != "" && a.Tenant != tenant,
+            actor != "" && a.Actor != actor,
+            endpoint != "" && !strings.HasPrefix(a.Endpoint, endpoint),
+            outcome != "" && a.Outcome != outcome:
+            return false
+        }
+        if employee == "" {
+            return true
+        }
+        for _, c := range a.Cards {
+            if strings.EqualFold(c.Employee, employee) {
+                return true
+            }
+        }
+        return false
+    }
+
+    auditMu.Lock()
+    f, err := os.Open(auditPath())
+    if err != nil {
+        auditMu.Unlock()
+        if os.IsNotExist(err) {
+            writeJSON(w, http.StatusOK, []*AuditEntry{})
+            return
+        }
+        httpError(w, r, fmt.Sprintf("error reading audit log: %v", err), http.StatusInternalServerError)
+        return
+    }
+    var out []*AuditEntry
+    sc := bufio.NewScanner(f)
+    sc.Buffer(make([]byte, 64<<10), 1<<20)
+    for sc.Scan() {
+        a := &AuditEntry{}
+        if json.Unmarshal(sc.Bytes(), a) != nil || !match(a) {
+            continue
+        }
+        out = append(out, a)
+        if len(out) > limit {
+            out = out[1:] // keep the newest
+        }
+    }
+    f.Close()
+    auditMu.Unlock()
+
+    for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+        out[i], out[j] = out[j], out[i]
+    }
+    if out == nil {
+        out = []*AuditEntry{}
+    }
+    writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+    "context"
+    "crypto/subtle"
+    "net/http"
+    "os"
+    "strings"
+)
+
+/* ==============
+   Authentication
+   ============== */
+
+// Admin keys come from ADMIN_API_KEYS as comma-separated "name:key" pairs
+// (a bare key is named "admin"). Clients send them as
+// "Authorization: Bearer <key>" or "X-API-Key: <key>". With no admin keys
+// configured, admin routes are closed rather than open.
+
+type apiKey struct {
+    Name string
+    Key  string
+}
+
+func parseAPIKeys(s string) []apiKey {
+    var out []apiKey
+    for _, item := range splitList(s) {
+        name, key, ok := strings.Cut(item, ":")
+        if !ok {
+            name, key = "admin", item
+        }
+        if key = strings.TrimSpace(key); key != "" {
+            out = append(out, apiKey{Name: strings.TrimSpace(name), Key: key})
+        }
+    }
+    return out
+}
+
+// presentedKey extracts the caller's key from the request
+func presentedKey(r *http.Request) string {
+    if h := r.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+        return strings.TrimSpace(strings.TrimPrefix(h, "Bearer "))
+    }
+    return strings.TrimSpace(r.Header.Get("X-API-Key"))
+}
+
+// matchKey compares in constant time against every configured key
+func matchKey(keys []apiKey, presented string) (apiKey, bool) {
+    var found apiKey
+    ok := false
+    for _, k := range keys {
+        if subtle.ConstantTimeCompare([]byte(k.Key), []byte(presented)) == 1 {
+            found, ok = k, true
+        }
+    }
+    return found, ok
+}
+
+// adminAuth guards operator-only routes (debug, profiling)
+func adminAuth(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        keys := parseAPIKeys(os.Getenv("ADMIN_API_KEYS"))
+        presented := presentedKey(r)
+        if len(keys) == 0 || presented == "" {
+            w.Header().Set("WWW-Authenticate", `Bearer realm="timecard-admin"`)
+            httpError(w, r, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+        k, ok := matchKey(keys, presented)
+        if !ok {
+            httpError(w, r, "forbidden", http.StatusForbidden)
+            return
+        }
+        identity := "admin:" + k.Name
+        setAccessIdentity(r.Context(), identity)
+        next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+    }
+}
+
+type identityKey struct{}
+
+// identityFrom names the authenticated caller of ctx ("" if anonymous)
+func identityFrom(ctx context.Context) string {
+    s, _ := ctx.Value(identityKey{}).(string)
+    return s
+}
+
+/* ===========
+   Tenant keys
+   =========== */
+
+// A tenant with "api_keys" in tenants.json ("name:key", as in
+// ADMIN_API_KEYS) only answers callers presenting one of them, so one
+// company can't read another's cards by changing X-Tenant-ID. A tenant key
+// on its own selects its tenant. Operator keys (ADMIN_API_KEYS and
+// HOOK_API_KEYS) may act for any tenant named by the header. Tenants
+// without keys stay open, selected by the header as before.
+
+func (t *Tenant) apiKeys() []apiKey {
+    return parseAPIKeys(strings.Join(t.APIKeys, ","))
+}
+
+// tenantMiddleware resolves and authorizes the request's tenant once, so
+// tenantFor and everything downstream see the same one
+func tenantMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        t, status := authorizeTenant(r)
+        switch status {
+        case http.StatusUnauthorized:
+            w.Header().Set("WWW-Authenticate", `Bearer realm="timecard"`)
+            httpError(w, r, "unauthorized", status)
+            return
+        case http.StatusForbidden:
+            httpError(w, r, "forbidden", status)
+            return
+        }
+        setAccessTenant(r.Context(), t.ID)
+        next(w, r.WithContext(withTenant(r.Context(), t)))
+    }
+}
+
+// authorizeTenant returns the tenant r may act for, or the status refusing it
+func authorizeTenant(r *http.Request) (*Tenant, int) {
+    presented := presentedKey(r)
+    header := strings.TrimSpace(r.Header.Get("X-Tenant-ID"))
+    if presented != "" {
+        for _, t := range loadTenants() {
+            k, ok := matchKey(t.apiKeys(), presented)
+            if !ok {
+                continue
+            }
+            if header != "" && header != t.ID {
+                loggerFrom(r.Context()).Printf("Key %s of tenant %s used for tenant %q", k.Name, t.ID, header)
+                return nil, http.StatusForbidden
+            }
+            setAccessIdentity(r.Context(), "tenant:"+t.ID+":"+k.Name)
+            return t, 0
+        }
+    }
+
+    t := tenantFor(r)
+    if len(t.APIKeys) == 0 {
+        return t, 0
+    }
+    if presented == "" {
+        return nil, http.StatusUnauthorized
+    }
+    operators := parseAPIKeys(os.Getenv("ADMIN_API_KEYS") + "," + os.Getenv("HOOK_API_KEYS"))
+    if _, ok := matchKey(operators, presented); !ok {
+        return nil, http.StatusForbidden
+    }
+    return t, 0
+}
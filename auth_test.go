@@ -0,0 +1,93 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+)
+
+const isolationTestTenants = `{
+    "acme":   {"api_keys": ["app:acme-key"]},
+    "globex": {"api_keys": ["app:globex-key", "batch:globex-batch"]},
+    "open":   {}
+}`
+
+// withIsolatedCards stores one card for each of acme, globex and open
+func withIsolatedCards(t *testing.T) {
+    t.Helper()
+    withTestData(t)
+    withTenants(t, isolationTestTenants)
+    t.Setenv("ADMIN_API_KEYS", "ops:admin-key")
+    for id, tenant := range map[string]string{"a1": "acme", "g1": "globex", "o1": "open"} {
+        rec := TimecardRecord{ID: id, Tenant: tenant, Employee: "Bob Smith", PayPeriodNum: 3, Year: 2025,
+            SchemaVersion: currentPayloadVersion, Payload: json.RawMessage(`{"employee_name": "Bob Smith"}`)}
+        if err := timecards.Put(id, rec); err != nil {
+            t.Fatal(err)
+        }
+    }
+}
+
+// getAs sends GET path through the API middleware with key and X-Tenant-ID
+func getAs(path, key, tenant string) *httptest.ResponseRecorder {
+    r := httptest.NewRequest(http.MethodGet, path, nil)
+    if key != "" {
+        r.Header.Set("Authorization", "Bearer "+key)
+    }
+    if tenant != "" {
+        r.Header.Set("X-Tenant-ID", tenant)
+    }
+    w := httptest.NewRecorder()
+    corsMiddleware(timecardsHandler)(w, r)
+    return w
+}
+
+func TestTenantIsolation(t *testing.T) {
+    withIsolatedCards(t)
+    tests := []struct {
+        name, path, key, tenant string
+        want                    int
+    }{
+        {"own card by key", "/api/timecards/a1", "acme-key", "", http.StatusOK},
+        {"own card by key and header", "/api/timecards/g1", "globex-batch", "globex", http.StatusOK},
+        {"another tenant's card", "/api/timecards/g1", "acme-key", "", http.StatusNotFound},
+        {"key for another tenant", "/api/timecards/g1", "acme-key", "globex", http.StatusForbidden},
+        {"no key", "/api/timecards/a1", "", "acme", http.StatusUnauthorized},
+        {"unknown key", "/api/timecards/a1", "nope", "acme", http.StatusForbidden},
+        {"operator key", "/api/timecards/g1", "admin-key", "globex", http.StatusOK},
+        {"open tenant", "/api/timecards/o1", "", "open", http.StatusOK},
+        {"locked card from an open tenant", "/api/timecards/a1", "", "open", http.StatusNotFound},
+    }
+    for _, tt := range tests {
+        if w := getAs(tt.path, tt.key, tt.tenant); w.Code != tt.want {
+            t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+        }
+    }
+}
+
+func TestTenantIsolationList(t *testing.T) {
+    withIsolatedCards(t)
+    tests := []struct {
+        key, tenant string
+        want        []string
+    }{
+        {"acme-key", "", []string{"a1"}},
+        {"globex-key", "", []string{"g1"}},
+        {"admin-key", "acme", []string{"a1"}},
+        {"", "open", []string{"o1"}},
+    }
+    for _, tt := range tests {
+        w := getAs("/api/timecards", tt.key, tt.tenant)
+        var recs []TimecardRecord
+        if err := json.Unmarshal(w.Body.Bytes(), &recs); err != nil {
+            t.Fatalf("%s/%s: %d %s", tt.key, tt.tenant, w.Code, w.Body)
+        }
+        var got []string
+        for _, rec := range recs {
+            got = append(got, rec.ID)
+        }
+        if len(got) != len(tt.want) || got[0] != tt.want[0] {
+            t.Errorf("%s/%s lists %v, want %v", tt.key, tt.tenant, got, tt.want)
+        }
+    }
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ==============
+   Leave balances
+   ============== */
+
+// Vacation and sick hours are tracked per directory employee:
+//
+//   GET /api/employees/{id}/balances
+//   PUT /api/employees/{id}/balances   {"vacation": 80, "sick": 40}
+//
+// PUT sets the opening balance. Every card kept for the employee (matched
+// by employee_id, else by name) then counts against it: its vacation and
+// sick entries are used, and the tenant's
+//
+//   "leave_accrual": {"vacation": 3.08, "sick": 1.54}
+//
+// hours are earned, once per pay period. A revised card replaces what its
+// period counted before rather than counting twice. Validation warns when
+// a card would take a balance below zero.
+
+// LeaveHours are vacation and sick hours
+type LeaveHours struct {
+    Vacation float64 `json:"vacation"`
+    Sick     float64 `json:"sick"`
+}
+
+func (h LeaveHours) plus(o LeaveHours) LeaveHours {
+    return LeaveHours{Vacation: h.Vacation + o.Vacation, Sick: h.Sick + o.Sick}
+}
+
+func (h LeaveHours) minus(o LeaveHours) LeaveHours {
+    return LeaveHours{Vacation: h.Vacation - o.Vacation, Sick: h.Sick - o.Sick}
+}
+
+func (h LeaveHours) rounded() LeaveHours {
+    return LeaveHours{Vacation: roundHours(h.Vacation), Sick: roundHours(h.Sick)}
+}
+
+// leavePeriod is what one pay period's card counted
+type leavePeriod struct {
+    Year         int        `json:"year"`
+    PayPeriodNum int        `json:"pay_period_num"`
+    TimecardID   string     `json:"timecard_id"`
+    Accrued      LeaveHours `json:"accrued"`
+    Used         LeaveHours `json:"used"`
+}
+
+// LeaveBalance is one employee's opening balance and what each pay
+// period's card has counted since
+type LeaveBalance struct {
+    Tenant     string                 `json:"tenant"`
+    EmployeeID string                 `json:"employee_id"`
+    Opening    LeaveHours             `json:"opening"`
+    Periods    map[string]leavePeriod `json:"periods,omitempty"` // by leavePeriodKey
+    UpdatedAt  time.Time              `json:"updated_at"`
+}
+
+// balance is the opening balance plus what was accrued less what was used,
+// leaving out the period skip (when not "")
+func (b LeaveBalance) balance(skip string) LeaveHours {
+    h := b.Opening
+    for key, p := range b.Periods {
+        if key != skip {
+            h = h.plus(p.Accrued).minus(p.Used)
+        }
+    }
+    return h
+}
+
+// leaveBalances is keyed like employees (employeeKey)
+var (
+    leaveBalances   = openCollection[LeaveBalance]("leave_balances")
+    leaveBalancesMu sync.Mutex // serializes read-modify-write of a balance
+)
+
+func leavePeriodKey(year, period int) string {
+    return fmt.Sprintf("%d/PP%02d", year, period)
+}
+
+// leaveUsed adds up a card's vacation and sick entries
+func leaveUsed(req TimecardRequest) LeaveHours {
+    var h LeaveHours
+    for _, e := range allEntries(req) {
+        switch e.Type {
+        case entryVacation:
+            h.Vacation += e.Hours
+        case entrySick:
+            h.Sick += e.Hours
+        }
+    }
+    return h
+}
+
+// leaveAccrual is what the tenant earns its employees each pay period
+func (t *Tenant) leaveAccrual() LeaveHours {
+    if t == nil || t.LeaveAccrual == nil {
+        return LeaveHours{}
+    }
+    return *t.LeaveAccrual
+}
+
+// cardEmployeeID is the directory ID of a card's employee: its
+// employee_id, else the active employee of the same name
+func cardEmployeeID(req TimecardRequest, t *Tenant) string {
+    if req.EmployeeID != "" {
+        return req.EmployeeID
+    }
+    for _, e := range tenantEmployees(t.ID) {
+        if e.sameEmployee(req.EmployeeName) {
+            return e.ID
+        }
+    }
+    return ""
+}
+
+// recordLeave counts a kept card against its employee's balance
+func recordLeave(ctx context.Context, t *Tenant, recordID string, req TimecardRequest) {
+    id := cardEmployeeID(req, t)
+    if id == "" {
+        return
+    }
+    leaveBalancesMu.Lock()
+    defer leaveBalancesMu.Unlock()
+    key := employeeKey(t.ID, id)
+    b, ok := leaveBalances.Get(key)
+    if !ok {
+        b = LeaveBalance{Tenant: t.ID, EmployeeID: id}
+    }
+    used, accrued := leaveUsed(req), t.leaveAccrual()
+    if !ok && used == (LeaveHours{}) && accrued == (LeaveHours{}) {
+        return // nothing to track yet
+    }
+    if b.Periods == nil {
+        b.Periods = map[string]leavePeriod{}
+    }
+    b.Periods[leavePeriodKey(req.Year, req.PayPeriodNum)] = leavePeriod{
+        Year: req.Year, PayPeriodNum: req.PayPeriodNum, TimecardID: recordID, Accrued: accrued, Used: used,
+    }
+    b.UpdatedAt = now().UTC()
+    if err := leaveBalances.Put(key, b); err != nil {
+        loggerFrom(ctx).Printf("Warning: could not update leave balance of %s: %v", id, err)
+    }
+}
+
+// checkLeaveBalance warns when a card's leave would overdraw a balance
+func checkLeaveBalance(v *validationResult, req TimecardRequest, t *Tenant) {
+    used := leaveUsed(req)
+    if used == (LeaveHours{}) {
+        return
+    }
+    id := cardEmployeeID(req, t)
+    if id == "" {
+        return
+    }
+    b, ok := leaveBalances.Get(employeeKey(t.ID, id))
+    if !ok {
+        return
+    }
+    left := b.balance(leavePeriodKey(req.Year, req.PayPeriodNum)).plus(t.leaveAccrual())
+    for _, k := range []struct {
+        kind        string
+        used, avail float64
+    }{{entryVacation, used.Vacation, left.Vacation}, {entrySick, used.Sick, left.Sick}} {
+        if k.used > 0 && k.used > roundHours(k.avail) {
+            v.warnf("%g %s hours entered but only %g available", roundHours(k.used), k.kind, roundHours(k.avail))
+        }
+    }
+}
+
+type balanceView struct {
+    EmployeeID string        `json:"employee_id"`
+    Employee   string        `json:"employee"`
+    Opening    LeaveHours    `json:"opening"`
+    Accrued    LeaveHours    `json:"accrued"`
+    Used       LeaveHours    `json:"used"`
+    Balance    LeaveHours    `json:"balance"`
+    Periods    []leavePeriod `json:"periods"`
+    UpdatedAt  *time.Time    `json:"updated_at,omitempty"`
+}
+
+// balancesHandler serves /api/employees/{id}/balances (see the top of this file)
+func balancesHandler(w http.ResponseWriter, r *http.Request, tenant, id string) {
+    e, ok := lookupEmployee(tenant, id)
+    if !ok {
+        httpError(w, r, "employee not found", http.StatusNotFound)
+        return
+    }
+    key := employeeKey(tenant, id)
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPut:
+        var opening LeaveHours
+        if err := json.NewDecoder(r.Body).Decode(&opening); err != nil {
+            httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+            return
+        }
+        leaveBalancesMu.Lock()
+        b, ok := leaveBalances.Get(key)
+        if !ok {
+            b = LeaveBalance{Tenant: tenant, EmployeeID: id}
+        }
+        b.Opening, b.UpdatedAt = opening, now().UTC()
+        err := leaveBalances.Put(key, b)
+        leaveBalancesMu.Unlock()
+        if err != nil {
+            httpError(w, r, fmt.Sprintf("error saving balance: %v", err), http.StatusInternalServerError)
+            return
+        }
+        loggerFrom(r.Context()).Printf("Opening leave balance of %s set to %g vacation, %g sick", id, opening.Vacation, opening.Sick)
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    b, _ := leaveBalances.Get(key)
+    out := balanceView{EmployeeID: id, Employee: e.Name, Opening: b.Opening, Periods: []leavePeriod{}}
+    for _, p := range b.Periods {
+        out.Accrued = out.Accrued.plus(p.Accrued)
+        out.Used = out.Used.plus(p.Used)
+        out.Periods = append(out.Periods, p)
+    }
+    sort.Slice(out.Periods, func(a, c int) bool {
+        return leavePeriodKey(out.Periods[a].Year, out.Periods[a].PayPeriodNum) < leavePeriodKey(out.Periods[c].Year, out.Periods[c].PayPeriodNum)
+    })
+    out.Accrued, out.Used = out.Accrued.rounded(), out.Used.rounded()
+    out.Balance = b.balance("").rounded()
+    if !b.UpdatedAt.IsZero() {
+        out.UpdatedAt = &b.UpdatedAt
+    }
+    writeJSON(w, http.StatusOK, out)
+}
+
+// isBalancesPath reports whether rest (the path after /api/employees/) is
+// {id}/balances, and returns the id
+func isBalancesPath(rest string) (string, bool) {
+    id, ok := strings.CutSuffix(rest, "/balances")
+    return id, ok && id != "" && !strings.Contains(id, "/")
+}
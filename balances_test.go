@@ -0,0 +1,123 @@
+package main
+
+import (
+    "context"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// testWeek is the week of 2025-01-05, a Sunday, for entries dated by weekday
+var testWeek = time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)
+
+// worked is an entry of hours on job, on day ("Sun".."Sat") of testWeek,
+// or of the week after with a "+" ("+Mon")
+func worked(day, job string, hours float64) Entry {
+    return Entry{Date: testDate(day), JobCode: job, Hours: hours}
+}
+
+func testDate(day string) string {
+    week := testWeek
+    if day[0] == '+' {
+        week, day = week.AddDate(0, 0, 7), day[1:]
+    }
+    for d := 0; d < 7; d++ {
+        if week.AddDate(0, 0, d).Format("Mon") == day {
+            return week.AddDate(0, 0, d).Format(time.RFC3339)
+        }
+    }
+    panic("bad test day " + day)
+}
+
+// withLeaveBalances gives the test an empty balance store of its own
+func withLeaveBalances(t *testing.T) {
+    t.Helper()
+    t.Setenv("DATA_DIR", t.TempDir())
+    saved := leaveBalances
+    leaveBalances = openCollection[LeaveBalance]("leave_balances")
+    t.Cleanup(func() { leaveBalances = saved })
+}
+
+// leaveCard is a card for employee E1 in pay period n of 2025 with the
+// given vacation and sick hours
+func leaveCard(n int, vacation, sick float64) TimecardRequest {
+    req := TimecardRequest{EmployeeID: "E1", EmployeeName: "Bob Smith", Year: 2025, PayPeriodNum: n}
+    for _, l := range []struct {
+        kind  string
+        hours float64
+    }{{entryVacation, vacation}, {entrySick, sick}} {
+        if l.hours > 0 {
+            e := worked("Mon", "", l.hours)
+            e.Type = l.kind
+            req.Entries = append(req.Entries, e)
+        }
+    }
+    return req
+}
+
+func TestCheckLeaveBalance(t *testing.T) {
+    withLeaveBalances(t)
+    tenant := &Tenant{ID: "leave-test", LeaveAccrual: &LeaveHours{Vacation: 2}}
+    // 16 vacation and 8 sick at the start, 8 vacation taken in period 1
+    b := LeaveBalance{Tenant: tenant.ID, EmployeeID: "E1", Opening: LeaveHours{Vacation: 16, Sick: 8},
+        Periods: map[string]leavePeriod{leavePeriodKey(2025, 1): {Year: 2025, PayPeriodNum: 1,
+            Accrued: LeaveHours{Vacation: 2}, Used: LeaveHours{Vacation: 8}}}}
+    if err := leaveBalances.Put(employeeKey(tenant.ID, "E1"), b); err != nil {
+        t.Fatal(err)
+    }
+
+    other := leaveCard(2, 100, 0)
+    other.EmployeeID = "E2"
+    tests := []struct {
+        name string
+        req  TimecardRequest
+        want []string
+    }{
+        // 16 + 2 - 8 left, and this period's 2 earned
+        {"all that's left", leaveCard(2, 12, 0), nil},
+        {"overdrawn", leaveCard(2, 12.5, 0), []string{"12.5 vacation hours entered but only 12 available"}},
+        {"sick overdrawn", leaveCard(2, 0, 9), []string{"9 sick hours entered but only 8 available"}},
+        {"both", leaveCard(2, 13, 9), []string{
+            "13 vacation hours entered but only 12 available",
+            "9 sick hours entered but only 8 available",
+        }},
+        // a revised card replaces what its period counted
+        {"revising period 1", leaveCard(1, 18, 0), nil},
+        {"revising period 1, overdrawn", leaveCard(1, 19, 0), []string{"19 vacation hours entered but only 18 available"}},
+        {"no leave", leaveCard(2, 0, 0), nil},
+        {"no balance kept", other, nil},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            var v validationResult
+            checkLeaveBalance(&v, tt.req, tenant)
+            if !reflect.DeepEqual(v.Warnings, tt.want) {
+                t.Errorf("warnings = %q, want %q", v.Warnings, tt.want)
+            }
+        })
+    }
+}
+
+func TestRecordLeave(t *testing.T) {
+    withLeaveBalances(t)
+    tenant := &Tenant{ID: "leave-test", LeaveAccrual: &LeaveHours{Vacation: 2, Sick: 1}}
+    ctx := context.Background()
+    key := employeeKey(tenant.ID, "E1")
+
+    recordLeave(ctx, tenant, "card-1", leaveCard(1, 8, 0))
+    recordLeave(ctx, tenant, "card-2", leaveCard(2, 0, 4))
+    b, _ := leaveBalances.Get(key)
+    if got, want := b.balance("").rounded(), (LeaveHours{Vacation: -4, Sick: -2}); got != want {
+        t.Errorf("balance = %+v, want %+v", got, want)
+    }
+
+    // resubmitting period 1 replaces what it counted
+    recordLeave(ctx, tenant, "card-1", leaveCard(1, 4, 0))
+    b, _ = leaveBalances.Get(key)
+    if got, want := b.balance("").rounded(), (LeaveHours{Vacation: 0, Sick: -2}); got != want {
+        t.Errorf("balance after the revision = %+v, want %+v", got, want)
+    }
+    if len(b.Periods) != 2 {
+        t.Errorf("%d periods kept, want 2", len(b.Periods))
+    }
+}
@@ -0,0 +1,269 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "image"
+    _ "image/jpeg"
+    _ "image/png"
+    "io"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ========
+   Branding
+   ======== */
+
+// Each tenant can bring its own card, logo and email footer, looked up
+// every time a card is generated or mailed:
+//
+//   "branding": {"template": "acme-card", "logo": "/etc/timecard/acme.png",
+//                "logo_cell": "H1", "email_footer": "Acme Construction Ltd."}
+//
+// in tenants.json, or through the API (changes need the admin key):
+//
+//   GET    /api/branding             the branding in effect
+//   PUT    /api/branding             {"template": ..., "logo_cell": ..., "email_footer": ...}
+//   DELETE /api/branding             back to tenants.json
+//   PUT    /api/branding/template    a bare template.xlsx laid out like the default card
+//   GET    /api/branding/logo
+//   PUT    /api/branding/logo        a PNG or JPEG
+//
+// The template is used for cards that don't name one. The logo is drawn
+// on every week sheet, logoHeight pixels tall, at logo_cell (default A1).
+// The footer is appended to every email sent for the tenant.
+
+// Branding is a tenant's own look
+type Branding struct {
+    Template    string `json:"template,omitempty"`
+    Logo        string `json:"logo,omitempty"` // path to a PNG or JPEG
+    LogoCell    string `json:"logo_cell,omitempty"`
+    EmailFooter string `json:"email_footer,omitempty"`
+}
+
+const (
+    logoHeight          = 40
+    maxLogoBytes        = 1 << 20
+    brandingTemplateKey = "company" // name of the workbook PUT to /api/branding/template
+)
+
+// brandings holds branding set through the API, by tenant id
+var brandings = openCollection[Branding]("branding")
+
+// branding is the tenant's branding in effect
+func (t *Tenant) branding() Branding {
+    if t == nil {
+        return Branding{}
+    }
+    if b, ok := brandings.Get(t.ID); ok {
+        return b
+    }
+    if t.Branding != nil {
+        return *t.Branding
+    }
+    return Branding{}
+}
+
+// check rejects branding that could not be applied for tenant
+func (b Branding) check(tenant string) error {
+    if b.Template != "" {
+        if _, err := resolveTemplate(tenant, b.Template); err != nil {
+            return err
+        }
+    }
+    if b.LogoCell != "" {
+        if _, _, err := excelize.CellNameToCoordinates(b.LogoCell); err != nil {
+            return fmt.Errorf("logo_cell %q is not a cell", b.LogoCell)
+        }
+    }
+    return nil
+}
+
+// emailFooter appends the tenant's footer, if any, to an email body
+func emailFooter(t *Tenant, body string) string {
+    footer := strings.TrimSpace(t.branding().EmailFooter)
+    if footer == "" {
+        return body
+    }
+    footer = strings.ReplaceAll(strings.ReplaceAll(footer, "\r\n", "\n"), "\n", "\r\n")
+    return strings.TrimRight(body, "\r\n") + "\r\n\r\n-- \r\n" + footer + "\r\n"
+}
+
+// placeLogo draws the tenant's logo on sheet
+func placeLogo(gc *genContext, f *excelize.File, sheet string) {
+    b := gc.tenant.branding()
+    if b.Logo == "" {
+        return
+    }
+    raw, err := os.ReadFile(b.Logo)
+    if err != nil {
+        gc.lg.Printf("Warning: skipping logo: %v", err)
+        return
+    }
+    cfg, format, err := image.DecodeConfig(bytes.NewReader(raw))
+    if err != nil {
+        gc.lg.Printf("Warning: skipping logo %s: %v", b.Logo, err)
+        return
+    }
+    cell := b.LogoCell
+    if cell == "" {
+        cell = "A1"
+    }
+    scale := 1.0
+    if cfg.Height > 0 {
+        scale = float64(logoHeight) / float64(cfg.Height)
+    }
+    err = f.AddPictureFromBytes(sheet, cell, &excelize.Picture{
+        Extension: "." + format,
+        File:      raw,
+        Format: &excelize.GraphicOptions{
+            AltText:         gc.tenant.Name + " logo",
+            LockAspectRatio: true,
+            ScaleX:          scale,
+            ScaleY:          scale,
+            Positioning:     "oneCell",
+        },
+    })
+    if err != nil {
+        gc.lg.Printf("Warning: could not place logo in %s!%s: %v", sheet, cell, err)
+    }
+}
+
+// brandingHandler serves /api/branding (see the top of this file)
+func brandingHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/branding"), "/")
+    t := tenantFor(r)
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        source := "tenants.json"
+        if _, ok := brandings.Get(t.ID); ok {
+            source = "api"
+        }
+        writeJSON(w, http.StatusOK, map[string]interface{}{"branding": t.branding(), "source": source})
+    case rest == "" && r.Method == http.MethodPut:
+        adminAuth(func(w http.ResponseWriter, r *http.Request) {
+            var b Branding
+            if err := json.NewDecoder(r.Body).Decode(&b); err != nil {
+                httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+                return
+            }
+            b.Logo = t.branding().Logo // only uploaded through /logo
+            saveBranding(w, r, t, b)
+        })(w, r)
+    case rest == "" && r.Method == http.MethodDelete:
+        adminAuth(func(w http.ResponseWriter, r *http.Request) {
+            if err := brandings.Delete(t.ID); err != nil {
+                httpError(w, r, fmt.Sprintf("error deleting branding: %v", err), http.StatusInternalServerError)
+                return
+            }
+            renders().purge()
+            loggerFrom(r.Context()).Printf("Branding for %s reset by %s", t.ID, identityFrom(r.Context()))
+            w.WriteHeader(http.StatusNoContent)
+        })(w, r)
+    case rest == "template" && r.Method == http.MethodPut:
+        adminAuth(func(w http.ResponseWriter, r *http.Request) {
+            uploadBrandingTemplate(w, r, t)
+        })(w, r)
+    case rest == "logo" && r.Method == http.MethodGet:
+        b := t.branding()
+        raw, err := os.ReadFile(b.Logo)
+        if b.Logo == "" || err != nil {
+            httpError(w, r, "no logo", http.StatusNotFound)
+            return
+        }
+        w.Header().Set("Content-Type", http.DetectContentType(raw))
+        _, _ = w.Write(raw)
+    case rest == "logo" && r.Method == http.MethodPut:
+        adminAuth(func(w http.ResponseWriter, r *http.Request) {
+            uploadLogo(w, r, t)
+        })(w, r)
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// saveBranding stores b as the tenant's API-set branding
+func saveBranding(w http.ResponseWriter, r *http.Request, t *Tenant, b Branding) {
+    if err := b.check(t.ID); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if err := brandings.Put(t.ID, b); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving branding: %v", err), http.StatusInternalServerError)
+        return
+    }
+    renders().purge()
+    loggerFrom(r.Context()).Printf("Branding for %s set by %s", t.ID, identityFrom(r.Context()))
+    writeJSON(w, http.StatusOK, b)
+}
+
+// uploadBrandingTemplate installs a bare workbook as the tenant's card
+func uploadBrandingTemplate(w http.ResponseWriter, r *http.Request, t *Tenant) {
+    data, err := io.ReadAll(io.LimitReader(r.Body, maxBundleSize+1))
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if len(data) > maxBundleSize {
+        httpError(w, r, "template too large", http.StatusRequestEntityTooLarge)
+        return
+    }
+    wb, err := excelize.OpenReader(bytes.NewReader(data))
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("invalid template: %v", err), http.StatusBadRequest)
+        return
+    }
+    sheets := len(wb.GetSheetList())
+    _ = wb.Close()
+    if sheets == 0 {
+        httpError(w, r, "invalid template: no sheets", http.StatusBadRequest)
+        return
+    }
+    keep, _, err := installTemplate(t.ID, brandingTemplateKey, map[string][]byte{"template.xlsx": data})
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("error saving template: %v", err), http.StatusInternalServerError)
+        return
+    }
+    keep()
+    b := t.branding()
+    b.Template = brandingTemplateKey
+    saveBranding(w, r, t, b)
+}
+
+// uploadLogo keeps the tenant's logo under DATA_DIR/branding
+func uploadLogo(w http.ResponseWriter, r *http.Request, t *Tenant) {
+    raw, err := io.ReadAll(io.LimitReader(r.Body, maxLogoBytes+1))
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if len(raw) > maxLogoBytes {
+        httpError(w, r, "logo too large", http.StatusRequestEntityTooLarge)
+        return
+    }
+    _, format, err := image.DecodeConfig(bytes.NewReader(raw))
+    if err != nil || (format != "png" && format != "jpeg") {
+        httpError(w, r, "invalid request: logo must be a PNG or JPEG", http.StatusBadRequest)
+        return
+    }
+    dir := filepath.Join(dataDir(), "branding")
+    if err := os.MkdirAll(dir, 0o755); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving logo: %v", err), http.StatusInternalServerError)
+        return
+    }
+    path := filepath.Join(dir, pathSegment(t.ID)+"."+format)
+    if err := os.WriteFile(path, raw, 0o644); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving logo: %v", err), http.StatusInternalServerError)
+        return
+    }
+    b := t.branding()
+    b.Logo = path
+    saveBranding(w, r, t, b)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+)
+
+/* ===============
+   Break deduction
+   =============== */
+
+// Under the collective agreement a long day carries an unpaid break the
+// crew doesn't always take off their hours. With
+//
+//   "break_rules": [{"after_hours": 6, "deduct_minutes": 30},
+//                   {"after_hours": 10, "deduct_minutes": 15}]
+//
+// every rule a day's worked hours exceed deducts its minutes from that
+// day (45 minutes off an 11-hour day here). The break comes off the day's
+// largest regular entry, then the next, and overtime only when a day has
+// no regular hours left; holiday and leave hours are never touched. The
+// cells show the hours after the deduction, and each deduction is noted
+// in the card's warnings.
+
+// BreakRule deducts an unpaid break from a day longer than AfterHours
+type BreakRule struct {
+    AfterHours    float64 `json:"after_hours"`
+    DeductMinutes float64 `json:"deduct_minutes"`
+}
+
+// BreakDeduction is one day's deducted break, kept on the card so the
+// deduction is made only once
+type BreakDeduction struct {
+    Date     string   `json:"date"` // YYYY-MM-DD
+    Worked   float64  `json:"worked"`
+    Deducted float64  `json:"deducted"`
+    Jobs     []string `json:"jobs"`
+}
+
+func validateBreakRules(rules []BreakRule) error {
+    for _, r := range rules {
+        if r.AfterHours <= 0 || r.AfterHours >= 24 {
+            return fmt.Errorf("break rule after_hours %g must be between 0 and 24", r.AfterHours)
+        }
+        if r.DeductMinutes <= 0 || r.DeductMinutes > 120 {
+            return fmt.Errorf("break rule deduct_minutes %g must be between 0 and 120", r.DeductMinutes)
+        }
+    }
+    return nil
+}
+
+// breakFor is the break owed on a day of worked hours
+func breakFor(rules []BreakRule, worked float64) float64 {
+    var minutes float64
+    for _, r := range rules {
+        if worked > r.AfterHours {
+            minutes += r.DeductMinutes
+        }
+    }
+    return minutes / 60
+}
+
+// deductBreaks takes the tenant's breaks off req's long days, once
+func deductBreaks(req *TimecardRequest, t *Tenant) {
+    if len(t.BreakRules) == 0 || req.BreaksDeducted != nil {
+        return
+    }
+    var entries []*Entry
+    if len(req.Weeks) == 0 {
+        for i := range req.Entries {
+            entries = append(entries, &req.Entries[i])
+        }
+    } else {
+        for w := range req.Weeks {
+            for i := range req.Weeks[w].Entries {
+                entries = append(entries, &req.Weeks[w].Entries[i])
+            }
+        }
+    }
+
+    loc := cardLocation(*req, t)
+    days := map[string][]*Entry{}
+    var order []string
+    for _, e := range entries {
+        day, err := parseCalendarDate(e.Date, loc)
+        if err != nil || !e.worked() || e.Hours <= 0 {
+            continue
+        }
+        date := day.Format("2006-01-02")
+        if days[date] == nil {
+            order = append(order, date)
+        }
+        days[date] = append(days[date], e)
+    }
+    sort.Strings(order)
+
+    req.BreaksDeducted = []BreakDeduction{}
+    for _, date := range order {
+        day := days[date]
+        var worked float64
+        for _, e := range day {
+            worked += e.Hours
+        }
+        owed := breakFor(t.BreakRules, worked)
+        if owed == 0 {
+            continue
+        }
+        // regular before overtime, largest first
+        sort.SliceStable(day, func(a, b int) bool {
+            if day[a].Overtime != day[b].Overtime {
+                return !day[a].Overtime
+            }
+            return day[a].Hours > day[b].Hours
+        })
+        d := BreakDeduction{Date: date, Worked: roundHours(worked)}
+        for _, e := range day {
+            if owed <= 0 {
+                break
+            }
+            take := owed
+            if take > e.Hours {
+                take = e.Hours
+            }
+            e.Hours = roundHours(e.Hours - take)
+            owed -= take
+            d.Deducted += take
+            d.Jobs = append(d.Jobs, e.JobCode)
+        }
+        d.Deducted = roundHours(d.Deducted)
+        req.BreaksDeducted = append(req.BreaksDeducted, d)
+    }
+}
+
+// noteBreaks lists the card's deductions among its warnings
+func noteBreaks(v *validationResult, req TimecardRequest) {
+    for _, d := range req.BreaksDeducted {
+        v.warnf("%s: %g h unpaid break deducted from %g h worked (job %s)", d.Date, d.Deducted, d.Worked, strings.Join(d.Jobs, ", "))
+    }
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+    "fmt"
+    "reflect"
+    "testing"
+    "time"
+)
+
+// describeEntries writes entries as "Mon A 8", "Mon A 2 ot@16:00", "Mon A 1 dt"
+func describeEntries(entries []Entry) []string {
+    var out []string
+    for _, e := range entries {
+        day, _ := time.Parse(time.RFC3339, e.Date)
+        s := fmt.Sprintf("%s %s %g", day.Format("Mon"), e.JobCode, e.Hours)
+        switch {
+        case e.DoubleTime:
+            s += " dt"
+        case e.Overtime:
+            s += " ot"
+        }
+        if e.Type != "" {
+            s += " " + e.Type
+        }
+        if e.Start != "" {
+            s += "@" + e.Start
+        }
+        out = append(out, s)
+    }
+    return out
+}
+
+func TestDeductBreaks(t *testing.T) {
+    rules := []BreakRule{{AfterHours: 6, DeductMinutes: 30}, {AfterHours: 10, DeductMinutes: 15}}
+    overtime := func(e Entry) Entry { e.Overtime = true; return e }
+    leave := func(e Entry) Entry { e.Type = entrySick; return e }
+
+    tests := []struct {
+        name    string
+        entries []Entry
+        want    []string
+        deduct  []BreakDeduction
+    }{
+        {"at the threshold", []Entry{worked("Mon", "A", 6)}, []string{"Mon A 6"}, []BreakDeduction{}},
+        {"past the first rule", []Entry{worked("Mon", "A", 7)}, []string{"Mon A 6.5"},
+            []BreakDeduction{{Date: "2025-01-06", Worked: 7, Deducted: 0.5, Jobs: []string{"A"}}}},
+        {"past both rules", []Entry{worked("Mon", "A", 11)}, []string{"Mon A 10.25"},
+            []BreakDeduction{{Date: "2025-01-06", Worked: 11, Deducted: 0.75, Jobs: []string{"A"}}}},
+        {"largest regular entry first",
+            []Entry{worked("Mon", "A", 3), worked("Mon", "B", 5), overtime(worked("Mon", "C", 3))},
+            []string{"Mon A 3", "Mon B 4.25", "Mon C 3 ot"},
+            []BreakDeduction{{Date: "2025-01-06", Worked: 11, Deducted: 0.75, Jobs: []string{"B"}}}},
+        {"overtime once regular runs out",
+            []Entry{worked("Mon", "A", 0.25), overtime(worked("Mon", "B", 8))},
+            []string{"Mon A 0", "Mon B 7.75 ot"},
+            []BreakDeduction{{Date: "2025-01-06", Worked: 8.25, Deducted: 0.5, Jobs: []string{"A", "B"}}}},
+        {"leave doesn't count", []Entry{leave(worked("Mon", "SICK", 4)), worked("Mon", "A", 4)},
+            []string{"Mon SICK 4 sick", "Mon A 4"}, []BreakDeduction{}},
+        {"each day on its own", []Entry{worked("Mon", "A", 4), worked("Tue", "A", 8), worked("Mon", "B", 5)},
+            []string{"Mon A 4", "Tue A 7.5", "Mon B 4.5"},
+            []BreakDeduction{
+                {Date: "2025-01-06", Worked: 9, Deducted: 0.5, Jobs: []string{"B"}},
+                {Date: "2025-01-07", Worked: 8, Deducted: 0.5, Jobs: []string{"A"}},
+            }},
+    }
+    tenant := &Tenant{ID: "break-test", BreakRules: rules}
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := TimecardRequest{Weeks: []WeekData{{Entries: append([]Entry(nil), tt.entries...)}}}
+            deductBreaks(&req, tenant)
+            if got := describeEntries(req.Weeks[0].Entries); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("entries\n got  %q\n want %q", got, tt.want)
+            }
+            if !reflect.DeepEqual(req.BreaksDeducted, tt.deduct) {
+                t.Errorf("deductions\n got  %+v\n want %+v", req.BreaksDeducted, tt.deduct)
+            }
+
+            // a card already deducted is left alone
+            again := describeEntries(req.Weeks[0].Entries)
+            deductBreaks(&req, tenant)
+            if got := describeEntries(req.Weeks[0].Entries); !reflect.DeepEqual(got, again) {
+                t.Errorf("deducted twice: %q", got)
+            }
+        })
+    }
+}
+
+func TestValidateBreakRules(t *testing.T) {
+    tests := []struct {
+        rule BreakRule
+        ok   bool
+    }{
+        {BreakRule{AfterHours: 6, DeductMinutes: 30}, true},
+        {BreakRule{AfterHours: 0, DeductMinutes: 30}, false},
+        {BreakRule{AfterHours: 24, DeductMinutes: 30}, false},
+        {BreakRule{AfterHours: 6, DeductMinutes: 0}, false},
+        {BreakRule{AfterHours: 6, DeductMinutes: 121}, false},
+    }
+    for _, tt := range tests {
+        if err := validateBreakRules([]BreakRule{tt.rule}); (err == nil) != tt.ok {
+            t.Errorf("%+v: %v, want ok=%v", tt.rule, err, tt.ok)
+        }
+    }
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "fmt"
+    "net/http"
+)
+
+/* ======
+   Bundle
+   ====== */
+
+// generateBundleHandler serves POST /api/generate-bundle: the workbook and
+// its PDF in one ZIP, built from a single Excel generation.
+func generateBundleHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    lg := loggerFrom(r.Context())
+
+    var req TimecardRequest
+    payload, err := readPayload(r, &req)
+    if err != nil {
+        lg.Printf("decode error: %v", err)
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    logEntries(lg, req)
+
+    applyTimecardDefaults(&req, tenantFor(r))
+
+    if _, ok := checkTimecard(w, r, req); !ok {
+        return
+    }
+    planGeneration(r.Context(), req, "converted")
+
+    lg.Printf("Generating timecard bundle for %s", req.EmployeeName)
+
+    gc := newGenContext(r.Context(), req, tenantFor(r), lg)
+    excelData, err := renderExcel(gc)
+    if err != nil {
+        lg.Printf("excel error: %v", err)
+        generationFailed(w, r, "error generating Excel", err)
+        return
+    }
+    pdfData, err := renderPDF(gc, excelData)
+    if err != nil {
+        lg.Printf("pdf conversion error: %v", err)
+        generationFailed(w, r, "error converting to PDF", err)
+        return
+    }
+    reportProgress(r.Context(), "converted", fmt.Sprintf("%d bytes", len(pdfData)))
+
+    files := []struct {
+        ext  string
+        data []byte
+    }{
+        {"xlsx", excelData},
+        {"pdf", pdfData},
+    }
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    for _, f := range files {
+        // Both formats are already compressed; storing them is as small and faster
+        name := fmt.Sprintf("timecard_%s.%s", req.fileEmployee(), f.ext)
+        fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: now()})
+        if err == nil {
+            _, err = fw.Write(f.data)
+        }
+        if err != nil {
+            generationFailed(w, r, "error building bundle", err)
+            return
+        }
+    }
+    if err := zw.Close(); err != nil {
+        generationFailed(w, r, "error building bundle", err)
+        return
+    }
+    finishProgress(r.Context(), nil)
+
+    id := saveTimecardRecord(r, "bundle", payload, req)
+    if id != "" {
+        for _, f := range files {
+            if link := storeArtifact(r, id, req, f.ext, f.data); link != "" {
+                w.Header().Add("X-Timecard-Drive-Link", link)
+            }
+        }
+        w.Header().Set("X-Timecard-ID", id)
+    }
+    emitEvent(r.Context(), "timecard.generated", tenantFor(r).ID, timecardEventData(id, req, "bundle", buf.Len()))
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"timecard_%s.zip\"", req.fileEmployee()))
+    w.WriteHeader(http.StatusOK)
+    _, _ = w.Write(buf.Bytes())
+
+    lg.Printf("OK: bundle bytes=%d", buf.Len())
+}
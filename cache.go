@@ -0,0 +1,179 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sync"
+    "time"
+)
+
+/* =====================
+   Rendered-file cache
+   ===================== */
+
+// The app typically generates a preview and then emails the very same card
+// seconds later. Finished files are kept briefly, keyed by a hash of all
+// that goes into them (output kind, tenant config, template file and the
+// request after defaults), so the second call skips the pipeline.
+//
+//   RENDER_CACHE_TTL        how long a file is reused (default 5m, 0 disables)
+//   RENDER_CACHE_MAX_BYTES  memory cap; oldest files go first (default 64 MB)
+//
+// A hit returns the earlier file byte for byte, so its footer reference
+// names the request that first rendered it.
+
+type renderCacheEntry struct {
+    data    []byte
+    expires time.Time
+}
+
+type renderCache struct {
+    ttl      time.Duration
+    maxBytes int
+
+    mu      sync.Mutex
+    size    int
+    entries map[string]renderCacheEntry
+    order   []string // oldest first
+}
+
+var (
+    rendersOnce sync.Once
+    theRenders  *renderCache
+)
+
+func renders() *renderCache {
+    rendersOnce.Do(func() {
+        theRenders = &renderCache{
+            ttl:      envDuration("RENDER_CACHE_TTL", 5*time.Minute),
+            maxBytes: envInt("RENDER_CACHE_MAX_BYTES", 64<<20),
+            entries:  map[string]renderCacheEntry{},
+        }
+    })
+    return theRenders
+}
+
+// renderKey hashes everything that determines the kind ("xlsx", "pdf") of
+// file gc would produce. "" means don't cache.
+func renderKey(gc *genContext, kind string) string {
+    if renders().ttl <= 0 {
+        return ""
+    }
+    tpl, err := resolveTemplate(gc.tenantID(), gc.req.Template)
+    if err != nil {
+        return ""
+    }
+    info, err := os.Stat(tpl.Path)
+    if err != nil {
+        return ""
+    }
+    tenant, err := json.Marshal(gc.tenant)
+    if err != nil {
+        return ""
+    }
+    req, err := json.Marshal(gc.req)
+    if err != nil {
+        return ""
+    }
+    h := sha256.New()
+    fmt.Fprintf(h, "%s\x00%d\x00%s\x00%d\x00%d\x00", kind, gc.printWeek, tpl.Path, info.Size(), info.ModTime().UnixNano())
+    h.Write(tenant)
+    h.Write([]byte{0})
+    h.Write(req)
+    return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *renderCache) get(key string) ([]byte, bool) {
+    if key == "" {
+        return nil, false
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    e, ok := c.entries[key]
+    if !ok || time.Now().After(e.expires) {
+        statRenderCacheMisses.Add(1)
+        return nil, false
+    }
+    statRenderCacheHits.Add(1)
+    return e.data, true
+}
+
+func (c *renderCache) put(key string, data []byte) {
+    if key == "" || len(data) > c.maxBytes {
+        return
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.removeLocked(key)
+    c.entries[key] = renderCacheEntry{data: data, expires: time.Now().Add(c.ttl)}
+    c.order = append(c.order, key)
+    c.size += len(data)
+    for len(c.order) > 0 {
+        oldest := c.order[0]
+        if c.size <= c.maxBytes && time.Now().Before(c.entries[oldest].expires) {
+            break
+        }
+        c.removeLocked(oldest)
+    }
+}
+
+func (c *renderCache) removeLocked(key string) {
+    e, ok := c.entries[key]
+    if !ok {
+        return
+    }
+    delete(c.entries, key)
+    c.size -= len(e.data)
+    for i, k := range c.order {
+        if k == key {
+            c.order = append(c.order[:i], c.order[i+1:]...)
+            break
+        }
+    }
+}
+
+// purge forgets everything, e.g. after a template bundle changed
+func (c *renderCache) purge() {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.entries = map[string]renderCacheEntry{}
+    c.order = nil
+    c.size = 0
+}
+
+// renderExcel is generateExcelFile through the cache
+func renderExcel(gc *genContext) ([]byte, error) {
+    key := renderKey(gc, "xlsx")
+    if data, ok := renders().get(key); ok {
+        gc.lg.Printf("Reusing cached workbook (%d bytes)", len(data))
+        return data, nil
+    }
+    data, err := generateExcelFile(gc)
+    if err != nil {
+        return nil, err
+    }
+    renders().put(key, data)
+    return data, nil
+}
+
+// renderPDF converts gc's workbook (excelData) through the cache
+func renderPDF(gc *genContext, excelData []byte) ([]byte, error) {
+    key := renderKey(gc, "pdf")
+    if data, ok := renders().get(key); ok {
+        gc.lg.Printf("Reusing cached PDF (%d bytes)", len(data))
+        return data, nil
+    }
+    excelData, err := printedExcel(gc, excelData)
+    if err != nil {
+        return nil, err
+    }
+    data, err := generatePDFFromExcel(gc.ctx, excelData, fmt.Sprintf("timecard_%s.xlsx", gc.req.fileEmployee()), gc.req.pdfOptions(gc.tenant), gc.lg)
+    if err != nil {
+        return nil, err
+    }
+    renders().put(key, data)
+    return data, nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "flag"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "os/signal"
+    "path/filepath"
+    "sort"
+    "strings"
+    "syscall"
+)
+
+/* ========
+   Commands
+   ======== */
+
+// The binary is the server and a small offline toolbox:
+//
+//   timecard [serve] [-port 8080]
+//   timecard generate -i request.json -o card.xlsx [-format pdf] [-tenant acme]
+//   timecard convert [-o card.pdf] card.xlsx
+//   timecard email -i request.json -to payroll@example.com [-cc ...] [-subject ...]
+//   timecard watch -in dir [-out dir] [-format xlsx|pdf|both] (dropfolder.go)
+//
+// The offline commands read the same JSON the API takes, apply the same
+// tenant defaults and validation (tenants.json, DATA_DIR) and use the same
+// SOFFICE_* and SMTP_* settings, but no server is started. "-" is stdin or
+// stdout. The log is quiet unless -v is given.
+
+type command struct {
+    run     func(args []string) error
+    summary string
+}
+
+var commands map[string]command
+
+func init() {
+    commands = map[string]command{
+        "serve":    {serveCommand, "run the HTTP server (the default)"},
+        "generate": {generateCommand, "render a request to .xlsx or .pdf"},
+        "convert":  {convertCommand, "convert an .xlsx workbook to PDF"},
+        "email":    {emailCommand, "render a request and email it"},
+        "watch":    {watchCommand, "turn requests dropped in a folder into cards"},
+    }
+}
+
+// runCommand runs the subcommand named by args[0] (serve when there is
+// none) and returns the exit status
+func runCommand(args []string) int {
+    name := "serve"
+    if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+        name, args = args[0], args[1:]
+    }
+    if name == "help" {
+        printUsage()
+        return 0
+    }
+    cmd, ok := commands[name]
+    if !ok {
+        fmt.Fprintf(os.Stderr, "unknown command %q\n", name)
+        printUsage()
+        return 2
+    }
+    if err := cmd.run(args); err != nil {
+        if errors.Is(err, flag.ErrHelp) {
+            return 0
+        }
+        fmt.Fprintf(os.Stderr, "%s: %v\n", name, err)
+        return 1
+    }
+    return 0
+}
+
+func printUsage() {
+    names := make([]string, 0, len(commands))
+    for name := range commands {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    fmt.Fprintln(os.Stderr, "usage: timecard <command> [flags]")
+    for _, name := range names {
+        fmt.Fprintf(os.Stderr, "  %-9s %s\n", name, commands[name].summary)
+    }
+}
+
+// offlineFlags is the flag set of an offline command, with -v
+func offlineFlags(name string) (*flag.FlagSet, *bool) {
+    fs := flag.NewFlagSet(name, flag.ContinueOnError)
+    return fs, fs.Bool("v", false, "log what is going on to stderr")
+}
+
+// quietLog silences the log unless verbose
+func quietLog(verbose bool) {
+    if !verbose {
+        log.SetOutput(io.Discard)
+    }
+}
+
+// commandContext is cancelled by SIGINT or SIGTERM
+func commandContext() (context.Context, context.CancelFunc) {
+    return signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+}
+
+// commandTenant is the tenant named by -tenant, which must exist unless it
+// is the default
+func commandTenant(id string) (*Tenant, error) {
+    if _, ok := loadTenants()[id]; !ok && id != defaultTenantID {
+        return nil, fmt.Errorf("unknown tenant %q", id)
+    }
+    return lookupTenant(id), nil
+}
+
+func readInput(path string) ([]byte, error) {
+    if path == "-" {
+        return io.ReadAll(os.Stdin)
+    }
+    return os.ReadFile(path)
+}
+
+func writeOutput(path string, data []byte) error {
+    if path == "-" {
+        _, err := os.Stdout.Write(data)
+        return err
+    }
+    if err := os.WriteFile(path, data, 0o644); err != nil {
+        return err
+    }
+    fmt.Fprintf(os.Stderr, "wrote %s (%d bytes)\n", path, len(data))
+    return nil
+}
+
+// checkOffline validates req like the API does, printing the warnings
+func checkOffline(req TimecardRequest, t *Tenant) error {
+    v := validateTimecard(req, t)
+    for _, w := range v.Warnings {
+        fmt.Fprintf(os.Stderr, "warning: %s\n", w)
+    }
+    if len(v.Errors) > 0 {
+        return v
+    }
+    return nil
+}
+
+// renderOffline renders req as format (xlsx or pdf)
+func renderOffline(ctx context.Context, req TimecardRequest, t *Tenant, format string) ([]byte, error) {
+    data, err := generateExcelFile(newGenContext(ctx, req, t, nil))
+    if err != nil || format == "xlsx" {
+        return data, err
+    }
+    return generatePDFFromExcel(ctx, data, fmt.Sprintf("timecard_%s.xlsx", req.fileEmployee()), req.pdfOptions(t), nil)
+}
+
+func generateCommand(args []string) error {
+    fs, verbose := offlineFlags("generate")
+    in := fs.String("i", "-", "request JSON file")
+    out := fs.String("o", "", "output file (default timecard_<employee>.<format>)")
+    format := fs.String("format", "", "xlsx or pdf (default from -o, else xlsx)")
+    tenantID := fs.String("tenant", defaultTenantID, "tenant whose defaults apply")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    quietLog(*verbose)
+
+    if *format == "" {
+        *format = "xlsx"
+        if strings.EqualFold(filepath.Ext(*out), ".pdf") {
+            *format = "pdf"
+        }
+    }
+    if *format != "xlsx" && *format != "pdf" {
+        return fmt.Errorf("-format must be xlsx or pdf")
+    }
+    t, err := commandTenant(*tenantID)
+    if err != nil {
+        return err
+    }
+    body, err := readInput(*in)
+    if err != nil {
+        return err
+    }
+    var req TimecardRequest
+    if err := json.Unmarshal(body, &req); err != nil {
+        return fmt.Errorf("invalid request: %w", err)
+    }
+    applyTimecardDefaults(&req, t)
+    if err := checkOffline(req, t); err != nil {
+        return err
+    }
+
+    ctx, stop := commandContext()
+    defer stop()
+    data, err := renderOffline(ctx, req, t, *format)
+    if err != nil {
+        return err
+    }
+    if *out == "" {
+        *out = fmt.Sprintf("timecard_%s.%s", strings.ReplaceAll(req.fileEmployee(), " ", "_"), *format)
+    }
+    return writeOutput(*out, data)
+}
+
+func convertCommand(args []string) error {
+    fs, verbose := offlineFlags("convert")
+    out := fs.String("o", "", "output file (default: the workbook's name with .pdf)")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    quietLog(*verbose)
+    if fs.NArg() != 1 {
+        return fmt.Errorf("usage: timecard convert [-o card.pdf] card.xlsx")
+    }
+    in := fs.Arg(0)
+    data, err := readInput(in)
+    if err != nil {
+        return err
+    }
+    name := filepath.Base(in)
+    if in == "-" {
+        name = "timecard.xlsx"
+    }
+    if *out == "" {
+        if in == "-" {
+            *out = "-"
+        } else {
+            *out = strings.TrimSuffix(in, filepath.Ext(in)) + ".pdf"
+        }
+    }
+
+    ctx, stop := commandContext()
+    defer stop()
+    pdf, err := generatePDFFromExcel(ctx, data, name, pdfOptions{}, nil)
+    if err != nil {
+        return err
+    }
+    return writeOutput(*out, pdf)
+}
+
+func emailCommand(args []string) error {
+    fs, verbose := offlineFlags("email")
+    in := fs.String("i", "-", "request JSON file, as sent to /api/email-timecard")
+    to := fs.String("to", "", "recipients (default the request's)")
+    cc := fs.String("cc", "", "CC recipients (default the request's)")
+    subject := fs.String("subject", "", "subject (default the request's)")
+    bodyText := fs.String("body", "", "message body (default the request's)")
+    tenantID := fs.String("tenant", defaultTenantID, "tenant whose defaults and SMTP settings apply")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    quietLog(*verbose)
+
+    t, err := commandTenant(*tenantID)
+    if err != nil {
+        return err
+    }
+    body, err := readInput(*in)
+    if err != nil {
+        return err
+    }
+    var req EmailTimecardRequest
+    if err := json.Unmarshal(body, &req); err != nil {
+        return fmt.Errorf("invalid request: %w", err)
+    }
+    for _, o := range []struct {
+        flag string
+        dst  *string
+    }{{*to, &req.To}, {*subject, &req.Subject}, {*bodyText, &req.Body}} {
+        if o.flag != "" {
+            *o.dst = o.flag
+        }
+    }
+    if *cc != "" {
+        req.CC = cc
+    }
+    if req.To == "" {
+        return fmt.Errorf("no recipients: give -to or \"to\" in the request")
+    }
+    applyEmailDefaults(&req, t)
+    if err := checkOffline(req.TimecardRequest, t); err != nil {
+        return err
+    }
+
+    ctx, stop := commandContext()
+    defer stop()
+    ctx = withTenant(ctx, t)
+    data, err := renderOffline(ctx, req.TimecardRequest, t, "xlsx")
+    if err != nil {
+        return err
+    }
+    if err := checkAttachments(req.Attachments); err != nil {
+        return err
+    }
+    if err := sendEmail(ctx, req.To, req.CC, req.Subject, req.Body, data, emailAttachmentName(req.fileEmployee(), t.location()), nil, req.Attachments...); err != nil {
+        return err
+    }
+    fmt.Fprintf(os.Stderr, "sent to %s\n", req.To)
+    return nil
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+    "log"
+    "os"
+    "sync"
+    "time"
+)
+
+/* ===========
+   Time source
+   =========== */
+
+// Clock is the single source of "now" for anything that ends up in a
+// document, filename, deadline or schedule. Swap it (e.g. for a fixed
+// clock) to reproduce date-boundary behavior deterministically.
+type Clock interface {
+    Now() time.Time
+}
+
+type systemClock struct {
+    offset time.Duration
+}
+
+func (c systemClock) Now() time.Time { return time.Now().Add(c.offset) }
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time { return time.Time(c) }
+
+var (
+    clockMu  sync.RWMutex
+    theClock Clock = newClockFromEnv()
+)
+
+// newClockFromEnv honors CLOCK_FIXED (RFC 3339, freezes time for
+// reproducing a bug report) and CLOCK_OFFSET (a duration such as "-90s",
+// correcting a host whose clock is known to be skewed).
+func newClockFromEnv() Clock {
+    if v := os.Getenv("CLOCK_FIXED"); v != "" {
+        t, err := time.Parse(time.RFC3339, v)
+        if err == nil {
+            log.Printf("Clock fixed at %s", t.Format(time.RFC3339))
+            return fixedClock(t)
+        }
+        log.Printf("Warning: bad CLOCK_FIXED=%q: %v", v, err)
+    }
+    return systemClock{offset: envDuration("CLOCK_OFFSET", 0)}
+}
+
+func now() time.Time {
+    clockMu.RLock()
+    defer clockMu.RUnlock()
+    return theClock.Now()
+}
+
+// setClock replaces the time source and returns the previous one
+func setClock(c Clock) Clock {
+    clockMu.Lock()
+    defer clockMu.Unlock()
+    prev := theClock
+    theClock = c
+    return prev
+}
+
+// timestampZone is the location of a tenant with no time zone. It is UTC
+// for everything but calendarDate, which reads a client timestamp's day at
+// the timestamp's own offset, as cards were read before tenants had zones.
+var timestampZone = time.FixedZone("UTC", 0)
+
+// location returns the tenant's configured time zone, or timestampZone
+func (t *Tenant) location() *time.Location {
+    if t == nil || t.Timezone == "" {
+        return timestampZone
+    }
+    loc, err := time.LoadLocation(t.Timezone)
+    if err != nil {
+        log.Printf("Warning: tenant %s has bad timezone %q: %v", t.ID, t.Timezone, err)
+        return timestampZone
+    }
+    return loc
+}
+
+// calendarDate returns midnight UTC of the calendar day t falls on in loc.
+// Client timestamps are instants (the app sends local midnight as UTC), so
+// the day must be read in the company's zone; after that all date math is
+// done on UTC midnights, where DST can't shift a day boundary. Without a
+// zone (timestampZone) the day is the one the timestamp was written in.
+func calendarDate(t time.Time, loc *time.Location) time.Time {
+    if loc != timestampZone {
+        t = t.In(loc)
+    }
+    y, m, d := t.Date()
+    return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// parseCalendarDate parses an RFC 3339 timestamp into its calendar day in loc
+func parseCalendarDate(s string, loc *time.Location) (time.Time, error) {
+    t, err := time.Parse(time.RFC3339, s)
+    if err != nil {
+        return time.Time{}, err
+    }
+    return calendarDate(t, loc), nil
+}
+
+// today is the current calendar day in loc
+func today(loc *time.Location) time.Time {
+    return calendarDate(now().In(loc), loc)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+    "testing"
+    "time"
+)
+
+func TestParseCalendarDate(t *testing.T) {
+    toronto := (&Tenant{ID: "tz-test", Timezone: "America/Toronto"}).location()
+    tests := []struct {
+        stamp string
+        loc   *time.Location
+        want  string
+    }{
+        // no zone: the day as the client wrote it
+        {"2025-01-06T00:00:00-05:00", (*Tenant)(nil).location(), "2025-01-06"},
+        {"2025-01-06T00:00:00+10:00", (&Tenant{ID: "no-tz"}).location(), "2025-01-06"},
+        {"2025-01-06T05:00:00Z", timestampZone, "2025-01-06"},
+        // the tenant's zone: the instant's day there
+        {"2025-01-06T05:00:00Z", toronto, "2025-01-06"},
+        {"2025-01-06T03:00:00Z", toronto, "2025-01-05"},
+        {"2025-01-06T00:00:00+10:00", time.UTC, "2025-01-05"},
+    }
+    for _, tt := range tests {
+        got, err := parseCalendarDate(tt.stamp, tt.loc)
+        if err != nil || got.Format("2006-01-02") != tt.want {
+            t.Errorf("%s in %s = %s %v, want %s", tt.stamp, tt.loc, got.Format("2006-01-02"), err, tt.want)
+        }
+    }
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "path/filepath"
+    "strings"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ======================
+   Generic PDF conversion
+   ====================== */
+
+// POST /api/convert-to-pdf takes any workbook (multipart field "file")
+// through the same LibreOffice pipeline, limits and breaker as timecards.
+// It is for internal documents, so it needs an admin API key; anonymous
+// callers don't get to feed arbitrary files to soffice. A "pdf_password"
+// form field locks the PDF (pdfpassword.go), and "pdf_a" makes it PDF/A
+// (pdfa.go).
+
+const maxConvertSize = 20 << 20
+
+func convertToPDFHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    lg := loggerFrom(r.Context())
+
+    r.Body = http.MaxBytesReader(w, r.Body, maxConvertSize+1<<20) // room for the multipart framing
+    file, hdr, err := r.FormFile("file")
+    if err != nil {
+        var tooBig *http.MaxBytesError
+        if errors.As(err, &tooBig) {
+            httpError(w, r, "file too large", http.StatusRequestEntityTooLarge)
+            return
+        }
+        httpError(w, r, fmt.Sprintf("invalid request: expected a multipart \"file\" field: %v", err), http.StatusBadRequest)
+        return
+    }
+    defer file.Close()
+    data, err := io.ReadAll(io.LimitReader(file, maxConvertSize+1))
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if len(data) > maxConvertSize {
+        httpError(w, r, "file too large", http.StatusRequestEntityTooLarge)
+        return
+    }
+
+    // Only hand soffice something that really is a workbook
+    name := filepath.Base(hdr.Filename)
+    if !strings.EqualFold(filepath.Ext(name), ".xlsx") {
+        httpError(w, r, "only .xlsx files can be converted", http.StatusUnsupportedMediaType)
+        return
+    }
+    f, err := excelize.OpenReader(bytes.NewReader(data))
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("not a readable .xlsx workbook: %v", err), http.StatusUnsupportedMediaType)
+        return
+    }
+    _ = f.Close()
+
+    opts := pdfOptions{Password: r.FormValue("pdf_password"), PDFA: r.FormValue("pdf_a")}
+    if opts.PDFA != "" {
+        if err := validatePDFA(opts.PDFA); err != nil {
+            httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+            return
+        }
+        if opts.Password != "" {
+            httpError(w, r, "invalid request: a PDF/A file can't be locked with a password", http.StatusBadRequest)
+            return
+        }
+    }
+
+    lg.Printf("Converting uploaded workbook (%d bytes) to PDF", len(data))
+    pdfData, err := generatePDFFromExcel(r.Context(), data, name, opts, lg)
+    if err != nil {
+        lg.Printf("pdf conversion error: %v", err)
+        generationFailed(w, r, "error converting to PDF", err)
+        return
+    }
+
+    base := pathSegment(strings.TrimSuffix(name, filepath.Ext(name)))
+    w.Header().Set("Content-Type", "application/pdf")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s.pdf\"", base))
+    w.WriteHeader(http.StatusOK)
+    _, _ = w.Write(pdfData)
+
+    lg.Printf("OK: converted PDF bytes=%d", len(pdfData))
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==========================
+   Phase and cost code levels
+   ========================== */
+
+// General contractors that track time below the job number send a phase
+// and cost code with each entry, or once on the job for all its entries:
+//
+//   "jobs": [{"job_code": "29699", "job_name": "201", "phase": "02", "cost_code": "03-300"}],
+//   "entries": [{"date": "...", "job_code": "29699", "phase": "03", "hours": 4}]
+//
+// An entry's own phase and cost code win over its job's. Entries on the same
+// job with a different phase or cost code get columns of their own. A
+// template maps the row of each table that takes them, the phase above the
+// labour code and the cost code above the job number:
+//
+//   "regular_cost_row": 3, "overtime_cost_row": 14
+//
+// Without those rows the job number cell reads "29699 / 02 / 03-300"
+// (a level left out stays blank, "29699 /  / 03-300"). The summary report
+// adds up the hours per job, phase and cost code.
+
+// costLevelSep joins the levels in an entry's column key; it can't be sent
+// in a phase or cost code
+const costLevelSep = "|"
+
+// costLevels is the job number an entry key's column is for, with the
+// entry's phase and cost code appended when it has either
+func costLevels(e Entry) string {
+    if e.Phase == "" && e.CostCode == "" {
+        return e.JobCode
+    }
+    return e.JobCode + costLevelSep + e.Phase + costLevelSep + e.CostCode
+}
+
+// splitCostLevels undoes costLevels
+func splitCostLevels(key string) (job, phase, costCode string) {
+    parts := strings.SplitN(key, costLevelSep, 3)
+    if len(parts) != 3 {
+        return key, "", ""
+    }
+    return parts[0], parts[1], parts[2]
+}
+
+// applyCostCodes gives worked entries their job's phase and cost code
+// where they have none of their own
+func applyCostCodes(req *TimecardRequest, t *Tenant) {
+    jobs := map[string]Job{}
+    for _, j := range t.Defaults.Jobs {
+        jobs[j.JobCode] = j
+    }
+    for _, j := range req.Jobs {
+        jobs[j.JobCode] = j
+    }
+    book := func(entries []Entry) {
+        for i := range entries {
+            e := &entries[i]
+            e.Phase, e.CostCode = strings.TrimSpace(e.Phase), strings.TrimSpace(e.CostCode)
+            if !e.worked() {
+                continue
+            }
+            j := jobs[e.JobCode]
+            if e.Phase == "" {
+                e.Phase = j.Phase
+            }
+            if e.CostCode == "" {
+                e.CostCode = j.CostCode
+            }
+        }
+    }
+    book(req.Entries)
+    for i := range req.Weeks {
+        book(req.Weeks[i].Entries)
+    }
+}
+
+// checkCostCodes rejects phases and cost codes the column keys can't carry
+func checkCostCodes(v *validationResult, req TimecardRequest) {
+    bad := func(s string) bool { return strings.Contains(s, costLevelSep) }
+    for _, j := range req.Jobs {
+        if bad(j.Phase) || bad(j.CostCode) {
+            v.errorf("job %s: phase and cost_code must not contain %q", j.JobCode, costLevelSep)
+        }
+    }
+    for _, e := range allEntries(req) {
+        if bad(e.Phase) || bad(e.CostCode) {
+            v.errorf("entry %s on job %s: phase and cost_code must not contain %q", e.Date, e.JobCode, costLevelSep)
+        }
+        if !e.worked() && (e.Phase != "" || e.CostCode != "") {
+            v.errorf("entry %s: %s has no phase or cost code", e.Date, e.Type)
+        }
+    }
+}
+
+// writeCostLevels puts a column's phase and cost code in its header: on
+// the table's cost row when the template maps one, else after the job
+// number on the header row
+func writeCostLevels(f *excelize.File, sheet, codeCol, jobCol string, headerRow, costRow int, job, phase, costCode string) {
+    if phase == "" && costCode == "" {
+        return
+    }
+    if costRow <= 0 {
+        _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", jobCol, headerRow), job+" / "+phase+" / "+costCode)
+        return
+    }
+    _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", codeCol, costRow), phase)
+    _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", jobCol, costRow), costCode)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+    "context"
+    "testing"
+
+    "github.com/xuri/excelize/v2"
+)
+
+func TestCostCodeColumns(t *testing.T) {
+    phased := func(e Entry, phase, cost string) Entry { e.Phase, e.CostCode = phase, cost; return e }
+    req := TimecardRequest{
+        EmployeeName: "Bob Smith",
+        Jobs:         []Job{{JobCode: "A", JobName: "201", Phase: "02", CostCode: "03-300"}, {JobCode: "B", JobName: "223"}},
+        Weeks: []WeekData{{WeekStartDate: "2025-01-05T00:00:00Z", Entries: []Entry{
+            worked("Mon", "A", 4),
+            phased(worked("Mon", "A", 4), "03", ""),
+            worked("Tue", "B", 8),
+        }}},
+    }
+    tenant := &Tenant{ID: "cost-test"}
+    applyCostCodes(&req, tenant)
+    var v validationResult
+    checkCostCodes(&v, req)
+    if len(v.Errors) > 0 {
+        t.Fatalf("errors: %v", v.Errors)
+    }
+    keys := getUniqueJobNumbersForType(req.Weeks[0].Entries, false)
+    if want := []string{"A|02|03-300", "A|03|03-300", "B"}; len(keys) != 3 || keys[0] != want[0] || keys[1] != want[1] || keys[2] != want[2] {
+        t.Fatalf("columns = %q, want %q", keys, want)
+    }
+
+    render := func(cm CellMap) *excelize.File {
+        f := excelize.NewFile()
+        gc := newGenContext(context.Background(), req, tenant, loggerFrom(context.Background()))
+        gc.tpl = &templateDef{CellMap: cm}
+        if err := fillWeekSheet(gc, f, "Sheet1", req.Weeks[0], 1); err != nil {
+            t.Fatal(err)
+        }
+        return f
+    }
+    cell := func(f *excelize.File, c string) string {
+        v, _ := f.GetCellValue("Sheet1", c)
+        return v
+    }
+
+    // no cost row: the levels follow the job number
+    cm := defaultCellMap()
+    f := render(cm)
+    if cell(f, "D4") != "A / 02 / 03-300" || cell(f, "F4") != "A / 03 / 03-300" || cell(f, "H4") != "B" || cell(f, "C4") != "201" {
+        t.Errorf("headers %q %q %q %q", cell(f, "C4"), cell(f, "D4"), cell(f, "F4"), cell(f, "H4"))
+    }
+
+    // a cost row above the header
+    cm.RegularCostRow = 3
+    f = render(cm)
+    if cell(f, "D4") != "A" || cell(f, "C3") != "02" || cell(f, "D3") != "03-300" || cell(f, "E3") != "03" || cell(f, "G3") != "" {
+        t.Errorf("cost row %q %q %q %q", cell(f, "C3"), cell(f, "D3"), cell(f, "E3"), cell(f, "G3"))
+    }
+
+    rep := summarize(tenant, []TimecardRequest{req})
+    if len(rep.CostCodes) != 2 || rep.CostCodes[0].Phase != "02" || rep.CostCodes[1].Phase != "03" || rep.CostCodes[1].Total != 4 {
+        t.Errorf("cost codes = %+v", rep.CostCodes)
+    }
+    if len(rep.Jobs) != 2 || rep.Jobs[0].Total != 8 {
+        t.Errorf("jobs = %+v", rep.Jobs)
+    }
+}
+
+func TestCheckCostCodes(t *testing.T) {
+    holiday := worked("Mon", "STAT", 8)
+    holiday.Type, holiday.Phase = entryHoliday, "02"
+    bad := worked("Tue", "A", 8)
+    bad.CostCode = "03|300"
+    var v validationResult
+    checkCostCodes(&v, TimecardRequest{Entries: []Entry{holiday, bad}})
+    if len(v.Errors) != 2 {
+        t.Errorf("errors = %q", v.Errors)
+    }
+}
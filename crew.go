@@ -0,0 +1,213 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+/* ====================
+   Crew card submission
+   ==================== */
+
+// A foreman can send the day's hours for the whole crew on one job at once:
+//
+//   POST /api/crew?generate=xlsx|pdf
+//   {"foreman": "Joe Smith", "date": "2025-01-06", "job_code": "29699",
+//    "pay_period_num": 7, "year": 2025, "week_start": "2025-01-05",
+//    "crew": [{"employee_name": "Bob Smith", "labour_code": "201", "hours": 8},
+//             {"employee_id": "E17", "labour_code": "223", "hours": 2, "overtime": true,
+//              "is_night_shift": false, "start": "07:00"}]}
+//
+// The crew is fanned out into one card per employee (a name listed twice
+// gets both lines on one card), each with the day's entry on the job.
+// pay_period_num and year, when left out, come from the tenant's pay
+// calendar; without a calendar the two weeks start on week_start or the
+// start of the date's payroll week, as in the CSV import. The response lists each
+// card as a generation request, validated. With generate, the cards that
+// passed validation are rendered and kept by an "import-generate" job
+// (202 with the job; see /admin/jobs), one generated card per employee.
+
+const maxCrewBytes = 1 << 20
+
+// CrewSubmission is one foreman's crew for a day on a job
+type CrewSubmission struct {
+    Foreman      string       `json:"foreman,omitempty"`
+    Date         string       `json:"date"` // YYYY-MM-DD
+    JobCode      string       `json:"job_code"`
+    PayPeriodNum int          `json:"pay_period_num,omitempty"`
+    Year         int          `json:"year,omitempty"`
+    WeekStart    string       `json:"week_start,omitempty"` // YYYY-MM-DD
+    Crew         []CrewMember `json:"crew"`
+}
+
+// CrewMember is one employee's hours in a crew submission
+type CrewMember struct {
+    EmployeeName string  `json:"employee_name,omitempty"`
+    EmployeeID   string  `json:"employee_id,omitempty"`
+    LabourCode   string  `json:"labour_code,omitempty"`
+    Hours        float64 `json:"hours"`
+    Overtime     bool    `json:"overtime,omitempty"`
+    IsNightShift bool    `json:"is_night_shift,omitempty"`
+    Start        string  `json:"start,omitempty"` // HH:MM
+    Notes        string  `json:"notes,omitempty"`
+}
+
+// crewCard is one employee's card built from a crew submission
+type crewCard struct {
+    Request TimecardRequest  `json:"request"`
+    Valid   bool             `json:"valid"`
+    Result  validationResult `json:"validation"`
+
+    raw TimecardRequest // before tenant defaults, as generation takes it
+}
+
+// crewHandler serves POST /api/crew (see the top of this file)
+func crewHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    format := r.URL.Query().Get("generate")
+    switch format {
+    case "", "xlsx", "pdf":
+    case "1", "true":
+        format = "xlsx"
+    default:
+        httpError(w, r, "invalid request: generate must be xlsx or pdf", http.StatusBadRequest)
+        return
+    }
+    var sub CrewSubmission
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCrewBytes)).Decode(&sub); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    t := tenantFor(r)
+    cards, v := crewCards(sub, t)
+    if len(v.Errors) > 0 {
+        writeJSON(w, http.StatusBadRequest, v)
+        return
+    }
+
+    valid := []TimecardRequest{}
+    for _, c := range cards {
+        if c.Valid {
+            valid = append(valid, c.raw)
+        }
+    }
+    lg := loggerFrom(r.Context())
+    lg.Printf("Crew submission from %q for job %s on %s: %d card(s), %d valid", sub.Foreman, sub.JobCode, sub.Date, len(cards), len(valid))
+    resp := map[string]interface{}{"cards": cards}
+    if format == "" || len(valid) == 0 {
+        writeJSON(w, http.StatusOK, resp)
+        return
+    }
+    owner := identityFrom(r.Context())
+    if owner == "" {
+        owner = clientIP(r)
+    }
+    job, err := enqueueJob("import-generate", owner, 0, importGenerateParams{Tenant: t.ID, Format: format, Cards: valid})
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("error queueing generation: %v", err), http.StatusInternalServerError)
+        return
+    }
+    lg.Printf("Crew submission: generating %d card(s) as %s in job %s", len(valid), format, job.ID)
+    resp["job"] = job
+    writeJSON(w, http.StatusAccepted, resp)
+}
+
+// crewCards fans the submission out into validated cards, one per
+// employee; the result has errors when the submission itself is unusable
+func crewCards(sub CrewSubmission, t *Tenant) ([]*crewCard, validationResult) {
+    var v validationResult
+    loc := t.location()
+    day, err := time.ParseInLocation("2006-01-02", sub.Date, loc)
+    if err != nil {
+        v.errorf("date %q is not YYYY-MM-DD", sub.Date)
+    }
+    var weekStart time.Time
+    if sub.WeekStart != "" {
+        if weekStart, err = time.ParseInLocation("2006-01-02", sub.WeekStart, loc); err != nil {
+            v.errorf("week_start %q is not YYYY-MM-DD", sub.WeekStart)
+        }
+    }
+    sub.JobCode = strings.TrimSpace(sub.JobCode)
+    if sub.JobCode == "" {
+        v.errorf("job_code is required")
+    }
+    if len(sub.Crew) == 0 {
+        v.errorf("crew is empty")
+    }
+    for i, m := range sub.Crew {
+        if strings.TrimSpace(m.EmployeeName) == "" && strings.TrimSpace(m.EmployeeID) == "" {
+            v.errorf("crew member %d: no employee_name or employee_id", i+1)
+        }
+        if m.Hours <= 0 {
+            v.errorf("crew member %d: hours must be positive", i+1)
+        }
+    }
+    if len(v.Errors) > 0 {
+        return nil, v
+    }
+
+    period, year := sub.PayPeriodNum, sub.Year
+    cal := t.payCalendar()
+    if (period == 0 || year == 0) && cal != nil {
+        p, err := cal.periodOf(day)
+        if err != nil {
+            v.errorf("%v", err)
+            return nil, v
+        }
+        if period == 0 {
+            period = p.Number
+        }
+        if year == 0 {
+            year = p.FiscalYear
+        }
+    }
+
+    byKey := map[string]*crewCard{}
+    var order []string
+    for _, m := range sub.Crew {
+        name, id := strings.TrimSpace(m.EmployeeName), strings.TrimSpace(m.EmployeeID)
+        key := strings.ToLower(name)
+        if id != "" {
+            key = "#" + id
+        }
+        c, ok := byKey[key]
+        if !ok {
+            c = &crewCard{raw: TimecardRequest{EmployeeName: name, EmployeeID: id, PayPeriodNum: period, Year: year}}
+            byKey[key] = c
+            order = append(order, key)
+        }
+        if m.LabourCode != "" && !hasImportJob(c.raw.Jobs, sub.JobCode) {
+            c.raw.Jobs = append(c.raw.Jobs, Job{JobCode: sub.JobCode, JobName: m.LabourCode})
+        }
+        c.raw.Entries = append(c.raw.Entries, Entry{
+            Date:         day.Format(time.RFC3339),
+            JobCode:      sub.JobCode,
+            Hours:        m.Hours,
+            Overtime:     m.Overtime,
+            IsNightShift: m.IsNightShift,
+            Start:        strings.TrimSpace(m.Start),
+            Notes:        strings.TrimSpace(m.Notes),
+        })
+    }
+
+    cards := make([]*crewCard, 0, len(order))
+    for _, key := range order {
+        c := byKey[key]
+        if cal == nil && !importWeeks(&c.raw, loc, weekStart, t.weekStartDay(), t.Defaults.WeekLabels) {
+            v.errorf("date %s is not in the two weeks from week_start %s", sub.Date, sub.WeekStart)
+            return nil, v
+        }
+        c.Request = c.raw
+        applyTimecardDefaults(&c.Request, t)
+        c.Result = validateTimecard(c.Request, t)
+        c.Valid = len(c.Result.Errors) == 0
+        cards = append(cards, c)
+    }
+    return cards, v
+}
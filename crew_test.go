@@ -0,0 +1,107 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestCrewCards(t *testing.T) {
+    withTenants(t, `{}`)
+    tenant := lookupTenant(defaultTenantID)
+    sub := CrewSubmission{Foreman: "Joe", Date: "2025-01-06", JobCode: "29699", PayPeriodNum: 3, Year: 2025, Crew: []CrewMember{
+        {EmployeeName: "Bob Smith", LabourCode: "201", Hours: 8},
+        {EmployeeName: "Ann Lee", LabourCode: "223", Hours: 8},
+        {EmployeeName: "bob smith", LabourCode: "201", Hours: 2, Overtime: true},
+    }}
+    cards, v := crewCards(sub, tenant)
+    if len(v.Errors) > 0 {
+        t.Fatal(v)
+    }
+    if len(cards) != 2 {
+        t.Fatalf("%d cards, want Bob's and Ann's", len(cards))
+    }
+    bob, ann := cards[0].raw, cards[1].raw
+    if bob.EmployeeName != "Bob Smith" || bob.PayPeriodNum != 3 || bob.Year != 2025 || ann.EmployeeName != "Ann Lee" {
+        t.Errorf("cards = %+v, %+v", bob, ann)
+    }
+    if !reflect.DeepEqual(bob.Jobs, []Job{{JobCode: "29699", JobName: "201"}}) || !reflect.DeepEqual(ann.Jobs, []Job{{JobCode: "29699", JobName: "223"}}) {
+        t.Errorf("jobs = %+v, %+v", bob.Jobs, ann.Jobs)
+    }
+    // the weeks start on the Sunday before the date
+    if len(bob.Weeks) != 2 || !strings.HasPrefix(bob.Weeks[0].WeekStartDate, "2025-01-05") {
+        t.Fatalf("weeks = %+v", bob.Weeks)
+    }
+    if got := describeEntries(bob.Weeks[0].Entries); !reflect.DeepEqual(got, []string{"Mon 29699 8", "Mon 29699 2 ot"}) {
+        t.Errorf("Bob's entries = %q", got)
+    }
+}
+
+func TestCrewCardsRejects(t *testing.T) {
+    withTenants(t, `{}`)
+    tenant := lookupTenant(defaultTenantID)
+    bob := CrewMember{EmployeeName: "Bob Smith", Hours: 8}
+    tests := []struct {
+        name string
+        sub  CrewSubmission
+        want string
+    }{
+        {"bad date", CrewSubmission{Date: "Jan 6", JobCode: "1", Crew: []CrewMember{bob}}, `date "Jan 6" is not YYYY-MM-DD`},
+        {"no job", CrewSubmission{Date: "2025-01-06", Crew: []CrewMember{bob}}, "job_code is required"},
+        {"no crew", CrewSubmission{Date: "2025-01-06", JobCode: "1"}, "crew is empty"},
+        {"no employee", CrewSubmission{Date: "2025-01-06", JobCode: "1", Crew: []CrewMember{{Hours: 8}}}, "crew member 1: no employee_name"},
+        {"no hours", CrewSubmission{Date: "2025-01-06", JobCode: "1", Crew: []CrewMember{bob, {EmployeeID: "E2"}}}, "crew member 2: hours must be positive"},
+        {"outside the weeks", CrewSubmission{Date: "2025-01-20", JobCode: "1", WeekStart: "2025-01-05", Crew: []CrewMember{bob}}, "not in the two weeks"},
+    }
+    for _, tt := range tests {
+        cards, v := crewCards(tt.sub, tenant)
+        if cards != nil || len(v.Errors) == 0 || !strings.Contains(strings.Join(v.Errors, "; "), tt.want) {
+            t.Errorf("%s: cards %+v, errors %q, want %q", tt.name, cards, v.Errors, tt.want)
+        }
+    }
+}
+
+func TestCrewHandler(t *testing.T) {
+    withTenants(t, `{}`)
+    t.Setenv("DATA_DIR", t.TempDir())
+    saved := backgroundJobs
+    backgroundJobs = openCollection[BackgroundJob]("jobs")
+    t.Cleanup(func() { backgroundJobs = saved })
+
+    const crew = `{"foreman": "Joe", "date": "2025-01-06", "job_code": "29699", "pay_period_num": 3, "year": 2025,
+        "crew": [{"employee_name": "Bob Smith", "hours": 8}, {"employee_name": "Ann Lee", "hours": 7.5}]}`
+    tests := []struct {
+        name, method, query, body string
+        want                      int
+    }{
+        {"wrong method", http.MethodGet, "", "", http.StatusMethodNotAllowed},
+        {"bad format", http.MethodPost, "?generate=docx", crew, http.StatusBadRequest},
+        {"bad JSON", http.MethodPost, "", "{", http.StatusBadRequest},
+        {"no crew", http.MethodPost, "", `{"date": "2025-01-06", "job_code": "1"}`, http.StatusBadRequest},
+        {"checked", http.MethodPost, "", crew, http.StatusOK},
+        {"generated", http.MethodPost, "?generate=pdf", crew, http.StatusAccepted},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest(tt.method, "/api/crew"+tt.query, strings.NewReader(tt.body))
+        w := httptest.NewRecorder()
+        crewHandler(w, r)
+        if w.Code != tt.want {
+            t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+        }
+    }
+
+    jobs := backgroundJobs.List()
+    if len(jobs) != 1 || jobs[0].Kind != "import-generate" {
+        t.Fatalf("jobs = %+v", jobs)
+    }
+    var p importGenerateParams
+    if err := json.Unmarshal(jobs[0].Params, &p); err != nil {
+        t.Fatal(err)
+    }
+    if p.Format != "pdf" || len(p.Cards) != 2 || p.Cards[1].EmployeeName != "Ann Lee" {
+        t.Errorf("job params = %+v", p)
+    }
+}
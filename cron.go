@@ -0,0 +1,121 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "strconv"
+    "strings"
+    "time"
+)
+
+/* ================
+   Cron expressions
+   ================ */
+
+// cronSpec is a parsed five-field cron expression: minute, hour, day of
+// month, month and day of week (0 or 7 is Sunday). Fields take *, numbers,
+// ranges (1-5), steps (*/15, 8-18/2) and comma lists. As in cron, when both
+// day fields are restricted a time matches if either does.
+type cronSpec struct {
+    minute, hour, dom, month, dow uint64
+    anyDOM, anyDOW                bool
+}
+
+func parseCron(expr string) (*cronSpec, error) {
+    fields := strings.Fields(expr)
+    if len(fields) != 5 {
+        return nil, fmt.Errorf("cron %q: want 5 fields, got %d", expr, len(fields))
+    }
+    var (
+        c   cronSpec
+        err error
+    )
+    bounds := []struct {
+        dst      *uint64
+        min, max int
+    }{
+        {&c.minute, 0, 59},
+        {&c.hour, 0, 23},
+        {&c.dom, 1, 31},
+        {&c.month, 1, 12},
+        {&c.dow, 0, 7},
+    }
+    for i, b := range bounds {
+        if *b.dst, err = parseCronField(fields[i], b.min, b.max); err != nil {
+            return nil, fmt.Errorf("cron %q: %w", expr, err)
+        }
+    }
+    if c.dow&(1<<7) != 0 {
+        c.dow |= 1
+    }
+    c.anyDOM, c.anyDOW = fields[2] == "*", fields[4] == "*"
+    return &c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+    var bits uint64
+    for _, part := range strings.Split(field, ",") {
+        rng, step := part, 1
+        if i := strings.Index(part, "/"); i >= 0 {
+            n, err := strconv.Atoi(part[i+1:])
+            if err != nil || n < 1 {
+                return 0, fmt.Errorf("bad step in %q", part)
+            }
+            rng, step = part[:i], n
+        }
+        lo, hi := min, max
+        if rng != "*" {
+            a, b, isRange := strings.Cut(rng, "-")
+            var err error
+            if lo, err = strconv.Atoi(a); err != nil {
+                return 0, fmt.Errorf("bad value %q", part)
+            }
+            hi = lo
+            if isRange {
+                if hi, err = strconv.Atoi(b); err != nil {
+                    return 0, fmt.Errorf("bad value %q", part)
+                }
+            } else if step > 1 {
+                hi = max // "5/15" means from 5 on
+            }
+        }
+        if lo < min || hi > max || lo > hi {
+            return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+        }
+        for v := lo; v <= hi; v += step {
+            bits |= 1 << v
+        }
+    }
+    return bits, nil
+}
+
+// matches reports whether t (already in the schedule's zone) is a minute
+// the expression fires on
+func (c *cronSpec) matches(t time.Time) bool {
+    if c.minute&(1<<t.Minute()) == 0 || c.hour&(1<<t.Hour()) == 0 || c.month&(1<<int(t.Month())) == 0 {
+        return false
+    }
+    dom, dow := c.dom&(1<<t.Day()) != 0, c.dow&(1<<int(t.Weekday())) != 0
+    switch {
+    case c.anyDOM && c.anyDOW:
+        return true
+    case c.anyDOM:
+        return dow
+    case c.anyDOW:
+        return dom
+    }
+    return dom || dow
+}
+
+// everyMinute calls fn at the start of each wall-clock minute until ctx ends
+func everyMinute(ctx context.Context, fn func()) {
+    for {
+        wait := time.Until(time.Now().Truncate(time.Minute).Add(time.Minute))
+        select {
+        case <-ctx.Done():
+            return
+        case <-time.After(wait):
+            fn()
+        }
+    }
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+    "expvar"
+    "net/http"
+    "net/http/pprof"
+    "runtime"
+)
+
+/* ======================
+   Runtime debug endpoints
+   ====================== */
+
+// Counters for watching buffer growth alongside heap profiles
+var (
+    statExcelGenerated = expvar.NewInt("excel_generated")
+    statExcelBytes     = expvar.NewInt("excel_bytes")
+    statPDFConverted   = expvar.NewInt("pdf_converted")
+    statPDFBytes       = expvar.NewInt("pdf_bytes")
+
+    statRenderCacheHits   = expvar.NewInt("render_cache_hits")
+    statRenderCacheMisses = expvar.NewInt("render_cache_misses")
+
+    statWebhooksSent   = expvar.NewInt("webhooks_sent")
+    statWebhooksFailed = expvar.NewInt("webhooks_failed")
+
+    statStorageFailures = expvar.NewInt("storage_failures")
+)
+
+func init() {
+    expvar.Publish("goroutines", expvar.Func(func() interface{} { return runtime.NumGoroutine() }))
+    expvar.Publish("generation_pools", expvar.Func(func() interface{} {
+        out := map[string]interface{}{}
+        for _, l := range pools().all() {
+            out[l.name] = l.stats()
+        }
+        return out
+    }))
+}
+
+// registerDebugRoutes mounts pprof and expvar under /debug, all behind
+// adminAuth. Both packages also self-register on http.DefaultServeMux,
+// which is why the server uses its own mux.
+func registerDebugRoutes(mux *http.ServeMux) {
+    guard := func(h http.HandlerFunc) http.HandlerFunc {
+        return requestIDMiddleware(adminAuth(h))
+    }
+    mux.HandleFunc("/debug/pprof/", guard(pprof.Index))
+    mux.HandleFunc("/debug/pprof/cmdline", guard(pprof.Cmdline))
+    mux.HandleFunc("/debug/pprof/profile", guard(pprof.Profile))
+    mux.HandleFunc("/debug/pprof/symbol", guard(pprof.Symbol))
+    mux.HandleFunc("/debug/pprof/trace", guard(pprof.Trace))
+    mux.HandleFunc("/debug/vars", guard(expvar.Handler().ServeHTTP))
+    mux.HandleFunc("/debug/race-harness", guard(raceHarnessHandler))
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ========
+   Delivery
+   ======== */
+
+// A Deliverer sends a rendered card somewhere outside the app: email, fax,
+// SFTP and whatever a customer insists on next. Stored cards are sent with
+//
+//   POST /api/timecards/{id}/deliver   {"channel": "fax", "to": "+15551234567"}
+//
+// and a new card is accepted and sent in one go with POST /api/deliver (the
+// card's fields plus "channel" and "to"). Either way the receipt is
+// appended to the record's deliveries.
+
+// Delivery is one rendered card on its way to one destination
+type Delivery struct {
+    To       string
+    Subject  string
+    Body     string
+    FileName string
+    Data     []byte
+    Record   TimecardRecord
+}
+
+// DeliveryTarget says where a card goes
+type DeliveryTarget struct {
+    Channel string `json:"channel"`
+    To      string `json:"to"`
+    Subject string `json:"subject"`
+    Body    string `json:"body"`
+}
+
+// DeliverTimecardRequest is the body of POST /api/deliver
+type DeliverTimecardRequest struct {
+    TimecardRequest
+    DeliveryTarget
+}
+
+// DeliveryReceipt is what the channel reported back
+type DeliveryReceipt struct {
+    Channel    string    `json:"channel"`
+    To         string    `json:"to"`
+    Provider   string    `json:"provider,omitempty"`
+    ProviderID string    `json:"provider_id,omitempty"` // e.g. the fax transmission id
+    Status     string    `json:"status"`                // sent, queued or failed
+    Error      string    `json:"error,omitempty"`
+    RequestID  string    `json:"request_id,omitempty"`
+    At         time.Time `json:"at"`
+}
+
+type Deliverer interface {
+    // Format is the file the channel sends: "xlsx" or "pdf"
+    Format() string
+    // CheckAddress rejects destinations the channel can't send to
+    CheckAddress(to string) error
+    // Deliver sends d, returning a receipt without Channel, To or At set
+    Deliver(ctx context.Context, d Delivery) (DeliveryReceipt, error)
+}
+
+var (
+    deliverersMu sync.RWMutex
+    deliverers   = map[string]Deliverer{}
+)
+
+// registerDeliverer makes a channel available to the deliver endpoint
+func registerDeliverer(channel string, d Deliverer) {
+    deliverersMu.Lock()
+    defer deliverersMu.Unlock()
+    deliverers[channel] = d
+}
+
+func delivererFor(channel string) (Deliverer, bool) {
+    deliverersMu.RLock()
+    defer deliverersMu.RUnlock()
+    d, ok := deliverers[channel]
+    return d, ok
+}
+
+func deliveryChannels() []string {
+    deliverersMu.RLock()
+    defer deliverersMu.RUnlock()
+    out := make([]string, 0, len(deliverers))
+    for name := range deliverers {
+        out = append(out, name)
+    }
+    sort.Strings(out)
+    return out
+}
+
+// emailDeliverer sends the workbook, as the email endpoint does
+type emailDeliverer struct{}
+
+func (emailDeliverer) Format() string { return "xlsx" }
+
+func (emailDeliverer) CheckAddress(to string) error {
+    for _, addr := range strings.Split(to, ",") {
+        if !emailPattern.MatchString(strings.TrimSpace(addr)) {
+            return fmt.Errorf("invalid email address %q", strings.TrimSpace(addr))
+        }
+    }
+    return nil
+}
+
+func (emailDeliverer) Deliver(ctx context.Context, d Delivery) (DeliveryReceipt, error) {
+    if err := sendEmail(ctx, d.To, nil, d.Subject, d.Body, d.Data, d.FileName, loggerFrom(ctx)); err != nil {
+        return DeliveryReceipt{}, err
+    }
+    return DeliveryReceipt{Provider: "smtp", Status: "sent"}, nil
+}
+
+func init() {
+    registerDeliverer("email", emailDeliverer{})
+}
+
+// deliverer finds t's channel and checks its address
+func (t DeliveryTarget) deliverer() (Deliverer, error) {
+    d, ok := delivererFor(t.Channel)
+    if !ok {
+        return nil, fmt.Errorf("channel must be one of %s", strings.Join(deliveryChannels(), ", "))
+    }
+    if err := d.CheckAddress(t.To); err != nil {
+        return nil, err
+    }
+    return d, nil
+}
+
+// deliverTimecard sends a stored record
+func deliverTimecard(w http.ResponseWriter, r *http.Request, id string) {
+    var target DeliveryTarget
+    if err := json.NewDecoder(r.Body).Decode(&target); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    d, err := target.deliverer()
+    if err != nil {
+        httpError(w, r, err.Error(), http.StatusBadRequest)
+        return
+    }
+    rec, ok := timecards.Get(id)
+    if !ok {
+        httpError(w, r, "timecard not found", http.StatusNotFound)
+        return
+    }
+    if receipt, ok := deliverRecord(w, r, rec, d, target); ok {
+        writeJSON(w, http.StatusOK, receipt)
+    }
+}
+
+// deliverHandler accepts a card like the generate endpoints, keeps it and
+// sends it to the channel
+func deliverHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    lg := loggerFrom(r.Context())
+
+    var req DeliverTimecardRequest
+    payload, err := readPayload(r, &req)
+    if err != nil {
+        lg.Printf("decode error: %v", err)
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    d, err := req.DeliveryTarget.deliverer()
+    if err != nil {
+        httpError(w, r, err.Error(), http.StatusBadRequest)
+        return
+    }
+    logEntries(lg, req.TimecardRequest)
+
+    claim, ok := claimIdempotencyKey(w, r, payload)
+    if !ok {
+        return
+    }
+    defer claim.release()
+
+    applyTimecardDefaults(&req.TimecardRequest, tenantFor(r))
+    validation, ok := checkTimecard(w, r, req.TimecardRequest)
+    if !ok {
+        return
+    }
+    id := saveTimecardRecord(r, "delivery", payload, req.TimecardRequest)
+    rec, ok := timecards.Get(id)
+    if !ok {
+        generationFailed(w, r, "error keeping timecard", fmt.Errorf("record was not saved"))
+        return
+    }
+    receipt, ok := deliverRecord(w, r, rec, d, req.DeliveryTarget)
+    if !ok {
+        return
+    }
+    resp := map[string]interface{}{
+        "timecard_id": id,
+        "delivery":    receipt,
+    }
+    if len(validation.Warnings) > 0 {
+        resp["warnings"] = validation.Warnings
+    }
+    claim.complete(http.StatusOK, resp)
+    writeJSON(w, http.StatusOK, resp)
+}
+
+// deliverRecord renders rec in the channel's format, sends it and keeps
+// the receipt, failed or not. On failure the error response is written
+// and ok is false.
+func deliverRecord(w http.ResponseWriter, r *http.Request, rec TimecardRecord, d Deliverer, target DeliveryTarget) (receipt DeliveryReceipt, ok bool) {
+    lg := loggerFrom(r.Context())
+    name, data, err := renderRecord(r.Context(), rec, d.Format())
+    if err != nil {
+        generationFailed(w, r, "error rendering timecard", err)
+        return receipt, false
+    }
+    subject := target.Subject
+    if subject == "" {
+        subject = fmt.Sprintf("Timecard - %s - PP%d %d", rec.Employee, rec.PayPeriodNum, rec.Year)
+    }
+    receipt, err = d.Deliver(r.Context(), Delivery{
+        To:       target.To,
+        Subject:  subject,
+        Body:     target.Body,
+        FileName: name[strings.LastIndex(name, "/")+1:],
+        Data:     data,
+        Record:   rec,
+    })
+    receipt.Channel, receipt.To, receipt.At = target.Channel, target.To, now().UTC()
+    receipt.RequestID = requestIDFrom(r.Context())
+    if err != nil {
+        receipt.Status, receipt.Error = "failed", err.Error()
+    }
+
+    // The record may have changed while we were sending
+    if cur, ok := timecards.Get(rec.ID); ok {
+        rec = cur
+    }
+    rec.Deliveries = append(rec.Deliveries, receipt)
+    if perr := timecards.Put(rec.ID, rec); perr != nil {
+        lg.Printf("Warning: could not record delivery receipt: %v", perr)
+    }
+
+    if err != nil {
+        lg.Printf("%s delivery error: %v", target.Channel, err)
+        generationFailed(w, r, fmt.Sprintf("error sending %s", target.Channel), err)
+        return receipt, false
+    }
+    lg.Printf("Delivered timecard %s by %s (%s)", rec.ID, target.Channel, receipt.Status)
+    return receipt, true
+}
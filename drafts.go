@@ -0,0 +1,184 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+)
+
+/* ======
+   Drafts
+   ====== */
+
+// A draft is a week the app has started but not sent, kept on the server so
+// it can be picked up on another device:
+//
+//   GET    /api/drafts?employee=&employee_id=
+//   POST   /api/drafts                  {"device": "...", "request": {...}}
+//   GET    /api/drafts/{id}
+//   PUT    /api/drafts/{id}             {"version": 3, "request": {...}}
+//   DELETE /api/drafts/{id}
+//   POST   /api/drafts/{id}/generate?format=xlsx|pdf
+//
+// The request is a generation request as far as it has been filled in and is
+// not validated until it is generated. A PUT must carry the version it was
+// based on; if another device saved in between, it gets 409 and the current
+// draft to merge with. Generating runs the request through
+// /api/generate-timecard (or /api/generate-pdf) and, when that succeeds,
+// removes the draft.
+
+// Draft is a partially entered card
+type Draft struct {
+    ID           string          `json:"id"`
+    Tenant       string          `json:"tenant"`
+    Employee     string          `json:"employee,omitempty"`
+    EmployeeID   string          `json:"employee_id,omitempty"`
+    PayPeriodNum int             `json:"pay_period_num,omitempty"`
+    Year         int             `json:"year,omitempty"`
+    Device       string          `json:"device,omitempty"` // the device that saved it last
+    Version      int             `json:"version"`
+    Request      json.RawMessage `json:"request"`
+    CreatedAt    time.Time       `json:"created_at"`
+    UpdatedAt    time.Time       `json:"updated_at"`
+}
+
+var drafts = openCollection[Draft]("drafts")
+
+// draftsHandler serves /api/drafts (see the top of this file)
+func draftsHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/drafts"), "/")
+    parts := strings.Split(rest, "/")
+    tenant := tenantFor(r).ID
+
+    var d Draft
+    if rest != "" {
+        var ok bool
+        if d, ok = drafts.Get(parts[0]); !ok || d.Tenant != tenant {
+            httpError(w, r, "draft not found", http.StatusNotFound)
+            return
+        }
+    }
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        listDrafts(w, r, tenant)
+    case rest == "" && r.Method == http.MethodPost:
+        saveDraft(w, r, Draft{ID: newID(), Tenant: tenant, CreatedAt: now().UTC()})
+    case len(parts) == 1 && r.Method == http.MethodGet:
+        writeJSON(w, http.StatusOK, d)
+    case len(parts) == 1 && r.Method == http.MethodPut:
+        saveDraft(w, r, d)
+    case len(parts) == 1 && r.Method == http.MethodDelete:
+        if err := drafts.Delete(d.ID); err != nil {
+            httpError(w, r, fmt.Sprintf("error deleting draft: %v", err), http.StatusInternalServerError)
+            return
+        }
+        w.WriteHeader(http.StatusNoContent)
+    case len(parts) == 2 && parts[1] == "generate" && r.Method == http.MethodPost:
+        generateDraft(w, r, d)
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func listDrafts(w http.ResponseWriter, r *http.Request, tenant string) {
+    q := r.URL.Query()
+    employee, employeeID := q.Get("employee"), q.Get("employee_id")
+
+    out := []Draft{}
+    for _, d := range drafts.List() {
+        if d.Tenant != tenant {
+            continue
+        }
+        if employee != "" && !strings.EqualFold(d.Employee, employee) {
+            continue
+        }
+        if employeeID != "" && d.EmployeeID != employeeID {
+            continue
+        }
+        d.Request = nil // fetch one draft for its request
+        out = append(out, d)
+    }
+    sort.Slice(out, func(a, b int) bool { return out[a].UpdatedAt.After(out[b].UpdatedAt) })
+    writeJSON(w, http.StatusOK, out)
+}
+
+// saveDraft stores the body over d, which is new when its Version is 0
+func saveDraft(w http.ResponseWriter, r *http.Request, d Draft) {
+    var body struct {
+        Device  string          `json:"device"`
+        Version int             `json:"version"`
+        Request json.RawMessage `json:"request"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if len(body.Request) == 0 {
+        httpError(w, r, "invalid request: request is required", http.StatusBadRequest)
+        return
+    }
+    var req TimecardRequest
+    if err := json.Unmarshal(body.Request, &req); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if d.Version > 0 && body.Version != d.Version {
+        loggerFrom(r.Context()).Printf("Draft %s: version %d is stale (now %d)", d.ID, body.Version, d.Version)
+        writeJSON(w, http.StatusConflict, map[string]interface{}{
+            "error": fmt.Sprintf("draft was saved by another device (version %d)", d.Version),
+            "draft": d,
+        })
+        return
+    }
+
+    status := http.StatusOK
+    if d.Version == 0 {
+        status = http.StatusCreated
+    }
+    d.Employee, d.EmployeeID = req.EmployeeName, req.EmployeeID
+    d.PayPeriodNum, d.Year = req.PayPeriodNum, req.Year
+    d.Device = body.Device
+    d.Request = body.Request
+    d.Version++
+    d.UpdatedAt = now().UTC()
+    if err := drafts.Put(d.ID, d); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving draft: %v", err), http.StatusInternalServerError)
+        return
+    }
+    loggerFrom(r.Context()).Printf("Draft %s saved for %s (version %d)", d.ID, d.Employee, d.Version)
+    writeJSON(w, status, d)
+}
+
+// generateDraft sends the draft's request through the generate endpoint and
+// drops the draft once it has become a card
+func generateDraft(w http.ResponseWriter, r *http.Request, d Draft) {
+    handler := generateTimecardHandler
+    switch r.URL.Query().Get("format") {
+    case "", "xlsx":
+    case "pdf":
+        handler = generatePDFHandler
+    default:
+        httpError(w, r, "format must be xlsx or pdf", http.StatusBadRequest)
+        return
+    }
+
+    gen := r.Clone(r.Context())
+    gen.Body = io.NopCloser(bytes.NewReader(d.Request))
+    gen.ContentLength = int64(len(d.Request))
+    rec := &statusRecorder{ResponseWriter: w}
+    handler(rec, gen)
+    if rec.status != http.StatusOK {
+        return
+    }
+    if err := drafts.Delete(d.ID); err != nil {
+        loggerFrom(r.Context()).Printf("Warning: could not remove generated draft %s: %v", d.ID, err)
+        return
+    }
+    loggerFrom(r.Context()).Printf("Draft %s generated as timecard %s", d.ID, w.Header().Get("X-Timecard-ID"))
+}
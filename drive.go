@@ -0,0 +1,205 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "mime/multipart"
+    "net/http"
+    "net/textproto"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ============
+   Google Drive
+   ============ */
+
+// With DRIVE_FOLDER_ID set, Drive is one of the artifact stores: every
+// stored file is uploaded under <year>/PP<nn>/<employee>/ inside that
+// folder, and its Drive link is returned to the client
+// (X-Timecard-Drive-Link, or "drive_link" in JSON responses). The service
+// account (GOOGLE_APPLICATION_CREDENTIALS) needs editor access to the
+// folder; a tenant can point at its own folder with "drive_folder_id".
+
+const driveFolderType = "application/vnd.google-apps.folder"
+
+type driveClient struct {
+    tokens    *googleTokenSource
+    apiURL    string
+    uploadURL string
+    client    *http.Client
+
+    mu      sync.Mutex        // serializes folder lookups so concurrent uploads don't create twins
+    folders map[string]string // "<parent id>/<name>" → folder id
+}
+
+var (
+    driveOnce sync.Once
+    theDrive  *driveClient
+)
+
+// drive returns the Drive client, or nil when Drive isn't configured
+func drive() *driveClient {
+    driveOnce.Do(func() {
+        if os.Getenv("DRIVE_FOLDER_ID") == "" && !anyTenant(func(t *Tenant) bool { return t.DriveFolderID != "" }) {
+            return
+        }
+        tokens, err := newGoogleTokenSource(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), "https://www.googleapis.com/auth/drive")
+        if err != nil {
+            log.Printf("Warning: Drive upload disabled: %v", err)
+            return
+        }
+        theDrive = &driveClient{
+            tokens:    tokens,
+            apiURL:    envOr("DRIVE_API_URL", "https://www.googleapis.com/drive/v3"),
+            uploadURL: envOr("DRIVE_UPLOAD_URL", "https://www.googleapis.com/upload/drive/v3"),
+            client:    &http.Client{Timeout: 60 * time.Second},
+            folders:   map[string]string{},
+        }
+    })
+    return theDrive
+}
+
+func (d *driveClient) Name() string { return "drive" }
+
+// Put uploads obj into <year>/PP<nn>/<employee>/ under the tenant's folder
+func (d *driveClient) Put(ctx context.Context, obj StoredObject) (StoredCopy, error) {
+    root := obj.Tenant.DriveFolderID
+    if root == "" {
+        root = os.Getenv("DRIVE_FOLDER_ID")
+    }
+    if root == "" {
+        return StoredCopy{}, nil
+    }
+    parent := root
+    for _, folder := range obj.Folders {
+        id, err := d.folder(ctx, parent, folder)
+        if err != nil {
+            return StoredCopy{}, err
+        }
+        parent = id
+    }
+    id, link, err := d.upload(ctx, parent, obj.Name, obj.Data)
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    return StoredCopy{Store: "drive", Location: "drive:" + id, Link: link, At: now().UTC()}, nil
+}
+
+// folder finds or creates the folder name inside parent
+func (d *driveClient) folder(ctx context.Context, parent, name string) (string, error) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    cacheKey := parent + "/" + name
+    if id, ok := d.folders[cacheKey]; ok {
+        return id, nil
+    }
+
+    q := fmt.Sprintf("name = '%s' and '%s' in parents and mimeType = '%s' and trashed = false",
+        driveQuote(name), driveQuote(parent), driveFolderType)
+    params := url.Values{
+        "q":                         {q},
+        "fields":                    {"files(id)"},
+        "supportsAllDrives":         {"true"},
+        "includeItemsFromAllDrives": {"true"},
+    }
+    var found struct {
+        Files []struct {
+            ID string `json:"id"`
+        } `json:"files"`
+    }
+    if err := d.call(ctx, http.MethodGet, d.apiURL+"/files?"+params.Encode(), nil, "", &found); err != nil {
+        return "", err
+    }
+    if len(found.Files) > 0 {
+        d.folders[cacheKey] = found.Files[0].ID
+        return found.Files[0].ID, nil
+    }
+
+    meta, _ := json.Marshal(map[string]interface{}{"name": name, "mimeType": driveFolderType, "parents": []string{parent}})
+    var created struct {
+        ID string `json:"id"`
+    }
+    if err := d.call(ctx, http.MethodPost, d.apiURL+"/files?supportsAllDrives=true&fields=id", bytes.NewReader(meta), "application/json", &created); err != nil {
+        return "", err
+    }
+    d.folders[cacheKey] = created.ID
+    return created.ID, nil
+}
+
+// upload creates the file in parent (a multipart upload: metadata, then content)
+func (d *driveClient) upload(ctx context.Context, parent, name string, data []byte) (id, link string, err error) {
+    var body bytes.Buffer
+    mw := multipart.NewWriter(&body)
+    meta, _ := json.Marshal(map[string]interface{}{"name": name, "parents": []string{parent}})
+    part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+    if err != nil {
+        return "", "", err
+    }
+    _, _ = part.Write(meta)
+    part, err = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {attachmentContentType(name)}})
+    if err != nil {
+        return "", "", err
+    }
+    _, _ = part.Write(data)
+    if err := mw.Close(); err != nil {
+        return "", "", err
+    }
+
+    var out struct {
+        ID          string `json:"id"`
+        WebViewLink string `json:"webViewLink"`
+    }
+    endpoint := d.uploadURL + "/files?uploadType=multipart&supportsAllDrives=true&fields=id,webViewLink"
+    if err := d.call(ctx, http.MethodPost, endpoint, &body, "multipart/related; boundary="+mw.Boundary(), &out); err != nil {
+        return "", "", err
+    }
+    return out.ID, out.WebViewLink, nil
+}
+
+// call makes an authorized Drive request and decodes the JSON answer into out
+func (d *driveClient) call(ctx context.Context, method, endpoint string, body io.Reader, contentType string, out interface{}) error {
+    token, err := d.tokens.Token(ctx)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, method, endpoint, body)
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Bearer "+token)
+    if contentType != "" {
+        req.Header.Set("Content-Type", contentType)
+    }
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode >= 300 {
+        var apiErr struct {
+            Error struct {
+                Message string `json:"message"`
+            } `json:"error"`
+        }
+        _ = json.Unmarshal(data, &apiErr)
+        return fmt.Errorf("drive: %s %s", resp.Status, apiErr.Error.Message)
+    }
+    return json.Unmarshal(data, out)
+}
+
+// driveQuote escapes a value for a single-quoted Drive query string
+func driveQuote(s string) string {
+    return strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s)
+}
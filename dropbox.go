@@ -0,0 +1,187 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* =======
+   Dropbox
+   ======= */
+
+// Small contractors who keep their records in Dropbox get every stored file
+// uploaded there. The app's credentials (DROPBOX_APP_KEY,
+// DROPBOX_APP_SECRET) are shared; each account is reached through a
+// long-lived refresh token from the app's offline OAuth flow. A tenant opts
+// in with
+//
+//   "dropbox": {"refresh_token": "...", "path": "/Timecards/{year}/PP{pp}/{employee}/{file}"}
+//
+// where path is an expandLayout template (that one is the default). With
+// DROPBOX_REFRESH_TOKEN (and optionally DROPBOX_PATH) set, tenants without
+// their own settings upload to that account. Existing files are never
+// replaced.
+
+// DropboxTarget is the account and layout a tenant's files go to
+type DropboxTarget struct {
+    RefreshToken string `json:"refresh_token"`
+    Path         string `json:"path,omitempty"`
+}
+
+const defaultDropboxPath = "/Timecards/{year}/PP{pp}/{employee}/{file}"
+
+type dropboxToken struct {
+    token   string
+    expires time.Time
+}
+
+type dropboxStore struct {
+    appKey, appSecret string
+    apiURL, uploadURL string
+    client            *http.Client
+
+    mu     sync.Mutex
+    tokens map[string]dropboxToken // by refresh token
+}
+
+var (
+    dropboxOnce sync.Once
+    theDropbox  *dropboxStore
+)
+
+// dropbox returns the Dropbox store, or nil when it isn't configured
+func dropbox() *dropboxStore {
+    dropboxOnce.Do(func() {
+        if os.Getenv("DROPBOX_REFRESH_TOKEN") == "" && !anyTenant(func(t *Tenant) bool { return t.Dropbox != nil }) {
+            return
+        }
+        if os.Getenv("DROPBOX_APP_KEY") == "" || os.Getenv("DROPBOX_APP_SECRET") == "" {
+            log.Printf("Warning: Dropbox upload disabled: DROPBOX_APP_KEY and DROPBOX_APP_SECRET are required")
+            return
+        }
+        theDropbox = &dropboxStore{
+            appKey:    os.Getenv("DROPBOX_APP_KEY"),
+            appSecret: os.Getenv("DROPBOX_APP_SECRET"),
+            apiURL:    strings.TrimSuffix(envOr("DROPBOX_API_URL", "https://api.dropboxapi.com"), "/"),
+            uploadURL: strings.TrimSuffix(envOr("DROPBOX_UPLOAD_URL", "https://content.dropboxapi.com"), "/"),
+            client:    &http.Client{Timeout: 60 * time.Second},
+            tokens:    map[string]dropboxToken{},
+        }
+    })
+    return theDropbox
+}
+
+func (d *dropboxStore) Name() string { return "dropbox" }
+
+func (d *dropboxStore) Put(ctx context.Context, obj StoredObject) (StoredCopy, error) {
+    target := DropboxTarget{RefreshToken: os.Getenv("DROPBOX_REFRESH_TOKEN"), Path: os.Getenv("DROPBOX_PATH")}
+    if t := obj.Tenant.Dropbox; t != nil {
+        target = *t
+    }
+    if target.RefreshToken == "" {
+        return StoredCopy{}, nil
+    }
+    if target.Path == "" {
+        target.Path = defaultDropboxPath
+    }
+    p := "/" + strings.TrimLeft(expandLayout(target.Path, obj.Record, obj.Name), "/")
+
+    token, err := d.token(ctx, target.RefreshToken)
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    arg, _ := json.Marshal(map[string]interface{}{"path": p, "mode": "add", "autorename": false, "mute": true})
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.uploadURL+"/2/files/upload", bytes.NewReader(obj.Data))
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    req.Header.Set("Authorization", "Bearer "+token)
+    req.Header.Set("Content-Type", "application/octet-stream")
+    req.Header.Set("Dropbox-API-Arg", asciiJSON(arg))
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+    if resp.StatusCode >= 300 {
+        var apiErr struct {
+            Summary string `json:"error_summary"`
+        }
+        if json.Unmarshal(body, &apiErr) != nil || apiErr.Summary == "" {
+            apiErr.Summary = strings.TrimSpace(string(body))
+        }
+        return StoredCopy{}, fmt.Errorf("dropbox: %s %s", resp.Status, apiErr.Summary)
+    }
+    var out struct {
+        ID          string `json:"id"`
+        PathDisplay string `json:"path_display"`
+    }
+    if err := json.Unmarshal(body, &out); err != nil {
+        return StoredCopy{}, fmt.Errorf("dropbox: %w", err)
+    }
+    return StoredCopy{Store: "dropbox", Location: "dropbox:" + out.PathDisplay, At: now().UTC()}, nil
+}
+
+// token returns a cached access token for the account behind refresh
+func (d *dropboxStore) token(ctx context.Context, refresh string) (string, error) {
+    d.mu.Lock()
+    defer d.mu.Unlock()
+    if t, ok := d.tokens[refresh]; ok && time.Now().Before(t.expires) {
+        return t.token, nil
+    }
+    form := url.Values{"grant_type": {"refresh_token"}, "refresh_token": {refresh}}
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.apiURL+"/oauth2/token", strings.NewReader(form.Encode()))
+    if err != nil {
+        return "", err
+    }
+    req.SetBasicAuth(d.appKey, d.appSecret)
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return "", fmt.Errorf("dropbox token: %w", err)
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+    var out struct {
+        AccessToken string `json:"access_token"`
+        ExpiresIn   int    `json:"expires_in"`
+        Error       string `json:"error_description"`
+    }
+    if err := json.Unmarshal(body, &out); err != nil || out.AccessToken == "" {
+        return "", fmt.Errorf("dropbox token: %s %s", resp.Status, strings.TrimSpace(out.Error))
+    }
+    d.tokens[refresh] = dropboxToken{
+        token:   out.AccessToken,
+        expires: time.Now().Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute),
+    }
+    return out.AccessToken, nil
+}
+
+// asciiJSON escapes non-ASCII characters in JSON meant for an HTTP header,
+// as Dropbox-API-Arg requires
+func asciiJSON(data []byte) string {
+    var b strings.Builder
+    for _, r := range string(data) {
+        switch {
+        case r < 0x80:
+            b.WriteRune(r)
+        case r > 0xFFFF:
+            r -= 0x10000
+            fmt.Fprintf(&b, `\u%04x\u%04x`, 0xD800+(r>>10), 0xDC00+(r&0x3FF))
+        default:
+            fmt.Fprintf(&b, `\u%04x`, r)
+        }
+    }
+    return b.String()
+}
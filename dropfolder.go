@@ -0,0 +1,284 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "encoding/json"
+    "flag"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "syscall"
+    "time"
+)
+
+/* ===========
+   Drop folder
+   =========== */
+
+// For on-prem batch integration the binary can watch a folder:
+//
+//   timecard watch -in /srv/timecards/in -out /srv/timecards/out [-format xlsx|pdf|both]
+//
+// Every .json file dropped in -in is a generation request and every .csv
+// file a CSV import (import.go) of one or more cards; both get the tenant's
+// defaults and validation like the API. The cards are written to -out as
+// <file>.xlsx (a CSV's as <file>_<employee>_PP<nn>.xlsx) and the input is
+// moved to -done. A file that fails to parse, validate or render is moved
+// to -errors with <file>.error.txt beside it saying why; a CSV is all or
+// nothing. Files are picked up once they have not changed for -settle, so
+// a half-copied file is left alone, and names starting with "." are
+// ignored. -once processes what is there and exits. An input that can't be
+// moved out of -in is not processed again until it changes.
+// The folders default to WATCH_DIR, WATCH_OUT_DIR, WATCH_DONE_DIR and
+// WATCH_ERROR_DIR.
+
+type dropFolder struct {
+    in, out, done, errs string
+    formats             []string
+    tenant              *Tenant
+    settle              time.Duration
+
+    stuck map[string]time.Time // inputs that couldn't be moved, by name, as of their mod time
+}
+
+func watchCommand(args []string) error {
+    fs := flag.NewFlagSet("watch", flag.ContinueOnError)
+    in := fs.String("in", os.Getenv("WATCH_DIR"), "folder to watch")
+    out := fs.String("out", os.Getenv("WATCH_OUT_DIR"), "folder for the cards (default <in>/out)")
+    done := fs.String("done", os.Getenv("WATCH_DONE_DIR"), "folder for processed inputs (default <in>/done)")
+    errs := fs.String("errors", os.Getenv("WATCH_ERROR_DIR"), "folder for failed inputs (default <in>/errors)")
+    format := fs.String("format", envOr("WATCH_FORMAT", "xlsx"), "xlsx, pdf or both")
+    tenantID := fs.String("tenant", defaultTenantID, "tenant whose defaults apply")
+    interval := fs.Duration("interval", envDuration("WATCH_INTERVAL", 5*time.Second), "how often to look for files")
+    settle := fs.Duration("settle", 2*time.Second, "how long a file must be unchanged before it is read")
+    once := fs.Bool("once", false, "process the files there now and exit")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
+    if *in == "" {
+        return fmt.Errorf("-in (or WATCH_DIR) is required")
+    }
+    d := &dropFolder{in: *in, out: *out, done: *done, errs: *errs, settle: *settle}
+    for _, dir := range []struct {
+        dst  *string
+        name string
+    }{{&d.out, "out"}, {&d.done, "done"}, {&d.errs, "errors"}} {
+        if *dir.dst == "" {
+            *dir.dst = filepath.Join(d.in, dir.name)
+        }
+    }
+    switch *format {
+    case "xlsx", "pdf":
+        d.formats = []string{*format}
+    case "both":
+        d.formats = []string{"xlsx", "pdf"}
+    default:
+        return fmt.Errorf("-format must be xlsx, pdf or both")
+    }
+    t, err := commandTenant(*tenantID)
+    if err != nil {
+        return err
+    }
+    d.tenant = t
+    for _, dir := range []string{d.in, d.out, d.done, d.errs} {
+        if err := os.MkdirAll(dir, 0o755); err != nil {
+            return err
+        }
+    }
+
+    ctx, stop := commandContext()
+    defer stop()
+    if *once {
+        d.settle = 0
+        d.scan(ctx)
+        return nil
+    }
+    log.Printf("Watching %s for timecard requests (every %s, output in %s)", d.in, *interval, d.out)
+    tick := time.NewTicker(*interval)
+    defer tick.Stop()
+    for {
+        d.scan(ctx)
+        select {
+        case <-ctx.Done():
+            log.Printf("Stopped watching %s", d.in)
+            return nil
+        case <-tick.C:
+        }
+    }
+}
+
+// scan processes the settled files in the watched folder, oldest first
+func (d *dropFolder) scan(ctx context.Context) {
+    entries, err := os.ReadDir(d.in)
+    if err != nil {
+        log.Printf("Warning: reading %s: %v", d.in, err)
+        return
+    }
+    type pending struct {
+        name string
+        mod  time.Time
+    }
+    var files []pending
+    for _, e := range entries {
+        name := e.Name()
+        ext := strings.ToLower(filepath.Ext(name))
+        if e.IsDir() || strings.HasPrefix(name, ".") || (ext != ".json" && ext != ".csv") {
+            continue
+        }
+        info, err := e.Info()
+        if err != nil || time.Since(info.ModTime()) < d.settle || d.stuck[name].Equal(info.ModTime()) {
+            continue
+        }
+        files = append(files, pending{name, info.ModTime()})
+    }
+    sort.Slice(files, func(a, b int) bool { return files[a].mod.Before(files[b].mod) })
+    for _, f := range files {
+        if ctx.Err() != nil {
+            return
+        }
+        d.process(ctx, f.name)
+    }
+}
+
+// process turns one dropped file into cards and moves it out of the way
+func (d *dropFolder) process(ctx context.Context, name string) {
+    path := filepath.Join(d.in, name)
+    data, err := os.ReadFile(path)
+    if err == nil {
+        var written []string
+        written, err = d.render(ctx, name, data)
+        if err == nil {
+            log.Printf("Drop folder: %s -> %s", name, strings.Join(written, ", "))
+            d.move(path, d.done)
+            return
+        }
+        for _, f := range written {
+            _ = os.Remove(filepath.Join(d.out, f))
+        }
+    }
+    if ctx.Err() != nil {
+        return // interrupted; try again next time
+    }
+    log.Printf("Drop folder: %s failed: %v", name, err)
+    if dst := d.move(path, d.errs); dst != "" {
+        reason := fmt.Sprintf("%s failed at %s:\n\n%s\n", name, now().Format(time.RFC3339), err)
+        if werr := os.WriteFile(dst+".error.txt", []byte(reason), 0o644); werr != nil {
+            log.Printf("Warning: writing reason for %s: %v", name, werr)
+        }
+    }
+}
+
+// render writes the cards in a dropped file to the output folder and
+// returns the names written
+func (d *dropFolder) render(ctx context.Context, name string, data []byte) ([]string, error) {
+    base := strings.TrimSuffix(name, filepath.Ext(name))
+    var reqs []TimecardRequest
+    var names []string
+    if strings.EqualFold(filepath.Ext(name), ".csv") {
+        cards, rowErrs, err := parseImportCSV(string(data), d.tenant, 0, 0, time.Time{})
+        if err != nil {
+            return nil, fmt.Errorf("invalid CSV: %w", err)
+        }
+        var problems []string
+        for _, re := range rowErrs {
+            problems = append(problems, fmt.Sprintf("row %d: %s", re.Row, re.Error))
+        }
+        for _, c := range cards {
+            for _, e := range c.Result.Errors {
+                problems = append(problems, fmt.Sprintf("%s (rows %v): %s", c.Request.EmployeeName, c.Rows, e))
+            }
+            reqs = append(reqs, c.Request)
+            names = append(names, fmt.Sprintf("%s_%s_PP%02d", base, strings.ReplaceAll(c.Request.EmployeeName, " ", "_"), c.Request.PayPeriodNum))
+        }
+        if len(problems) > 0 {
+            return nil, fmt.Errorf("%s", strings.Join(problems, "\n"))
+        }
+        if len(reqs) == 0 {
+            return nil, fmt.Errorf("no entries")
+        }
+    } else {
+        var req TimecardRequest
+        if err := json.Unmarshal(data, &req); err != nil {
+            return nil, fmt.Errorf("invalid request: %w", err)
+        }
+        applyTimecardDefaults(&req, d.tenant)
+        if v := validateTimecard(req, d.tenant); len(v.Errors) > 0 {
+            return nil, v
+        }
+        reqs, names = []TimecardRequest{req}, []string{base}
+    }
+
+    var written []string
+    for i, req := range reqs {
+        excel, err := generateExcelFile(newGenContext(ctx, req, d.tenant, nil))
+        if err != nil {
+            return written, fmt.Errorf("%s: %w", req.EmployeeName, err)
+        }
+        for _, format := range d.formats {
+            out := excel
+            if format == "pdf" {
+                if out, err = generatePDFFromExcel(ctx, excel, names[i]+".xlsx", req.pdfOptions(d.tenant), nil); err != nil {
+                    return written, fmt.Errorf("%s: %w", req.EmployeeName, err)
+                }
+            }
+            file := names[i] + "." + format
+            if err := writeFileAtomic(filepath.Join(d.out, file), out); err != nil {
+                return written, err
+            }
+            written = append(written, file)
+        }
+    }
+    return written, nil
+}
+
+// move puts path in dir, under a new name if one is taken, and returns
+// where it went
+func (d *dropFolder) move(path, dir string) string {
+    dst := filepath.Join(dir, filepath.Base(path))
+    if _, err := os.Stat(dst); err == nil {
+        ext := filepath.Ext(dst)
+        dst = fmt.Sprintf("%s_%s%s", strings.TrimSuffix(dst, ext), now().Format("20060102T150405"), ext)
+    }
+    err := os.Rename(path, dst)
+    if errors.Is(err, syscall.EXDEV) {
+        // dir is on another filesystem
+        err = copyAndRemove(path, dst)
+    }
+    if err != nil {
+        log.Printf("Warning: could not move %s to %s: %v", path, dir, err)
+        if info, serr := os.Stat(path); serr == nil {
+            if d.stuck == nil {
+                d.stuck = map[string]time.Time{}
+            }
+            d.stuck[filepath.Base(path)] = info.ModTime()
+        }
+        return ""
+    }
+    return dst
+}
+
+// copyAndRemove moves path to dst where a rename can't
+func copyAndRemove(path, dst string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    if err := writeFileAtomic(dst, data); err != nil {
+        return err
+    }
+    return os.Remove(path)
+}
+
+// writeFileAtomic writes through a temporary file so readers of the
+// output folder never see a partial card
+func writeFileAtomic(path string, data []byte) error {
+    tmp := path + ".part"
+    if err := os.WriteFile(tmp, data, 0o644); err != nil {
+        return err
+    }
+    return os.Rename(tmp, path)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+    "context"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+func TestDropFolderStuckInput(t *testing.T) {
+    in := t.TempDir()
+    d := &dropFolder{in: in, out: t.TempDir(), done: filepath.Join(in, "missing", "done"), errs: t.TempDir(),
+        formats: []string{"xlsx"}, tenant: &Tenant{ID: defaultTenantID}}
+    card := `{"employee_name": "Bob Smith", "pay_period_num": 1, "year": 2025,
+        "weeks": [{"week_start_date": "2025-01-05T00:00:00Z", "entries": [{"date": "2025-01-06T00:00:00Z", "job_code": "29699", "hours": 8}]}]}`
+    if err := os.WriteFile(filepath.Join(in, "card.json"), []byte(card), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    out := filepath.Join(d.out, "card.xlsx")
+
+    // done can't be reached: the card is written once, not on every scan
+    d.scan(context.Background())
+    if _, err := os.Stat(out); err != nil {
+        t.Fatalf("not rendered: %v", err)
+    }
+    _ = os.Remove(out)
+    d.scan(context.Background())
+    if _, err := os.Stat(out); err == nil {
+        t.Error("rendered again while stuck in the drop folder")
+    }
+
+    // until it changes
+    later := time.Now().Add(time.Minute)
+    _ = os.Chtimes(filepath.Join(in, "card.json"), later, later)
+    d.settle = -time.Hour
+    d.scan(context.Background())
+    if _, err := os.Stat(out); err != nil {
+        t.Errorf("changed input not rendered: %v", err)
+    }
+}
+
+func TestCopyAndRemove(t *testing.T) {
+    src := filepath.Join(t.TempDir(), "card.json")
+    dst := filepath.Join(t.TempDir(), "card.json")
+    _ = os.WriteFile(src, []byte("{}"), 0o644)
+    if err := copyAndRemove(src, dst); err != nil {
+        t.Fatal(err)
+    }
+    if b, err := os.ReadFile(dst); err != nil || string(b) != "{}" {
+        t.Errorf("copied %q %v", b, err)
+    }
+    if _, err := os.Stat(src); !os.IsNotExist(err) {
+        t.Errorf("source left behind: %v", err)
+    }
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/mail"
+    "strings"
+    "time"
+)
+
+/* ==================
+   Employee directory
+   ================== */
+
+// The directory lists who is expected to hand in a card each pay period
+// and where to reach them, per tenant (X-Tenant-ID):
+//
+//   GET    /api/employees?q=&inactive=1
+//   POST   /api/employees        {"id": "1042", "name": "Bob Smith", "email": "...", ...}
+//   GET    /api/employees/{id}
+//   PUT    /api/employees/{id}   the whole employee
+//   DELETE /api/employees/{id}
+//   GET    /api/employees/{id}/balances   leave balances (balances.go)
+//
+// The id is the company's employee ID (made up when left out). A card may
+// then carry just "employee_id": the name, manager_email, supervisor and
+// default jobs
+// are filled in from the directory, so the app stops re-sending details
+// that drift out of sync, and the employee's timezone (default the
+// tenant's) decides which day an entry falls on. An employee on a
+// different card layout (apprentices, salaried staff) names its template;
+// it is used unless the card names one itself.
+
+// Employee is one person in a tenant's directory
+type Employee struct {
+    ID            string    `json:"id"`
+    Tenant        string    `json:"tenant"`
+    Name          string    `json:"name"`
+    Email         string    `json:"email,omitempty"`
+    ManagerEmail  string    `json:"manager_email,omitempty"`
+    DefaultJobs   []Job     `json:"default_jobs,omitempty"`
+    Timezone      string    `json:"timezone,omitempty"` // IANA zone
+    Template      string    `json:"template,omitempty"` // card layout, default the tenant's
+    PayrollNumber string    `json:"payroll_number,omitempty"`
+    Supervisor    string    `json:"supervisor,omitempty"` // printed in the card's approval block
+    Inactive      bool      `json:"inactive,omitempty"` // left or on leave; not expected to submit
+    CreatedAt     time.Time `json:"created_at"`
+    UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// employees is keyed by tenant and employee ID (employeeKey)
+var employees = openCollection[Employee]("employees")
+
+func employeeKey(tenant, id string) string {
+    return tenant + "/" + id
+}
+
+// lookupEmployee finds a tenant's employee by employee ID
+func lookupEmployee(tenant, id string) (Employee, bool) {
+    return employees.Get(employeeKey(tenant, id))
+}
+
+// tenantEmployees returns the tenant's active employees
+func tenantEmployees(tenant string) []Employee {
+    var out []Employee
+    for _, e := range employees.List() {
+        if e.Tenant == tenant && !e.Inactive {
+            out = append(out, e)
+        }
+    }
+    return out
+}
+
+// sameEmployee reports whether a card's employee name is e
+func (e Employee) sameEmployee(name string) bool {
+    return strings.EqualFold(strings.TrimSpace(e.Name), strings.TrimSpace(name))
+}
+
+// applyEmployee fills in what the card left out from its directory entry
+func applyEmployee(req *TimecardRequest, t *Tenant) {
+    if req.EmployeeID == "" {
+        return
+    }
+    e, ok := lookupEmployee(t.ID, req.EmployeeID)
+    if !ok {
+        return
+    }
+    if req.EmployeeName == "" {
+        req.EmployeeName = e.Name
+    }
+    if req.ManagerEmail == "" {
+        req.ManagerEmail = e.ManagerEmail
+    }
+    if req.PayrollNumber == "" {
+        req.PayrollNumber = e.PayrollNumber
+    }
+    if req.Supervisor == "" {
+        req.Supervisor = e.Supervisor
+    }
+    if req.Template == "" {
+        req.Template = e.Template
+    }
+    known := make(map[string]bool, len(req.Jobs))
+    for _, j := range req.Jobs {
+        known[j.JobCode] = true
+    }
+    for _, j := range e.DefaultJobs {
+        if !known[j.JobCode] {
+            req.Jobs = append(req.Jobs, j)
+            known[j.JobCode] = true
+        }
+    }
+}
+
+// employeeRef tells employees with the same name apart: the card's
+// employee ID, else its payroll number, else "" when it has neither
+func (req TimecardRequest) employeeRef() string {
+    if id := strings.TrimSpace(req.EmployeeID); id != "" {
+        return id
+    }
+    return strings.TrimSpace(req.PayrollNumber)
+}
+
+// fileEmployee names the employee in file names and storage paths
+func (req TimecardRequest) fileEmployee() string {
+    if ref := req.employeeRef(); ref != "" {
+        return ref
+    }
+    return req.EmployeeName
+}
+
+// cardLocation is the zone a card's days are read in: its employee's
+// timezone, or else the tenant's
+func cardLocation(req TimecardRequest, t *Tenant) *time.Location {
+    if req.EmployeeID != "" {
+        if e, ok := lookupEmployee(t.ID, req.EmployeeID); ok && e.Timezone != "" {
+            if loc, err := time.LoadLocation(e.Timezone); err == nil {
+                return loc
+            }
+        }
+    }
+    return t.location()
+}
+
+// checkEmployee reports a card naming an employee ID the directory lacks
+func checkEmployee(v *validationResult, req TimecardRequest, t *Tenant) {
+    if req.EmployeeID == "" {
+        return
+    }
+    if _, ok := lookupEmployee(t.ID, req.EmployeeID); !ok {
+        v.errorf("employee_id %q is not in the employee directory", req.EmployeeID)
+    }
+}
+
+// employeesHandler serves /api/employees (see the top of this file)
+func employeesHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/employees"), "/")
+    tenant := tenantFor(r).ID
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        listEmployees(w, r, tenant)
+    case rest == "" && r.Method == http.MethodPost:
+        saveEmployee(w, r, tenant, "")
+    case strings.HasSuffix(rest, "/balances"):
+        id, ok := isBalancesPath(rest)
+        if !ok {
+            httpError(w, r, "employee not found", http.StatusNotFound)
+            return
+        }
+        balancesHandler(w, r, tenant, id)
+    case strings.Contains(rest, "/"):
+        httpError(w, r, "employee not found", http.StatusNotFound)
+    case r.Method == http.MethodGet:
+        e, ok := lookupEmployee(tenant, rest)
+        if !ok {
+            httpError(w, r, "employee not found", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, e)
+    case r.Method == http.MethodPut:
+        saveEmployee(w, r, tenant, rest)
+    case r.Method == http.MethodDelete:
+        if _, ok := lookupEmployee(tenant, rest); !ok {
+            httpError(w, r, "employee not found", http.StatusNotFound)
+            return
+        }
+        if err := employees.Delete(employeeKey(tenant, rest)); err != nil {
+            httpError(w, r, fmt.Sprintf("error deleting employee: %v", err), http.StatusInternalServerError)
+            return
+        }
+        loggerFrom(r.Context()).Printf("Employee %s removed from %s", rest, tenant)
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func listEmployees(w http.ResponseWriter, r *http.Request, tenant string) {
+    q := strings.ToLower(r.URL.Query().Get("q"))
+    inactive := r.URL.Query().Get("inactive") != ""
+
+    out := []Employee{}
+    for _, e := range employees.List() {
+        if e.Tenant != tenant || (e.Inactive && !inactive) {
+            continue
+        }
+        if q != "" && !strings.Contains(strings.ToLower(e.Name), q) && !strings.Contains(strings.ToLower(e.Email), q) && e.ID != q {
+            continue
+        }
+        out = append(out, e)
+    }
+    writeJSON(w, http.StatusOK, out)
+}
+
+// saveEmployee creates an employee (id == "") or replaces employee id
+func saveEmployee(w http.ResponseWriter, r *http.Request, tenant, id string) {
+    var e Employee
+    if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    e.Name = strings.TrimSpace(e.Name)
+    if err := e.check(tenant); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+
+    status := http.StatusOK
+    if id == "" {
+        if e.ID == "" {
+            e.ID = newID()
+        }
+        if _, exists := lookupEmployee(tenant, e.ID); exists {
+            httpError(w, r, fmt.Sprintf("employee %s already exists", e.ID), http.StatusConflict)
+            return
+        }
+        e.CreatedAt = now().UTC()
+        status = http.StatusCreated
+    } else {
+        cur, ok := lookupEmployee(tenant, id)
+        if !ok {
+            httpError(w, r, "employee not found", http.StatusNotFound)
+            return
+        }
+        if e.ID != "" && e.ID != id {
+            httpError(w, r, "invalid request: id cannot be changed", http.StatusBadRequest)
+            return
+        }
+        e.ID, e.CreatedAt = id, cur.CreatedAt
+    }
+    e.Tenant = tenant
+    e.UpdatedAt = now().UTC()
+    if err := employees.Put(employeeKey(tenant, e.ID), e); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving employee: %v", err), http.StatusInternalServerError)
+        return
+    }
+    loggerFrom(r.Context()).Printf("Employee %s (%s) saved for %s", e.ID, e.Name, tenant)
+    writeJSON(w, status, e)
+}
+
+// check rejects an employee the tenant's directory can't use
+func (e Employee) check(tenant string) error {
+    if e.Name == "" {
+        return fmt.Errorf("name is required")
+    }
+    if strings.Contains(e.ID, "/") {
+        return fmt.Errorf("id must not contain /")
+    }
+    for _, addr := range []struct{ field, value string }{{"email", e.Email}, {"manager_email", e.ManagerEmail}} {
+        if addr.value == "" {
+            continue
+        }
+        if _, err := mail.ParseAddress(addr.value); err != nil {
+            return fmt.Errorf("%s %q is not an email address", addr.field, addr.value)
+        }
+    }
+    if e.Timezone != "" {
+        if _, err := time.LoadLocation(e.Timezone); err != nil {
+            return fmt.Errorf("unknown timezone %q", e.Timezone)
+        }
+    }
+    if e.Template != "" {
+        if _, err := resolveTemplate(tenant, e.Template); err != nil {
+            return err
+        }
+    }
+    for _, j := range e.DefaultJobs {
+        if strings.TrimSpace(j.JobCode) == "" {
+            return fmt.Errorf("default_jobs: job_code is required")
+        }
+    }
+    return nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "regexp"
+    "runtime/debug"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ==========================
+   Error reporting (Sentry)
+   ========================== */
+
+// Panics and 5xx responses are sent to the Sentry-compatible sink named by
+// SENTRY_DSN (https://<key>@<host>/<project>). Events carry route, status,
+// request ID and tenant only: no request bodies, no employee names, and
+// email addresses are scrubbed from error text.
+
+type errorContext struct {
+    mu     sync.Mutex
+    errors []string
+    extra  map[string]string
+}
+
+type errorContextKey struct{}
+
+// noteError records an error message for the event sent if the request ends in a 5xx
+func noteError(ctx context.Context, msg string) {
+    if ec, ok := ctx.Value(errorContextKey{}).(*errorContext); ok {
+        ec.mu.Lock()
+        ec.errors = append(ec.errors, scrubPII(msg))
+        ec.mu.Unlock()
+    }
+}
+
+// noteErrorExtra attaches diagnostic output (e.g. LibreOffice stderr)
+func noteErrorExtra(ctx context.Context, key, value string) {
+    if ec, ok := ctx.Value(errorContextKey{}).(*errorContext); ok {
+        const maxExtra = 8 << 10
+        if len(value) > maxExtra {
+            value = value[:maxExtra] + "…(truncated)"
+        }
+        ec.mu.Lock()
+        ec.extra[key] = scrubPII(value)
+        ec.mu.Unlock()
+    }
+}
+
+var emailPattern = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+
+func scrubPII(s string) string {
+    return emailPattern.ReplaceAllString(s, "[email]")
+}
+
+// errorReportMiddleware recovers panics (answering 500) and reports both
+// panics and 5xx responses.
+func errorReportMiddleware(next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        ec := &errorContext{extra: map[string]string{}}
+        rec := &statusRecorder{ResponseWriter: w}
+        ctx := context.WithValue(r.Context(), errorContextKey{}, ec)
+
+        defer func() {
+            if p := recover(); p != nil {
+                stack := string(debug.Stack())
+                log.Printf("PANIC on %s %s: %v\n%s", r.Method, r.URL.Path, p, stack)
+                if rec.status == 0 {
+                    httpError(rec, r.WithContext(ctx), "internal server error", http.StatusInternalServerError)
+                }
+                reportEvent(r, rec, ec, fmt.Sprintf("panic: %v", p), stack)
+                return
+            }
+            if rec.status >= 500 {
+                ec.mu.Lock()
+                msg := strings.Join(ec.errors, "; ")
+                ec.mu.Unlock()
+                if msg == "" {
+                    msg = fmt.Sprintf("HTTP %d", rec.status)
+                }
+                reportEvent(r, rec, ec, msg, "")
+            }
+        }()
+        next.ServeHTTP(rec, r.WithContext(ctx))
+    })
+}
+
+type sentryDSN struct {
+    storeURL string
+    key      string
+}
+
+var (
+    sentryOnce   sync.Once
+    sentryTarget *sentryDSN
+)
+
+func sentryConfig() *sentryDSN {
+    sentryOnce.Do(func() {
+        raw := os.Getenv("SENTRY_DSN")
+        if raw == "" {
+            return
+        }
+        u, err := url.Parse(raw)
+        if err != nil || u.User == nil || u.Host == "" {
+            log.Printf("Warning: invalid SENTRY_DSN, error reporting disabled")
+            return
+        }
+        project := strings.Trim(u.Path, "/")
+        prefix := ""
+        if i := strings.LastIndex(project, "/"); i >= 0 {
+            prefix, project = "/"+project[:i], project[i+1:]
+        }
+        sentryTarget = &sentryDSN{
+            storeURL: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, prefix, project),
+            key:      u.User.Username(),
+        }
+        log.Printf("Error reporting enabled (%s)", u.Host)
+    })
+    return sentryTarget
+}
+
+func reportEvent(r *http.Request, rec *statusRecorder, ec *errorContext, message, stack string) {
+    dsn := sentryConfig()
+    if dsn == nil {
+        return
+    }
+
+    eventID := make([]byte, 16)
+    _, _ = rand.Read(eventID)
+    ec.mu.Lock()
+    extra := make(map[string]string, len(ec.extra)+1)
+    for k, v := range ec.extra {
+        extra[k] = v
+    }
+    ec.mu.Unlock()
+    if stack != "" {
+        extra["stack"] = stack
+    }
+    status := rec.status
+    if status == 0 {
+        status = http.StatusInternalServerError
+    }
+    sha, _ := buildRevision()
+
+    event := map[string]interface{}{
+        "event_id":  hex.EncodeToString(eventID),
+        "timestamp": time.Now().UTC().Format(time.RFC3339),
+        "level":     "error",
+        "platform":  "go",
+        "logger":    "timecard-api",
+        "release":   sha,
+        "message":   map[string]string{"formatted": message},
+        "tags": map[string]string{
+            "route":      r.URL.Path,
+            "method":     r.Method,
+            "status":     fmt.Sprint(status),
+            "request_id": rec.Header().Get("X-Request-ID"),
+            "tenant":     r.Header.Get("X-Tenant-ID"),
+        },
+        "request": map[string]string{
+            "method": r.Method,
+            "url":    r.URL.Path, // no query string: it may carry names or addresses
+        },
+        "extra": extra,
+    }
+
+    go func() {
+        body, err := json.Marshal(event)
+        if err != nil {
+            return
+        }
+        req, err := http.NewRequest(http.MethodPost, dsn.storeURL, bytes.NewReader(body))
+        if err != nil {
+            return
+        }
+        req.Header.Set("Content-Type", "application/json")
+        req.Header.Set("X-Sentry-Auth", fmt.Sprintf(
+            "Sentry sentry_version=7, sentry_client=timecard-api/1.0, sentry_key=%s", dsn.key))
+        client := &http.Client{Timeout: 10 * time.Second}
+        resp, err := client.Do(req)
+        if err != nil {
+            log.Printf("error report failed: %v", err)
+            return
+        }
+        resp.Body.Close()
+        if resp.StatusCode >= 300 {
+            log.Printf("error report rejected: %s", resp.Status)
+        }
+    }()
+}
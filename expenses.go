@@ -0,0 +1,285 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ========
+   Expenses
+   ======== */
+
+// Field staff claim per diems and small purchases on the same card:
+//
+//   "expenses": [{"date": "2025-01-06T00:00:00Z", "type": "per_diem", "job_code": "29699"},
+//                {"date": "2025-01-07T00:00:00Z", "type": "materials", "job_code": "29699",
+//                 "description": "Anchors, Home Depot", "amount": 42.18}]
+//
+// type is per_diem, materials or other. A per diem without an amount is
+// paid at the tenant's rate ("per_diem": 75). A template can map an area
+// of each week sheet for the week's lines,
+//
+//   "expenses": {"first_row": 30, "rows": 5, "date_column": "B", "type_column": "C",
+//                "job_column": "D", "description_column": "E", "amount_column": "H",
+//                "total": "H35"}
+//
+// and when it maps none, or the lines don't fit it, they are all listed on
+// an "Expenses" sheet added to the workbook instead. The summary, year to
+// date and job cost reports add the amounts up by type, apart from hours.
+
+const (
+    expensePerDiem   = "per_diem"
+    expenseMaterials = "materials"
+    expenseOther     = "other"
+)
+
+// expenseTypes are the accepted expense types, in the order reports list them
+var expenseTypes = []string{expensePerDiem, expenseMaterials, expenseOther}
+
+var expenseTypeLabels = map[string][2]string{
+    expensePerDiem:   {"Per diem", "Indemnité journalière"},
+    expenseMaterials: {"Materials", "Matériaux"},
+    expenseOther:     {"Other", "Autre"},
+}
+
+// Expense is one expense line on a card
+type Expense struct {
+    Date        string  `json:"date"`
+    Type        string  `json:"type"`
+    JobCode     string  `json:"job_code,omitempty"` // JOB NUMBER it is charged to
+    Description string  `json:"description,omitempty"`
+    Amount      float64 `json:"amount"`
+}
+
+// ExpenseArea is where a template takes each week's expense lines
+type ExpenseArea struct {
+    FirstRow          int    `json:"first_row"`
+    Rows              int    `json:"rows"`
+    DateColumn        string `json:"date_column,omitempty"`
+    TypeColumn        string `json:"type_column,omitempty"`
+    JobColumn         string `json:"job_column,omitempty"`
+    DescriptionColumn string `json:"description_column,omitempty"`
+    AmountColumn      string `json:"amount_column"`
+    Total             string `json:"total,omitempty"` // the week's total
+}
+
+const expenseSheet = "Expenses"
+
+// applyExpenses prices per diems left without an amount
+func applyExpenses(req *TimecardRequest, t *Tenant) {
+    for i := range req.Expenses {
+        x := &req.Expenses[i]
+        x.Type = strings.ToLower(strings.TrimSpace(x.Type))
+        if x.Type == expensePerDiem && x.Amount == 0 {
+            x.Amount = t.PerDiem
+        }
+    }
+}
+
+// checkExpenses reports expense lines the card can't carry
+func checkExpenses(v *validationResult, req TimecardRequest, t *Tenant) {
+    loc := cardLocation(req, t)
+    for i, x := range req.Expenses {
+        label := fmt.Sprintf("expenses[%d]", i)
+        if _, err := parseCalendarDate(x.Date, loc); err != nil {
+            v.errorf("%s: date %q is not an RFC 3339 date", label, x.Date)
+        }
+        if _, ok := expenseTypeLabels[x.Type]; !ok {
+            v.errorf("%s: type %q must be %s", label, x.Type, strings.Join(expenseTypes, ", "))
+        }
+        switch {
+        case x.Amount < 0:
+            v.errorf("%s: amount must not be negative", label)
+        case x.Amount == 0 && x.Type == expensePerDiem:
+            v.errorf("%s: per diem has no amount and the tenant has no per_diem rate", label)
+        case x.Amount == 0:
+            v.errorf("%s: amount is required", label)
+        }
+    }
+}
+
+// expenseSums are the expense amounts of one row of a report
+type expenseSums struct {
+    Expenses      map[string]float64 `json:"expenses,omitempty"` // by type
+    ExpensesTotal float64            `json:"expenses_total,omitempty"`
+}
+
+func (s *expenseSums) addExpense(x Expense) {
+    if s.Expenses == nil {
+        s.Expenses = map[string]float64{}
+    }
+    s.Expenses[x.Type] += x.Amount
+    s.ExpensesTotal += x.Amount
+}
+
+// roundedExpenses drops the float noise of adding up cents
+func (s expenseSums) roundedExpenses() expenseSums {
+    out := expenseSums{ExpensesTotal: roundAmount(s.ExpensesTotal)}
+    for k, v := range s.Expenses {
+        if out.Expenses == nil {
+            out.Expenses = map[string]float64{}
+        }
+        out.Expenses[k] = roundAmount(v)
+    }
+    return out
+}
+
+func roundAmount(a float64) float64 {
+    return math.Round(a*100) / 100
+}
+
+// weekExpenses are the card's expenses dated in the week from weekStart
+func weekExpenses(req TimecardRequest, loc *time.Location, weekStart time.Time) []Expense {
+    var out []Expense
+    for _, x := range req.Expenses {
+        day, err := parseCalendarDate(x.Date, loc)
+        if err != nil || day.Before(weekStart) || !day.Before(weekStart.AddDate(0, 0, 7)) {
+            continue
+        }
+        out = append(out, x)
+    }
+    return out
+}
+
+// placeExpenses writes the card's expense lines into the template's area
+// of its week sheets, or on an expense sheet when they don't fit there
+func placeExpenses(gc *genContext, f *excelize.File, sheets []string) {
+    if len(gc.req.Expenses) == 0 {
+        return
+    }
+    if !expensesFitTemplate(gc, len(sheets)) {
+        if err := addExpenseSheet(gc, f); err != nil {
+            gc.lg.Printf("Warning: expense sheet: %v", err)
+        }
+        return
+    }
+    for i, wk := range gc.req.Weeks {
+        if i >= len(sheets) {
+            break
+        }
+        start, _ := parseCalendarDate(wk.WeekStartDate, gc.loc)
+        writeWeekExpenses(gc, f, sheets[i], start)
+    }
+}
+
+// expensesFitTemplate tells whether every expense line has a place in the
+// template's area of the card's week sheets
+func expensesFitTemplate(gc *genContext, sheets int) bool {
+    area := gc.tpl.CellMap.Expenses
+    if area == nil || area.Rows <= 0 {
+        return false
+    }
+    placed := 0
+    for i, wk := range gc.req.Weeks {
+        if i >= sheets {
+            break
+        }
+        start, err := parseCalendarDate(wk.WeekStartDate, gc.loc)
+        if err != nil {
+            return false
+        }
+        n := len(weekExpenses(gc.req, gc.loc, start))
+        if n > area.Rows {
+            return false
+        }
+        placed += n
+    }
+    return placed == len(gc.req.Expenses)
+}
+
+// writeWeekExpenses fills the template's expense area with one week's lines
+func writeWeekExpenses(gc *genContext, f *excelize.File, sheet string, weekStart time.Time) {
+    area := gc.tpl.CellMap.Expenses
+    var total float64
+    for i, x := range weekExpenses(gc.req, gc.loc, weekStart) {
+        row := area.FirstRow + i
+        day, _ := parseCalendarDate(x.Date, gc.loc)
+        cell := func(col string) string {
+            if col == "" {
+                return ""
+            }
+            return fmt.Sprintf("%s%d", col, row)
+        }
+        setMapped(f, sheet, cell(area.DateColumn), timeToExcelDate(day))
+        setMapped(f, sheet, cell(area.TypeColumn), expenseTypeLabel(x.Type, gc.req.Bilingual))
+        setMapped(f, sheet, cell(area.JobColumn), x.JobCode)
+        setMapped(f, sheet, cell(area.DescriptionColumn), x.Description)
+        setMapped(f, sheet, cell(area.AmountColumn), x.Amount)
+        total += x.Amount
+    }
+    setMapped(f, sheet, area.Total, roundAmount(total))
+}
+
+// addExpenseSheet lists all the card's expense lines on a sheet of their own
+func addExpenseSheet(gc *genContext, f *excelize.File) error {
+    if _, err := f.NewSheet(expenseSheet); err != nil {
+        return err
+    }
+    header := []interface{}{"Date", "Type", "Job number", "Description", "Amount"}
+    if gc.req.Bilingual {
+        header = []interface{}{bilingual("Date", "Date"), bilingual("Type", "Type"),
+            bilingual("Job number", "Numéro de projet"), bilingual("Description", "Description"), bilingual("Amount", "Montant")}
+    }
+    if err := f.SetSheetRow(expenseSheet, "A1", &header); err != nil {
+        return err
+    }
+    lines := append([]Expense(nil), gc.req.Expenses...)
+    sort.SliceStable(lines, func(a, b int) bool { return lines[a].Date < lines[b].Date })
+    var total float64
+    for i, x := range lines {
+        day, _ := parseCalendarDate(x.Date, gc.loc)
+        row := []interface{}{timeToExcelDate(day), expenseTypeLabel(x.Type, gc.req.Bilingual), x.JobCode, x.Description, x.Amount}
+        if err := f.SetSheetRow(expenseSheet, fmt.Sprintf("A%d", i+2), &row); err != nil {
+            return err
+        }
+        total += x.Amount
+    }
+    last := len(lines) + 2
+    totalRow := []interface{}{"Total", nil, nil, nil, roundAmount(total)}
+    if err := f.SetSheetRow(expenseSheet, fmt.Sprintf("A%d", last), &totalRow); err != nil {
+        return err
+    }
+
+    bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+    if err != nil {
+        return err
+    }
+    date, err := f.NewStyle(&excelize.Style{NumFmt: 14}) // m/d/yyyy
+    if err != nil {
+        return err
+    }
+    money, err := f.NewStyle(&excelize.Style{NumFmt: 4}) // #,##0.00
+    if err != nil {
+        return err
+    }
+    boldMoney, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}, NumFmt: 4})
+    if err != nil {
+        return err
+    }
+    _ = f.SetCellStyle(expenseSheet, "A1", "E1", bold)
+    _ = f.SetCellStyle(expenseSheet, fmt.Sprintf("A%d", last), fmt.Sprintf("D%d", last), bold)
+    _ = f.SetCellStyle(expenseSheet, "A2", fmt.Sprintf("A%d", last-1), date)
+    _ = f.SetCellStyle(expenseSheet, "E2", fmt.Sprintf("E%d", last-1), money)
+    _ = f.SetCellStyle(expenseSheet, fmt.Sprintf("E%d", last), fmt.Sprintf("E%d", last), boldMoney)
+    _ = f.SetColWidth(expenseSheet, "A", "C", 14)
+    _ = f.SetColWidth(expenseSheet, "D", "D", 40)
+    _ = f.SetColWidth(expenseSheet, "E", "E", 12)
+    gc.lg.Printf("  %d expense line(s) on the %s sheet, %.2f", len(lines), expenseSheet, total)
+    return nil
+}
+
+func expenseTypeLabel(kind string, both bool) string {
+    l, ok := expenseTypeLabels[kind]
+    if !ok {
+        return kind
+    }
+    if both {
+        return bilingual(l[0], l[1])
+    }
+    return l[0]
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+    "context"
+    "reflect"
+    "strings"
+    "testing"
+
+    "github.com/xuri/excelize/v2"
+)
+
+func TestCheckExpenses(t *testing.T) {
+    tenant := &Tenant{ID: "expense-test", PerDiem: 75}
+    const mon = "2025-01-06T00:00:00Z"
+    tests := []struct {
+        name string
+        x    Expense
+        want string
+    }{
+        {"materials", Expense{Date: mon, Type: "Materials", Amount: 42.18}, ""},
+        {"per diem at the rate", Expense{Date: mon, Type: "per_diem"}, ""},
+        {"bad date", Expense{Date: "Jan 6", Type: "other", Amount: 1}, `date "Jan 6"`},
+        {"bad type", Expense{Date: mon, Type: "mileage", Amount: 1}, `type "mileage" must be per_diem, materials, other`},
+        {"negative", Expense{Date: mon, Type: "other", Amount: -1}, "must not be negative"},
+        {"no amount", Expense{Date: mon, Type: "other"}, "amount is required"},
+    }
+    for _, tt := range tests {
+        req := TimecardRequest{Expenses: []Expense{tt.x}}
+        applyExpenses(&req, tenant)
+        var v validationResult
+        checkExpenses(&v, req, tenant)
+        got := strings.Join(v.Errors, "; ")
+        if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+            t.Errorf("%s: errors %q, want %q", tt.name, got, tt.want)
+        }
+    }
+
+    req := TimecardRequest{Expenses: []Expense{{Date: mon, Type: "per_diem"}}}
+    applyExpenses(&req, &Tenant{ID: "expense-test"})
+    var v validationResult
+    checkExpenses(&v, req, &Tenant{ID: "expense-test"})
+    if len(v.Errors) != 1 || !strings.Contains(v.Errors[0], "no per_diem rate") {
+        t.Errorf("per diem without a rate: %q", v.Errors)
+    }
+}
+
+func TestSummarizeExpenses(t *testing.T) {
+    tenant := &Tenant{ID: "expense-test"}
+    bob := TimecardRequest{EmployeeName: "Bob Smith", Entries: []Entry{worked("Mon", "A", 8)}, Expenses: []Expense{
+        {Date: "2025-01-06T00:00:00Z", Type: expensePerDiem, JobCode: "A", Amount: 75},
+        {Date: "2025-01-06T00:00:00Z", Type: expenseMaterials, JobCode: "B", Amount: 0.1},
+        {Date: "2025-01-07T00:00:00Z", Type: expenseMaterials, JobCode: "B", Amount: 0.2},
+    }}
+    rep := summarize(tenant, []TimecardRequest{bob})
+    if rep.ExpensesTotal != 75.3 || !reflect.DeepEqual(rep.Expenses, map[string]float64{expensePerDiem: 75, expenseMaterials: 0.3}) {
+        t.Errorf("totals = %+v", rep.expenseSums)
+    }
+    if rep.Employees[0].ExpensesTotal != 75.3 {
+        t.Errorf("Bob = %+v", rep.Employees[0].expenseSums)
+    }
+    // B has expenses but no hours
+    if len(rep.Jobs) != 2 || rep.Jobs[1].JobNumber != "B" || rep.Jobs[1].ExpensesTotal != 0.3 || rep.Jobs[1].Total != 0 {
+        t.Errorf("jobs = %+v", rep.Jobs)
+    }
+}
+
+func TestPlaceExpenses(t *testing.T) {
+    req := TimecardRequest{
+        EmployeeName: "Bob Smith",
+        Weeks:        []WeekData{{WeekStartDate: "2025-01-05T00:00:00Z"}, {WeekStartDate: "2025-01-12T00:00:00Z"}},
+        Expenses: []Expense{
+            {Date: "2025-01-07T00:00:00Z", Type: expenseMaterials, JobCode: "A", Description: "Anchors", Amount: 42.18},
+            {Date: "2025-01-06T00:00:00Z", Type: expensePerDiem, Amount: 75},
+            {Date: "2025-01-13T00:00:00Z", Type: expensePerDiem, Amount: 75},
+        },
+    }
+    render := func(area *ExpenseArea) *excelize.File {
+        f := excelize.NewFile()
+        _, _ = f.NewSheet("Week 2")
+        gc := newGenContext(context.Background(), req, &Tenant{ID: "expense-test"}, loggerFrom(context.Background()))
+        cm := defaultCellMap()
+        cm.Expenses = area
+        gc.tpl = &templateDef{CellMap: cm}
+        placeExpenses(gc, f, []string{"Sheet1", "Week 2"})
+        return f
+    }
+    cell := func(f *excelize.File, sheet, c string) string {
+        v, _ := f.GetCellValue(sheet, c)
+        return v
+    }
+
+    // no area: everything on the expense sheet, in date order
+    f := render(nil)
+    if cell(f, expenseSheet, "B2") != "Per diem" || cell(f, expenseSheet, "D3") != "Anchors" || cell(f, expenseSheet, "E5") != "192.18" {
+        rows, _ := f.GetRows(expenseSheet)
+        t.Errorf("expense sheet = %q", rows)
+    }
+
+    // an area that fits: each week's lines on its sheet
+    area := &ExpenseArea{FirstRow: 30, Rows: 2, TypeColumn: "C", DescriptionColumn: "E", AmountColumn: "H", Total: "H32"}
+    f = render(area)
+    if i, _ := f.GetSheetIndex(expenseSheet); i != -1 {
+        t.Errorf("expense sheet added though the lines fit")
+    }
+    if cell(f, "Sheet1", "E30") != "Anchors" || cell(f, "Sheet1", "H32") != "117.18" || cell(f, "Week 2", "C30") != "Per diem" {
+        t.Errorf("week 1 %q %q, week 2 %q", cell(f, "Sheet1", "E30"), cell(f, "Sheet1", "H32"), cell(f, "Week 2", "C30"))
+    }
+
+    // too small: back to the sheet
+    area.Rows = 1
+    f = render(area)
+    if i, _ := f.GetSheetIndex(expenseSheet); i == -1 || cell(f, "Sheet1", "H30") != "" {
+        t.Errorf("lines that don't fit should all go on the expense sheet")
+    }
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "math"
+    "net/http"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* =======
+   Exports
+   ======= */
+
+// Accounting and payroll systems want the hours as lines, not a sheet.
+// Each format is an Exporter registered under its name and served by
+//
+//   GET  /api/export                 the available formats
+//   POST /api/export/{format}        one card, or a JSON array of cards
+//
+// ?format=<variant> picks a registered "{format}-<variant>" (e.g.
+// /api/export/quickbooks?format=csv). hourLines flattens a card the way the
+// workbook adds it up (per day, job number, overtime and night shift) for
+// the exporters to map onto their own layouts.
+
+// Exporter turns validated cards into one file for another system
+type Exporter interface {
+    Name() string
+    ContentType() string
+    // FileName is the download name for an export of reqs for t
+    FileName(t *Tenant, reqs []TimecardRequest) string
+    // Export renders reqs using t's settings for the format. Cards the
+    // settings can't map are reported as a validationResult.
+    Export(t *Tenant, reqs []TimecardRequest) ([]byte, error)
+}
+
+var (
+    exportersMu sync.RWMutex
+    exporters   = map[string]Exporter{}
+)
+
+// registerExporter makes a format available at /api/export/{name}
+func registerExporter(e Exporter) {
+    exportersMu.Lock()
+    defer exportersMu.Unlock()
+    exporters[e.Name()] = e
+}
+
+func exporterFor(name string) (Exporter, bool) {
+    exportersMu.RLock()
+    defer exportersMu.RUnlock()
+    e, ok := exporters[name]
+    return e, ok
+}
+
+func exportFormats() []string {
+    exportersMu.RLock()
+    defer exportersMu.RUnlock()
+    out := make([]string, 0, len(exporters))
+    for name := range exporters {
+        out = append(out, name)
+    }
+    sort.Strings(out)
+    return out
+}
+
+func exportHandler(w http.ResponseWriter, r *http.Request) {
+    name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/export"), "/")
+    if name == "" {
+        if r.Method != http.MethodGet {
+            httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        writeJSON(w, http.StatusOK, map[string]interface{}{"formats": exportFormats()})
+        return
+    }
+    if r.Method != http.MethodPost {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if variant := r.URL.Query().Get("format"); variant != "" {
+        if _, ok := exporterFor(name + "-" + variant); ok {
+            name += "-" + variant
+        }
+    }
+    e, ok := exporterFor(name)
+    if !ok {
+        httpError(w, r, fmt.Sprintf("unknown export format %q; available: %s", name, strings.Join(exportFormats(), ", ")), http.StatusNotFound)
+        return
+    }
+    lg := loggerFrom(r.Context())
+    tenant := tenantFor(r)
+
+    reqs, ok := readExportCards(w, r, tenant)
+    if !ok {
+        return
+    }
+    data, err := e.Export(tenant, reqs)
+    if err != nil {
+        var v validationResult
+        if errors.As(err, &v) {
+            writeJSON(w, http.StatusBadRequest, v)
+            return
+        }
+        httpError(w, r, fmt.Sprintf("error exporting %s: %v", name, err), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", e.ContentType())
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", e.FileName(tenant, reqs)))
+    w.WriteHeader(http.StatusOK)
+    _, _ = w.Write(data)
+    lg.Printf("OK: %s export of %d timecard(s), %d bytes", name, len(reqs), len(data))
+}
+
+// hourLine is one day's hours on one job
+type hourLine struct {
+    Date       time.Time // calendar day (UTC midnight)
+    JobNumber  string
+    LabourCode string // from the card's jobs; "" when the job isn't listed
+    Phase      string // and cost code, when the entries have them (costcodes.go)
+    CostCode   string
+    Hours      float64
+    Overtime   bool
+    DoubleTime bool // also Overtime
+    Night      bool
+    Kind       string // the entries' type: "" for worked hours
+}
+
+// hourLines aggregates the entries of req in day, job order. Entries with
+// unreadable dates were already reported by validation and are skipped.
+func hourLines(req TimecardRequest, loc *time.Location) []hourLine {
+    codes := make(map[string]string, len(req.Jobs))
+    for _, j := range req.Jobs {
+        codes[j.JobCode] = j.JobName
+    }
+    type lineKey struct {
+        date            time.Time
+        job             string
+        phase, cost     string
+        overtime, night bool
+        double          bool
+        kind            string
+    }
+    sums := map[lineKey]float64{}
+    for _, e := range allEntries(req) {
+        day, err := parseCalendarDate(e.Date, loc)
+        if err != nil || e.Hours == 0 {
+            continue
+        }
+        sums[lineKey{day, e.JobCode, e.Phase, e.CostCode, e.Overtime, e.IsNightShift, e.DoubleTime, e.Type}] += e.Hours
+    }
+
+    lines := make([]hourLine, 0, len(sums))
+    for k, h := range sums {
+        lines = append(lines, hourLine{
+            Date:       k.date,
+            JobNumber:  k.job,
+            LabourCode: codes[k.job],
+            Phase:      k.phase,
+            CostCode:   k.cost,
+            Hours:      h,
+            Overtime:   k.overtime,
+            DoubleTime: k.double,
+            Night:      k.night,
+            Kind:       k.kind,
+        })
+    }
+    sort.Slice(lines, func(i, j int) bool {
+        a, b := lines[i], lines[j]
+        if !a.Date.Equal(b.Date) {
+            return a.Date.Before(b.Date)
+        }
+        if a.JobNumber != b.JobNumber {
+            return a.JobNumber < b.JobNumber
+        }
+        if a.Phase != b.Phase {
+            return a.Phase < b.Phase
+        }
+        if a.CostCode != b.CostCode {
+            return a.CostCode < b.CostCode
+        }
+        if a.Overtime != b.Overtime {
+            return !a.Overtime
+        }
+        if a.DoubleTime != b.DoubleTime {
+            return !a.DoubleTime
+        }
+        return !a.Night && b.Night
+    })
+    return lines
+}
+
+// worked is false for stat pay and leave
+func (l hourLine) worked() bool {
+    return l.Kind == ""
+}
+
+// code is the labour code as the sheet shows it: "N" marks night shift
+// and "DT" double time
+func (l hourLine) code() string {
+    code := l.LabourCode
+    if l.Night {
+        code = "N" + code
+    }
+    if l.DoubleTime {
+        code = "DT" + code
+    }
+    return code
+}
+
+// clockDuration formats hours as H:MM, rounded to the minute
+func clockDuration(hours float64) string {
+    minutes := int(math.Round(hours * 60))
+    return fmt.Sprintf("%d:%02d", minutes/60, minutes%60)
+}
+
+// exportFileName is the usual download name for an export of reqs: named
+// after the card when there is one, after the format when there are more
+func exportFileName(reqs []TimecardRequest, format, ext string) string {
+    if len(reqs) != 1 {
+        return fmt.Sprintf("%s_timecards.%s", format, ext)
+    }
+    req := reqs[0]
+    return fmt.Sprintf("timecard_%s_PP%02d_%d_%s.%s", pathSegment(req.fileEmployee()), req.PayPeriodNum, req.Year, format, ext)
+}
+
+// cardLabel names the i'th card of an export in error messages
+func cardLabel(i int, req TimecardRequest) string {
+    return fmt.Sprintf("timecard %d (%s)", i+1, req.EmployeeName)
+}
+
+// readCards decodes a body holding one card or a JSON array of them
+func readCards(r *http.Request) ([]TimecardRequest, error) {
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        return nil, err
+    }
+    body = bytes.TrimSpace(body)
+    var reqs []TimecardRequest
+    if len(body) > 0 && body[0] == '[' {
+        if err := json.Unmarshal(body, &reqs); err != nil {
+            return nil, err
+        }
+        if len(reqs) == 0 {
+            return nil, fmt.Errorf("no timecards")
+        }
+        return reqs, nil
+    }
+    var req TimecardRequest
+    if err := json.Unmarshal(body, &req); err != nil {
+        return nil, err
+    }
+    return []TimecardRequest{req}, nil
+}
+
+// readExportCards reads the cards of an export request, applies tenant
+// defaults and validates each, prefixing messages with the card they
+// concern. When ok is false the response has been written.
+func readExportCards(w http.ResponseWriter, r *http.Request, tenant *Tenant) (reqs []TimecardRequest, ok bool) {
+    reqs, err := readCards(r)
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return nil, false
+    }
+    var v validationResult
+    for i := range reqs {
+        applyTimecardDefaults(&reqs[i], tenant)
+        label := cardLabel(i, reqs[i])
+        cv := validateTimecard(reqs[i], tenant)
+        for _, e := range cv.Errors {
+            v.errorf("%s: %s", label, e)
+        }
+        for _, e := range cv.Warnings {
+            v.warnf("%s: %s", label, e)
+        }
+    }
+    if len(v.Errors) > 0 {
+        writeJSON(w, http.StatusBadRequest, v)
+        return nil, false
+    }
+    if len(v.Warnings) > 0 {
+        w.Header().Set("X-Timecard-Warnings", strings.Join(v.Warnings, "; "))
+    }
+    return reqs, true
+}
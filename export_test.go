@@ -0,0 +1,97 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+// exportTestCard is a week of Bob Smith's on two jobs: two entries on the
+// same day and job to be added up, overtime, a night shift, a job the card
+// doesn't list and an undated entry to be skipped
+func exportTestCard() TimecardRequest {
+    return TimecardRequest{
+        EmployeeName: "Bob Smith",
+        PayPeriodNum: 3,
+        Year:         2025,
+        Jobs:         []Job{{JobCode: "29699", JobName: "201"}, {JobCode: "12215", JobName: "223"}},
+        Entries: []Entry{
+            {Date: "2025-01-06T00:00:00Z", JobCode: "29699", Hours: 6},
+            {Date: "2025-01-06T00:00:00Z", JobCode: "29699", Hours: 2},
+            {Date: "2025-01-06T00:00:00Z", JobCode: "29699", Hours: 1.5, Overtime: true},
+            {Date: "2025-01-07T00:00:00Z", JobCode: "12215", Hours: 7.75, IsNightShift: true},
+            {Date: "2025-01-07T00:00:00Z", JobCode: "50000", Hours: 0.5},
+            {Date: "Tuesday", JobCode: "29699", Hours: 4},
+        },
+    }
+}
+
+func TestHourLines(t *testing.T) {
+    var got []string
+    for _, l := range hourLines(exportTestCard(), time.UTC) {
+        got = append(got, fmt.Sprintf("%s %s/%s %s ot=%v night=%v",
+            l.Date.Format("Mon"), l.JobNumber, l.code(), clockDuration(l.Hours), l.Overtime, l.Night))
+    }
+    want := []string{
+        "Mon 29699/201 8:00 ot=false night=false",
+        "Mon 29699/201 1:30 ot=true night=false",
+        "Tue 12215/N223 7:45 ot=false night=true",
+        "Tue 50000/ 0:30 ot=false night=false",
+    }
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got %q\nwant %q", got, want)
+    }
+}
+
+func TestClockDuration(t *testing.T) {
+    for hours, want := range map[float64]string{0: "0:00", 7.75: "7:45", 8: "8:00", 0.333: "0:20", 10.999: "11:00"} {
+        if got := clockDuration(hours); got != want {
+            t.Errorf("clockDuration(%g) = %s, want %s", hours, got, want)
+        }
+    }
+}
+
+func TestExportHandler(t *testing.T) {
+    withTenants(t, `{}`)
+    r := httptest.NewRequest(http.MethodGet, "/api/export", nil)
+    w := httptest.NewRecorder()
+    exportHandler(w, r)
+    var list struct {
+        Formats []string `json:"formats"`
+    }
+    if err := json.Unmarshal(w.Body.Bytes(), &list); err != nil || !reflect.DeepEqual(list.Formats, []string{"adp", "ics", "quickbooks", "quickbooks-csv", "sage"}) {
+        t.Errorf("formats = %s", w.Body)
+    }
+
+    card := exportTestCard()
+    card.Entries = card.Entries[:5]
+    tests := []struct {
+        path        string
+        status      int
+        contentType string
+    }{
+        {"/api/export/quickbooks", http.StatusOK, "application/x-iif"},
+        {"/api/export/quickbooks?format=csv", http.StatusOK, "text/csv; charset=utf-8"},
+        {"/api/export/quickbooks-csv", http.StatusOK, "text/csv; charset=utf-8"},
+        {"/api/export/quickbooks?format=xml", http.StatusOK, "application/x-iif"},
+        {"/api/export/myob", http.StatusNotFound, ""},
+        {"/api/export/adp", http.StatusBadRequest, ""}, // not configured
+    }
+    for _, tt := range tests {
+        w := postCards(t, exportHandler, tt.path, "", card)
+        if w.Code != tt.status || (tt.contentType != "" && w.Header().Get("Content-Type") != tt.contentType) {
+            t.Errorf("%s: %d %s, want %d %s", tt.path, w.Code, w.Header().Get("Content-Type"), tt.status, tt.contentType)
+        }
+    }
+
+    // cards that don't validate never reach the exporter
+    card.Template = "missing"
+    if w := postCards(t, exportHandler, "/api/export/quickbooks", "", card); w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), `unknown template \"missing\"`) {
+        t.Errorf("invalid card: %d %s", w.Code, w.Body)
+    }
+}
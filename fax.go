@@ -0,0 +1,203 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "mime/multipart"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "time"
+)
+
+/* ============
+   Fax delivery
+   ============ */
+
+// Some general contractors still want timecards by fax. The "fax" channel
+// sends the PDF through the provider named by FAX_PROVIDER:
+//
+//   phaxio  PHAXIO_API_KEY, PHAXIO_API_SECRET
+//   srfax   SRFAX_ACCESS_ID, SRFAX_ACCESS_PWD, SRFAX_CALLER_ID, SRFAX_SENDER_EMAIL
+//
+// Providers queue the fax and transmit it later, so the receipt records
+// status "queued" and the provider's fax id to look the transmission up by.
+// Ten-digit numbers are taken as North American and get a leading 1.
+
+var faxClient = &http.Client{Timeout: 30 * time.Second}
+
+func init() {
+    switch p := os.Getenv("FAX_PROVIDER"); p {
+    case "":
+    case "phaxio":
+        registerDeliverer("fax", phaxioFax{
+            apiURL: envOr("PHAXIO_API_URL", "https://api.phaxio.com/v2"),
+            key:    os.Getenv("PHAXIO_API_KEY"),
+            secret: os.Getenv("PHAXIO_API_SECRET"),
+        })
+    case "srfax":
+        registerDeliverer("fax", srFax{
+            apiURL:   envOr("SRFAX_API_URL", "https://secure.srfax.com/SRF_SecWebSvc.php"),
+            id:       os.Getenv("SRFAX_ACCESS_ID"),
+            pwd:      os.Getenv("SRFAX_ACCESS_PWD"),
+            callerID: os.Getenv("SRFAX_CALLER_ID"),
+            sender:   os.Getenv("SRFAX_SENDER_EMAIL"),
+        })
+    default:
+        log.Printf("Warning: unknown FAX_PROVIDER %q, fax delivery disabled", p)
+    }
+}
+
+// faxDigits normalizes a fax number to its international digits
+func faxDigits(number string) (string, error) {
+    var b strings.Builder
+    for _, c := range number {
+        if c >= '0' && c <= '9' {
+            b.WriteRune(c)
+        }
+    }
+    digits := b.String()
+    if len(digits) == 10 && !strings.HasPrefix(strings.TrimSpace(number), "+") {
+        digits = "1" + digits
+    }
+    if len(digits) < 8 || len(digits) > 15 {
+        return "", fmt.Errorf("invalid fax number %q", number)
+    }
+    return digits, nil
+}
+
+type phaxioFax struct {
+    apiURL, key, secret string
+}
+
+func (phaxioFax) Format() string { return "pdf" }
+
+func (phaxioFax) CheckAddress(to string) error {
+    _, err := faxDigits(to)
+    return err
+}
+
+func (p phaxioFax) Deliver(ctx context.Context, d Delivery) (DeliveryReceipt, error) {
+    digits, err := faxDigits(d.To)
+    if err != nil {
+        return DeliveryReceipt{}, err
+    }
+    if p.key == "" || p.secret == "" {
+        return DeliveryReceipt{}, fmt.Errorf("phaxio not configured")
+    }
+
+    var body bytes.Buffer
+    mw := multipart.NewWriter(&body)
+    _ = mw.WriteField("to", "+"+digits)
+    fw, err := mw.CreateFormFile("file", d.FileName)
+    if err != nil {
+        return DeliveryReceipt{}, err
+    }
+    _, _ = fw.Write(d.Data)
+    if err := mw.Close(); err != nil {
+        return DeliveryReceipt{}, err
+    }
+
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.apiURL+"/faxes", &body)
+    if err != nil {
+        return DeliveryReceipt{}, err
+    }
+    req.Header.Set("Content-Type", mw.FormDataContentType())
+    req.SetBasicAuth(p.key, p.secret)
+
+    var out struct {
+        Success bool   `json:"success"`
+        Message string `json:"message"`
+        Data    struct {
+            ID json.Number `json:"id"`
+        } `json:"data"`
+    }
+    if err := faxCall(req, &out); err != nil {
+        return DeliveryReceipt{}, fmt.Errorf("phaxio: %w", err)
+    }
+    if !out.Success {
+        return DeliveryReceipt{}, fmt.Errorf("phaxio: %s", out.Message)
+    }
+    return DeliveryReceipt{Provider: "phaxio", ProviderID: out.Data.ID.String(), Status: "queued"}, nil
+}
+
+type srFax struct {
+    apiURL, id, pwd, callerID, sender string
+}
+
+func (srFax) Format() string { return "pdf" }
+
+func (srFax) CheckAddress(to string) error {
+    _, err := faxDigits(to)
+    return err
+}
+
+func (s srFax) Deliver(ctx context.Context, d Delivery) (DeliveryReceipt, error) {
+    digits, err := faxDigits(d.To)
+    if err != nil {
+        return DeliveryReceipt{}, err
+    }
+    if s.id == "" || s.pwd == "" || s.callerID == "" || s.sender == "" {
+        return DeliveryReceipt{}, fmt.Errorf("srfax not configured")
+    }
+
+    form := url.Values{
+        "action":         {"Queue_Fax"},
+        "access_id":      {s.id},
+        "access_pwd":     {s.pwd},
+        "sCallerID":      {s.callerID},
+        "sSenderEmail":   {s.sender},
+        "sFaxType":       {"SINGLE"},
+        "sToFaxNumber":   {digits},
+        "sFileName_1":    {d.FileName},
+        "sFileContent_1": {base64.StdEncoding.EncodeToString(d.Data)},
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.apiURL, strings.NewReader(form.Encode()))
+    if err != nil {
+        return DeliveryReceipt{}, err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+    var out struct {
+        Status string          `json:"Status"`
+        Result json.RawMessage `json:"Result"`
+    }
+    if err := faxCall(req, &out); err != nil {
+        return DeliveryReceipt{}, fmt.Errorf("srfax: %w", err)
+    }
+    // Result is the fax id on success and an error message otherwise
+    var result string
+    if err := json.Unmarshal(out.Result, &result); err != nil {
+        result = string(out.Result)
+    }
+    if out.Status != "Success" {
+        return DeliveryReceipt{}, fmt.Errorf("srfax: %s", result)
+    }
+    return DeliveryReceipt{Provider: "srfax", ProviderID: result, Status: "queued"}, nil
+}
+
+// faxCall sends req and decodes the JSON answer into out
+func faxCall(req *http.Request, out interface{}) error {
+    resp, err := faxClient.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    if err != nil {
+        return err
+    }
+    if err := json.Unmarshal(data, out); err != nil {
+        if resp.StatusCode >= 300 {
+            return fmt.Errorf("HTTP %d", resp.StatusCode)
+        }
+        return fmt.Errorf("unexpected response: %v", err)
+    }
+    return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "context"
+    "crypto"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/base64"
+    "encoding/json"
+    "encoding/pem"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ===========================
+   Google service-account auth
+   =========================== */
+
+// Google APIs are called with an access token minted from a service-account
+// key file (GOOGLE_APPLICATION_CREDENTIALS, the JSON downloaded from the
+// Cloud console) through the JWT bearer grant. It is one signed POST, so no
+// SDK is pulled in for it.
+
+type googleCredentials struct {
+    ClientEmail string `json:"client_email"`
+    PrivateKey  string `json:"private_key"`
+    TokenURI    string `json:"token_uri"`
+}
+
+type googleTokenSource struct {
+    email    string
+    tokenURI string
+    key      *rsa.PrivateKey
+    scope    string
+    client   *http.Client
+
+    mu      sync.Mutex
+    token   string
+    expires time.Time
+}
+
+// newGoogleTokenSource reads the service-account key at path for scope
+func newGoogleTokenSource(path, scope string) (*googleTokenSource, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    var creds googleCredentials
+    if err := json.Unmarshal(data, &creds); err != nil {
+        return nil, fmt.Errorf("parse %s: %w", path, err)
+    }
+    block, _ := pem.Decode([]byte(creds.PrivateKey))
+    if block == nil || creds.ClientEmail == "" {
+        return nil, fmt.Errorf("%s is not a service-account key", path)
+    }
+    parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+    if err != nil {
+        return nil, fmt.Errorf("%s: %w", path, err)
+    }
+    key, ok := parsed.(*rsa.PrivateKey)
+    if !ok {
+        return nil, fmt.Errorf("%s: private key is not RSA", path)
+    }
+    if creds.TokenURI == "" {
+        creds.TokenURI = "https://oauth2.googleapis.com/token"
+    }
+    return &googleTokenSource{
+        email:    creds.ClientEmail,
+        tokenURI: creds.TokenURI,
+        key:      key,
+        scope:    scope,
+        client:   &http.Client{Timeout: 10 * time.Second},
+    }, nil
+}
+
+// Token returns a cached access token, fetching a new one near expiry
+func (g *googleTokenSource) Token(ctx context.Context) (string, error) {
+    g.mu.Lock()
+    defer g.mu.Unlock()
+    if g.token != "" && time.Now().Before(g.expires) {
+        return g.token, nil
+    }
+
+    assertion, err := g.assertion(time.Now())
+    if err != nil {
+        return "", err
+    }
+    form := url.Values{
+        "grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+        "assertion":  {assertion},
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.tokenURI, strings.NewReader(form.Encode()))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    resp, err := g.client.Do(req)
+    if err != nil {
+        return "", fmt.Errorf("google token: %w", err)
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+    var out struct {
+        AccessToken string `json:"access_token"`
+        ExpiresIn   int    `json:"expires_in"`
+        Error       string `json:"error_description"`
+    }
+    if err := json.Unmarshal(body, &out); err != nil || out.AccessToken == "" {
+        return "", fmt.Errorf("google token: %s %s", resp.Status, strings.TrimSpace(out.Error))
+    }
+    g.token = out.AccessToken
+    g.expires = time.Now().Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute)
+    return g.token, nil
+}
+
+// assertion is the RS256-signed JWT exchanged for a token
+func (g *googleTokenSource) assertion(t time.Time) (string, error) {
+    enc := base64.RawURLEncoding
+    header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+    claims, _ := json.Marshal(map[string]interface{}{
+        "iss":   g.email,
+        "scope": g.scope,
+        "aud":   g.tokenURI,
+        "iat":   t.Unix(),
+        "exp":   t.Add(time.Hour).Unix(),
+    })
+    signing := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+    sum := sha256.Sum256([]byte(signing))
+    sig, err := rsa.SignPKCS1v15(rand.Reader, g.key, crypto.SHA256, sum[:])
+    if err != nil {
+        return "", err
+    }
+    return signing + "." + enc.EncodeToString(sig), nil
+}
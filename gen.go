@@ -0,0 +1,247 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==================
+   Generation context
+   ================== */
+
+// genContext carries everything one generation needs. It is built per
+// request from a deep copy of the payload and never shared, so no
+// excelize.File, slice or map in the pipeline is reachable from another
+// goroutine. Package-level state touched by generation is read-only
+// (tenant config, default cell maps) or lock-protected (template files).
+type genContext struct {
+    ctx    context.Context // carries the trace span of the caller
+    req    TimecardRequest
+    tenant *Tenant
+    theme  *Theme
+    loc    *time.Location // the tenant's zone; entry dates are read as days here
+    tpl    *templateDef   // resolved by generateExcelFile
+    lg     *requestLogger
+    // printWeek lays the workbook out for the PDF of that week alone, or
+    // of all weeks with printAllWeeks (pdflayout.go); 0 leaves it as is
+    printWeek int
+}
+
+func newGenContext(ctx context.Context, req TimecardRequest, tenant *Tenant, lg *requestLogger) *genContext {
+    return &genContext{
+        ctx:    ctx,
+        req:    cloneRequest(req),
+        tenant: tenant,
+        theme:  tenant.theme(),
+        loc:    cardLocation(req, tenant),
+        lg:     lg,
+    }
+}
+
+// tenantID is the generating tenant, default when there is none
+func (gc *genContext) tenantID() string {
+    if gc.tenant == nil {
+        return defaultTenantID
+    }
+    return gc.tenant.ID
+}
+
+// cloneRequest copies every slice and pointer in req so the generation
+// can't alias memory the caller (or tenant defaults) still hold.
+func cloneRequest(req TimecardRequest) TimecardRequest {
+    out := req
+    out.Jobs = append([]Job(nil), req.Jobs...)
+    out.Entries = append([]Entry(nil), req.Entries...)
+    if req.Weeks != nil {
+        out.Weeks = make([]WeekData, len(req.Weeks))
+        for i, wk := range req.Weeks {
+            wk.Entries = append([]Entry(nil), wk.Entries...)
+            out.Weeks[i] = wk
+        }
+    }
+    if req.BreaksDeducted != nil {
+        out.BreaksDeducted = make([]BreakDeduction, len(req.BreaksDeducted))
+        for i, b := range req.BreaksDeducted {
+            b.Jobs = append([]string(nil), b.Jobs...)
+            out.BreaksDeducted[i] = b
+        }
+    }
+    if req.Punches != nil {
+        out.Punches = make([]Punch, len(req.Punches))
+        for i, p := range req.Punches {
+            p.Breaks = append([]PunchBreak(nil), p.Breaks...)
+            out.Punches[i] = p
+        }
+    }
+    out.Expenses = append([]Expense(nil), req.Expenses...)
+    out.Travel = append([]TravelLine(nil), req.Travel...)
+    out.EmployeeSignature = clonePtr(req.EmployeeSignature)
+    out.SupervisorSignature = clonePtr(req.SupervisorSignature)
+    out.PageSetup = clonePtr(req.PageSetup)
+    return out
+}
+
+// clonePtr points to a copy of *p, or is nil with p
+func clonePtr[T any](p *T) *T {
+    if p == nil {
+        return nil
+    }
+    v := *p
+    return &v
+}
+
+/* ----- race harness -----
+
+   POST /debug/race-harness?n=32 renders the same card n times concurrently
+   and compares every result against a serial reference. Run the binary
+   built with `go build -race` to have the detector check the pipeline too.
+   Disabled unless ENABLE_DEBUG_ENDPOINTS=1.
+*/
+
+const maxHarnessRuns = 256
+
+func raceHarnessHandler(w http.ResponseWriter, r *http.Request) {
+    if os.Getenv("ENABLE_DEBUG_ENDPOINTS") != "1" {
+        http.NotFound(w, r)
+        return
+    }
+    if r.Method != http.MethodPost {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    lg := loggerFrom(r.Context())
+
+    n := 32
+    if v := r.URL.Query().Get("n"); v != "" {
+        parsed, err := strconv.Atoi(v)
+        if err != nil || parsed < 1 || parsed > maxHarnessRuns {
+            httpError(w, r, fmt.Sprintf("n must be 1..%d", maxHarnessRuns), http.StatusBadRequest)
+            return
+        }
+        n = parsed
+    }
+
+    req := harnessRequest()
+    if body, _ := io.ReadAll(io.LimitReader(r.Body, 1<<20)); len(strings.TrimSpace(string(body))) > 0 {
+        if err := json.Unmarshal(body, &req); err != nil {
+            httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+            return
+        }
+    }
+    tenant := tenantFor(r)
+
+    reference, err := generateExcelFile(newGenContext(r.Context(), req, tenant, lg))
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("reference render failed: %v", err), http.StatusInternalServerError)
+        return
+    }
+    want, err := workbookFingerprint(reference)
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("reference unreadable: %v", err), http.StatusInternalServerError)
+        return
+    }
+
+    start := time.Now()
+    var (
+        wg         sync.WaitGroup
+        mu         sync.Mutex
+        mismatches = []string{}
+        rejected   int // turned away by the generation limiter, not a failure
+    )
+    for i := 0; i < n; i++ {
+        wg.Add(1)
+        go func(i int) {
+            defer wg.Done()
+            ctx := withPriority(r.Context(), priorityBatch) // don't crowd out real users
+            data, err := generateExcelFile(newGenContext(ctx, req, tenant, nil))
+            var got string
+            if err == nil {
+                got, err = workbookFingerprint(data)
+            }
+            mu.Lock()
+            defer mu.Unlock()
+            if errors.Is(err, errBusy) {
+                rejected++
+            } else if err != nil {
+                mismatches = append(mismatches, fmt.Sprintf("run %d: %v", i, err))
+            } else if got != want {
+                mismatches = append(mismatches, fmt.Sprintf("run %d: content differs from reference", i))
+            }
+        }(i)
+    }
+    wg.Wait()
+
+    lg.Printf("Race harness: %d runs, %d mismatches, %d rejected in %s", n, len(mismatches), rejected, time.Since(start))
+    status := http.StatusOK
+    if len(mismatches) > 0 {
+        status = http.StatusInternalServerError
+    }
+    writeJSON(w, status, map[string]interface{}{
+        "runs":        n,
+        "mismatches":  mismatches,
+        "rejected":    rejected,
+        "duration_ms": time.Since(start).Milliseconds(),
+        "reference":   want,
+    })
+}
+
+// workbookFingerprint hashes every sheet's cell values and formulas, which
+// is stable across runs (unlike the zip bytes).
+func workbookFingerprint(data []byte) (string, error) {
+    f, err := excelize.OpenReader(bytes.NewReader(data))
+    if err != nil {
+        return "", err
+    }
+    defer func() { _ = f.Close() }()
+
+    h := sha256.New()
+    for _, sheet := range f.GetSheetList() {
+        fmt.Fprintf(h, "[%s]\n", sheet)
+        rows, err := f.GetRows(sheet)
+        if err != nil {
+            return "", err
+        }
+        for r, row := range rows {
+            for c, v := range row {
+                cell, _ := excelize.CoordinatesToCellName(c+1, r+1)
+                formula, _ := f.GetCellFormula(sheet, cell)
+                fmt.Fprintf(h, "%s=%s|%s\n", cell, v, formula)
+            }
+        }
+    }
+    return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// harnessRequest is a two-week card touching regular, overtime and night columns
+func harnessRequest() TimecardRequest {
+    return TimecardRequest{
+        EmployeeName: "Harness Test",
+        PayPeriodNum: 1,
+        Year:         2025,
+        Jobs:         []Job{{JobCode: "29699", JobName: "201"}, {JobCode: "12215", JobName: "223"}},
+        Weeks: []WeekData{
+            {WeekNumber: 1, WeekStartDate: "2025-01-05T00:00:00Z", WeekLabel: "Week #1", Entries: []Entry{
+                {Date: "2025-01-06T00:00:00Z", JobCode: "29699", Hours: 8},
+                {Date: "2025-01-07T00:00:00Z", JobCode: "12215", Hours: 6, IsNightShift: true},
+                {Date: "2025-01-07T00:00:00Z", JobCode: "29699", Hours: 2, Overtime: true},
+            }},
+            {WeekNumber: 2, WeekStartDate: "2025-01-12T00:00:00Z", WeekLabel: "Week #2", Entries: []Entry{
+                {Date: "2025-01-13T00:00:00Z", JobCode: "29699", Hours: 9},
+            }},
+        },
+    }
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestCloneRequest(t *testing.T) {
+    req := TimecardRequest{
+        Jobs:                []Job{{JobCode: "29699"}},
+        Weeks:               []WeekData{{Entries: []Entry{{JobCode: "29699", Hours: 8, Notes: "pour"}}}},
+        BreaksDeducted:      []BreakDeduction{{Date: "2025-01-06", Jobs: []string{"29699"}}},
+        Punches:             []Punch{{JobCode: "29699", Breaks: []PunchBreak{{Start: "a", End: "b"}}}},
+        Expenses:            []Expense{{Type: "per_diem", Amount: 50}},
+        Travel:              []TravelLine{{Kilometres: 40}},
+        EmployeeSignature:   &Signature{Name: "Bob Smith"},
+        SupervisorSignature: &Signature{Name: "Ann Lee"},
+        PageSetup:           &PageSetup{Orientation: "landscape"},
+    }
+    out := cloneRequest(req)
+    if !reflect.DeepEqual(out, req) {
+        t.Fatalf("clone differs: %+v", out)
+    }
+
+    out.Jobs[0].JobCode = "x"
+    out.Weeks[0].Entries[0].Notes = "x"
+    out.BreaksDeducted[0].Jobs[0] = "x"
+    out.Punches[0].Breaks[0].Start = "x"
+    out.Expenses[0].Amount = 0
+    out.Travel[0].Kilometres = 0
+    out.EmployeeSignature.Name = "x"
+    out.SupervisorSignature.Name = "x"
+    out.PageSetup.Orientation = "x"
+    if req.Jobs[0].JobCode != "29699" || req.Weeks[0].Entries[0].Notes != "pour" || req.BreaksDeducted[0].Jobs[0] != "29699" ||
+        req.Punches[0].Breaks[0].Start != "a" || req.Expenses[0].Amount != 50 || req.Travel[0].Kilometres != 40 ||
+        req.EmployeeSignature.Name != "Bob Smith" || req.SupervisorSignature.Name != "Ann Lee" || req.PageSetup.Orientation != "landscape" {
+        t.Errorf("clone shares memory with the request: %+v", req)
+    }
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+    "bufio"
+    "context"
+    "fmt"
+    "net"
+    "net/http"
+    "os"
+    "os/exec"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ====================
+   Deep health checks
+   ==================== */
+
+const healthCheckTimeout = 5 * time.Second
+
+type dependencyStatus struct {
+    Status    string `json:"status"` // ok, fail, skipped
+    Detail    string `json:"detail,omitempty"`
+    LatencyMS int64  `json:"latency_ms"`
+}
+
+type healthCheck struct {
+    name string
+    run  func(ctx context.Context) (detail string, err error)
+}
+
+// errSkipped marks a dependency that isn't configured on this instance
+var errSkipped = fmt.Errorf("not configured")
+
+func dependencyChecks() []healthCheck {
+    return []healthCheck{
+        {"template", checkTemplate},
+        {"libreoffice", checkSoffice},
+        {"libreoffice_circuit", sofficeBreaker.check},
+        {"smtp", checkSMTP},
+    }
+}
+
+// readyHandler actively probes every dependency and returns 200 only when
+// none of them failed, so a load balancer can pull half-broken instances.
+func readyHandler(w http.ResponseWriter, r *http.Request) {
+    ctx, cancel := context.WithTimeout(r.Context(), healthCheckTimeout)
+    defer cancel()
+
+    checks := dependencyChecks()
+    results := make(map[string]dependencyStatus, len(checks))
+    var mu sync.Mutex
+    var wg sync.WaitGroup
+    for _, c := range checks {
+        wg.Add(1)
+        go func(c healthCheck) {
+            defer wg.Done()
+            start := time.Now()
+            detail, err := c.run(ctx)
+            st := dependencyStatus{Status: "ok", Detail: detail, LatencyMS: time.Since(start).Milliseconds()}
+            if err == errSkipped {
+                st.Status = "skipped"
+                st.Detail = err.Error()
+            } else if err != nil {
+                st.Status = "fail"
+                st.Detail = err.Error()
+            }
+            mu.Lock()
+            results[c.name] = st
+            mu.Unlock()
+        }(c)
+    }
+    wg.Wait()
+
+    status := http.StatusOK
+    overall := "ok"
+    for _, st := range results {
+        if st.Status == "fail" {
+            status = http.StatusServiceUnavailable
+            overall = "fail"
+        }
+    }
+    writeJSON(w, status, map[string]interface{}{
+        "status":       overall,
+        "dependencies": results,
+    })
+}
+
+func checkTemplate(ctx context.Context) (string, error) {
+    const templatePath = "template.xlsx"
+    f, err := excelize.OpenFile(templatePath)
+    if err != nil {
+        return "", fmt.Errorf("open %s: %w", templatePath, err)
+    }
+    defer func() { _ = f.Close() }()
+    sheets := f.GetSheetList()
+    if len(sheets) == 0 {
+        return "", fmt.Errorf("no sheets in template")
+    }
+    return fmt.Sprintf("%d sheets", len(sheets)), nil
+}
+
+func checkSoffice(ctx context.Context) (string, error) {
+    out, err := exec.CommandContext(ctx, "soffice", "--version").CombinedOutput()
+    if err != nil {
+        return "", fmt.Errorf("soffice --version: %w", err)
+    }
+    return strings.TrimSpace(string(out)), nil
+}
+
+// checkSMTP opens a TCP connection and waits for the server's 220 greeting.
+// It does not authenticate, so it never consumes a login attempt.
+func checkSMTP(ctx context.Context) (string, error) {
+    host, port := os.Getenv("SMTP_HOST"), os.Getenv("SMTP_PORT")
+    if host == "" || port == "" {
+        return "", errSkipped
+    }
+    var d net.Dialer
+    conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(host, port))
+    if err != nil {
+        return "", fmt.Errorf("dial: %w", err)
+    }
+    defer conn.Close()
+    if deadline, ok := ctx.Deadline(); ok {
+        _ = conn.SetDeadline(deadline)
+    }
+    greeting, err := bufio.NewReader(conn).ReadString('\n')
+    if err != nil {
+        return "", fmt.Errorf("read greeting: %w", err)
+    }
+    if !strings.HasPrefix(greeting, "220") {
+        return "", fmt.Errorf("unexpected greeting: %s", strings.TrimSpace(greeting))
+    }
+    _, _ = conn.Write([]byte("QUIT\r\n"))
+    return strings.TrimSpace(greeting), nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "regexp"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==================
+   Statutory holidays
+   ================== */
+
+// Stat pay is entered as its own kind of entry,
+//
+//   {"date": "2025-02-17", "type": "holiday", "hours": 8}
+//
+// which goes in a column of its own: the tenant's holiday job number and
+// labour code (default STAT and H) whatever job_code the entry names,
+// filled in a colour of its own. The tenant lists its holidays with
+//
+//   "holidays": {"labour_code": "H", "job_number": "STAT", "fill": "FFF2CC",
+//                "dates": [{"date": "2025-02-17", "name": "Family Day"}]}
+//
+// and validation keeps the two kinds of hours apart: regular hours on a
+// listed holiday need a holiday entry beside them, a holiday entry needs a
+// listed holiday (when any are listed), stat pay is never overtime or
+// night shift, and worked hours cannot be booked to the holiday job.
+//
+//   GET /api/holidays?year=    the tenant's holidays, for one year or all
+
+const entryHoliday = "holiday"
+
+// Holiday is one statutory holiday
+type Holiday struct {
+    Date string `json:"date"` // YYYY-MM-DD
+    Name string `json:"name,omitempty"`
+}
+
+// HolidayCalendar is a tenant's holidays and how stat pay goes on the card
+type HolidayCalendar struct {
+    LabourCode string    `json:"labour_code,omitempty"` // default "H"
+    JobNumber  string    `json:"job_number,omitempty"`  // default "STAT"
+    Fill       string    `json:"fill,omitempty"`        // hex RGB, default "FFF2CC"
+    Dates      []Holiday `json:"dates,omitempty"`
+}
+
+var hexColor = regexp.MustCompile(`^[0-9A-Fa-f]{6}$`)
+
+func (c *HolidayCalendar) validate() error {
+    for _, h := range c.Dates {
+        if _, err := time.Parse("2006-01-02", h.Date); err != nil {
+            return fmt.Errorf("holiday %q: date must be YYYY-MM-DD", h.Date)
+        }
+    }
+    if c.Fill != "" && !hexColor.MatchString(c.Fill) {
+        return fmt.Errorf("holiday fill %q must be hex RGB, e.g. FFF2CC", c.Fill)
+    }
+    return nil
+}
+
+func (c *HolidayCalendar) labourCode() string {
+    if c != nil && c.LabourCode != "" {
+        return c.LabourCode
+    }
+    return "H"
+}
+
+func (c *HolidayCalendar) jobNumber() string {
+    if c != nil && c.JobNumber != "" {
+        return c.JobNumber
+    }
+    return "STAT"
+}
+
+func (c *HolidayCalendar) fill() string {
+    if c != nil && c.Fill != "" {
+        return c.Fill
+    }
+    return "FFF2CC"
+}
+
+// on returns the holiday falling on date (YYYY-MM-DD)
+func (c *HolidayCalendar) on(date string) (Holiday, bool) {
+    if c == nil {
+        return Holiday{}, false
+    }
+    for _, h := range c.Dates {
+        if h.Date == date {
+            return h, true
+        }
+    }
+    return Holiday{}, false
+}
+
+// holidays returns the tenant's holiday calendar, or nil when none is configured
+func (t *Tenant) holidays() *HolidayCalendar {
+    if t == nil {
+        return nil
+    }
+    return t.Holidays
+}
+
+// checkHolidays keeps stat pay and worked hours apart on listed holidays;
+// checkEntryTypes does the rest (see the top of this file)
+func checkHolidays(v *validationResult, req TimecardRequest, t *Tenant) {
+    cal := t.holidays()
+    loc := cardLocation(req, t)
+    worked := map[string][]string{} // date -> jobs with regular hours
+    stat := map[string]bool{}
+    for _, e := range allEntries(req) {
+        day, err := parseCalendarDate(e.Date, loc)
+        if err != nil || e.Hours == 0 {
+            continue
+        }
+        date := day.Format("2006-01-02")
+        switch {
+        case e.Type == entryHoliday:
+            stat[date] = true
+            if _, ok := cal.on(date); !ok && cal != nil && len(cal.Dates) > 0 {
+                v.errorf("%s: holiday entry on a day that is not a holiday", date)
+            }
+        case e.worked() && !e.Overtime:
+            worked[date] = append(worked[date], e.JobCode)
+        }
+    }
+
+    dates := make([]string, 0, len(worked))
+    for date := range worked {
+        dates = append(dates, date)
+    }
+    sort.Strings(dates)
+    for _, date := range dates {
+        h, ok := cal.on(date)
+        if !ok {
+            continue
+        }
+        name := h.Name
+        if name == "" {
+            name = "a holiday"
+        }
+        jobs := strings.Join(worked[date], ", ")
+        if stat[date] {
+            v.warnf("%s is %s: regular hours on job %s are paid on top of stat pay", date, name, jobs)
+        } else {
+            v.errorf("%s is %s: enter stat pay as a holiday entry, not as regular hours (job %s)", date, name, jobs)
+        }
+    }
+}
+
+// styleHolidayColumn fills the regular table's holiday column, header and
+// days, in the holiday colour; the cells keep the rest of their style
+func styleHolidayColumn(gc *genContext, f *excelize.File, sheet string, regularKeys []string) {
+    cal := gc.tenant.holidays()
+    cm := gc.tpl.CellMap
+    for i, key := range regularKeys {
+        if key != cal.jobNumber() || i >= len(cm.CodeColumns) {
+            continue
+        }
+        cells := []string{
+            fmt.Sprintf("%s%d", cm.CodeColumns[i], cm.RegularHeaderRow),
+            fmt.Sprintf("%s%d", cm.JobColumns[i], cm.RegularHeaderRow),
+        }
+        for d := 0; d < 7; d++ {
+            cells = append(cells, fmt.Sprintf("%s%d", cm.CodeColumns[i], cm.RegularFirstRow+d))
+        }
+        styles := map[int]int{}
+        for _, cell := range cells {
+            if err := fillCell(f, sheet, cell, cal.fill(), styles); err != nil {
+                gc.lg.Printf("Warning: holiday fill %s!%s: %v", sheet, cell, err)
+            }
+        }
+    }
+}
+
+// fillCell gives cell a solid fill, deriving one style per source style
+func fillCell(f *excelize.File, sheet, cell, color string, styles map[int]int) error {
+    base, err := f.GetCellStyle(sheet, cell)
+    if err != nil {
+        return err
+    }
+    id, ok := styles[base]
+    if !ok {
+        st, err := f.GetStyle(base)
+        if err != nil {
+            return err
+        }
+        st.Fill = excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{color}}
+        if id, err = f.NewStyle(st); err != nil {
+            return err
+        }
+        styles[base] = id
+    }
+    return f.SetCellStyle(sheet, cell, cell, id)
+}
+
+type holidayView struct {
+    Holiday
+    Weekday string `json:"weekday"`
+}
+
+// holidaysHandler serves GET /api/holidays (see the top of this file)
+func holidaysHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    cal := tenantFor(r).holidays()
+    year := 0
+    if r.URL.Query().Get("year") != "" {
+        y, err := queryInts(r, "year")
+        if err != nil {
+            httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        year = y[0]
+    }
+    out := []holidayView{}
+    if cal != nil {
+        for _, h := range cal.Dates {
+            day, err := time.Parse("2006-01-02", h.Date)
+            if err != nil || (year != 0 && day.Year() != year) {
+                continue
+            }
+            out = append(out, holidayView{Holiday: h, Weekday: day.Weekday().String()})
+        }
+    }
+    sort.Slice(out, func(a, b int) bool { return out[a].Date < out[b].Date })
+    writeJSON(w, http.StatusOK, map[string]interface{}{
+        "labour_code": cal.labourCode(),
+        "job_number":  cal.jobNumber(),
+        "holidays":    out,
+    })
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "math"
+    "os"
+    "strings"
+    "time"
+)
+
+/* ================
+   iCalendar export
+   ================ */
+
+// The "ics" export turns a card into a calendar with one event per entry,
+// so an employee can lay it over their own calendar and spot a missing or
+// doubled day. Cards don't record clock times, so each day's entries are
+// stacked back to back from ICS_DAY_START (default 07:00), night-shift
+// entries from ICS_NIGHT_START (default 19:00), in floating local time;
+// the event's DURATION is the hours worked. The title carries the job
+// number and labour code, with "OT" or "night" when it applies.
+
+func init() {
+    registerExporter(icsExporter{})
+}
+
+type icsExporter struct{}
+
+func (icsExporter) Name() string        { return "ics" }
+func (icsExporter) ContentType() string { return "text/calendar; charset=utf-8" }
+
+func (icsExporter) FileName(t *Tenant, reqs []TimecardRequest) string {
+    return exportFileName(reqs, "calendar", "ics")
+}
+
+func (icsExporter) Export(t *Tenant, reqs []TimecardRequest) ([]byte, error) {
+    dayStart, err := icsClock("ICS_DAY_START", 7*time.Hour)
+    if err != nil {
+        return nil, err
+    }
+    nightStart, err := icsClock("ICS_NIGHT_START", 19*time.Hour)
+    if err != nil {
+        return nil, err
+    }
+    stamp := now().UTC().Format("20060102T150405Z")
+    loc := t.location()
+
+    var b bytes.Buffer
+    icsLine(&b, "BEGIN:VCALENDAR")
+    icsLine(&b, "VERSION:2.0")
+    icsLine(&b, "PRODID:-//Timecard API//Timecard export//EN")
+    icsLine(&b, "CALSCALE:GREGORIAN")
+    icsLine(&b, "METHOD:PUBLISH")
+    if len(reqs) == 1 {
+        req := reqs[0]
+        icsLine(&b, "X-WR-CALNAME:"+icsText(fmt.Sprintf("%s PP%02d %d", req.EmployeeName, req.PayPeriodNum, req.Year)))
+    }
+    for _, req := range reqs {
+        codes := make(map[string]string, len(req.Jobs))
+        for _, j := range req.Jobs {
+            codes[j.JobCode] = j.JobName
+        }
+        // next free start per day and shift
+        type slot struct {
+            day   time.Time
+            night bool
+        }
+        next := map[slot]time.Duration{}
+        for i, e := range allEntries(req) {
+            day, err := parseCalendarDate(e.Date, loc)
+            if err != nil || e.Hours <= 0 {
+                continue
+            }
+            s := slot{day, e.IsNightShift}
+            offset, ok := next[s]
+            if !ok {
+                offset = dayStart
+                if e.IsNightShift {
+                    offset = nightStart
+                }
+            }
+            length := time.Duration(math.Round(e.Hours*60)) * time.Minute
+            next[s] = offset + length
+
+            summary := "Job " + e.JobCode
+            if code := codes[e.JobCode]; code != "" {
+                summary += " (" + code + ")"
+            }
+            switch {
+            case e.IsNightShift && e.Overtime:
+                summary += " night OT"
+            case e.IsNightShift:
+                summary += " night"
+            case e.Overtime:
+                summary += " OT"
+            }
+            summary += " " + clockDuration(e.Hours)
+
+            icsLine(&b, "BEGIN:VEVENT")
+            icsLine(&b, "UID:"+icsUID(req, i))
+            icsLine(&b, "DTSTAMP:"+stamp)
+            icsLine(&b, "DTSTART:"+day.Add(offset).Format("20060102T150405"))
+            icsLine(&b, "DURATION:"+icsDuration(length))
+            icsLine(&b, "SUMMARY:"+icsText(summary))
+            icsLine(&b, "DESCRIPTION:"+icsText(fmt.Sprintf("%s, pay period %d/%d: %g h", req.EmployeeName, req.PayPeriodNum, req.Year, e.Hours)))
+            icsLine(&b, "TRANSP:TRANSPARENT")
+            icsLine(&b, "END:VEVENT")
+        }
+    }
+    icsLine(&b, "END:VCALENDAR")
+    return b.Bytes(), nil
+}
+
+// icsClock reads an HH:MM time of day from the environment
+func icsClock(key string, def time.Duration) (time.Duration, error) {
+    v := os.Getenv(key)
+    if v == "" {
+        return def, nil
+    }
+    t, err := time.Parse("15:04", v)
+    if err != nil {
+        return 0, fmt.Errorf("%s must be HH:MM", key)
+    }
+    return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// icsUID identifies the i'th entry of a card, stable across exports of it
+func icsUID(req TimecardRequest, i int) string {
+    sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%d", req.EmployeeName, req.Year, req.PayPeriodNum)))
+    return fmt.Sprintf("%s-%d@timecard", hex.EncodeToString(sum[:8]), i)
+}
+
+// icsDuration formats d as an RFC 5545 duration (PT7H30M)
+func icsDuration(d time.Duration) string {
+    h, m := int(d/time.Hour), int(d%time.Hour/time.Minute)
+    switch {
+    case m == 0:
+        return fmt.Sprintf("PT%dH", h)
+    case h == 0:
+        return fmt.Sprintf("PT%dM", m)
+    }
+    return fmt.Sprintf("PT%dH%dM", h, m)
+}
+
+// icsText escapes a TEXT value
+func icsText(s string) string {
+    return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(s)
+}
+
+// icsLine writes a content line, folded at 75 octets without splitting a
+// UTF-8 sequence
+func icsLine(b *bytes.Buffer, line string) {
+    limit := 75
+    for len(line) > limit {
+        cut := limit
+        for cut > 0 && line[cut]&0xC0 == 0x80 {
+            cut--
+        }
+        b.WriteString(line[:cut])
+        b.WriteString("\r\n ")
+        line = line[cut:]
+        limit = 74 // the leading space counts
+    }
+    b.WriteString(line)
+    b.WriteString("\r\n")
+}
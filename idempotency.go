@@ -0,0 +1,159 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ================
+   Idempotency keys
+   ================ */
+
+// A client that retries /api/email-timecard after a timeout sends the same
+// Idempotency-Key header. The first request with a key claims it; once it
+// succeeds its response is stored and replayed verbatim to any retry, so
+// payroll gets one email. A retry that arrives while the first attempt is
+// still running gets 409; a failed attempt releases the key so a retry
+// really retries. Keys are scoped per tenant and kept IDEMPOTENCY_TTL
+// (default 24h).
+//
+// A key still in flight after IDEMPOTENCY_IN_FLIGHT_TTL (default 10m) was
+// held by an attempt that died with the process; the next retry claims it
+// afresh instead of getting 409 until the key expires.
+
+const (
+    idempotencyInFlight  = "in_flight"
+    idempotencyCompleted = "completed"
+)
+
+type idempotencyRecord struct {
+    Key         string          `json:"key"`
+    Tenant      string          `json:"tenant"`
+    PayloadHash string          `json:"payload_hash"`
+    State       string          `json:"state"`
+    Status      int             `json:"status,omitempty"`
+    Response    json.RawMessage `json:"response,omitempty"`
+    CreatedAt   time.Time       `json:"created_at"`
+}
+
+var (
+    idempotencyMu   sync.Mutex // claim is check-then-put
+    idempotencyKeys = openCollection[idempotencyRecord]("idempotency")
+)
+
+// idempotencyClaim is a request's hold on its key; nil when no key was sent
+type idempotencyClaim struct {
+    id       string
+    at       time.Time // the record's CreatedAt, to tell it from a reclaimed one
+    finished bool
+}
+
+// claimIdempotencyKey handles the Idempotency-Key header of r. When it
+// returns ok=false the response has already been written (a replay or a
+// conflict) and the handler must stop.
+func claimIdempotencyKey(w http.ResponseWriter, r *http.Request, payload []byte) (claim *idempotencyClaim, ok bool) {
+    key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+    if key == "" {
+        return nil, true
+    }
+    if len(key) > 255 {
+        httpError(w, r, "Idempotency-Key too long", http.StatusBadRequest)
+        return nil, false
+    }
+    tenant := tenantFor(r).ID
+    sum := sha256.Sum256(payload)
+    hash := hex.EncodeToString(sum[:])
+    id := tenant + "/" + key
+
+    idempotencyMu.Lock()
+    defer idempotencyMu.Unlock()
+    pruneIdempotencyKeysLocked()
+
+    rec, exists := idempotencyKeys.Get(id)
+    if exists && rec.PayloadHash == hash && rec.abandoned() {
+        loggerFrom(r.Context()).Printf("Reclaiming Idempotency-Key %q, in flight since %s", key, rec.CreatedAt.Format(time.RFC3339))
+        exists = false
+    }
+    if exists {
+        switch {
+        case rec.PayloadHash != hash:
+            httpError(w, r, "Idempotency-Key was already used with a different request", http.StatusUnprocessableEntity)
+        case rec.State == idempotencyInFlight:
+            w.Header().Set("Retry-After", "5")
+            httpError(w, r, "a request with this Idempotency-Key is still in progress", http.StatusConflict)
+        default:
+            loggerFrom(r.Context()).Printf("Replaying response for Idempotency-Key %q", key)
+            w.Header().Set("Idempotent-Replayed", "true")
+            w.Header().Set("Content-Type", "application/json")
+            w.WriteHeader(rec.Status)
+            _, _ = w.Write(rec.Response)
+        }
+        return nil, false
+    }
+
+    rec = idempotencyRecord{
+        Key:         key,
+        Tenant:      tenant,
+        PayloadHash: hash,
+        State:       idempotencyInFlight,
+        CreatedAt:   now().UTC(),
+    }
+    if err := idempotencyKeys.Put(id, rec); err != nil {
+        httpError(w, r, "could not record Idempotency-Key", http.StatusInternalServerError)
+        return nil, false
+    }
+    return &idempotencyClaim{id: id, at: rec.CreatedAt}, true
+}
+
+// abandoned reports whether rec has been in flight longer than any attempt
+// runs
+func (rec idempotencyRecord) abandoned() bool {
+    return rec.State == idempotencyInFlight &&
+        rec.CreatedAt.Before(now().Add(-envDuration("IDEMPOTENCY_IN_FLIGHT_TTL", 10*time.Minute)))
+}
+
+// complete stores the response to replay for this key
+func (c *idempotencyClaim) complete(status int, resp interface{}) {
+    if c == nil {
+        return
+    }
+    c.finished = true
+    data, err := json.Marshal(resp)
+    if err != nil {
+        return
+    }
+    idempotencyMu.Lock()
+    defer idempotencyMu.Unlock()
+    rec, ok := idempotencyKeys.Get(c.id)
+    if !ok || !rec.CreatedAt.Equal(c.at) {
+        return
+    }
+    rec.State, rec.Status, rec.Response = idempotencyCompleted, status, data
+    _ = idempotencyKeys.Put(c.id, rec)
+}
+
+// release frees the key if the request didn't complete; defer it
+func (c *idempotencyClaim) release() {
+    if c == nil || c.finished {
+        return
+    }
+    idempotencyMu.Lock()
+    defer idempotencyMu.Unlock()
+    if rec, ok := idempotencyKeys.Get(c.id); ok && rec.CreatedAt.Equal(c.at) {
+        _ = idempotencyKeys.Delete(c.id)
+    }
+}
+
+func pruneIdempotencyKeysLocked() {
+    cutoff := now().Add(-envDuration("IDEMPOTENCY_TTL", 24*time.Hour))
+    for _, rec := range idempotencyKeys.List() {
+        if rec.CreatedAt.Before(cutoff) {
+            _ = idempotencyKeys.Delete(rec.Tenant + "/" + rec.Key)
+        }
+    }
+}
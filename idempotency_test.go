@@ -0,0 +1,53 @@
+package main
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+    "time"
+)
+
+func TestIdempotencyKeyAbandoned(t *testing.T) {
+    t.Setenv("DATA_DIR", t.TempDir())
+    saved := idempotencyKeys
+    idempotencyKeys = openCollection[idempotencyRecord]("idempotency")
+    t.Cleanup(func() { idempotencyKeys = saved })
+
+    payload := []byte(`{"employee_name": "Bob Smith"}`)
+    sum := sha256.Sum256(payload)
+    claim := func() (*idempotencyClaim, int) {
+        r := httptest.NewRequest(http.MethodPost, "/api/email-timecard", nil)
+        r.Header.Set("Idempotency-Key", "k1")
+        w := httptest.NewRecorder()
+        c, ok := claimIdempotencyKey(w, r, payload)
+        if ok {
+            return c, http.StatusOK
+        }
+        return nil, w.Code
+    }
+    put := func(age time.Duration) {
+        _ = idempotencyKeys.Put(defaultTenantID+"/k1", idempotencyRecord{Key: "k1", Tenant: defaultTenantID,
+            PayloadHash: hex.EncodeToString(sum[:]), State: idempotencyInFlight, CreatedAt: now().UTC().Add(-age)})
+    }
+
+    put(time.Minute)
+    if _, code := claim(); code != http.StatusConflict {
+        t.Errorf("retry during an attempt: %d", code)
+    }
+
+    // the attempt died with the process an hour ago
+    put(time.Hour)
+    c, code := claim()
+    if code != http.StatusOK {
+        t.Fatalf("retry after a crashed attempt: %d", code)
+    }
+    if _, code := claim(); code != http.StatusConflict {
+        t.Errorf("second retry while the reclaimed one runs: %d", code)
+    }
+    c.release()
+    if _, code := claim(); code != http.StatusOK {
+        t.Errorf("retry after a failed attempt: %d", code)
+    }
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+    "context"
+    "encoding/csv"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+/* ===============
+   CSV time import
+   =============== */
+
+// Site supervisors keep hours in a spreadsheet first. Its CSV export is
+// turned into cards with
+//
+//   POST /api/import/csv?generate=xlsx|pdf&pay_period_num=&year=&week_start=
+//
+// The first line names the columns, in any order and any case:
+//
+//   employee,employee_id,date,job,labour_code,hours,ot,night,pay_period,year
+//   Bob Smith,,2025-01-06,29699,201,8,,,7,2025
+//
+// employee (or employee_id, to take the name from the directory), date
+// (YYYY-MM-DD), job and hours are required; ot and night are true for
+// 1/y/yes/true/x. payroll_number keeps employees with the same name on
+// cards of their own. type marks holiday and leave rows (leave.go), which
+// need no job; notes go on the card with the row's hours (notes.go), and
+// phase and cost_code below the job (costcodes.go). Rows are grouped into
+// one card per employee and pay period: the row's pay_period and year,
+// else the query's, else the tenant's pay calendar. Without a calendar the
+// two weeks start on the payroll week (weekstart.go) of the card's first
+// date, or on week_start.
+//
+// The response lists each card as a generation request, validated, plus
+// the rows that could not be read. With generate, the cards that passed
+// validation are rendered and kept by an "import-generate" job (202 with
+// the job; see /admin/jobs).
+
+const maxImportBytes = 5 << 20
+
+// importColumns maps the accepted header names to a column
+var importColumns = map[string]string{
+    "employee":       "employee",
+    "employee_name":  "employee",
+    "name":           "employee",
+    "employee_id":    "employee_id",
+    "payroll_number": "payroll_number",
+    "date":           "date",
+    "job":            "job",
+    "job_code":       "job",
+    "job_number":     "job",
+    "labour_code":    "labour_code",
+    "labor_code":     "labour_code",
+    "hours":          "hours",
+    "ot":             "ot",
+    "overtime":       "ot",
+    "night":          "night",
+    "night_shift":    "night",
+    "pay_period":     "pay_period",
+    "pay_period_num": "pay_period",
+    "year":           "year",
+    "type":           "type",
+    "notes":          "notes",
+    "note":           "notes",
+    "phase":          "phase",
+    "cost_code":      "cost_code",
+}
+
+// importedCard is one card built from the CSV
+type importedCard struct {
+    Request TimecardRequest  `json:"request"`
+    Rows    []int            `json:"rows"`
+    Valid   bool             `json:"valid"`
+    Result  validationResult `json:"validation"`
+
+    raw TimecardRequest // before tenant defaults, as generation takes it
+}
+
+// importRowError is a CSV row that was left out
+type importRowError struct {
+    Row   int    `json:"row"`
+    Error string `json:"error"`
+}
+
+// importGenerateParams are the params of an "import-generate" job
+type importGenerateParams struct {
+    Tenant string            `json:"tenant"`
+    Format string            `json:"format"`
+    Cards  []TimecardRequest `json:"cards"`
+}
+
+// importItem is one card's outcome in an "import-generate" job
+type importItem struct {
+    Employee     string `json:"employee"`
+    PayPeriodNum int    `json:"pay_period_num"`
+    Year         int    `json:"year"`
+    TimecardID   string `json:"timecard_id,omitempty"`
+    Reason       string `json:"reason,omitempty"`
+}
+
+type importResult struct {
+    Generated []importItem `json:"generated"`
+    Failed    []importItem `json:"failed"`
+}
+
+func init() {
+    registerJobKind("import-generate", runImportGenerateJob)
+}
+
+// importCSVHandler serves POST /api/import/csv (see the top of this file)
+func importCSVHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    q := r.URL.Query()
+    format := q.Get("generate")
+    switch format {
+    case "", "xlsx", "pdf":
+    case "1", "true":
+        format = "xlsx"
+    default:
+        httpError(w, r, "invalid request: generate must be xlsx or pdf", http.StatusBadRequest)
+        return
+    }
+    var period, year int
+    for _, p := range []struct {
+        name string
+        dst  *int
+    }{{"pay_period_num", &period}, {"year", &year}} {
+        if v := q.Get(p.name); v != "" {
+            n, err := strconv.Atoi(v)
+            if err != nil || n < 1 {
+                httpError(w, r, fmt.Sprintf("invalid request: %s must be a positive number", p.name), http.StatusBadRequest)
+                return
+            }
+            *p.dst = n
+        }
+    }
+    t := tenantFor(r)
+    var weekStart time.Time
+    if v := q.Get("week_start"); v != "" {
+        d, err := time.ParseInLocation("2006-01-02", v, t.location())
+        if err != nil {
+            httpError(w, r, "invalid request: week_start must be YYYY-MM-DD", http.StatusBadRequest)
+            return
+        }
+        weekStart = d
+    }
+
+    data, err := io.ReadAll(io.LimitReader(r.Body, maxImportBytes+1))
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if len(data) > maxImportBytes {
+        httpError(w, r, "CSV too large", http.StatusRequestEntityTooLarge)
+        return
+    }
+    cards, rowErrs, err := parseImportCSV(string(data), t, period, year, weekStart)
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("invalid CSV: %v", err), http.StatusBadRequest)
+        return
+    }
+
+    valid := []TimecardRequest{}
+    for _, c := range cards {
+        if c.Valid {
+            valid = append(valid, c.raw)
+        }
+    }
+    lg := loggerFrom(r.Context())
+    lg.Printf("CSV import: %d card(s), %d valid, %d row(s) skipped", len(cards), len(valid), len(rowErrs))
+    resp := map[string]interface{}{"cards": cards, "row_errors": rowErrs}
+    if format == "" || len(valid) == 0 {
+        writeJSON(w, http.StatusOK, resp)
+        return
+    }
+    owner := identityFrom(r.Context())
+    if owner == "" {
+        owner = clientIP(r)
+    }
+    job, err := enqueueJob("import-generate", owner, 0, importGenerateParams{Tenant: t.ID, Format: format, Cards: valid})
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("error queueing generation: %v", err), http.StatusInternalServerError)
+        return
+    }
+    lg.Printf("CSV import: generating %d card(s) as %s in job %s", len(valid), format, job.ID)
+    resp["job"] = job
+    writeJSON(w, http.StatusAccepted, resp)
+}
+
+// parseImportCSV groups the CSV's rows into validated cards. period and
+// year, when set, apply to rows without their own; weekStart starts the
+// cards of a tenant without a pay calendar.
+func parseImportCSV(data string, t *Tenant, period, year int, weekStart time.Time) ([]*importedCard, []importRowError, error) {
+    cr := csv.NewReader(strings.NewReader(strings.TrimPrefix(data, "\ufeff")))
+    cr.FieldsPerRecord = -1
+    cr.TrimLeadingSpace = true
+    header, err := cr.Read()
+    if err == io.EOF {
+        return nil, nil, errors.New("empty file")
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+    cols := map[string]int{}
+    for i, h := range header {
+        name := strings.NewReplacer(" ", "_", "-", "_").Replace(strings.ToLower(strings.TrimSpace(h)))
+        if col, ok := importColumns[name]; ok {
+            cols[col] = i
+        }
+    }
+    _, hasName := cols["employee"]
+    _, hasID := cols["employee_id"]
+    if !hasName && !hasID {
+        return nil, nil, errors.New("no employee or employee_id column")
+    }
+    for _, col := range []string{"date", "job", "hours"} {
+        if _, ok := cols[col]; !ok {
+            return nil, nil, fmt.Errorf("no %s column", col)
+        }
+    }
+
+    loc := t.location()
+    cal := t.payCalendar()
+    byKey := map[string]*importedCard{}
+    var order []string
+    rowErrs := []importRowError{}
+    for row := 2; ; row++ {
+        rec, err := cr.Read()
+        if err == io.EOF {
+            break
+        }
+        if err != nil {
+            return nil, nil, err
+        }
+        field := func(col string) string {
+            if i, ok := cols[col]; ok && i < len(rec) {
+                return strings.TrimSpace(rec[i])
+            }
+            return ""
+        }
+        if strings.TrimSpace(strings.Join(rec, "")) == "" {
+            continue
+        }
+        fail := func(format string, args ...interface{}) {
+            rowErrs = append(rowErrs, importRowError{Row: row, Error: fmt.Sprintf(format, args...)})
+        }
+
+        name, id := field("employee"), field("employee_id")
+        if name == "" && id == "" {
+            fail("no employee")
+            continue
+        }
+        day, err := parseImportDate(field("date"), loc)
+        if err != nil {
+            fail("date %q is not YYYY-MM-DD", field("date"))
+            continue
+        }
+        kind := strings.ToLower(field("type"))
+        job := field("job")
+        if job == "" && kind == "" {
+            fail("no job")
+            continue
+        }
+        hours, err := strconv.ParseFloat(field("hours"), 64)
+        if err != nil || hours <= 0 {
+            fail("hours %q is not a positive number", field("hours"))
+            continue
+        }
+        pp, fy := period, year
+        if v := field("pay_period"); v != "" {
+            if pp, err = strconv.Atoi(v); err != nil {
+                fail("pay_period %q is not a number", v)
+                continue
+            }
+        }
+        if v := field("year"); v != "" {
+            if fy, err = strconv.Atoi(v); err != nil {
+                fail("year %q is not a number", v)
+                continue
+            }
+        }
+        if (pp == 0 || fy == 0) && cal != nil {
+            p, err := cal.periodOf(day)
+            if err != nil {
+                fail("%v", err)
+                continue
+            }
+            if pp == 0 {
+                pp = p.Number
+            }
+            if fy == 0 {
+                fy = p.FiscalYear
+            }
+        }
+
+        payroll := field("payroll_number")
+        who := strings.ToLower(name)
+        if id != "" {
+            who = "#" + id
+        } else if payroll != "" {
+            who = "$" + payroll
+        }
+        key := fmt.Sprintf("%s|%d|%d", who, fy, pp)
+        c, ok := byKey[key]
+        if !ok {
+            c = &importedCard{raw: TimecardRequest{EmployeeName: name, EmployeeID: id, PayrollNumber: payroll, PayPeriodNum: pp, Year: fy}}
+            byKey[key] = c
+            order = append(order, key)
+        }
+        if code := field("labour_code"); code != "" && !hasImportJob(c.raw.Jobs, job) {
+            c.raw.Jobs = append(c.raw.Jobs, Job{JobCode: job, JobName: code})
+        }
+        c.raw.Entries = append(c.raw.Entries, Entry{
+            Date:         day.Format(time.RFC3339),
+            JobCode:      job,
+            Hours:        hours,
+            Overtime:     importBool(field("ot")),
+            IsNightShift: importBool(field("night")),
+            Type:         kind,
+            Notes:        field("notes"),
+            Phase:        field("phase"),
+            CostCode:     field("cost_code"),
+        })
+        c.Rows = append(c.Rows, row)
+    }
+
+    cards := make([]*importedCard, 0, len(order))
+    for _, key := range order {
+        c := byKey[key]
+        if cal == nil {
+            if !importWeeks(&c.raw, loc, weekStart, t.weekStartDay(), t.Defaults.WeekLabels) {
+                for _, row := range c.Rows {
+                    rowErrs = append(rowErrs, importRowError{Row: row, Error: "the card's dates span more than two weeks"})
+                }
+                continue
+            }
+        }
+        c.Request = c.raw
+        applyTimecardDefaults(&c.Request, t)
+        c.Result = validateTimecard(c.Request, t)
+        c.Valid = len(c.Result.Errors) == 0
+        cards = append(cards, c)
+    }
+    sort.Slice(rowErrs, func(a, b int) bool { return rowErrs[a].Row < rowErrs[b].Row })
+    return cards, rowErrs, nil
+}
+
+// importWeeks splits the card's entries into its two weeks, starting on
+// start or else the start of the payroll week (weekStart) of the first
+// entry; false when they don't fit
+func importWeeks(req *TimecardRequest, loc *time.Location, start time.Time, weekStart time.Weekday, labels []string) bool {
+    first, _, ok := entryRange(req.Entries, loc)
+    if !ok {
+        return false
+    }
+    if start.IsZero() {
+        start = startOfWeek(first, weekStart)
+    }
+    weeks := make([]WeekData, 2)
+    for i := range weeks {
+        weeks[i] = WeekData{
+            WeekNumber:    i + 1,
+            WeekStartDate: start.AddDate(0, 0, 7*i).Format(time.RFC3339),
+            WeekLabel:     fmt.Sprintf("Week #%d", i+1),
+        }
+        if i < len(labels) {
+            weeks[i].WeekLabel = labels[i]
+        }
+    }
+    for _, e := range req.Entries {
+        day, _ := parseCalendarDate(e.Date, loc)
+        i := int(day.Sub(start).Hours()/24) / 7
+        if day.Before(start) || i >= len(weeks) {
+            return false
+        }
+        weeks[i].Entries = append(weeks[i].Entries, e)
+    }
+    req.Weeks, req.Entries = weeks, nil
+    req.WeekStartDate, req.WeekNumberLabel = weeks[0].WeekStartDate, weeks[0].WeekLabel
+    return true
+}
+
+// parseImportDate reads a spreadsheet date as a day in loc
+func parseImportDate(s string, loc *time.Location) (time.Time, error) {
+    if d, err := time.ParseInLocation("2006-01-02", s, loc); err == nil {
+        return d, nil
+    }
+    return parseCalendarDate(s, loc)
+}
+
+func importBool(s string) bool {
+    switch strings.ToLower(s) {
+    case "1", "y", "yes", "true", "x":
+        return true
+    }
+    return false
+}
+
+func hasImportJob(jobs []Job, code string) bool {
+    for _, j := range jobs {
+        if j.JobCode == code {
+            return true
+        }
+    }
+    return false
+}
+
+func runImportGenerateJob(ctx context.Context, job *BackgroundJob) (interface{}, error) {
+    var p importGenerateParams
+    if err := json.Unmarshal(job.Params, &p); err != nil {
+        return nil, fmt.Errorf("invalid params: %w", err)
+    }
+    if p.Format != "xlsx" && p.Format != "pdf" {
+        return nil, fmt.Errorf("format must be xlsx or pdf")
+    }
+    t := lookupTenant(p.Tenant)
+    ctx = withTenant(ctx, t)
+    lg := loggerFrom(ctx)
+
+    res := importResult{Generated: []importItem{}, Failed: []importItem{}}
+    for i, req := range p.Cards {
+        reportJobProgress(job, i, len(p.Cards))
+        if err := ctx.Err(); err != nil {
+            return res, err
+        }
+        item := importItem{Employee: req.EmployeeName, PayPeriodNum: req.PayPeriodNum, Year: req.Year}
+        id, err := generateImported(ctx, t, req, p.Format, lg)
+        if err != nil {
+            item.Reason = err.Error()
+            res.Failed = append(res.Failed, item)
+            continue
+        }
+        item.TimecardID = id
+        res.Generated = append(res.Generated, item)
+    }
+    reportJobProgress(job, len(p.Cards), len(p.Cards))
+    lg.Printf("CSV import job %s: %d generated, %d failed", job.ID, len(res.Generated), len(res.Failed))
+    return res, nil
+}
+
+// generateImported renders one imported card and keeps it like a card
+// generated through the API, returning the record id
+func generateImported(ctx context.Context, t *Tenant, req TimecardRequest, format string, lg *requestLogger) (string, error) {
+    payload, err := json.Marshal(req)
+    if err != nil {
+        return "", err
+    }
+    applyTimecardDefaults(&req, t)
+    if v := validateTimecard(req, t); len(v.Errors) > 0 {
+        return "", v
+    }
+    gc := newGenContext(ctx, req, t, lg)
+    data, err := renderExcel(gc)
+    if err == nil && format == "pdf" {
+        data, err = renderPDF(gc, data)
+    }
+    if err != nil {
+        return "", err
+    }
+    id := keepTimecardRecord(ctx, t, format, payload, req)
+    keepArtifact(ctx, t, id, req, format, data)
+    emitEvent(ctx, "timecard.generated", t.ID, timecardEventData(id, req, format, len(data)))
+    return id, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+const importTestCSV = "\ufeffEmployee,Date,Job,Labour Code,Hours,OT,Night\n" +
+    "Bob Smith,2025-01-06,29699,201,8,,\n" +
+    "Bob Smith,2025-01-06,29699,201,1.5,yes,\n" +
+    "bob smith,2025-01-14,12215,,7.75,,x\n" +
+    "Ann Lee,2025-01-07,29699,201,8,,\n" +
+    ",,,,,,\n" +
+    "Ann Lee,Jan 7,29699,201,8,,\n" +
+    "Ann Lee,2025-01-08,,201,8,,\n" +
+    "Ann Lee,2025-01-08,29699,201,-1,,\n"
+
+func TestParseImportCSV(t *testing.T) {
+    withTenants(t, `{}`)
+    cards, rowErrs, err := parseImportCSV(importTestCSV, lookupTenant(defaultTenantID), 3, 2025, time.Time{})
+    if err != nil {
+        t.Fatal(err)
+    }
+    wantErrs := []importRowError{
+        {7, `date "Jan 7" is not YYYY-MM-DD`},
+        {8, "no job"},
+        {9, `hours "-1" is not a positive number`},
+    }
+    if !reflect.DeepEqual(rowErrs, wantErrs) {
+        t.Errorf("row errors = %+v, want %+v", rowErrs, wantErrs)
+    }
+    if len(cards) != 2 {
+        t.Fatalf("%d cards, want Bob's and Ann's", len(cards))
+    }
+
+    // rows group by employee, whatever the case, in the order first seen
+    bob, ann := cards[0], cards[1]
+    if !reflect.DeepEqual(bob.Rows, []int{2, 3, 4}) || !reflect.DeepEqual(ann.Rows, []int{5}) {
+        t.Errorf("rows: Bob %v, Ann %v", bob.Rows, ann.Rows)
+    }
+    req := bob.raw
+    if req.EmployeeName != "Bob Smith" || req.PayPeriodNum != 3 || req.Year != 2025 {
+        t.Errorf("Bob's card = %+v", req)
+    }
+    if len(req.Jobs) != 1 || req.Jobs[0] != (Job{JobCode: "29699", JobName: "201"}) {
+        t.Errorf("jobs = %+v", req.Jobs)
+    }
+    // the weeks start on the Sunday before the first date
+    if len(req.Weeks) != 2 || !strings.HasPrefix(req.Weeks[0].WeekStartDate, "2025-01-05") || !strings.HasPrefix(req.Weeks[1].WeekStartDate, "2025-01-12") {
+        t.Fatalf("weeks = %+v", req.Weeks)
+    }
+    w1, w2 := req.Weeks[0].Entries, req.Weeks[1].Entries
+    if len(w1) != 2 || w1[0].Overtime || !w1[1].Overtime || w1[1].Hours != 1.5 {
+        t.Errorf("week 1 = %+v", w1)
+    }
+    if len(w2) != 1 || !w2[0].IsNightShift || w2[0].JobCode != "12215" {
+        t.Errorf("week 2 = %+v", w2)
+    }
+}
+
+func TestParseImportCSVRejects(t *testing.T) {
+    withTenants(t, `{}`)
+    tenant := lookupTenant(defaultTenantID)
+    tests := []struct {
+        name, csv, want string
+    }{
+        {"empty", "", "empty file"},
+        {"no employee column", "date,job,hours\n", "no employee or employee_id column"},
+        {"no hours column", "employee,date,job\n", "no hours column"},
+        {"unbalanced quotes", "employee,date,job,hours\n\"Bob,2025-01-06,1,8\n", "quote"},
+    }
+    for _, tt := range tests {
+        if _, _, err := parseImportCSV(tt.csv, tenant, 3, 2025, time.Time{}); err == nil || !strings.Contains(err.Error(), tt.want) {
+            t.Errorf("%s: err = %v, want %q", tt.name, err, tt.want)
+        }
+    }
+
+    // a card running past its two weeks is left out, row by row
+    const long = "employee,date,job,hours\nBob Smith,2025-01-06,1,8\nBob Smith,2025-01-20,1,8\n"
+    cards, rowErrs, err := parseImportCSV(long, tenant, 3, 2025, time.Time{})
+    if err != nil || len(cards) != 0 || len(rowErrs) != 2 || !strings.Contains(rowErrs[0].Error, "more than two weeks") {
+        t.Errorf("cards %+v, row errors %+v, err %v", cards, rowErrs, err)
+    }
+}
+
+func TestImportCSVHandler(t *testing.T) {
+    withTenants(t, `{}`)
+    t.Setenv("DATA_DIR", t.TempDir())
+    saved := backgroundJobs
+    backgroundJobs = openCollection[BackgroundJob]("jobs")
+    t.Cleanup(func() { backgroundJobs = saved })
+
+    tests := []struct {
+        name, method, query, body string
+        want                      int
+    }{
+        {"wrong method", http.MethodGet, "", "", http.StatusMethodNotAllowed},
+        {"bad format", http.MethodPost, "?generate=docx", importTestCSV, http.StatusBadRequest},
+        {"bad period", http.MethodPost, "?pay_period_num=0", importTestCSV, http.StatusBadRequest},
+        {"bad week start", http.MethodPost, "?week_start=Jan+5", importTestCSV, http.StatusBadRequest},
+        {"bad CSV", http.MethodPost, "", "date,job\n", http.StatusBadRequest},
+        {"too large", http.MethodPost, "", strings.Repeat("x", maxImportBytes+1), http.StatusRequestEntityTooLarge},
+        {"checked", http.MethodPost, "?pay_period_num=3&year=2025", importTestCSV, http.StatusOK},
+        {"generated", http.MethodPost, "?generate=pdf&pay_period_num=3&year=2025", importTestCSV, http.StatusAccepted},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest(tt.method, "/api/import/csv"+tt.query, strings.NewReader(tt.body))
+        w := httptest.NewRecorder()
+        importCSVHandler(w, r)
+        if w.Code != tt.want {
+            t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+        }
+    }
+
+    jobs := backgroundJobs.List()
+    if len(jobs) != 1 || jobs[0].Kind != "import-generate" {
+        t.Fatalf("jobs = %+v", jobs)
+    }
+    var p importGenerateParams
+    if err := json.Unmarshal(jobs[0].Params, &p); err != nil {
+        t.Fatal(err)
+    }
+    if p.Format != "pdf" || len(p.Cards) != 2 || p.Cards[0].EmployeeName != "Bob Smith" {
+        t.Errorf("job params = %+v", p)
+    }
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+)
+
+/* ========
+   Job cost
+   ======== */
+
+// Project cost tracking wants the hours by job:
+//
+//   GET /api/reports/job-cost?from=YYYY-MM-DD&to=YYYY-MM-DD
+//
+// adds up the hours on the tenant's stored cards (current revisions) dated
+// in the range by job number and, under each, by labour code. With hourly
+// rates per labour code,
+//
+//   "job_cost": {"rates": {"201": 42.50, "223": 38}, "overtime_multiplier": 1.5, "currency": "CAD"}
+//
+// each line is costed too: regular hours at the rate, overtime at the rate
+// times overtime_multiplier (default 1.5) and double time at the rate
+// times double_time_multiplier (default 2). Codes without a rate are listed
+// as unpriced and left out of the cost. A job in the registry can set
+// rates of its own (jobregistry.go), which win over these for its hours.
+// Stat pay and leave are not job costs and are left out. Expense lines
+// charged to a job (expenses.go) are added up under it by type, apart
+// from the labour cost.
+
+// JobCostSettings price labour for the job cost report
+type JobCostSettings struct {
+    Rates                map[string]float64 `json:"rates"` // hourly, by labour code
+    OvertimeMultiplier   float64            `json:"overtime_multiplier,omitempty"`
+    DoubleTimeMultiplier float64            `json:"double_time_multiplier,omitempty"`
+    Currency             string             `json:"currency,omitempty"`
+}
+
+const (
+    defaultOvertimeMultiplier   = 1.5
+    defaultDoubleTimeMultiplier = 2
+)
+
+type jobCostCode struct {
+    LabourCode string   `json:"labour_code"`
+    Rate       *float64 `json:"rate,omitempty"`
+    Cost       *float64 `json:"cost,omitempty"`
+    hourSums
+}
+
+type jobCostJob struct {
+    JobNumber string        `json:"job_number"`
+    Codes     []jobCostCode `json:"labour_codes"`
+    Cost      *float64      `json:"cost,omitempty"`
+    Employees int           `json:"employees"`
+    hourSums
+    expenseSums
+}
+
+type jobCostReport struct {
+    From          string       `json:"from"`
+    To            string       `json:"to"`
+    Currency      string       `json:"currency,omitempty"`
+    Jobs          []jobCostJob `json:"jobs"`
+    Totals        hourSums     `json:"totals"`
+    Cost          *float64     `json:"cost,omitempty"`
+    UnpricedCodes []string     `json:"unpriced_codes,omitempty"`
+    expenseSums
+}
+
+// jobCostReportHandler serves GET /api/reports/job-cost (see the top of this file)
+func jobCostReportHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    t := tenantFor(r)
+    from, to, err := queryDateRange(r)
+    if err != nil {
+        httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    reqs, err := storedCards(t, func(TimecardRecord) bool { return true })
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("error reading timecards: %v", err), http.StatusInternalServerError)
+        return
+    }
+
+    rep := jobCostReport{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Jobs: []jobCostJob{}}
+    settings := t.JobCost
+    if settings != nil {
+        rep.Currency = settings.Currency
+    }
+    jobRates := map[string]map[string]float64{}
+    for _, j := range tenantJobs(t.ID) {
+        jobRates[j.Number] = j.Rates
+    }
+    type codeKey struct{ job, code string }
+    codes := map[codeKey]*hourSums{}
+    crews := map[string]map[string]bool{}
+    for _, req := range reqs {
+        for _, l := range hourLines(req, cardLocation(req, t)) {
+            if !l.worked() || l.Date.Before(from) || l.Date.After(to) {
+                continue
+            }
+            k := codeKey{l.JobNumber, l.LabourCode}
+            if codes[k] == nil {
+                codes[k] = &hourSums{}
+            }
+            codes[k].add(l)
+            if crews[l.JobNumber] == nil {
+                crews[l.JobNumber] = map[string]bool{}
+            }
+            crews[l.JobNumber][req.EmployeeName] = true
+        }
+    }
+    expenses := map[string]*expenseSums{}
+    for _, req := range reqs {
+        loc := cardLocation(req, t)
+        for _, x := range req.Expenses {
+            day, err := parseCalendarDate(x.Date, loc)
+            if err != nil || x.JobCode == "" || day.Before(from) || day.After(to) {
+                continue
+            }
+            if expenses[x.JobCode] == nil {
+                expenses[x.JobCode] = &expenseSums{}
+            }
+            expenses[x.JobCode].addExpense(x)
+            rep.addExpense(x)
+        }
+    }
+
+    byJob := map[string]*jobCostJob{}
+    unpriced := map[string]bool{}
+    for k, sums := range codes {
+        j := byJob[k.job]
+        if j == nil {
+            j = &jobCostJob{JobNumber: k.job, Employees: len(crews[k.job])}
+            byJob[k.job] = j
+        }
+        c := jobCostCode{LabourCode: k.code, hourSums: *sums}
+        if rate, ok := settings.rate(jobRates[k.job], k.code); ok {
+            cost := settings.cost(rate, *sums)
+            c.Rate, c.Cost = &rate, roundCost(&cost)
+            j.Cost = addCost(j.Cost, cost)
+            rep.Cost = addCost(rep.Cost, cost)
+        } else if settings != nil {
+            unpriced[k.code] = true
+        }
+        j.merge(*sums)
+        c.hourSums = c.rounded()
+        j.Codes = append(j.Codes, c)
+    }
+    for job, x := range expenses {
+        j := byJob[job]
+        if j == nil {
+            j = &jobCostJob{JobNumber: job, Codes: []jobCostCode{}}
+            byJob[job] = j
+        }
+        j.expenseSums = *x
+    }
+    for _, j := range byJob {
+        j.hourSums = j.rounded()
+        j.expenseSums = j.roundedExpenses()
+        j.Cost = roundCost(j.Cost)
+        sort.Slice(j.Codes, func(a, b int) bool { return j.Codes[a].LabourCode < j.Codes[b].LabourCode })
+        rep.Totals.merge(j.hourSums)
+        rep.Jobs = append(rep.Jobs, *j)
+    }
+    sort.Slice(rep.Jobs, func(a, b int) bool { return rep.Jobs[a].JobNumber < rep.Jobs[b].JobNumber })
+    rep.Totals = rep.Totals.rounded()
+    rep.expenseSums = rep.roundedExpenses()
+    rep.Cost = roundCost(rep.Cost)
+    for code := range unpriced {
+        rep.UnpricedCodes = append(rep.UnpricedCodes, code)
+    }
+    sort.Strings(rep.UnpricedCodes)
+    writeJSON(w, http.StatusOK, rep)
+}
+
+// rate is the hourly rate of a labour code, if one is configured, from
+// the job's own rates first
+func (s *JobCostSettings) rate(job map[string]float64, code string) (float64, bool) {
+    if rate, ok := job[code]; ok {
+        return rate, true
+    }
+    if s == nil {
+        return 0, false
+    }
+    rate, ok := s.Rates[code]
+    return rate, ok
+}
+
+// cost prices hours at rate, overtime and double time at their multipliers
+func (s *JobCostSettings) cost(rate float64, h hourSums) float64 {
+    var ot, dt float64
+    if s != nil {
+        ot, dt = s.OvertimeMultiplier, s.DoubleTimeMultiplier
+    }
+    if ot == 0 {
+        ot = defaultOvertimeMultiplier
+    }
+    if dt == 0 {
+        dt = defaultDoubleTimeMultiplier
+    }
+    return h.Regular*rate + (h.Overtime-h.DoubleTime)*rate*ot + h.DoubleTime*rate*dt
+}
+
+func addCost(sum *float64, cost float64) *float64 {
+    if sum == nil {
+        return &cost
+    }
+    v := *sum + cost
+    return &v
+}
+
+func roundCost(c *float64) *float64 {
+    if c == nil {
+        return nil
+    }
+    v := roundHours(*c) // to the cent
+    return &v
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+)
+
+/* =================
+   Job code registry
+   ================= */
+
+// The registry is a tenant's canonical list of job numbers and the labour
+// codes booked against them:
+//
+//   GET    /api/jobs?inactive=1
+//   POST   /api/jobs            {"number": "29699", "description": "Tower B",
+//                                "labour_codes": [{"code": "201", "description": "Carpenter"}]}
+//   GET    /api/jobs/{number}
+//   PUT    /api/jobs/{number}   the whole job
+//   DELETE /api/jobs/{number}
+//
+// Once a tenant has registered any job, every card's hours must be booked
+// to a registered, active job number, with a labour code the job lists
+// (when it lists any), or the card is rejected; a number one or two typos
+// away from a registered one is suggested. Retired jobs and codes are
+// marked inactive rather than deleted so the list still explains old cards.
+//
+// A job can also override the tenant's pay rules for its hours, e.g. a
+// prevailing-wage job:
+//
+//   "overtime_rules": {"daily_overtime_after": 7}  tiers its hours (otrules.go)
+//   "rates": {"201": 55.25}                        prices its labour codes (jobcost.go)
+
+// RegisteredJob is one job number in the registry
+type RegisteredJob struct {
+    Number        string             `json:"number"`
+    Tenant        string             `json:"tenant"`
+    Description   string             `json:"description,omitempty"`
+    LabourCodes   []LabourCode       `json:"labour_codes,omitempty"`
+    OvertimeRules *OvertimeRules     `json:"overtime_rules,omitempty"` // over the tenant's
+    Rates         map[string]float64 `json:"rates,omitempty"`          // over the tenant's job cost rates
+    Inactive      bool               `json:"inactive,omitempty"`
+    CreatedAt     time.Time          `json:"created_at"`
+    UpdatedAt     time.Time          `json:"updated_at"`
+}
+
+// LabourCode is a labour code allowed on a job
+type LabourCode struct {
+    Code        string `json:"code"`
+    Description string `json:"description,omitempty"`
+    Inactive    bool   `json:"inactive,omitempty"`
+}
+
+// jobRegistry is keyed by tenant and job number
+var jobRegistry = openCollection[RegisteredJob]("job_registry")
+
+func registryKey(tenant, number string) string {
+    return tenant + "/" + number
+}
+
+// tenantJobs returns the tenant's registered jobs, inactive ones included
+func tenantJobs(tenant string) []RegisteredJob {
+    var out []RegisteredJob
+    for _, j := range jobRegistry.List() {
+        if j.Tenant == tenant {
+            out = append(out, j)
+        }
+    }
+    return out
+}
+
+// checkJobCodes rejects hours booked to jobs or labour codes the tenant's
+// registry doesn't allow
+func checkJobCodes(v *validationResult, req TimecardRequest, t *Tenant) {
+    registered := map[string]RegisteredJob{}
+    for _, j := range tenantJobs(t.ID) {
+        registered[j.Number] = j
+    }
+    if len(registered) == 0 {
+        return
+    }
+    labour := make(map[string]string, len(req.Jobs))
+    for _, j := range req.Jobs {
+        labour[j.JobCode] = j.JobName
+    }
+
+    seen := map[string]bool{}
+    for _, e := range allEntries(req) {
+        if e.Hours == 0 || !e.worked() || seen[e.JobCode] {
+            continue
+        }
+        seen[e.JobCode] = true
+        j, ok := registered[e.JobCode]
+        switch {
+        case !ok:
+            if near := nearestJob(e.JobCode, registered); near != "" {
+                v.errorf("job %s is not in the job registry (did you mean %s?)", e.JobCode, near)
+            } else {
+                v.errorf("job %s is not in the job registry", e.JobCode)
+            }
+            continue
+        case j.Inactive:
+            v.errorf("job %s (%s) is inactive", j.Number, j.Description)
+            continue
+        }
+        if len(j.LabourCodes) == 0 {
+            continue
+        }
+        code := labour[e.JobCode]
+        allowed := false
+        for _, lc := range j.LabourCodes {
+            if lc.Code == code && !lc.Inactive {
+                allowed = true
+            }
+        }
+        if !allowed {
+            v.errorf("labour code %q is not allowed on job %s", code, j.Number)
+        }
+    }
+}
+
+// nearestJob returns the active registered number at most two edits from
+// number, if exactly one is closest
+func nearestJob(number string, registered map[string]RegisteredJob) string {
+    best, bestDist, tie := "", 3, false
+    for n, j := range registered {
+        if j.Inactive {
+            continue
+        }
+        d := editDistance(number, n)
+        switch {
+        case d < bestDist:
+            best, bestDist, tie = n, d, false
+        case d == bestDist:
+            tie = true
+        }
+    }
+    if tie {
+        return ""
+    }
+    return best
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+    prev := make([]int, len(b)+1)
+    for j := range prev {
+        prev[j] = j
+    }
+    for i := 1; i <= len(a); i++ {
+        cur := make([]int, len(b)+1)
+        cur[0] = i
+        for j := 1; j <= len(b); j++ {
+            cost := 1
+            if a[i-1] == b[j-1] {
+                cost = 0
+            }
+            cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+        }
+        prev = cur
+    }
+    return prev[len(b)]
+}
+
+// jobRegistryHandler serves /api/jobs (see the top of this file)
+func jobRegistryHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/jobs"), "/")
+    tenant := tenantFor(r).ID
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        inactive := r.URL.Query().Get("inactive") != ""
+        out := []RegisteredJob{}
+        for _, j := range tenantJobs(tenant) {
+            if !j.Inactive || inactive {
+                out = append(out, j)
+            }
+        }
+        sort.Slice(out, func(a, b int) bool { return out[a].Number < out[b].Number })
+        writeJSON(w, http.StatusOK, out)
+    case rest == "" && r.Method == http.MethodPost:
+        saveRegisteredJob(w, r, tenant, "")
+    case strings.Contains(rest, "/"):
+        httpError(w, r, "job not found", http.StatusNotFound)
+    case r.Method == http.MethodGet:
+        j, ok := jobRegistry.Get(registryKey(tenant, rest))
+        if !ok {
+            httpError(w, r, "job not found", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, j)
+    case r.Method == http.MethodPut:
+        saveRegisteredJob(w, r, tenant, rest)
+    case r.Method == http.MethodDelete:
+        if _, ok := jobRegistry.Get(registryKey(tenant, rest)); !ok {
+            httpError(w, r, "job not found", http.StatusNotFound)
+            return
+        }
+        if err := jobRegistry.Delete(registryKey(tenant, rest)); err != nil {
+            httpError(w, r, fmt.Sprintf("error deleting job: %v", err), http.StatusInternalServerError)
+            return
+        }
+        loggerFrom(r.Context()).Printf("Job %s removed from the %s registry", rest, tenant)
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// saveRegisteredJob registers a job (number == "") or replaces job number
+func saveRegisteredJob(w http.ResponseWriter, r *http.Request, tenant, number string) {
+    var j RegisteredJob
+    if err := json.NewDecoder(r.Body).Decode(&j); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    j.Number = strings.TrimSpace(j.Number)
+    if number != "" && j.Number == "" {
+        j.Number = number
+    }
+    if err := j.check(); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+
+    status := http.StatusOK
+    cur, exists := jobRegistry.Get(registryKey(tenant, j.Number))
+    switch {
+    case number == "" && exists:
+        httpError(w, r, fmt.Sprintf("job %s already exists", j.Number), http.StatusConflict)
+        return
+    case number == "":
+        j.CreatedAt = now().UTC()
+        status = http.StatusCreated
+    case j.Number != number:
+        httpError(w, r, "invalid request: number cannot be changed", http.StatusBadRequest)
+        return
+    case !exists:
+        httpError(w, r, "job not found", http.StatusNotFound)
+        return
+    default:
+        j.CreatedAt = cur.CreatedAt
+    }
+    j.Tenant = tenant
+    j.UpdatedAt = now().UTC()
+    if err := jobRegistry.Put(registryKey(tenant, j.Number), j); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving job: %v", err), http.StatusInternalServerError)
+        return
+    }
+    loggerFrom(r.Context()).Printf("Job %s saved in the %s registry", j.Number, tenant)
+    writeJSON(w, status, j)
+}
+
+// check rejects a job the registry can't hold
+func (j RegisteredJob) check() error {
+    if j.Number == "" {
+        return fmt.Errorf("number is required")
+    }
+    if strings.Contains(j.Number, "/") {
+        return fmt.Errorf("number must not contain /")
+    }
+    seen := map[string]bool{}
+    for _, lc := range j.LabourCodes {
+        if strings.TrimSpace(lc.Code) == "" {
+            return fmt.Errorf("labour_codes: code is required")
+        }
+        if seen[lc.Code] {
+            return fmt.Errorf("labour_codes: %s is listed twice", lc.Code)
+        }
+        seen[lc.Code] = true
+    }
+    if j.OvertimeRules != nil {
+        if err := j.OvertimeRules.validate(); err != nil {
+            return err
+        }
+    }
+    for code, rate := range j.Rates {
+        if rate < 0 {
+            return fmt.Errorf("rates: %s must not be negative", code)
+        }
+    }
+    return nil
+}
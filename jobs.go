@@ -0,0 +1,446 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "path/filepath"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ===============
+   Background jobs
+   =============== */
+
+// Long-running work (archive exports, batches, scheduled sends) runs as a
+// persisted BackgroundJob picked up by JOB_WORKERS (default 2) goroutines. Jobs run
+// in the batch generation lane, highest Priority first, then oldest. Every
+// state change is recorded on the job and saved, so an operator can see
+// who cancelled or requeued what, and a restart resumes where it stopped.
+
+const (
+    jobQueued    = "queued"
+    jobRunning   = "running"
+    jobSucceeded = "succeeded"
+    jobFailed    = "failed"
+    jobCancelled = "cancelled"
+)
+
+type BackgroundJob struct {
+    ID          string          `json:"id"`
+    Kind        string          `json:"kind"`
+    Owner       string          `json:"owner"` // identity that created it, or "system"
+    Priority    int             `json:"priority"`
+    State       string          `json:"state"`
+    Params      json.RawMessage `json:"params,omitempty"`
+    Result      json.RawMessage `json:"result,omitempty"`
+    Error       string          `json:"error,omitempty"`
+    Attempts    int             `json:"attempts"`
+    Progress    *jobProgress    `json:"progress,omitempty"`
+    Transitions []jobTransition `json:"transitions,omitempty"`
+    CreatedAt   time.Time       `json:"created_at"`
+    UpdatedAt   time.Time       `json:"updated_at"`
+}
+
+type jobProgress struct {
+    Done  int `json:"done"`
+    Total int `json:"total"`
+}
+
+type jobTransition struct {
+    From   string    `json:"from,omitempty"`
+    To     string    `json:"to"`
+    By     string    `json:"by"`
+    Reason string    `json:"reason,omitempty"`
+    At     time.Time `json:"at"`
+}
+
+// jobFunc does the work of one job. It should return promptly once ctx is
+// done; the value it returns is stored as the job's result.
+type jobFunc func(ctx context.Context, job *BackgroundJob) (interface{}, error)
+
+var (
+    jobKindsMu sync.RWMutex
+    jobKinds   = map[string]jobFunc{}
+)
+
+func registerJobKind(kind string, fn jobFunc) {
+    jobKindsMu.Lock()
+    defer jobKindsMu.Unlock()
+    jobKinds[kind] = fn
+}
+
+func jobKind(kind string) (jobFunc, bool) {
+    jobKindsMu.RLock()
+    defer jobKindsMu.RUnlock()
+    fn, ok := jobKinds[kind]
+    return fn, ok
+}
+
+var backgroundJobs = openCollection[BackgroundJob]("jobs")
+
+// jobsMu serializes state changes; runningJobs maps job id to its cancel func
+var (
+    jobsMu      sync.Mutex
+    runningJobs = map[string]context.CancelFunc{}
+    jobWake     = make(chan struct{}, 1)
+)
+
+func wakeJobWorkers() {
+    select {
+    case jobWake <- struct{}{}:
+    default:
+    }
+}
+
+// transitionLocked moves job to state and saves it. Callers hold jobsMu.
+func transitionLocked(job *BackgroundJob, to, by, reason string) error {
+    t := now().UTC()
+    job.Transitions = append(job.Transitions, jobTransition{From: job.State, To: to, By: by, Reason: reason, At: t})
+    job.State = to
+    job.UpdatedAt = t
+    return backgroundJobs.Put(job.ID, *job)
+}
+
+// enqueueJob creates and saves a queued job
+func enqueueJob(kind, owner string, priority int, params interface{}) (BackgroundJob, error) {
+    if _, ok := jobKind(kind); !ok {
+        return BackgroundJob{}, fmt.Errorf("unknown job kind %q", kind)
+    }
+    raw, err := json.Marshal(params)
+    if err != nil {
+        return BackgroundJob{}, fmt.Errorf("encode params: %w", err)
+    }
+    job := BackgroundJob{
+        ID:        newID(),
+        Kind:      kind,
+        Owner:     owner,
+        Priority:  priority,
+        Params:    raw,
+        CreatedAt: now().UTC(),
+    }
+    jobsMu.Lock()
+    err = transitionLocked(&job, jobQueued, owner, "")
+    jobsMu.Unlock()
+    if err != nil {
+        return BackgroundJob{}, err
+    }
+    wakeJobWorkers()
+    return job, nil
+}
+
+// nextJobLocked claims the highest-priority, oldest queued job
+func nextJobLocked() (BackgroundJob, bool) {
+    var queued []BackgroundJob
+    for _, j := range backgroundJobs.List() {
+        if j.State == jobQueued {
+            queued = append(queued, j)
+        }
+    }
+    if len(queued) == 0 {
+        return BackgroundJob{}, false
+    }
+    sort.SliceStable(queued, func(a, b int) bool {
+        if queued[a].Priority != queued[b].Priority {
+            return queued[a].Priority > queued[b].Priority
+        }
+        return queued[a].CreatedAt.Before(queued[b].CreatedAt)
+    })
+    return queued[0], true
+}
+
+// startJobWorkers resumes interrupted jobs and runs workers until ctx ends
+func startJobWorkers(ctx context.Context) {
+    jobsMu.Lock()
+    for _, j := range backgroundJobs.List() {
+        if j.State == jobRunning {
+            _ = transitionLocked(&j, jobQueued, "system", "interrupted by restart")
+        }
+    }
+    jobsMu.Unlock()
+
+    n := envInt("JOB_WORKERS", 2)
+    for i := 0; i < n; i++ {
+        go jobWorker(ctx)
+    }
+    wakeJobWorkers()
+    log.Printf("Started %d job worker(s)", n)
+}
+
+func jobWorker(ctx context.Context) {
+    for {
+        jobsMu.Lock()
+        job, ok := nextJobLocked()
+        var jobCtx context.Context
+        if ok {
+            var cancel context.CancelFunc
+            jobCtx, cancel = context.WithCancel(withPriority(ctx, priorityBatch))
+            runningJobs[job.ID] = cancel
+            job.Attempts++
+            job.Error = ""
+            if err := transitionLocked(&job, jobRunning, "system", ""); err != nil {
+                log.Printf("Warning: job %s: %v", job.ID, err)
+            }
+        }
+        jobsMu.Unlock()
+
+        if !ok {
+            select {
+            case <-jobWake:
+                continue
+            case <-ctx.Done():
+                return
+            }
+        }
+        runJob(ctx, jobCtx, job)
+        wakeJobWorkers() // another worker may be idle with work waiting
+    }
+}
+
+func runJob(serverCtx, ctx context.Context, job BackgroundJob) {
+    fn, _ := jobKind(job.Kind)
+    var (
+        result interface{}
+        err    error
+    )
+    ctx, audited := auditJob(ctx, job)
+    if fn == nil {
+        err = fmt.Errorf("unknown job kind %q", job.Kind)
+    } else {
+        result, err = safeRunJob(ctx, fn, &job)
+    }
+    audited(err)
+
+    jobsMu.Lock()
+    defer jobsMu.Unlock()
+    delete(runningJobs, job.ID)
+    current, _ := backgroundJobs.Get(job.ID)
+    job.Transitions = current.Transitions // cancel may have recorded who asked
+    job.Priority = current.Priority
+    job.Progress = current.Progress
+
+    switch {
+    case serverCtx.Err() != nil:
+        err = transitionLocked(&job, jobQueued, "system", "interrupted by shutdown")
+    case current.State == jobCancelled:
+        job.State = jobCancelled
+        err = backgroundJobs.Put(job.ID, job)
+    case err != nil:
+        job.Error = err.Error()
+        log.Printf("BackgroundJob %s (%s) failed: %v", job.ID, job.Kind, err)
+        err = transitionLocked(&job, jobFailed, "system", "")
+    default:
+        job.Result, _ = json.Marshal(result)
+        log.Printf("BackgroundJob %s (%s) succeeded", job.ID, job.Kind)
+        err = transitionLocked(&job, jobSucceeded, "system", "")
+    }
+    if err != nil {
+        log.Printf("Warning: job %s: %v", job.ID, err)
+    }
+}
+
+// safeRunJob keeps one bad job from taking a worker down
+func safeRunJob(ctx context.Context, fn jobFunc, job *BackgroundJob) (result interface{}, err error) {
+    defer func() {
+        if p := recover(); p != nil {
+            err = fmt.Errorf("panic: %v", p)
+        }
+    }()
+    return fn(ctx, job)
+}
+
+// reportJobProgress records how far a running job has got
+func reportJobProgress(job *BackgroundJob, done, total int) {
+    jobsMu.Lock()
+    defer jobsMu.Unlock()
+    current, ok := backgroundJobs.Get(job.ID)
+    if !ok || current.State != jobRunning {
+        return
+    }
+    current.Progress = &jobProgress{Done: done, Total: total}
+    current.UpdatedAt = now().UTC()
+    _ = backgroundJobs.Put(current.ID, current)
+}
+
+var errJobState = errors.New("not allowed in the job's current state")
+
+// cancelJob stops a queued or running job
+func cancelJob(id, by string) (BackgroundJob, error) {
+    jobsMu.Lock()
+    defer jobsMu.Unlock()
+    job, ok := backgroundJobs.Get(id)
+    if !ok {
+        return job, errNotFound
+    }
+    if job.State != jobQueued && job.State != jobRunning {
+        return job, fmt.Errorf("cancel %s job: %w", job.State, errJobState)
+    }
+    if cancel := runningJobs[id]; cancel != nil {
+        cancel()
+    }
+    return job, transitionLocked(&job, jobCancelled, by, "")
+}
+
+// requeueJob puts a finished job back in the queue
+func requeueJob(id, by string) (BackgroundJob, error) {
+    jobsMu.Lock()
+    job, ok := backgroundJobs.Get(id)
+    if !ok {
+        jobsMu.Unlock()
+        return job, errNotFound
+    }
+    if job.State == jobQueued || job.State == jobRunning {
+        jobsMu.Unlock()
+        return job, fmt.Errorf("requeue %s job: %w", job.State, errJobState)
+    }
+    job.Error, job.Result, job.Progress = "", nil, nil
+    err := transitionLocked(&job, jobQueued, by, "")
+    jobsMu.Unlock()
+    wakeJobWorkers()
+    return job, err
+}
+
+// reprioritizeJob changes where a queued job sits in line
+func reprioritizeJob(id, by string, priority int) (BackgroundJob, error) {
+    jobsMu.Lock()
+    defer jobsMu.Unlock()
+    job, ok := backgroundJobs.Get(id)
+    if !ok {
+        return job, errNotFound
+    }
+    if job.State != jobQueued && job.State != jobRunning {
+        return job, fmt.Errorf("reprioritize %s job: %w", job.State, errJobState)
+    }
+    reason := fmt.Sprintf("priority %d → %d", job.Priority, priority)
+    job.Priority = priority
+    job.Transitions = append(job.Transitions, jobTransition{From: job.State, To: job.State, By: by, Reason: reason, At: now().UTC()})
+    job.UpdatedAt = now().UTC()
+    return job, backgroundJobs.Put(job.ID, job)
+}
+
+var errNotFound = errors.New("not found")
+
+// jobsHandler serves (admin only):
+//   GET  /admin/jobs?state=&kind=&owner=
+//   POST /admin/jobs                    {"kind","params","priority"}
+//   GET  /admin/jobs/{id}
+//   POST /admin/jobs/{id}/cancel
+//   POST /admin/jobs/{id}/requeue
+//   POST /admin/jobs/{id}/priority      {"priority": 10}
+//   GET  /admin/jobs/{id}/result        download the file a job produced
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/admin/jobs"), "/")
+    parts := strings.Split(rest, "/")
+    who := identityFrom(r.Context())
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        listJobs(w, r)
+    case rest == "" && r.Method == http.MethodPost:
+        var body struct {
+            Kind     string          `json:"kind"`
+            Params   json.RawMessage `json:"params"`
+            Priority int             `json:"priority"`
+        }
+        if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+            httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+            return
+        }
+        if len(body.Params) == 0 {
+            body.Params = json.RawMessage("{}")
+        }
+        job, err := enqueueJob(body.Kind, who, body.Priority, body.Params)
+        if err != nil {
+            httpError(w, r, err.Error(), http.StatusBadRequest)
+            return
+        }
+        writeJSON(w, http.StatusAccepted, job)
+    case len(parts) == 1 && r.Method == http.MethodGet:
+        job, ok := backgroundJobs.Get(parts[0])
+        if !ok {
+            httpError(w, r, "job not found", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, job)
+    case len(parts) == 2 && parts[1] == "result" && r.Method == http.MethodGet:
+        serveJobFile(w, r, parts[0])
+    case len(parts) == 2 && r.Method == http.MethodPost:
+        var (
+            job BackgroundJob
+            err error
+        )
+        switch parts[1] {
+        case "cancel":
+            job, err = cancelJob(parts[0], who)
+        case "requeue":
+            job, err = requeueJob(parts[0], who)
+        case "priority":
+            var body struct {
+                Priority int `json:"priority"`
+            }
+            if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+                httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+                return
+            }
+            job, err = reprioritizeJob(parts[0], who, body.Priority)
+        default:
+            httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+            return
+        }
+        switch {
+        case errors.Is(err, errNotFound):
+            httpError(w, r, "job not found", http.StatusNotFound)
+        case errors.Is(err, errJobState):
+            httpError(w, r, err.Error(), http.StatusConflict)
+        case err != nil:
+            httpError(w, r, fmt.Sprintf("error updating job: %v", err), http.StatusInternalServerError)
+        default:
+            loggerFrom(r.Context()).Printf("BackgroundJob %s %s by %s", job.ID, parts[1], who)
+            writeJSON(w, http.StatusOK, job)
+        }
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func listJobs(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    state, kind, owner := q.Get("state"), q.Get("kind"), q.Get("owner")
+
+    out := []BackgroundJob{}
+    for _, j := range backgroundJobs.List() {
+        if (state != "" && j.State != state) || (kind != "" && j.Kind != kind) || (owner != "" && j.Owner != owner) {
+            continue
+        }
+        j.Transitions = nil // fetch one job for its history
+        out = append(out, j)
+    }
+    sort.SliceStable(out, func(a, b int) bool { return out[a].CreatedAt.After(out[b].CreatedAt) })
+    writeJSON(w, http.StatusOK, out)
+}
+
+// jobFileResult is the result shape of jobs that produce a downloadable file
+type jobFileResult struct {
+    File string `json:"file"`
+}
+
+func serveJobFile(w http.ResponseWriter, r *http.Request, id string) {
+    job, ok := backgroundJobs.Get(id)
+    if !ok {
+        httpError(w, r, "job not found", http.StatusNotFound)
+        return
+    }
+    var res jobFileResult
+    if job.State != jobSucceeded || json.Unmarshal(job.Result, &res) != nil || res.File == "" {
+        httpError(w, r, "job has no downloadable result", http.StatusNotFound)
+        return
+    }
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filepath.Base(res.File)))
+    http.ServeFile(w, r, res.File)
+}
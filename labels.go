@@ -0,0 +1,91 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ========================
+   Bilingual (EN/FR) labels
+   ======================== */
+
+type dualLabel struct {
+    Cell string `json:"cell"`
+    EN   string `json:"en"`
+    FR   string `json:"fr"`
+}
+
+// templateLabels are the static captions of the default template.xlsx that get rewritten
+// in bilingual mode. The "Labour Codes:" / "Job:" header captions are left
+// alone on purpose: the overtime formulas match on the literal text
+// "Labour Codes:" to skip unused columns.
+var templateLabels = []dualLabel{
+    {"J2", "Employee", "Employé(e)"},
+    {"AI2", "PP #", "PP no"},
+    {"A3", "Regular Time", "Temps régulier"},
+    {"AI3", "YEAR", "ANNÉE"},
+    {"A4", "Sun Date Start:", "Début (dim.) :"},
+    {"AI4", "Shift Hours", "Heures de quart"},
+    {"AJ5", "Office Use Only", "Réservé au bureau"},
+    {"AJ6", "Regular Time:", "Temps régulier :"},
+    {"AJ7", "OT:", "TS :"},
+    {"AJ8", "DT:", "TD :"},
+    {"AJ9", "VP:", "VAC :"},
+    {"AJ10", "NS:", "QN :"},
+    {"AJ11", "STAT:", "FÉRIÉ :"},
+    {"AJ12", "On Call:", "Sur appel :"},
+    {"AJ13", "# of On Call", "Nbre sur appel"},
+    {"A12", "TOTAL REGULAR", "TOTAL RÉGULIER"},
+    {"A13", "TOTAL NIGHT", "TOTAL NUIT"},
+    {"A14", "Overtime & Double-Time", "Temps supplémentaire et temps double"},
+    {"A15", "Date:", "Date :"},
+    {"W15", "Overtime", "Temps suppl."},
+    {"Y15", "Double-Time", "Temps double"},
+    {"A23", "TOTAL OVERTIME", "TOTAL TEMPS SUPPL."},
+    {"A24", "Approved by:", "Approuvé par :"},
+    {"A25", "Note:", "Remarque :"},
+}
+
+var dayLabels = [7][2]string{
+    {"Sun", "dim."},
+    {"Mon", "lun."},
+    {"Tues", "mar."},
+    {"Wed", "mer."},
+    {"Thurs", "jeu."},
+    {"Fri", "ven."},
+    {"Sat", "sam."},
+}
+
+func bilingual(en, fr string) string {
+    return en + " / " + fr
+}
+
+// writeBilingualLabels overwrites the template captions on sheet with
+// "English / Français" pairs, including the day names of both tables.
+func writeBilingualLabels(f *excelize.File, sheet string, cm CellMap) {
+    for _, l := range cm.Labels {
+        _ = f.SetCellValue(sheet, l.Cell, bilingual(l.EN, l.FR))
+    }
+    if cm.DayLabelColumn == "" {
+        return
+    }
+    for d, names := range dayLabels {
+        _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", cm.DayLabelColumn, cm.RegularFirstRow+d), bilingual(names[0], names[1]))
+        _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", cm.DayLabelColumn, cm.OvertimeFirstRow+d), bilingual(names[0], names[1]))
+    }
+}
+
+// bilingualWeekLabel turns the usual "Week #N" label into "Week #N / Semaine no N";
+// anything else the client sent is kept as-is.
+func bilingualWeekLabel(label string, weekNum int) string {
+    if label == "" {
+        label = fmt.Sprintf("Week #%d", weekNum)
+    }
+    var n int
+    if _, err := fmt.Sscanf(strings.TrimSpace(label), "Week #%d", &n); err == nil {
+        return bilingual(label, fmt.Sprintf("Semaine no %d", n))
+    }
+    return label
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+)
+
+/* ===========
+   Leave codes
+   =========== */
+
+// Time off goes on the card as entries of its own kind,
+//
+//   {"date": "2025-01-09", "type": "vacation", "hours": 8}
+//
+// with "type" vacation, sick or bereavement (or holiday, for stat pay; see
+// holidays.go). Each kind is booked to a job number and labour code of its
+// own, whatever job_code the entry names, so it gets its own column:
+//
+//   "leave_codes": {"vacation": {"labour_code": "V", "job_number": "VAC"},
+//                   "sick": {"labour_code": "S", "job_number": "SICK"}}
+//
+// Those two and bereavement (BRV, B) are the defaults. Leave is paid time
+// rather than worked time: it is never overtime or night shift, worked
+// hours cannot be booked to its jobs, and the overtime and job cost
+// reports leave it out.
+
+const (
+    entryVacation    = "vacation"
+    entrySick        = "sick"
+    entryBereavement = "bereavement"
+)
+
+// entryKinds are the entry types besides worked hours, in column order
+var entryKinds = []string{entryHoliday, entryVacation, entrySick, entryBereavement}
+
+// LeaveCode is where one kind of leave goes on the card
+type LeaveCode struct {
+    LabourCode string `json:"labour_code,omitempty"`
+    JobNumber  string `json:"job_number,omitempty"`
+}
+
+var defaultLeaveCodes = map[string]LeaveCode{
+    entryVacation:    {LabourCode: "V", JobNumber: "VAC"},
+    entrySick:        {LabourCode: "S", JobNumber: "SICK"},
+    entryBereavement: {LabourCode: "B", JobNumber: "BRV"},
+}
+
+func validateLeaveCodes(codes map[string]LeaveCode) error {
+    for kind := range codes {
+        if _, ok := defaultLeaveCodes[kind]; !ok {
+            return fmt.Errorf("leave code %q must be %s, %s or %s", kind, entryVacation, entrySick, entryBereavement)
+        }
+    }
+    return nil
+}
+
+// worked is false for stat pay and leave
+func (e Entry) worked() bool {
+    return e.Type == ""
+}
+
+// entryCode returns where entries of kind are booked, and false when kind
+// is not a known entry type
+func (t *Tenant) entryCode(kind string) (LeaveCode, bool) {
+    if kind == entryHoliday {
+        cal := t.holidays()
+        return LeaveCode{LabourCode: cal.labourCode(), JobNumber: cal.jobNumber()}, true
+    }
+    c, ok := defaultLeaveCodes[kind]
+    if !ok {
+        return c, false
+    }
+    if t != nil {
+        own := t.LeaveCodes[kind]
+        if own.LabourCode != "" {
+            c.LabourCode = own.LabourCode
+        }
+        if own.JobNumber != "" {
+            c.JobNumber = own.JobNumber
+        }
+    }
+    return c, true
+}
+
+// applyEntryTypes books every holiday and leave entry to its kind's job and
+// makes sure the card carries those jobs with their labour codes
+func applyEntryTypes(req *TimecardRequest, t *Tenant) {
+    used := map[string]bool{}
+    book := func(entries []Entry) {
+        for i := range entries {
+            if c, ok := t.entryCode(entries[i].Type); ok {
+                entries[i].JobCode = c.JobNumber
+                used[entries[i].Type] = true
+            }
+        }
+    }
+    book(req.Entries)
+    for i := range req.Weeks {
+        book(req.Weeks[i].Entries)
+    }
+
+    for _, kind := range entryKinds {
+        if !used[kind] {
+            continue
+        }
+        c, _ := t.entryCode(kind)
+        listed := false
+        for i := range req.Jobs {
+            if req.Jobs[i].JobCode == c.JobNumber {
+                req.Jobs[i].JobName = c.LabourCode
+                listed = true
+            }
+        }
+        if !listed {
+            req.Jobs = append(req.Jobs, Job{JobCode: c.JobNumber, JobName: c.LabourCode})
+        }
+    }
+}
+
+// checkEntryTypes rejects unknown entry types, overtime or night shift
+// that isn't worked, and worked hours on a holiday or leave job
+func checkEntryTypes(v *validationResult, req TimecardRequest, t *Tenant) {
+    reserved := map[string]string{} // job number -> kind
+    for _, kind := range entryKinds {
+        c, _ := t.entryCode(kind)
+        reserved[c.JobNumber] = kind
+    }
+    loc := cardLocation(req, t)
+    for _, e := range allEntries(req) {
+        date := e.Date
+        if day, err := parseCalendarDate(e.Date, loc); err == nil {
+            date = day.Format("2006-01-02")
+        }
+        if e.worked() {
+            if kind, ok := reserved[e.JobCode]; ok && e.Hours != 0 {
+                v.errorf("%s: job %s is for %s hours; enter them with \"type\": %q", date, e.JobCode, kind, kind)
+            }
+            continue
+        }
+        if _, ok := t.entryCode(e.Type); !ok {
+            v.errorf("%s: entry type %q is not known (use %s, or leave it out for worked hours)", date, e.Type, strings.Join(entryKinds, ", "))
+            continue
+        }
+        if e.Hours != 0 && (e.Overtime || e.IsNightShift) {
+            v.errorf("%s: %s hours are not worked and cannot be overtime or night shift", date, e.Type)
+        }
+    }
+}
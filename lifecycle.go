@@ -0,0 +1,96 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "sync"
+    "sync/atomic"
+    "time"
+)
+
+/* ============================
+   Lifecycle: liveness/readiness
+   ============================ */
+
+// ready is flipped on once startup() finishes and off again as soon as a
+// shutdown signal arrives, so /readyz drains traffic before the listener closes.
+var ready atomic.Bool
+
+var (
+    readinessMu     sync.Mutex
+    readinessChecks = map[string]func() error{}
+)
+
+// registerReadinessCheck adds a cheap, in-process condition to /readyz
+// (unlike /health/ready, these must not touch the network or fork processes).
+func registerReadinessCheck(name string, check func() error) {
+    readinessMu.Lock()
+    defer readinessMu.Unlock()
+    readinessChecks[name] = check
+}
+
+// startup performs one-time initialization and marks the instance ready
+func startup() {
+    registerReadinessCheck("template", func() error {
+        if _, err := os.Stat("template.xlsx"); err != nil {
+            return fmt.Errorf("template.xlsx: %w", err)
+        }
+        return nil
+    })
+    loadTenants()
+    pools()
+    migrateRecords()
+
+    ready.Store(true)
+    log.Printf("Instance ready")
+}
+
+// beginShutdown flips readiness off; serving continues until drainContext expires
+func beginShutdown() {
+    ready.Store(false)
+    log.Printf("Shutdown signal received, marking instance not ready")
+}
+
+// drainContext is cancelled SHUTDOWN_DRAIN (default 5s) after parent, giving
+// load balancers time to observe /readyz failing before connections close.
+func drainContext(parent context.Context) context.Context {
+    ctx, cancel := context.WithCancel(context.Background())
+    go func() {
+        <-parent.Done()
+        time.Sleep(envDuration("SHUTDOWN_DRAIN", 5*time.Second))
+        cancel()
+    }()
+    return ctx
+}
+
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+    if !ready.Load() {
+        writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "not ready"})
+        return
+    }
+
+    readinessMu.Lock()
+    checks := make(map[string]func() error, len(readinessChecks))
+    for name, c := range readinessChecks {
+        checks[name] = c
+    }
+    readinessMu.Unlock()
+
+    failures := map[string]string{}
+    for name, c := range checks {
+        if err := c(); err != nil {
+            failures[name] = err.Error()
+        }
+    }
+    if len(failures) > 0 {
+        writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{
+            "status":   "not ready",
+            "failures": failures,
+        })
+        return
+    }
+    writeJSON(w, http.StatusOK, map[string]string{"status": "ready"})
+}
@@ -2,16 +2,24 @@ package main
 
 import (
     "bytes"
+    "context"
+    "crypto/tls"
     "encoding/base64"
     "encoding/json"
+    "flag"
     "fmt"
-    "log"
+    "io"
+    "mime/multipart"
+    "net"
     "net/http"
     "net/smtp"
+    "net/textproto"
     "os"
-    "os/exec"
+    "os/signal"
     "path/filepath"
+    "slices"
     "strings"
+    "syscall"
     "time"
 
     "github.com/xuri/excelize/v2"
@@ -30,6 +38,46 @@ type TimecardRequest struct {
     Jobs            []Job      `json:"jobs"`
     Entries         []Entry    `json:"entries"`
     Weeks           []WeekData `json:"weeks,omitempty"`
+    // Bilingual renders the sheet captions in English and French side by side
+    Bilingual       bool       `json:"bilingual,omitempty"`
+    // Template selects an imported template bundle; empty means template.xlsx
+    Template        string     `json:"template,omitempty"`
+    // ManagerEmail sends the card to this manager for approval
+    ManagerEmail    string     `json:"manager_email,omitempty"`
+    // Revision numbers the cards kept for one employee and pay period
+    // (revisions.go); from 2 on it is printed on the sheet
+    Revision        int        `json:"revision,omitempty"`
+    // EmployeeSignature and SupervisorSignature sign the card (signature.go)
+    EmployeeSignature   *Signature `json:"employee_signature,omitempty"`
+    SupervisorSignature *Signature `json:"supervisor_signature,omitempty"`
+    // Supervisor names who approves the card, and ApprovedBy and ApprovedAt
+    // (RFC 3339) record that they did (signature.go)
+    Supervisor string `json:"supervisor,omitempty"`
+    ApprovedBy string `json:"approved_by,omitempty"`
+    ApprovedAt string `json:"approved_at,omitempty"`
+    // EmployeeID names the employee from the directory (employees.go)
+    EmployeeID string `json:"employee_id,omitempty"`
+    // PayrollNumber is the employee's number in the payroll system
+    PayrollNumber string `json:"payroll_number,omitempty"`
+    // BreaksDeducted records the unpaid breaks taken off (breaks.go)
+    BreaksDeducted []BreakDeduction `json:"breaks_deducted,omitempty"`
+    // Punches are clock in and out times to turn into entries (punches.go)
+    Punches []Punch `json:"punches,omitempty"`
+    // Expenses are per diems and purchases claimed on the card (expenses.go)
+    Expenses []Expense `json:"expenses,omitempty"`
+    // Travel is the card's mileage and travel time log (travel.go)
+    Travel []TravelLine `json:"travel,omitempty"`
+    // PDFPassword locks the card's PDF (pdfpassword.go)
+    PDFPassword string `json:"pdf_password,omitempty"`
+    // PDFA exports the card's PDF as PDF/A at this level, e.g. "2b" (pdfa.go)
+    PDFA string `json:"pdf_a,omitempty"`
+    // PageSetup changes how the card's sheets print (pagesetup.go)
+    PageSetup *PageSetup `json:"page_setup,omitempty"`
+    // Draft marks a preview copy that is not for payroll (watermark.go)
+    Draft bool `json:"draft,omitempty"`
+    // PDFLayout is merged or per_week (pdflayout.go); empty prints the
+    // workbook as it is
+    PDFLayout string `json:"pdf_layout,omitempty"`
 }
 
 type Job struct {
@@ -37,6 +85,10 @@ type Job struct {
     JobCode string `json:"job_code"`
     // JobName is the LABOUR CODE (e.g., "201", "223", "H")
     JobName string `json:"job_name"`
+    // Phase and CostCode go to entries on the job without their own
+    // (costcodes.go)
+    Phase    string `json:"phase,omitempty"`
+    CostCode string `json:"cost_code,omitempty"`
 }
 
 type Entry struct {
@@ -45,6 +97,12 @@ type Entry struct {
     Hours        float64 `json:"hours"`
     Overtime     bool    `json:"overtime"`
     IsNightShift bool    `json:"is_night_shift"`
+    Type         string  `json:"type,omitempty"`        // "" for worked hours, else holiday or leave (leave.go)
+    Start        string  `json:"start,omitempty"`       // HH:MM the shift started, for the night differential
+    DoubleTime   bool    `json:"double_time,omitempty"` // overtime at double time (otrules.go)
+    Notes        string  `json:"notes,omitempty"`       // written with the hours (notes.go)
+    Phase        string  `json:"phase,omitempty"`       // below the job number (costcodes.go)
+    CostCode     string  `json:"cost_code,omitempty"`
 }
 
 // accept both snake_case and camelCase keys
@@ -59,6 +117,12 @@ func (e *Entry) UnmarshalJSON(data []byte) error {
         NightShift        *bool   `json:"night_shift"`
         IsNightShiftSnake *bool   `json:"is_night_shift"`
         IsNightShiftCamel *bool   `json:"isNightShift"`
+        Type              string  `json:"type"`
+        Start             string  `json:"start"`
+        DoubleTime        bool    `json:"double_time"`
+        Notes             string  `json:"notes"`
+        Phase             string  `json:"phase"`
+        CostCode          string  `json:"cost_code"`
     }
     var aux rawEntry
     if err := json.Unmarshal(data, &aux); err != nil {
@@ -72,12 +136,20 @@ func (e *Entry) UnmarshalJSON(data []byte) error {
         e.JobCode = aux.Code
     }
     e.Hours = aux.Hours
+    e.Type = strings.ToLower(strings.TrimSpace(aux.Type))
+    e.Start = strings.TrimSpace(aux.Start)
+    e.Notes = strings.TrimSpace(aux.Notes)
+    e.Phase = strings.TrimSpace(aux.Phase)
+    e.CostCode = strings.TrimSpace(aux.CostCode)
 
     if aux.Overtime != nil {
         e.Overtime = *aux.Overtime
     } else if aux.IsOvertimeCamel != nil {
         e.Overtime = *aux.IsOvertimeCamel
     }
+    if aux.DoubleTime {
+        e.DoubleTime, e.Overtime = true, true
+    }
 
     if aux.NightShift != nil {
         e.IsNightShift = *aux.NightShift
@@ -87,16 +159,33 @@ func (e *Entry) UnmarshalJSON(data []byte) error {
         e.IsNightShift = *aux.IsNightShiftCamel
     }
 
-    log.Printf("  Unmarshaled entry: JobCode=%s, Hours=%.2f, OT=%v, Night=%v",
-        e.JobCode, e.Hours, e.Overtime, e.IsNightShift)
     return nil
 }
 
+// logEntries logs the decoded entries (both flat and per-week) for debugging
+func logEntries(lg *requestLogger, req TimecardRequest) {
+    for _, e := range allEntries(req) {
+        lg.Printf("  entry: JobCode=%s, Hours=%.2f, OT=%v, Night=%v",
+            e.JobCode, e.Hours, e.Overtime, e.IsNightShift)
+    }
+}
+
 type WeekData struct {
     WeekNumber    int     `json:"week_number"`
     WeekStartDate string  `json:"week_start_date"`
     WeekLabel     string  `json:"week_label"`
     Entries       []Entry `json:"entries"`
+    // Days cuts the week short at the end of a semi-monthly or monthly
+    // pay period (paycalendar.go); 0 is all seven
+    Days int `json:"days,omitempty"`
+}
+
+// days is how many days the week covers
+func (wk WeekData) days() int {
+    if wk.Days > 0 && wk.Days < 7 {
+        return wk.Days
+    }
+    return 7
 }
 
 type EmailTimecardRequest struct {
@@ -105,6 +194,11 @@ type EmailTimecardRequest struct {
     CC      *string `json:"cc"`
     Subject string  `json:"subject"`
     Body    string  `json:"body"`
+    // IncludeSummary appends the card's hours summary to Body; an empty
+    // Body gets the summary anyway
+    IncludeSummary bool `json:"include_summary,omitempty"`
+    // Attachments go out with the card (attachments.go)
+    Attachments []EmailAttachment `json:"attachments,omitempty"`
 }
 
 /* ===============
@@ -112,20 +206,85 @@ type EmailTimecardRequest struct {
    =============== */
 
 func main() {
-    port := os.Getenv("PORT")
-    if port == "" {
-        port = "8080"
-    }
+    os.Exit(runCommand(os.Args[1:]))
+}
 
-    http.HandleFunc("/health", healthHandler)
-    http.HandleFunc("/api/generate-timecard", corsMiddleware(generateTimecardHandler))
-    http.HandleFunc("/api/generate-pdf", corsMiddleware(generatePDFHandler))
-    http.HandleFunc("/api/email-timecard", corsMiddleware(emailTimecardHandler))
+// serveCommand runs the HTTP server until SIGINT or SIGTERM
+func serveCommand(args []string) error {
+    fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+    port := fs.String("port", envOr("PORT", "8080"), "port to listen on")
+    if err := fs.Parse(args); err != nil {
+        return err
+    }
 
-    log.Printf("Server starting on :%s ...", port)
-    if err := http.ListenAndServe(":"+port, nil); err != nil {
-        log.Fatal(err)
+    mux := http.NewServeMux()
+    mux.HandleFunc("/health", healthHandler)
+    mux.HandleFunc("/health/ready", readyHandler)
+    mux.HandleFunc("/livez", healthHandler)
+    mux.HandleFunc("/readyz", readyzHandler)
+    mux.HandleFunc("/version", versionHandler)
+    mux.HandleFunc("/api/generate-timecard", corsMiddleware(tracingMiddleware("/api/generate-timecard", generateTimecardHandler)))
+    mux.HandleFunc("/api/generate-pdf", corsMiddleware(tracingMiddleware("/api/generate-pdf", generatePDFHandler)))
+    mux.HandleFunc("/api/email-timecard", corsMiddleware(tracingMiddleware("/api/email-timecard", emailTimecardHandler)))
+    mux.HandleFunc("/api/generate-bundle", corsMiddleware(tracingMiddleware("/api/generate-bundle", generateBundleHandler)))
+    mux.HandleFunc("/api/deliver", corsMiddleware(tracingMiddleware("/api/deliver", deliverHandler)))
+    mux.HandleFunc("/api/export", corsMiddleware(tracingMiddleware("/api/export", exportHandler)))
+    mux.HandleFunc("/api/export/", corsMiddleware(tracingMiddleware("/api/export/{format}", exportHandler)))
+    mux.HandleFunc("/api/preview", corsMiddleware(tracingMiddleware("/api/preview", previewHandler)))
+    mux.HandleFunc("/api/convert-to-pdf", corsMiddleware(tracingMiddleware("/api/convert-to-pdf", adminAuth(convertToPDFHandler))))
+    mux.HandleFunc("/api/absences", corsMiddleware(absencesHandler))
+    mux.HandleFunc("/api/absences/", corsMiddleware(absencesHandler))
+    mux.HandleFunc("/api/employees", corsMiddleware(employeesHandler))
+    mux.HandleFunc("/api/employees/", corsMiddleware(employeesHandler))
+    mux.HandleFunc("/api/jobs", corsMiddleware(jobRegistryHandler))
+    mux.HandleFunc("/api/jobs/", corsMiddleware(jobRegistryHandler))
+    mux.HandleFunc("/api/pay-calendar", corsMiddleware(payCalendarHandler))
+    mux.HandleFunc("/api/pay-calendar/", corsMiddleware(payCalendarHandler))
+    mux.HandleFunc("/api/holidays", corsMiddleware(holidaysHandler))
+    mux.HandleFunc("/api/branding", corsMiddleware(brandingHandler))
+    mux.HandleFunc("/api/branding/", corsMiddleware(brandingHandler))
+    mux.HandleFunc("/api/reports/summary", corsMiddleware(summaryReportHandler))
+    mux.HandleFunc("/api/reports/ytd", corsMiddleware(ytdReportHandler))
+    mux.HandleFunc("/api/reports/rollup", corsMiddleware(rollupReportHandler))
+    mux.HandleFunc("/api/reports/overtime", corsMiddleware(overtimeReportHandler))
+    mux.HandleFunc("/api/reports/job-cost", corsMiddleware(jobCostReportHandler))
+    mux.HandleFunc("/api/import/csv", corsMiddleware(tracingMiddleware("/api/import/csv", importCSVHandler)))
+    mux.HandleFunc("/api/crew", corsMiddleware(tracingMiddleware("/api/crew", crewHandler)))
+    mux.HandleFunc("/api/drafts", corsMiddleware(draftsHandler))
+    mux.HandleFunc("/api/drafts/", corsMiddleware(draftsHandler))
+    mux.HandleFunc("/api/templates", corsMiddleware(templatesHandler))
+    mux.HandleFunc("/api/templates/", corsMiddleware(templatesHandler))
+    mux.HandleFunc("/api/progress/", corsMiddleware(progressHandler))
+    mux.HandleFunc("/api/timecards", corsMiddleware(timecardsHandler))
+    mux.HandleFunc("/api/timecards/", corsMiddleware(timecardsHandler))
+    mux.HandleFunc("/api/hooks", corsMiddleware(hookAuth(hooksHandler)))
+    mux.HandleFunc("/api/hooks/", corsMiddleware(hookAuth(hooksHandler)))
+
+    mux.HandleFunc("/api/audit", corsMiddleware(adminAuth(auditHandler)))
+    mux.HandleFunc("/admin/jobs", requestIDMiddleware(adminAuth(jobsHandler)))
+    mux.HandleFunc("/admin/jobs/", requestIDMiddleware(adminAuth(jobsHandler)))
+    mux.HandleFunc("/dav/", requestIDMiddleware(webdavHandler))
+    mux.HandleFunc("/approve/", requestIDMiddleware(approveHandler))
+    registerDebugRoutes(mux)
+
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    defer stop()
+
+    go startup()
+    startJobWorkers(ctx)
+    startPayrollScheduler(ctx)
+    startReminderScheduler(ctx)
+    go func() {
+        <-ctx.Done()
+        beginShutdown()
+    }()
+
+    if err := serve(drainContext(ctx), *port, accessLogMiddleware(auditMiddleware(errorReportMiddleware(mux)))); err != nil {
+        return err
     }
+    flushTraces(5 * time.Second)
+    flushWebhooks(5 * time.Second)
+    return nil
 }
 
 func healthHandler(w http.ResponseWriter, r *http.Request) {
@@ -134,170 +293,354 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func corsMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    next = requestIDMiddleware(tenantMiddleware(next))
     return func(w http.ResponseWriter, r *http.Request) {
         w.Header().Set("Access-Control-Allow-Origin", "*")
-        w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+        w.Header().Set("Access-Control-Allow-Methods", "POST, GET, PUT, DELETE, OPTIONS")
+        w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, X-Tenant-ID, X-Request-ID, X-Timecard-Priority, Idempotency-Key")
+        w.Header().Set("Access-Control-Expose-Headers", "X-Timecard-Warnings, X-Request-ID, X-Timecard-ID, X-Timecard-Drive-Link, Idempotent-Replayed")
         if r.Method == http.MethodOptions {
             w.WriteHeader(http.StatusOK)
             return
         }
+        if strings.EqualFold(r.Header.Get("X-Timecard-Priority"), "batch") {
+            r = r.WithContext(withPriority(r.Context(), priorityBatch))
+        }
         next(w, r)
     }
 }
 
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+    w.Header().Set("Content-Type", "application/json")
+    w.WriteHeader(status)
+    _ = json.NewEncoder(w).Encode(v)
+}
+
 /* ===================
    API: Generate / Mail
    =================== */
 
 func generateTimecardHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodPost {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
         return
     }
+    lg := loggerFrom(r.Context())
 
     var req TimecardRequest
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        log.Printf("decode error: %v", err)
-        http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+    payload, err := readPayload(r, &req)
+    if err != nil {
+        lg.Printf("decode error: %v", err)
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    logEntries(lg, req)
+
+    applyTimecardDefaults(&req, tenantFor(r))
+
+    if _, ok := checkTimecard(w, r, req); !ok {
         return
     }
+    planGeneration(r.Context(), req, "")
 
-    log.Printf("Generating timecard for %s", req.EmployeeName)
+    lg.Printf("Generating timecard for %s", req.EmployeeName)
 
-    excelData, err := generateExcelFile(req)
+    excelData, err := renderExcel(newGenContext(r.Context(), req, tenantFor(r), lg))
     if err != nil {
-        log.Printf("excel error: %v", err)
-        http.Error(w, fmt.Sprintf("error generating timecard: %v", err), http.StatusInternalServerError)
+        lg.Printf("excel error: %v", err)
+        generationFailed(w, r, "error generating timecard", err)
         return
     }
 
+    finishProgress(r.Context(), nil)
+    id := saveTimecardRecord(r, "xlsx", payload, req)
+    if id != "" {
+        if link := storeArtifact(r, id, req, "xlsx", excelData); link != "" {
+            w.Header().Set("X-Timecard-Drive-Link", link)
+        }
+        w.Header().Set("X-Timecard-ID", id)
+    }
+    emitEvent(r.Context(), "timecard.generated", tenantFor(r).ID, timecardEventData(id, req, "xlsx", len(excelData)))
     w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
-    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"timecard_%s.xlsx\"", req.EmployeeName))
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"timecard_%s.xlsx\"", req.fileEmployee()))
     w.WriteHeader(http.StatusOK)
     _, _ = w.Write(excelData)
 
-    log.Printf("OK: timecard bytes=%d", len(excelData))
+    lg.Printf("OK: timecard bytes=%d", len(excelData))
 }
 
 func generatePDFHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodPost {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
         return
     }
+    lg := loggerFrom(r.Context())
 
     var req TimecardRequest
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        log.Printf("decode error: %v", err)
-        http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+    payload, err := readPayload(r, &req)
+    if err != nil {
+        lg.Printf("decode error: %v", err)
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
         return
     }
+    logEntries(lg, req)
 
-    log.Printf("Generating PDF timecard for %s", req.EmployeeName)
+    applyTimecardDefaults(&req, tenantFor(r))
 
-    // First generate Excel
-    excelData, err := generateExcelFile(req)
-    if err != nil {
-        log.Printf("excel error: %v", err)
-        http.Error(w, fmt.Sprintf("error generating Excel: %v", err), http.StatusInternalServerError)
+    if _, ok := checkTimecard(w, r, req); !ok {
         return
     }
+    planGeneration(r.Context(), req, "converted")
 
-    // Convert to PDF
-    pdfData, err := generatePDFFromExcel(excelData, fmt.Sprintf("timecard_%s.xlsx", req.EmployeeName))
-    if err != nil {
-        log.Printf("pdf conversion error: %v", err)
-        http.Error(w, fmt.Sprintf("error converting to PDF: %v", err), http.StatusInternalServerError)
+    lg.Printf("Generating PDF timecard for %s", req.EmployeeName)
+
+    gc := newGenContext(r.Context(), req, tenantFor(r), lg)
+    if req.PDFLayout == pdfLayoutPerWeek {
+        sendWeekPDFs(w, r, gc, payload)
         return
     }
+    pdfKey := renderKey(gc, "pdf")
+    pdfData, cached := renders().get(pdfKey)
+    if cached {
+        lg.Printf("Reusing cached PDF (%d bytes)", len(pdfData))
+    } else {
+        // First generate Excel
+        excelData, err := renderExcel(gc)
+        if err != nil {
+            lg.Printf("excel error: %v", err)
+            generationFailed(w, r, "error generating Excel", err)
+            return
+        }
+
+        if excelData, err = printedExcel(gc, excelData); err != nil {
+            lg.Printf("excel error: %v", err)
+            generationFailed(w, r, "error generating Excel", err)
+            return
+        }
 
+        // Convert to PDF
+        pdfData, err = generatePDFFromExcel(r.Context(), excelData, fmt.Sprintf("timecard_%s.xlsx", req.fileEmployee()), req.pdfOptions(tenantFor(r)), lg)
+        if err != nil {
+            lg.Printf("pdf conversion error: %v", err)
+            generationFailed(w, r, "error converting to PDF", err)
+            return
+        }
+        renders().put(pdfKey, pdfData)
+    }
+    reportProgress(r.Context(), "converted", fmt.Sprintf("%d bytes", len(pdfData)))
+    finishProgress(r.Context(), nil)
+
+    id := saveTimecardRecord(r, "pdf", payload, req)
+    if id != "" {
+        if link := storeArtifact(r, id, req, "pdf", pdfData); link != "" {
+            w.Header().Set("X-Timecard-Drive-Link", link)
+        }
+        w.Header().Set("X-Timecard-ID", id)
+    }
+    emitEvent(r.Context(), "timecard.generated", tenantFor(r).ID, timecardEventData(id, req, "pdf", len(pdfData)))
     w.Header().Set("Content-Type", "application/pdf")
-    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"timecard_%s.pdf\"", req.EmployeeName))
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"timecard_%s.pdf\"", req.fileEmployee()))
     w.WriteHeader(http.StatusOK)
     _, _ = w.Write(pdfData)
 
-    log.Printf("OK: PDF bytes=%d", len(pdfData))
+    lg.Printf("OK: PDF bytes=%d", len(pdfData))
 }
 
 func emailTimecardHandler(w http.ResponseWriter, r *http.Request) {
     if r.Method != http.MethodPost {
-        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
         return
     }
+    lg := loggerFrom(r.Context())
 
     var req EmailTimecardRequest
-    if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-        log.Printf("decode error: %v", err)
-        http.Error(w, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+    payload, err := readPayload(r, &req)
+    if err != nil {
+        lg.Printf("decode error: %v", err)
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    logEntries(lg, req.TimecardRequest)
+    if err := checkAttachments(req.Attachments); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
         return
     }
 
-    log.Printf("Emailing timecard for %s → %s", req.EmployeeName, req.To)
+    claim, ok := claimIdempotencyKey(w, r, payload)
+    if !ok {
+        return
+    }
+    defer claim.release()
+
+    applyEmailDefaults(&req, tenantFor(r))
 
-    excelData, err := generateExcelFile(req.TimecardRequest)
+    validation, ok := checkTimecard(w, r, req.TimecardRequest)
+    if !ok {
+        return
+    }
+    planGeneration(r.Context(), req.TimecardRequest, "emailed")
+
+    lg.Printf("Emailing timecard for %s → %s", req.EmployeeName, req.To)
+
+    excelData, err := renderExcel(newGenContext(r.Context(), req.TimecardRequest, tenantFor(r), lg))
     if err != nil {
-        log.Printf("excel error: %v", err)
-        http.Error(w, fmt.Sprintf("error generating timecard: %v", err), http.StatusInternalServerError)
+        lg.Printf("excel error: %v", err)
+        generationFailed(w, r, "error generating timecard", err)
         return
     }
 
-    if err := sendEmail(req.To, req.CC, req.Subject, req.Body, excelData, req.EmployeeName); err != nil {
-        log.Printf("send email error: %v", err)
-        http.Error(w, fmt.Sprintf("error sending email: %v", err), http.StatusInternalServerError)
+    if err := sendEmail(r.Context(), req.To, req.CC, req.Subject, req.Body, excelData, emailAttachmentName(req.fileEmployee(), tenantFor(r).location()), lg, req.Attachments...); err != nil {
+        lg.Printf("send email error: %v", err)
+        emitEvent(r.Context(), "email.failed", tenantFor(r).ID, emailEventData("", req, len(excelData), err))
+        generationFailed(w, r, "error sending email", err)
         return
     }
+    reportProgress(r.Context(), "emailed", req.To)
+    finishProgress(r.Context(), nil)
 
-    w.Header().Set("Content-Type", "application/json")
-    _ = json.NewEncoder(w).Encode(map[string]string{
+    resp := map[string]interface{}{
         "status":  "success",
         "message": fmt.Sprintf("Email sent to %s", req.To),
-    })
+    }
+    id := saveTimecardRecord(r, "email", payload, req.TimecardRequest)
+    if id != "" {
+        if link := storeArtifact(r, id, req.TimecardRequest, "xlsx", excelData); link != "" {
+            resp["drive_link"] = link
+        }
+        resp["timecard_id"] = id
+    }
+    emitEvent(r.Context(), "email.sent", tenantFor(r).ID, emailEventData(id, req, len(excelData), nil))
+    if len(validation.Warnings) > 0 {
+        resp["warnings"] = validation.Warnings
+    }
+    claim.complete(http.StatusOK, resp)
+    writeJSON(w, http.StatusOK, resp)
 }
 
 /* ===========================
    Excel generation (Excelize)
    =========================== */
 
-func generateExcelFile(req TimecardRequest) ([]byte, error) {
-    const templatePath = "template.xlsx"
+func generateExcelFile(gc *genContext) (data []byte, err error) {
+    req, lg := gc.req, gc.lg
+    _, sp := startSpan(gc.ctx, "excel.generate", spanKindInternal)
+    defer func() {
+        sp.SetAttr("excel.bytes", len(data))
+        sp.RecordError(err)
+        sp.End()
+    }()
+
+    release, err := pools().excel.acquire(gc.ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+
+    tpl, err := resolveTemplate(gc.tenantID(), req.Template)
+    if err != nil {
+        return nil, err
+    }
+    gc.tpl = tpl
+    sp.SetAttr("template", tpl.Name)
+    sp.SetAttr("weeks", len(req.Weeks))
 
-    f, err := excelize.OpenFile(templatePath)
+    f, err := openTemplate(tpl)
     if err != nil {
-        log.Printf("Template not found, using basic file: %v", err)
-        return generateBasicExcelFile(req)
+        lg.Printf("Template not found, using basic file: %v", err)
+        return generateBasicExcelFile(gc)
     }
     defer func() { _ = f.Close() }()
+    tpl = withNamedCells(gc, f, tpl)
+    gc.tpl = tpl
 
     sheets := f.GetSheetList()
     if len(sheets) == 0 {
         return nil, fmt.Errorf("no sheets in template")
     }
 
-    if len(req.Weeks) > 0 {
-        if err := fillWeekSheet(f, sheets[0], req, req.Weeks[0], 1); err != nil {
-            log.Printf("Week 1 fill error: %v", err)
+    sheets = weekSheets(gc, f, sheets)
+    for i, wk := range req.Weeks {
+        if i >= len(sheets) {
+            break
         }
-    }
-    if len(sheets) > 1 && len(req.Weeks) > 1 {
-        if err := fillWeekSheet(f, sheets[1], req, req.Weeks[1], 2); err != nil {
-            log.Printf("Week 2 fill error: %v", err)
+        if err := fillWeekSheet(gc, f, sheets[i], wk, i+1); err != nil {
+            lg.Printf("Week %d fill error: %v", i+1, err)
         }
     }
 
+    placeExpenses(gc, f, sheets)
+    placeTravel(gc, f, sheets)
+    layoutForPrint(gc, f, sheets[:min(len(sheets), len(req.Weeks))])
+
+    // Nobody is waiting for the workbook any more; skip serializing it
+    if err := gc.ctx.Err(); err != nil {
+        return nil, err
+    }
+
+    stampArtifactRef(gc, f, stampDraft(gc, f, stampPrintFooter(gc, f, templateFooters(tpl))))
+    applyPageSetup(gc, f)
+
     // Clear cached values so Excel recalculates on open
     if err := f.UpdateLinkedValue(); err != nil {
-        log.Printf("UpdateLinkedValue warning: %v", err)
+        lg.Printf("UpdateLinkedValue warning: %v", err)
     }
 
     buf, err := f.WriteToBuffer()
     if err != nil {
         return nil, err
     }
-    return buf.Bytes(), nil
+    data, err = withPrintTitles(buf.Bytes(), f, sheets[:min(len(sheets), len(req.Weeks))], tpl.CellMap.Print)
+    if err != nil {
+        return nil, fmt.Errorf("print titles: %w", err)
+    }
+    statExcelGenerated.Add(1)
+    statExcelBytes.Add(int64(len(data)))
+    return data, nil
+}
+
+// weekSheets returns the sheets the card's weeks go on: the template's
+// first two, plus copies of the last of those named "Week 3", "Week 4", ...
+// for a pay period of more weeks (paycalendar.go). Copies go at the end of
+// the workbook.
+func weekSheets(gc *genContext, f *excelize.File, sheets []string) []string {
+    out := append([]string(nil), sheets[:min(len(sheets), 2)]...)
+    from, err := f.GetSheetIndex(out[len(out)-1])
+    if err != nil {
+        return out
+    }
+    for n := len(out) + 1; n <= len(gc.req.Weeks); n++ {
+        name := fmt.Sprintf("Week %d", n)
+        for i := 2; slices.Contains(sheets, name); i++ {
+            name = fmt.Sprintf("Week %d (%d)", n, i)
+        }
+        to, err := f.NewSheet(name)
+        if err == nil {
+            err = f.CopySheet(from, to)
+        }
+        if err != nil {
+            gc.lg.Printf("Warning: no sheet for week %d: %v", n, err)
+            break
+        }
+        out = append(out, name)
+    }
+    return out
 }
 
 // Generate PDF from Excel using LibreOffice (pixel-perfect conversion)
-func generatePDFFromExcel(excelData []byte, filename string) ([]byte, error) {
+func generatePDFFromExcel(ctx context.Context, excelData []byte, filename string, opts pdfOptions, lg *requestLogger) (pdfData []byte, err error) {
+    ctx, sp := startSpan(ctx, "pdf.convert", spanKindInternal)
+    defer func() {
+        sp.SetAttr("pdf.bytes", len(pdfData))
+        sp.RecordError(err)
+        sp.End()
+    }()
+
+    release, err := pools().pdf.acquire(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+
     // Save Excel data to temp file
     tmpExcel, err := os.CreateTemp("", "timecard-*.xlsx")
     if err != nil {
@@ -312,89 +655,94 @@ func generatePDFFromExcel(excelData []byte, filename string) ([]byte, error) {
     }
     tmpExcel.Close()
 
-    // Create temp output directory for PDF
-    tmpDir, err := os.MkdirTemp("", "pdf-")
-    if err != nil {
-        return nil, fmt.Errorf("create temp dir: %w", err)
-    }
-    defer os.RemoveAll(tmpDir)
-
-    log.Printf("🔄 Converting Excel to PDF using LibreOffice...")
-
-    // Convert using LibreOffice headless mode
-    cmd := exec.Command(
-        "soffice",
-        "--headless",
-        "--convert-to", "pdf",
-        "--outdir", tmpDir,
-        tmpExcelPath,
-    )
-
-    // Capture output for debugging
-    output, err := cmd.CombinedOutput()
+    lg.Printf("🔄 Converting Excel to PDF using LibreOffice...")
+    pdfData, err = convertWithSoffice(ctx, tmpExcelPath, opts, lg)
     if err != nil {
-        log.Printf("❌ LibreOffice conversion failed: %s", string(output))
-        return nil, fmt.Errorf("libreoffice conversion failed: %w\nOutput: %s", err, string(output))
-    }
-
-    log.Printf("LibreOffice output: %s", string(output))
-
-    // Find the generated PDF file
-    files, err := os.ReadDir(tmpDir)
-    if err != nil {
-        return nil, fmt.Errorf("read output dir: %w", err)
-    }
-
-    if len(files) == 0 {
-        return nil, fmt.Errorf("no PDF generated by LibreOffice")
+        if ctx.Err() == nil { // not just an abandoned request
+            emitEvent(ctx, "conversion.failed", "", map[string]interface{}{"file": filename, "error": err.Error()})
+        }
+        return nil, err
     }
-
-    // Read the PDF file
-    pdfPath := filepath.Join(tmpDir, files[0].Name())
-    pdfData, err := os.ReadFile(pdfPath)
-    if err != nil {
-        return nil, fmt.Errorf("read pdf: %w", err)
+    if opts.Sign {
+        if pdfData, err = pdfSigning().sign(pdfData, now()); err != nil {
+            return nil, fmt.Errorf("sign pdf: %w", err)
+        }
     }
 
-    log.Printf("✅ Generated LibreOffice PDF: %d bytes (perfect Excel conversion)", len(pdfData))
+    statPDFConverted.Add(1)
+    statPDFBytes.Add(int64(len(pdfData)))
+    lg.Printf("✅ Generated LibreOffice PDF: %d bytes (perfect Excel conversion)", len(pdfData))
     return pdfData, nil
 }
 
 // Apply borders to a range of cells
-func applyBordersToRange(f *excelize.File, sheet string, startCell string, endCell string) error {
+func applyBordersToRange(f *excelize.File, sheet string, rule borderRule) error {
+    color, weight := rule.Color, rule.Style
+    if color == "" {
+        color = "000000"
+    }
+    if weight == 0 {
+        weight = 1
+    }
     style, err := f.NewStyle(&excelize.Style{
         Border: []excelize.Border{
-            {Type: "left", Color: "000000", Style: 1},
-            {Type: "top", Color: "000000", Style: 1},
-            {Type: "bottom", Color: "000000", Style: 1},
-            {Type: "right", Color: "000000", Style: 1},
+            {Type: "left", Color: color, Style: weight},
+            {Type: "top", Color: color, Style: weight},
+            {Type: "bottom", Color: color, Style: weight},
+            {Type: "right", Color: color, Style: weight},
         },
     })
     if err != nil {
         return err
     }
-    return f.SetCellStyle(sheet, startCell, endCell, style)
+    return f.SetCellStyle(sheet, rule.From, rule.To, style)
 }
 
 // Fill a single week sheet with headers and daily hours
-func fillWeekSheet(f *excelize.File, sheet string, req TimecardRequest, week WeekData, weekNum int) error {
-    weekStart, err := time.Parse(time.RFC3339, week.WeekStartDate)
+func fillWeekSheet(gc *genContext, f *excelize.File, sheet string, week WeekData, weekNum int) error {
+    req, lg, theme := gc.req, gc.lg, gc.theme
+    _, sp := startSpan(gc.ctx, "excel.fill_week", spanKindInternal)
+    defer sp.End()
+    sp.SetAttr("week", weekNum)
+    sp.SetAttr("entries", len(week.Entries))
+    cm := gc.tpl.CellMap
+    weekStart, err := parseCalendarDate(week.WeekStartDate, gc.loc)
     if err != nil {
         return fmt.Errorf("parse week start: %w", err)
     }
-    log.Printf("=== Filling %s (week %d) start=%s entries=%d ===",
+    lg.Printf("=== Filling %s (week %d) start=%s entries=%d ===",
         sheet, weekNum, weekStart.Format("2006-01-02"), len(week.Entries))
 
     // Header info - just set values
-    _ = f.SetCellValue(sheet, "M2", req.EmployeeName)
-    _ = f.SetCellValue(sheet, "AJ2", req.PayPeriodNum)
-    _ = f.SetCellValue(sheet, "AJ3", req.Year)
-    _ = f.SetCellValue(sheet, "B4", timeToExcelDate(weekStart))
-    _ = f.SetCellValue(sheet, "AJ4", week.WeekLabel)
+    setMapped(f, sheet, cm.EmployeeName, req.EmployeeName)
+    setMapped(f, sheet, cm.EmployeeID, req.EmployeeID)
+    setMapped(f, sheet, cm.PayrollNumber, req.PayrollNumber)
+    setMapped(f, sheet, cm.PayPeriod, req.PayPeriodNum)
+    if cal := gc.tenant.payCalendar(); cal != nil {
+        setMapped(f, sheet, cm.Year, cal.yearLabel(req.Year))
+    } else {
+        setMapped(f, sheet, cm.Year, req.Year)
+    }
+    setMapped(f, sheet, cm.WeekStart, timeToExcelDate(weekStart))
+    if req.Revision > 1 {
+        rev := fmt.Sprintf("Rev. %d", req.Revision)
+        if req.Bilingual {
+            rev = bilingual(rev, fmt.Sprintf("Rév. %d", req.Revision))
+        }
+        setMapped(f, sheet, cm.Revision, rev)
+    }
+    placeSignatures(gc, f, sheet)
+    placeLogo(gc, f, sheet)
+    if req.Bilingual {
+        writeBilingualLabels(f, sheet, cm)
+        setMapped(f, sheet, cm.WeekLabel, bilingualWeekLabel(week.WeekLabel, weekNum))
+    } else {
+        setMapped(f, sheet, cm.WeekLabel, week.WeekLabel)
+    }
 
-    // Columns: labour codes in C,E,G,... and job numbers in D,F,H,...
-    codeCols := []string{"C", "E", "G", "I", "K", "M", "O", "Q", "S", "U", "W", "Y", "AA", "AC", "AE", "AG"}
-    jobCols := []string{"D", "F", "H", "J", "L", "N", "P", "R", "T", "V", "X", "Z", "AB", "AD", "AF", "AH"}
+    // Columns: labour codes in C,E,G,... and job numbers in D,F,H,... (per cell map)
+    codeCols := cm.CodeColumns
+    jobCols := cm.JobColumns
 
     // Job lookup by job number
     jobMap := make(map[string]*Job, len(req.Jobs))
@@ -405,50 +753,52 @@ func fillWeekSheet(f *excelize.File, sheet string, req TimecardRequest, week Wee
     regularKeys := getUniqueJobNumbersForType(week.Entries, false)
     overtimeKeys := getUniqueJobNumbersForType(week.Entries, true)
 
-    // Fill row 4 (regular headers)
+    // Fill regular headers (row 4 in the default template)
     if len(regularKeys) > 0 {
         for i, key := range regularKeys {
             if i >= len(codeCols) {
                 break
             }
-            actual := key
-            night := strings.HasPrefix(key, "N")
-            if night {
-                actual = key[1:]
-            }
+            levels, night, double := splitEntryKey(key)
+            actual, phase, costCode := splitCostLevels(levels)
             if job := jobMap[actual]; job != nil {
                 code := job.JobName
                 if night {
                     code = "N" + code
                 }
-                _ = f.SetCellValue(sheet, codeCols[i]+"4", code)
-                _ = f.SetCellValue(sheet, jobCols[i]+"4", actual)
-                log.Printf("  regular header %s4=%s (code), %s4=%s (job)",
-                    codeCols[i], code, jobCols[i], actual)
+                if double {
+                    code = "DT" + code
+                }
+                _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", codeCols[i], cm.RegularHeaderRow), code)
+                _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", jobCols[i], cm.RegularHeaderRow), actual)
+                writeCostLevels(f, sheet, codeCols[i], jobCols[i], cm.RegularHeaderRow, cm.RegularCostRow, actual, phase, costCode)
+                lg.Printf("  regular header %s%d=%s (code), %s%d=%s (job)",
+                    codeCols[i], cm.RegularHeaderRow, code, jobCols[i], cm.RegularHeaderRow, actual)
             }
         }
     }
 
-    // Fill row 15 (overtime headers)
+    // Fill overtime headers (row 15 in the default template)
     if len(overtimeKeys) > 0 {
         for i, key := range overtimeKeys {
             if i >= len(codeCols) {
                 break
             }
-            actual := key
-            night := strings.HasPrefix(key, "N")
-            if night {
-                actual = key[1:]
-            }
+            levels, night, double := splitEntryKey(key)
+            actual, phase, costCode := splitCostLevels(levels)
             if job := jobMap[actual]; job != nil {
                 code := job.JobName
                 if night {
                     code = "N" + code
                 }
-                _ = f.SetCellValue(sheet, codeCols[i]+"15", code)
-                _ = f.SetCellValue(sheet, jobCols[i]+"15", actual)
-                log.Printf("  overtime header %s15=%s (code), %s15=%s (job)",
-                    codeCols[i], code, jobCols[i], actual)
+                if double {
+                    code = "DT" + code
+                }
+                _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", codeCols[i], cm.OvertimeHeaderRow), code)
+                _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", jobCols[i], cm.OvertimeHeaderRow), actual)
+                writeCostLevels(f, sheet, codeCols[i], jobCols[i], cm.OvertimeHeaderRow, cm.OvertimeCostRow, actual, phase, costCode)
+                lg.Printf("  overtime header %s%d=%s (code), %s%d=%s (job)",
+                    codeCols[i], cm.OvertimeHeaderRow, code, jobCols[i], cm.OvertimeHeaderRow, actual)
             }
         }
     }
@@ -458,16 +808,13 @@ func fillWeekSheet(f *excelize.File, sheet string, req TimecardRequest, week Wee
     otMap := make(map[string]map[string]float64)
 
     for _, e := range week.Entries {
-        t, err := time.Parse(time.RFC3339, e.Date)
+        t, err := parseCalendarDate(e.Date, gc.loc)
         if err != nil {
-            log.Printf("  bad entry date %q: %v", e.Date, err)
+            lg.Printf("  bad entry date %q: %v", e.Date, err)
             continue
         }
         date := t.Format("2006-01-02")
-        key := e.JobCode
-        if e.IsNightShift {
-            key = "N" + key
-        }
+        key := entryKey(e)
 
         if e.Overtime {
             if otMap[date] == nil {
@@ -483,16 +830,16 @@ func fillWeekSheet(f *excelize.File, sheet string, req TimecardRequest, week Wee
     }
 
     // Write dates + hours
-    for d := 0; d < 7; d++ {
+    for d := 0; d < week.days(); d++ {
         day := weekStart.AddDate(0, 0, d)
         dateKey := day.Format("2006-01-02")
         dateSerial := timeToExcelDate(day)
 
-        rowReg := 5 + d
-        rowOT := 16 + d
+        rowReg := cm.RegularFirstRow + d
+        rowOT := cm.OvertimeFirstRow + d
 
-        _ = f.SetCellValue(sheet, fmt.Sprintf("B%d", rowReg), dateSerial)
-        _ = f.SetCellValue(sheet, fmt.Sprintf("B%d", rowOT), dateSerial)
+        _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", cm.DateColumn, rowReg), dateSerial)
+        _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", cm.DateColumn, rowOT), dateSerial)
 
         if hours := regMap[dateKey]; hours != nil {
             for i, key := range regularKeys {
@@ -502,7 +849,7 @@ func fillWeekSheet(f *excelize.File, sheet string, req TimecardRequest, week Wee
                 if v, ok := hours[key]; ok && v != 0 {
                     cell := fmt.Sprintf("%s%d", codeCols[i], rowReg)
                     _ = f.SetCellValue(sheet, cell, v)
-                    log.Printf("    REG %s = %.2f (%s)", cell, v, key)
+                    lg.Printf("    REG %s = %.2f (%s)", cell, v, key)
                 }
             }
         }
@@ -514,28 +861,47 @@ func fillWeekSheet(f *excelize.File, sheet string, req TimecardRequest, week Wee
                 if v, ok := hours[key]; ok && v != 0 {
                     cell := fmt.Sprintf("%s%d", codeCols[i], rowOT)
                     _ = f.SetCellValue(sheet, cell, v)
-                    log.Printf("    OT  %s = %.2f (%s)", cell, v, key)
+                    lg.Printf("    OT  %s = %.2f (%s)", cell, v, key)
                 }
             }
         }
     }
 
-    // Apply borders to the entire Regular Time table (rows 4-11, columns A-AJ)
-    log.Printf("Applying borders to Regular Time table...")
-    if err := applyBordersToRange(f, sheet, "A4", "AJ12"); err != nil {
-        log.Printf("Warning: Failed to apply borders to regular table: %v", err)
+    writeNightPremium(gc, f, sheet, week, weekStart)
+    writeEntryNotes(gc, f, sheet, week, weekStart, regularKeys, overtimeKeys)
+
+    // Apply the template's border rules (both tables, A-AJ, in the default template)
+    for _, rule := range gc.tpl.Style.Borders {
+        rule = theme.borders(rule)
+        lg.Printf("Applying borders to %s:%s...", rule.From, rule.To)
+        if err := applyBordersToRange(f, sheet, rule); err != nil {
+            lg.Printf("Warning: Failed to apply borders to %s:%s: %v", rule.From, rule.To, err)
+        }
     }
 
-    // Apply borders to the entire Overtime table (rows 15-23, columns A-AJ)  
-    log.Printf("Applying borders to Overtime table...")
-    if err := applyBordersToRange(f, sheet, "A15", "AJ24"); err != nil {
-        log.Printf("Warning: Failed to apply borders to overtime table: %v", err)
+    // Tenant theme last, so it layers on top of the borders
+    applyThemeToWeekSheet(f, sheet, cm, theme, lg)
+    styleHolidayColumn(gc, f, sheet, regularKeys)
+    if err := weekPrintLayout(f, sheet); err != nil {
+        lg.Printf("Warning: print layout of %s: %v", sheet, err)
+    }
+    if err := applyPrintConfig(f, sheet, cm.Print); err != nil {
+        lg.Printf("Warning: print settings of %s: %v", sheet, err)
     }
 
-    log.Printf("=== %s week %d done ===", sheet, weekNum)
+    lg.Printf("=== %s week %d done ===", sheet, weekNum)
+    reportProgress(gc.ctx, fmt.Sprintf("rendered_week_%d", weekNum), sheet)
     return nil
 }
 
+// setMapped writes v to cell unless the template has no such cell mapped
+func setMapped(f *excelize.File, sheet, cell string, v interface{}) {
+    if cell == "" {
+        return
+    }
+    _ = f.SetCellValue(sheet, cell, v)
+}
+
 func getUniqueJobNumbersForType(entries []Entry, isOvertime bool) []string {
     seen := make(map[string]bool)
     var out []string
@@ -543,10 +909,7 @@ func getUniqueJobNumbersForType(entries []Entry, isOvertime bool) []string {
         if e.Overtime != isOvertime {
             continue
         }
-        key := e.JobCode
-        if e.IsNightShift {
-            key = "N" + key
-        }
+        key := entryKey(e)
         if !seen[key] {
             seen[key] = true
             out = append(out, key)
@@ -555,17 +918,59 @@ func getUniqueJobNumbersForType(entries []Entry, isOvertime bool) []string {
     return out
 }
 
+// entryKey names the sheet column an entry's hours go in: its job number
+// (and phase and cost code, see costcodes.go), prefixed "N" for night
+// shift and "DT:" for double time
+func entryKey(e Entry) string {
+    key := costLevels(e)
+    if e.IsNightShift {
+        key = "N" + key
+    }
+    if e.DoubleTime {
+        key = "DT:" + key
+    }
+    return key
+}
+
+// splitEntryKey undoes entryKey
+func splitEntryKey(key string) (job string, night, double bool) {
+    if strings.HasPrefix(key, "DT:") {
+        key, double = key[3:], true
+    }
+    if strings.HasPrefix(key, "N") {
+        key, night = key[1:], true
+    }
+    return key, night, double
+}
+
+// timeToExcelDate converts a calendar day (UTC midnight, see calendarDate)
+// to an Excel serial date
 func timeToExcelDate(t time.Time) float64 {
     excelEpoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
     return t.Sub(excelEpoch).Hours() / 24.0
 }
 
-func generateBasicExcelFile(req TimecardRequest) ([]byte, error) {
+func generateBasicExcelFile(gc *genContext) ([]byte, error) {
+    req, theme := gc.req, gc.theme
     f := excelize.NewFile()
     defer func() { _ = f.Close() }()
     const sheet = "Sheet1"
-    _ = f.SetCellValue(sheet, "A1", "Employee:")
+    if req.Bilingual {
+        _ = f.SetCellValue(sheet, "A1", bilingual("Employee:", "Employé(e) :"))
+    } else {
+        _ = f.SetCellValue(sheet, "A1", "Employee:")
+    }
     _ = f.SetCellValue(sheet, "B1", req.EmployeeName)
+    if theme != nil {
+        if theme.FontFamily != "" {
+            _ = f.SetDefaultFont(theme.FontFamily)
+        }
+        t := newThemer(f, theme)
+        _ = t.apply(sheet, "A1", true)
+        _ = t.apply(sheet, "B1", false)
+    }
+    stampArtifactRef(gc, f, stampDraft(gc, f, nil))
+    applyPageSetup(gc, f)
     buf, err := f.WriteToBuffer()
     if err != nil {
         return nil, err
@@ -577,12 +982,52 @@ func generateBasicExcelFile(req TimecardRequest) ([]byte, error) {
    Email utils
    ========== */
 
-func sendEmail(to string, cc *string, subject string, body string, attachment []byte, employeeName string) error {
-    smtpHost := os.Getenv("SMTP_HOST")
-    smtpPort := os.Getenv("SMTP_PORT")
-    smtpUser := os.Getenv("SMTP_USER")
-    smtpPass := os.Getenv("SMTP_PASS")
-    fromEmail := os.Getenv("SMTP_FROM")
+// emailAttachmentName is timecard_<employee>_<date>.xlsx, dated in the
+// tenant's zone; employee is the card's fileEmployee
+func emailAttachmentName(employee string, loc *time.Location) string {
+    return fmt.Sprintf("timecard_%s_%s.xlsx",
+        strings.ReplaceAll(employee, " ", "_"),
+        today(loc).Format("2006-01-02"))
+}
+
+// SMTPSettings is an SMTP server and login; a tenant's replaces SMTP_*
+type SMTPSettings struct {
+    Host string `json:"host"`
+    Port string `json:"port"`
+    User string `json:"user"`
+    Pass string `json:"pass"`
+    From string `json:"from,omitempty"`
+}
+
+// smtpSettings is the server mail for ctx's tenant goes through
+func smtpSettings(ctx context.Context) SMTPSettings {
+    if t := tenantFrom(ctx); t != nil && t.SMTP != nil {
+        return *t.SMTP
+    }
+    return SMTPSettings{
+        Host: os.Getenv("SMTP_HOST"),
+        Port: os.Getenv("SMTP_PORT"),
+        User: os.Getenv("SMTP_USER"),
+        Pass: os.Getenv("SMTP_PASS"),
+        From: os.Getenv("SMTP_FROM"),
+    }
+}
+
+func sendEmail(ctx context.Context, to string, cc *string, subject string, body string, attachment []byte, fileName string, lg *requestLogger, extra ...EmailAttachment) (err error) {
+    _, sp := startSpan(ctx, "email.send", spanKindClient)
+    defer func() {
+        sp.RecordError(err)
+        sp.End()
+    }()
+
+    release, err := pools().email.acquire(ctx)
+    if err != nil {
+        return err
+    }
+    defer release()
+
+    s := smtpSettings(ctx)
+    smtpHost, smtpPort, smtpUser, smtpPass, fromEmail := s.Host, s.Port, s.User, s.Pass, s.From
 
     if smtpHost == "" || smtpPort == "" || smtpUser == "" || smtpPass == "" {
         return fmt.Errorf("SMTP not configured")
@@ -607,51 +1052,192 @@ func sendEmail(to string, cc *string, subject string, body string, attachment []
     all := append([]string{}, recipients...)
     all = append(all, ccRecipients...)
 
-    fileName := fmt.Sprintf("timecard_%s_%s.xlsx",
-        strings.ReplaceAll(employeeName, " ", "_"),
-        time.Now().Format("2006-01-02"))
-
-    msg := buildEmailMessage(fromEmail, recipients, ccRecipients, subject, body, attachment, fileName)
     auth := smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)
     addr := fmt.Sprintf("%s:%s", smtpHost, smtpPort)
-    return smtp.SendMail(addr, auth, fromEmail, all, []byte(msg))
+    lg.Printf("Sending email via %s to %d recipient(s)", addr, len(all))
+    auditRecipients(ctx, all...)
+    body = emailFooter(tenantFrom(ctx), body)
+    sp.SetAttr("smtp.host", smtpHost)
+    sp.SetAttr("email.recipients", len(all))
+    sp.SetAttr("email.attachment_bytes", len(attachment))
+    sp.SetAttr("email.extra_attachments", len(extra))
+    return sendMail(ctx, addr, auth, fromEmail, all, func(w io.Writer) error {
+        var att io.Reader
+        if len(attachment) > 0 {
+            att = bytes.NewReader(attachment)
+        }
+        return writeEmailMessage(w, fromEmail, recipients, ccRecipients, subject, body, att, fileName, lg.requestID(), extra...)
+    })
 }
 
-func buildEmailMessage(from string, to []string, cc []string, subject string, body string, attachment []byte, fileName string) string {
-    boundary := "==BOUNDARY=="
-    var buf bytes.Buffer
+// sendMail is smtp.SendMail, except that the message is written straight
+// into the DATA stream by write instead of being passed in as one slice.
+func sendMail(ctx context.Context, addr string, auth smtp.Auth, from string, to []string, write func(io.Writer) error) error {
+    host, _, err := net.SplitHostPort(addr)
+    if err != nil {
+        return err
+    }
+    conn, err := (&net.Dialer{Timeout: 30 * time.Second}).DialContext(ctx, "tcp", addr)
+    if err != nil {
+        return err
+    }
+    c, err := smtp.NewClient(conn, host)
+    if err != nil {
+        conn.Close()
+        return err
+    }
+    defer c.Close()
 
-    buf.WriteString(fmt.Sprintf("From: %s\r\n", from))
-    buf.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(to, ", ")))
+    if ok, _ := c.Extension("STARTTLS"); ok {
+        if err := c.StartTLS(&tls.Config{ServerName: host}); err != nil {
+            return err
+        }
+    }
+    if auth != nil {
+        if ok, _ := c.Extension("AUTH"); !ok {
+            return fmt.Errorf("smtp: server doesn't support AUTH")
+        }
+        if err := c.Auth(auth); err != nil {
+            return err
+        }
+    }
+    if err := c.Mail(from); err != nil {
+        return err
+    }
+    for _, rcpt := range to {
+        if err := c.Rcpt(rcpt); err != nil {
+            return err
+        }
+    }
+    w, err := c.Data()
+    if err != nil {
+        return err
+    }
+    if err := write(w); err != nil {
+        return err
+    }
+    if err := w.Close(); err != nil {
+        return err
+    }
+    return c.Quit()
+}
+
+// writeEmailMessage writes the message to w as it goes, base64-encoding
+// the attachment (if any) and the extra files on the fly, so a large file
+// is never held in memory a second time as text.
+func writeEmailMessage(w io.Writer, from string, to []string, cc []string, subject string, body string, attachment io.Reader, fileName string, requestID string, extra ...EmailAttachment) error {
+    mw := multipart.NewWriter(w)
+
+    var hdr bytes.Buffer
+    hdr.WriteString(fmt.Sprintf("From: %s\r\n", from))
+    hdr.WriteString(fmt.Sprintf("To: %s\r\n", strings.Join(to, ", ")))
     if len(cc) > 0 {
-        buf.WriteString(fmt.Sprintf("Cc: %s\r\n", strings.Join(cc, ", ")))
+        hdr.WriteString(fmt.Sprintf("Cc: %s\r\n", strings.Join(cc, ", ")))
+    }
+    hdr.WriteString(fmt.Sprintf("Subject: %s\r\n", subject))
+    if requestID != "" {
+        hdr.WriteString(fmt.Sprintf("X-Timecard-Request-ID: %s\r\n", requestID))
+    }
+    hdr.WriteString("MIME-Version: 1.0\r\n")
+    hdr.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\r\n\r\n", mw.Boundary()))
+    if _, err := w.Write(hdr.Bytes()); err != nil {
+        return err
     }
-    buf.WriteString(fmt.Sprintf("Subject: %s\r\n", subject))
-    buf.WriteString("MIME-Version: 1.0\r\n")
-    buf.WriteString(fmt.Sprintf("Content-Type: multipart/mixed; boundary=\"%s\"\r\n\r\n", boundary))
 
     // body
-    buf.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-    buf.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n\r\n")
-    buf.WriteString(body + "\r\n\r\n")
-
-    // attachment
-    if len(attachment) > 0 {
-        buf.WriteString(fmt.Sprintf("--%s\r\n", boundary))
-        buf.WriteString("Content-Type: application/vnd.openxmlformats-officedocument.spreadsheetml.sheet\r\n")
-        buf.WriteString(fmt.Sprintf("Content-Disposition: attachment; filename=\"%s\"\r\n", fileName))
-        buf.WriteString("Content-Transfer-Encoding: base64\r\n\r\n")
-        enc := base64.StdEncoding.EncodeToString(attachment)
-        for i := 0; i < len(enc); i += 76 {
-            end := i + 76
-            if end > len(enc) {
-                end = len(enc)
+    part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {`text/plain; charset="utf-8"`}})
+    if err != nil {
+        return err
+    }
+    if _, err := io.WriteString(part, body+"\r\n"); err != nil {
+        return err
+    }
+
+    // attachment, then the photos and receipts sent with it
+    if attachment != nil {
+        if err := writeAttachmentPart(mw, attachmentContentType(fileName), fileName, attachment); err != nil {
+            return err
+        }
+    }
+    for _, a := range extra {
+        if err := writeAttachmentPart(mw, a.contentType(), a.FileName, bytes.NewReader(a.Data)); err != nil {
+            return err
+        }
+    }
+
+    return mw.Close()
+}
+
+// writeAttachmentPart adds one base64-encoded attachment to the message
+func writeAttachmentPart(mw *multipart.Writer, contentType, fileName string, data io.Reader) error {
+    part, err := mw.CreatePart(textproto.MIMEHeader{
+        "Content-Type":              {contentType},
+        "Content-Disposition":       {fmt.Sprintf("attachment; filename=\"%s\"", fileName)},
+        "Content-Transfer-Encoding": {"base64"},
+    })
+    if err != nil {
+        return err
+    }
+    lines := &lineBreaker{w: part}
+    enc := base64.NewEncoder(base64.StdEncoding, lines)
+    if _, err := io.Copy(enc, data); err != nil {
+        return err
+    }
+    if err := enc.Close(); err != nil {
+        return err
+    }
+    return lines.Close()
+}
+
+func attachmentContentType(fileName string) string {
+    switch strings.ToLower(filepath.Ext(fileName)) {
+    case ".pdf":
+        return "application/pdf"
+    case ".xlsx":
+        return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+    case ".zip":
+        return "application/zip"
+    }
+    return "application/octet-stream"
+}
+
+// lineBreaker ends a line every 76 bytes, as RFC 2045 wants of base64 bodies
+type lineBreaker struct {
+    w   io.Writer
+    col int
+}
+
+const mimeLineLength = 76
+
+func (l *lineBreaker) Write(p []byte) (n int, err error) {
+    for len(p) > 0 {
+        if l.col == mimeLineLength {
+            if _, err := io.WriteString(l.w, "\r\n"); err != nil {
+                return n, err
             }
-            buf.WriteString(enc[i:end] + "\r\n")
+            l.col = 0
+        }
+        k := mimeLineLength - l.col
+        if k > len(p) {
+            k = len(p)
+        }
+        written, err := l.w.Write(p[:k])
+        n += written
+        l.col += written
+        if err != nil {
+            return n, err
         }
-        buf.WriteString("\r\n")
+        p = p[k:]
     }
+    return n, nil
+}
 
-    buf.WriteString(fmt.Sprintf("--%s--\r\n", boundary))
-    return buf.String()
+// Close ends the last line
+func (l *lineBreaker) Close() error {
+    if l.col == 0 {
+        return nil
+    }
+    l.col = 0
+    _, err := io.WriteString(l.w, "\r\n")
+    return err
 }
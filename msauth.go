@@ -0,0 +1,82 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* =======================
+   Microsoft identity auth
+   ======================= */
+
+// Microsoft Graph is called as an app registration (client credentials):
+// MS_TENANT_ID, MS_CLIENT_ID and MS_CLIENT_SECRET. The app needs the
+// application permissions of whatever is called, e.g. Sites.ReadWrite.All.
+
+type msTokenSource struct {
+    tokenURL string
+    form     url.Values
+    client   *http.Client
+
+    mu      sync.Mutex
+    token   string
+    expires time.Time
+}
+
+// newMSTokenSource reads the app registration from the environment
+func newMSTokenSource(scope string) (*msTokenSource, error) {
+    tenant, id, secret := os.Getenv("MS_TENANT_ID"), os.Getenv("MS_CLIENT_ID"), os.Getenv("MS_CLIENT_SECRET")
+    if tenant == "" || id == "" || secret == "" {
+        return nil, fmt.Errorf("MS_TENANT_ID, MS_CLIENT_ID and MS_CLIENT_SECRET are required")
+    }
+    login := strings.TrimSuffix(envOr("MS_LOGIN_URL", "https://login.microsoftonline.com"), "/")
+    return &msTokenSource{
+        tokenURL: login + "/" + url.PathEscape(tenant) + "/oauth2/v2.0/token",
+        form: url.Values{
+            "grant_type":    {"client_credentials"},
+            "client_id":     {id},
+            "client_secret": {secret},
+            "scope":         {scope},
+        },
+        client: &http.Client{Timeout: 10 * time.Second},
+    }, nil
+}
+
+// Token returns a cached access token, fetching a new one near expiry
+func (m *msTokenSource) Token(ctx context.Context) (string, error) {
+    m.mu.Lock()
+    defer m.mu.Unlock()
+    if m.token != "" && time.Now().Before(m.expires) {
+        return m.token, nil
+    }
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.tokenURL, strings.NewReader(m.form.Encode()))
+    if err != nil {
+        return "", err
+    }
+    req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+    resp, err := m.client.Do(req)
+    if err != nil {
+        return "", fmt.Errorf("microsoft token: %w", err)
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+    var out struct {
+        AccessToken string `json:"access_token"`
+        ExpiresIn   int    `json:"expires_in"`
+        Error       string `json:"error_description"`
+    }
+    if err := json.Unmarshal(body, &out); err != nil || out.AccessToken == "" {
+        return "", fmt.Errorf("microsoft token: %s %s", resp.Status, strings.TrimSpace(out.Error))
+    }
+    m.token = out.AccessToken
+    m.expires = time.Now().Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute)
+    return m.token, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "strings"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ===========
+   Named cells
+   =========== */
+
+// A template workbook can name the cells the server fills instead of
+// relying on cellmap.json's addresses, so its author can move them about
+// in Excel without breaking generation. A defined name wins over the cell
+// map's address for the same thing:
+//
+//   EmployeeName, EmployeeID, PayrollNumber, PayPeriod, Year, WeekStart,
+//   WeekLabel, Revision, NightPremiumTotal, Supervisor, ApprovedAt
+//                    a single cell, e.g. 'Week 1'!$M$2
+//   RegularTable, OvertimeTable
+//                    the regular and overtime tables, header row first,
+//                    e.g. 'Week 1'!$A$4:$AJ12; only their rows are taken,
+//                    the columns stay as cellmap.json has them
+//
+// Names are read from the template as opened; the address applies to every
+// week sheet, whichever sheet the name points into. Names that don't point
+// at a cell or range (#REF! and the like) are ignored.
+
+// namedCells maps each name to the cell map address it sets
+var namedCells = map[string]func(*CellMap) *string{
+    "EmployeeName":      func(m *CellMap) *string { return &m.EmployeeName },
+    "EmployeeID":        func(m *CellMap) *string { return &m.EmployeeID },
+    "PayrollNumber":     func(m *CellMap) *string { return &m.PayrollNumber },
+    "PayPeriod":         func(m *CellMap) *string { return &m.PayPeriod },
+    "Year":              func(m *CellMap) *string { return &m.Year },
+    "WeekStart":         func(m *CellMap) *string { return &m.WeekStart },
+    "WeekLabel":         func(m *CellMap) *string { return &m.WeekLabel },
+    "Revision":          func(m *CellMap) *string { return &m.Revision },
+    "NightPremiumTotal": func(m *CellMap) *string { return &m.NightPremiumTotal },
+    "Supervisor":        func(m *CellMap) *string { return &m.Supervisor },
+    "ApprovedAt":        func(m *CellMap) *string { return &m.ApprovedAt },
+}
+
+// namedTables maps each table name to its header and first rows
+var namedTables = map[string]func(*CellMap) (header, first *int){
+    "RegularTable":  func(m *CellMap) (*int, *int) { return &m.RegularHeaderRow, &m.RegularFirstRow },
+    "OvertimeTable": func(m *CellMap) (*int, *int) { return &m.OvertimeHeaderRow, &m.OvertimeFirstRow },
+}
+
+// withNamedCells returns t with the cell map f's defined names give it, or
+// t itself when f names none of them
+func withNamedCells(gc *genContext, f *excelize.File, t *templateDef) *templateDef {
+    cm, found := t.CellMap, false
+    for _, dn := range f.GetDefinedName() {
+        if set, ok := namedCells[dn.Name]; ok {
+            from, to, ok := definedNameCells(dn.RefersTo)
+            if !ok || from != to {
+                gc.lg.Printf("Warning: template name %s (%s) is not a single cell; ignoring it", dn.Name, dn.RefersTo)
+                continue
+            }
+            *set(&cm), found = from, true
+        }
+        if set, ok := namedTables[dn.Name]; ok {
+            from, _, ok := definedNameCells(dn.RefersTo)
+            if !ok {
+                gc.lg.Printf("Warning: template name %s (%s) is not a range; ignoring it", dn.Name, dn.RefersTo)
+                continue
+            }
+            _, row, _ := excelize.CellNameToCoordinates(from)
+            header, first := set(&cm)
+            *header, *first, found = row, row+1, true
+        }
+    }
+    if !found {
+        return t
+    }
+    if err := cm.validate(); err != nil {
+        gc.lg.Printf("Warning: template %s's names give %v; using cellmap.json", t.Name, err)
+        return t
+    }
+    named := *t
+    named.CellMap = cm
+    return &named
+}
+
+// definedNameCells parses a name's refers-to, "'Week 1'!$A$4:$AJ$12" or
+// "Sheet1!$M$2", into its first and last cells
+func definedNameCells(refersTo string) (from, to string, ok bool) {
+    i := strings.LastIndex(refersTo, "!")
+    if i < 0 {
+        return "", "", false
+    }
+    ref := strings.ReplaceAll(refersTo[i+1:], "$", "")
+    from, to, _ = strings.Cut(ref, ":")
+    if to == "" {
+        to = from
+    }
+    for _, cell := range []string{from, to} {
+        if _, _, err := excelize.CellNameToCoordinates(cell); err != nil {
+            return "", "", false
+        }
+    }
+    return from, to, true
+}
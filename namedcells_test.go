@@ -0,0 +1,42 @@
+package main
+
+import (
+    "context"
+    "testing"
+
+    "github.com/xuri/excelize/v2"
+)
+
+func TestWithNamedCells(t *testing.T) {
+    gc := newGenContext(context.Background(), TimecardRequest{}, nil, loggerFrom(context.Background()))
+    tpl, _ := resolveTemplate("", "")
+    f := excelize.NewFile()
+    if got := withNamedCells(gc, f, tpl); got != tpl {
+        t.Error("copied a template without names")
+    }
+
+    for _, dn := range []excelize.DefinedName{
+        {Name: "EmployeeName", RefersTo: "Sheet1!$N$3"},
+        {Name: "PayPeriod", RefersTo: "'Sheet1'!AK2"},
+        {Name: "RegularTable", RefersTo: "Sheet1!$A$6:$AJ$14"},
+        {Name: "OvertimeTable", RefersTo: "Sheet1!$A$18:$AJ$27"},
+        {Name: "WeekLabel", RefersTo: "Sheet1!$AJ$4:$AK$4"}, // not a cell
+        {Name: "Year", RefersTo: "#REF!"},
+        {Name: "OT", RefersTo: "Sheet1!$A$1"},
+    } {
+        if err := f.SetDefinedName(&dn); err != nil {
+            t.Fatal(err)
+        }
+    }
+    got := withNamedCells(gc, f, tpl)
+    cm := got.CellMap
+    if cm.EmployeeName != "N3" || cm.PayPeriod != "AK2" || cm.WeekLabel != "AJ4" || cm.Year != "AJ3" {
+        t.Errorf("cells: name %s, period %s, week label %s, year %s", cm.EmployeeName, cm.PayPeriod, cm.WeekLabel, cm.Year)
+    }
+    if cm.RegularHeaderRow != 6 || cm.RegularFirstRow != 7 || cm.OvertimeHeaderRow != 18 || cm.OvertimeFirstRow != 19 {
+        t.Errorf("tables: regular %d/%d, overtime %d/%d", cm.RegularHeaderRow, cm.RegularFirstRow, cm.OvertimeHeaderRow, cm.OvertimeFirstRow)
+    }
+    if tpl.CellMap.EmployeeName != "M2" {
+        t.Error("changed the template's own cell map")
+    }
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+    "fmt"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==================
+   Night differential
+   ================== */
+
+// Besides the "N" on a night shift's labour code, a tenant can have the
+// night premium worked out:
+//
+//   "night_differential": {"window_start": "22:00", "window_end": "06:00", "multiplier": 1}
+//
+// An entry with a start time ("start": "21:00", HH:MM on the entry's date)
+// earns premium for the hours of it inside the window, which may run past
+// midnight; one without earns it for all its hours when it is marked
+// night shift. The premium hours are those hours times multiplier
+// (default 1, the hours themselves; 0.1 is a 10% differential). They are
+// booked to the day the entry starts and written where the template maps
+// them, one cell per day and a total:
+//
+//   "night_premium_column": "AL", "night_premium_total": "AI13"
+//
+// The bundled template has the total only, in AI13 (which its NS: box
+// reads); a template that maps neither is left as it was.
+
+// NightDifferential is a tenant's night shift premium rule
+type NightDifferential struct {
+    WindowStart string  `json:"window_start"` // HH:MM
+    WindowEnd   string  `json:"window_end"`   // HH:MM, before WindowStart when it runs past midnight
+    Multiplier  float64 `json:"multiplier,omitempty"`
+}
+
+func (n *NightDifferential) validate() error {
+    if _, err := clockHours(n.WindowStart); err != nil {
+        return fmt.Errorf("night differential window_start: %w", err)
+    }
+    if _, err := clockHours(n.WindowEnd); err != nil {
+        return fmt.Errorf("night differential window_end: %w", err)
+    }
+    if n.WindowStart == n.WindowEnd {
+        return fmt.Errorf("night differential window is empty")
+    }
+    if n.Multiplier < 0 {
+        return fmt.Errorf("night differential multiplier must not be negative")
+    }
+    return nil
+}
+
+// clockHours reads HH:MM as hours after midnight
+func clockHours(s string) (float64, error) {
+    t, err := time.Parse("15:04", s)
+    if err != nil {
+        return 0, fmt.Errorf("%q is not HH:MM", s)
+    }
+    return float64(t.Hour()) + float64(t.Minute())/60, nil
+}
+
+// premium is the premium hours an entry earns
+func (n *NightDifferential) premium(e Entry) float64 {
+    if n == nil || !e.worked() || e.Hours <= 0 {
+        return 0
+    }
+    mult := n.Multiplier
+    if mult == 0 {
+        mult = 1
+    }
+    if e.Start == "" {
+        if e.IsNightShift {
+            return e.Hours * mult
+        }
+        return 0
+    }
+    start, err := clockHours(e.Start)
+    if err != nil {
+        return 0
+    }
+    ws, _ := clockHours(n.WindowStart)
+    we, _ := clockHours(n.WindowEnd)
+    if we <= ws {
+        we += 24
+    }
+    end := start + e.Hours
+    var in float64
+    for day := -24.0; day <= 24; day += 24 { // yesterday's, today's and tomorrow's windows
+        lo, hi := ws+day, we+day
+        if start > lo {
+            lo = start
+        }
+        if end < hi {
+            hi = end
+        }
+        if hi > lo {
+            in += hi - lo
+        }
+    }
+    return in * mult
+}
+
+// checkEntryStarts reports start times that aren't HH:MM
+func checkEntryStarts(v *validationResult, req TimecardRequest) {
+    for _, e := range allEntries(req) {
+        if e.Start == "" {
+            continue
+        }
+        if _, err := clockHours(e.Start); err != nil {
+            v.errorf("%s: start %v", e.Date, err)
+        }
+    }
+}
+
+// writeNightPremium fills the template's night premium cells for one week
+func writeNightPremium(gc *genContext, f *excelize.File, sheet string, week WeekData, weekStart time.Time) {
+    n := gc.tenant.nightDifferential()
+    cm := gc.tpl.CellMap
+    if n == nil || (cm.NightPremiumColumn == "" && cm.NightPremiumTotal == "") {
+        return
+    }
+    var days [7]float64
+    var total float64
+    for _, e := range week.Entries {
+        day, err := parseCalendarDate(e.Date, gc.loc)
+        if err != nil {
+            continue
+        }
+        d := int(day.Sub(weekStart).Hours() / 24)
+        if d < 0 || d > 6 {
+            continue
+        }
+        p := n.premium(e)
+        days[d] += p
+        total += p
+    }
+    if cm.NightPremiumColumn != "" {
+        for d, h := range days {
+            if h != 0 {
+                _ = f.SetCellValue(sheet, fmt.Sprintf("%s%d", cm.NightPremiumColumn, cm.RegularFirstRow+d), roundHours(h))
+            }
+        }
+    }
+    setMapped(f, sheet, cm.NightPremiumTotal, roundHours(total))
+    gc.lg.Printf("  night premium %.2f h", total)
+}
+
+// nightDifferential returns the tenant's rule, or nil when none is configured
+func (t *Tenant) nightDifferential() *NightDifferential {
+    if t == nil {
+        return nil
+    }
+    return t.NightDifferential
+}
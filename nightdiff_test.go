@@ -0,0 +1,72 @@
+package main
+
+import (
+    "testing"
+)
+
+func TestNightPremium(t *testing.T) {
+    overnight := &NightDifferential{WindowStart: "22:00", WindowEnd: "06:00"}
+    tenth := &NightDifferential{WindowStart: "22:00", WindowEnd: "06:00", Multiplier: 0.1}
+    early := &NightDifferential{WindowStart: "00:00", WindowEnd: "06:00"}
+
+    shift := func(start string, hours float64) Entry {
+        e := worked("Mon", "A", hours)
+        e.Start = start
+        return e
+    }
+    night := func(e Entry) Entry { e.IsNightShift = true; return e }
+    leave := func(e Entry) Entry { e.Type = entryVacation; return e }
+
+    tests := []struct {
+        name  string
+        rule  *NightDifferential
+        entry Entry
+        want  float64
+    }{
+        {"into the window", overnight, shift("21:00", 8), 7},
+        {"past midnight and out", overnight, shift("23:00", 10), 7},
+        {"in yesterday's window", overnight, shift("02:00", 6), 4},
+        {"day shift", overnight, shift("08:00", 8), 0},
+        {"ends as the window opens", overnight, shift("14:00", 8), 0},
+        {"starts as the window closes", overnight, shift("06:00", 8), 0},
+        {"whole window and more", overnight, shift("20:00", 12), 8},
+        {"into a second window", overnight, shift("12:00", 16), 6},
+        {"multiplier", tenth, shift("21:00", 8), 0.7},
+        {"window after midnight", early, shift("22:00", 8), 6},
+        {"window after midnight, day shift", early, shift("07:00", 8), 0},
+        {"no start, night shift", overnight, night(worked("Mon", "A", 10)), 10},
+        {"no start, day shift", overnight, worked("Mon", "A", 10), 0},
+        {"bad start", overnight, shift("9pm", 8), 0},
+        {"leave", overnight, leave(night(worked("Mon", "VAC", 8))), 0},
+        {"no rule", nil, shift("21:00", 8), 0},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if got := roundHours(tt.rule.premium(tt.entry)); got != tt.want {
+                t.Errorf("premium = %g, want %g", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestNightDifferentialValidate(t *testing.T) {
+    tests := []struct {
+        name string
+        rule NightDifferential
+        ok   bool
+    }{
+        {"overnight", NightDifferential{WindowStart: "22:00", WindowEnd: "06:00"}, true},
+        {"same day", NightDifferential{WindowStart: "18:00", WindowEnd: "23:30", Multiplier: 0.1}, true},
+        {"empty", NightDifferential{WindowStart: "22:00", WindowEnd: "22:00"}, false},
+        {"bad start", NightDifferential{WindowStart: "10pm", WindowEnd: "06:00"}, false},
+        {"bad end", NightDifferential{WindowStart: "22:00", WindowEnd: "25:00"}, false},
+        {"negative", NightDifferential{WindowStart: "22:00", WindowEnd: "06:00", Multiplier: -1}, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if err := tt.rule.validate(); (err == nil) != tt.ok {
+                t.Errorf("validate() = %v, want ok=%v", err, tt.ok)
+            }
+        })
+    }
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+    "fmt"
+    "slices"
+    "strings"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ===========
+   Entry notes
+   =========== */
+
+// An entry can carry a short explanation for payroll,
+//
+//   {"date": "2025-01-06", "job_code": "29699", "hours": 4, "notes": "rain delay"}
+//
+// which goes on the card with its hours. A template that maps a notes
+// column,
+//
+//   "notes_column": "AK"
+//
+// gets each day's notes there, on the row of the table (regular or
+// overtime) the hours are in; otherwise each note is a comment on the
+// cell holding its hours. Several notes in one place are joined with
+// "; ", each once. Notes on hours that don't make it onto the sheet (a
+// job past the last column) are dropped with the hours.
+
+// writeEntryNotes puts the notes of one week's entries on the sheet
+func writeEntryNotes(gc *genContext, f *excelize.File, sheet string, week WeekData, weekStart time.Time, regularKeys, overtimeKeys []string) {
+    cm := gc.tpl.CellMap
+    var places []string // cells or rows, in the order first noted
+    notes := map[string][]string{}
+    for _, e := range week.Entries {
+        note := strings.TrimSpace(e.Notes)
+        if note == "" {
+            continue
+        }
+        day, err := parseCalendarDate(e.Date, gc.loc)
+        if err != nil {
+            continue
+        }
+        d := int(day.Sub(weekStart).Hours() / 24)
+        if d < 0 || d > 6 {
+            continue
+        }
+        row, keys := cm.RegularFirstRow+d, regularKeys
+        if e.Overtime {
+            row, keys = cm.OvertimeFirstRow+d, overtimeKeys
+        }
+        i := slices.Index(keys, entryKey(e))
+        if i < 0 || i >= len(cm.CodeColumns) {
+            continue
+        }
+        place := fmt.Sprintf("%s%d", cm.CodeColumns[i], row)
+        if cm.NotesColumn != "" {
+            place = fmt.Sprintf("%s%d", cm.NotesColumn, row)
+        }
+        if notes[place] == nil {
+            places = append(places, place)
+        }
+        if !slices.Contains(notes[place], note) {
+            notes[place] = append(notes[place], note)
+        }
+    }
+
+    for _, cell := range places {
+        text := strings.Join(notes[cell], "; ")
+        if cm.NotesColumn != "" {
+            _ = f.SetCellValue(sheet, cell, text)
+            continue
+        }
+        if err := f.AddComment(sheet, excelize.Comment{Cell: cell, Author: gc.req.EmployeeName, Text: text}); err != nil {
+            gc.lg.Printf("Warning: note on %s!%s: %v", sheet, cell, err)
+        }
+    }
+    if len(places) > 0 {
+        gc.lg.Printf("  %d note(s)", len(places))
+    }
+}
+
+// joinNotes adds note b to a, once
+func joinNotes(a, b string) string {
+    switch {
+    case b == "" || a == b:
+        return a
+    case a == "":
+        return b
+    }
+    return a + "; " + b
+}
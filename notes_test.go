@@ -0,0 +1,85 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+func TestWriteEntryNotes(t *testing.T) {
+    noted := func(e Entry, note string) Entry { e.Notes = note; return e }
+    overtime := func(e Entry) Entry { e.Overtime = true; return e }
+    week := WeekData{Entries: []Entry{
+        noted(worked("Mon", "A", 4), "rain delay"),
+        noted(worked("Mon", "A", 4), "rain delay"),
+        noted(worked("Mon", "B", 4), "travel time"),
+        noted(overtime(worked("Tue", "A", 2)), "pour ran late"),
+        worked("Wed", "A", 8),
+    }}
+    weekStart := time.Date(2025, 1, 5, 0, 0, 0, 0, time.UTC)
+    regular := getUniqueJobNumbersForType(week.Entries, false)
+    overtimeKeys := getUniqueJobNumbersForType(week.Entries, true)
+
+    render := func(cm CellMap) *excelize.File {
+        f := excelize.NewFile()
+        gc := newGenContext(context.Background(), TimecardRequest{EmployeeName: "Bob Smith"}, &Tenant{ID: "notes-test"}, loggerFrom(context.Background()))
+        gc.tpl = &templateDef{CellMap: cm}
+        writeEntryNotes(gc, f, "Sheet1", week, weekStart, regular, overtimeKeys)
+        return f
+    }
+
+    // comments on the hour cells: A and B in the first two code columns,
+    // Monday on the second row of each table
+    cm := defaultCellMap()
+    f := render(cm)
+    comments, err := f.GetComments("Sheet1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    got := map[string]string{}
+    for _, c := range comments {
+        got[c.Cell] = c.Text
+    }
+    c0, c1 := cm.CodeColumns[0], cm.CodeColumns[1]
+    want := map[string]string{
+        fmt.Sprintf("%s%d", c0, cm.RegularFirstRow+1):  "rain delay",
+        fmt.Sprintf("%s%d", c1, cm.RegularFirstRow+1):  "travel time",
+        fmt.Sprintf("%s%d", c0, cm.OvertimeFirstRow+2): "pour ran late",
+    }
+    if len(got) != len(want) {
+        t.Errorf("comments = %v, want %v", got, want)
+    }
+    for cell, text := range want {
+        if !strings.Contains(got[cell], text) {
+            t.Errorf("comment on %s = %q, want %q", cell, got[cell], text)
+        }
+    }
+
+    // a notes column gets the day's notes joined
+    cm.NotesColumn = "AK"
+    f = render(cm)
+    if v, _ := f.GetCellValue("Sheet1", fmt.Sprintf("AK%d", cm.RegularFirstRow+1)); v != "rain delay; travel time" {
+        t.Errorf("Monday's notes = %q", v)
+    }
+    if v, _ := f.GetCellValue("Sheet1", fmt.Sprintf("AK%d", cm.OvertimeFirstRow+2)); v != "pour ran late" {
+        t.Errorf("Tuesday's overtime notes = %q", v)
+    }
+}
+
+func TestJoinNotes(t *testing.T) {
+    for _, tt := range []struct{ a, b, want string }{
+        {"", "", ""},
+        {"rain", "", "rain"},
+        {"", "rain", "rain"},
+        {"rain", "rain", "rain"},
+        {"rain", "travel", "rain; travel"},
+    } {
+        if got := joinNotes(tt.a, tt.b); got != tt.want {
+            t.Errorf("joinNotes(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+        }
+    }
+}
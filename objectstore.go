@@ -0,0 +1,349 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/md5"
+    "crypto/sha256"
+    "encoding/base64"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "mime"
+    "mime/multipart"
+    "net/http"
+    "net/textproto"
+    "net/url"
+    "os"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ======================
+   Object-storage archive
+   ====================== */
+
+// Every generated file is also written once to an object-storage bucket,
+// which is what satisfies the 7-year record-keeping requirement:
+//
+//   ARCHIVE_STORAGE         s3 (any S3-compatible service) or gcs
+//   ARCHIVE_PREFIX          optional key prefix inside the bucket
+//   ARCHIVE_RETENTION_DAYS  retention set on each object (default 2557, 7 years)
+//
+//   S3_BUCKET, S3_REGION (default us-east-1), S3_ENDPOINT (default AWS),
+//   AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, AWS_SESSION_TOKEN
+//   S3_OBJECT_LOCK_MODE     GOVERNANCE or COMPLIANCE; needs Object Lock on the bucket
+//
+//   GCS_BUCKET, GOOGLE_APPLICATION_CREDENTIALS
+//   GCS_RETENTION_MODE      Unlocked or Locked; needs object retention on the bucket
+//
+// Objects are created only if absent, so nothing already archived can be
+// replaced from here. Without a lock mode, retention is whatever the
+// bucket's default retention policy says; set one there or here. The
+// tenant, record, employee, pay period, request ID and SHA-256 travel as
+// object metadata.
+
+const defaultRetentionDays = 2557
+
+var (
+    archiveOnce sync.Once
+    theArchive  Storage
+)
+
+// archiveStore returns the archive bucket, or nil when none is configured
+func archiveStore() Storage {
+    archiveOnce.Do(func() {
+        var (
+            a   Storage
+            err error
+        )
+        switch kind := os.Getenv("ARCHIVE_STORAGE"); kind {
+        case "":
+            return
+        case "s3":
+            a, err = newS3Archive()
+        case "gcs":
+            a, err = newGCSArchive()
+        default:
+            err = fmt.Errorf("unknown ARCHIVE_STORAGE %q", kind)
+        }
+        if err != nil {
+            log.Printf("Warning: archiving disabled: %v", err)
+            return
+        }
+        theArchive = a
+        log.Printf("Archiving generated files to %s", a.Name())
+    })
+    return theArchive
+}
+
+// archiveKey is obj's name in the bucket
+func archiveKey(obj StoredObject) string {
+    if prefix := strings.Trim(os.Getenv("ARCHIVE_PREFIX"), "/"); prefix != "" {
+        return prefix + "/" + obj.Key
+    }
+    return obj.Key
+}
+
+// retainUntil is when an object archived at t may first be deleted
+func retainUntil(t time.Time) time.Time {
+    return t.UTC().AddDate(0, 0, envInt("ARCHIVE_RETENTION_DAYS", defaultRetentionDays)).Truncate(time.Second)
+}
+
+/* ----- S3 ----- */
+
+type s3Archive struct {
+    bucket, region, endpoint string
+    accessKey, secretKey     string
+    sessionToken             string
+    lockMode                 string
+    client                   *http.Client
+}
+
+func newS3Archive() (*s3Archive, error) {
+    a := &s3Archive{
+        bucket:       os.Getenv("S3_BUCKET"),
+        region:       envOr("S3_REGION", "us-east-1"),
+        accessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+        secretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+        sessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+        lockMode:     strings.ToUpper(os.Getenv("S3_OBJECT_LOCK_MODE")),
+        client:       &http.Client{Timeout: 60 * time.Second},
+    }
+    a.endpoint = strings.TrimSuffix(envOr("S3_ENDPOINT", "https://s3."+a.region+".amazonaws.com"), "/")
+    switch {
+    case a.bucket == "":
+        return nil, fmt.Errorf("S3_BUCKET is not set")
+    case a.accessKey == "" || a.secretKey == "":
+        return nil, fmt.Errorf("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+    case a.lockMode != "" && a.lockMode != "GOVERNANCE" && a.lockMode != "COMPLIANCE":
+        return nil, fmt.Errorf("S3_OBJECT_LOCK_MODE must be GOVERNANCE or COMPLIANCE")
+    }
+    return a, nil
+}
+
+func (a *s3Archive) Name() string { return "s3://" + a.bucket }
+
+// Put uploads obj path-style (<endpoint>/<bucket>/<key>), which every
+// S3-compatible service understands
+func (a *s3Archive) Put(ctx context.Context, obj StoredObject) (StoredCopy, error) {
+    key := archiveKey(obj)
+    at := now().UTC()
+    req, err := http.NewRequestWithContext(ctx, http.MethodPut, a.endpoint+"/"+s3Escape(a.bucket+"/"+key), bytes.NewReader(obj.Data))
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    md5sum := md5.Sum(obj.Data)
+    req.Header.Set("Content-Type", obj.ContentType)
+    req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5sum[:]))
+    req.Header.Set("If-None-Match", "*")
+    for k, v := range obj.Meta {
+        req.Header.Set("X-Amz-Meta-"+k, mime.QEncoding.Encode("utf-8", v))
+    }
+    if a.lockMode != "" {
+        req.Header.Set("X-Amz-Object-Lock-Mode", a.lockMode)
+        req.Header.Set("X-Amz-Object-Lock-Retain-Until-Date", retainUntil(at).Format(time.RFC3339))
+    }
+    if a.sessionToken != "" {
+        req.Header.Set("X-Amz-Security-Token", a.sessionToken)
+    }
+    a.sign(req, obj.Data, time.Now().UTC())
+
+    resp, err := a.client.Do(req)
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    defer resp.Body.Close()
+    body, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+    if resp.StatusCode >= 300 {
+        if resp.StatusCode == http.StatusPreconditionFailed {
+            return StoredCopy{}, fmt.Errorf("s3: %s is already archived", key)
+        }
+        return StoredCopy{}, fmt.Errorf("s3: %s %s", resp.Status, s3ErrorMessage(body))
+    }
+    return StoredCopy{Store: "s3", Location: "s3://" + a.bucket + "/" + key, At: at}, nil
+}
+
+// sign adds an AWS Signature Version 4 Authorization header covering
+// every header set so far
+func (a *s3Archive) sign(req *http.Request, payload []byte, t time.Time) {
+    amzDate := t.Format("20060102T150405Z")
+    day := amzDate[:8]
+    payloadHash := sha256.Sum256(payload)
+    req.Header.Set("X-Amz-Date", amzDate)
+    req.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payloadHash[:]))
+
+    headers := map[string]string{"host": req.URL.Host}
+    for k, v := range req.Header {
+        headers[strings.ToLower(k)] = strings.TrimSpace(strings.Join(v, ","))
+    }
+    names := make([]string, 0, len(headers))
+    for k := range headers {
+        names = append(names, k)
+    }
+    sort.Strings(names)
+    var canonHeaders strings.Builder
+    for _, k := range names {
+        canonHeaders.WriteString(k + ":" + headers[k] + "\n")
+    }
+    signed := strings.Join(names, ";")
+
+    canonical := strings.Join([]string{
+        req.Method,
+        req.URL.EscapedPath(),
+        req.URL.RawQuery,
+        canonHeaders.String(),
+        signed,
+        hex.EncodeToString(payloadHash[:]),
+    }, "\n")
+    scope := day + "/" + a.region + "/s3/aws4_request"
+    canonHash := sha256.Sum256([]byte(canonical))
+    toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonHash[:])
+
+    key := hmacSHA256([]byte("AWS4"+a.secretKey), day)
+    key = hmacSHA256(key, a.region)
+    key = hmacSHA256(key, "s3")
+    key = hmacSHA256(key, "aws4_request")
+    sig := hex.EncodeToString(hmacSHA256(key, toSign))
+    req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", a.accessKey, scope, signed, sig))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(data))
+    return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but unreserved characters and "/",
+// as SigV4 expects of object paths
+func s3Escape(p string) string {
+    var b strings.Builder
+    for i := 0; i < len(p); i++ {
+        c := p[i]
+        if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+            'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' {
+            b.WriteByte(c)
+        } else {
+            fmt.Fprintf(&b, "%%%02X", c)
+        }
+    }
+    return b.String()
+}
+
+// s3ErrorMessage pulls <Message> out of an S3 XML error body
+func s3ErrorMessage(body []byte) string {
+    s := string(body)
+    start := strings.Index(s, "<Message>")
+    end := strings.Index(s, "</Message>")
+    if start < 0 || end < start {
+        return ""
+    }
+    return s[start+len("<Message>") : end]
+}
+
+/* ----- Google Cloud Storage ----- */
+
+type gcsArchive struct {
+    bucket        string
+    apiURL        string
+    retentionMode string
+    tokens        *googleTokenSource
+    client        *http.Client
+}
+
+func newGCSArchive() (*gcsArchive, error) {
+    bucket := os.Getenv("GCS_BUCKET")
+    if bucket == "" {
+        return nil, fmt.Errorf("GCS_BUCKET is not set")
+    }
+    mode := os.Getenv("GCS_RETENTION_MODE")
+    if mode != "" && mode != "Unlocked" && mode != "Locked" {
+        return nil, fmt.Errorf("GCS_RETENTION_MODE must be Unlocked or Locked")
+    }
+    tokens, err := newGoogleTokenSource(os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"), "https://www.googleapis.com/auth/devstorage.read_write")
+    if err != nil {
+        return nil, err
+    }
+    return &gcsArchive{
+        bucket:        bucket,
+        apiURL:        strings.TrimSuffix(envOr("GCS_API_URL", "https://storage.googleapis.com"), "/"),
+        retentionMode: mode,
+        tokens:        tokens,
+        client:        &http.Client{Timeout: 60 * time.Second},
+    }, nil
+}
+
+func (g *gcsArchive) Name() string { return "gs://" + g.bucket }
+
+// Put is a multipart upload (object resource, then content) that only
+// succeeds if no live object has the name yet (ifGenerationMatch=0)
+func (g *gcsArchive) Put(ctx context.Context, obj StoredObject) (StoredCopy, error) {
+    key := archiveKey(obj)
+    at := now().UTC()
+    md5sum := md5.Sum(obj.Data)
+    resource := map[string]interface{}{
+        "name":        key,
+        "contentType": obj.ContentType,
+        "md5Hash":     base64.StdEncoding.EncodeToString(md5sum[:]),
+        "metadata":    obj.Meta,
+    }
+    if g.retentionMode != "" {
+        resource["retention"] = map[string]string{
+            "mode":            g.retentionMode,
+            "retainUntilTime": retainUntil(at).Format(time.RFC3339),
+        }
+    }
+    meta, _ := json.Marshal(resource)
+
+    var body bytes.Buffer
+    mw := multipart.NewWriter(&body)
+    part, err := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/json; charset=UTF-8"}})
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    _, _ = part.Write(meta)
+    part, err = mw.CreatePart(textproto.MIMEHeader{"Content-Type": {obj.ContentType}})
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    _, _ = part.Write(obj.Data)
+    if err := mw.Close(); err != nil {
+        return StoredCopy{}, err
+    }
+
+    token, err := g.tokens.Token(ctx)
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    endpoint := fmt.Sprintf("%s/upload/storage/v1/b/%s/o?uploadType=multipart&ifGenerationMatch=0", g.apiURL, url.PathEscape(g.bucket))
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, &body)
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    req.Header.Set("Authorization", "Bearer "+token)
+    req.Header.Set("Content-Type", "multipart/related; boundary="+mw.Boundary())
+    resp, err := g.client.Do(req)
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    defer resp.Body.Close()
+    data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+    if resp.StatusCode >= 300 {
+        if resp.StatusCode == http.StatusPreconditionFailed {
+            return StoredCopy{}, fmt.Errorf("gcs: %s is already archived", key)
+        }
+        var apiErr struct {
+            Error struct {
+                Message string `json:"message"`
+            } `json:"error"`
+        }
+        _ = json.Unmarshal(data, &apiErr)
+        return StoredCopy{}, fmt.Errorf("gcs: %s %s", resp.Status, apiErr.Error.Message)
+    }
+    return StoredCopy{Store: "gcs", Location: "gs://" + g.bucket + "/" + key, At: at}, nil
+}
@@ -0,0 +1,273 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+)
+
+/* =================
+   Overtime analysis
+   ================= */
+
+// Which jobs are burning overtime, and who is working it:
+//
+//   GET /api/reports/overtime?from=YYYY-MM-DD&to=YYYY-MM-DD
+//       [&daily_hours=12&daily_overtime=4&weekly_overtime=10]
+//
+// adds up the overtime on the tenant's stored cards (current revisions)
+// dated in the range, by employee, by day of the week and by job, and
+// flags the employee-days over daily_hours in all or daily_overtime of
+// overtime and the employee-weeks (payroll weeks, weekstart.go) over
+// weekly_overtime. The thresholds come from the query, else the tenant's
+//
+//   "overtime_thresholds": {"daily_hours": 12, "daily_overtime": 4, "weekly_overtime": 10}
+//
+// else those defaults; 0 turns a check off. Only worked hours count: stat
+// pay and leave are left out of the totals and the thresholds.
+
+// OvertimeThresholds are the limits the overtime report flags
+type OvertimeThresholds struct {
+    DailyHours     float64 `json:"daily_hours"`
+    DailyOvertime  float64 `json:"daily_overtime"`
+    WeeklyOvertime float64 `json:"weekly_overtime"`
+}
+
+var defaultOvertimeThresholds = OvertimeThresholds{DailyHours: 12, DailyOvertime: 4, WeeklyOvertime: 10}
+
+// otShare is a report row's overtime against all its hours
+type otShare struct {
+    Overtime float64 `json:"overtime"`
+    Total    float64 `json:"total"`
+    Percent  float64 `json:"percent"` // overtime as a share of the total
+}
+
+func (s *otShare) add(l hourLine) {
+    if l.Overtime {
+        s.Overtime += l.Hours
+    }
+    s.Total += l.Hours
+}
+
+func (s otShare) rounded() otShare {
+    out := otShare{Overtime: roundHours(s.Overtime), Total: roundHours(s.Total)}
+    if s.Total > 0 {
+        out.Percent = roundHours(100 * s.Overtime / s.Total)
+    }
+    return out
+}
+
+type otEmployeeRow struct {
+    Employee string `json:"employee"`
+    otShare
+}
+
+type otWeekdayRow struct {
+    Weekday string `json:"weekday"`
+    otShare
+}
+
+type otJobRow struct {
+    JobNumber  string `json:"job_number"`
+    LabourCode string `json:"labour_code,omitempty"`
+    otShare
+}
+
+// otFlag is an employee-day or -week over a threshold
+type otFlag struct {
+    Employee string   `json:"employee"`
+    Date     string   `json:"date"`   // the day, or the first day of the week
+    Period   string   `json:"period"` // day or week
+    Jobs     []string `json:"jobs"`
+    Hours    float64  `json:"hours"`
+    Overtime float64  `json:"overtime"`
+    Reason   string   `json:"reason"`
+}
+
+type overtimeReport struct {
+    From       string             `json:"from"`
+    To         string             `json:"to"`
+    Thresholds OvertimeThresholds `json:"thresholds"`
+    Totals     otShare            `json:"totals"`
+    Employees  []otEmployeeRow    `json:"employees"`
+    Weekdays   []otWeekdayRow     `json:"weekdays"`
+    Jobs       []otJobRow         `json:"jobs"`
+    Flags      []otFlag           `json:"flags"`
+}
+
+// overtimeThresholds are the tenant's thresholds with the query's over them
+func overtimeThresholds(r *http.Request, t *Tenant) (OvertimeThresholds, error) {
+    th := defaultOvertimeThresholds
+    if t.OvertimeThresholds != nil {
+        th = *t.OvertimeThresholds
+    }
+    for _, p := range []struct {
+        name string
+        dst  *float64
+    }{{"daily_hours", &th.DailyHours}, {"daily_overtime", &th.DailyOvertime}, {"weekly_overtime", &th.WeeklyOvertime}} {
+        if v := r.URL.Query().Get(p.name); v != "" {
+            n, err := strconv.ParseFloat(v, 64)
+            if err != nil || n < 0 {
+                return th, fmt.Errorf("%s must be a number of hours", p.name)
+            }
+            *p.dst = n
+        }
+    }
+    return th, nil
+}
+
+// overtimeReportHandler serves GET /api/reports/overtime (see the top of this file)
+func overtimeReportHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    t := tenantFor(r)
+    from, to, err := queryDateRange(r)
+    if err != nil {
+        httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    th, err := overtimeThresholds(r, t)
+    if err != nil {
+        httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+        return
+    }
+    reqs, err := storedCards(t, func(TimecardRecord) bool { return true })
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("error reading timecards: %v", err), http.StatusInternalServerError)
+        return
+    }
+    writeJSON(w, http.StatusOK, analyzeOvertime(t, reqs, from, to, th))
+}
+
+// queryDateRange reads the required from and to days of a report
+func queryDateRange(r *http.Request) (from, to time.Time, err error) {
+    q := r.URL.Query()
+    if from, err = time.Parse("2006-01-02", q.Get("from")); err != nil {
+        return from, to, fmt.Errorf("from must be YYYY-MM-DD")
+    }
+    if to, err = time.Parse("2006-01-02", q.Get("to")); err != nil {
+        return from, to, fmt.Errorf("to must be YYYY-MM-DD")
+    }
+    if to.Before(from) {
+        return from, to, fmt.Errorf("to is before from")
+    }
+    return from, to, nil
+}
+
+// analyzeOvertime builds the report over the lines of reqs dated from to to
+func analyzeOvertime(t *Tenant, reqs []TimecardRequest, from, to time.Time, th OvertimeThresholds) overtimeReport {
+    rep := overtimeReport{From: from.Format("2006-01-02"), To: to.Format("2006-01-02"), Thresholds: th,
+        Employees: []otEmployeeRow{}, Jobs: []otJobRow{}, Flags: []otFlag{}}
+    employees := map[string]*otEmployeeRow{}
+    jobs := map[string]*otJobRow{}
+    var weekdays [7]otShare
+
+    type span struct {
+        employee string
+        day      time.Time
+    }
+    days, weeks := map[span]*otFlag{}, map[span]*otFlag{}
+    note := func(m map[span]*otFlag, k span, period string, l hourLine) {
+        f := m[k]
+        if f == nil {
+            f = &otFlag{Employee: k.employee, Date: k.day.Format("2006-01-02"), Period: period}
+            m[k] = f
+        }
+        f.Hours += l.Hours
+        if l.Overtime {
+            f.Overtime += l.Hours
+        }
+        for _, j := range f.Jobs {
+            if j == l.JobNumber {
+                return
+            }
+        }
+        f.Jobs = append(f.Jobs, l.JobNumber)
+    }
+
+    for _, req := range reqs {
+        for _, l := range hourLines(req, cardLocation(req, t)) {
+            if !l.worked() || l.Date.Before(from) || l.Date.After(to) {
+                continue
+            }
+            rep.Totals.add(l)
+            e := employees[req.EmployeeName]
+            if e == nil {
+                e = &otEmployeeRow{Employee: req.EmployeeName}
+                employees[req.EmployeeName] = e
+            }
+            e.add(l)
+            j := jobs[l.JobNumber]
+            if j == nil {
+                j = &otJobRow{JobNumber: l.JobNumber, LabourCode: l.LabourCode}
+                jobs[l.JobNumber] = j
+            }
+            j.add(l)
+            weekdays[l.Date.Weekday()].add(l)
+            note(days, span{req.EmployeeName, l.Date}, "day", l)
+            note(weeks, span{req.EmployeeName, startOfWeek(l.Date, t.weekStartDay())}, "week", l)
+        }
+    }
+
+    rep.Totals = rep.Totals.rounded()
+    for _, e := range employees {
+        e.otShare = e.rounded()
+        rep.Employees = append(rep.Employees, *e)
+    }
+    sort.Slice(rep.Employees, func(a, b int) bool {
+        if rep.Employees[a].Overtime != rep.Employees[b].Overtime {
+            return rep.Employees[a].Overtime > rep.Employees[b].Overtime
+        }
+        return rep.Employees[a].Employee < rep.Employees[b].Employee
+    })
+    for _, j := range jobs {
+        j.otShare = j.rounded()
+        rep.Jobs = append(rep.Jobs, *j)
+    }
+    sort.Slice(rep.Jobs, func(a, b int) bool {
+        if rep.Jobs[a].Overtime != rep.Jobs[b].Overtime {
+            return rep.Jobs[a].Overtime > rep.Jobs[b].Overtime
+        }
+        return rep.Jobs[a].JobNumber < rep.Jobs[b].JobNumber
+    })
+    for d := time.Sunday; d <= time.Saturday; d++ {
+        rep.Weekdays = append(rep.Weekdays, otWeekdayRow{Weekday: d.String(), otShare: weekdays[d].rounded()})
+    }
+
+    for _, f := range days {
+        switch {
+        case th.DailyHours > 0 && f.Hours > th.DailyHours:
+            f.Reason = fmt.Sprintf("%g hours in a day (limit %g)", roundHours(f.Hours), th.DailyHours)
+        case th.DailyOvertime > 0 && f.Overtime > th.DailyOvertime:
+            f.Reason = fmt.Sprintf("%g overtime hours in a day (limit %g)", roundHours(f.Overtime), th.DailyOvertime)
+        default:
+            continue
+        }
+        rep.Flags = append(rep.Flags, *f)
+    }
+    for _, f := range weeks {
+        if th.WeeklyOvertime > 0 && f.Overtime > th.WeeklyOvertime {
+            f.Reason = fmt.Sprintf("%g overtime hours in a week (limit %g)", roundHours(f.Overtime), th.WeeklyOvertime)
+            rep.Flags = append(rep.Flags, *f)
+        }
+    }
+    for i := range rep.Flags {
+        rep.Flags[i].Hours, rep.Flags[i].Overtime = roundHours(rep.Flags[i].Hours), roundHours(rep.Flags[i].Overtime)
+        sort.Strings(rep.Flags[i].Jobs)
+    }
+    sort.Slice(rep.Flags, func(a, b int) bool {
+        fa, fb := rep.Flags[a], rep.Flags[b]
+        if fa.Date != fb.Date {
+            return fa.Date < fb.Date
+        }
+        if fa.Employee != fb.Employee {
+            return fa.Employee < fb.Employee
+        }
+        return fa.Period < fb.Period
+    })
+    return rep
+}
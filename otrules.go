@@ -0,0 +1,246 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+)
+
+/* ==============
+   Overtime rules
+   ============== */
+
+// Instead of the app marking entries overtime, a tenant can have the
+// collective agreement's tiers applied server-side:
+//
+//   "overtime_rules": {"daily_overtime_after": 8, "daily_double_after": 12,
+//                      "weekly_overtime_after": 40, "double_time_days": ["Sunday"]}
+//
+// Each day's worked hours are split in the order they were entered:
+// past daily_overtime_after they are overtime (1.5x), past
+// daily_double_after double time (2x), and every hour on a
+// double_time_days day is double time. Regular hours past
+// weekly_overtime_after in a payroll week (weekstart.go) are overtime too.
+// 0 or absent turns a tier off. The app's own overtime and double_time
+// flags are ignored when rules are set; holiday and leave entries are
+// left alone. Double time is written in the overtime table in columns of
+// its own, the labour code prefixed "DT", and counts as overtime in the
+// reports as well as on its own.
+//
+// A job in the registry can carry overtime_rules of its own (a
+// prevailing-wage job with a shorter day, say). Its hours are tiered by
+// those instead of the tenant's, still counting the day's and week's
+// hours on other jobs. Without tenant rules, hours on other jobs keep the
+// app's flags and count towards the day and week as they were sent.
+
+// OvertimeRules are a tenant's overtime tiers
+type OvertimeRules struct {
+    DailyOvertimeAfter  float64  `json:"daily_overtime_after,omitempty"`
+    DailyDoubleAfter    float64  `json:"daily_double_after,omitempty"`
+    WeeklyOvertimeAfter float64  `json:"weekly_overtime_after,omitempty"`
+    DoubleTimeDays      []string `json:"double_time_days,omitempty"` // weekday names
+}
+
+func (o *OvertimeRules) validate() error {
+    for _, h := range []float64{o.DailyOvertimeAfter, o.DailyDoubleAfter, o.WeeklyOvertimeAfter} {
+        if h < 0 {
+            return fmt.Errorf("overtime rules: hours must not be negative")
+        }
+    }
+    if o.DailyOvertimeAfter > 0 && o.DailyDoubleAfter > 0 && o.DailyDoubleAfter < o.DailyOvertimeAfter {
+        return fmt.Errorf("overtime rules: daily_double_after is before daily_overtime_after")
+    }
+    for _, d := range o.DoubleTimeDays {
+        if _, ok := parseWeekday(d); !ok {
+            return fmt.Errorf("overtime rules: %q is not a day of the week", d)
+        }
+    }
+    return nil
+}
+
+// parseWeekday reads a weekday name ("Sunday", "sun")
+func parseWeekday(s string) (time.Weekday, bool) {
+    for d := time.Sunday; d <= time.Saturday; d++ {
+        name := d.String()
+        if len(s) >= 3 && len(s) <= len(name) && strings.EqualFold(name[:len(s)], s) {
+            return d, true
+        }
+    }
+    return 0, false
+}
+
+func (o *OvertimeRules) doubleTimeDay(d time.Weekday) bool {
+    for _, name := range o.DoubleTimeDays {
+        if wd, ok := parseWeekday(name); ok && wd == d {
+            return true
+        }
+    }
+    return false
+}
+
+// applyOvertimeRules splits the worked entries of req into regular,
+// overtime and double time (see the top of this file)
+func applyOvertimeRules(req *TimecardRequest, t *Tenant) {
+    if t == nil {
+        return
+    }
+    byJob := map[string]*OvertimeRules{}
+    for _, j := range tenantJobs(t.ID) {
+        if j.OvertimeRules != nil {
+            byJob[j.Number] = j.OvertimeRules
+        }
+    }
+    if t.OvertimeRules == nil && len(byJob) == 0 {
+        return
+    }
+    rulesFor := func(job string) *OvertimeRules {
+        if o := byJob[job]; o != nil {
+            return o
+        }
+        return t.OvertimeRules
+    }
+    loc := cardLocation(*req, t)
+    weekly := map[time.Time]float64{} // regular hours so far, by week's first day
+    first := t.weekStartDay()
+    if len(req.Weeks) == 0 {
+        req.Entries = splitOvertime(req.Entries, rulesFor, loc, first, weekly)
+        return
+    }
+    for i := range req.Weeks {
+        req.Weeks[i].Entries = splitOvertime(req.Weeks[i].Entries, rulesFor, loc, first, weekly)
+    }
+}
+
+// splitOvertime re-tiers one list of entries by the rules of their jobs;
+// weekly carries the regular hours of each week across lists
+func splitOvertime(entries []Entry, rulesFor func(job string) *OvertimeRules, loc *time.Location, weekStart time.Weekday, weekly map[time.Time]float64) []Entry {
+    // Merge what earlier passes (or the app) split, keeping the order and
+    // the first start time. Entries on jobs without rules are kept as sent.
+    type lineKey struct {
+        date, job, kind string // job with its phase and cost code
+        night           bool
+    }
+    type line struct {
+        key   lineKey
+        kept  bool
+        entry Entry // when kept
+    }
+    var order []line
+    merged := map[lineKey]Entry{}
+    var out []Entry
+    for _, e := range entries {
+        if !e.worked() {
+            out = append(out, e)
+            continue
+        }
+        if rulesFor(e.JobCode) == nil {
+            order = append(order, line{kept: true, entry: e})
+            continue
+        }
+        k := lineKey{e.Date, costLevels(e), e.Type, e.IsNightShift}
+        m, ok := merged[k]
+        if !ok {
+            order = append(order, line{key: k})
+            m = e
+            m.Hours = 0
+            m.Overtime, m.DoubleTime = false, false
+        }
+        m.Hours += e.Hours
+        m.Notes = joinNotes(m.Notes, e.Notes)
+        merged[k] = m
+    }
+
+    // Days in date order, entries in the order given within a day
+    type dayEntry struct {
+        day   time.Time
+        entry Entry
+        kept  bool
+    }
+    var work []dayEntry
+    for _, l := range order {
+        e := merged[l.key]
+        if l.kept {
+            e = l.entry
+        }
+        day, err := parseCalendarDate(e.Date, loc)
+        if err != nil {
+            out = append(out, e) // validation reports it
+            continue
+        }
+        work = append(work, dayEntry{day, e, l.kept})
+    }
+    sort.SliceStable(work, func(a, b int) bool { return work[a].day.Before(work[b].day) })
+
+    daily := map[time.Time]float64{}
+    for _, w := range work {
+        week := startOfWeek(w.day, weekStart)
+        h := w.entry.Hours
+        done := daily[w.day]
+        daily[w.day] += h
+        if w.kept {
+            if !w.entry.Overtime {
+                weekly[week] += h
+            }
+            out = append(out, w.entry)
+            continue
+        }
+
+        o := rulesFor(w.entry.JobCode)
+        var regular, double float64
+        switch {
+        case o.doubleTimeDay(w.day.Weekday()):
+            double = h
+        default:
+            regular = h
+            if o.DailyOvertimeAfter > 0 {
+                regular = clampHours(o.DailyOvertimeAfter-done, regular)
+            }
+            if o.DailyDoubleAfter > 0 {
+                below := clampHours(o.DailyDoubleAfter-done, h)
+                regular = clampHours(below, regular)
+                double = h - below
+            }
+            if o.WeeklyOvertimeAfter > 0 {
+                regular = clampHours(o.WeeklyOvertimeAfter-weekly[week], regular)
+            }
+            weekly[week] += regular
+        }
+
+        // Later pieces start where the earlier ones end
+        offset := 0.0
+        for _, p := range []struct {
+            hours            float64
+            overtime, double bool
+        }{{regular, false, false}, {h - regular - double, true, false}, {double, true, true}} {
+            if p.hours <= 0 {
+                continue
+            }
+            e := w.entry
+            e.Hours, e.Overtime, e.DoubleTime = roundHours(p.hours), p.overtime, p.double
+            if start, err := clockHours(e.Start); err == nil && offset > 0 {
+                e.Start = formatClock(start + offset)
+            }
+            offset += p.hours
+            out = append(out, e)
+        }
+    }
+    return out
+}
+
+// clampHours is room limited to 0..h
+func clampHours(room, h float64) float64 {
+    if room < 0 {
+        return 0
+    }
+    if room > h {
+        return h
+    }
+    return room
+}
+
+// formatClock writes hours after midnight as HH:MM, wrapping past 24
+func formatClock(h float64) string {
+    m := int(h*60+0.5) % (24 * 60)
+    return fmt.Sprintf("%02d:%02d", m/60, m%60)
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+    "reflect"
+    "testing"
+)
+
+func TestApplyOvertimeRules(t *testing.T) {
+    daily := &OvertimeRules{DailyOvertimeAfter: 8}
+    double := &OvertimeRules{DailyOvertimeAfter: 8, DailyDoubleAfter: 12}
+    weekly := &OvertimeRules{WeeklyOvertimeAfter: 40}
+    both := &OvertimeRules{DailyOvertimeAfter: 8, WeeklyOvertimeAfter: 40}
+    sundays := &OvertimeRules{DailyOvertimeAfter: 8, DoubleTimeDays: []string{"Sunday"}}
+
+    startingAt := func(e Entry, start string) Entry { e.Start = start; return e }
+    flagged := func(e Entry) Entry { e.Overtime = true; return e }
+    leave := func(e Entry) Entry { e.Type = entryVacation; return e }
+    fiveDays := func(hours float64) []Entry {
+        var out []Entry
+        for _, d := range []string{"Mon", "Tue", "Wed", "Thu", "Fri"} {
+            out = append(out, worked(d, "A", hours))
+        }
+        return out
+    }
+
+    tests := []struct {
+        name    string
+        rules   *OvertimeRules
+        entries []Entry
+        want    []string
+    }{
+        {"under daily", daily, []Entry{worked("Mon", "A", 8)}, []string{"Mon A 8"}},
+        {"past daily", daily, []Entry{worked("Mon", "A", 10)}, []string{"Mon A 8", "Mon A 2 ot"}},
+        {"past double", double, []Entry{worked("Mon", "A", 13)}, []string{"Mon A 8", "Mon A 4 ot", "Mon A 1 dt"}},
+        {"at double", double, []Entry{worked("Mon", "A", 12)}, []string{"Mon A 8", "Mon A 4 ot"}},
+        {"day split over jobs", daily,
+            []Entry{worked("Mon", "A", 6), startingAt(worked("Mon", "B", 4), "14:00")},
+            []string{"Mon A 6", "Mon B 2@14:00", "Mon B 2 ot@16:00"}},
+        {"same job merged", daily,
+            []Entry{worked("Mon", "A", 5), worked("Mon", "A", 5)},
+            []string{"Mon A 8", "Mon A 2 ot"}},
+        {"days in date order", daily,
+            []Entry{worked("Tue", "A", 9), worked("Mon", "A", 9)},
+            []string{"Mon A 8", "Mon A 1 ot", "Tue A 8", "Tue A 1 ot"}},
+        {"past weekly", weekly, fiveDays(9),
+            []string{"Mon A 9", "Tue A 9", "Wed A 9", "Thu A 9", "Fri A 4", "Fri A 5 ot"}},
+        {"daily overtime doesn't count to the week", both, append(fiveDays(9), worked("Sat", "A", 8)),
+            []string{"Mon A 8", "Mon A 1 ot", "Tue A 8", "Tue A 1 ot", "Wed A 8", "Wed A 1 ot",
+                "Thu A 8", "Thu A 1 ot", "Fri A 8", "Fri A 1 ot", "Sat A 8 ot"}},
+        {"weekly restarts each week", weekly, append(fiveDays(9), worked("+Mon", "A", 9)),
+            []string{"Mon A 9", "Tue A 9", "Wed A 9", "Thu A 9", "Fri A 4", "Fri A 5 ot", "Mon A 9"}},
+        {"double time day", sundays, []Entry{worked("Sun", "A", 5), worked("Mon", "A", 5)},
+            []string{"Sun A 5 dt", "Mon A 5"}},
+        {"app flags ignored", daily, []Entry{flagged(worked("Mon", "A", 4))}, []string{"Mon A 4"}},
+        {"leave left alone", daily, []Entry{leave(worked("Mon", "VAC", 8)), worked("Mon", "A", 4)},
+            []string{"Mon VAC 8 vacation", "Mon A 4"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := TimecardRequest{Entries: append([]Entry(nil), tt.entries...)}
+            applyOvertimeRules(&req, &Tenant{ID: "ot-test", OvertimeRules: tt.rules})
+            if got := describeEntries(req.Entries); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("got  %q\nwant %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestApplyOvertimeRulesAcrossWeeks(t *testing.T) {
+    req := TimecardRequest{Weeks: []WeekData{
+        {Entries: []Entry{worked("Mon", "A", 20), worked("Tue", "A", 20)}},
+        {Entries: []Entry{worked("Sat", "A", 4)}}, // sent as a second sheet, same payroll week
+        {Entries: []Entry{worked("+Mon", "A", 4)}},
+    }}
+    applyOvertimeRules(&req, &Tenant{ID: "ot-test", OvertimeRules: &OvertimeRules{WeeklyOvertimeAfter: 40}})
+    var got []string
+    for _, wk := range req.Weeks {
+        got = append(got, describeEntries(wk.Entries)...)
+    }
+    want := []string{"Mon A 20", "Tue A 20", "Sat A 4 ot", "Mon A 4"}
+    if !reflect.DeepEqual(got, want) {
+        t.Errorf("got  %q\nwant %q", got, want)
+    }
+}
+
+func TestOvertimeRulesValidate(t *testing.T) {
+    tests := []struct {
+        name  string
+        rules OvertimeRules
+        ok    bool
+    }{
+        {"tiers", OvertimeRules{DailyOvertimeAfter: 8, DailyDoubleAfter: 12, WeeklyOvertimeAfter: 40}, true},
+        {"short day name", OvertimeRules{DoubleTimeDays: []string{"sun"}}, true},
+        {"negative", OvertimeRules{DailyOvertimeAfter: -1}, false},
+        {"double before overtime", OvertimeRules{DailyOvertimeAfter: 10, DailyDoubleAfter: 8}, false},
+        {"bad day", OvertimeRules{DoubleTimeDays: []string{"Funday"}}, false},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            if err := tt.rules.validate(); (err == nil) != tt.ok {
+                t.Errorf("validate() = %v, want ok=%v", err, tt.ok)
+            }
+        })
+    }
+}
+
+// withJobRegistry gives the test an empty job registry of its own
+func withJobRegistry(t *testing.T) {
+    t.Helper()
+    t.Setenv("DATA_DIR", t.TempDir())
+    saved := jobRegistry
+    jobRegistry = openCollection[RegisteredJob]("job_registry")
+    t.Cleanup(func() { jobRegistry = saved })
+}
+
+func TestApplyOvertimeRulesPerJob(t *testing.T) {
+    withJobRegistry(t)
+    const tenant = "ot-job-test"
+    prevailing := RegisteredJob{Number: "P", Tenant: tenant, OvertimeRules: &OvertimeRules{DailyOvertimeAfter: 7}}
+    if err := jobRegistry.Put(registryKey(tenant, "P"), prevailing); err != nil {
+        t.Fatal(err)
+    }
+    flagged := func(e Entry) Entry { e.Overtime = true; return e }
+
+    tests := []struct {
+        name    string
+        rules   *OvertimeRules
+        entries []Entry
+        want    []string
+    }{
+        {"job's own threshold", &OvertimeRules{DailyOvertimeAfter: 8},
+            []Entry{worked("Mon", "P", 9), worked("Tue", "A", 9)},
+            []string{"Mon P 7", "Mon P 2 ot", "Tue A 8", "Tue A 1 ot"}},
+        {"day counted across jobs", &OvertimeRules{DailyOvertimeAfter: 8},
+            []Entry{worked("Mon", "A", 5), worked("Mon", "P", 4)},
+            []string{"Mon A 5", "Mon P 2", "Mon P 2 ot"}},
+        {"no tenant rules", nil,
+            []Entry{worked("Mon", "A", 5), flagged(worked("Mon", "A", 1)), worked("Mon", "P", 2)},
+            []string{"Mon A 5", "Mon A 1 ot", "Mon P 1", "Mon P 1 ot"}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := TimecardRequest{Entries: append([]Entry(nil), tt.entries...)}
+            applyOvertimeRules(&req, &Tenant{ID: tenant, OvertimeRules: tt.rules})
+            if got := describeEntries(req.Entries); !reflect.DeepEqual(got, tt.want) {
+                t.Errorf("got  %q\nwant %q", got, tt.want)
+            }
+        })
+    }
+}
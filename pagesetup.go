@@ -0,0 +1,158 @@
+package main
+
+import (
+    "fmt"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==========
+   Page setup
+   ========== */
+
+// The PDF's pages are the workbook's print pages, so a card or tenant can
+// change how every sheet prints instead of living with the template's
+// setup (which clips the AJ column on Letter paper):
+//
+//   "page_setup": {"paper_size": "letter", "orientation": "landscape",
+//                  "fit_to_width": 1, "fit_to_height": 1}
+//
+// fit_to_width and fit_to_height are how many pages across and down a
+// sheet is shrunk to; with only fit_to_width set, a sheet takes as many
+// pages down as it needs. scale (a percent, 10-400) is used when not
+// fitting. A card's settings win over the tenant's, field by field, and
+// anything neither sets stays as weekPrintLayout leaves it.
+//
+// Every week sheet is first set to print landscape, one page wide, with
+// narrow margins (weekPrintLayout), so Excel and LibreOffice both print
+// the whole row without clipping the AJ column.
+
+// PageSetup is how the card's sheets print
+type PageSetup struct {
+    PaperSize   string `json:"paper_size,omitempty"`  // letter, legal or a4
+    Orientation string `json:"orientation,omitempty"` // portrait or landscape
+    FitToWidth  int    `json:"fit_to_width,omitempty"`
+    FitToHeight int    `json:"fit_to_height,omitempty"`
+    Scale       int    `json:"scale,omitempty"`
+}
+
+// paperSizes are excelize's paper size numbers
+var paperSizes = map[string]int{"letter": 1, "legal": 5, "a4": 9}
+
+func (p *PageSetup) validate() error {
+    if _, ok := paperSizes[p.PaperSize]; p.PaperSize != "" && !ok {
+        return fmt.Errorf("paper_size %q must be letter, legal or a4", p.PaperSize)
+    }
+    if p.Orientation != "" && p.Orientation != "portrait" && p.Orientation != "landscape" {
+        return fmt.Errorf("orientation %q must be portrait or landscape", p.Orientation)
+    }
+    if p.FitToWidth < 0 || p.FitToHeight < 0 {
+        return fmt.Errorf("fit_to_width and fit_to_height must not be negative")
+    }
+    if p.Scale != 0 && (p.Scale < 10 || p.Scale > 400) {
+        return fmt.Errorf("scale %d must be between 10 and 400", p.Scale)
+    }
+    return nil
+}
+
+// pageSetup merges the card's page setup over the tenant's; nil when
+// neither has one
+func (req TimecardRequest) pageSetup(t *Tenant) *PageSetup {
+    var base PageSetup
+    if t != nil && t.PageSetup != nil {
+        base = *t.PageSetup
+    }
+    if p := req.PageSetup; p != nil {
+        if p.PaperSize != "" {
+            base.PaperSize = p.PaperSize
+        }
+        if p.Orientation != "" {
+            base.Orientation = p.Orientation
+        }
+        if p.FitToWidth != 0 || p.FitToHeight != 0 {
+            base.FitToWidth, base.FitToHeight = p.FitToWidth, p.FitToHeight
+        }
+        if p.Scale != 0 {
+            base.Scale = p.Scale
+        }
+    }
+    if base == (PageSetup{}) {
+        return nil
+    }
+    return &base
+}
+
+// checkPageSetup rejects page setups soffice can't print
+func checkPageSetup(v *validationResult, req TimecardRequest) {
+    if req.PageSetup == nil {
+        return
+    }
+    if err := req.PageSetup.validate(); err != nil {
+        v.errorf("page_setup: %v", err)
+    }
+}
+
+// Week sheet margins, in inches
+const (
+    weekMarginSide   = 0.25
+    weekMarginTop    = 0.5
+    weekMarginHeader = 0.3
+)
+
+// weekPrintLayout sets a week sheet to print landscape and one page wide,
+// as many pages down as it needs, with narrow margins centred across
+func weekPrintLayout(f *excelize.File, sheet string) error {
+    orientation, wide, tall, fit := "landscape", 1, 0, true
+    if err := f.SetPageLayout(sheet, &excelize.PageLayoutOptions{
+        Orientation: &orientation,
+        FitToWidth:  &wide,
+        FitToHeight: &tall,
+    }); err != nil {
+        return err
+    }
+    if err := f.SetSheetProps(sheet, &excelize.SheetPropsOptions{FitToPage: &fit}); err != nil {
+        return err
+    }
+    side, top, header, centred := weekMarginSide, weekMarginTop, weekMarginHeader, true
+    return f.SetPageMargins(sheet, &excelize.PageLayoutMarginsOptions{
+        Left:         &side,
+        Right:        &side,
+        Top:          &top,
+        Bottom:       &top,
+        Header:       &header,
+        Footer:       &header,
+        Horizontally: &centred,
+    })
+}
+
+// applyPageSetup sets up every sheet of f to print as the card asks
+func applyPageSetup(gc *genContext, f *excelize.File) {
+    p := gc.req.pageSetup(gc.tenant)
+    if p == nil {
+        return
+    }
+    var opts excelize.PageLayoutOptions
+    if size, ok := paperSizes[p.PaperSize]; ok {
+        opts.Size = &size
+    }
+    if p.Orientation != "" {
+        opts.Orientation = &p.Orientation
+    }
+    fit := p.FitToWidth > 0 || p.FitToHeight > 0
+    if fit {
+        // 0 pages down is "as many as needed"
+        opts.FitToWidth, opts.FitToHeight = &p.FitToWidth, &p.FitToHeight
+    } else if p.Scale > 0 {
+        scale := uint(p.Scale)
+        opts.AdjustTo = &scale
+    }
+    for _, sheet := range f.GetSheetList() {
+        if err := f.SetPageLayout(sheet, &opts); err != nil {
+            gc.lg.Printf("Warning: page setup of %s: %v", sheet, err)
+            continue
+        }
+        if fit || p.Scale > 0 {
+            _ = f.SetSheetProps(sheet, &excelize.SheetPropsOptions{FitToPage: &fit})
+        }
+    }
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+    "context"
+    "testing"
+
+    "github.com/xuri/excelize/v2"
+)
+
+func TestPageSetup(t *testing.T) {
+    tenant := &Tenant{ID: "page-test", PageSetup: &PageSetup{PaperSize: "letter", Orientation: "portrait", Scale: 90}}
+    req := TimecardRequest{EmployeeName: "Bob Smith", PageSetup: &PageSetup{Orientation: "landscape", FitToWidth: 1}}
+    p := req.pageSetup(tenant)
+    if *p != (PageSetup{PaperSize: "letter", Orientation: "landscape", FitToWidth: 1, Scale: 90}) {
+        t.Errorf("merged setup %+v", *p)
+    }
+    if (TimecardRequest{}).pageSetup(nil) != nil {
+        t.Error("setup without settings")
+    }
+
+    f := excelize.NewFile()
+    _, _ = f.NewSheet("Travel")
+    applyPageSetup(newGenContext(context.Background(), req, tenant, loggerFrom(context.Background())), f)
+    for _, sheet := range f.GetSheetList() {
+        layout, err := f.GetPageLayout(sheet)
+        if err != nil {
+            t.Fatal(err)
+        }
+        props, _ := f.GetSheetProps(sheet)
+        if *layout.Size != 1 || *layout.Orientation != "landscape" || *layout.FitToWidth != 1 || *layout.FitToHeight != 0 || !*props.FitToPage {
+            t.Errorf("%s: size %d, %s, fit %dx%d, fit to page %v", sheet,
+                *layout.Size, *layout.Orientation, *layout.FitToWidth, *layout.FitToHeight, *props.FitToPage)
+        }
+    }
+
+    for _, bad := range []PageSetup{{PaperSize: "tabloid"}, {Orientation: "sideways"}, {Scale: 500}, {FitToHeight: -1}} {
+        var v validationResult
+        checkPageSetup(&v, TimecardRequest{PageSetup: &bad})
+        if len(v.Errors) != 1 {
+            t.Errorf("%+v: errors %q", bad, v.Errors)
+        }
+    }
+}
+
+func TestWeekPrintLayout(t *testing.T) {
+    f := excelize.NewFile()
+    if err := weekPrintLayout(f, "Sheet1"); err != nil {
+        t.Fatal(err)
+    }
+    layout, _ := f.GetPageLayout("Sheet1")
+    props, _ := f.GetSheetProps("Sheet1")
+    if *layout.Orientation != "landscape" || *layout.FitToWidth != 1 || *layout.FitToHeight != 0 || !*props.FitToPage {
+        t.Errorf("%s, fit %dx%d, fit to page %v", *layout.Orientation, *layout.FitToWidth, *layout.FitToHeight, *props.FitToPage)
+    }
+    margins, _ := f.GetPageMargins("Sheet1")
+    if *margins.Left != weekMarginSide || *margins.Top != weekMarginTop || !*margins.Horizontally {
+        t.Errorf("margins left %v top %v centred %v", *margins.Left, *margins.Top, *margins.Horizontally)
+    }
+
+    // a card's page setup still wins
+    req := TimecardRequest{PageSetup: &PageSetup{Orientation: "portrait", Scale: 80}}
+    applyPageSetup(newGenContext(context.Background(), req, nil, loggerFrom(context.Background())), f)
+    layout, _ = f.GetPageLayout("Sheet1")
+    props, _ = f.GetSheetProps("Sheet1")
+    if *layout.Orientation != "portrait" || *layout.AdjustTo != 80 || *props.FitToPage {
+        t.Errorf("after page setup: %s, scale %d, fit to page %v", *layout.Orientation, *layout.AdjustTo, *props.FitToPage)
+    }
+}
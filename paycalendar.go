@@ -0,0 +1,692 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+/* ============
+   Pay calendar
+   ============ */
+
+// PayCalendar describes how a tenant numbers its pay periods. Weekly and
+// biweekly periods run back to back from Anchor (any period's first day);
+// semi-monthly periods are the 1st to the 15th and the 16th to the end of
+// each month, and monthly ones the calendar months, with no anchor.
+// Numbering restarts at 1 with the first period that begins on or after
+// the fiscal year start.
+//
+//   {"anchor": "2025-01-05", "scheme": "biweekly",
+//    "fiscal_start_month": 4, "year_numbering": "end", "year_label": "span"}
+//
+// numbers periods from April, calls April 2025–March 2026 fiscal 2026, and
+// writes "2025-26" in the year cell. A card gets a week sheet for every
+// seven days of its period, the last one cut short at the period's end
+// (so 3 for a semi-monthly period, 5 for most months).
+type PayCalendar struct {
+    Anchor           string `json:"anchor,omitempty"`
+    Scheme           string `json:"scheme,omitempty"`             // weekly, biweekly (default), semi_monthly or monthly
+    PeriodDays       int    `json:"period_days,omitempty"`        // weekly and biweekly only; default 7 or 14
+    FiscalStartMonth int    `json:"fiscal_start_month,omitempty"` // 1-12, default 1 (calendar year)
+    FiscalStartDay   int    `json:"fiscal_start_day,omitempty"`   // default 1
+    // YearNumbering names a fiscal year by the calendar year it "start"s
+    // in (default) or "end"s in.
+    YearNumbering string `json:"year_numbering,omitempty"`
+    // YearLabel is how the year cell reads: "number" (default, e.g. 2026),
+    // "fy" ("FY2026") or "span" ("2025-26").
+    YearLabel string `json:"year_label,omitempty"`
+}
+
+// payPeriod is one numbered period; reports group by (FiscalYear, Number)
+type payPeriod struct {
+    FiscalYear int
+    Number     int
+    Start      time.Time // first day, UTC midnight
+    End        time.Time // last day, UTC midnight
+}
+
+const (
+    schemeWeekly      = "weekly"
+    schemeBiweekly    = "biweekly"
+    schemeSemiMonthly = "semi_monthly"
+    schemeMonthly     = "monthly"
+)
+
+// byMonth reports whether periods follow the calendar months rather than
+// running back to back from the anchor
+func (c *PayCalendar) byMonth() bool {
+    return c.Scheme == schemeSemiMonthly || c.Scheme == schemeMonthly
+}
+
+// periodDays is the length of a weekly or biweekly period
+func (c *PayCalendar) periodDays() int {
+    switch {
+    case c.PeriodDays > 0:
+        return c.PeriodDays
+    case c.Scheme == schemeWeekly:
+        return 7
+    }
+    return 14
+}
+
+func (c *PayCalendar) validate() error {
+    switch c.Scheme {
+    case "", schemeWeekly, schemeBiweekly:
+        if _, err := time.Parse("2006-01-02", c.Anchor); err != nil {
+            return fmt.Errorf("pay calendar anchor: %w", err)
+        }
+    case schemeSemiMonthly, schemeMonthly:
+        if c.PeriodDays != 0 {
+            return fmt.Errorf("pay calendar period_days does not apply to %s periods", c.Scheme)
+        }
+    default:
+        return fmt.Errorf("pay calendar scheme %q must be weekly, biweekly, semi_monthly or monthly", c.Scheme)
+    }
+    if (c.Scheme == schemeWeekly && c.PeriodDays != 0 && c.PeriodDays != 7) ||
+        (c.Scheme == schemeBiweekly && c.PeriodDays != 0 && c.PeriodDays != 14) {
+        return fmt.Errorf("pay calendar period_days %d does not match %s periods", c.PeriodDays, c.Scheme)
+    }
+    if c.FiscalStartMonth < 0 || c.FiscalStartMonth > 12 {
+        return fmt.Errorf("pay calendar fiscal_start_month %d out of range", c.FiscalStartMonth)
+    }
+    if c.FiscalStartDay < 0 || c.FiscalStartDay > 28 {
+        return fmt.Errorf("pay calendar fiscal_start_day %d out of range (1-28)", c.FiscalStartDay)
+    }
+    switch c.YearNumbering {
+    case "", "start", "end":
+    default:
+        return fmt.Errorf("pay calendar year_numbering %q must be start or end", c.YearNumbering)
+    }
+    switch c.YearLabel {
+    case "", "number", "fy", "span":
+    default:
+        return fmt.Errorf("pay calendar year_label %q must be number, fy or span", c.YearLabel)
+    }
+    return nil
+}
+
+// fiscalStart returns the first day of the fiscal year beginning in calendar year y
+func (c *PayCalendar) fiscalStart(y int) time.Time {
+    m, d := c.FiscalStartMonth, c.FiscalStartDay
+    if m == 0 {
+        m = 1
+    }
+    if d == 0 {
+        d = 1
+    }
+    return time.Date(y, time.Month(m), d, 0, 0, 0, 0, time.UTC)
+}
+
+// calendarYear reports whether the fiscal year is simply January-December
+func (c *PayCalendar) calendarYear() bool {
+    return c.FiscalStartMonth <= 1 && c.FiscalStartDay <= 1
+}
+
+// fiscalYearName turns the calendar year a fiscal year starts in into the
+// number it goes by.
+func (c *PayCalendar) fiscalYearName(startYear int) int {
+    if c.YearNumbering == "end" && !c.calendarYear() {
+        return startYear + 1
+    }
+    return startYear
+}
+
+// firstPeriodOnOrAfter aligns t forward onto the period cadence
+func (c *PayCalendar) firstPeriodOnOrAfter(anchor, t time.Time) time.Time {
+    p := c.periodStart(anchor, t)
+    if p.Before(t) {
+        p = p.AddDate(0, 0, c.periodDays())
+    }
+    return p
+}
+
+// periodStart returns the first day of the period containing day
+func (c *PayCalendar) periodStart(anchor, day time.Time) time.Time {
+    n := c.periodDays()
+    diff := int(day.Sub(anchor).Hours() / 24)
+    k := diff / n
+    if diff%n < 0 {
+        k--
+    }
+    return anchor.AddDate(0, 0, k*n)
+}
+
+// periodOf returns the numbered pay period that day (a calendar date at
+// UTC midnight) falls in. A period belongs to the fiscal year its first
+// day is in.
+func (c *PayCalendar) periodOf(day time.Time) (payPeriod, error) {
+    if c.byMonth() {
+        return c.monthPeriodOf(day), nil
+    }
+    anchor, err := time.Parse("2006-01-02", c.Anchor)
+    if err != nil {
+        return payPeriod{}, fmt.Errorf("pay calendar anchor: %w", err)
+    }
+    start := c.periodStart(anchor, day)
+
+    fyYear := start.Year()
+    if start.Before(c.fiscalStart(fyYear)) {
+        fyYear--
+    }
+    // Periods that begin before the first aligned period of this fiscal
+    // year still belong to the previous one.
+    first := c.firstPeriodOnOrAfter(anchor, c.fiscalStart(fyYear))
+    if start.Before(first) {
+        fyYear--
+        first = c.firstPeriodOnOrAfter(anchor, c.fiscalStart(fyYear))
+    }
+
+    return payPeriod{
+        FiscalYear: c.fiscalYearName(fyYear),
+        Number:     int(start.Sub(first).Hours()/24)/c.periodDays() + 1,
+        Start:      start,
+        End:        start.AddDate(0, 0, c.periodDays()-1),
+    }, nil
+}
+
+// monthPeriodOf numbers the semi-monthly or monthly period day falls in
+func (c *PayCalendar) monthPeriodOf(day time.Time) payPeriod {
+    start, end := c.monthPeriod(day)
+    fyYear := start.Year()
+    if start.Before(c.fiscalStart(fyYear)) {
+        fyYear--
+    }
+    first := c.monthPeriodOnOrAfter(c.fiscalStart(fyYear))
+    if start.Before(first) {
+        fyYear--
+        first = c.monthPeriodOnOrAfter(c.fiscalStart(fyYear))
+    }
+    return payPeriod{
+        FiscalYear: c.fiscalYearName(fyYear),
+        Number:     c.monthPeriodIndex(start) - c.monthPeriodIndex(first) + 1,
+        Start:      start,
+        End:        end,
+    }
+}
+
+// monthPeriod returns the first and last days of the semi-monthly or
+// monthly period containing day
+func (c *PayCalendar) monthPeriod(day time.Time) (start, end time.Time) {
+    y, m, d := day.Date()
+    start = time.Date(y, m, 1, 0, 0, 0, 0, time.UTC)
+    end = start.AddDate(0, 1, -1)
+    if c.Scheme == schemeSemiMonthly {
+        if d <= 15 {
+            end = time.Date(y, m, 15, 0, 0, 0, 0, time.UTC)
+        } else {
+            start = time.Date(y, m, 16, 0, 0, 0, 0, time.UTC)
+        }
+    }
+    return start, end
+}
+
+// monthPeriodOnOrAfter returns the first day of the first period that
+// begins on or after day
+func (c *PayCalendar) monthPeriodOnOrAfter(day time.Time) time.Time {
+    start, end := c.monthPeriod(day)
+    if start.Before(day) {
+        start, _ = c.monthPeriod(end.AddDate(0, 0, 1))
+    }
+    return start
+}
+
+// monthPeriodIndex counts the periods before the one starting on start
+func (c *PayCalendar) monthPeriodIndex(start time.Time) int {
+    n := start.Year()*12 + int(start.Month()) - 1
+    if c.Scheme != schemeSemiMonthly {
+        return n
+    }
+    n *= 2
+    if start.Day() > 15 {
+        n++
+    }
+    return n
+}
+
+// periodWeeks splits a period into week sheets of seven days from its
+// start, the last one as long as what is left
+func periodWeeks(p payPeriod) []WeekData {
+    days := int(p.End.Sub(p.Start).Hours()/24) + 1
+    weeks := make([]WeekData, (days+6)/7)
+    for i := range weeks {
+        weeks[i].WeekNumber = i + 1
+        if left := days - 7*i; left < 7 {
+            weeks[i].Days = left
+        }
+    }
+    return weeks
+}
+
+// yearLabel renders a fiscal year as configured for the year cell
+func (c *PayCalendar) yearLabel(fiscalYear int) interface{} {
+    if c == nil {
+        return fiscalYear
+    }
+    switch c.YearLabel {
+    case "fy":
+        return fmt.Sprintf("FY%d", fiscalYear)
+    case "span":
+        if c.calendarYear() {
+            return fmt.Sprintf("%d", fiscalYear)
+        }
+        first := fiscalYear
+        if c.fiscalYearName(first) != fiscalYear {
+            first--
+        }
+        return fmt.Sprintf("%d-%02d", first, (first+1)%100)
+    }
+    return fiscalYear
+}
+
+// cardStart is the first day a card covers, read in loc: its week start,
+// or for a card of bare entries the earliest entry
+func cardStart(req TimecardRequest, loc *time.Location) (time.Time, bool) {
+    s := req.WeekStartDate
+    if len(req.Weeks) > 0 && req.Weeks[0].WeekStartDate != "" {
+        s = req.Weeks[0].WeekStartDate
+    }
+    if t, err := parseCalendarDate(s, loc); err == nil {
+        return t, true
+    }
+    first, _, ok := entryRange(req.Entries, loc)
+    return first, ok
+}
+
+// entryRange returns the first and last days of entries, read in loc
+func entryRange(entries []Entry, loc *time.Location) (first, last time.Time, ok bool) {
+    for _, e := range entries {
+        day, err := parseCalendarDate(e.Date, loc)
+        if err != nil {
+            continue
+        }
+        if !ok || day.Before(first) {
+            first = day
+        }
+        if !ok || day.After(last) {
+            last = day
+        }
+        ok = true
+    }
+    return first, last, ok
+}
+
+// applyPayCalendar fills PayPeriodNum and Year from the tenant's calendar
+// when the client left them out, splits a card of bare entries into the
+// period's weeks, and fills in the start dates, numbers and labels of
+// weeks sent without them.
+func applyPayCalendar(req *TimecardRequest, t *Tenant) {
+    cal := t.payCalendar()
+    if cal == nil {
+        return
+    }
+    loc := cardLocation(*req, t)
+    if len(req.Weeks) == 0 {
+        deriveWeeks(req, cal, loc, t.Defaults.WeekLabels)
+    } else {
+        fillWeeks(req, cal, loc, t.Defaults.WeekLabels)
+    }
+    if req.PayPeriodNum != 0 && req.Year != 0 {
+        return
+    }
+    day, ok := cardStart(*req, loc)
+    if !ok {
+        return
+    }
+    pp, err := cal.periodOf(day)
+    if err != nil {
+        return
+    }
+    if req.PayPeriodNum == 0 {
+        req.PayPeriodNum = pp.Number
+    }
+    if req.Year == 0 {
+        req.Year = pp.FiscalYear
+    }
+}
+
+// deriveWeeks groups the card's entries into the weeks of the pay period
+// they fall in, with start dates and labels, unless they span more than
+// one period (checkPayCalendar reports that).
+func deriveWeeks(req *TimecardRequest, cal *PayCalendar, loc *time.Location, labels []string) {
+    first, last, ok := entryRange(req.Entries, loc)
+    if !ok {
+        return
+    }
+    pp, err := cal.periodOf(first)
+    if err != nil || last.After(pp.End) {
+        return
+    }
+    weeks := labelWeeks(pp, loc, labels)
+    for _, e := range req.Entries {
+        day, err := parseCalendarDate(e.Date, loc)
+        if err != nil {
+            continue // undated entries can't be placed; validation reports them
+        }
+        i := weekIndex(pp, day)
+        weeks[i].Entries = append(weeks[i].Entries, e)
+    }
+    req.Weeks, req.Entries = weeks, nil
+    if req.WeekStartDate == "" {
+        req.WeekStartDate = weeks[0].WeekStartDate
+    }
+    if req.WeekNumberLabel == "" {
+        req.WeekNumberLabel = weeks[0].WeekLabel
+    }
+}
+
+// fillWeeks completes the weeks a client sent from the pay period they
+// fall in: a week without a start date starts on the period's week that
+// holds its first entry, and one without a number or label gets the
+// period's. A week starting on a period week also gets its length.
+func fillWeeks(req *TimecardRequest, cal *PayCalendar, loc *time.Location, labels []string) {
+    for i := range req.Weeks {
+        wk := &req.Weeks[i]
+        day, err := parseCalendarDate(wk.WeekStartDate, loc)
+        if err != nil {
+            first, _, ok := entryRange(wk.Entries, loc)
+            if wk.WeekStartDate != "" || !ok {
+                continue // a bad date is reported by validation
+            }
+            day = first
+        }
+        pp, err := cal.periodOf(day)
+        if err != nil {
+            return
+        }
+        std := labelWeeks(pp, loc, labels)[weekIndex(pp, day)]
+        if wk.WeekStartDate == "" {
+            wk.WeekStartDate = std.WeekStartDate
+        }
+        if wk.WeekNumber == 0 {
+            wk.WeekNumber = std.WeekNumber
+        }
+        if wk.WeekLabel == "" {
+            wk.WeekLabel = std.WeekLabel
+        }
+        if wk.Days == 0 && wk.WeekStartDate == std.WeekStartDate {
+            wk.Days = std.Days
+        }
+    }
+    if req.WeekStartDate == "" {
+        req.WeekStartDate = req.Weeks[0].WeekStartDate
+    }
+    if req.WeekNumberLabel == "" {
+        req.WeekNumberLabel = req.Weeks[0].WeekLabel
+    }
+}
+
+// labelWeeks is periodWeeks with the weeks' start dates, read in loc, and
+// their labels: the tenant's by week of the period, else "Week #N"
+func labelWeeks(pp payPeriod, loc *time.Location, labels []string) []WeekData {
+    weeks := periodWeeks(pp)
+    for i := range weeks {
+        y, m, d := pp.Start.AddDate(0, 0, 7*i).Date()
+        weeks[i].WeekStartDate = time.Date(y, m, d, 0, 0, 0, 0, loc).Format(time.RFC3339)
+        weeks[i].WeekLabel = fmt.Sprintf("Week #%d", i+1)
+        if i < len(labels) {
+            weeks[i].WeekLabel = labels[i]
+        }
+    }
+    return weeks
+}
+
+// weekIndex is the week of pp that day falls in
+func weekIndex(pp payPeriod, day time.Time) int {
+    return int(day.Sub(pp.Start).Hours()/24) / 7
+}
+
+// checkPayCalendar warns when client-sent numbering disagrees with the
+// tenant's calendar (typically an app still counting calendar years) or a
+// week doesn't start on one of the period's weeks, and rejects bare
+// entries that don't fit in one period.
+func checkPayCalendar(v *validationResult, req TimecardRequest, t *Tenant) {
+    cal := t.payCalendar()
+    if cal == nil {
+        return
+    }
+    loc := cardLocation(req, t)
+    if len(req.Weeks) == 0 {
+        if first, last, ok := entryRange(req.Entries, loc); ok {
+            a, errA := cal.periodOf(first)
+            b, errB := cal.periodOf(last)
+            if errA == nil && errB == nil && a.Start != b.Start {
+                v.errorf("entries from %s to %s span pay periods %d/%d and %d/%d; send one card per period",
+                    first.Format("2006-01-02"), last.Format("2006-01-02"), a.Number, a.FiscalYear, b.Number, b.FiscalYear)
+            }
+        }
+    }
+    day, ok := cardStart(req, loc)
+    if !ok {
+        return
+    }
+    pp, err := cal.periodOf(day)
+    if err != nil {
+        v.warnf("%v", err)
+        return
+    }
+    if req.PayPeriodNum != pp.Number || req.Year != pp.FiscalYear {
+        v.warnf("pay period %d/%d does not match the pay calendar (%d/%d for %s)",
+            req.PayPeriodNum, req.Year, pp.Number, pp.FiscalYear, day.Format("2006-01-02"))
+    }
+    for i, wk := range req.Weeks {
+        start, err := parseCalendarDate(wk.WeekStartDate, loc)
+        if err != nil {
+            continue
+        }
+        wp, err := cal.periodOf(start)
+        if err == nil && int(start.Sub(wp.Start).Hours()/24)%7 != 0 {
+            v.warnf("weeks[%d]: week_start_date %s is not the start of a week of pay period %d/%d (from %s)",
+                i, start.Format("2006-01-02"), wp.Number, wp.FiscalYear, wp.Start.Format("2006-01-02"))
+        }
+    }
+}
+
+/* =======================
+   Pay calendar management
+   ======================= */
+
+// A tenant's calendar can be set without touching tenants.json:
+//
+//   GET    /api/pay-calendar                     the calendar in effect and where it comes from
+//   PUT    /api/pay-calendar                     a PayCalendar (admin key)
+//   DELETE /api/pay-calendar                     back to tenants.json (admin key)
+//   GET    /api/pay-calendar/periods?from=&to=   the numbered periods between two dates
+//   GET    /api/pay-calendar/card?from=&to=      the numbering of a card covering from..to
+//
+// With a calendar in place the app can send bare dated entries, or weeks
+// without dates, numbers or labels, and leave pay_period_num, year, week
+// start dates and week labels to the server; or ask for them up front with
+// the dates the card will cover.
+
+// payCalendars holds calendars set through the API, by tenant id
+var payCalendars = openCollection[PayCalendar]("pay_calendars")
+
+// payCalendar is the tenant's calendar in effect, or nil
+func (t *Tenant) payCalendar() *PayCalendar {
+    if t == nil {
+        return nil
+    }
+    if c, ok := payCalendars.Get(t.ID); ok {
+        return &c
+    }
+    return t.PayCalendar
+}
+
+// periodView is how a pay period is listed
+type periodView struct {
+    FiscalYear int         `json:"fiscal_year"`
+    YearLabel  interface{} `json:"year_label"`
+    Number     int         `json:"pay_period_num"`
+    Start      string      `json:"start"`
+    End        string      `json:"end"`
+    WeekStarts []string    `json:"week_starts"`
+}
+
+func payCalendarHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/pay-calendar"), "/")
+    t := tenantFor(r)
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        cal := t.payCalendar()
+        if cal == nil {
+            httpError(w, r, "no pay calendar", http.StatusNotFound)
+            return
+        }
+        source := "tenants.json"
+        if _, ok := payCalendars.Get(t.ID); ok {
+            source = "api"
+        }
+        writeJSON(w, http.StatusOK, map[string]interface{}{"calendar": cal, "source": source})
+    case rest == "" && r.Method == http.MethodPut:
+        adminAuth(func(w http.ResponseWriter, r *http.Request) {
+            var cal PayCalendar
+            if err := json.NewDecoder(r.Body).Decode(&cal); err != nil {
+                httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+                return
+            }
+            if err := cal.validate(); err != nil {
+                httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+                return
+            }
+            if err := payCalendars.Put(t.ID, cal); err != nil {
+                httpError(w, r, fmt.Sprintf("error saving pay calendar: %v", err), http.StatusInternalServerError)
+                return
+            }
+            loggerFrom(r.Context()).Printf("Pay calendar for %s set by %s: %+v", t.ID, identityFrom(r.Context()), cal)
+            writeJSON(w, http.StatusOK, cal)
+        })(w, r)
+    case rest == "" && r.Method == http.MethodDelete:
+        adminAuth(func(w http.ResponseWriter, r *http.Request) {
+            if err := payCalendars.Delete(t.ID); err != nil {
+                httpError(w, r, fmt.Sprintf("error deleting pay calendar: %v", err), http.StatusInternalServerError)
+                return
+            }
+            loggerFrom(r.Context()).Printf("Pay calendar for %s reset by %s", t.ID, identityFrom(r.Context()))
+            w.WriteHeader(http.StatusNoContent)
+        })(w, r)
+    case rest == "periods" && r.Method == http.MethodGet:
+        listPayPeriods(w, r, t)
+    case rest == "card" && r.Method == http.MethodGet:
+        cardNumbering(w, r, t)
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// listPayPeriods lists the periods touching from..to (default: the current
+// period and the five after it), at most 60
+func listPayPeriods(w http.ResponseWriter, r *http.Request, t *Tenant) {
+    cal := t.payCalendar()
+    if cal == nil {
+        httpError(w, r, "no pay calendar", http.StatusNotFound)
+        return
+    }
+    from := today(t.location())
+    to := from
+    for i := 0; i < 5; i++ {
+        if p, err := cal.periodOf(to); err == nil {
+            to = p.End.AddDate(0, 0, 1)
+        }
+    }
+    for _, q := range []struct {
+        name string
+        dst  *time.Time
+    }{{"from", &from}, {"to", &to}} {
+        if v := r.URL.Query().Get(q.name); v != "" {
+            d, err := time.Parse("2006-01-02", v)
+            if err != nil {
+                httpError(w, r, fmt.Sprintf("invalid request: %s must be YYYY-MM-DD", q.name), http.StatusBadRequest)
+                return
+            }
+            *q.dst = d
+        }
+    }
+
+    out := []periodView{}
+    for day := from; !day.After(to) && len(out) < 60; {
+        p, err := cal.periodOf(day)
+        if err != nil {
+            httpError(w, r, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        v := periodView{
+            FiscalYear: p.FiscalYear,
+            YearLabel:  cal.yearLabel(p.FiscalYear),
+            Number:     p.Number,
+            Start:      p.Start.Format("2006-01-02"),
+            End:        p.End.Format("2006-01-02"),
+        }
+        for d := p.Start; !d.After(p.End); d = d.AddDate(0, 0, 7) {
+            v.WeekStarts = append(v.WeekStarts, d.Format("2006-01-02"))
+        }
+        out = append(out, v)
+        day = p.End.AddDate(0, 0, 1)
+    }
+    writeJSON(w, http.StatusOK, out)
+}
+
+// cardView is the numbering of a card, as a TimecardRequest takes it
+type cardView struct {
+    PayPeriodNum    int         `json:"pay_period_num"`
+    Year            int         `json:"year"`
+    YearLabel       interface{} `json:"year_label"`
+    WeekStartDate   string      `json:"week_start_date"`
+    WeekNumberLabel string      `json:"week_number_label"`
+    PeriodStart     string      `json:"period_start"`
+    PeriodEnd       string      `json:"period_end"`
+    Weeks           []WeekData  `json:"weeks"`
+}
+
+// cardNumbering answers with the pay period and weeks of a card for the
+// days from..to (to defaults to from), which must fall in one period. Only
+// the period's weeks touching those days are listed.
+func cardNumbering(w http.ResponseWriter, r *http.Request, t *Tenant) {
+    cal := t.payCalendar()
+    if cal == nil {
+        httpError(w, r, "no pay calendar", http.StatusNotFound)
+        return
+    }
+    q := r.URL.Query()
+    from, err := time.Parse("2006-01-02", q.Get("from"))
+    if err != nil {
+        httpError(w, r, "invalid request: from must be YYYY-MM-DD", http.StatusBadRequest)
+        return
+    }
+    to := from
+    if v := q.Get("to"); v != "" {
+        if to, err = time.Parse("2006-01-02", v); err != nil || to.Before(from) {
+            httpError(w, r, "invalid request: to must be YYYY-MM-DD, not before from", http.StatusBadRequest)
+            return
+        }
+    }
+    pp, err := cal.periodOf(from)
+    if err != nil {
+        httpError(w, r, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if to.After(pp.End) {
+        httpError(w, r, fmt.Sprintf("invalid request: %s to %s spans more than one pay period (%d/%d ends %s)",
+            from.Format("2006-01-02"), to.Format("2006-01-02"), pp.Number, pp.FiscalYear, pp.End.Format("2006-01-02")), http.StatusBadRequest)
+        return
+    }
+
+    weeks := labelWeeks(pp, t.location(), t.Defaults.WeekLabels)
+    weeks = weeks[weekIndex(pp, from) : weekIndex(pp, to)+1]
+    for i := range weeks {
+        weeks[i].Entries = []Entry{}
+    }
+    writeJSON(w, http.StatusOK, cardView{
+        PayPeriodNum:    pp.Number,
+        Year:            pp.FiscalYear,
+        YearLabel:       cal.yearLabel(pp.FiscalYear),
+        WeekStartDate:   weeks[0].WeekStartDate,
+        WeekNumberLabel: weeks[0].WeekLabel,
+        PeriodStart:     pp.Start.Format("2006-01-02"),
+        PeriodEnd:       pp.End.Format("2006-01-02"),
+        Weeks:           weeks,
+    })
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+    "fmt"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestPeriodOf(t *testing.T) {
+    biweekly := &PayCalendar{Anchor: "2025-01-05"}
+    weekly := &PayCalendar{Anchor: "2025-01-06", PeriodDays: 7}
+    april := &PayCalendar{Anchor: "2025-01-05", FiscalStartMonth: 4, YearNumbering: "end"}
+    semiMonthly := &PayCalendar{Scheme: "semi_monthly"}
+    monthly := &PayCalendar{Scheme: "monthly", FiscalStartMonth: 4}
+
+    tests := []struct {
+        name       string
+        cal        *PayCalendar
+        day        string
+        year, num  int
+        start, end string
+    }{
+        {"anchor", biweekly, "2025-01-05", 2025, 1, "2025-01-05", "2025-01-18"},
+        {"period's last day", biweekly, "2025-01-18", 2025, 1, "2025-01-05", "2025-01-18"},
+        {"next period", biweekly, "2025-01-19", 2025, 2, "2025-01-19", "2025-02-01"},
+        {"before the anchor", biweekly, "2025-01-04", 2024, 26, "2024-12-22", "2025-01-04"},
+        {"runs into the new year", biweekly, "2026-01-02", 2025, 26, "2025-12-21", "2026-01-03"},
+        {"first of the new year", biweekly, "2026-01-04", 2026, 1, "2026-01-04", "2026-01-17"},
+        {"weekly", weekly, "2025-01-13", 2025, 2, "2025-01-13", "2025-01-19"},
+        {"53rd week", weekly, "2025-01-05", 2024, 53, "2024-12-30", "2025-01-05"},
+        {"fiscal year end", april, "2025-04-12", 2025, 26, "2025-03-30", "2025-04-12"},
+        {"fiscal year start", april, "2025-04-13", 2026, 1, "2025-04-13", "2025-04-26"},
+        {"fiscal mid-year", april, "2026-01-01", 2026, 19, "2025-12-21", "2026-01-03"},
+        {"semi-monthly first half", semiMonthly, "2025-02-15", 2025, 3, "2025-02-01", "2025-02-15"},
+        {"semi-monthly second half", semiMonthly, "2025-02-16", 2025, 4, "2025-02-16", "2025-02-28"},
+        {"semi-monthly year end", semiMonthly, "2025-12-31", 2025, 24, "2025-12-16", "2025-12-31"},
+        {"monthly fiscal start", monthly, "2025-04-30", 2025, 1, "2025-04-01", "2025-04-30"},
+        {"monthly fiscal end", monthly, "2026-03-01", 2025, 12, "2026-03-01", "2026-03-31"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            pp, err := tt.cal.periodOf(parseTestDay(tt.day))
+            if err != nil {
+                t.Fatal(err)
+            }
+            got := payPeriodString(pp)
+            want := payPeriodString(payPeriod{FiscalYear: tt.year, Number: tt.num, Start: parseTestDay(tt.start), End: parseTestDay(tt.end)})
+            if got != want {
+                t.Errorf("periodOf(%s) = %s, want %s", tt.day, got, want)
+            }
+        })
+    }
+}
+
+func TestPeriodWeeks(t *testing.T) {
+    for _, tt := range []struct {
+        start, end string
+        weeks, last int
+    }{
+        {"2025-01-05", "2025-01-18", 2, 0},
+        {"2025-01-16", "2025-01-31", 3, 2},
+        {"2025-02-16", "2025-02-28", 2, 6},
+        {"2025-03-01", "2025-03-31", 5, 3},
+    } {
+        weeks := periodWeeks(payPeriod{Start: parseTestDay(tt.start), End: parseTestDay(tt.end)})
+        if len(weeks) != tt.weeks || weeks[len(weeks)-1].Days != tt.last {
+            t.Errorf("%s..%s: %+v", tt.start, tt.end, weeks)
+        }
+    }
+
+    for _, cal := range []*PayCalendar{
+        {Scheme: "fortnightly", Anchor: "2025-01-05"},
+        {Scheme: "weekly"},
+        {Scheme: "monthly", PeriodDays: 14},
+        {Scheme: "weekly", Anchor: "2025-01-05", PeriodDays: 14},
+    } {
+        if err := cal.validate(); err == nil {
+            t.Errorf("%+v accepted", cal)
+        }
+    }
+}
+
+func TestFillWeeks(t *testing.T) {
+    tenant := &Tenant{ID: "fill-weeks-test", PayCalendar: &PayCalendar{Anchor: "2025-01-05"}}
+    req := TimecardRequest{Weeks: []WeekData{
+        {WeekStartDate: "2025-01-05T00:00:00Z", WeekLabel: "First"},
+        {Entries: []Entry{worked("+Tue", "A", 8)}},
+    }}
+    applyPayCalendar(&req, tenant)
+    got := fmt.Sprintf("%d/%d %s %q", req.PayPeriodNum, req.Year, req.WeekStartDate, req.WeekNumberLabel)
+    if want := `1/2025 2025-01-05T00:00:00Z "First"`; got != want {
+        t.Errorf("card %s, want %s", got, want)
+    }
+    if wk := req.Weeks[1]; wk.WeekStartDate != "2025-01-12T00:00:00Z" || wk.WeekNumber != 2 || wk.WeekLabel != "Week #2" {
+        t.Errorf("week 2 = %+v", wk)
+    }
+
+    var v validationResult
+    req.Weeks[1].WeekStartDate = "2025-01-13T00:00:00Z"
+    checkPayCalendar(&v, req, tenant)
+    if len(v.Warnings) != 1 || !strings.Contains(v.Warnings[0], "weeks[1]: week_start_date 2025-01-13") {
+        t.Errorf("warnings = %q", v.Warnings)
+    }
+}
+
+func TestYearLabel(t *testing.T) {
+    tests := []struct {
+        cal  *PayCalendar
+        year int
+        want interface{}
+    }{
+        {nil, 2025, 2025},
+        {&PayCalendar{YearLabel: "fy"}, 2025, "FY2025"},
+        {&PayCalendar{YearLabel: "span"}, 2025, "2025"},
+        {&PayCalendar{FiscalStartMonth: 4, YearNumbering: "end", YearLabel: "span"}, 2026, "2025-26"},
+        {&PayCalendar{FiscalStartMonth: 4, YearLabel: "span"}, 2025, "2025-26"},
+        {&PayCalendar{FiscalStartMonth: 4, YearLabel: "span"}, 2099, "2099-00"},
+    }
+    for _, tt := range tests {
+        if got := tt.cal.yearLabel(tt.year); got != tt.want {
+            t.Errorf("%+v: yearLabel(%d) = %v, want %v", tt.cal, tt.year, got, tt.want)
+        }
+    }
+}
+
+func parseTestDay(s string) time.Time {
+    d, err := time.Parse("2006-01-02", s)
+    if err != nil {
+        panic(err)
+    }
+    return d
+}
+
+func payPeriodString(pp payPeriod) string {
+    return fmt.Sprintf("%d/PP%02d %s..%s", pp.FiscalYear, pp.Number, pp.Start.Format("2006-01-02"), pp.End.Format("2006-01-02"))
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "time"
+)
+
+/* =========================
+   Pay-period close emailing
+   ========================= */
+
+// A tenant with a pay calendar can have its cards emailed to payroll
+// automatically when a period closes:
+//
+//   "payroll_schedule": {"cron": "0 17 * * *", "to": "payroll@example.com",
+//                        "format": "pdf", "require_approval": true}
+//
+// The cron expression is read in the tenant's timezone. When it fires, the
+// latest period that has ended (today counts as ended on its last day) is
+// closed, once: a "payroll-close" job emails each employee's current card for
+// that period to payroll, one message per card, and then mails report_to
+// (default: to) a summary of what was sent, what failed and what was
+// skipped. Cards awaiting or refused approval are always skipped; with
+// require_approval, so are cards that were never submitted. The summary is
+// also the job's result under /admin/jobs.
+
+// PayrollSchedule says when and where a tenant's closed periods are sent
+type PayrollSchedule struct {
+    Cron            string `json:"cron"`
+    To              string `json:"to"`
+    CC              string `json:"cc,omitempty"`
+    ReportTo        string `json:"report_to,omitempty"`
+    Format          string `json:"format,omitempty"` // xlsx (default) or pdf
+    RequireApproval bool   `json:"require_approval,omitempty"`
+
+    spec *cronSpec
+}
+
+func (s *PayrollSchedule) validate(t *Tenant) error {
+    spec, err := parseCron(s.Cron)
+    if err != nil {
+        return err
+    }
+    if t.payCalendar() == nil {
+        return fmt.Errorf("payroll schedule needs a pay calendar")
+    }
+    if strings.TrimSpace(s.To) == "" {
+        return fmt.Errorf("payroll schedule needs a to address")
+    }
+    switch s.Format {
+    case "", "xlsx", "pdf":
+    default:
+        return fmt.Errorf("payroll schedule format %q is not xlsx or pdf", s.Format)
+    }
+    s.spec = spec
+    return nil
+}
+
+// PayrollRun marks a tenant's period as closed, so it is only sent once
+type PayrollRun struct {
+    ID           string    `json:"id"` // tenant/year/period
+    Tenant       string    `json:"tenant"`
+    Year         int       `json:"year"`
+    PayPeriodNum int       `json:"pay_period_num"`
+    JobID        string    `json:"job_id"`
+    CreatedAt    time.Time `json:"created_at"`
+}
+
+var payrollRuns = openCollection[PayrollRun]("payroll_runs")
+
+// payrollCloseParams are the params of a "payroll-close" job
+type payrollCloseParams struct {
+    Tenant       string `json:"tenant"`
+    Year         int    `json:"year"`
+    PayPeriodNum int    `json:"pay_period_num"`
+}
+
+// payrollItem is one card's outcome in a close summary
+type payrollItem struct {
+    TimecardID string `json:"timecard_id"`
+    Employee   string `json:"employee"`
+    Reason     string `json:"reason,omitempty"` // why it failed or was skipped
+}
+
+// payrollSummary is the result of a "payroll-close" job
+type payrollSummary struct {
+    Tenant       string        `json:"tenant"`
+    Year         int           `json:"year"`
+    PayPeriodNum int           `json:"pay_period_num"`
+    To           string        `json:"to"`
+    Sent         []payrollItem `json:"sent"`
+    Failed       []payrollItem `json:"failed"`
+    Skipped      []payrollItem `json:"skipped"`
+}
+
+func init() {
+    registerJobKind("payroll-close", runPayrollCloseJob)
+}
+
+// startPayrollScheduler checks the tenants' payroll schedules every minute
+// until ctx ends
+func startPayrollScheduler(ctx context.Context) {
+    if !anyTenant(func(t *Tenant) bool { return t.PayrollSchedule != nil }) {
+        return
+    }
+    log.Printf("Payroll scheduler started")
+    go everyMinute(ctx, checkPayrollSchedules)
+}
+
+func checkPayrollSchedules() {
+    for _, t := range loadTenants() {
+        s := t.PayrollSchedule
+        if s == nil || !s.spec.matches(now().In(t.location())) {
+            continue
+        }
+        p, err := closedPeriod(t)
+        if err != nil {
+            log.Printf("Warning: tenant %s payroll schedule: %v", t.ID, err)
+            continue
+        }
+        closePayPeriod(t.ID, p.FiscalYear, p.Number)
+    }
+}
+
+// closedPeriod is the tenant's latest pay period that has ended
+func closedPeriod(t *Tenant) (payPeriod, error) {
+    cal := t.payCalendar()
+    if cal == nil {
+        return payPeriod{}, fmt.Errorf("no pay calendar")
+    }
+    day := today(t.location())
+    p, err := cal.periodOf(day)
+    if err != nil || p.End.Equal(day) {
+        return p, err
+    }
+    return cal.periodOf(p.Start.AddDate(0, 0, -1))
+}
+
+// closePayPeriod queues the period's payroll email unless it was queued before
+func closePayPeriod(tenant string, year, period int) {
+    id := fmt.Sprintf("%s/%d/%02d", tenant, year, period)
+    if _, done := payrollRuns.Get(id); done {
+        return
+    }
+    job, err := enqueueJob("payroll-close", "scheduler", 0, payrollCloseParams{Tenant: tenant, Year: year, PayPeriodNum: period})
+    if err != nil {
+        log.Printf("Warning: could not queue payroll close for %s: %v", id, err)
+        return
+    }
+    run := PayrollRun{ID: id, Tenant: tenant, Year: year, PayPeriodNum: period, JobID: job.ID, CreatedAt: now().UTC()}
+    if err := payrollRuns.Put(id, run); err != nil {
+        log.Printf("Warning: could not record payroll run %s: %v", id, err)
+    }
+    log.Printf("Closing pay period %d/%d for tenant %s (job %s)", period, year, tenant, job.ID)
+}
+
+func runPayrollCloseJob(ctx context.Context, job *BackgroundJob) (interface{}, error) {
+    var p payrollCloseParams
+    if err := json.Unmarshal(job.Params, &p); err != nil {
+        return nil, fmt.Errorf("invalid params: %w", err)
+    }
+    tenant := lookupTenant(p.Tenant)
+    ctx = withTenant(ctx, tenant)
+    s := tenant.PayrollSchedule
+    if s == nil {
+        return nil, fmt.Errorf("tenant %s has no payroll schedule", p.Tenant)
+    }
+    format := s.Format
+    if format == "" {
+        format = "xlsx"
+    }
+    var cc *string
+    if s.CC != "" {
+        cc = &s.CC
+    }
+
+    sum := payrollSummary{Tenant: p.Tenant, Year: p.Year, PayPeriodNum: p.PayPeriodNum, To: s.To,
+        Sent: []payrollItem{}, Failed: []payrollItem{}, Skipped: []payrollItem{}}
+    recs := periodRecords(p.Tenant, p.Year, p.PayPeriodNum)
+    for i, rec := range recs {
+        reportJobProgress(job, i, len(recs))
+        item := payrollItem{TimecardID: rec.ID, Employee: rec.Employee}
+        if reason := payrollSkipReason(rec, s); reason != "" {
+            item.Reason = reason
+            sum.Skipped = append(sum.Skipped, item)
+            continue
+        }
+        if err := sendToPayroll(ctx, rec, format, s.To, cc); err != nil {
+            item.Reason = err.Error()
+            sum.Failed = append(sum.Failed, item)
+            continue
+        }
+        sum.Sent = append(sum.Sent, item)
+    }
+    reportJobProgress(job, len(recs), len(recs))
+
+    reportTo := s.ReportTo
+    if reportTo == "" {
+        reportTo = s.To
+    }
+    subject := fmt.Sprintf("Payroll PP%02d %d: %d sent, %d failed, %d skipped",
+        p.PayPeriodNum, p.Year, len(sum.Sent), len(sum.Failed), len(sum.Skipped))
+    if err := sendEmail(ctx, reportTo, nil, subject, sum.text(), nil, "", nil); err != nil {
+        return sum, fmt.Errorf("sending summary: %w", err)
+    }
+    return sum, nil
+}
+
+// periodRecords returns each employee's current revision for the period
+func periodRecords(tenant string, year, period int) []TimecardRecord {
+    newest := map[string]TimecardRecord{}
+    for _, rec := range timecards.List() {
+        if rec.Tenant != tenant || rec.Year != year || rec.PayPeriodNum != period {
+            continue
+        }
+        key := strings.ToLower(rec.Employee)
+        if cur, ok := newest[key]; !ok || laterRevision(rec, cur) {
+            newest[key] = rec
+        }
+    }
+    out := make([]TimecardRecord, 0, len(newest))
+    for _, rec := range newest {
+        out = append(out, rec)
+    }
+    sort.Slice(out, func(a, b int) bool { return out[a].Employee < out[b].Employee })
+    return out
+}
+
+func payrollSkipReason(rec TimecardRecord, s *PayrollSchedule) string {
+    switch {
+    case rec.Approval == nil && s.RequireApproval:
+        return "not submitted for approval"
+    case rec.Approval == nil:
+        return ""
+    case rec.Approval.Status == approvalPending:
+        return "awaiting approval by " + rec.Approval.Manager
+    case rec.Approval.Status == approvalRejected:
+        return "rejected: " + rec.Approval.Comment
+    }
+    return ""
+}
+
+// sendToPayroll emails one card and keeps the receipt on its record
+func sendToPayroll(ctx context.Context, rec TimecardRecord, format, to string, cc *string) error {
+    auditCardTouched(ctx, rec.ID, rec.Employee, rec.PayPeriodNum, rec.Year)
+    name, data, err := renderRecord(ctx, rec, format)
+    if err != nil {
+        return err
+    }
+    req, _ := rec.request()
+    subject := fmt.Sprintf("Timecard - %s - PP%02d %d", rec.Employee, rec.PayPeriodNum, rec.Year)
+    body := fmt.Sprintf("Timecard for %s, pay period %d, %d.", rec.Employee, rec.PayPeriodNum, rec.Year)
+    err = sendEmail(ctx, to, cc, subject, body, data, name[strings.LastIndex(name, "/")+1:], nil)
+
+    receipt := DeliveryReceipt{Channel: "email", To: to, Provider: "smtp", Status: "sent", At: now().UTC()}
+    ev := EmailTimecardRequest{TimecardRequest: req, To: to, CC: cc}
+    if err != nil {
+        receipt.Status, receipt.Error = "failed", err.Error()
+        emitEvent(ctx, "email.failed", rec.Tenant, emailEventData(rec.ID, ev, len(data), err))
+    } else {
+        emitEvent(ctx, "email.sent", rec.Tenant, emailEventData(rec.ID, ev, len(data), nil))
+    }
+    if cur, ok := timecards.Get(rec.ID); ok {
+        cur.Deliveries = append(cur.Deliveries, receipt)
+        if perr := timecards.Put(cur.ID, cur); perr != nil {
+            log.Printf("Warning: could not record payroll receipt for %s: %v", rec.ID, perr)
+        }
+    }
+    return err
+}
+
+// text is the summary email's body
+func (s payrollSummary) text() string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Pay period %d, %d was sent to %s.\r\n", s.PayPeriodNum, s.Year, s.To)
+    for _, sec := range []struct {
+        title string
+        items []payrollItem
+    }{
+        {"Sent", s.Sent},
+        {"Failed", s.Failed},
+        {"Skipped", s.Skipped},
+    } {
+        fmt.Fprintf(&b, "\r\n%s (%d):\r\n", sec.title, len(sec.items))
+        for _, it := range sec.items {
+            line := "  " + it.Employee
+            if it.Reason != "" {
+                line += " - " + it.Reason
+            }
+            b.WriteString(line + "\r\n")
+        }
+    }
+    return b.String()
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+    "fmt"
+)
+
+/* ============
+   PDF/A output
+   ============ */
+
+// Document retention systems that only ingest PDF/A get it when a card
+// asks for it,
+//
+//   "pdf_a": "2b"
+//
+// or when the tenant sets "pdf_a" for all its PDFs. LibreOffice exports
+// PDF/A-1b, -2b and -3b. A PDF/A file can't be encrypted, so a card can't
+// ask for PDF/A and have a PDF password too, and a tenant can't set both.
+
+// pdfALevels are soffice's SelectPdfVersion for each conformance level
+var pdfALevels = map[string]int{"1b": 1, "2b": 2, "3b": 3}
+
+func validatePDFA(level string) error {
+    if _, ok := pdfALevels[level]; !ok {
+        return fmt.Errorf("pdf_a %q must be 1b, 2b or 3b", level)
+    }
+    return nil
+}
+
+// pdfA is the PDF/A level req's PDF is exported at, or "" for plain PDF
+func (req TimecardRequest) pdfA(t *Tenant) string {
+    if req.PDFA != "" || t == nil {
+        return req.PDFA
+    }
+    return t.PDFA
+}
+
+// checkPDFA rejects levels soffice doesn't export and PDF/A cards that
+// would be locked with a password
+func checkPDFA(v *validationResult, req TimecardRequest, t *Tenant) {
+    level := req.pdfA(t)
+    if level == "" {
+        return
+    }
+    if err := validatePDFA(level); err != nil {
+        v.errorf("%v", err)
+    }
+    if req.pdfPassword(t) != "" {
+        v.errorf("a PDF/A file can't be locked with a password; send pdf_a or pdf_password, not both")
+    }
+}
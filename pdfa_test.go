@@ -0,0 +1,34 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestPDFA(t *testing.T) {
+    tenant := &Tenant{ID: "pdfa-test", PDFA: "2b"}
+    req := TimecardRequest{EmployeeName: "Bob Smith"}
+    if got := req.pdfOptions(tenant).exportFilter(); !strings.Contains(got, `"SelectPdfVersion":{"type":"long","value":"2"}`) {
+        t.Errorf("tenant filter = %q", got)
+    }
+    req.PDFA = "1b"
+    if got := req.pdfOptions(tenant).exportFilter(); !strings.Contains(got, `"value":"1"`) {
+        t.Errorf("card filter = %q", got)
+    }
+
+    for _, tt := range []struct {
+        name string
+        req  TimecardRequest
+        want int
+    }{
+        {"tenant level", TimecardRequest{}, 0},
+        {"unknown level", TimecardRequest{PDFA: "4u"}, 1},
+        {"with a password", TimecardRequest{PDFPassword: "s3cret"}, 1},
+    } {
+        var v validationResult
+        checkPDFA(&v, tt.req, tenant)
+        if len(v.Errors) != tt.want {
+            t.Errorf("%s: errors %q", tt.name, v.Errors)
+        }
+    }
+}
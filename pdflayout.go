@@ -0,0 +1,173 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "slices"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==========
+   PDF layout
+   ========== */
+
+// By default a card's PDF is its whole workbook as LibreOffice prints it,
+// template sheets the card left empty included. A card can lay it out
+// itself:
+//
+//   "pdf_layout": "merged"      one PDF, each week's sheet shrunk onto a page
+//                               of its own, sheets the card doesn't use left out
+//   "pdf_layout": "per_week"    one such PDF per week; /api/generate-pdf and
+//                               a PDF regenerate answer with a ZIP of them
+//
+// The expense and travel sheets print after the last week. Anywhere else
+// a PDF is made (bundles, emails, imports), per_week cards get the merged
+// PDF. A page_setup (pagesetup.go) still wins over the one-page fit.
+
+const (
+    pdfLayoutMerged  = "merged"
+    pdfLayoutPerWeek = "per_week"
+
+    // printAllWeeks is genContext.printWeek for the merged layout
+    printAllWeeks = -1
+)
+
+// checkPDFLayout rejects layouts the server doesn't know
+func checkPDFLayout(v *validationResult, req TimecardRequest) {
+    switch req.PDFLayout {
+    case "", pdfLayoutMerged, pdfLayoutPerWeek:
+    default:
+        v.errorf("pdf_layout %q must be merged or per_week", req.PDFLayout)
+    }
+}
+
+// layoutForPrint hides the sheets gc.printWeek leaves out of the PDF and
+// fits each week's sheet that stays on one page. weeks are the sheets the
+// card's weeks were written on, in order.
+func layoutForPrint(gc *genContext, f *excelize.File, weeks []string) {
+    if gc.printWeek == 0 {
+        return
+    }
+    keep := map[string]bool{}
+    for i, sheet := range weeks {
+        keep[sheet] = gc.printWeek == printAllWeeks || gc.printWeek == i+1
+    }
+    if gc.printWeek == printAllWeeks || gc.printWeek == len(weeks) {
+        keep[expenseSheet], keep[travelSheet] = true, true
+    }
+
+    sheets := f.GetSheetList()
+    // The active sheet can't be hidden
+    for i, sheet := range sheets {
+        if keep[sheet] {
+            f.SetActiveSheet(i)
+            break
+        }
+    }
+    fit, pages := true, 1
+    for _, sheet := range sheets {
+        if !keep[sheet] {
+            if err := f.SetSheetVisible(sheet, false); err != nil {
+                gc.lg.Printf("Warning: could not leave %s out of the PDF: %v", sheet, err)
+            }
+            continue
+        }
+        if slices.Contains(weeks, sheet) {
+            _ = f.SetPageLayout(sheet, &excelize.PageLayoutOptions{FitToWidth: &pages, FitToHeight: &pages})
+            _ = f.SetSheetProps(sheet, &excelize.SheetPropsOptions{FitToPage: &fit})
+        }
+    }
+}
+
+// printedExcel is the workbook gc's PDF is converted from: excelData as
+// generated, or the card generated again laid out as it asks
+func printedExcel(gc *genContext, excelData []byte) ([]byte, error) {
+    if gc.printWeek != 0 || gc.req.PDFLayout == "" {
+        return excelData, nil
+    }
+    pc := *gc
+    pc.printWeek = printAllWeeks
+    return renderExcel(&pc)
+}
+
+// renderWeekPDFs converts each of gc's weeks into a PDF of its own
+func renderWeekPDFs(gc *genContext) ([][]byte, error) {
+    var out [][]byte
+    for i := range gc.req.Weeks {
+        pc := *gc
+        pc.printWeek = i + 1
+        excelData, err := renderExcel(&pc)
+        if err != nil {
+            return nil, err
+        }
+        pdfData, err := renderPDF(&pc, excelData)
+        if err != nil {
+            return nil, fmt.Errorf("week %d: %w", i+1, err)
+        }
+        out = append(out, pdfData)
+    }
+    return out, nil
+}
+
+// sendWeekPDFs answers /api/generate-pdf for a per_week card with a ZIP of
+// its weeks' PDFs
+func sendWeekPDFs(w http.ResponseWriter, r *http.Request, gc *genContext, payload json.RawMessage) {
+    req, lg := gc.req, gc.lg
+    pdfs, err := renderWeekPDFs(gc)
+    if err != nil {
+        lg.Printf("pdf conversion error: %v", err)
+        generationFailed(w, r, "error converting to PDF", err)
+        return
+    }
+    archive, err := weekPDFArchive(req, pdfs)
+    if err != nil {
+        generationFailed(w, r, "error building PDF archive", err)
+        return
+    }
+    reportProgress(r.Context(), "converted", fmt.Sprintf("%d PDFs, %d bytes", len(pdfs), len(archive)))
+    finishProgress(r.Context(), nil)
+
+    id := saveTimecardRecord(r, "pdf", payload, req)
+    if id != "" {
+        if link := storeArtifact(r, id, req, "zip", archive); link != "" {
+            w.Header().Set("X-Timecard-Drive-Link", link)
+        }
+        w.Header().Set("X-Timecard-ID", id)
+    }
+    emitEvent(r.Context(), "timecard.generated", tenantFor(r).ID, timecardEventData(id, req, "pdf", len(archive)))
+    writeWeekPDFArchive(w, req, archive)
+
+    lg.Printf("OK: %d week PDFs, zip bytes=%d", len(pdfs), len(archive))
+}
+
+// weekPDFArchive zips req's week PDFs, in week order
+func weekPDFArchive(req TimecardRequest, pdfs [][]byte) ([]byte, error) {
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    for i, pdfData := range pdfs {
+        name := fmt.Sprintf("timecard_%s_week%d.pdf", req.fileEmployee(), i+1)
+        fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: now()})
+        if err == nil {
+            _, err = fw.Write(pdfData)
+        }
+        if err != nil {
+            return nil, err
+        }
+    }
+    if err := zw.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// writeWeekPDFArchive answers with a per_week card's ZIP
+func writeWeekPDFArchive(w http.ResponseWriter, req TimecardRequest, archive []byte) {
+    w.Header().Set("Content-Type", "application/zip")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"timecard_%s_weeks.zip\"", req.fileEmployee()))
+    w.WriteHeader(http.StatusOK)
+    _, _ = w.Write(archive)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "github.com/xuri/excelize/v2"
+)
+
+func TestLayoutForPrint(t *testing.T) {
+    req := TimecardRequest{EmployeeName: "Bob Smith", PDFLayout: pdfLayoutPerWeek, Weeks: make([]WeekData, 2)}
+    render := func(week int) *excelize.File {
+        f := excelize.NewFile()
+        _ = f.SetSheetName("Sheet1", "Week 1")
+        for _, sheet := range []string{"Week 2", "Instructions", travelSheet} {
+            _, _ = f.NewSheet(sheet)
+        }
+        gc := newGenContext(context.Background(), req, &Tenant{ID: "layout-test"}, loggerFrom(context.Background()))
+        gc.printWeek = week
+        layoutForPrint(gc, f, []string{"Week 1", "Week 2"})
+        return f
+    }
+    visible := func(f *excelize.File) (out []string) {
+        for _, sheet := range f.GetSheetList() {
+            if ok, _ := f.GetSheetVisible(sheet); ok {
+                out = append(out, sheet)
+            }
+        }
+        return out
+    }
+
+    for _, tt := range []struct {
+        week int
+        want []string
+    }{
+        {0, []string{"Week 1", "Week 2", "Instructions", travelSheet}},
+        {printAllWeeks, []string{"Week 1", "Week 2", travelSheet}},
+        {1, []string{"Week 1"}},
+        {2, []string{"Week 2", travelSheet}},
+    } {
+        f := render(tt.week)
+        got := visible(f)
+        if len(got) != len(tt.want) {
+            t.Errorf("week %d: visible %q, want %q", tt.week, got, tt.want)
+            continue
+        }
+        for i := range got {
+            if got[i] != tt.want[i] {
+                t.Errorf("week %d: visible %q, want %q", tt.week, got, tt.want)
+                break
+            }
+        }
+        if tt.week != 0 {
+            layout, _ := f.GetPageLayout(tt.want[0])
+            if layout.FitToWidth == nil || *layout.FitToWidth != 1 || *layout.FitToHeight != 1 {
+                t.Errorf("week %d: %s not fit to a page", tt.week, tt.want[0])
+            }
+            if active := f.GetSheetName(f.GetActiveSheetIndex()); active != tt.want[0] {
+                t.Errorf("week %d: active sheet %s", tt.week, active)
+            }
+        }
+    }
+
+    var v validationResult
+    checkPDFLayout(&v, TimecardRequest{PDFLayout: "per_page"})
+    if len(v.Errors) != 1 {
+        t.Errorf("errors = %q", v.Errors)
+    }
+}
+
+func TestRegeneratePerWeek(t *testing.T) {
+    withTestData(t)
+    fakePreviewTools(t)
+    rec := TimecardRecord{ID: "per-week", Tenant: defaultTenantID, Kind: "pdf", Employee: "Bob Smith", PayPeriodNum: 1, Year: 2025,
+        SchemaVersion: currentPayloadVersion,
+        Payload: json.RawMessage(`{"employee_name": "Bob Smith", "pay_period_num": 1, "year": 2025, "pdf_layout": "per_week",
+            "weeks": [{"week_start_date": "2025-01-05T00:00:00Z", "entries": [{"date": "2025-01-06T00:00:00Z", "job_code": "29699", "hours": 8}]},
+                      {"week_start_date": "2025-01-12T00:00:00Z", "entries": [{"date": "2025-01-13T00:00:00Z", "job_code": "29699", "hours": 8}]}]}`)}
+    if err := timecards.Put(rec.ID, rec); err != nil {
+        t.Fatal(err)
+    }
+
+    r := httptest.NewRequest(http.MethodPost, "/api/timecards/per-week/regenerate?format=pdf", nil)
+    w := httptest.NewRecorder()
+    timecardsHandler(w, r)
+    if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/zip" {
+        t.Fatalf("regenerate: %d %s %.80s", w.Code, w.Header().Get("Content-Type"), w.Body)
+    }
+    zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var names []string
+    for _, zf := range zr.File {
+        names = append(names, zf.Name)
+    }
+    if len(names) != 2 || names[0] != "timecard_Bob Smith_week1.pdf" || names[1] != "timecard_Bob Smith_week2.pdf" {
+        t.Errorf("archive holds %q", names)
+    }
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "encoding/json"
+    "fmt"
+)
+
+/* =============
+   PDF passwords
+   ============= */
+
+// Cards carry personal data and get forwarded around by email, so a PDF
+// can be locked with a password needed to open it. A card sets its own,
+//
+//   "pdf_password": "s3cret"
+//
+// or the tenant locks every PDF with a field of the card:
+//
+//   "pdf_password": "employee_id"     (or "payroll_number")
+//
+// A card's own password wins. LibreOffice encrypts the PDF while it
+// converts it (7.4 or later). The password is not kept with the card's
+// record; a stored card regenerated later is locked by the tenant's
+// setting only. Workbooks are never locked.
+
+// pdfPasswordFields are the card fields a tenant can lock PDFs with
+var pdfPasswordFields = map[string]func(TimecardRequest) string{
+    "employee_id":    func(req TimecardRequest) string { return req.EmployeeID },
+    "payroll_number": func(req TimecardRequest) string { return req.PayrollNumber },
+}
+
+func validatePDFPassword(field string) error {
+    if _, ok := pdfPasswordFields[field]; !ok {
+        return fmt.Errorf("pdf_password %q must be employee_id or payroll_number", field)
+    }
+    return nil
+}
+
+// pdfPassword is the password req's PDF is locked with, or "" for none
+func (req TimecardRequest) pdfPassword(t *Tenant) string {
+    if req.PDFPassword != "" {
+        return req.PDFPassword
+    }
+    if t == nil || t.PDFPassword == "" {
+        return ""
+    }
+    return pdfPasswordFields[t.PDFPassword](req)
+}
+
+// checkPDFPassword rejects cards the tenant would lock with a field they
+// leave blank, rather than send their PDF out unlocked
+func checkPDFPassword(v *validationResult, req TimecardRequest, t *Tenant) {
+    if req.PDFPassword == "" && t != nil && t.PDFPassword != "" && req.pdfPassword(t) == "" {
+        v.errorf("%s is required: this tenant locks PDFs with it", t.PDFPassword)
+    }
+}
+
+// withoutPDFPassword drops a card's own password from the payload kept
+// with its record
+func withoutPDFPassword(payload json.RawMessage) json.RawMessage {
+    return withoutPayloadField(payload, "pdf_password")
+}
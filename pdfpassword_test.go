@@ -0,0 +1,43 @@
+package main
+
+import (
+    "encoding/json"
+    "strings"
+    "testing"
+)
+
+func TestPDFPassword(t *testing.T) {
+    tenant := &Tenant{ID: "pdf-test", PDFPassword: "payroll_number"}
+    req := TimecardRequest{EmployeeName: "Bob Smith", PayrollNumber: "P-104"}
+    if got := req.pdfPassword(tenant); got != "P-104" {
+        t.Errorf("tenant password = %q", got)
+    }
+    req.PDFPassword = "s3cret"
+    if got := req.pdfPassword(tenant); got != "s3cret" {
+        t.Errorf("card password = %q", got)
+    }
+    if got := (TimecardRequest{}).pdfPassword(nil); got != "" {
+        t.Errorf("no password = %q", got)
+    }
+
+    var v validationResult
+    checkPDFPassword(&v, TimecardRequest{EmployeeName: "Bob Smith"}, tenant)
+    if len(v.Errors) != 1 {
+        t.Errorf("errors = %q", v.Errors)
+    }
+    if validatePDFPassword("employee_name") == nil {
+        t.Error("employee_name accepted")
+    }
+
+    if got := (pdfOptions{}).exportFilter(); got != "pdf" {
+        t.Errorf("plain filter = %q", got)
+    }
+    if got := (pdfOptions{Password: `a"b`}).exportFilter(); !strings.HasPrefix(got, "pdf:calc_pdf_Export:{") || !strings.Contains(got, `"value":"a\"b"`) {
+        t.Errorf("filter = %q", got)
+    }
+
+    kept := withoutPDFPassword(json.RawMessage(`{"employee_name":"Bob Smith","pdf_password":"s3cret"}`))
+    if strings.Contains(string(kept), "s3cret") || !strings.Contains(string(kept), "Bob Smith") {
+        t.Errorf("kept payload %s", kept)
+    }
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+    "bytes"
+    "crypto"
+    "crypto/ecdsa"
+    "crypto/rand"
+    "crypto/rsa"
+    "crypto/sha256"
+    "crypto/x509"
+    "encoding/asn1"
+    "encoding/hex"
+    "encoding/pem"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf16"
+
+    "golang.org/x/crypto/pkcs12"
+)
+
+/* ==============
+   PDF signatures
+   ============== */
+
+// With the company certificate configured, every timecard PDF is signed
+// so recipients can check it hasn't been altered since it was generated:
+//
+//   PDF_SIGN_P12       PKCS#12 file with the certificate, its key and chain
+//   PDF_SIGN_PASSWORD  the file's password
+//   PDF_SIGN_REASON    shown with the signature (default "Generated timecard")
+//
+// The signature is an invisible signature field added to LibreOffice's
+// PDF as an incremental update, holding a detached CMS signature
+// (adbe.pkcs7.detached) over SHA-256. A PDF locked with a password
+// (pdfpassword.go) is not signed.
+
+var (
+    errPDFStructure = errors.New("unexpected PDF structure")
+
+    pdfSignerOnce sync.Once
+    thePDFSigner  *pdfSigner
+)
+
+// pdfSignatureSize is the room kept for the CMS signature, in bytes
+const pdfSignatureSize = 8192
+
+// pdfSigner signs PDFs with one certificate
+type pdfSigner struct {
+    key    crypto.Signer
+    cert   *x509.Certificate
+    chain  []*x509.Certificate
+    reason string
+}
+
+// pdfSigning is the configured signer, or nil when PDFs aren't signed
+func pdfSigning() *pdfSigner {
+    pdfSignerOnce.Do(func() {
+        path := os.Getenv("PDF_SIGN_P12")
+        if path == "" {
+            return
+        }
+        data, err := os.ReadFile(path)
+        if err == nil {
+            thePDFSigner, err = newPDFSigner(data, os.Getenv("PDF_SIGN_PASSWORD"), envOr("PDF_SIGN_REASON", "Generated timecard"))
+        }
+        if err != nil {
+            log.Printf("Warning: PDF_SIGN_P12: %v; PDFs are not signed", err)
+            return
+        }
+        log.Printf("Signing PDFs as %s", thePDFSigner.cert.Subject.CommonName)
+    })
+    return thePDFSigner
+}
+
+// newPDFSigner reads a PKCS#12 file: its key, the certificate for that
+// key and any other certificates as the chain
+func newPDFSigner(p12 []byte, password, reason string) (*pdfSigner, error) {
+    blocks, err := pkcs12.ToPEM(p12, password)
+    if err != nil {
+        return nil, err
+    }
+    s := &pdfSigner{reason: reason}
+    var certs []*x509.Certificate
+    for _, b := range blocks {
+        switch b.Type {
+        case "PRIVATE KEY":
+            if s.key, err = parseSigningKey(b); err != nil {
+                return nil, err
+            }
+        case "CERTIFICATE":
+            c, err := x509.ParseCertificate(b.Bytes)
+            if err != nil {
+                return nil, err
+            }
+            certs = append(certs, c)
+        }
+    }
+    if s.key == nil {
+        return nil, errors.New("no private key")
+    }
+    pub, _ := s.key.Public().(interface{ Equal(crypto.PublicKey) bool })
+    for _, c := range certs {
+        if s.cert == nil && pub != nil && pub.Equal(c.PublicKey) {
+            s.cert = c
+        } else {
+            s.chain = append(s.chain, c)
+        }
+    }
+    if s.cert == nil {
+        return nil, errors.New("no certificate for the private key")
+    }
+    return s, nil
+}
+
+func parseSigningKey(b *pem.Block) (crypto.Signer, error) {
+    if k, err := x509.ParsePKCS1PrivateKey(b.Bytes); err == nil {
+        return k, nil
+    }
+    if k, err := x509.ParseECPrivateKey(b.Bytes); err == nil {
+        return k, nil
+    }
+    k, err := x509.ParsePKCS8PrivateKey(b.Bytes)
+    if err != nil {
+        return nil, err
+    }
+    switch k := k.(type) {
+    case *rsa.PrivateKey:
+        return k, nil
+    case *ecdsa.PrivateKey:
+        return k, nil
+    }
+    return nil, fmt.Errorf("%T keys can't sign PDFs", k)
+}
+
+// checkPDFSigning warns about cards whose PDF will go out unsigned
+func checkPDFSigning(v *validationResult, req TimecardRequest, t *Tenant) {
+    if pdfSigning() != nil && req.pdfPassword(t) != "" {
+        v.warnf("the PDF is locked with a password, so it is not signed")
+    }
+}
+
+/* ----- incremental update ----- */
+
+var (
+    pdfTrailerRoot = regexp.MustCompile(`/Root\s+(\d+)\s+(\d+)\s+R`)
+    pdfTrailerSize = regexp.MustCompile(`/Size\s+(\d+)`)
+    pdfTrailerInfo = regexp.MustCompile(`/Info\s+\d+\s+\d+\s+R`)
+    pdfTrailerID   = regexp.MustCompile(`/ID\s*\[[^\]]*\]`)
+    pdfTrailerPrev = regexp.MustCompile(`/Prev\s+(\d+)`)
+)
+
+// sign appends an invisible signature over pdf, as signed at at
+func (s *pdfSigner) sign(pdf []byte, at time.Time) ([]byte, error) {
+    xref, err := lastXref(pdf)
+    if err != nil {
+        return nil, err
+    }
+    trailer, err := xrefTrailer(pdf, xref)
+    if err != nil {
+        return nil, err
+    }
+    root := pdfTrailerRoot.FindSubmatch(trailer)
+    size := pdfTrailerSize.FindSubmatch(trailer)
+    if root == nil || size == nil {
+        return nil, fmt.Errorf("%w: trailer without /Root or /Size", errPDFStructure)
+    }
+    rootNum, _ := strconv.Atoi(string(root[1]))
+    next, _ := strconv.Atoi(string(size[1]))
+    catalog, err := pdfObjectDict(pdf, xref, rootNum)
+    if err != nil {
+        return nil, err
+    }
+    if bytes.Contains(catalog, []byte("/AcroForm")) {
+        return nil, fmt.Errorf("%w: the PDF already has a form", errPDFStructure)
+    }
+    sigNum, fieldNum := next, next+1
+
+    var b bytes.Buffer
+    b.Write(pdf)
+    if !bytes.HasSuffix(pdf, []byte("\n")) {
+        b.WriteByte('\n')
+    }
+    offsets := map[int]int{}
+
+    offsets[sigNum] = b.Len()
+    fmt.Fprintf(&b, "%d 0 obj\n<</Type/Sig/Filter/Adobe.PPKLite/SubFilter/adbe.pkcs7.detached", sigNum)
+    b.WriteString("/ByteRange[")
+    rangeAt := b.Len()
+    b.WriteString(strings.Repeat(" ", 4*11) + "]")
+    b.WriteString("/Contents<")
+    contentsAt := b.Len() - 1
+    b.WriteString(strings.Repeat("0", 2*pdfSignatureSize))
+    b.WriteString(">")
+    contentsEnd := b.Len()
+    fmt.Fprintf(&b, "/M(D:%s)/Name%s/Reason%s>>\nendobj\n",
+        at.UTC().Format("20060102150405Z"), pdfText(s.cert.Subject.CommonName), pdfText(s.reason))
+
+    offsets[fieldNum] = b.Len()
+    fmt.Fprintf(&b, "%d 0 obj\n<</FT/Sig/T(Signature1)/V %d 0 R/Type/Annot/Subtype/Widget/Rect[0 0 0 0]/F 132>>\nendobj\n",
+        fieldNum, sigNum)
+
+    offsets[rootNum] = b.Len()
+    fmt.Fprintf(&b, "%d %s obj\n%s/AcroForm<</Fields[%d 0 R]/SigFlags 3>>>>\nendobj\n",
+        rootNum, root[2], bytes.TrimSuffix(catalog, []byte(">>")), fieldNum)
+
+    xrefAt := b.Len()
+    b.WriteString("xref\n")
+    nums := make([]int, 0, len(offsets))
+    for n := range offsets {
+        nums = append(nums, n)
+    }
+    sort.Ints(nums)
+    for _, n := range nums {
+        gen := 0
+        if n == rootNum {
+            gen, _ = strconv.Atoi(string(root[2]))
+        }
+        fmt.Fprintf(&b, "%d 1\n%010d %05d n \n", n, offsets[n], gen)
+    }
+    fmt.Fprintf(&b, "trailer\n<</Size %d/Root %d %s R", fieldNum+1, rootNum, root[2])
+    if info := pdfTrailerInfo.Find(trailer); info != nil {
+        b.Write(info)
+    }
+    if id := pdfTrailerID.Find(trailer); id != nil {
+        b.Write(id)
+    }
+    fmt.Fprintf(&b, "/Prev %d>>\nstartxref\n%d\n%%%%EOF\n", xref, xrefAt)
+
+    out := b.Bytes()
+    byteRange := fmt.Sprintf("0 %d %d %d", contentsAt, contentsEnd, len(out)-contentsEnd)
+    copy(out[rangeAt:], byteRange)
+
+    h := sha256.New()
+    h.Write(out[:contentsAt])
+    h.Write(out[contentsEnd:])
+    sig, err := s.cms(h.Sum(nil), at)
+    if err != nil {
+        return nil, err
+    }
+    if len(sig) > pdfSignatureSize {
+        return nil, fmt.Errorf("signature of %d bytes doesn't fit in %d", len(sig), pdfSignatureSize)
+    }
+    hex.Encode(out[contentsAt+1:], sig)
+    return out, nil
+}
+
+// lastXref is the offset of the PDF's last cross-reference section
+func lastXref(pdf []byte) (int, error) {
+    i := bytes.LastIndex(pdf, []byte("startxref"))
+    if i < 0 {
+        return 0, fmt.Errorf("%w: no startxref", errPDFStructure)
+    }
+    fields := bytes.Fields(pdf[i+len("startxref"):])
+    if len(fields) == 0 {
+        return 0, fmt.Errorf("%w: no startxref", errPDFStructure)
+    }
+    n, err := strconv.Atoi(string(fields[0]))
+    if err != nil || n < 0 || n >= len(pdf) {
+        return 0, fmt.Errorf("%w: bad startxref", errPDFStructure)
+    }
+    return n, nil
+}
+
+// xrefTrailer is the trailer dictionary of the cross-reference table at
+// offset; cross-reference streams are not supported
+func xrefTrailer(pdf []byte, offset int) ([]byte, error) {
+    if !bytes.HasPrefix(pdf[offset:], []byte("xref")) {
+        return nil, fmt.Errorf("%w: cross-reference streams are not supported", errPDFStructure)
+    }
+    i := bytes.Index(pdf[offset:], []byte("trailer"))
+    if i < 0 {
+        return nil, fmt.Errorf("%w: no trailer", errPDFStructure)
+    }
+    return pdfDict(pdf[offset+i+len("trailer"):])
+}
+
+// pdfObjectDict finds object num through the cross-reference sections
+// from offset back, and returns its dictionary
+func pdfObjectDict(pdf []byte, offset, num int) ([]byte, error) {
+    for seen := 0; seen < 100; seen++ {
+        if at, ok := xrefEntry(pdf[offset:], num); ok {
+            if at < 0 || at >= len(pdf) {
+                break
+            }
+            i := bytes.Index(pdf[at:], []byte("obj"))
+            if i < 0 {
+                break
+            }
+            return pdfDict(pdf[at+i+len("obj"):])
+        }
+        trailer, err := xrefTrailer(pdf, offset)
+        if err != nil {
+            return nil, err
+        }
+        prev := pdfTrailerPrev.FindSubmatch(trailer)
+        if prev == nil {
+            break
+        }
+        offset, _ = strconv.Atoi(string(prev[1]))
+        if offset < 0 || offset >= len(pdf) {
+            break
+        }
+    }
+    return nil, fmt.Errorf("%w: object %d not found", errPDFStructure, num)
+}
+
+// xrefEntry looks num up in the cross-reference table at the start of
+// section, returning its offset
+func xrefEntry(section []byte, num int) (int, bool) {
+    end := bytes.Index(section, []byte("trailer"))
+    if !bytes.HasPrefix(section, []byte("xref")) || end < 0 {
+        return 0, false
+    }
+    lines := strings.Split(string(section[:end]), "\n")
+    for i := 1; i < len(lines); i++ {
+        head := strings.Fields(lines[i])
+        if len(head) != 2 {
+            continue
+        }
+        first, err1 := strconv.Atoi(head[0])
+        count, err2 := strconv.Atoi(head[1])
+        if err1 != nil || err2 != nil {
+            continue
+        }
+        if num >= first && num < first+count && i+1+num-first < len(lines) {
+            entry := strings.Fields(lines[i+1+num-first])
+            if len(entry) == 3 && entry[2] == "n" {
+                at, err := strconv.Atoi(entry[0])
+                return at, err == nil
+            }
+            return 0, false
+        }
+        i += count
+    }
+    return 0, false
+}
+
+// pdfDict returns the dictionary that starts data (after white space),
+// "<<" to its matching ">>"
+func pdfDict(data []byte) ([]byte, error) {
+    start := bytes.Index(data, []byte("<<"))
+    if start < 0 || len(bytes.TrimSpace(data[:start])) > 0 {
+        return nil, fmt.Errorf("%w: no dictionary", errPDFStructure)
+    }
+    depth := 0
+    for i := start; i < len(data); i++ {
+        switch {
+        case data[i] == '(':
+            // skip a literal string, which may hold unbalanced brackets
+            for nest := 0; i < len(data); i++ {
+                if data[i] == '\\' {
+                    i++
+                } else if data[i] == '(' {
+                    nest++
+                } else if data[i] == ')' {
+                    if nest--; nest == 0 {
+                        break
+                    }
+                }
+            }
+        case bytes.HasPrefix(data[i:], []byte("<<")):
+            depth++
+            i++
+        case bytes.HasPrefix(data[i:], []byte(">>")):
+            depth--
+            i++
+            if depth == 0 {
+                return data[start : i+1], nil
+            }
+        }
+    }
+    return nil, fmt.Errorf("%w: unterminated dictionary", errPDFStructure)
+}
+
+// pdfText is s as a PDF text string (UTF-16BE, hex)
+func pdfText(s string) string {
+    var b strings.Builder
+    b.WriteString("<FEFF")
+    for _, u := range utf16.Encode([]rune(s)) {
+        fmt.Fprintf(&b, "%04X", u)
+    }
+    b.WriteString(">")
+    return b.String()
+}
+
+/* ----- CMS ----- */
+
+var (
+    oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+    oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+    oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+    oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+    oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+    oidSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+    oidRSA           = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+    oidECDSASHA256   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+)
+
+// cms is a detached CMS SignedData over a SHA-256 digest
+func (s *pdfSigner) cms(digest []byte, at time.Time) ([]byte, error) {
+    sigAlg := derSeq(derMust(oidRSA), asn1.NullBytes)
+    if _, ok := s.key.(*ecdsa.PrivateKey); ok {
+        sigAlg = derSeq(derMust(oidECDSASHA256))
+    }
+    sha256Alg := derSeq(derMust(oidSHA256), asn1.NullBytes)
+
+    attrs := derSet(
+        derSeq(derMust(oidContentType), derSet(derMust(oidData))),
+        derSeq(derMust(oidSigningTime), derSet(derMust(at.UTC()))),
+        derSeq(derMust(oidMessageDigest), derSet(derMust(digest))),
+    )
+    sum := sha256.Sum256(attrs)
+    sig, err := s.key.Sign(rand.Reader, sum[:], crypto.SHA256)
+    if err != nil {
+        return nil, err
+    }
+    signedAttrs := append([]byte(nil), attrs...)
+    signedAttrs[0] = 0xA0 // [0] IMPLICIT in the SignerInfo
+
+    signerInfo := derSeq(
+        derMust(1),
+        derSeq(s.cert.RawIssuer, derMust(s.cert.SerialNumber)),
+        sha256Alg,
+        signedAttrs,
+        sigAlg,
+        derMust(sig),
+    )
+    certs := [][]byte{s.cert.Raw}
+    for _, c := range s.chain {
+        certs = append(certs, c.Raw)
+    }
+    certSet := derSet(certs...)
+    certSet[0] = 0xA0 // [0] IMPLICIT
+
+    signedData := derSeq(
+        derMust(1),
+        derSet(sha256Alg),
+        derSeq(derMust(oidData)),
+        certSet,
+        derSet(signerInfo),
+    )
+    return derSeq(derMust(oidSignedData), derExplicit(0, signedData)), nil
+}
+
+func derMust(v interface{}) []byte {
+    b, err := asn1.Marshal(v)
+    if err != nil {
+        panic(err)
+    }
+    return b
+}
+
+func derSeq(parts ...[]byte) []byte {
+    return derMust(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: bytes.Join(parts, nil)})
+}
+
+// derSet is a DER SET OF, its members sorted
+func derSet(parts ...[]byte) []byte {
+    sorted := append([][]byte(nil), parts...)
+    sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i], sorted[j]) < 0 })
+    return derMust(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(sorted, nil)})
+}
+
+func derExplicit(tag int, content []byte) []byte {
+    return derMust(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: content})
+}
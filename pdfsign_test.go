@@ -0,0 +1,149 @@
+package main
+
+import (
+    "bytes"
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/asn1"
+    "encoding/hex"
+    "fmt"
+    "math/big"
+    "regexp"
+    "strconv"
+    "testing"
+    "time"
+)
+
+// testPDF is a one-page PDF with a classic cross-reference table, as
+// LibreOffice writes them
+func testPDF() []byte {
+    objs := []string{
+        "<</Type/Catalog/Pages 2 0 R/Lang(en-US)>>",
+        "<</Type/Pages/Kids[3 0 R]/Count 1>>",
+        "<</Type/Page/Parent 2 0 R/MediaBox[0 0 612 792]>>",
+    }
+    var b bytes.Buffer
+    b.WriteString("%PDF-1.7\n")
+    var offsets []int
+    for i, o := range objs {
+        offsets = append(offsets, b.Len())
+        fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, o)
+    }
+    xref := b.Len()
+    fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objs)+1)
+    for _, at := range offsets {
+        fmt.Fprintf(&b, "%010d 00000 n \n", at)
+    }
+    fmt.Fprintf(&b, "trailer\n<</Size %d/Root 1 0 R/ID[<AB><AB>]>>\nstartxref\n%d\n%%%%EOF\n", len(objs)+1, xref)
+    return b.Bytes()
+}
+
+func TestSignPDF(t *testing.T) {
+    key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    tmpl := &x509.Certificate{
+        SerialNumber: big.NewInt(42),
+        Subject:      pkix.Name{CommonName: "Acme Payroll"},
+        NotBefore:    time.Now().Add(-time.Hour),
+        NotAfter:     time.Now().Add(time.Hour),
+    }
+    der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, _ := x509.ParseCertificate(der)
+    s := &pdfSigner{key: key, cert: cert, reason: "Generated timecard"}
+
+    pdf := testPDF()
+    signed, err := s.sign(pdf, time.Date(2025, 1, 20, 9, 0, 0, 0, time.UTC))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.HasPrefix(signed, pdf) {
+        t.Fatal("the original PDF was changed")
+    }
+
+    // the new catalog has the signature field
+    xref, err := lastXref(signed)
+    if err != nil {
+        t.Fatal(err)
+    }
+    catalog, err := pdfObjectDict(signed, xref, 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := "<</Type/Catalog/Pages 2 0 R/Lang(en-US)/AcroForm<</Fields[5 0 R]/SigFlags 3>>>>"; string(catalog) != want {
+        t.Errorf("catalog %s", catalog)
+    }
+    if page, err := pdfObjectDict(signed, xref, 3); err != nil || !bytes.Contains(page, []byte("/Type/Page")) {
+        t.Errorf("page through /Prev: %s %v", page, err)
+    }
+
+    // the byte range covers all but the signature, which signs its digest
+    m := regexp.MustCompile(`/ByteRange\[0 (\d+) (\d+) (\d+)\s*\]`).FindSubmatch(signed)
+    if m == nil {
+        t.Fatal("no byte range")
+    }
+    n := func(i int) int { v, _ := strconv.Atoi(string(m[i])); return v }
+    if n(2)+n(3) != len(signed) || signed[n(1)] != '<' || signed[n(2)-1] != '>' {
+        t.Fatalf("byte range %s %s %s of %d bytes", m[1], m[2], m[3], len(signed))
+    }
+    digest := sha256.New()
+    digest.Write(signed[:n(1)])
+    digest.Write(signed[n(2):])
+    sig, err := hex.DecodeString(string(signed[n(1)+1 : n(2)-1])) // zero padded
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var ci struct {
+        Type    asn1.ObjectIdentifier
+        Content asn1.RawValue `asn1:"explicit,tag:0"`
+    }
+    if _, err := asn1.Unmarshal(sig, &ci); err != nil || !ci.Type.Equal(oidSignedData) {
+        t.Fatalf("content info %v %v", ci.Type, err)
+    }
+    var sd struct {
+        Version     int
+        Digests     asn1.RawValue
+        Encap       asn1.RawValue
+        Certs       asn1.RawValue `asn1:"tag:0"`
+        SignerInfos asn1.RawValue
+    }
+    if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+        t.Fatal(err)
+    }
+    var si struct {
+        Version   int
+        SID       asn1.RawValue
+        DigestAlg asn1.RawValue
+        Attrs     asn1.RawValue `asn1:"tag:0"`
+        SigAlg    asn1.RawValue
+        Sig       []byte
+    }
+    if _, err := asn1.Unmarshal(sd.SignerInfos.Bytes, &si); err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Contains(si.Attrs.Bytes, digest.Sum(nil)) {
+        t.Error("signed attributes without the PDF's digest")
+    }
+    attrs := append([]byte(nil), si.Attrs.FullBytes...)
+    attrs[0] = 0x31 // signed as a SET
+    if err := cert.CheckSignature(x509.ECDSAWithSHA256, attrs, si.Sig); err != nil {
+        t.Errorf("signature: %v", err)
+    }
+}
+
+func TestSignPDFRejects(t *testing.T) {
+    s := &pdfSigner{}
+    if _, err := s.sign([]byte("%PDF-1.7\nno xref"), time.Now()); err == nil {
+        t.Error("signed a PDF without startxref")
+    }
+    stream := []byte("%PDF-1.7\n1 0 obj\n<</Type/XRef>>\nendobj\nstartxref\n9\n%%EOF\n")
+    if _, err := s.sign(stream, time.Now()); err == nil {
+        t.Error("signed a PDF with a cross-reference stream")
+    }
+}
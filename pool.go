@@ -0,0 +1,235 @@
+package main
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "net/http"
+    "runtime"
+    "sync"
+    "time"
+)
+
+/* ===================
+   Generation limiting
+   =================== */
+
+// Each soffice conversion is a full LibreOffice process (~200 MB), and each
+// excelize workbook holds the whole file in memory, so concurrent work is
+// capped per stage. Callers over the cap wait in a bounded queue; once the
+// queue is full, or they have waited QUEUE_WAIT, they get errBusy (a 429).
+//
+// Work runs in one of two lanes. Interactive work (a single card someone
+// is waiting for in the app) always gets the next free slot; batch work
+// (archives, reports, scheduled sends) only runs when no interactive
+// request is queued, waits as long as its context allows, and has its own
+// larger queue so a big export can't fill the interactive one.
+//
+//   EXCEL_WORKERS     concurrent workbook builds (default NumCPU)
+//   PDF_WORKERS       concurrent LibreOffice conversions (default 2)
+//   EMAIL_WORKERS     concurrent SMTP sends (default 4)
+//   QUEUE_MAX         interactive waiters allowed per stage (default 32)
+//   BATCH_QUEUE_MAX   batch waiters allowed per stage (default 1000)
+//   QUEUE_WAIT        longest an interactive waiter is held (default 30s)
+
+var errBusy = errors.New("server busy, try again shortly")
+
+type priority int
+
+const (
+    priorityInteractive priority = iota
+    priorityBatch
+    numPriorities
+)
+
+func (p priority) String() string {
+    if p == priorityBatch {
+        return "batch"
+    }
+    return "interactive"
+}
+
+type priorityKey struct{}
+
+// withPriority marks all generation work under ctx as belonging to lane p
+func withPriority(ctx context.Context, p priority) context.Context {
+    return context.WithValue(ctx, priorityKey{}, p)
+}
+
+// priorityFrom returns the lane of ctx; unmarked work is interactive
+func priorityFrom(ctx context.Context) priority {
+    if p, ok := ctx.Value(priorityKey{}).(priority); ok {
+        return p
+    }
+    return priorityInteractive
+}
+
+type limiter struct {
+    name     string
+    workers  int
+    maxQueue [numPriorities]int
+    maxWait  time.Duration // interactive only
+
+    mu     sync.Mutex
+    busy   int
+    queues [numPriorities][]chan struct{}
+}
+
+func newLimiter(name string, workers, maxQueue, maxBatchQueue int, maxWait time.Duration) *limiter {
+    if workers < 1 {
+        workers = 1
+    }
+    return &limiter{
+        name:     name,
+        workers:  workers,
+        maxQueue: [numPriorities]int{maxQueue, maxBatchQueue},
+        maxWait:  maxWait,
+    }
+}
+
+// acquire takes a slot, queueing in ctx's lane if none is free. The
+// returned release must be called exactly once.
+func (l *limiter) acquire(ctx context.Context) (release func(), err error) {
+    p := priorityFrom(ctx)
+
+    l.mu.Lock()
+    if l.busy < l.workers {
+        l.busy++
+        l.mu.Unlock()
+        return l.release, nil
+    }
+    if len(l.queues[p]) >= l.maxQueue[p] {
+        l.mu.Unlock()
+        return nil, fmt.Errorf("%s %s queue full: %w", l.name, p, errBusy)
+    }
+    granted := make(chan struct{})
+    l.queues[p] = append(l.queues[p], granted)
+    l.mu.Unlock()
+
+    var timeout <-chan time.Time
+    if p == priorityInteractive {
+        timer := time.NewTimer(l.maxWait)
+        defer timer.Stop()
+        timeout = timer.C
+    }
+    select {
+    case <-granted:
+        return l.release, nil
+    case <-timeout:
+        err = fmt.Errorf("%s queue wait exceeded %s: %w", l.name, l.maxWait, errBusy)
+    case <-ctx.Done():
+        err = ctx.Err()
+    }
+
+    if !l.dequeue(p, granted) {
+        // The slot was handed over while we were giving up; pass it on.
+        l.release()
+    }
+    return nil, err
+}
+
+// dequeue removes a waiter that gave up; false means it was already granted
+func (l *limiter) dequeue(p priority, granted chan struct{}) bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    for i, ch := range l.queues[p] {
+        if ch == granted {
+            l.queues[p] = append(l.queues[p][:i], l.queues[p][i+1:]...)
+            return true
+        }
+    }
+    return false
+}
+
+// release hands the slot to the next waiter, interactive lane first
+func (l *limiter) release() {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    for p := range l.queues {
+        if len(l.queues[p]) > 0 {
+            next := l.queues[p][0]
+            l.queues[p] = l.queues[p][1:]
+            close(next)
+            return
+        }
+    }
+    l.busy--
+}
+
+// saturated reports whether every slot is taken and the interactive queue is full
+func (l *limiter) saturated() bool {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return l.busy == l.workers && len(l.queues[priorityInteractive]) >= l.maxQueue[priorityInteractive]
+}
+
+func (l *limiter) stats() map[string]int {
+    l.mu.Lock()
+    defer l.mu.Unlock()
+    return map[string]int{
+        "workers":             l.workers,
+        "busy":                l.busy,
+        "waiting_interactive": len(l.queues[priorityInteractive]),
+        "waiting_batch":       len(l.queues[priorityBatch]),
+    }
+}
+
+type generationPools struct {
+    excel, pdf, email *limiter
+}
+
+var (
+    poolsOnce sync.Once
+    thePools  *generationPools
+)
+
+func pools() *generationPools {
+    poolsOnce.Do(func() {
+        maxQueue := envInt("QUEUE_MAX", 32)
+        maxBatch := envInt("BATCH_QUEUE_MAX", 1000)
+        maxWait := envDuration("QUEUE_WAIT", 30*time.Second)
+        thePools = &generationPools{
+            excel: newLimiter("excel", envInt("EXCEL_WORKERS", runtime.NumCPU()), maxQueue, maxBatch, maxWait),
+            pdf:   newLimiter("pdf", envInt("PDF_WORKERS", 2), maxQueue, maxBatch, maxWait),
+            email: newLimiter("email", envInt("EMAIL_WORKERS", 4), maxQueue, maxBatch, maxWait),
+        }
+        registerReadinessCheck("generation_queue", func() error {
+            for _, l := range thePools.all() {
+                if l.saturated() {
+                    return fmt.Errorf("%s queue saturated", l.name)
+                }
+            }
+            return nil
+        })
+    })
+    return thePools
+}
+
+func (g *generationPools) all() []*limiter {
+    return []*limiter{g.excel, g.pdf, g.email}
+}
+
+// statusClientClosed is nginx's code for a client that hung up before the
+// response; it keeps abandoned work out of the 5xx error reports.
+const statusClientClosed = 499
+
+// generationFailed reports a failed build or conversion, turning errBusy
+// into 429 and an open breaker into 503 (both with a Retry-After hint) and
+// everything else into a 500.
+func generationFailed(w http.ResponseWriter, r *http.Request, msg string, err error) {
+    if errors.Is(err, context.Canceled) && r.Context().Err() != nil {
+        httpError(w, r, fmt.Sprintf("%s: %v", msg, err), statusClientClosed)
+        return
+    }
+    if errors.Is(err, errCircuitOpen) {
+        w.Header().Set("Retry-After", fmt.Sprint(int(sofficeBreaker.retryAfter().Seconds())+1))
+        httpError(w, r, fmt.Sprintf("%s: %v", msg, err), http.StatusServiceUnavailable)
+        return
+    }
+    if errors.Is(err, errBusy) {
+        w.Header().Set("Retry-After", "5")
+        httpError(w, r, fmt.Sprintf("%s: %v", msg, err), http.StatusTooManyRequests)
+        return
+    }
+    httpError(w, r, fmt.Sprintf("%s: %v", msg, err), http.StatusInternalServerError)
+}
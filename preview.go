@@ -0,0 +1,208 @@
+package main
+
+import (
+    "context"
+    "encoding/base64"
+    "fmt"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strconv"
+    "time"
+)
+
+/* ============
+   PNG previews
+   ============ */
+
+// POST /api/preview takes a generation request and answers with images of
+// the card's PDF pages, so the app can show it inline without a PDF viewer:
+//
+//   POST /api/preview?dpi=96&page=1     image/png of one page (default the first)
+//   POST /api/preview?dpi=96&page=all   {"dpi": 96, "pages": ["<base64 PNG>", ...]}
+//
+// dpi is 36 to 300 (default 96). X-Preview-Pages tells how many pages the
+// card has. The pages are the PDF's, pdf_layout and page_setup included,
+// rasterised by poppler's pdftoppm (PDFTOPPM, default "pdftoppm" on the
+// PATH), except that a preview's PDF is never password-locked or signed.
+// A preview is not kept as a timecard record.
+
+const (
+    defaultPreviewDPI = 96
+    minPreviewDPI     = 36
+    maxPreviewDPI     = 300
+)
+
+// previewPages is the JSON answer for page=all
+type previewPages struct {
+    DPI   int      `json:"dpi"`
+    Pages []string `json:"pages"` // base64 PNGs
+}
+
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    lg := loggerFrom(r.Context())
+
+    q := r.URL.Query()
+    dpi := defaultPreviewDPI
+    if v := q.Get("dpi"); v != "" {
+        n, err := strconv.Atoi(v)
+        if err != nil || n < minPreviewDPI || n > maxPreviewDPI {
+            httpError(w, r, fmt.Sprintf("invalid request: dpi must be %d to %d", minPreviewDPI, maxPreviewDPI), http.StatusBadRequest)
+            return
+        }
+        dpi = n
+    }
+    page := 1 // 0 is all of them
+    switch v := q.Get("page"); v {
+    case "":
+    case "all":
+        page = 0
+    default:
+        n, err := strconv.Atoi(v)
+        if err != nil || n < 1 {
+            httpError(w, r, "invalid request: page must be a page number or all", http.StatusBadRequest)
+            return
+        }
+        page = n
+    }
+
+    var req TimecardRequest
+    if _, err := readPayload(r, &req); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    applyTimecardDefaults(&req, tenantFor(r))
+    if _, ok := checkTimecard(w, r, req); !ok {
+        return
+    }
+
+    gc := newGenContext(r.Context(), req, tenantFor(r), lg)
+    excelData, err := renderExcel(gc)
+    if err != nil {
+        generationFailed(w, r, "error generating Excel", err)
+        return
+    }
+    pdfData, err := previewPDF(gc, excelData)
+    if err != nil {
+        generationFailed(w, r, "error converting to PDF", err)
+        return
+    }
+    pages := pdfPageCount(pdfData)
+    if page > pages {
+        httpError(w, r, fmt.Sprintf("invalid request: the card has %d page(s)", pages), http.StatusBadRequest)
+        return
+    }
+    pngs, err := rasterizePDF(r.Context(), pdfData, dpi, page)
+    if err != nil {
+        lg.Printf("preview error: %v", err)
+        generationFailed(w, r, "error rendering preview", err)
+        return
+    }
+
+    w.Header().Set("X-Preview-Pages", strconv.Itoa(pages))
+    if page == 0 {
+        out := previewPages{DPI: dpi, Pages: []string{}}
+        for _, png := range pngs {
+            out.Pages = append(out.Pages, base64.StdEncoding.EncodeToString(png))
+        }
+        writeJSON(w, http.StatusOK, out)
+        return
+    }
+    w.Header().Set("Content-Type", "image/png")
+    w.WriteHeader(http.StatusOK)
+    _, _ = w.Write(pngs[0])
+    lg.Printf("OK: preview page %d of %d at %d dpi, bytes=%d", page, pages, dpi, len(pngs[0]))
+}
+
+// previewOptions are gc's PDF options for a preview: never locked, since
+// pdftoppm has to open it, nor signed, since the images carry no signature
+func previewOptions(gc *genContext) pdfOptions {
+    opts := gc.req.pdfOptions(gc.tenant)
+    opts.Password, opts.Sign = "", false
+    return opts
+}
+
+// previewPDF converts gc's workbook (excelData) as renderPDF does, with
+// previewOptions, through the cache
+func previewPDF(gc *genContext, excelData []byte) ([]byte, error) {
+    key := renderKey(gc, "preview-pdf")
+    if data, ok := renders().get(key); ok {
+        gc.lg.Printf("Reusing cached preview PDF (%d bytes)", len(data))
+        return data, nil
+    }
+    excelData, err := printedExcel(gc, excelData)
+    if err != nil {
+        return nil, err
+    }
+    data, err := generatePDFFromExcel(gc.ctx, excelData, fmt.Sprintf("timecard_%s.xlsx", gc.req.fileEmployee()), previewOptions(gc), gc.lg)
+    if err != nil {
+        return nil, err
+    }
+    renders().put(key, data)
+    return data, nil
+}
+
+// pdfPageRef matches a page object's type; /Type/Pages is the page tree
+var pdfPageRef = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// pdfPageCount counts the page objects of a PDF as LibreOffice writes it
+// (no object streams)
+func pdfPageCount(pdf []byte) int {
+    return len(pdfPageRef.FindAllIndex(pdf, -1))
+}
+
+// rasterizePDF renders page (0 for all pages) of pdfData as PNGs at dpi
+func rasterizePDF(ctx context.Context, pdfData []byte, dpi, page int) ([][]byte, error) {
+    release, err := pools().pdf.acquire(ctx)
+    if err != nil {
+        return nil, err
+    }
+    defer release()
+
+    dir, err := os.MkdirTemp("", "preview-")
+    if err != nil {
+        return nil, fmt.Errorf("create temp dir: %w", err)
+    }
+    defer os.RemoveAll(dir)
+    in := filepath.Join(dir, "card.pdf")
+    if err := os.WriteFile(in, pdfData, 0o600); err != nil {
+        return nil, fmt.Errorf("write pdf: %w", err)
+    }
+
+    execCtx, cancel := context.WithTimeout(ctx, envDuration("PREVIEW_TIMEOUT", 30*time.Second))
+    defer cancel()
+    args := []string{"-png", "-r", strconv.Itoa(dpi)}
+    if page > 0 {
+        args = append(args, "-f", strconv.Itoa(page), "-l", strconv.Itoa(page))
+    }
+    args = append(args, in, filepath.Join(dir, "page"))
+    if out, err := exec.CommandContext(execCtx, envOr("PDFTOPPM", "pdftoppm"), args...).CombinedOutput(); err != nil {
+        if ctx.Err() != nil {
+            return nil, ctx.Err()
+        }
+        return nil, fmt.Errorf("pdftoppm: %v: %s", err, out)
+    }
+
+    // page-1.png, page-2.png, ... (zero padded to the same width)
+    names, err := filepath.Glob(filepath.Join(dir, "page-*.png"))
+    if err != nil || len(names) == 0 {
+        return nil, fmt.Errorf("pdftoppm rendered no pages")
+    }
+    sort.Strings(names)
+    var pngs [][]byte
+    for _, name := range names {
+        data, err := os.ReadFile(name)
+        if err != nil {
+            return nil, fmt.Errorf("read preview: %w", err)
+        }
+        pngs = append(pngs, data)
+    }
+    return pngs, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "path/filepath"
+    "strings"
+    "testing"
+)
+
+func TestPDFPageCount(t *testing.T) {
+    if n := pdfPageCount(testPDF()); n != 1 {
+        t.Errorf("one-page PDF counted %d pages", n)
+    }
+    two := []byte("<</Type/Pages/Kids[3 0 R 4 0 R]/Count 2>>\n<</Type /Page/Parent 2 0 R>>\n<</Type/Page/Parent 2 0 R>>")
+    if n := pdfPageCount(two); n != 2 {
+        t.Errorf("two-page PDF counted %d pages", n)
+    }
+}
+
+// fakePreviewTools puts stand-ins for soffice and pdftoppm first on the
+// PATH. The PDF soffice writes is /Encrypt-ed when asked for a password,
+// and pdftoppm, given no password, can't open such a one.
+func fakePreviewTools(t *testing.T) {
+    t.Helper()
+    dir := t.TempDir()
+    scripts := map[string]string{
+        "soffice": `#!/bin/sh
+out=""; enc=""
+while [ $# -gt 0 ]; do
+    case "$1" in
+        --outdir) out="$2"; shift ;;
+        *EncryptFile*) enc="/Encrypt 9 0 R" ;;
+    esac
+    shift
+done
+printf '%%PDF-1.7\n<</Type/Page>>\ntrailer\n<<%s>>\n' "$enc" > "$out/card.pdf"
+`,
+        "pdftoppm": `#!/bin/sh
+for last; do :; done
+for a; do case "$a" in *.pdf) in="$a" ;; esac; done
+if grep -q Encrypt "$in"; then echo "Command Line Error: Incorrect password" >&2; exit 3; fi
+printf 'PNG' > "$last-1.png"
+`,
+    }
+    for name, script := range scripts {
+        if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o755); err != nil {
+            t.Fatal(err)
+        }
+    }
+    t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestPreviewLockedCard(t *testing.T) {
+    fakePreviewTools(t)
+    withTenants(t, `{"locked": {"pdf_password": "payroll_number"}}`)
+    body := `{"employee_name": "Bob Smith", "payroll_number": "P-7", "pay_period_num": 1, "year": 2025,
+        "weeks": [{"week_start_date": "2025-01-05T00:00:00Z", "entries": [{"date": "2025-01-06T00:00:00Z", "job_code": "29699", "hours": 8}]}]}`
+    r := httptest.NewRequest(http.MethodPost, "/api/preview", strings.NewReader(body))
+    r.Header.Set("X-Tenant-ID", "locked")
+    w := httptest.NewRecorder()
+    previewHandler(w, r)
+    if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || w.Body.String() != "PNG" {
+        t.Fatalf("preview of a locked card: %d %s", w.Code, w.Body)
+    }
+    if got := w.Header().Get("X-Preview-Pages"); got != "1" {
+        t.Errorf("X-Preview-Pages %q", got)
+    }
+
+    gc := newGenContext(context.Background(), TimecardRequest{PayrollNumber: "P-7"}, lookupTenant("locked"), loggerFrom(context.Background()))
+    if opts := previewOptions(gc); opts.Password != "" || opts.Sign {
+        t.Errorf("preview options %+v", opts)
+    }
+}
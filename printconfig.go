@@ -0,0 +1,265 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "encoding/xml"
+    "fmt"
+    "io"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==============
+   Print settings
+   ============== */
+
+// A template's cellmap.json can say how its week sheets print and scroll,
+// on top of weekPrintLayout's landscape, one-page-wide setup (pagesetup.go):
+//
+//   "print": {
+//     "title_rows": "1:4",            repeated at the top of every printed page
+//     "freeze_rows": 4,               kept in view when scrolling in Excel
+//     "freeze_columns": 2,
+//     "margins": {"top": 0.75, "bottom": 0.75},   inches; unset ones stay
+//     "footer": "&LTimecard&CPage &P of &N"
+//   }
+//
+// The footer is Excel's header/footer codes for the left and centre
+// sections, on every sheet; the right section stays the artifact
+// reference (artifactref.go). A card's page_setup still decides paper,
+// orientation and fit.
+
+// PrintConfig is a template's print settings
+type PrintConfig struct {
+    TitleRows     string        `json:"title_rows,omitempty"`
+    FreezeRows    int           `json:"freeze_rows,omitempty"`
+    FreezeColumns int           `json:"freeze_columns,omitempty"`
+    Margins       *PrintMargins `json:"margins,omitempty"`
+    Footer        string        `json:"footer,omitempty"`
+}
+
+// PrintMargins are page margins in inches
+type PrintMargins struct {
+    Left   *float64 `json:"left,omitempty"`
+    Right  *float64 `json:"right,omitempty"`
+    Top    *float64 `json:"top,omitempty"`
+    Bottom *float64 `json:"bottom,omitempty"`
+    Header *float64 `json:"header,omitempty"`
+    Footer *float64 `json:"footer,omitempty"`
+}
+
+const (
+    maxPrintMargin = 5 // inches
+    // maxPrintFooter leaves room in Excel's 255 characters for the reference
+    maxPrintFooter = 180
+)
+
+var titleRowsPattern = regexp.MustCompile(`^(\d+):(\d+)$`)
+
+// titleRows parses "first:last"
+func (p *PrintConfig) titleRows() (first, last int, ok bool) {
+    m := titleRowsPattern.FindStringSubmatch(p.TitleRows)
+    if m == nil {
+        return 0, 0, false
+    }
+    first, _ = strconv.Atoi(m[1])
+    last, _ = strconv.Atoi(m[2])
+    return first, last, first >= 1 && first <= last && last <= excelize.TotalRows
+}
+
+func (p *PrintConfig) validate() error {
+    if _, _, ok := p.titleRows(); p.TitleRows != "" && !ok {
+        return fmt.Errorf("title_rows %q must be rows like 1:4", p.TitleRows)
+    }
+    if p.FreezeRows < 0 || p.FreezeColumns < 0 {
+        return fmt.Errorf("freeze_rows and freeze_columns must not be negative")
+    }
+    if m := p.Margins; m != nil {
+        for _, v := range []*float64{m.Left, m.Right, m.Top, m.Bottom, m.Header, m.Footer} {
+            if v != nil && (*v < 0 || *v > maxPrintMargin) {
+                return fmt.Errorf("margins must be 0 to %d inches", maxPrintMargin)
+            }
+        }
+    }
+    if strings.Contains(p.Footer, "&R") {
+        return fmt.Errorf("footer's right section is the artifact reference")
+    }
+    if len(p.Footer) > maxPrintFooter {
+        return fmt.Errorf("footer is longer than %d characters", maxPrintFooter)
+    }
+    return nil
+}
+
+// applyPrintConfig sets a week sheet's margins and frozen panes
+func applyPrintConfig(f *excelize.File, sheet string, p *PrintConfig) error {
+    if p == nil {
+        return nil
+    }
+    if m := p.Margins; m != nil {
+        if err := f.SetPageMargins(sheet, &excelize.PageLayoutMarginsOptions{
+            Left: m.Left, Right: m.Right, Top: m.Top, Bottom: m.Bottom, Header: m.Header, Footer: m.Footer,
+        }); err != nil {
+            return err
+        }
+    }
+    if p.FreezeRows == 0 && p.FreezeColumns == 0 {
+        return nil
+    }
+    cell, err := excelize.CoordinatesToCellName(p.FreezeColumns+1, p.FreezeRows+1)
+    if err != nil {
+        return err
+    }
+    pane := "bottomRight"
+    if p.FreezeColumns == 0 {
+        pane = "bottomLeft"
+    } else if p.FreezeRows == 0 {
+        pane = "topRight"
+    }
+    return f.SetPanes(sheet, &excelize.Panes{
+        Freeze:      true,
+        XSplit:      p.FreezeColumns,
+        YSplit:      p.FreezeRows,
+        TopLeftCell: cell,
+        ActivePane:  pane,
+        Selection:   []excelize.Selection{{SQRef: cell, ActiveCell: cell, Pane: pane}},
+    })
+}
+
+// stampPrintFooter puts the template's footer in every sheet's left and
+// centre footer sections. Like stampDraft it returns the footers as they
+// now are, for stampArtifactRef.
+func stampPrintFooter(gc *genContext, f *excelize.File, footers map[string]excelize.HeaderFooterOptions) map[string]excelize.HeaderFooterOptions {
+    p := gc.tpl.CellMap.Print
+    if p == nil || p.Footer == "" {
+        return footers
+    }
+    out := make(map[string]excelize.HeaderFooterOptions, len(footers))
+    for _, sheet := range f.GetSheetList() {
+        hf := footers[sheet]
+        hf.OddFooter = printFooter(hf.OddFooter, p.Footer)
+        if hf.DifferentOddEven {
+            hf.EvenFooter = printFooter(hf.EvenFooter, p.Footer)
+        }
+        if hf.DifferentFirst {
+            hf.FirstFooter = printFooter(hf.FirstFooter, p.Footer)
+        }
+        if err := f.SetHeaderFooter(sheet, &hf); err != nil {
+            gc.lg.Printf("Warning: could not set the %s footer: %v", sheet, err)
+            out[sheet] = footers[sheet]
+            continue
+        }
+        out[sheet] = hf
+    }
+    return out
+}
+
+// printFooter replaces the left and centre sections of a footer, keeping
+// its right section
+func printFooter(footer, sections string) string {
+    if i := strings.Index(footer, "&R"); i >= 0 {
+        return sections + footer[i:]
+    }
+    return sections
+}
+
+// withPrintTitles sets the rows a template repeats on every printed page
+// of sheets. excelize won't write the built-in _xlnm.Print_Titles name, so
+// it goes into the saved workbook's xl/workbook.xml, replacing any the
+// template had for those sheets.
+func withPrintTitles(data []byte, f *excelize.File, sheets []string, p *PrintConfig) ([]byte, error) {
+    if p == nil || p.TitleRows == "" || len(sheets) == 0 {
+        return data, nil
+    }
+    first, last, ok := p.titleRows()
+    if !ok {
+        return data, nil
+    }
+    titles := map[int]string{}
+    for _, sheet := range sheets {
+        if i, err := f.GetSheetIndex(sheet); err == nil && i >= 0 {
+            titles[i] = fmt.Sprintf("'%s'!$%d:$%d", strings.ReplaceAll(sheet, "'", "''"), first, last)
+        }
+    }
+
+    zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil {
+        return nil, err
+    }
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    for _, zf := range zr.File {
+        if zf.Name != "xl/workbook.xml" {
+            if err := zw.Copy(zf); err != nil {
+                return nil, err
+            }
+            continue
+        }
+        rc, err := zf.Open()
+        if err != nil {
+            return nil, err
+        }
+        wb, err := io.ReadAll(rc)
+        rc.Close()
+        if err != nil {
+            return nil, err
+        }
+        wb, err = setPrintTitles(wb, titles)
+        if err != nil {
+            return nil, err
+        }
+        fw, err := zw.CreateHeader(&zip.FileHeader{Name: zf.Name, Method: zip.Deflate, Modified: zf.Modified})
+        if err != nil {
+            return nil, err
+        }
+        if _, err := fw.Write(wb); err != nil {
+            return nil, err
+        }
+    }
+    if err := zw.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+var printTitlesName = regexp.MustCompile(`<definedName name="_xlnm\.Print_Titles" localSheetId="(\d+)"[^>]*>[^<]*</definedName>`)
+
+// setPrintTitles writes titles (refers-to by sheet index) into workbook.xml
+func setPrintTitles(wb []byte, titles map[int]string) ([]byte, error) {
+    wb = printTitlesName.ReplaceAllFunc(wb, func(m []byte) []byte {
+        i, _ := strconv.Atoi(string(printTitlesName.FindSubmatch(m)[1]))
+        if _, ok := titles[i]; ok {
+            return nil
+        }
+        return m
+    })
+    sheets := make([]int, 0, len(titles))
+    for i := range titles {
+        sheets = append(sheets, i)
+    }
+    sort.Ints(sheets)
+    var names bytes.Buffer
+    for _, i := range sheets {
+        fmt.Fprintf(&names, `<definedName name="_xlnm.Print_Titles" localSheetId="%d">`, i)
+        _ = xml.EscapeText(&names, []byte(titles[i]))
+        names.WriteString(`</definedName>`)
+    }
+
+    if i := bytes.Index(wb, []byte("<definedNames>")); i >= 0 {
+        at := i + len("<definedNames>")
+        return append(wb[:at:at], append(names.Bytes(), wb[at:]...)...), nil
+    }
+    i := bytes.Index(wb, []byte("</sheets>"))
+    if i < 0 {
+        return nil, fmt.Errorf("workbook.xml has no sheets")
+    }
+    at := i + len("</sheets>")
+    out := append(wb[:at:at], "<definedNames>"...)
+    out = append(out, names.Bytes()...)
+    out = append(out, "</definedNames>"...)
+    return append(out, wb[at:]...), nil
+}
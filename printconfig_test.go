@@ -0,0 +1,87 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "strings"
+    "testing"
+
+    "github.com/xuri/excelize/v2"
+)
+
+func TestPrintConfig(t *testing.T) {
+    top := 0.75
+    p := &PrintConfig{TitleRows: "1:4", FreezeRows: 4, FreezeColumns: 2, Margins: &PrintMargins{Top: &top}, Footer: "&CPage &P of &N"}
+    if err := p.validate(); err != nil {
+        t.Fatal(err)
+    }
+    f := excelize.NewFile()
+    _, _ = f.NewSheet("Bob's week")
+    for _, sheet := range f.GetSheetList() {
+        if err := weekPrintLayout(f, sheet); err != nil {
+            t.Fatal(err)
+        }
+        if err := applyPrintConfig(f, sheet, p); err != nil {
+            t.Fatal(err)
+        }
+    }
+    margins, _ := f.GetPageMargins("Sheet1")
+    if *margins.Top != 0.75 || *margins.Left != weekMarginSide {
+        t.Errorf("margins top %v left %v", *margins.Top, *margins.Left)
+    }
+    panes, _ := f.GetPanes("Sheet1")
+    if !panes.Freeze || panes.XSplit != 2 || panes.YSplit != 4 || panes.TopLeftCell != "C5" {
+        t.Errorf("panes %+v", panes)
+    }
+
+    gc := newGenContext(context.Background(), TimecardRequest{}, nil, loggerFrom(context.Background()))
+    gc.tpl = &templateDef{CellMap: CellMap{Print: p}}
+    footers := stampPrintFooter(gc, f, map[string]excelize.HeaderFooterOptions{"Sheet1": {OddFooter: "&LOld&Rref x"}})
+    if got := footers["Sheet1"].OddFooter; got != "&CPage &P of &N&Rref x" {
+        t.Errorf("footer %q", got)
+    }
+
+    var buf bytes.Buffer
+    if err := f.Write(&buf); err != nil {
+        t.Fatal(err)
+    }
+    data, err := withPrintTitles(buf.Bytes(), f, f.GetSheetList(), p)
+    if err != nil {
+        t.Fatal(err)
+    }
+    out, err := excelize.OpenReader(bytes.NewReader(data))
+    if err != nil {
+        t.Fatal(err)
+    }
+    var titles []string
+    for _, dn := range out.GetDefinedName() {
+        if dn.Name == "_xlnm.Print_Titles" {
+            titles = append(titles, dn.Scope+" "+dn.RefersTo)
+        }
+    }
+    if strings.Join(titles, ", ") != "Sheet1 'Sheet1'!$1:$4, Bob's week 'Bob''s week'!$1:$4" {
+        t.Errorf("print titles %q", titles)
+    }
+
+    // titles the template had are replaced, not repeated
+    again, err := withPrintTitles(data, out, []string{"Sheet1"}, &PrintConfig{TitleRows: "2:3"})
+    if err != nil {
+        t.Fatal(err)
+    }
+    out, _ = excelize.OpenReader(bytes.NewReader(again))
+    titles = nil
+    for _, dn := range out.GetDefinedName() {
+        if dn.Name == "_xlnm.Print_Titles" {
+            titles = append(titles, dn.Scope+" "+dn.RefersTo)
+        }
+    }
+    if strings.Join(titles, ", ") != "Sheet1 'Sheet1'!$2:$3, Bob's week 'Bob''s week'!$1:$4" {
+        t.Errorf("replaced print titles %q", titles)
+    }
+
+    for _, bad := range []PrintConfig{{TitleRows: "4:1"}, {TitleRows: "A1"}, {FreezeRows: -1}, {Margins: &PrintMargins{Top: new(float64)}, Footer: "&Rmine"}} {
+        if err := bad.validate(); err == nil {
+            t.Errorf("%+v validated", bad)
+        }
+    }
+}
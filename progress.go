@@ -0,0 +1,232 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ===================
+   Generation progress
+   =================== */
+
+// Long requests publish their stages (validated → rendered_week_1 → … →
+// converted → emailed → done) to a stream keyed by request ID. The app
+// picks its own X-Request-ID, opens
+//
+//   GET /api/progress/{request_id}   (text/event-stream)
+//
+// and then sends the generation request with that ID. Events already
+// published are replayed to late subscribers, and a finished stream is
+// kept for progressRetention so a slow client still sees the outcome.
+
+const (
+    progressRetention = time.Minute
+    progressHeartbeat = 15 * time.Second
+)
+
+type progressEvent struct {
+    Stage   string    `json:"stage"`
+    Message string    `json:"message,omitempty"`
+    Percent int       `json:"percent"`
+    Final   bool      `json:"final,omitempty"`
+    At      time.Time `json:"at"`
+}
+
+type progressStream struct {
+    plan    []string // expected stages in order, for Percent
+    events  []progressEvent
+    subs    map[chan progressEvent]struct{}
+    done    bool
+    expires time.Time
+}
+
+var (
+    progressMu      sync.Mutex
+    progressStreams = map[string]*progressStream{}
+)
+
+// streamLocked returns the stream for id, creating it. Callers hold progressMu.
+func streamLocked(id string) *progressStream {
+    s, ok := progressStreams[id]
+    if !ok {
+        s = &progressStream{subs: map[chan progressEvent]struct{}{}, expires: time.Now().Add(progressRetention)}
+        progressStreams[id] = s
+    }
+    return s
+}
+
+// pruneProgressLocked drops streams nobody came back for
+func pruneProgressLocked() {
+    t := time.Now()
+    for id, s := range progressStreams {
+        if len(s.subs) == 0 && t.After(s.expires) {
+            delete(progressStreams, id)
+        }
+    }
+}
+
+// planProgress declares the stages a request will go through, so each
+// event can carry a percentage.
+func planProgress(ctx context.Context, stages ...string) {
+    id := requestIDFrom(ctx)
+    if id == "" {
+        return
+    }
+    progressMu.Lock()
+    defer progressMu.Unlock()
+    pruneProgressLocked()
+    streamLocked(id).plan = append(stages, "done")
+}
+
+// reportProgress publishes a stage for the request in ctx, if anyone
+// planned or subscribed to it. "done" and "failed" end the stream.
+func reportProgress(ctx context.Context, stage, message string) {
+    id := requestIDFrom(ctx)
+    if id == "" {
+        return
+    }
+    progressMu.Lock()
+    defer progressMu.Unlock()
+    s, ok := progressStreams[id]
+    if !ok || s.done {
+        return
+    }
+    ev := progressEvent{Stage: stage, Message: message, At: time.Now().UTC()}
+    for i, st := range s.plan {
+        if st == stage {
+            ev.Percent = (i + 1) * 100 / len(s.plan)
+        }
+    }
+    if n := len(s.events); ev.Percent == 0 && n > 0 {
+        ev.Percent = s.events[n-1].Percent
+    }
+    if stage == "done" || stage == "failed" {
+        ev.Final, s.done = true, true
+        if stage == "done" {
+            ev.Percent = 100
+        }
+    }
+    s.events = append(s.events, ev)
+    s.expires = time.Now().Add(progressRetention)
+    for ch := range s.subs {
+        select {
+        case ch <- ev:
+        default: // a stalled subscriber misses intermediate stages, not the end
+        }
+    }
+    if s.done {
+        for ch := range s.subs {
+            close(ch)
+        }
+        s.subs = map[chan progressEvent]struct{}{}
+    }
+}
+
+// planGeneration plans the usual stages of a card: validation, one render
+// per week sheet, then final (e.g. "converted" or "emailed", or "" for none).
+func planGeneration(ctx context.Context, req TimecardRequest, final string) {
+    stages := []string{"validated"}
+    for i := range req.Weeks {
+        if i == 2 { // the template has two week sheets
+            break
+        }
+        stages = append(stages, fmt.Sprintf("rendered_week_%d", i+1))
+    }
+    if final != "" {
+        stages = append(stages, final)
+    }
+    planProgress(ctx, stages...)
+    reportProgress(ctx, "validated", "")
+}
+
+// finishProgress ends the stream with done, or failed when err is set
+func finishProgress(ctx context.Context, err error) {
+    if err != nil {
+        reportProgress(ctx, "failed", err.Error())
+        return
+    }
+    reportProgress(ctx, "done", "")
+}
+
+// progressHandler serves GET /api/progress/{request_id} as server-sent events
+func progressHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/progress"), "/")
+    if !requestIDPattern.MatchString(id) {
+        httpError(w, r, "invalid request id", http.StatusBadRequest)
+        return
+    }
+    flusher, ok := w.(http.Flusher)
+    if !ok {
+        httpError(w, r, "streaming unsupported", http.StatusInternalServerError)
+        return
+    }
+
+    progressMu.Lock()
+    pruneProgressLocked()
+    s := streamLocked(id)
+    backlog := append([]progressEvent(nil), s.events...)
+    var ch chan progressEvent
+    if !s.done {
+        ch = make(chan progressEvent, 16)
+        s.subs[ch] = struct{}{}
+    }
+    progressMu.Unlock()
+    defer func() {
+        if ch == nil {
+            return
+        }
+        progressMu.Lock()
+        delete(s.subs, ch)
+        progressMu.Unlock()
+    }()
+
+    w.Header().Set("Content-Type", "text/event-stream")
+    w.Header().Set("Cache-Control", "no-cache")
+    w.Header().Set("X-Accel-Buffering", "no") // don't let nginx hold events back
+    w.WriteHeader(http.StatusOK)
+
+    send := func(ev progressEvent) {
+        data, _ := json.Marshal(ev)
+        fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Stage, data)
+        flusher.Flush()
+    }
+    for _, ev := range backlog {
+        send(ev)
+    }
+    if ch == nil {
+        return
+    }
+    fmt.Fprint(w, ": waiting\n\n")
+    flusher.Flush()
+
+    heartbeat := time.NewTicker(progressHeartbeat)
+    defer heartbeat.Stop()
+    idle := time.NewTimer(progressRetention) // the request may never come
+    defer idle.Stop()
+    for {
+        select {
+        case ev, open := <-ch:
+            if !open {
+                return
+            }
+            send(ev)
+            idle.Reset(progressRetention)
+        case <-idle.C:
+            return
+        case <-heartbeat.C:
+            fmt.Fprint(w, ": keep-alive\n\n")
+            flusher.Flush()
+        case <-r.Context().Done():
+            return
+        }
+    }
+}
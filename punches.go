@@ -0,0 +1,167 @@
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+/* ================
+   Clock in and out
+   ================ */
+
+// Crews that clock in and out can send punches instead of hours:
+//
+//   "punches": [{"job_code": "29699", "in": "2025-01-06T21:00:00-05:00",
+//                "out": "2025-01-07T05:30:00-05:00",
+//                "breaks": [{"start": "2025-01-07T01:00:00-05:00", "end": "2025-01-07T01:30:00-05:00"}]}]
+//
+// Each punch becomes a worked entry: its hours are the time between in and
+// out less its breaks, it starts at the clock-in time (for the night
+// differential) and it is booked to the day it started, in the employee's
+// time zone, even when it runs past midnight. A shift is night shift when
+// at least half of it falls in the tenant's night_differential window
+// (22:00 to 06:00 without one). On a card with weeks the entry goes in
+// the week it falls in. Punches are turned into entries once; any that
+// can't be (a bad time, out before in, a break outside the shift, a day
+// outside the card's weeks) stay on the card and fail validation.
+//
+// The tenant's break_rules still apply to the day's hours afterwards, so a
+// tenant whose crews punch their breaks would normally leave them unset.
+
+// Punch is one clocked shift on one job
+type Punch struct {
+    JobCode string       `json:"job_code"` // JOB NUMBER
+    In      string       `json:"in"`       // RFC 3339
+    Out     string       `json:"out"`      // RFC 3339
+    Breaks  []PunchBreak `json:"breaks,omitempty"`
+}
+
+// PunchBreak is an unpaid break taken during a punch
+type PunchBreak struct {
+    Start string `json:"start"` // RFC 3339
+    End   string `json:"end"`   // RFC 3339
+}
+
+// maxShift is the longest punch taken as one shift
+const maxShift = 24 * time.Hour
+
+var defaultNightWindow = NightDifferential{WindowStart: "22:00", WindowEnd: "06:00"}
+
+// entry turns the punch into an entry on the day it starts in loc
+func (p Punch) entry(loc *time.Location, night NightDifferential) (Entry, error) {
+    if p.JobCode == "" {
+        return Entry{}, fmt.Errorf("job_code is required")
+    }
+    in, err := time.Parse(time.RFC3339, p.In)
+    if err != nil {
+        return Entry{}, fmt.Errorf("in %q is not an RFC 3339 time", p.In)
+    }
+    out, err := time.Parse(time.RFC3339, p.Out)
+    if err != nil {
+        return Entry{}, fmt.Errorf("out %q is not an RFC 3339 time", p.Out)
+    }
+    shift := out.Sub(in)
+    switch {
+    case shift <= 0:
+        return Entry{}, fmt.Errorf("out %s is not after in %s", p.Out, p.In)
+    case shift > maxShift:
+        return Entry{}, fmt.Errorf("shift from %s to %s is longer than 24 hours", p.In, p.Out)
+    }
+
+    var breaks time.Duration
+    for _, b := range p.Breaks {
+        start, err1 := time.Parse(time.RFC3339, b.Start)
+        end, err2 := time.Parse(time.RFC3339, b.End)
+        if err1 != nil || err2 != nil || !end.After(start) || start.Before(in) || end.After(out) {
+            return Entry{}, fmt.Errorf("break %s to %s is not within the shift", b.Start, b.End)
+        }
+        breaks += end.Sub(start)
+    }
+    if breaks >= shift {
+        return Entry{}, fmt.Errorf("breaks take up the whole shift from %s", p.In)
+    }
+
+    local := in.In(loc)
+    y, m, d := local.Date()
+    e := Entry{
+        Date:    time.Date(y, m, d, 0, 0, 0, 0, loc).Format(time.RFC3339),
+        JobCode: p.JobCode,
+        Hours:   roundHours((shift - breaks).Hours()),
+        Start:   local.Format("15:04"),
+    }
+    night.Multiplier = 0 // the hours themselves
+    e.IsNightShift = 2*night.premium(Entry{Hours: shift.Hours(), Start: e.Start}) >= shift.Hours()
+    return e, nil
+}
+
+// punchNightWindow is the window that makes a punch night shift
+func punchNightWindow(t *Tenant) NightDifferential {
+    if n := t.nightDifferential(); n != nil {
+        return *n
+    }
+    return defaultNightWindow
+}
+
+// applyPunches turns req's punches into entries (see the top of this file)
+func applyPunches(req *TimecardRequest, t *Tenant) {
+    if len(req.Punches) == 0 {
+        return
+    }
+    loc := cardLocation(*req, t)
+    night := punchNightWindow(t)
+    var left []Punch
+    for _, p := range req.Punches {
+        e, err := p.entry(loc, night)
+        if err != nil {
+            left = append(left, p)
+            continue
+        }
+        if len(req.Weeks) == 0 {
+            req.Entries = append(req.Entries, e)
+            continue
+        }
+        i, ok := weekOf(*req, e.Date, loc)
+        if !ok {
+            left = append(left, p)
+            continue
+        }
+        req.Weeks[i].Entries = append(req.Weeks[i].Entries, e)
+    }
+    req.Punches = left
+}
+
+// weekOf is the index of the card's week the date falls in
+func weekOf(req TimecardRequest, date string, loc *time.Location) (int, bool) {
+    day, err := parseCalendarDate(date, loc)
+    if err != nil {
+        return 0, false
+    }
+    for i, wk := range req.Weeks {
+        start, err := parseCalendarDate(wk.WeekStartDate, loc)
+        if err != nil {
+            continue
+        }
+        if !day.Before(start) && day.Before(start.AddDate(0, 0, wk.days())) {
+            return i, true
+        }
+    }
+    return 0, false
+}
+
+// checkPunches reports the punches applyPunches couldn't turn into entries
+func checkPunches(v *validationResult, req TimecardRequest, t *Tenant) {
+    loc := cardLocation(req, t)
+    night := punchNightWindow(t)
+    for _, p := range req.Punches {
+        e, err := p.entry(loc, night)
+        if err != nil {
+            v.errorf("punch in at %s: %v", p.In, err)
+            continue
+        }
+        if len(req.Weeks) > 0 {
+            if _, ok := weekOf(req, e.Date, loc); !ok {
+                v.errorf("punch in at %s: not in any of the card's weeks", p.In)
+            }
+        }
+    }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+)
+
+func TestApplyPunches(t *testing.T) {
+    tenant := &Tenant{ID: "punch-test", Timezone: "America/Toronto"}
+    punch := func(in, out string, breaks ...PunchBreak) Punch {
+        return Punch{JobCode: "A", In: "2025-01-" + in + "-05:00", Out: "2025-01-" + out + "-05:00", Breaks: breaks}
+    }
+    lunch := PunchBreak{Start: "2025-01-06T12:00:00-05:00", End: "2025-01-06T12:30:00-05:00"}
+
+    tests := []struct {
+        name  string
+        punch Punch
+        want  Entry
+    }{
+        {"day shift", punch("06T07:00:00", "06T15:30:00", lunch),
+            Entry{Date: "2025-01-06T00:00:00-05:00", JobCode: "A", Hours: 8, Start: "07:00"}},
+        {"past midnight", punch("06T21:00:00", "07T05:30:00"),
+            Entry{Date: "2025-01-06T00:00:00-05:00", JobCode: "A", Hours: 8.5, Start: "21:00", IsNightShift: true}},
+        {"evening", punch("06T15:00:00", "06T23:00:00"),
+            Entry{Date: "2025-01-06T00:00:00-05:00", JobCode: "A", Hours: 8, Start: "15:00"}},
+        {"mostly at night", punch("06T20:00:00", "07T02:00:00"),
+            Entry{Date: "2025-01-06T00:00:00-05:00", JobCode: "A", Hours: 6, Start: "20:00", IsNightShift: true}},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := TimecardRequest{Punches: []Punch{tt.punch}}
+            applyPunches(&req, tenant)
+            if len(req.Punches) != 0 || !reflect.DeepEqual(req.Entries, []Entry{tt.want}) {
+                t.Errorf("entries %+v, punches left %+v\nwant %+v", req.Entries, req.Punches, tt.want)
+            }
+        })
+    }
+}
+
+func TestApplyPunchesIntoWeeks(t *testing.T) {
+    tenant := &Tenant{ID: "punch-test"}
+    req := TimecardRequest{
+        Weeks: []WeekData{{WeekStartDate: "2025-01-05T00:00:00Z"}, {WeekStartDate: "2025-01-12T00:00:00Z"}},
+        Punches: []Punch{
+            {JobCode: "A", In: "2025-01-13T07:00:00Z", Out: "2025-01-13T15:00:00Z"},
+            {JobCode: "A", In: "2025-01-20T07:00:00Z", Out: "2025-01-20T15:00:00Z"},
+        },
+    }
+    applyPunches(&req, tenant)
+    if len(req.Weeks[0].Entries) != 0 || len(req.Weeks[1].Entries) != 1 || req.Weeks[1].Entries[0].Hours != 8 {
+        t.Errorf("weeks %+v", req.Weeks)
+    }
+    var v validationResult
+    checkPunches(&v, req, tenant)
+    if len(v.Errors) != 1 || !strings.Contains(v.Errors[0], "not in any of the card's weeks") {
+        t.Errorf("errors %q", v.Errors)
+    }
+}
+
+func TestCheckPunches(t *testing.T) {
+    tests := []struct {
+        name  string
+        punch Punch
+        want  string
+    }{
+        {"no job", Punch{In: "2025-01-06T07:00:00Z", Out: "2025-01-06T15:00:00Z"}, "job_code is required"},
+        {"bad in", Punch{JobCode: "A", In: "7am", Out: "2025-01-06T15:00:00Z"}, `in "7am" is not an RFC 3339 time`},
+        {"out before in", Punch{JobCode: "A", In: "2025-01-06T15:00:00Z", Out: "2025-01-06T07:00:00Z"}, "is not after in"},
+        {"too long", Punch{JobCode: "A", In: "2025-01-06T07:00:00Z", Out: "2025-01-07T08:00:00Z"}, "longer than 24 hours"},
+        {"break outside", Punch{JobCode: "A", In: "2025-01-06T07:00:00Z", Out: "2025-01-06T15:00:00Z",
+            Breaks: []PunchBreak{{Start: "2025-01-06T15:00:00Z", End: "2025-01-06T15:30:00Z"}}}, "is not within the shift"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            req := TimecardRequest{Punches: []Punch{tt.punch}}
+            applyPunches(&req, &Tenant{ID: "punch-test"})
+            var v validationResult
+            checkPunches(&v, req, &Tenant{ID: "punch-test"})
+            if len(req.Entries) != 0 || len(v.Errors) != 1 || !strings.Contains(v.Errors[0], tt.want) {
+                t.Errorf("entries %+v, errors %q, want %q", req.Entries, v.Errors, tt.want)
+            }
+        })
+    }
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+    "bytes"
+    "encoding/csv"
+    "strings"
+)
+
+/* =================
+   QuickBooks export
+   ================= */
+
+// The "quickbooks" export turns cards into time activities QuickBooks
+// imports, so billed hours don't have to be retyped:
+//
+//   quickbooks      a QuickBooks Desktop IIF file of TIMEACT rows
+//   quickbooks-csv  the columns of the QuickBooks Online time-activity import
+//                   (also /api/export/quickbooks?format=csv)
+//
+// Each line is one day on one job: the employee, the service item (the
+// job's labour code, "N"-prefixed for night shift as on the sheet), the
+// customer:job and the duration. How job numbers map onto customers, and
+// which payroll items carry regular and overtime hours, is tenant config:
+//
+//   "quickbooks": {"customer": "Acme Construction",
+//                  "jobs": {"29699": "Acme Construction:Tower B"},
+//                  "payroll_item": "Hourly", "overtime_payroll_item": "Overtime",
+//                  "billable": true}
+//
+// A job number missing from "jobs" becomes "<customer>:<job number>" (or the
+// bare job number without a customer).
+
+// QuickBooksSettings maps a tenant's jobs onto its QuickBooks company file
+type QuickBooksSettings struct {
+    Customer            string            `json:"customer,omitempty"`
+    Jobs                map[string]string `json:"jobs,omitempty"` // job number → "Customer:Job"
+    PayrollItem         string            `json:"payroll_item,omitempty"`
+    OvertimePayrollItem string            `json:"overtime_payroll_item,omitempty"`
+    Billable            bool              `json:"billable,omitempty"`
+}
+
+// customerJob is the QuickBooks customer:job for a job number
+func (s *QuickBooksSettings) customerJob(job string) string {
+    if s == nil {
+        return job
+    }
+    if cj, ok := s.Jobs[job]; ok {
+        return cj
+    }
+    if s.Customer != "" {
+        return s.Customer + ":" + job
+    }
+    return job
+}
+
+func (s *QuickBooksSettings) payrollItem(overtime bool) string {
+    switch {
+    case s == nil:
+        return ""
+    case overtime && s.OvertimePayrollItem != "":
+        return s.OvertimePayrollItem
+    default:
+        return s.PayrollItem
+    }
+}
+
+func (s *QuickBooksSettings) billable() bool {
+    return s != nil && s.Billable
+}
+
+func init() {
+    registerExporter(quickBooksExporter{})
+    registerExporter(quickBooksExporter{csv: true})
+}
+
+type quickBooksExporter struct {
+    csv bool
+}
+
+func (q quickBooksExporter) Name() string {
+    if q.csv {
+        return "quickbooks-csv"
+    }
+    return "quickbooks"
+}
+
+func (q quickBooksExporter) ContentType() string {
+    if q.csv {
+        return "text/csv; charset=utf-8"
+    }
+    return "application/x-iif"
+}
+
+func (q quickBooksExporter) FileName(t *Tenant, reqs []TimecardRequest) string {
+    if q.csv {
+        return exportFileName(reqs, "quickbooks", "csv")
+    }
+    return exportFileName(reqs, "quickbooks", "iif")
+}
+
+func (q quickBooksExporter) Export(t *Tenant, reqs []TimecardRequest) ([]byte, error) {
+    var b bytes.Buffer
+    if q.csv {
+        cw := csv.NewWriter(&b)
+        _ = cw.Write([]string{"Date", "Employee", "Customer", "Service Item", "Duration", "Billable", "Description"})
+        for _, req := range reqs {
+            quickBooksCSV(cw, req, t.QuickBooks, hourLines(req, t.location()))
+        }
+        cw.Flush()
+        return b.Bytes(), cw.Error()
+    }
+    b.WriteString("!TIMEACT\tDATE\tJOB\tEMP\tITEM\tPITEM\tDURATION\tPROJ\tNOTE\tBILLINGSTATUS\r\n")
+    for _, req := range reqs {
+        quickBooksIIF(&b, req, t.QuickBooks, hourLines(req, t.location()))
+    }
+    return b.Bytes(), nil
+}
+
+// quickBooksIIF writes a card's lines as Desktop TIMEACT rows
+func quickBooksIIF(b *bytes.Buffer, req TimecardRequest, s *QuickBooksSettings, lines []hourLine) {
+    status := "0" // not billable
+    if s.billable() {
+        status = "1"
+    }
+    for _, l := range lines {
+        fields := []string{
+            "TIMEACT",
+            l.Date.Format("01/02/2006"),
+            s.customerJob(l.JobNumber),
+            req.EmployeeName,
+            l.code(),
+            s.payrollItem(l.Overtime),
+            clockDuration(l.Hours),
+            "",
+            quickBooksNote(l),
+            status,
+        }
+        for i, f := range fields {
+            fields[i] = iifField(f)
+        }
+        b.WriteString(strings.Join(fields, "\t") + "\r\n")
+    }
+}
+
+// quickBooksCSV writes a card's lines in the Online time-activity import layout
+func quickBooksCSV(cw *csv.Writer, req TimecardRequest, s *QuickBooksSettings, lines []hourLine) {
+    billable := "No"
+    if s.billable() {
+        billable = "Yes"
+    }
+    for _, l := range lines {
+        _ = cw.Write([]string{
+            l.Date.Format("01/02/2006"),
+            req.EmployeeName,
+            s.customerJob(l.JobNumber),
+            l.code(),
+            clockDuration(l.Hours),
+            billable,
+            quickBooksNote(l),
+        })
+    }
+}
+
+func quickBooksNote(l hourLine) string {
+    var parts []string
+    if l.Overtime {
+        parts = append(parts, "Overtime")
+    }
+    if l.Night {
+        parts = append(parts, "Night shift")
+    }
+    return strings.Join(parts, ", ")
+}
+
+// iifField keeps a value from breaking the tab-separated IIF layout
+func iifField(s string) string {
+    return strings.NewReplacer("\t", " ", "\r", " ", "\n", " ", `"`, "'").Replace(s)
+}
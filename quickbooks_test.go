@@ -0,0 +1,103 @@
+package main
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestQuickBooksExport(t *testing.T) {
+    acme := &QuickBooksSettings{
+        Customer:            "Acme Construction",
+        Jobs:                map[string]string{"29699": "Acme Construction:Tower B"},
+        PayrollItem:         "Hourly",
+        OvertimePayrollItem: "Overtime",
+        Billable:            true,
+    }
+    tests := []struct {
+        name     string
+        settings *QuickBooksSettings
+        iif, csv []string
+    }{
+        {"no settings", nil,
+            []string{
+                "TIMEACT\t01/06/2025\t29699\tBob Smith\t201\t\t8:00\t\t\t0",
+                "TIMEACT\t01/06/2025\t29699\tBob Smith\t201\t\t1:30\t\tOvertime\t0",
+                "TIMEACT\t01/07/2025\t12215\tBob Smith\tN223\t\t7:45\t\tNight shift\t0",
+                "TIMEACT\t01/07/2025\t50000\tBob Smith\t\t\t0:30\t\t\t0",
+            },
+            []string{
+                "01/06/2025,Bob Smith,29699,201,8:00,No,",
+                "01/06/2025,Bob Smith,29699,201,1:30,No,Overtime",
+                "01/07/2025,Bob Smith,12215,N223,7:45,No,Night shift",
+                "01/07/2025,Bob Smith,50000,,0:30,No,",
+            }},
+        {"mapped jobs and payroll items", acme,
+            []string{
+                "TIMEACT\t01/06/2025\tAcme Construction:Tower B\tBob Smith\t201\tHourly\t8:00\t\t\t1",
+                "TIMEACT\t01/06/2025\tAcme Construction:Tower B\tBob Smith\t201\tOvertime\t1:30\t\tOvertime\t1",
+                "TIMEACT\t01/07/2025\tAcme Construction:12215\tBob Smith\tN223\tHourly\t7:45\t\tNight shift\t1",
+                "TIMEACT\t01/07/2025\tAcme Construction:50000\tBob Smith\t\tHourly\t0:30\t\t\t1",
+            },
+            []string{
+                "01/06/2025,Bob Smith,Acme Construction:Tower B,201,8:00,Yes,",
+                "01/06/2025,Bob Smith,Acme Construction:Tower B,201,1:30,Yes,Overtime",
+                "01/07/2025,Bob Smith,Acme Construction:12215,N223,7:45,Yes,Night shift",
+                "01/07/2025,Bob Smith,Acme Construction:50000,,0:30,Yes,",
+            }},
+        {"overtime without its own item", &QuickBooksSettings{PayrollItem: "Hourly"},
+            []string{
+                "TIMEACT\t01/06/2025\t29699\tBob Smith\t201\tHourly\t8:00\t\t\t0",
+                "TIMEACT\t01/06/2025\t29699\tBob Smith\t201\tHourly\t1:30\t\tOvertime\t0",
+                "TIMEACT\t01/07/2025\t12215\tBob Smith\tN223\tHourly\t7:45\t\tNight shift\t0",
+                "TIMEACT\t01/07/2025\t50000\tBob Smith\t\tHourly\t0:30\t\t\t0",
+            },
+            nil},
+    }
+    reqs := []TimecardRequest{exportTestCard()}
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            tenant := &Tenant{QuickBooks: tt.settings}
+            data, err := quickBooksExporter{}.Export(tenant, reqs)
+            if err != nil {
+                t.Fatal(err)
+            }
+            iif := strings.Split(strings.TrimSuffix(string(data), "\r\n"), "\r\n")
+            if iif[0] != "!TIMEACT\tDATE\tJOB\tEMP\tITEM\tPITEM\tDURATION\tPROJ\tNOTE\tBILLINGSTATUS" {
+                t.Errorf("IIF header %q", iif[0])
+            }
+            assertLines(t, "IIF", iif[1:], tt.iif)
+
+            if tt.csv == nil {
+                return
+            }
+            if data, err = (quickBooksExporter{csv: true}).Export(tenant, reqs); err != nil {
+                t.Fatal(err)
+            }
+            csv := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+            if csv[0] != "Date,Employee,Customer,Service Item,Duration,Billable,Description" {
+                t.Errorf("CSV header %q", csv[0])
+            }
+            assertLines(t, "CSV", csv[1:], tt.csv)
+        })
+    }
+}
+
+func TestIIFField(t *testing.T) {
+    if got := iifField("Smith, \"Bob\"\tJr.\r\n"); got != "Smith, 'Bob' Jr.  " {
+        t.Errorf("got %q", got)
+    }
+}
+
+// assertLines compares an export's lines with the ones wanted
+func assertLines(t *testing.T, what string, got, want []string) {
+    t.Helper()
+    if len(got) != len(want) {
+        t.Errorf("%s has %d lines, want %d:\n%s", what, len(got), len(want), strings.Join(got, "\n"))
+        return
+    }
+    for i := range want {
+        if got[i] != want[i] {
+            t.Errorf("%s line %d\n got  %q\n want %q", what, i+1, got[i], want[i])
+        }
+    }
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strings"
+    "time"
+)
+
+/* ================
+   Timecard records
+   ================ */
+
+// Every accepted card is kept as the JSON payload the client sent, tagged
+// with the payload schema version, so it can be regenerated long after the
+// app and the model have moved on. When the model changes incompatibly,
+// bump currentPayloadVersion and append a step to payloadMigrations;
+// stored records are upgraded on load and rewritten by migrateRecords.
+
+const currentPayloadVersion = 2
+
+// payloadMigrations[v-1] upgrades a version v payload to v+1 in place
+var payloadMigrations = []func(p map[string]interface{}) error{
+    // 1 → 2: canonical entry keys. Early apps sent camelCase and
+    // short aliases ("code", "isOvertime", "night_shift").
+    func(p map[string]interface{}) error {
+        canon := func(list interface{}) {
+            entries, _ := list.([]interface{})
+            for _, raw := range entries {
+                e, ok := raw.(map[string]interface{})
+                if !ok {
+                    continue
+                }
+                renameKey(e, "code", "job_code")
+                renameKey(e, "isOvertime", "overtime")
+                renameKey(e, "night_shift", "is_night_shift")
+                renameKey(e, "isNightShift", "is_night_shift")
+            }
+        }
+        canon(p["entries"])
+        weeks, _ := p["weeks"].([]interface{})
+        for _, raw := range weeks {
+            if wk, ok := raw.(map[string]interface{}); ok {
+                canon(wk["entries"])
+            }
+        }
+        return nil
+    },
+}
+
+// renameKey moves from to to unless to is already set
+func renameKey(m map[string]interface{}, from, to string) {
+    v, ok := m[from]
+    if !ok {
+        return
+    }
+    delete(m, from)
+    if _, exists := m[to]; !exists {
+        m[to] = v
+    }
+}
+
+// migratePayload upgrades payload from version to currentPayloadVersion
+func migratePayload(payload json.RawMessage, version int) (json.RawMessage, error) {
+    if version == currentPayloadVersion {
+        return payload, nil
+    }
+    if version < 1 || version > currentPayloadVersion {
+        return nil, fmt.Errorf("unknown payload schema version %d", version)
+    }
+    var p map[string]interface{}
+    if err := json.Unmarshal(payload, &p); err != nil {
+        return nil, fmt.Errorf("decode payload: %w", err)
+    }
+    for v := version; v < currentPayloadVersion; v++ {
+        if err := payloadMigrations[v-1](p); err != nil {
+            return nil, fmt.Errorf("migrate payload v%d→v%d: %w", v, v+1, err)
+        }
+    }
+    return json.Marshal(p)
+}
+
+// TimecardRecord is one accepted card
+type TimecardRecord struct {
+    ID            string            `json:"id"`
+    Kind          string            `json:"kind"` // xlsx, pdf, bundle, email or delivery
+    Tenant        string            `json:"tenant"`
+    Employee      string            `json:"employee"`
+    EmployeeID    string            `json:"employee_id,omitempty"` // from the employee directory
+    PayrollNumber string            `json:"payroll_number,omitempty"`
+    PayPeriodNum  int               `json:"pay_period_num"`
+    Year          int               `json:"year"`
+    RequestID     string            `json:"request_id,omitempty"`
+    SchemaVersion int               `json:"schema_version"`
+    Payload       json.RawMessage   `json:"payload,omitempty"`
+    Artifacts     []string          `json:"artifacts,omitempty"` // stored files, relative to artifactsDir()
+    Copies        []StoredCopy      `json:"copies,omitempty"`    // the same files in Drive and the archive
+    Deliveries    []DeliveryReceipt `json:"deliveries,omitempty"`
+    Template      string            `json:"template,omitempty"`   // the layout it was generated on
+    Revision      int               `json:"revision,omitempty"`   // see revisions.go
+    Supersedes    string            `json:"supersedes,omitempty"` // the previous revision's record
+    Approval      *Approval         `json:"approval,omitempty"`
+    Reviews       []Approval        `json:"reviews,omitempty"` // earlier decisions, oldest first
+    CreatedAt     time.Time         `json:"created_at"`
+}
+
+var timecards = openCollection[TimecardRecord]("timecards")
+
+// employeeRef is the record's employee ID, else its payroll number
+func (rec TimecardRecord) employeeRef() string {
+    if id := strings.TrimSpace(rec.EmployeeID); id != "" {
+        return id
+    }
+    return strings.TrimSpace(rec.PayrollNumber)
+}
+
+// revision is the record's revision number, 1 until it is resubmitted
+func (rec TimecardRecord) revision() int {
+    if rec.Revision < 1 {
+        return 1
+    }
+    return rec.Revision
+}
+
+// request decodes the record's payload after bringing it up to date
+func (rec TimecardRecord) request() (TimecardRequest, error) {
+    var req TimecardRequest
+    payload, err := migratePayload(rec.Payload, rec.SchemaVersion)
+    if err != nil {
+        return req, err
+    }
+    if err := json.Unmarshal(payload, &req); err != nil {
+        return req, fmt.Errorf("decode payload: %w", err)
+    }
+    if req.Revision == 0 {
+        req.Revision = rec.revision()
+    }
+    if req.Template == "" {
+        req.Template = rec.Template
+    }
+    if a := rec.Approval; a != nil && a.Status == approvalApproved && a.DecidedAt != nil && a.Revision == rec.revision() {
+        req.ApprovedBy, req.ApprovedAt = a.Manager, a.DecidedAt.Format(time.RFC3339)
+    }
+    return req, nil
+}
+
+// readPayload reads the request body and decodes it into v, returning the
+// bytes so the caller can keep them.
+func readPayload(r *http.Request, v interface{}) (json.RawMessage, error) {
+    body, err := io.ReadAll(r.Body)
+    if err != nil {
+        return nil, err
+    }
+    if err := json.Unmarshal(body, v); err != nil {
+        return nil, err
+    }
+    return body, nil
+}
+
+// saveTimecardRecord stores an accepted payload (as sent, version 1 wire
+// format, upgraded to current) and returns the record id. Failing to keep
+// the record never fails the request.
+func saveTimecardRecord(r *http.Request, kind string, payload json.RawMessage, req TimecardRequest) string {
+    return keepTimecardRecord(r.Context(), tenantFor(r), kind, payload, req)
+}
+
+// keepTimecardRecord is saveTimecardRecord outside a request, for jobs
+func keepTimecardRecord(ctx context.Context, tenant *Tenant, kind string, payload json.RawMessage, req TimecardRequest) string {
+    lg := loggerFrom(ctx)
+    if req.Draft {
+        return "" // a preview, not a card (watermark.go)
+    }
+    upgraded, err := migratePayload(payload, 1)
+    if err != nil {
+        lg.Printf("Warning: not keeping payload: %v", err)
+        return ""
+    }
+    rec := TimecardRecord{
+        ID:            newID(),
+        Kind:          kind,
+        Tenant:        tenant.ID,
+        Employee:      req.EmployeeName,
+        EmployeeID:    req.EmployeeID,
+        PayrollNumber: req.PayrollNumber,
+        PayPeriodNum:  req.PayPeriodNum,
+        Year:          req.Year,
+        RequestID:     requestIDFrom(ctx),
+        SchemaVersion: currentPayloadVersion,
+        Payload:       withoutAttachments(withoutPDFPassword(upgraded)),
+        Template:      req.Template,
+        Revision:      req.Revision,
+        CreatedAt:     now().UTC(),
+    }
+    if prev, ok := latestRevision(rec.Tenant, rec.Employee, rec.employeeRef(), rec.Year, rec.PayPeriodNum); ok {
+        rec.Supersedes = prev.ID
+    }
+    if req.ManagerEmail != "" {
+        rec.Approval = newApproval(req.ManagerEmail, rec.revision())
+    }
+    if err := timecards.Put(rec.ID, rec); err != nil {
+        lg.Printf("Warning: could not save timecard record: %v", err)
+        return ""
+    }
+    auditCardTouched(ctx, rec.ID, rec.Employee, rec.PayPeriodNum, rec.Year)
+    recordLeave(ctx, tenant, rec.ID, req)
+    if rec.Approval != nil {
+        notifyManager(ctx, rec.ID)
+    }
+    return rec.ID
+}
+
+// withoutPayloadField drops a top-level key from a payload, which is left
+// as it is when it has no such key
+func withoutPayloadField(payload json.RawMessage, key string) json.RawMessage {
+    var fields map[string]json.RawMessage
+    if json.Unmarshal(payload, &fields) != nil {
+        return payload
+    }
+    if _, ok := fields[key]; !ok {
+        return payload
+    }
+    delete(fields, key)
+    out, err := json.Marshal(fields)
+    if err != nil {
+        return payload
+    }
+    return out
+}
+
+// migrateRecords rewrites every stored record at an older schema version
+func migrateRecords() {
+    upgraded := 0
+    for _, rec := range timecards.List() {
+        if rec.SchemaVersion == currentPayloadVersion {
+            continue
+        }
+        payload, err := migratePayload(rec.Payload, rec.SchemaVersion)
+        if err != nil {
+            log.Printf("Warning: timecard %s: %v", rec.ID, err)
+            continue
+        }
+        rec.Payload, rec.SchemaVersion = payload, currentPayloadVersion
+        if err := timecards.Put(rec.ID, rec); err != nil {
+            log.Printf("Warning: timecard %s: %v", rec.ID, err)
+            continue
+        }
+        upgraded++
+    }
+    if upgraded > 0 {
+        log.Printf("Migrated %d timecard record(s) to schema v%d", upgraded, currentPayloadVersion)
+    }
+}
+
+// timecardsHandler serves:
+//   GET  /api/timecards?employee=&year=&approval=&current=1
+//   GET  /api/timecards/{id}
+//   GET  /api/timecards/{id}/status
+//   GET  /api/timecards/{id}/revisions
+//   POST /api/timecards/{id}/regenerate?format=xlsx|pdf
+//   POST /api/timecards/{id}/deliver
+//   POST /api/timecards/{id}/submit
+//   POST /api/timecards/{id}/resubmit
+func timecardsHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/timecards"), "/")
+    parts := strings.Split(rest, "/")
+    if rec, ok := timecards.Get(parts[0]); ok && rest != "" {
+        if rec.Tenant != tenantFor(r).ID {
+            httpError(w, r, "timecard not found", http.StatusNotFound)
+            return
+        }
+        auditCardTouched(r.Context(), rec.ID, rec.Employee, rec.PayPeriodNum, rec.Year)
+    }
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        listTimecards(w, r)
+    case len(parts) == 1 && r.Method == http.MethodGet:
+        rec, ok := timecards.Get(parts[0])
+        if !ok {
+            httpError(w, r, "timecard not found", http.StatusNotFound)
+            return
+        }
+        writeJSON(w, http.StatusOK, rec)
+    case len(parts) == 2 && parts[1] == "status" && r.Method == http.MethodGet:
+        timecardStatus(w, r, parts[0])
+    case len(parts) == 2 && parts[1] == "revisions" && r.Method == http.MethodGet:
+        timecardRevisions(w, r, parts[0])
+    case len(parts) == 2 && parts[1] == "regenerate" && r.Method == http.MethodPost:
+        regenerateTimecard(w, r, parts[0])
+    case len(parts) == 2 && parts[1] == "deliver" && r.Method == http.MethodPost:
+        deliverTimecard(w, r, parts[0])
+    case len(parts) == 2 && parts[1] == "submit" && r.Method == http.MethodPost:
+        submitTimecard(w, r, parts[0])
+    case len(parts) == 2 && parts[1] == "resubmit" && r.Method == http.MethodPost:
+        resubmitTimecard(w, r, parts[0])
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func listTimecards(w http.ResponseWriter, r *http.Request) {
+    q := r.URL.Query()
+    employee, year, approval := q.Get("employee"), q.Get("year"), q.Get("approval")
+    current := q.Get("current") != ""
+    tenant := tenantFor(r).ID
+
+    out := []TimecardRecord{}
+    for _, rec := range timecards.List() {
+        if rec.Tenant != tenant {
+            continue
+        }
+        if employee != "" && !strings.EqualFold(rec.Employee, employee) {
+            continue
+        }
+        if year != "" && fmt.Sprint(rec.Year) != year {
+            continue
+        }
+        if approval != "" && (rec.Approval == nil || rec.Approval.Status != approval) {
+            continue
+        }
+        if current && !rec.isCurrent() {
+            continue
+        }
+        rec.Payload = nil // listing is an index; fetch one record for its payload
+        out = append(out, rec)
+    }
+    writeJSON(w, http.StatusOK, out)
+}
+
+// regenerateTimecard renders a stored record again with its original
+// tenant, laid out as /api/generate-pdf laid it out (pdflayout.go)
+func regenerateTimecard(w http.ResponseWriter, r *http.Request, id string) {
+    lg := loggerFrom(r.Context())
+    rec, ok := timecards.Get(id)
+    if !ok {
+        httpError(w, r, "timecard not found", http.StatusNotFound)
+        return
+    }
+    req, err := rec.request()
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("stored payload unusable: %v", err), http.StatusInternalServerError)
+        return
+    }
+    tenant := lookupTenant(rec.Tenant)
+    applyTimecardDefaults(&req, tenant)
+
+    gc := newGenContext(r.Context(), req, tenant, lg)
+    excelData, err := generateExcelFile(gc)
+    if err != nil {
+        generationFailed(w, r, "error generating timecard", err)
+        return
+    }
+
+    switch r.URL.Query().Get("format") {
+    case "", "xlsx":
+        w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"timecard_%s.xlsx\"", req.fileEmployee()))
+        _, _ = w.Write(excelData)
+    case "pdf":
+        if req.PDFLayout == pdfLayoutPerWeek {
+            pdfs, err := renderWeekPDFs(gc)
+            if err != nil {
+                generationFailed(w, r, "error converting to PDF", err)
+                return
+            }
+            archive, err := weekPDFArchive(req, pdfs)
+            if err != nil {
+                generationFailed(w, r, "error building PDF archive", err)
+                return
+            }
+            writeWeekPDFArchive(w, req, archive)
+            return
+        }
+        pdfData, err := renderPDF(gc, excelData)
+        if err != nil {
+            generationFailed(w, r, "error converting to PDF", err)
+            return
+        }
+        w.Header().Set("Content-Type", "application/pdf")
+        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"timecard_%s.pdf\"", req.fileEmployee()))
+        _, _ = w.Write(pdfData)
+    default:
+        httpError(w, r, "format must be xlsx or pdf", http.StatusBadRequest)
+    }
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+    "time"
+)
+
+/* ======================
+   Missing-card reminders
+   ====================== */
+
+// A tenant with a pay calendar and an employee directory can nag the people
+// who haven't handed in a card:
+//
+//   "reminders": {"cron": "0 9 * * *", "deadline": "17:00", "deadline_days": 1}
+//
+// The deadline is deadline_days after the last day of a period, at the
+// deadline time in the tenant's timezone (default: 17:00 on the last day).
+// Each time the cron expression fires, a "timecard-reminders" job looks at
+// the latest period whose deadline has passed, emails every active employee
+// with no card for it and sends each manager one digest listing their
+// missing people. Employees whose approved absences cover the whole period
+// are left out of both. The cron expression is how often they are nagged; it
+// stops once the card is in or the next period's deadline passes.
+
+// ReminderSchedule says when late employees and their managers are emailed
+type ReminderSchedule struct {
+    Cron         string `json:"cron"`
+    Deadline     string `json:"deadline,omitempty"`      // HH:MM, default 17:00
+    DeadlineDays int    `json:"deadline_days,omitempty"` // after the period's last day
+
+    spec   *cronSpec
+    cutoff time.Duration // Deadline as a time of day
+}
+
+func (s *ReminderSchedule) validate(t *Tenant) error {
+    spec, err := parseCron(s.Cron)
+    if err != nil {
+        return err
+    }
+    if t.payCalendar() == nil {
+        return fmt.Errorf("reminders need a pay calendar")
+    }
+    deadline := s.Deadline
+    if deadline == "" {
+        deadline = "17:00"
+    }
+    at, err := time.Parse("15:04", deadline)
+    if err != nil {
+        return fmt.Errorf("reminder deadline %q is not HH:MM", s.Deadline)
+    }
+    if s.DeadlineDays < 0 {
+        return fmt.Errorf("reminder deadline_days must not be negative")
+    }
+    s.spec = spec
+    s.cutoff = time.Duration(at.Hour())*time.Hour + time.Duration(at.Minute())*time.Minute
+    return nil
+}
+
+// deadlineOf is when cards for p are due
+func (s *ReminderSchedule) deadlineOf(p payPeriod, loc *time.Location) time.Time {
+    y, m, d := p.End.AddDate(0, 0, s.DeadlineDays).Date()
+    return time.Date(y, m, d, 0, 0, 0, 0, loc).Add(s.cutoff)
+}
+
+// reminderParams are the params of a "timecard-reminders" job
+type reminderParams struct {
+    Tenant       string    `json:"tenant"`
+    Year         int       `json:"year"`
+    PayPeriodNum int       `json:"pay_period_num"`
+    Deadline     time.Time `json:"deadline"`
+    // Start and End are the period's first and last days, YYYY-MM-DD
+    Start string `json:"start,omitempty"`
+    End   string `json:"end,omitempty"`
+}
+
+// reminderResult is the result of a "timecard-reminders" job
+type reminderResult struct {
+    Missing  []string `json:"missing"`
+    OnLeave  []string `json:"on_leave,omitempty"` // no card, but away all period
+    Reminded int      `json:"reminded"`
+    Digests  int      `json:"digests"`
+    Failed   []string `json:"failed,omitempty"` // addresses that could not be mailed
+}
+
+func init() {
+    registerJobKind("timecard-reminders", runRemindersJob)
+}
+
+// startReminderScheduler checks the tenants' reminder schedules every
+// minute until ctx ends
+func startReminderScheduler(ctx context.Context) {
+    if !anyTenant(func(t *Tenant) bool { return t.Reminders != nil }) {
+        return
+    }
+    log.Printf("Reminder scheduler started")
+    go everyMinute(ctx, checkReminderSchedules)
+}
+
+func checkReminderSchedules() {
+    for _, t := range loadTenants() {
+        s := t.Reminders
+        if s == nil || !s.spec.matches(now().In(t.location())) {
+            continue
+        }
+        p, deadline, err := overduePeriod(t)
+        if err != nil {
+            log.Printf("Warning: tenant %s reminders: %v", t.ID, err)
+            continue
+        }
+        params := reminderParams{Tenant: t.ID, Year: p.FiscalYear, PayPeriodNum: p.Number, Deadline: deadline,
+            Start: p.Start.Format("2006-01-02"), End: p.End.Format("2006-01-02")}
+        if _, err := enqueueJob("timecard-reminders", "scheduler", 0, params); err != nil {
+            log.Printf("Warning: could not queue reminders for tenant %s: %v", t.ID, err)
+        }
+    }
+}
+
+// overduePeriod is the tenant's latest period whose deadline has passed.
+// Deadlines only move forward, so walking back always finds one.
+func overduePeriod(t *Tenant) (payPeriod, time.Time, error) {
+    cal := t.payCalendar()
+    if cal == nil {
+        return payPeriod{}, time.Time{}, fmt.Errorf("no pay calendar")
+    }
+    loc := t.location()
+    p, err := cal.periodOf(today(loc))
+    for err == nil && now().Before(t.Reminders.deadlineOf(p, loc)) {
+        p, err = cal.periodOf(p.Start.AddDate(0, 0, -1))
+    }
+    return p, t.Reminders.deadlineOf(p, loc), err
+}
+
+func runRemindersJob(ctx context.Context, job *BackgroundJob) (interface{}, error) {
+    var p reminderParams
+    if err := json.Unmarshal(job.Params, &p); err != nil {
+        return nil, fmt.Errorf("invalid params: %w", err)
+    }
+    tenant := lookupTenant(p.Tenant)
+    ctx = withTenant(ctx, tenant)
+    deadline := p.Deadline.In(tenant.location()).Format("Monday, January 2 at 15:04")
+
+    res := reminderResult{Missing: []string{}}
+    var missing []Employee
+    for _, e := range tenantEmployees(p.Tenant) {
+        switch {
+        case hasCard(p.Tenant, e, p.Year, p.PayPeriodNum):
+        case onLeave(p.Tenant, e, p.Start, p.End):
+            res.OnLeave = append(res.OnLeave, e.Name)
+        default:
+            missing = append(missing, e)
+        }
+    }
+    byManager := map[string][]string{}
+    for i, e := range missing {
+        reportJobProgress(job, i, len(missing))
+        res.Missing = append(res.Missing, e.Name)
+        if e.ManagerEmail != "" {
+            byManager[e.ManagerEmail] = append(byManager[e.ManagerEmail], e.Name)
+        }
+        if e.Email == "" {
+            continue
+        }
+        subject := fmt.Sprintf("Reminder: your timecard for pay period %d, %d is missing", p.PayPeriodNum, p.Year)
+        body := fmt.Sprintf("Hi %s,\r\n\r\nWe haven't received your timecard for pay period %d, %d, which was due %s. "+
+            "Please submit it from the app as soon as you can.\r\n", e.Name, p.PayPeriodNum, p.Year, deadline)
+        if err := sendEmail(ctx, e.Email, nil, subject, body, nil, "", nil); err != nil {
+            log.Printf("Warning: reminder to %s: %v", e.Email, err)
+            res.Failed = append(res.Failed, e.Email)
+            continue
+        }
+        res.Reminded++
+    }
+
+    managers := make([]string, 0, len(byManager))
+    for m := range byManager {
+        managers = append(managers, m)
+    }
+    sort.Strings(managers)
+    for _, m := range managers {
+        names := byManager[m]
+        subject := fmt.Sprintf("%d timecard(s) missing for pay period %d, %d", len(names), p.PayPeriodNum, p.Year)
+        body := fmt.Sprintf("These people haven't submitted a timecard for pay period %d, %d:\r\n\r\n  %s\r\n",
+            p.PayPeriodNum, p.Year, strings.Join(names, "\r\n  "))
+        if err := sendEmail(ctx, m, nil, subject, body, nil, "", nil); err != nil {
+            log.Printf("Warning: reminder digest to %s: %v", m, err)
+            res.Failed = append(res.Failed, m)
+            continue
+        }
+        res.Digests++
+    }
+    reportJobProgress(job, len(missing), len(missing))
+    return res, nil
+}
+
+// onLeave reports whether e's approved absences cover every day from start
+// to end (YYYY-MM-DD); false when the period isn't known
+func onLeave(tenant string, e Employee, start, end string) bool {
+    from, err := time.Parse("2006-01-02", start)
+    if err != nil {
+        return false
+    }
+    to, err := time.Parse("2006-01-02", end)
+    if err != nil || to.Before(from) {
+        return false
+    }
+    for d := from; !d.After(to); d = d.AddDate(0, 0, 1) {
+        if _, ok := approvedAbsenceOn(tenant, e.Name, d.Format("2006-01-02")); !ok {
+            return false
+        }
+    }
+    return true
+}
+
+// hasCard reports whether e has any kept card for the period
+func hasCard(tenant string, e Employee, year, period int) bool {
+    for _, rec := range timecards.List() {
+        if rec.Tenant != tenant || rec.Year != year || rec.PayPeriodNum != period {
+            continue
+        }
+        if rec.EmployeeID == e.ID || e.sameEmployee(rec.Employee) {
+            return true
+        }
+    }
+    return false
+}
@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestOnLeave(t *testing.T) {
+    t.Setenv("DATA_DIR", t.TempDir())
+    saved := absences
+    absences = openCollection[Absence]("absences")
+    t.Cleanup(func() { absences = saved })
+    for _, a := range []Absence{
+        {ID: "1", Tenant: "acme", Employee: "Bob Smith", StartDate: "2025-01-05", EndDate: "2025-01-11", Status: absenceApproved},
+        {ID: "2", Tenant: "acme", Employee: "bob smith", StartDate: "2025-01-12", EndDate: "2025-01-18", Status: absenceApproved},
+        {ID: "3", Tenant: "acme", Employee: "Ann Lee", StartDate: "2025-01-05", EndDate: "2025-01-18", Status: absenceRequested},
+    } {
+        _ = absences.Put(a.ID, a)
+    }
+    tests := []struct {
+        name       string
+        employee   string
+        start, end string
+        want       bool
+    }{
+        {"covered by two absences", "Bob Smith", "2025-01-05", "2025-01-18", true},
+        {"a day uncovered", "Bob Smith", "2025-01-05", "2025-01-19", false},
+        {"only requested", "Ann Lee", "2025-01-05", "2025-01-18", false},
+        {"period unknown", "Bob Smith", "", "", false},
+    }
+    for _, tt := range tests {
+        if got := onLeave("acme", Employee{Name: tt.employee}, tt.start, tt.end); got != tt.want {
+            t.Errorf("%s: onLeave = %v", tt.name, got)
+        }
+    }
+}
@@ -0,0 +1,474 @@
+package main
+
+import (
+    "fmt"
+    "math"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+)
+
+/* =======
+   Reports
+   ======= */
+
+// Totals for payroll and project managers, added up with hourLines, the
+// way the workbook adds up a card:
+//
+//   POST /api/reports/summary                          one card, or a JSON array of cards
+//   GET  /api/reports/summary?year=&pay_period_num=    the period's stored cards
+//   GET  /api/reports/ytd?employee=|employee_id=&year=
+//
+// The summary has the totals per employee, per job number and per labour
+// code, and per phase and cost code when the cards have them. Year to date adds up every stored pay period of the year (by
+// default this one, the pay calendar's fiscal year when there is one) for
+// one employee, per period and per job, to reconcile against pay stubs.
+// hoursSummaryText puts one card's totals in the body of its email.
+// Stored cards count at their current revision. Night hours are also
+// counted in regular or overtime, whichever they were, and double time in
+// overtime. Expense lines (expenses.go) are added up by type beside the
+// hours, per employee, job and period, and the summary adds up the travel
+// log (travel.go) per employee and job.
+
+// hourSums are the hours of one row of a report
+type hourSums struct {
+    Regular    float64 `json:"regular"`
+    Overtime   float64 `json:"overtime"`
+    DoubleTime float64 `json:"double_time"` // also in Overtime
+    Night      float64 `json:"night"`
+    Total      float64 `json:"total"`
+}
+
+func (s *hourSums) add(l hourLine) {
+    if l.Overtime {
+        s.Overtime += l.Hours
+    } else {
+        s.Regular += l.Hours
+    }
+    if l.DoubleTime {
+        s.DoubleTime += l.Hours
+    }
+    if l.Night {
+        s.Night += l.Hours
+    }
+    s.Total += l.Hours
+}
+
+// merge adds the sums of another row
+func (s *hourSums) merge(o hourSums) {
+    s.Regular += o.Regular
+    s.Overtime += o.Overtime
+    s.DoubleTime += o.DoubleTime
+    s.Night += o.Night
+    s.Total += o.Total
+}
+
+// roundHours drops the float noise of adding up quarter hours
+func roundHours(h float64) float64 {
+    return math.Round(h*100) / 100
+}
+
+func (s hourSums) rounded() hourSums {
+    return hourSums{Regular: roundHours(s.Regular), Overtime: roundHours(s.Overtime), DoubleTime: roundHours(s.DoubleTime),
+        Night: roundHours(s.Night), Total: roundHours(s.Total)}
+}
+
+type employeeSummary struct {
+    Employee     string `json:"employee"`
+    EmployeeID   string `json:"employee_id,omitempty"`
+    PayPeriodNum int    `json:"pay_period_num"`
+    Year         int    `json:"year"`
+    hourSums
+    expenseSums
+    travelSums
+}
+
+type jobSummary struct {
+    JobNumber string `json:"job_number"`
+    hourSums
+    expenseSums
+    travelSums
+}
+
+// costCodeSummary is one job, phase and cost code (costcodes.go)
+type costCodeSummary struct {
+    JobNumber string `json:"job_number"`
+    Phase     string `json:"phase,omitempty"`
+    CostCode  string `json:"cost_code,omitempty"`
+    hourSums
+}
+
+type labourCodeSummary struct {
+    LabourCode string `json:"labour_code"`
+    hourSums
+}
+
+type summaryReport struct {
+    Cards       int                 `json:"cards"`
+    Totals      hourSums            `json:"totals"`
+    Employees   []employeeSummary   `json:"employees"`
+    Jobs        []jobSummary        `json:"jobs"`
+    LabourCodes []labourCodeSummary `json:"labour_codes"`
+    CostCodes   []costCodeSummary   `json:"cost_codes,omitempty"` // hours with a phase or cost code
+    expenseSums
+    travelSums
+}
+
+// summarize adds up cards, which have had the tenant's defaults applied
+func summarize(t *Tenant, reqs []TimecardRequest) summaryReport {
+    rep := summaryReport{Cards: len(reqs), Employees: []employeeSummary{}}
+    jobs := map[string]*hourSums{}
+    codes := map[string]*hourSums{}
+    jobExpenses := map[string]*expenseSums{}
+    jobTravel := map[string]*travelSums{}
+    costCodes := map[string]*costCodeSummary{}
+    for _, req := range reqs {
+        emp := employeeSummary{Employee: req.EmployeeName, EmployeeID: req.EmployeeID, PayPeriodNum: req.PayPeriodNum, Year: req.Year}
+        for _, l := range hourLines(req, cardLocation(req, t)) {
+            emp.add(l)
+            rep.Totals.add(l)
+            for _, g := range []struct {
+                sums map[string]*hourSums
+                key  string
+            }{{jobs, l.JobNumber}, {codes, l.LabourCode}} {
+                if g.sums[g.key] == nil {
+                    g.sums[g.key] = &hourSums{}
+                }
+                g.sums[g.key].add(l)
+            }
+            if l.Phase != "" || l.CostCode != "" {
+                key := l.JobNumber + costLevelSep + l.Phase + costLevelSep + l.CostCode
+                if costCodes[key] == nil {
+                    costCodes[key] = &costCodeSummary{JobNumber: l.JobNumber, Phase: l.Phase, CostCode: l.CostCode}
+                }
+                costCodes[key].add(l)
+            }
+        }
+        for _, x := range req.Expenses {
+            emp.addExpense(x)
+            rep.addExpense(x)
+            if x.JobCode == "" {
+                continue
+            }
+            if jobs[x.JobCode] == nil {
+                jobs[x.JobCode] = &hourSums{}
+            }
+            if jobExpenses[x.JobCode] == nil {
+                jobExpenses[x.JobCode] = &expenseSums{}
+            }
+            jobExpenses[x.JobCode].addExpense(x)
+        }
+        for _, l := range req.Travel {
+            emp.addTravel(l)
+            rep.addTravel(l)
+            if l.JobCode == "" {
+                continue
+            }
+            if jobs[l.JobCode] == nil {
+                jobs[l.JobCode] = &hourSums{}
+            }
+            if jobTravel[l.JobCode] == nil {
+                jobTravel[l.JobCode] = &travelSums{}
+            }
+            jobTravel[l.JobCode].addTravel(l)
+        }
+        emp.hourSums = emp.rounded()
+        emp.expenseSums = emp.roundedExpenses()
+        emp.travelSums = emp.roundedTravel()
+        rep.Employees = append(rep.Employees, emp)
+    }
+    rep.Totals = rep.Totals.rounded()
+    rep.expenseSums = rep.roundedExpenses()
+    rep.travelSums = rep.roundedTravel()
+    sort.SliceStable(rep.Employees, func(a, b int) bool { return rep.Employees[a].Employee < rep.Employees[b].Employee })
+    rep.Jobs = make([]jobSummary, 0, len(jobs))
+    for _, key := range sortedKeys(jobs) {
+        j := jobSummary{JobNumber: key, hourSums: jobs[key].rounded()}
+        if x := jobExpenses[key]; x != nil {
+            j.expenseSums = x.roundedExpenses()
+        }
+        if x := jobTravel[key]; x != nil {
+            j.travelSums = x.roundedTravel()
+        }
+        rep.Jobs = append(rep.Jobs, j)
+    }
+    rep.LabourCodes = make([]labourCodeSummary, 0, len(codes))
+    for _, key := range sortedKeys(codes) {
+        rep.LabourCodes = append(rep.LabourCodes, labourCodeSummary{LabourCode: key, hourSums: codes[key].rounded()})
+    }
+    for _, key := range sortedKeys(costCodes) {
+        c := *costCodes[key]
+        c.hourSums = c.rounded()
+        rep.CostCodes = append(rep.CostCodes, c)
+    }
+    return rep
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+    keys := make([]string, 0, len(m))
+    for k := range m {
+        keys = append(keys, k)
+    }
+    sort.Strings(keys)
+    return keys
+}
+
+// currentRecords returns the tenant's current revisions that keep accepts
+func currentRecords(t *Tenant, keep func(TimecardRecord) bool) []TimecardRecord {
+    newest := map[string]TimecardRecord{}
+    for _, rec := range timecards.List() {
+        if rec.Tenant != t.ID || !keep(rec) {
+            continue
+        }
+        key := fmt.Sprintf("%s|%d|%d", strings.ToLower(strings.TrimSpace(rec.Employee)), rec.Year, rec.PayPeriodNum)
+        if cur, ok := newest[key]; !ok || laterRevision(rec, cur) {
+            newest[key] = rec
+        }
+    }
+    out := make([]TimecardRecord, 0, len(newest))
+    for _, rec := range newest {
+        out = append(out, rec)
+    }
+    sort.Slice(out, func(a, b int) bool {
+        if out[a].Year != out[b].Year {
+            return out[a].Year < out[b].Year
+        }
+        if out[a].PayPeriodNum != out[b].PayPeriodNum {
+            return out[a].PayPeriodNum < out[b].PayPeriodNum
+        }
+        return out[a].Employee < out[b].Employee
+    })
+    return out
+}
+
+// storedCards returns the requests of currentRecords, with the tenant's
+// defaults applied
+func storedCards(t *Tenant, keep func(TimecardRecord) bool) ([]TimecardRequest, error) {
+    recs := currentRecords(t, keep)
+    reqs := make([]TimecardRequest, 0, len(recs))
+    for _, rec := range recs {
+        req, err := rec.request()
+        if err != nil {
+            return nil, fmt.Errorf("timecard %s: %w", rec.ID, err)
+        }
+        applyTimecardDefaults(&req, t)
+        reqs = append(reqs, req)
+    }
+    return reqs, nil
+}
+
+// queryInts reads required positive integer query parameters
+func queryInts(r *http.Request, names ...string) ([]int, error) {
+    out := make([]int, len(names))
+    for i, name := range names {
+        n, err := strconv.Atoi(r.URL.Query().Get(name))
+        if err != nil || n < 1 {
+            return nil, fmt.Errorf("%s must be a positive number", name)
+        }
+        out[i] = n
+    }
+    return out, nil
+}
+
+// summaryReportHandler serves /api/reports/summary (see the top of this file)
+func summaryReportHandler(w http.ResponseWriter, r *http.Request) {
+    t := tenantFor(r)
+    var reqs []TimecardRequest
+    switch r.Method {
+    case http.MethodPost:
+        var ok bool
+        if reqs, ok = readExportCards(w, r, t); !ok {
+            return
+        }
+    case http.MethodGet:
+        q, err := queryInts(r, "year", "pay_period_num")
+        if err != nil {
+            httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        reqs, err = storedCards(t, func(rec TimecardRecord) bool { return rec.Year == q[0] && rec.PayPeriodNum == q[1] })
+        if err != nil {
+            httpError(w, r, fmt.Sprintf("error reading timecards: %v", err), http.StatusInternalServerError)
+            return
+        }
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    writeJSON(w, http.StatusOK, summarize(t, reqs))
+}
+
+type ytdPeriod struct {
+    PayPeriodNum int    `json:"pay_period_num"`
+    TimecardID   string `json:"timecard_id"`
+    hourSums
+    expenseSums
+}
+
+type ytdJob struct {
+    JobNumber  string `json:"job_number"`
+    LabourCode string `json:"labour_code,omitempty"`
+    hourSums
+    expenseSums
+}
+
+type ytdReport struct {
+    Employee   string      `json:"employee"`
+    EmployeeID string      `json:"employee_id,omitempty"`
+    Year       int         `json:"year"`
+    Periods    []ytdPeriod `json:"periods"`
+    Jobs       []ytdJob    `json:"jobs"`
+    Totals     hourSums    `json:"totals"`
+    expenseSums
+}
+
+// ytdReportHandler serves GET /api/reports/ytd (see the top of this file)
+func ytdReportHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    t := tenantFor(r)
+    q := r.URL.Query()
+    employee, employeeID := strings.TrimSpace(q.Get("employee")), strings.TrimSpace(q.Get("employee_id"))
+    if employee == "" && employeeID == "" {
+        httpError(w, r, "invalid request: employee or employee_id is required", http.StatusBadRequest)
+        return
+    }
+    year := today(t.location()).Year()
+    if cal := t.payCalendar(); cal != nil {
+        if p, err := cal.periodOf(today(t.location())); err == nil {
+            year = p.FiscalYear
+        }
+    }
+    if q.Get("year") != "" {
+        y, err := queryInts(r, "year")
+        if err != nil {
+            httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        year = y[0]
+    }
+
+    recs := currentRecords(t, func(rec TimecardRecord) bool {
+        switch {
+        case rec.Year != year,
+            employeeID != "" && rec.EmployeeID != employeeID,
+            employee != "" && !strings.EqualFold(strings.TrimSpace(rec.Employee), employee):
+            return false
+        }
+        return true
+    })
+
+    rep := ytdReport{Employee: employee, EmployeeID: employeeID, Year: year, Periods: []ytdPeriod{}, Jobs: []ytdJob{}}
+    jobs := map[string]*ytdJob{}
+    for _, rec := range recs {
+        req, err := rec.request()
+        if err != nil {
+            httpError(w, r, fmt.Sprintf("error reading timecard %s: %v", rec.ID, err), http.StatusInternalServerError)
+            return
+        }
+        applyTimecardDefaults(&req, t)
+        rep.Employee = req.EmployeeName
+        period := ytdPeriod{PayPeriodNum: rec.PayPeriodNum, TimecardID: rec.ID}
+        for _, l := range hourLines(req, cardLocation(req, t)) {
+            period.add(l)
+            rep.Totals.add(l)
+            j := jobs[l.JobNumber]
+            if j == nil {
+                j = &ytdJob{JobNumber: l.JobNumber, LabourCode: l.LabourCode}
+                jobs[l.JobNumber] = j
+            }
+            j.add(l)
+        }
+        for _, x := range req.Expenses {
+            period.addExpense(x)
+            rep.addExpense(x)
+            if x.JobCode == "" {
+                continue
+            }
+            j := jobs[x.JobCode]
+            if j == nil {
+                j = &ytdJob{JobNumber: x.JobCode}
+                jobs[x.JobCode] = j
+            }
+            j.addExpense(x)
+        }
+        period.hourSums = period.rounded()
+        period.expenseSums = period.roundedExpenses()
+        rep.Periods = append(rep.Periods, period)
+    }
+    for _, j := range jobs {
+        j.hourSums = j.rounded()
+        j.expenseSums = j.roundedExpenses()
+        rep.Jobs = append(rep.Jobs, *j)
+    }
+    sort.Slice(rep.Jobs, func(a, b int) bool { return rep.Jobs[a].JobNumber < rep.Jobs[b].JobNumber })
+    rep.Totals = rep.Totals.rounded()
+    rep.expenseSums = rep.roundedExpenses()
+    writeJSON(w, http.StatusOK, rep)
+}
+
+// hoursSummaryText is the plain-text summary of a card for an email body:
+// hours per day, regular against overtime, the jobs worked and expenses
+func hoursSummaryText(req TimecardRequest, loc *time.Location) string {
+    var b strings.Builder
+    fmt.Fprintf(&b, "Timecard for %s, pay period %d, %d.\r\n\r\n", req.EmployeeName, req.PayPeriodNum, req.Year)
+    lines := hourLines(req, loc)
+    if len(lines) == 0 {
+        b.WriteString("No hours entered.\r\n")
+        return b.String()
+    }
+
+    hours := func(h float64) string {
+        if h == 0 {
+            return ""
+        }
+        return strconv.FormatFloat(roundHours(h), 'f', 2, 64)
+    }
+    row := func(label string, s hourSums) {
+        line := fmt.Sprintf("%-16s %8s %9s %6s", label, hours(s.Regular), hours(s.Overtime), hours(s.Night))
+        b.WriteString(strings.TrimRight(line, " ") + "\r\n")
+    }
+    fmt.Fprintf(&b, "%-16s %8s %9s %6s\r\n", "Day", "Regular", "Overtime", "Night")
+    var day, total hourSums
+    var jobs []string
+    seen := map[string]bool{}
+    for i, l := range lines {
+        day.add(l)
+        total.add(l)
+        if !seen[l.JobNumber] {
+            seen[l.JobNumber] = true
+            job := l.JobNumber
+            if l.LabourCode != "" {
+                job += " (" + l.LabourCode + ")"
+            }
+            jobs = append(jobs, job)
+        }
+        if i == len(lines)-1 || !lines[i+1].Date.Equal(l.Date) {
+            row(l.Date.Format("Mon 2006-01-02"), day)
+            day = hourSums{}
+        }
+    }
+    row("Total", total)
+    fmt.Fprintf(&b, "\r\nJobs: %s\r\n", strings.Join(jobs, ", "))
+    if len(req.Expenses) > 0 {
+        var x expenseSums
+        for _, e := range req.Expenses {
+            x.addExpense(e)
+        }
+        var kinds []string
+        for _, kind := range expenseTypes {
+            if a, ok := x.Expenses[kind]; ok {
+                kinds = append(kinds, fmt.Sprintf("%s %.2f", expenseTypeLabel(kind, false), a))
+            }
+        }
+        fmt.Fprintf(&b, "Expenses: %.2f (%s)\r\n", x.ExpensesTotal, strings.Join(kinds, ", "))
+    }
+    if len(req.Travel) > 0 {
+        b.WriteString(travelSummaryLine(req) + "\r\n")
+    }
+    return b.String()
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "regexp"
+)
+
+/* ===========
+   Request IDs
+   =========== */
+
+type ctxKey int
+
+const requestIDKey ctxKey = iota
+
+// honor client IDs only when they look sane enough to put in logs and headers
+var requestIDPattern = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+
+// requestIDMiddleware assigns every call an ID (or adopts X-Request-ID),
+// echoes it back in the response header and stores it on the context.
+func requestIDMiddleware(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        id := r.Header.Get("X-Request-ID")
+        if !requestIDPattern.MatchString(id) {
+            id = newID()
+        }
+        w.Header().Set("X-Request-ID", id)
+        next(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+    }
+}
+
+func requestIDFrom(ctx context.Context) string {
+    id, _ := ctx.Value(requestIDKey).(string)
+    return id
+}
+
+// requestLogger prefixes every line with the request ID. A nil logger
+// (background work, imports) logs without a prefix.
+type requestLogger struct {
+    ID string
+}
+
+func loggerFrom(ctx context.Context) *requestLogger {
+    return &requestLogger{ID: requestIDFrom(ctx)}
+}
+
+func (l *requestLogger) Printf(format string, args ...interface{}) {
+    if l == nil || l.ID == "" {
+        _ = log.Output(2, fmt.Sprintf(format, args...))
+        return
+    }
+    _ = log.Output(2, "["+l.ID+"] "+fmt.Sprintf(format, args...))
+}
+
+func (l *requestLogger) requestID() string {
+    if l == nil {
+        return ""
+    }
+    return l.ID
+}
+
+// httpError is http.Error with the request ID appended, so a user's
+// screenshot of an error is enough to find the matching log lines.
+func httpError(w http.ResponseWriter, r *http.Request, msg string, status int) {
+    if status >= 500 {
+        noteError(r.Context(), msg)
+    }
+    if status >= 400 {
+        finishProgress(r.Context(), fmt.Errorf("%s", msg))
+        auditError(r.Context(), msg)
+    }
+    if id := requestIDFrom(r.Context()); id != "" {
+        msg = fmt.Sprintf("%s (request_id=%s)", msg, id)
+    }
+    http.Error(w, msg, status)
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ==========
+   REST hooks
+   ========== */
+
+// Zapier (and anything else speaking its REST Hooks protocol) subscribes
+// to events itself instead of an operator editing WEBHOOK_URLS:
+//
+//   POST   /api/hooks             {"target_url": "...", "event": "email.sent"}  → 201 {"id": ...}
+//   GET    /api/hooks             the tenant's subscriptions
+//   DELETE /api/hooks/{id}
+//   GET    /api/hooks/sample?event=email.sent   recent payloads, for the Zap editor
+//
+// Callers authenticate with a key from HOOK_API_KEYS ("name:key" pairs, as
+// ADMIN_API_KEYS) and subscribe for the tenant named by X-Tenant-ID. Each
+// matching event is POSTed to target_url as one flat hookPayload; a 410 Gone
+// answer removes the subscription, as the protocol asks.
+
+// hookEventTypes are the events a hook can subscribe to
+var hookEventTypes = []string{"timecard.generated", "email.sent", "email.failed", "timecard.approved", "timecard.rejected"}
+
+type HookSubscription struct {
+    ID        string    `json:"id"`
+    Tenant    string    `json:"tenant"`
+    Event     string    `json:"event"`
+    TargetURL string    `json:"target_url"`
+    CreatedBy string    `json:"created_by,omitempty"`
+    CreatedAt time.Time `json:"created_at"`
+}
+
+// hookPayload is what a hook receives. Its fields are fixed (empty rather
+// than missing when they don't apply), so a Zap mapped against one event
+// keeps working; new fields may be added, none removed or renamed.
+type hookPayload struct {
+    ID            string    `json:"id"`
+    Event         string    `json:"event"`
+    OccurredAt    time.Time `json:"occurred_at"`
+    Tenant        string    `json:"tenant"`
+    TimecardID    string    `json:"timecard_id"`
+    Employee      string    `json:"employee"`
+    PayPeriodNum  int       `json:"pay_period_num"`
+    Year          int       `json:"year"`
+    Format        string    `json:"format"`
+    RegularHours  float64   `json:"regular_hours"`
+    OvertimeHours float64   `json:"overtime_hours"`
+    TotalHours    float64   `json:"total_hours"`
+    To            string    `json:"to"`
+    CC            string    `json:"cc"`
+    Error         string    `json:"error"`
+    Comment       string    `json:"comment"`
+}
+
+// newHookPayload flattens an event's data into the hook schema
+func newHookPayload(ev webhookEvent) hookPayload {
+    data, _ := ev.Data.(map[string]interface{})
+    str := func(k string) string { s, _ := data[k].(string); return s }
+    num := func(k string) float64 {
+        switch v := data[k].(type) {
+        case float64:
+            return v
+        case int:
+            return float64(v)
+        }
+        return 0
+    }
+    return hookPayload{
+        ID:            ev.ID,
+        Event:         ev.Type,
+        OccurredAt:    ev.CreatedAt,
+        Tenant:        ev.Tenant,
+        TimecardID:    str("timecard_id"),
+        Employee:      str("employee"),
+        PayPeriodNum:  int(num("pay_period_num")),
+        Year:          int(num("year")),
+        Format:        str("format"),
+        RegularHours:  num("regular_hours"),
+        OvertimeHours: num("overtime_hours"),
+        TotalHours:    num("total_hours"),
+        To:            str("to"),
+        CC:            str("cc"),
+        Error:         str("error"),
+        Comment:       str("comment"),
+    }
+}
+
+// hookSender delivers events to the subscriptions in subs. Queued
+// deliveries hold on to the sender they were queued for, so a test can give
+// the handlers a sender (and store) of its own without racing them.
+type hookSender struct {
+    client *http.Client
+    subs   *collection[HookSubscription]
+
+    mu     sync.Mutex
+    recent map[string][]hookPayload // by tenant and event, newest first
+}
+
+func newHookSender(subs *collection[HookSubscription]) *hookSender {
+    return &hookSender{
+        client: &http.Client{Timeout: 10 * time.Second},
+        subs:   subs,
+        recent: map[string][]hookPayload{},
+    }
+}
+
+var theHookSender = newHookSender(openCollection[HookSubscription]("hook_subscriptions"))
+
+func init() {
+    subscribeEvents(queuedSubscriber("hook", theHookSender.send))
+}
+
+// send delivers ev to each subscription for its tenant and type
+func (h *hookSender) send(ev webhookEvent) error {
+    if !isHookEvent(ev.Type) {
+        return nil
+    }
+    p := newHookPayload(ev)
+    h.remember(p)
+    body, err := json.Marshal(p)
+    if err != nil {
+        return err
+    }
+    for _, sub := range h.subs.List() {
+        if sub.Tenant != ev.Tenant || sub.Event != ev.Type {
+            continue
+        }
+        if err := h.post(sub, ev, body); err != nil {
+            statWebhooksFailed.Add(1)
+            log.Printf("hook %s %s to %s: %v", ev.Type, ev.ID, sub.TargetURL, err)
+            continue
+        }
+        statWebhooksSent.Add(1)
+    }
+    return nil
+}
+
+func (h *hookSender) post(sub HookSubscription, ev webhookEvent, body []byte) error {
+    req, err := http.NewRequest(http.MethodPost, sub.TargetURL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "timecard-api-hooks")
+    req.Header.Set("X-Timecard-Event", ev.Type)
+    req.Header.Set("X-Timecard-Delivery", ev.ID)
+    resp, err := h.client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    switch {
+    case resp.StatusCode == http.StatusGone:
+        log.Printf("hook %s answered 410 Gone, unsubscribing %s", sub.TargetURL, sub.ID)
+        return h.subs.Delete(sub.ID)
+    case resp.StatusCode < 200 || resp.StatusCode >= 300:
+        return fmt.Errorf("receiver returned %s", resp.Status)
+    }
+    return nil
+}
+
+// remember keeps the last few payloads of each kind for /api/hooks/sample
+func (h *hookSender) remember(p hookPayload) {
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    key := p.Tenant + "\x00" + p.Event
+    list := append([]hookPayload{p}, h.recent[key]...)
+    if len(list) > 3 {
+        list = list[:3]
+    }
+    h.recent[key] = list
+}
+
+// samples returns recent payloads for tenant and event, or a made-up one
+// when nothing has happened yet, since Zapier needs something to map
+func (h *hookSender) samples(tenant, event string) []hookPayload {
+    h.mu.Lock()
+    list := h.recent[tenant+"\x00"+event]
+    h.mu.Unlock()
+    if len(list) > 0 {
+        return list
+    }
+    p := hookPayload{
+        ID:            "sample",
+        Event:         event,
+        OccurredAt:    now().UTC(),
+        Tenant:        tenant,
+        TimecardID:    "0123456789abcdef",
+        Employee:      "Sample Employee",
+        PayPeriodNum:  1,
+        Year:          now().Year(),
+        Format:        "xlsx",
+        RegularHours:  40,
+        OvertimeHours: 2,
+        TotalHours:    42,
+    }
+    if strings.HasPrefix(event, "email.") {
+        p.To = "payroll@example.com"
+    }
+    if event == "email.failed" {
+        p.Error = "smtp: connection refused"
+    }
+    return []hookPayload{p}
+}
+
+func isHookEvent(typ string) bool {
+    for _, t := range hookEventTypes {
+        if t == typ {
+            return true
+        }
+    }
+    return false
+}
+
+// hookAuth admits callers holding a key from HOOK_API_KEYS
+func hookAuth(next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        keys := parseAPIKeys(os.Getenv("HOOK_API_KEYS"))
+        presented := presentedKey(r)
+        if len(keys) == 0 || presented == "" {
+            w.Header().Set("WWW-Authenticate", `Bearer realm="timecard-hooks"`)
+            httpError(w, r, "unauthorized", http.StatusUnauthorized)
+            return
+        }
+        k, ok := matchKey(keys, presented)
+        if !ok {
+            httpError(w, r, "forbidden", http.StatusForbidden)
+            return
+        }
+        identity := "hooks:" + k.Name
+        setAccessIdentity(r.Context(), identity)
+        next(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, identity)))
+    }
+}
+
+// hooksHandler serves /api/hooks (see the top of this file)
+func hooksHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/hooks"), "/")
+    tenant := tenantFor(r).ID
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        out := []HookSubscription{}
+        for _, s := range theHookSender.subs.List() {
+            if s.Tenant == tenant {
+                out = append(out, s)
+            }
+        }
+        writeJSON(w, http.StatusOK, out)
+    case rest == "" && r.Method == http.MethodPost:
+        subscribeHook(w, r, tenant)
+    case rest == "sample" && r.Method == http.MethodGet:
+        event := r.URL.Query().Get("event")
+        if !isHookEvent(event) {
+            httpError(w, r, fmt.Sprintf("invalid request: event must be one of %s", strings.Join(hookEventTypes, ", ")), http.StatusBadRequest)
+            return
+        }
+        writeJSON(w, http.StatusOK, theHookSender.samples(tenant, event))
+    case !strings.Contains(rest, "/") && r.Method == http.MethodDelete:
+        sub, ok := theHookSender.subs.Get(rest)
+        if !ok || sub.Tenant != tenant {
+            httpError(w, r, "subscription not found", http.StatusNotFound)
+            return
+        }
+        if err := theHookSender.subs.Delete(sub.ID); err != nil {
+            httpError(w, r, fmt.Sprintf("error deleting subscription: %v", err), http.StatusInternalServerError)
+            return
+        }
+        loggerFrom(r.Context()).Printf("Hook %s unsubscribed from %s", sub.ID, sub.Event)
+        w.WriteHeader(http.StatusNoContent)
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func subscribeHook(w http.ResponseWriter, r *http.Request, tenant string) {
+    var in struct {
+        TargetURL string `json:"target_url"`
+        Event     string `json:"event"`
+    }
+    if err := json.NewDecoder(r.Body).Decode(&in); err != nil {
+        httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+        return
+    }
+    if !isHookEvent(in.Event) {
+        httpError(w, r, fmt.Sprintf("invalid request: event must be one of %s", strings.Join(hookEventTypes, ", ")), http.StatusBadRequest)
+        return
+    }
+    u, err := url.Parse(in.TargetURL)
+    if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+        httpError(w, r, "invalid request: target_url must be an http(s) URL", http.StatusBadRequest)
+        return
+    }
+
+    sub := HookSubscription{
+        ID:        newID(),
+        Tenant:    tenant,
+        Event:     in.Event,
+        TargetURL: in.TargetURL,
+        CreatedBy: identityFrom(r.Context()),
+        CreatedAt: now().UTC(),
+    }
+    if err := theHookSender.subs.Put(sub.ID, sub); err != nil {
+        httpError(w, r, fmt.Sprintf("error saving subscription: %v", err), http.StatusInternalServerError)
+        return
+    }
+    loggerFrom(r.Context()).Printf("Hook %s subscribed to %s for %s", sub.ID, sub.Event, sub.TargetURL)
+    writeJSON(w, http.StatusCreated, sub)
+}
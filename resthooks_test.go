@@ -0,0 +1,168 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "net/http/httptest"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+// withHookSubscriptions gives the test a subscription store of its own and
+// a hook key, "zap-key"
+func withHookSubscriptions(t *testing.T) {
+    t.Helper()
+    t.Setenv("DATA_DIR", t.TempDir())
+    t.Setenv("HOOK_API_KEYS", "zapier:zap-key")
+    saved := theHookSender
+    theHookSender = newHookSender(openCollection[HookSubscription]("hook_subscriptions"))
+    t.Cleanup(func() { theHookSender = saved })
+    withTenants(t, `{"acme": {}, "globex": {}}`)
+}
+
+// callHooks sends a request to /api/hooks as the zapier key for tenant
+func callHooks(t *testing.T, method, path, tenant, body string) *httptest.ResponseRecorder {
+    t.Helper()
+    r := httptest.NewRequest(method, path, strings.NewReader(body))
+    r.Header.Set("Authorization", "Bearer zap-key")
+    r.Header.Set("X-Tenant-ID", tenant)
+    w := httptest.NewRecorder()
+    hookAuth(hooksHandler)(w, r)
+    return w
+}
+
+// hookTarget records the payloads POSTed to it and answers with status
+type hookTarget struct {
+    mu       sync.Mutex
+    status   int
+    payloads []hookPayload
+}
+
+func (h *hookTarget) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    var p hookPayload
+    _ = json.NewDecoder(r.Body).Decode(&p)
+    h.mu.Lock()
+    defer h.mu.Unlock()
+    h.payloads = append(h.payloads, p)
+    if h.status != 0 {
+        w.WriteHeader(h.status)
+    }
+}
+
+func TestHookAuth(t *testing.T) {
+    withHookSubscriptions(t)
+    t.Setenv("ADMIN_API_KEYS", "ops:admin-key")
+    tests := []struct {
+        name, header string
+        want         int
+    }{
+        {"no key", "", http.StatusUnauthorized},
+        {"wrong key", "Bearer nope", http.StatusForbidden},
+        {"admin key", "Bearer admin-key", http.StatusForbidden},
+        {"hook key", "Bearer zap-key", http.StatusOK},
+    }
+    for _, tt := range tests {
+        r := httptest.NewRequest(http.MethodGet, "/api/hooks", nil)
+        r.Header.Set("Authorization", tt.header)
+        w := httptest.NewRecorder()
+        hookAuth(hooksHandler)(w, r)
+        if w.Code != tt.want {
+            t.Errorf("%s: %d, want %d", tt.name, w.Code, tt.want)
+        }
+    }
+}
+
+func TestHookSubscriptions(t *testing.T) {
+    withHookSubscriptions(t)
+    target := &hookTarget{}
+    ts := httptest.NewServer(target)
+    defer ts.Close()
+
+    w := callHooks(t, http.MethodPost, "/api/hooks", "acme", `{"target_url": "`+ts.URL+`", "event": "email.sent"}`)
+    if w.Code != http.StatusCreated {
+        t.Fatalf("subscribe: %d %s", w.Code, w.Body)
+    }
+    var sub HookSubscription
+    _ = json.Unmarshal(w.Body.Bytes(), &sub)
+    if sub.ID == "" || sub.Tenant != "acme" || sub.CreatedBy != "hooks:zapier" {
+        t.Errorf("subscription = %+v", sub)
+    }
+    if w := callHooks(t, http.MethodGet, "/api/hooks", "acme", ""); !strings.Contains(w.Body.String(), sub.ID) {
+        t.Errorf("acme's list: %s", w.Body)
+    }
+    if w := callHooks(t, http.MethodGet, "/api/hooks", "globex", ""); strings.TrimSpace(w.Body.String()) != "[]" {
+        t.Errorf("globex's list: %s", w.Body)
+    }
+
+    // only acme's email.sent events reach the target
+    data := map[string]interface{}{"timecard_id": "abc", "employee": "Bob Smith", "pay_period_num": 3, "year": 2025,
+        "format": "xlsx", "regular_hours": 40.0, "overtime_hours": 1.5, "total_hours": 41.5, "to": "payroll@example.com"}
+    at := time.Date(2025, 1, 13, 9, 30, 0, 0, time.UTC)
+    for _, ev := range []webhookEvent{
+        {ID: "1", Type: "email.sent", Tenant: "acme", CreatedAt: at, Data: data},
+        {ID: "2", Type: "email.sent", Tenant: "globex", CreatedAt: at, Data: data},
+        {ID: "3", Type: "timecard.generated", Tenant: "acme", CreatedAt: at, Data: data},
+        {ID: "4", Type: "conversion.failed", Tenant: "acme", CreatedAt: at, Data: data},
+    } {
+        if err := theHookSender.send(ev); err != nil {
+            t.Fatal(err)
+        }
+    }
+    target.mu.Lock()
+    got := target.payloads
+    target.mu.Unlock()
+    want := hookPayload{ID: "1", Event: "email.sent", OccurredAt: at, Tenant: "acme", TimecardID: "abc", Employee: "Bob Smith",
+        PayPeriodNum: 3, Year: 2025, Format: "xlsx", RegularHours: 40, OvertimeHours: 1.5, TotalHours: 41.5, To: "payroll@example.com"}
+    if len(got) != 1 || got[0] != want {
+        t.Fatalf("delivered %+v, want %+v", got, want)
+    }
+
+    // what was sent is the sample now
+    w = callHooks(t, http.MethodGet, "/api/hooks/sample?event=email.sent", "acme", "")
+    var samples []hookPayload
+    if err := json.Unmarshal(w.Body.Bytes(), &samples); err != nil || len(samples) != 1 || samples[0] != want {
+        t.Errorf("samples = %s", w.Body)
+    }
+
+    // 410 Gone unsubscribes
+    target.status = http.StatusGone
+    _ = theHookSender.send(webhookEvent{ID: "5", Type: "email.sent", Tenant: "acme", Data: data})
+    if _, ok := theHookSender.subs.Get(sub.ID); ok {
+        t.Error("still subscribed after 410 Gone")
+    }
+}
+
+func TestHookSubscriptionRequests(t *testing.T) {
+    withHookSubscriptions(t)
+    tests := []struct {
+        name, method, path, tenant, body string
+        want                             int
+    }{
+        {"unknown event", http.MethodPost, "/api/hooks", "acme", `{"target_url": "https://example.com/h", "event": "card.lost"}`, http.StatusBadRequest},
+        {"not a URL", http.MethodPost, "/api/hooks", "acme", `{"target_url": "example.com/h", "event": "email.sent"}`, http.StatusBadRequest},
+        {"not http", http.MethodPost, "/api/hooks", "acme", `{"target_url": "ftp://example.com/h", "event": "email.sent"}`, http.StatusBadRequest},
+        {"not JSON", http.MethodPost, "/api/hooks", "acme", `target_url=x`, http.StatusBadRequest},
+        {"sample of an unknown event", http.MethodGet, "/api/hooks/sample?event=card.lost", "acme", "", http.StatusBadRequest},
+        {"made-up sample", http.MethodGet, "/api/hooks/sample?event=email.failed", "acme", "", http.StatusOK},
+        {"unsubscribe unknown", http.MethodDelete, "/api/hooks/nope", "acme", "", http.StatusNotFound},
+        {"wrong method", http.MethodPut, "/api/hooks", "acme", "", http.StatusMethodNotAllowed},
+    }
+    for _, tt := range tests {
+        if w := callHooks(t, tt.method, tt.path, tt.tenant, tt.body); w.Code != tt.want {
+            t.Errorf("%s: %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+        }
+    }
+
+    // another tenant's subscription can't be removed
+    w := callHooks(t, http.MethodPost, "/api/hooks", "acme", `{"target_url": "https://example.com/h", "event": "email.sent"}`)
+    var sub HookSubscription
+    _ = json.Unmarshal(w.Body.Bytes(), &sub)
+    if w := callHooks(t, http.MethodDelete, "/api/hooks/"+sub.ID, "globex", ""); w.Code != http.StatusNotFound {
+        t.Errorf("globex unsubscribing acme's hook: %d", w.Code)
+    }
+    if w := callHooks(t, http.MethodDelete, "/api/hooks/"+sub.ID, "acme", ""); w.Code != http.StatusNoContent {
+        t.Errorf("unsubscribe: %d %s", w.Code, w.Body)
+    }
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+    "net/http"
+    "sort"
+    "strings"
+    "time"
+)
+
+/* ==================
+   Timecard revisions
+   ================== */
+
+// Every card kept for the same tenant, employee and pay period is a
+// revision of one timecard rather than an anonymous overwrite: a new card
+// (or a resubmission) gets the next revision number, links the record it
+// supersedes, and has "Rev. N" printed on its sheet from revision 2 on, so
+// payroll can tell which copy is current. The history is at
+//
+//   GET /api/timecards/{id}/revisions
+//
+// and GET /api/timecards?current=1 lists only current revisions.
+
+// revisionView is one entry of a revision history
+type revisionView struct {
+    ID         string    `json:"id"`
+    Revision   int       `json:"revision"`
+    Kind       string    `json:"kind"`
+    Supersedes string    `json:"supersedes,omitempty"`
+    Approval   string    `json:"approval,omitempty"`
+    CreatedAt  time.Time `json:"created_at"`
+    Current    bool      `json:"current"`
+}
+
+// sameTimecard reports whether a record is a revision of the employee's
+// timecard. Employees are told apart by employee ID or payroll number
+// (employeeRef) when both cards have one, else by name.
+func sameTimecard(a TimecardRecord, tenant, employee, ref string, year, period int) bool {
+    if a.Tenant != tenant || a.Year != year || a.PayPeriodNum != period {
+        return false
+    }
+    if own := a.employeeRef(); own != "" && ref != "" {
+        return own == ref
+    }
+    return strings.EqualFold(strings.TrimSpace(a.Employee), strings.TrimSpace(employee))
+}
+
+// laterRevision orders revisions: higher number first, then newer
+func laterRevision(a, b TimecardRecord) bool {
+    if a.revision() != b.revision() {
+        return a.revision() > b.revision()
+    }
+    return a.CreatedAt.After(b.CreatedAt)
+}
+
+// revisionsOf returns every revision of a timecard, oldest first
+func revisionsOf(tenant, employee, ref string, year, period int) []TimecardRecord {
+    var out []TimecardRecord
+    for _, rec := range timecards.List() {
+        if sameTimecard(rec, tenant, employee, ref, year, period) {
+            out = append(out, rec)
+        }
+    }
+    sort.Slice(out, func(i, j int) bool { return laterRevision(out[j], out[i]) })
+    return out
+}
+
+// latestRevision returns the current revision of a timecard, if any is kept
+func latestRevision(tenant, employee, ref string, year, period int) (TimecardRecord, bool) {
+    revs := revisionsOf(tenant, employee, ref, year, period)
+    if len(revs) == 0 {
+        return TimecardRecord{}, false
+    }
+    return revs[len(revs)-1], true
+}
+
+// isCurrent reports whether rec is its timecard's latest revision
+func (rec TimecardRecord) isCurrent() bool {
+    latest, ok := latestRevision(rec.Tenant, rec.Employee, rec.employeeRef(), rec.Year, rec.PayPeriodNum)
+    return !ok || latest.ID == rec.ID
+}
+
+// assignRevision numbers a new card after the revisions already kept
+func assignRevision(req *TimecardRequest, t *Tenant) {
+    if req.Revision != 0 || req.EmployeeName == "" || req.PayPeriodNum == 0 {
+        return
+    }
+    if prev, ok := latestRevision(t.ID, req.EmployeeName, req.employeeRef(), req.Year, req.PayPeriodNum); ok {
+        req.Revision = prev.revision() + 1
+    }
+}
+
+func timecardRevisions(w http.ResponseWriter, r *http.Request, id string) {
+    rec, ok := timecards.Get(id)
+    if !ok {
+        httpError(w, r, "timecard not found", http.StatusNotFound)
+        return
+    }
+    revs := revisionsOf(rec.Tenant, rec.Employee, rec.employeeRef(), rec.Year, rec.PayPeriodNum)
+    out := make([]revisionView, 0, len(revs))
+    for i, rv := range revs {
+        v := revisionView{
+            ID:         rv.ID,
+            Revision:   rv.revision(),
+            Kind:       rv.Kind,
+            Supersedes: rv.Supersedes,
+            CreatedAt:  rv.CreatedAt,
+            Current:    i == len(revs)-1,
+        }
+        if rv.Approval != nil {
+            v.Approval = rv.Approval.Status
+        }
+        out = append(out, v)
+    }
+    writeJSON(w, http.StatusOK, out)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "sort"
+    "strings"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ============
+   Crew roll-up
+   ============ */
+
+// Project managers review the whole crew at once:
+//
+//   GET  /api/reports/rollup?year=&pay_period_num=&layout=matrix|sheets
+//   POST /api/reports/rollup?layout=matrix|sheets      one card, or a JSON array of cards
+//
+// returns one workbook. Its "Crew" sheet is the matrix: a row per employee,
+// a column per job number (headed with its labour code) and the regular,
+// overtime, night and total hours. layout=sheets (the default) adds a sheet
+// per employee with their hours day by day. Stored cards count at their
+// current revision.
+
+const rollupMatrixSheet = "Crew"
+
+// rollupReportHandler serves /api/reports/rollup (see the top of this file)
+func rollupReportHandler(w http.ResponseWriter, r *http.Request) {
+    t := tenantFor(r)
+    layout := r.URL.Query().Get("layout")
+    switch layout {
+    case "":
+        layout = "sheets"
+    case "matrix", "sheets":
+    default:
+        httpError(w, r, "invalid request: layout must be matrix or sheets", http.StatusBadRequest)
+        return
+    }
+
+    var reqs []TimecardRequest
+    name := "crew_rollup.xlsx"
+    switch r.Method {
+    case http.MethodPost:
+        var ok bool
+        if reqs, ok = readExportCards(w, r, t); !ok {
+            return
+        }
+        if len(reqs) > 0 {
+            name = fmt.Sprintf("crew_rollup_PP%02d_%d.xlsx", reqs[0].PayPeriodNum, reqs[0].Year)
+        }
+    case http.MethodGet:
+        q, err := queryInts(r, "year", "pay_period_num")
+        if err != nil {
+            httpError(w, r, "invalid request: "+err.Error(), http.StatusBadRequest)
+            return
+        }
+        reqs, err = storedCards(t, func(rec TimecardRecord) bool { return rec.Year == q[0] && rec.PayPeriodNum == q[1] })
+        if err != nil {
+            httpError(w, r, fmt.Sprintf("error reading timecards: %v", err), http.StatusInternalServerError)
+            return
+        }
+        if len(reqs) == 0 {
+            httpError(w, r, "no timecards for that pay period", http.StatusNotFound)
+            return
+        }
+        name = fmt.Sprintf("crew_rollup_PP%02d_%d.xlsx", q[1], q[0])
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    sort.SliceStable(reqs, func(a, b int) bool { return reqs[a].EmployeeName < reqs[b].EmployeeName })
+
+    data, err := rollupWorkbook(t, reqs, layout == "sheets")
+    if err != nil {
+        httpError(w, r, fmt.Sprintf("error building roll-up: %v", err), http.StatusInternalServerError)
+        return
+    }
+    w.Header().Set("Content-Type", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet")
+    w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", name))
+    w.WriteHeader(http.StatusOK)
+    _, _ = w.Write(data)
+    loggerFrom(r.Context()).Printf("OK: crew roll-up of %d timecard(s), %d bytes", len(reqs), len(data))
+}
+
+// rollupWorkbook builds the roll-up of reqs, with a sheet per employee
+// when perEmployee is set
+func rollupWorkbook(t *Tenant, reqs []TimecardRequest, perEmployee bool) ([]byte, error) {
+    f := excelize.NewFile()
+    defer func() { _ = f.Close() }()
+    if err := f.SetSheetName("Sheet1", rollupMatrixSheet); err != nil {
+        return nil, err
+    }
+    bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+    if err != nil {
+        return nil, err
+    }
+    hours, err := f.NewStyle(&excelize.Style{NumFmt: 2}) // 0.00
+    if err != nil {
+        return nil, err
+    }
+
+    lines := make([][]hourLine, len(reqs))
+    jobCodes := map[string]string{}
+    for i, req := range reqs {
+        lines[i] = hourLines(req, cardLocation(req, t))
+        for _, l := range lines[i] {
+            if _, ok := jobCodes[l.JobNumber]; !ok || jobCodes[l.JobNumber] == "" {
+                jobCodes[l.JobNumber] = l.LabourCode
+            }
+        }
+    }
+    jobs := make([]string, 0, len(jobCodes))
+    for j := range jobCodes {
+        jobs = append(jobs, j)
+    }
+    sort.Strings(jobs)
+
+    // The matrix: employees down, jobs across
+    header := []interface{}{"Employee", "Pay period"}
+    for _, j := range jobs {
+        label := j
+        if jobCodes[j] != "" {
+            label = fmt.Sprintf("%s (%s)", j, jobCodes[j])
+        }
+        header = append(header, label)
+    }
+    header = append(header, "Regular", "Overtime", "Night", "Total")
+    if err := f.SetSheetRow(rollupMatrixSheet, "A1", &header); err != nil {
+        return nil, err
+    }
+    var crew hourSums
+    crewJobs := make([]float64, len(jobs))
+    for i, req := range reqs {
+        var sums hourSums
+        perJob := make([]float64, len(jobs))
+        for _, l := range lines[i] {
+            sums.add(l)
+            perJob[sort.SearchStrings(jobs, l.JobNumber)] += l.Hours
+        }
+        row := []interface{}{req.EmployeeName, fmt.Sprintf("PP%02d %d", req.PayPeriodNum, req.Year)}
+        for k, h := range perJob {
+            crewJobs[k] += h
+            row = append(row, rollupCell(h))
+        }
+        crew.merge(sums)
+        sums = sums.rounded()
+        row = append(row, sums.Regular, sums.Overtime, sums.Night, sums.Total)
+        if err := f.SetSheetRow(rollupMatrixSheet, fmt.Sprintf("A%d", i+2), &row); err != nil {
+            return nil, err
+        }
+    }
+    total := []interface{}{"Total", nil}
+    for _, h := range crewJobs {
+        total = append(total, rollupCell(h))
+    }
+    crew = crew.rounded()
+    total = append(total, crew.Regular, crew.Overtime, crew.Night, crew.Total)
+    last := len(reqs) + 2
+    if err := f.SetSheetRow(rollupMatrixSheet, fmt.Sprintf("A%d", last), &total); err != nil {
+        return nil, err
+    }
+    lastCol, _ := excelize.ColumnNumberToName(len(header))
+    _ = f.SetCellStyle(rollupMatrixSheet, "C2", fmt.Sprintf("%s%d", lastCol, last), hours)
+    _ = f.SetCellStyle(rollupMatrixSheet, "A1", lastCol+"1", bold)
+    _ = f.SetCellStyle(rollupMatrixSheet, fmt.Sprintf("A%d", last), fmt.Sprintf("A%d", last), bold)
+    _ = f.SetColWidth(rollupMatrixSheet, "A", "A", 24)
+    _ = f.SetColWidth(rollupMatrixSheet, "B", lastCol, 14)
+    _ = f.SetPanes(rollupMatrixSheet, &excelize.Panes{Freeze: true, XSplit: 2, YSplit: 1, TopLeftCell: "C2", ActivePane: "bottomRight"})
+
+    if perEmployee {
+        used := map[string]bool{strings.ToLower(rollupMatrixSheet): true}
+        for i, req := range reqs {
+            sheet := rollupSheetName(req.EmployeeName, used)
+            if _, err := f.NewSheet(sheet); err != nil {
+                return nil, err
+            }
+            if err := fillRollupSheet(f, sheet, lines[i], bold, hours); err != nil {
+                return nil, err
+            }
+        }
+    }
+
+    buf, err := f.WriteToBuffer()
+    if err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// fillRollupSheet lists one employee's hours day by day
+func fillRollupSheet(f *excelize.File, sheet string, lines []hourLine, bold, hours int) error {
+    header := []interface{}{"Date", "Job number", "Labour code", "Regular", "Overtime", "Night"}
+    if err := f.SetSheetRow(sheet, "A1", &header); err != nil {
+        return err
+    }
+    var sums hourSums
+    for i, l := range lines {
+        sums.add(l)
+        row := []interface{}{l.Date.Format("Mon 2006-01-02"), l.JobNumber, l.code(), nil, nil, nil}
+        if l.Overtime {
+            row[4] = l.Hours
+        } else {
+            row[3] = l.Hours
+        }
+        if l.Night {
+            row[5] = l.Hours
+        }
+        if err := f.SetSheetRow(sheet, fmt.Sprintf("A%d", i+2), &row); err != nil {
+            return err
+        }
+    }
+    sums = sums.rounded()
+    last := len(lines) + 2
+    total := []interface{}{"Total", nil, nil, sums.Regular, sums.Overtime, sums.Night}
+    if err := f.SetSheetRow(sheet, fmt.Sprintf("A%d", last), &total); err != nil {
+        return err
+    }
+    _ = f.SetCellStyle(sheet, "D2", fmt.Sprintf("F%d", last), hours)
+    _ = f.SetCellStyle(sheet, "A1", "F1", bold)
+    _ = f.SetCellStyle(sheet, fmt.Sprintf("A%d", last), fmt.Sprintf("A%d", last), bold)
+    _ = f.SetColWidth(sheet, "A", "A", 16)
+    _ = f.SetColWidth(sheet, "B", "F", 12)
+    return nil
+}
+
+// rollupCell leaves a job an employee didn't work on blank
+func rollupCell(h float64) interface{} {
+    if h == 0 {
+        return nil
+    }
+    return roundHours(h)
+}
+
+// rollupSheetName is a sheet name for employee that Excel accepts and that
+// is not yet in used
+func rollupSheetName(employee string, used map[string]bool) string {
+    name := strings.Map(func(r rune) rune {
+        if strings.ContainsRune(`:\/?*[]`, r) {
+            return '_'
+        }
+        return r
+    }, strings.TrimSpace(employee))
+    if name == "" {
+        name = "Employee"
+    }
+    if len([]rune(name)) > 28 {
+        name = string([]rune(name)[:28])
+    }
+    base := name
+    for n := 2; used[strings.ToLower(name)]; n++ {
+        name = fmt.Sprintf("%s %d", base, n)
+    }
+    used[strings.ToLower(name)] = true
+    return name
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+    "bytes"
+    "encoding/csv"
+    "fmt"
+)
+
+/* ===================
+   Sage 300 CRE export
+   =================== */
+
+// The "sage" export writes cards as a Sage 300 Construction and Real
+// Estate payroll time-entry import, one line per day, job and pay type:
+//
+//   Employee, Date, Job, Extra, Cost Code, Category, Pay ID, Hours
+//
+// (no header line; the Sage import setup names the columns). The job number
+// is the Job and the labour code the Cost Code, as the office keys them in
+// today; either can be translated through tenant config, which also holds
+// the Sage employee numbers, the cost category and the pay IDs:
+//
+//   "sage": {"employees": {"Bob Smith": "1042"},
+//            "jobs": {"29699": "29-699"}, "cost_codes": {"201": "01-201"},
+//            "category": "L",
+//            "pay_ids": {"regular": "1", "overtime": "2", "night": "3", "night_overtime": "4"}}
+//
+// The body is one card or a JSON array of them. Every employee needs a Sage
+// employee number.
+
+// SageSettings maps a tenant's cards onto its Sage 300 CRE payroll
+type SageSettings struct {
+    Employees map[string]string `json:"employees,omitempty"`  // employee name → Sage employee number
+    Jobs      map[string]string `json:"jobs,omitempty"`       // job number → Sage job
+    CostCodes map[string]string `json:"cost_codes,omitempty"` // labour code → Sage cost code
+    Category  string            `json:"category,omitempty"`
+    PayIDs    SagePayIDs        `json:"pay_ids"`
+}
+
+// SagePayIDs are the pay IDs hours are entered under; night-shift hours
+// fall back to the regular and overtime IDs when none is set
+type SagePayIDs struct {
+    Regular       string `json:"regular,omitempty"`
+    Overtime      string `json:"overtime,omitempty"`
+    Night         string `json:"night,omitempty"`
+    NightOvertime string `json:"night_overtime,omitempty"`
+}
+
+// payID picks the pay ID for a line
+func (s *SageSettings) payID(l hourLine) string {
+    p := s.PayIDs
+    regular, overtime := orDefault(p.Regular, "1"), orDefault(p.Overtime, "2")
+    switch {
+    case l.Night && l.Overtime:
+        return orDefault(p.NightOvertime, overtime)
+    case l.Night:
+        return orDefault(p.Night, regular)
+    case l.Overtime:
+        return overtime
+    default:
+        return regular
+    }
+}
+
+func init() {
+    registerExporter(sageExporter{})
+}
+
+type sageExporter struct{}
+
+func (sageExporter) Name() string        { return "sage" }
+func (sageExporter) ContentType() string { return "text/csv; charset=utf-8" }
+
+func (sageExporter) FileName(t *Tenant, reqs []TimecardRequest) string {
+    return exportFileName(reqs, "sage", "csv")
+}
+
+func (sageExporter) Export(t *Tenant, reqs []TimecardRequest) ([]byte, error) {
+    s := t.Sage
+    var v validationResult
+    if s == nil {
+        v.errorf("Sage export is not configured for this tenant")
+        return nil, v
+    }
+    for i, req := range reqs {
+        if s.Employees[req.EmployeeName] == "" {
+            v.errorf("%s: no Sage employee number for this employee", cardLabel(i, req))
+        }
+        for _, l := range hourLines(req, t.location()) {
+            if l.LabourCode == "" {
+                v.errorf("%s: job %s has no labour code for the cost code", cardLabel(i, req), l.JobNumber)
+                break
+            }
+        }
+    }
+    if len(v.Errors) > 0 {
+        return nil, v
+    }
+
+    var b bytes.Buffer
+    cw := csv.NewWriter(&b)
+    for _, req := range reqs {
+        employee := s.Employees[req.EmployeeName]
+        for _, l := range hourLines(req, t.location()) {
+            _ = cw.Write([]string{
+                employee,
+                l.Date.Format("01/02/2006"),
+                orDefault(s.Jobs[l.JobNumber], l.JobNumber),
+                "",
+                orDefault(s.CostCodes[l.LabourCode], l.LabourCode),
+                orDefault(s.Category, "L"),
+                s.payID(l),
+                fmt.Sprintf("%.2f", l.Hours),
+            })
+        }
+    }
+    cw.Flush()
+    return b.Bytes(), cw.Error()
+}
+
+// orDefault is v, or def when v is empty
+func orDefault(v, def string) string {
+    if v == "" {
+        return def
+    }
+    return v
+}
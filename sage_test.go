@@ -0,0 +1,93 @@
+package main
+
+import (
+    "net/http"
+    "strings"
+    "testing"
+)
+
+const sageTestTenants = `{
+  "acme": {"sage": {"employees": {"Bob Smith": "1042", "Ann Lee": "1043"},
+                    "jobs": {"29699": "29-699"}, "cost_codes": {"201": "01-201"},
+                    "pay_ids": {"regular": "R", "overtime": "O", "night_overtime": "NO"}}},
+  "defaults": {"sage": {"employees": {"Bob Smith": "1042"}}},
+  "plain": {}
+}`
+
+// sageTestCard is exportTestCard without the job it doesn't list
+func sageTestCard() TimecardRequest {
+    req := exportTestCard()
+    req.Entries = append(req.Entries[:4:4], Entry{Date: "2025-01-08T00:00:00Z", JobCode: "12215", Hours: 2, Overtime: true, IsNightShift: true})
+    return req
+}
+
+func TestSageExport(t *testing.T) {
+    withTenants(t, sageTestTenants)
+    tests := []struct {
+        tenant string
+        want   []string
+    }{
+        // jobs and cost codes translated, night hours under the regular ID
+        // when there's no night ID
+        {"acme", []string{
+            "1042,01/06/2025,29-699,,01-201,L,R,8.00",
+            "1042,01/06/2025,29-699,,01-201,L,O,1.50",
+            "1042,01/07/2025,12215,,223,L,R,7.75",
+            "1042,01/08/2025,12215,,223,L,NO,2.00",
+        }},
+        // sent as keyed in, with the default category and pay IDs
+        {"defaults", []string{
+            "1042,01/06/2025,29699,,201,L,1,8.00",
+            "1042,01/06/2025,29699,,201,L,2,1.50",
+            "1042,01/07/2025,12215,,223,L,1,7.75",
+            "1042,01/08/2025,12215,,223,L,2,2.00",
+        }},
+    }
+    for _, tt := range tests {
+        t.Run(tt.tenant, func(t *testing.T) {
+            w := postCards(t, exportHandler, "/api/export/sage", tt.tenant, sageTestCard())
+            if w.Code != http.StatusOK {
+                t.Fatalf("%d %s", w.Code, w.Body)
+            }
+            if got := w.Header().Get("Content-Disposition"); got != `attachment; filename="timecard_Bob_Smith_PP03_2025_sage.csv"` {
+                t.Errorf("Content-Disposition = %s", got)
+            }
+            assertLines(t, "Sage import", strings.Split(strings.TrimSpace(w.Body.String()), "\n"), tt.want)
+        })
+    }
+
+    // a batch is named for no one card
+    ann := sageTestCard()
+    ann.EmployeeName = "Ann Lee"
+    w := postCards(t, exportHandler, "/api/export/sage", "acme", []TimecardRequest{sageTestCard(), ann})
+    if got := w.Header().Get("Content-Disposition"); w.Code != http.StatusOK || got != `attachment; filename="sage_timecards.csv"` {
+        t.Errorf("batch: %d, Content-Disposition = %s", w.Code, got)
+    }
+    if n := strings.Count(w.Body.String(), "\n"); n != 8 {
+        t.Errorf("batch has %d lines, want 8", n)
+    }
+}
+
+func TestSageExportRefuses(t *testing.T) {
+    withTenants(t, sageTestTenants)
+    stranger := sageTestCard()
+    stranger.EmployeeName = "Raj Patel"
+    tests := []struct {
+        name   string
+        tenant string
+        body   interface{}
+        want   string
+    }{
+        {"not configured", "plain", sageTestCard(), "Sage export is not configured"},
+        {"no employee number", "acme", stranger, "timecard 1 (Raj Patel): no Sage employee number"},
+        {"no labour code", "acme", exportTestCard(), "timecard 1 (Bob Smith): job 50000 has no labour code"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            w := postCards(t, exportHandler, "/api/export/sage", tt.tenant, tt.body)
+            if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), tt.want) {
+                t.Errorf("%d %s, want 400 with %q", w.Code, w.Body, tt.want)
+            }
+        })
+    }
+}
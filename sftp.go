@@ -0,0 +1,348 @@
+package main
+
+import (
+    "context"
+    "encoding/binary"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "os"
+    "path"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/ssh"
+    "golang.org/x/crypto/ssh/knownhosts"
+)
+
+/* =============
+   SFTP delivery
+   ============= */
+
+// Payroll bureaus that only take files by SFTP drop get the "sftp" channel:
+//
+//   SFTP_HOST             host[:port]
+//   SFTP_USER             login
+//   SFTP_KEY_FILE         private key (SFTP_KEY_PASSPHRASE if it is encrypted)
+//   SFTP_KNOWN_HOSTS      known_hosts file holding the server's key (ssh-keyscan)
+//   SFTP_PATH             remote path template (default "{file}")
+//   SFTP_FORMAT           xlsx (default) or pdf
+//
+// The template takes the placeholders of expandLayout, e.g.
+// "/inbound/{year}/PP{pp}/{employee}_{id}.{ext}". A delivery's "to", if
+// given, is used as the template instead. Files are
+// written as "<name>.part" and renamed when complete, so the bureau never
+// picks up half a file; missing directories are created.
+//
+// Only the few SFTP (version 3) requests an upload needs are implemented,
+// on top of x/crypto/ssh.
+
+type sftpDeliverer struct {
+    addr     string
+    config   *ssh.ClientConfig
+    template string
+    format   string
+}
+
+func init() {
+    host := os.Getenv("SFTP_HOST")
+    if host == "" {
+        return
+    }
+    d, err := newSFTPDeliverer(host)
+    if err != nil {
+        log.Printf("Warning: SFTP delivery disabled: %v", err)
+        return
+    }
+    registerDeliverer("sftp", d)
+}
+
+func newSFTPDeliverer(host string) (*sftpDeliverer, error) {
+    if _, _, err := net.SplitHostPort(host); err != nil {
+        host = net.JoinHostPort(host, "22")
+    }
+    keyData, err := os.ReadFile(os.Getenv("SFTP_KEY_FILE"))
+    if err != nil {
+        return nil, fmt.Errorf("SFTP_KEY_FILE: %w", err)
+    }
+    var signer ssh.Signer
+    if pass := os.Getenv("SFTP_KEY_PASSPHRASE"); pass != "" {
+        signer, err = ssh.ParsePrivateKeyWithPassphrase(keyData, []byte(pass))
+    } else {
+        signer, err = ssh.ParsePrivateKey(keyData)
+    }
+    if err != nil {
+        return nil, fmt.Errorf("SFTP_KEY_FILE: %w", err)
+    }
+    hostKeys, err := knownhosts.New(os.Getenv("SFTP_KNOWN_HOSTS"))
+    if err != nil {
+        return nil, fmt.Errorf("SFTP_KNOWN_HOSTS: %w", err)
+    }
+    format := envOr("SFTP_FORMAT", "xlsx")
+    if format != "xlsx" && format != "pdf" {
+        return nil, fmt.Errorf("SFTP_FORMAT must be xlsx or pdf")
+    }
+    return &sftpDeliverer{
+        addr: host,
+        config: &ssh.ClientConfig{
+            User:            os.Getenv("SFTP_USER"),
+            Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+            HostKeyCallback: hostKeys,
+            Timeout:         15 * time.Second,
+        },
+        template: envOr("SFTP_PATH", "{file}"),
+        format:   format,
+    }, nil
+}
+
+func (s *sftpDeliverer) Format() string { return s.format }
+
+// CheckAddress vets a path template given as "to"; empty means SFTP_PATH
+func (s *sftpDeliverer) CheckAddress(to string) error {
+    for _, seg := range strings.Split(to, "/") {
+        if seg == ".." {
+            return fmt.Errorf("remote path must not contain \"..\"")
+        }
+    }
+    return nil
+}
+
+func (s *sftpDeliverer) Deliver(ctx context.Context, d Delivery) (DeliveryReceipt, error) {
+    tpl := s.template
+    if d.To != "" {
+        tpl = d.To
+    }
+    remote := expandLayout(tpl, d.Record, d.FileName)
+
+    var dialer net.Dialer
+    conn, err := dialer.DialContext(ctx, "tcp", s.addr)
+    if err != nil {
+        return DeliveryReceipt{}, err
+    }
+    // Closing the connection is what interrupts a transfer on cancellation
+    stop := context.AfterFunc(ctx, func() { conn.Close() })
+    defer stop()
+    defer conn.Close()
+    _ = conn.SetDeadline(time.Now().Add(envDuration("SFTP_TIMEOUT", 2*time.Minute)))
+
+    sshConn, chans, reqs, err := ssh.NewClientConn(conn, s.addr, s.config)
+    if err != nil {
+        return DeliveryReceipt{}, err
+    }
+    client := ssh.NewClient(sshConn, chans, reqs)
+    defer client.Close()
+
+    sc, err := newSFTPClient(client)
+    if err != nil {
+        return DeliveryReceipt{}, err
+    }
+    defer sc.close()
+    if err := sc.upload(remote, d.Data); err != nil {
+        return DeliveryReceipt{}, fmt.Errorf("sftp %s: %w", remote, err)
+    }
+    loggerFrom(ctx).Printf("Uploaded %s to sftp://%s%s", d.FileName, s.addr, remote)
+    return DeliveryReceipt{Provider: s.addr, ProviderID: remote, Status: "sent"}, nil
+}
+
+/* ----- a minimal SFTP v3 client ----- */
+
+const (
+    sftpInit     = 1
+    sftpVersion  = 2
+    sftpOpen     = 3
+    sftpClose    = 4
+    sftpWrite    = 6
+    sftpRemove   = 13
+    sftpMkdir    = 14
+    sftpRename   = 18
+    sftpStatus   = 101
+    sftpHandle   = 102
+    sftpWriteMax = 32 << 10
+
+    sftpFlagWrite = 0x02
+    sftpFlagCreat = 0x08
+    sftpFlagTrunc = 0x10
+)
+
+type sftpClient struct {
+    session *ssh.Session
+    in      io.WriteCloser
+    out     io.Reader
+    nextID  uint32
+}
+
+func newSFTPClient(client *ssh.Client) (*sftpClient, error) {
+    session, err := client.NewSession()
+    if err != nil {
+        return nil, err
+    }
+    c := &sftpClient{session: session}
+    if c.in, err = session.StdinPipe(); err == nil {
+        c.out, err = session.StdoutPipe()
+    }
+    if err == nil {
+        err = session.RequestSubsystem("sftp")
+    }
+    if err != nil {
+        session.Close()
+        return nil, err
+    }
+    if err := c.send(sftpInit, sftpUint32(3)); err != nil {
+        c.close()
+        return nil, err
+    }
+    typ, _, err := c.recv()
+    if err == nil && typ != sftpVersion {
+        err = fmt.Errorf("unexpected sftp packet %d during handshake", typ)
+    }
+    if err != nil {
+        c.close()
+        return nil, err
+    }
+    return c, nil
+}
+
+func (c *sftpClient) close() {
+    c.in.Close()
+    c.session.Close()
+}
+
+// upload writes data to remote by way of remote+".part"
+func (c *sftpClient) upload(remote string, data []byte) error {
+    c.mkdirAll(path.Dir(remote))
+    tmp := remote + ".part"
+    handle, err := c.open(tmp, sftpFlagWrite|sftpFlagCreat|sftpFlagTrunc)
+    if err != nil {
+        return err
+    }
+    for off := 0; off < len(data); off += sftpWriteMax {
+        end := off + sftpWriteMax
+        if end > len(data) {
+            end = len(data)
+        }
+        payload := append(sftpString(handle), sftpUint64(uint64(off))...)
+        payload = append(payload, sftpString(string(data[off:end]))...)
+        if err := c.call(sftpWrite, payload); err != nil {
+            _ = c.call(sftpClose, sftpString(handle))
+            return err
+        }
+    }
+    if err := c.call(sftpClose, sftpString(handle)); err != nil {
+        return err
+    }
+    // SFTP v3 rename refuses to replace an existing file
+    _ = c.call(sftpRemove, sftpString(remote))
+    return c.call(sftpRename, append(sftpString(tmp), sftpString(remote)...))
+}
+
+// mkdirAll creates each directory of dir, ignoring ones that exist
+func (c *sftpClient) mkdirAll(dir string) {
+    if dir == "." || dir == "/" || dir == "" {
+        return
+    }
+    c.mkdirAll(path.Dir(dir))
+    _ = c.call(sftpMkdir, append(sftpString(dir), sftpUint32(0)...))
+}
+
+func (c *sftpClient) open(name string, flags uint32) (string, error) {
+    payload := append(sftpString(name), sftpUint32(flags)...)
+    payload = append(payload, sftpUint32(0)...) // no attributes
+    typ, data, err := c.request(sftpOpen, payload)
+    if err != nil {
+        return "", err
+    }
+    if typ == sftpStatus {
+        return "", sftpStatusError(data)
+    }
+    if typ != sftpHandle || len(data) < 4 {
+        return "", fmt.Errorf("unexpected sftp packet %d", typ)
+    }
+    n := binary.BigEndian.Uint32(data)
+    if int(n) > len(data)-4 {
+        return "", fmt.Errorf("short sftp handle")
+    }
+    return string(data[4 : 4+n]), nil
+}
+
+// call makes a request that answers with a status, returning it as an error
+func (c *sftpClient) call(typ byte, payload []byte) error {
+    rtyp, data, err := c.request(typ, payload)
+    if err != nil {
+        return err
+    }
+    if rtyp != sftpStatus {
+        return fmt.Errorf("unexpected sftp packet %d", rtyp)
+    }
+    return sftpStatusError(data)
+}
+
+// request sends one request and reads its answer (requests are never
+// pipelined, so the answer is the next packet), minus the request id
+func (c *sftpClient) request(typ byte, payload []byte) (byte, []byte, error) {
+    c.nextID++
+    if err := c.send(typ, append(sftpUint32(c.nextID), payload...)); err != nil {
+        return 0, nil, err
+    }
+    rtyp, data, err := c.recv()
+    if err != nil {
+        return 0, nil, err
+    }
+    if len(data) < 4 || binary.BigEndian.Uint32(data) != c.nextID {
+        return 0, nil, fmt.Errorf("sftp answer out of order")
+    }
+    return rtyp, data[4:], nil
+}
+
+func (c *sftpClient) send(typ byte, payload []byte) error {
+    pkt := append(sftpUint32(uint32(len(payload)+1)), typ)
+    _, err := c.in.Write(append(pkt, payload...))
+    return err
+}
+
+func (c *sftpClient) recv() (byte, []byte, error) {
+    var hdr [5]byte
+    if _, err := io.ReadFull(c.out, hdr[:]); err != nil {
+        return 0, nil, err
+    }
+    n := binary.BigEndian.Uint32(hdr[:4])
+    if n < 1 || n > 256<<10 {
+        return 0, nil, fmt.Errorf("bad sftp packet length %d", n)
+    }
+    data := make([]byte, n-1)
+    if _, err := io.ReadFull(c.out, data); err != nil {
+        return 0, nil, err
+    }
+    return hdr[4], data, nil
+}
+
+// sftpStatusError turns a status payload (code, message) into an error;
+// code 0 is success
+func sftpStatusError(data []byte) error {
+    if len(data) < 4 {
+        return fmt.Errorf("short sftp status")
+    }
+    code := binary.BigEndian.Uint32(data)
+    if code == 0 {
+        return nil
+    }
+    msg := ""
+    if len(data) >= 8 {
+        if n := binary.BigEndian.Uint32(data[4:]); int(n) <= len(data)-8 {
+            msg = string(data[8 : 8+n])
+        }
+    }
+    return fmt.Errorf("sftp status %d: %s", code, msg)
+}
+
+func sftpUint32(v uint32) []byte {
+    return binary.BigEndian.AppendUint32(nil, v)
+}
+
+func sftpUint64(v uint64) []byte {
+    return binary.BigEndian.AppendUint64(nil, v)
+}
+
+func sftpString(s string) []byte {
+    return append(sftpUint32(uint32(len(s))), s...)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/ed25519"
+    "encoding/binary"
+    "io"
+    "net"
+    "path"
+    "strings"
+    "sync"
+    "testing"
+
+    "golang.org/x/crypto/ssh"
+)
+
+// fakeSFTP is an SSH server on localhost with an in-memory SFTP subsystem
+// answering the requests sftpClient makes. Directories must exist before
+// files or directories are made in them, rename won't replace a file, and
+// anything under /readonly is refused, as on a real server.
+type fakeSFTP struct {
+    addr    string
+    hostKey ssh.PublicKey
+
+    mu    sync.Mutex
+    files map[string][]byte
+    dirs  map[string]bool
+}
+
+const (
+    fxOK               = 0
+    fxNoSuchFile       = 2
+    fxPermissionDenied = 3
+    fxFailure          = 4
+)
+
+func startFakeSFTP(t *testing.T, clientKey ssh.PublicKey) *fakeSFTP {
+    t.Helper()
+    _, hostPriv, _ := ed25519.GenerateKey(nil)
+    hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+    if err != nil {
+        t.Fatal(err)
+    }
+    config := &ssh.ServerConfig{
+        PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+            if !bytes.Equal(key.Marshal(), clientKey.Marshal()) {
+                return nil, io.EOF
+            }
+            return nil, nil
+        },
+    }
+    config.AddHostKey(hostSigner)
+
+    ln, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { ln.Close() })
+    s := &fakeSFTP{addr: ln.Addr().String(), hostKey: hostSigner.PublicKey(),
+        files: map[string][]byte{}, dirs: map[string]bool{"/": true}}
+    go func() {
+        for {
+            conn, err := ln.Accept()
+            if err != nil {
+                return
+            }
+            go s.serveConn(conn, config)
+        }
+    }()
+    return s
+}
+
+func (s *fakeSFTP) serveConn(conn net.Conn, config *ssh.ServerConfig) {
+    defer conn.Close()
+    _, chans, reqs, err := ssh.NewServerConn(conn, config)
+    if err != nil {
+        return
+    }
+    go ssh.DiscardRequests(reqs)
+    for nc := range chans {
+        if nc.ChannelType() != "session" {
+            _ = nc.Reject(ssh.UnknownChannelType, "session only")
+            continue
+        }
+        ch, reqs, err := nc.Accept()
+        if err != nil {
+            return
+        }
+        go func() {
+            for req := range reqs {
+                ok := req.Type == "subsystem" && string(req.Payload[4:]) == "sftp"
+                _ = req.Reply(ok, nil)
+                if ok {
+                    go s.serveSFTP(ch)
+                }
+            }
+        }()
+    }
+}
+
+func (s *fakeSFTP) serveSFTP(ch ssh.Channel) {
+    defer ch.Close()
+    for {
+        var hdr [5]byte
+        if _, err := io.ReadFull(ch, hdr[:]); err != nil {
+            return
+        }
+        data := make([]byte, binary.BigEndian.Uint32(hdr[:4])-1)
+        if _, err := io.ReadFull(ch, data); err != nil {
+            return
+        }
+        if hdr[4] == sftpInit {
+            s.reply(ch, sftpVersion, sftpUint32(3))
+            continue
+        }
+        id, data := data[:4], data[4:]
+        typ, answer := s.handle(hdr[4], data)
+        s.reply(ch, typ, append(append([]byte(nil), id...), answer...))
+    }
+}
+
+func (s *fakeSFTP) reply(w io.Writer, typ byte, payload []byte) {
+    _, _ = w.Write(append(append(sftpUint32(uint32(len(payload)+1)), typ), payload...))
+}
+
+// handle answers one request with a handle or a status
+func (s *fakeSFTP) handle(typ byte, data []byte) (byte, []byte) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    status := func(code uint32, msg string) (byte, []byte) {
+        return sftpStatus, append(append(sftpUint32(code), sftpString(msg)...), sftpString("")...)
+    }
+    name, data := fakeSFTPString(data)
+    switch {
+    case typ != sftpClose && strings.HasPrefix(name, "/readonly"):
+        return status(fxPermissionDenied, "permission denied")
+    case typ == sftpOpen:
+        if !s.dirs[path.Dir(name)] {
+            return status(fxNoSuchFile, "no such directory")
+        }
+        if binary.BigEndian.Uint32(data)&sftpFlagTrunc != 0 || s.files[name] == nil {
+            s.files[name] = []byte{}
+        }
+        return sftpHandle, sftpString(name)
+    case typ == sftpWrite:
+        off := binary.BigEndian.Uint64(data)
+        chunk, _ := fakeSFTPString(data[8:])
+        file := s.files[name]
+        if need := int(off) + len(chunk); need > len(file) {
+            file = append(file, make([]byte, need-len(file))...)
+        }
+        copy(file[off:], chunk)
+        s.files[name] = file
+    case typ == sftpClose:
+    case typ == sftpRemove:
+        if s.files[name] == nil {
+            return status(fxNoSuchFile, "no such file")
+        }
+        delete(s.files, name)
+    case typ == sftpMkdir:
+        if s.dirs[name] {
+            return status(fxFailure, "exists")
+        }
+        if !s.dirs[path.Dir(name)] {
+            return status(fxNoSuchFile, "no such directory")
+        }
+        s.dirs[name] = true
+    case typ == sftpRename:
+        to, _ := fakeSFTPString(data)
+        if s.files[to] != nil {
+            return status(fxFailure, "file exists")
+        }
+        if s.files[name] == nil {
+            return status(fxNoSuchFile, "no such file")
+        }
+        s.files[to] = s.files[name]
+        delete(s.files, name)
+    default:
+        return status(8, "unsupported") // SSH_FX_OP_UNSUPPORTED
+    }
+    return status(fxOK, "")
+}
+
+func fakeSFTPString(data []byte) (string, []byte) {
+    if len(data) < 4 {
+        return "", nil
+    }
+    n := binary.BigEndian.Uint32(data)
+    return string(data[4 : 4+n]), data[4+n:]
+}
+
+func (s *fakeSFTP) file(name string) ([]byte, bool) {
+    s.mu.Lock()
+    defer s.mu.Unlock()
+    f, ok := s.files[name]
+    return f, ok
+}
+
+// newTestSFTPDeliverer logs in to addr with signer, trusting hostKey
+func newTestSFTPDeliverer(signer ssh.Signer, addr string, hostKey ssh.PublicKey) *sftpDeliverer {
+    return &sftpDeliverer{
+        addr: addr,
+        config: &ssh.ClientConfig{
+            User:            "payroll",
+            Auth:            []ssh.AuthMethod{ssh.PublicKeys(signer)},
+            HostKeyCallback: ssh.FixedHostKey(hostKey),
+        },
+        template: "/inbound/{year}/PP{pp}/{file}",
+        format:   "xlsx",
+    }
+}
+
+func testSSHSigner(t *testing.T) ssh.Signer {
+    t.Helper()
+    _, priv, _ := ed25519.GenerateKey(nil)
+    signer, err := ssh.NewSignerFromKey(priv)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return signer
+}
+
+func TestSFTPDeliver(t *testing.T) {
+    signer := testSSHSigner(t)
+    srv := startFakeSFTP(t, signer.PublicKey())
+    d := newTestSFTPDeliverer(signer, srv.addr, srv.hostKey)
+
+    // more than one write's worth
+    data := make([]byte, 3*sftpWriteMax+123)
+    for i := range data {
+        data[i] = byte(i * 7)
+    }
+    delivery := Delivery{FileName: "timecard_Bob_Smith_abc.xlsx", Data: data,
+        Record: TimecardRecord{ID: "abc", Employee: "Bob Smith", Year: 2025, PayPeriodNum: 3}}
+    receipt, err := d.Deliver(context.Background(), delivery)
+    if err != nil {
+        t.Fatal(err)
+    }
+    const remote = "/inbound/2025/PP03/timecard_Bob_Smith_abc.xlsx"
+    if receipt.ProviderID != remote || receipt.Status != "sent" {
+        t.Errorf("receipt = %+v", receipt)
+    }
+    if got, ok := srv.file(remote); !ok || !bytes.Equal(got, data) {
+        t.Errorf("uploaded %d bytes, want %d", len(got), len(data))
+    }
+    if _, ok := srv.file(remote + ".part"); ok {
+        t.Error("the .part file was left behind")
+    }
+
+    // a second delivery replaces the first
+    delivery.Data = []byte("revised")
+    if _, err := d.Deliver(context.Background(), delivery); err != nil {
+        t.Fatal(err)
+    }
+    if got, _ := srv.file(remote); string(got) != "revised" {
+        t.Errorf("after redelivery the file holds %q", got)
+    }
+
+    // "to" overrides SFTP_PATH
+    delivery.To = "/other/{employee}.{ext}"
+    if receipt, err := d.Deliver(context.Background(), delivery); err != nil || receipt.ProviderID != "/other/Bob_Smith.xlsx" {
+        t.Errorf("to: %+v, %v", receipt, err)
+    }
+}
+
+func TestSFTPDeliverFailures(t *testing.T) {
+    signer := testSSHSigner(t)
+    srv := startFakeSFTP(t, signer.PublicKey())
+    delivery := Delivery{FileName: "a.xlsx", Data: []byte("x"), Record: TimecardRecord{ID: "abc", Year: 2025, PayPeriodNum: 3}}
+
+    tests := []struct {
+        name string
+        d    *sftpDeliverer
+        to   string
+        want string
+    }{
+        {"refused by the server", newTestSFTPDeliverer(signer, srv.addr, srv.hostKey), "/readonly/{file}",
+            "sftp status 3: permission denied"},
+        {"unknown host key", newTestSFTPDeliverer(signer, srv.addr, testSSHSigner(t).PublicKey()), "",
+            "host key mismatch"},
+        {"key not authorized", newTestSFTPDeliverer(testSSHSigner(t), srv.addr, srv.hostKey), "",
+            "unable to authenticate"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            delivery.To = tt.to
+            _, err := tt.d.Deliver(context.Background(), delivery)
+            if err == nil || !strings.Contains(err.Error(), tt.want) {
+                t.Errorf("err = %v, want %q", err, tt.want)
+            }
+        })
+    }
+}
+
+func TestSFTPStatusError(t *testing.T) {
+    tests := []struct {
+        name string
+        data []byte
+        want string
+    }{
+        {"ok", sftpUint32(0), ""},
+        {"with message", append(sftpUint32(2), sftpString("no such file")...), "sftp status 2: no such file"},
+        {"without message", sftpUint32(4), "sftp status 4: "},
+        {"message overruns", append(sftpUint32(4), sftpUint32(99)...), "sftp status 4: "},
+        {"short", []byte{0, 0}, "short sftp status"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            got := ""
+            if err := sftpStatusError(tt.data); err != nil {
+                got = err.Error()
+            }
+            if got != tt.want {
+                t.Errorf("got %q, want %q", got, tt.want)
+            }
+        })
+    }
+}
+
+func TestSFTPCheckAddress(t *testing.T) {
+    var d sftpDeliverer
+    for to, ok := range map[string]bool{
+        "":                         true,
+        "/inbound/{year}/{file}":   true,
+        "/inbound/../etc/{file}":   false,
+        "../{file}":                false,
+        "/inbound/..backup/{file}": true,
+    } {
+        if err := d.CheckAddress(to); (err == nil) != ok {
+            t.Errorf("CheckAddress(%q) = %v, want ok=%v", to, err, ok)
+        }
+    }
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ==========
+   SharePoint
+   ========== */
+
+// With SHAREPOINT_DRIVE_ID set (the document library's Graph drive ID),
+// every stored file is also uploaded there through Microsoft Graph:
+//
+//   <SHAREPOINT_FOLDER>/<year>/PP<nn>/<employee>/<file>
+//
+// SHAREPOINT_FOLDER defaults to "Timecards"; Graph creates missing folders
+// on the way. A tenant can file into its own library with
+// "sharepoint": {"drive_id": ..., "folder": ...}. OneDrive works the same
+// way given the drive ID of the user's OneDrive. The app registration
+// (msauth.go) needs Sites.ReadWrite.All or Files.ReadWrite.All.
+
+// SharePointLocation is a tenant's own library and folder
+type SharePointLocation struct {
+    DriveID string `json:"drive_id"`
+    Folder  string `json:"folder,omitempty"`
+}
+
+// Graph takes files up to 4 MB in one PUT; bigger ones go through an upload
+// session in chunks of a multiple of 320 KiB
+const (
+    graphSimpleUploadMax = 4 << 20
+    graphChunkSize       = 32 * 320 << 10
+)
+
+type sharePointStore struct {
+    tokens *msTokenSource
+    apiURL string
+    client *http.Client
+}
+
+var (
+    sharePointOnce sync.Once
+    theSharePoint  *sharePointStore
+)
+
+// sharePoint returns the SharePoint store, or nil when it isn't configured
+func sharePoint() *sharePointStore {
+    sharePointOnce.Do(func() {
+        if os.Getenv("SHAREPOINT_DRIVE_ID") == "" && !anyTenant(func(t *Tenant) bool { return t.SharePoint != nil }) {
+            return
+        }
+        tokens, err := newMSTokenSource("https://graph.microsoft.com/.default")
+        if err != nil {
+            log.Printf("Warning: SharePoint upload disabled: %v", err)
+            return
+        }
+        theSharePoint = &sharePointStore{
+            tokens: tokens,
+            apiURL: strings.TrimSuffix(envOr("GRAPH_API_URL", "https://graph.microsoft.com/v1.0"), "/"),
+            client: &http.Client{Timeout: 60 * time.Second},
+        }
+    })
+    return theSharePoint
+}
+
+func (s *sharePointStore) Name() string { return "sharepoint" }
+
+func (s *sharePointStore) Put(ctx context.Context, obj StoredObject) (StoredCopy, error) {
+    loc := SharePointLocation{DriveID: os.Getenv("SHAREPOINT_DRIVE_ID"), Folder: envOr("SHAREPOINT_FOLDER", "Timecards")}
+    if t := obj.Tenant.SharePoint; t != nil {
+        loc = *t
+    }
+    if loc.DriveID == "" {
+        return StoredCopy{}, nil
+    }
+
+    var segments []string
+    for _, p := range strings.Split(loc.Folder, "/") {
+        if p = strings.TrimSpace(p); p != "" {
+            segments = append(segments, url.PathEscape(p))
+        }
+    }
+    for _, p := range append(append([]string{}, obj.Folders...), obj.Name) {
+        segments = append(segments, url.PathEscape(sharePointName(p)))
+    }
+    item := s.apiURL + "/drives/" + url.PathEscape(loc.DriveID) + "/root:/" + strings.Join(segments, "/") + ":"
+
+    var out struct {
+        ID     string `json:"id"`
+        WebURL string `json:"webUrl"`
+    }
+    var err error
+    if len(obj.Data) <= graphSimpleUploadMax {
+        err = s.call(ctx, http.MethodPut, item+"/content?@microsoft.graph.conflictBehavior=fail", obj.ContentType, obj.Data, &out)
+    } else {
+        err = s.uploadSession(ctx, item, obj.Data, &out)
+    }
+    if err != nil {
+        return StoredCopy{}, err
+    }
+    return StoredCopy{Store: "sharepoint", Location: "sharepoint:" + out.ID, Link: out.WebURL, At: now().UTC()}, nil
+}
+
+// uploadSession sends data in chunks to a fresh upload session for item
+func (s *sharePointStore) uploadSession(ctx context.Context, item string, data []byte, out interface{}) error {
+    var session struct {
+        UploadURL string `json:"uploadUrl"`
+    }
+    body, _ := json.Marshal(map[string]interface{}{"item": map[string]string{"@microsoft.graph.conflictBehavior": "fail"}})
+    if err := s.call(ctx, http.MethodPost, item+"/createUploadSession", "application/json", body, &session); err != nil {
+        return err
+    }
+    for start := 0; start < len(data); start += graphChunkSize {
+        end := start + graphChunkSize
+        if end > len(data) {
+            end = len(data)
+        }
+        // The upload URL is pre-authorized; it must not get a bearer token
+        req, err := http.NewRequestWithContext(ctx, http.MethodPut, session.UploadURL, bytes.NewReader(data[start:end]))
+        if err != nil {
+            return err
+        }
+        req.Header.Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end-1, len(data)))
+        resp, err := s.client.Do(req)
+        if err != nil {
+            return err
+        }
+        err = graphResponse(resp, out)
+        resp.Body.Close()
+        if err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// call makes an authorized Graph request and decodes the JSON answer into out
+func (s *sharePointStore) call(ctx context.Context, method, endpoint, contentType string, body []byte, out interface{}) error {
+    token, err := s.tokens.Token(ctx)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequestWithContext(ctx, method, endpoint, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Authorization", "Bearer "+token)
+    req.Header.Set("Content-Type", contentType)
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    return graphResponse(resp, out)
+}
+
+// graphResponse decodes a Graph answer, turning errors into Go errors.
+// Intermediate upload-session chunks answer 202 and leave out alone.
+func graphResponse(resp *http.Response, out interface{}) error {
+    data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+    if err != nil {
+        return err
+    }
+    if resp.StatusCode >= 300 {
+        var apiErr struct {
+            Error struct {
+                Code    string `json:"code"`
+                Message string `json:"message"`
+            } `json:"error"`
+        }
+        _ = json.Unmarshal(data, &apiErr)
+        return fmt.Errorf("graph: %s %s %s", resp.Status, apiErr.Error.Code, apiErr.Error.Message)
+    }
+    if resp.StatusCode == http.StatusAccepted {
+        return nil
+    }
+    return json.Unmarshal(data, out)
+}
+
+var sharePointReplacer = strings.NewReplacer(`"`, "_", "*", "_", ":", "_", "<", "_", ">", "_", "?", "_", "/", "_", `\`, "_", "|", "_", "#", "_", "%", "_")
+
+// sharePointName replaces the characters SharePoint refuses in names
+func sharePointName(s string) string {
+    s = strings.Trim(sharePointReplacer.Replace(s), " .")
+    if s == "" {
+        return "_"
+    }
+    return s
+}
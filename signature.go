@@ -0,0 +1,187 @@
+package main
+
+import (
+    "bytes"
+    "encoding/base64"
+    "fmt"
+    "image"
+    "image/png"
+    "strings"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==========
+   Signatures
+   ========== */
+
+// A card can carry the employee's and the supervisor's handwritten
+// signatures, captured on the device as PNGs:
+//
+//   "employee_signature":   {"image": "<base64 PNG>", "name": "Bob Smith", "signed_at": "2025-01-20T16:05:00-05:00"}
+//   "supervisor_signature": {...}
+//
+// Each is drawn at its cell map position on every week sheet (scaled to
+// the row's height) with "<role>: <name>, <date time>" beside it in the
+// tenant's timezone. The default template signs the supervisor on its
+// "Approved by:" row and the employee on the row under it.
+//
+// The card's "supervisor" (or the employee directory's) goes in the same
+// approval block, and once the manager approves the card (approval.go)
+// regenerated copies add the time, or a card approved elsewhere sends
+// "approved_by" and "approved_at". A template can map the block's own
+// "supervisor" and "approved_at" cells; otherwise they share the
+// supervisor signature's text cell when nobody signed.
+
+// Signature is one signer's mark on the card
+type Signature struct {
+    Image    string `json:"image"` // base64 PNG, bare or as a data: URL
+    Name     string `json:"name"`
+    SignedAt string `json:"signed_at"` // RFC 3339
+}
+
+// SignatureCells is where a signature goes: the picture's top-left cell
+// and the cell for the name and time
+type SignatureCells struct {
+    Image string `json:"image"`
+    Text  string `json:"text"`
+}
+
+const maxSignatureBytes = 256 << 10
+
+// signatureHeight is how tall a signature is drawn, in pixels (a row of
+// the default template is 27)
+const signatureHeight = 26
+
+// decode returns the PNG bytes and the parsed signing time
+func (s *Signature) decode() ([]byte, time.Time, error) {
+    data := s.Image
+    if i := strings.Index(data, ","); strings.HasPrefix(data, "data:") && i >= 0 {
+        data = data[i+1:]
+    }
+    raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(data))
+    if err != nil {
+        return nil, time.Time{}, fmt.Errorf("image is not valid base64")
+    }
+    if len(raw) > maxSignatureBytes {
+        return nil, time.Time{}, fmt.Errorf("image is larger than %d KB", maxSignatureBytes>>10)
+    }
+    if _, err := png.DecodeConfig(bytes.NewReader(raw)); err != nil {
+        return nil, time.Time{}, fmt.Errorf("image is not a PNG")
+    }
+    at, err := time.Parse(time.RFC3339, s.SignedAt)
+    if err != nil {
+        return nil, time.Time{}, fmt.Errorf("signed_at must be an RFC 3339 timestamp")
+    }
+    return raw, at, nil
+}
+
+// checkSignatures adds a validation error for each unusable signature
+func checkSignatures(v *validationResult, req TimecardRequest) {
+    for _, s := range []struct {
+        field string
+        sig   *Signature
+    }{
+        {"employee_signature", req.EmployeeSignature},
+        {"supervisor_signature", req.SupervisorSignature},
+    } {
+        if s.sig == nil {
+            continue
+        }
+        if strings.TrimSpace(s.sig.Name) == "" {
+            v.errorf("%s: name is required", s.field)
+        }
+        if _, _, err := s.sig.decode(); err != nil {
+            v.errorf("%s: %v", s.field, err)
+        }
+    }
+    if req.ApprovedAt != "" {
+        if _, err := time.Parse(time.RFC3339, req.ApprovedAt); err != nil {
+            v.errorf("approved_at must be an RFC 3339 timestamp")
+        }
+    }
+}
+
+// placeSignatures draws the card's signatures on sheet
+func placeSignatures(gc *genContext, f *excelize.File, sheet string) {
+    req, cm := gc.req, gc.tpl.CellMap
+    employee, supervisor := "Employee:", "Supervisor:"
+    if req.Bilingual {
+        employee, supervisor = bilingual("Employee:", "Employé(e) :"), bilingual("Supervisor:", "Superviseur :")
+    }
+    placeSignature(gc, f, sheet, req.EmployeeSignature, cm.EmployeeSignature, employee)
+    placeSignature(gc, f, sheet, req.SupervisorSignature, cm.SupervisorSignature, supervisor)
+    placeApproval(gc, f, sheet, supervisor)
+}
+
+// placeApproval writes the supervisor's name and the approval time into
+// the approval block: its own cells when the template maps them, else the
+// supervisor signature's text cell when nobody signed there
+func placeApproval(gc *genContext, f *excelize.File, sheet, role string) {
+    req, cm := gc.req, gc.tpl.CellMap
+    name := req.Supervisor
+    if name == "" {
+        name = req.ApprovedBy
+    }
+    var approved string
+    if at, err := time.Parse(time.RFC3339, req.ApprovedAt); err == nil {
+        approved = at.In(gc.loc).Format("2006-01-02 15:04")
+    }
+    if name == "" && approved == "" {
+        return
+    }
+    if cm.Supervisor != "" || cm.ApprovedAt != "" {
+        setMapped(f, sheet, cm.Supervisor, name)
+        if approved != "" {
+            setMapped(f, sheet, cm.ApprovedAt, approved)
+        }
+        return
+    }
+    if req.SupervisorSignature != nil {
+        return
+    }
+    text := strings.TrimSpace(role + " " + name)
+    if approved != "" {
+        label := "approved"
+        if req.Bilingual {
+            label = bilingual("approved", "approuvé")
+        }
+        text += ", " + label + " " + approved
+    }
+    setMapped(f, sheet, cm.SupervisorSignature.Text, text)
+}
+
+func placeSignature(gc *genContext, f *excelize.File, sheet string, sig *Signature, cells SignatureCells, role string) {
+    if sig == nil {
+        return
+    }
+    raw, at, err := sig.decode()
+    if err != nil {
+        gc.lg.Printf("Warning: skipping %s signature: %v", strings.TrimSuffix(role, ":"), err)
+        return
+    }
+    if cells.Image != "" {
+        cfg, _, _ := image.DecodeConfig(bytes.NewReader(raw))
+        scale := 1.0
+        if cfg.Height > 0 {
+            scale = float64(signatureHeight) / float64(cfg.Height)
+        }
+        err := f.AddPictureFromBytes(sheet, cells.Image, &excelize.Picture{
+            Extension: ".png",
+            File:      raw,
+            Format: &excelize.GraphicOptions{
+                AltText:         "Signature of " + sig.Name,
+                LockAspectRatio: true,
+                ScaleX:          scale,
+                ScaleY:          scale,
+                OffsetY:         1,
+                Positioning:     "oneCell",
+            },
+        })
+        if err != nil {
+            gc.lg.Printf("Warning: could not place signature in %s!%s: %v", sheet, cells.Image, err)
+        }
+    }
+    setMapped(f, sheet, cells.Text, fmt.Sprintf("%s %s, %s", role, sig.Name, at.In(gc.loc).Format("2006-01-02 15:04")))
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+/* ===================
+   Slack notifications
+   =================== */
+
+// Every generated or emailed card can be announced in Slack, either through
+// an incoming webhook (SLACK_WEBHOOK_URL) or as a bot (SLACK_BOT_TOKEN and
+// SLACK_CHANNEL, using chat.postMessage):
+//
+//   Timecard generated for *Bob Smith*: PP 7 / 2025, 40 h (2 h OT) · xlsx · <link|Open>
+//
+// The link points at the stored file on the WebDAV share (see timecardLink).
+
+type slackNotifier struct {
+    webhookURL string
+    token      string
+    channel    string
+    apiURL     string
+    client     *http.Client
+}
+
+func init() {
+    s := slackNotifier{
+        webhookURL: os.Getenv("SLACK_WEBHOOK_URL"),
+        token:      os.Getenv("SLACK_BOT_TOKEN"),
+        channel:    os.Getenv("SLACK_CHANNEL"),
+        apiURL:     envOr("SLACK_API_URL", "https://slack.com/api"),
+        client:     &http.Client{Timeout: 10 * time.Second},
+    }
+    if s.webhookURL == "" && (s.token == "" || s.channel == "") {
+        return
+    }
+    subscribeEvents(queuedSubscriber("slack", s.notify))
+}
+
+func (s slackNotifier) notify(ev webhookEvent) error {
+    text := slackText(ev)
+    if text == "" {
+        return nil
+    }
+    if s.webhookURL != "" {
+        return s.post(s.webhookURL, "", map[string]string{"text": text})
+    }
+    return s.post(s.apiURL+"/chat.postMessage", s.token, map[string]string{"channel": s.channel, "text": text})
+}
+
+func (s slackNotifier) post(endpoint, token string, msg interface{}) error {
+    body, err := json.Marshal(msg)
+    if err != nil {
+        return err
+    }
+    req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json; charset=utf-8")
+    if token != "" {
+        req.Header.Set("Authorization", "Bearer "+token)
+    }
+    resp, err := s.client.Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("slack returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+    }
+    // The Web API answers 200 with {"ok": false} on errors
+    if token != "" {
+        var out struct {
+            OK    bool   `json:"ok"`
+            Error string `json:"error"`
+        }
+        if err := json.Unmarshal(data, &out); err == nil && !out.OK {
+            return fmt.Errorf("slack: %s", out.Error)
+        }
+    }
+    return nil
+}
+
+// slackText is the message for ev, or "" for events Slack doesn't announce
+func slackText(ev webhookEvent) string {
+    data, _ := ev.Data.(map[string]interface{})
+    var lead string
+    switch ev.Type {
+    case "timecard.generated":
+        lead = "Timecard generated for"
+    case "email.sent":
+        lead = "Timecard emailed to " + slackEscape(fmt.Sprint(data["to"])) + " for"
+    default:
+        return ""
+    }
+
+    var b strings.Builder
+    fmt.Fprintf(&b, "%s *%s*: PP %v / %v, %s",
+        lead, slackEscape(fmt.Sprint(data["employee"])), data["pay_period_num"], data["year"], hoursSummary(data))
+    if f, ok := data["format"].(string); ok && ev.Type == "timecard.generated" {
+        fmt.Fprintf(&b, " · %s", f)
+    }
+    if t := lookupTenant(ev.Tenant); ev.Tenant != "" && ev.Tenant != defaultTenantID && t.Name != "" {
+        fmt.Fprintf(&b, " · %s", slackEscape(t.Name))
+    }
+    if id, ok := data["timecard_id"].(string); ok {
+        if link := timecardLink(id); link != "" {
+            fmt.Fprintf(&b, " · <%s|Open>", link)
+        }
+    }
+    return b.String()
+}
+
+// hoursSummary is "40 h (2 h OT)" from timecardEventData's totals
+func hoursSummary(data map[string]interface{}) string {
+    total, _ := data["total_hours"].(float64)
+    ot, _ := data["overtime_hours"].(float64)
+    s := fmt.Sprintf("%s h", formatHours(total))
+    if ot > 0 {
+        s += fmt.Sprintf(" (%s h OT)", formatHours(ot))
+    }
+    return s
+}
+
+func formatHours(h float64) string {
+    return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.2f", h), "0"), ".")
+}
+
+// slackEscape escapes the three characters Slack treats as markup
+func slackEscape(s string) string {
+    return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "sync"
+    "syscall"
+    "time"
+)
+
+/* ======================
+   LibreOffice conversion
+   ====================== */
+
+// LibreOffice occasionally dies on startup (a corrupt or locked user
+// profile is the usual cause). A failed conversion is retried with a fresh
+// throwaway profile, up to PDF_ATTEMPTS (default 2) tries in all. If
+// conversions keep failing anyway, the breaker opens after
+// PDF_BREAKER_THRESHOLD (default 5) consecutive failures and PDF requests
+// fail fast with 503 for PDF_BREAKER_COOLDOWN (default 30s) before one
+// trial conversion is let through.
+
+var (
+    errSofficeFailed = errors.New("libreoffice conversion failed")
+    errCircuitOpen   = errors.New("PDF conversion temporarily disabled after repeated failures")
+)
+
+// pdfOptions are how soffice exports a PDF
+type pdfOptions struct {
+    Password  string // locks the PDF (pdfpassword.go)
+    PDFA      string // PDF/A conformance level, e.g. "2b" (pdfa.go)
+    Watermark string // drawn across every page (watermark.go)
+    Sign      bool   // signs the PDF with the server's certificate (pdfsign.go)
+}
+
+// pdfOptions are the export options for req's PDF
+func (req TimecardRequest) pdfOptions(t *Tenant) pdfOptions {
+    opts := pdfOptions{Password: req.pdfPassword(t), PDFA: req.pdfA(t)}
+    if req.Draft {
+        opts.Watermark = draftWatermark
+    }
+    opts.Sign = pdfSigning() != nil && opts.Password == ""
+    return opts
+}
+
+// exportFilter is soffice's --convert-to argument for o: a plain "pdf",
+// or the PDF export filter with its options as JSON (LibreOffice 7.4+)
+func (o pdfOptions) exportFilter() string {
+    type option struct {
+        Type  string `json:"type"`
+        Value string `json:"value"`
+    }
+    opts := map[string]option{}
+    if o.Password != "" {
+        opts["EncryptFile"] = option{"boolean", "true"}
+        opts["DocumentOpenPassword"] = option{"string", o.Password}
+    }
+    if o.PDFA != "" {
+        opts["SelectPdfVersion"] = option{"long", strconv.Itoa(pdfALevels[o.PDFA])}
+    }
+    if o.Watermark != "" {
+        opts["Watermark"] = option{"string", o.Watermark}
+    }
+    if len(opts) == 0 {
+        return "pdf"
+    }
+    js, _ := json.Marshal(opts)
+    return "pdf:calc_pdf_Export:" + string(js)
+}
+
+// convertWithSoffice turns the workbook at xlsxPath into PDF bytes
+func convertWithSoffice(ctx context.Context, xlsxPath string, opts pdfOptions, lg *requestLogger) (pdfData []byte, err error) {
+    if err := sofficeBreaker.allow(); err != nil {
+        return nil, err
+    }
+    defer func() {
+        if ctx.Err() != nil { // an abandoned request says nothing about soffice
+            sofficeBreaker.abandon()
+            return
+        }
+        sofficeBreaker.record(err)
+    }()
+
+    attempts := envInt("PDF_ATTEMPTS", 2)
+    profile := "" // LibreOffice's default profile on the first try
+    for attempt := 1; ; attempt++ {
+        pdfData, err = runSoffice(ctx, xlsxPath, profile, opts, lg)
+        if err == nil || !errors.Is(err, errSofficeFailed) || attempt >= attempts {
+            return pdfData, err
+        }
+        lg.Printf("LibreOffice attempt %d failed, retrying with a fresh profile: %v", attempt, err)
+        if profile == "" {
+            dir, mkErr := os.MkdirTemp("", "lo-profile-")
+            if mkErr != nil {
+                return nil, err
+            }
+            defer os.RemoveAll(dir)
+            profile = dir
+        }
+    }
+}
+
+// runSoffice makes one conversion attempt. profile, when set, is used as
+// the LibreOffice user installation instead of the default one.
+func runSoffice(ctx context.Context, xlsxPath, profile string, opts pdfOptions, lg *requestLogger) ([]byte, error) {
+    tmpDir, err := os.MkdirTemp("", "pdf-")
+    if err != nil {
+        return nil, fmt.Errorf("create temp dir: %w", err)
+    }
+    defer os.RemoveAll(tmpDir)
+
+    // PDF_TIMEOUT bounds one conversion; the client going away ends it too
+    timeout := envDuration("PDF_TIMEOUT", 60*time.Second)
+    execCtx, cancel := context.WithTimeout(ctx, timeout)
+    defer cancel()
+
+    args := []string{"--headless", "--convert-to", opts.exportFilter(), "--outdir", tmpDir, xlsxPath}
+    if profile != "" {
+        args = append([]string{"-env:UserInstallation=file://" + filepath.ToSlash(profile)}, args...)
+    }
+    cmd := exec.CommandContext(execCtx, "soffice", args...)
+    // soffice forks soffice.bin; kill the whole process group, not just
+    // the launcher, so nothing keeps writing into tmpDir after cleanup.
+    cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+    cmd.Cancel = func() error {
+        return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+    }
+    cmd.WaitDelay = 5 * time.Second
+
+    // Capture output for debugging
+    _, execSpan := startSpan(ctx, "soffice.exec", spanKindInternal)
+    execSpan.SetAttr("fresh_profile", profile != "")
+    output, err := cmd.CombinedOutput()
+    execSpan.RecordError(err)
+    execSpan.End()
+    if execCtx.Err() != nil {
+        if ctx.Err() != nil {
+            lg.Printf("LibreOffice conversion abandoned: %v", ctx.Err())
+            return nil, ctx.Err()
+        }
+        lg.Printf("❌ LibreOffice conversion timed out after %s", timeout)
+        return nil, fmt.Errorf("libreoffice conversion timed out after %s", timeout)
+    }
+    if err != nil {
+        lg.Printf("❌ LibreOffice conversion failed: %s", string(output))
+        noteErrorExtra(ctx, "libreoffice_output", string(output))
+        return nil, fmt.Errorf("%w: %v\nOutput: %s", errSofficeFailed, err, string(output))
+    }
+
+    lg.Printf("LibreOffice output: %s", string(output))
+
+    // Find the generated PDF file
+    files, err := os.ReadDir(tmpDir)
+    if err != nil {
+        return nil, fmt.Errorf("read output dir: %w", err)
+    }
+    if len(files) == 0 {
+        noteErrorExtra(ctx, "libreoffice_output", string(output))
+        return nil, fmt.Errorf("%w: no PDF generated", errSofficeFailed)
+    }
+
+    pdfData, err := os.ReadFile(filepath.Join(tmpDir, files[0].Name()))
+    if err != nil {
+        return nil, fmt.Errorf("read pdf: %w", err)
+    }
+    return pdfData, nil
+}
+
+/* ----- circuit breaker ----- */
+
+type circuitBreaker struct {
+    name      string
+    threshold int
+    cooldown  time.Duration
+
+    mu       sync.Mutex
+    failures int
+    open     bool
+    openedAt time.Time
+    trial    bool // a half-open trial is in flight
+    lastErr  string
+}
+
+var sofficeBreaker = &circuitBreaker{
+    name:      "libreoffice",
+    threshold: envInt("PDF_BREAKER_THRESHOLD", 5),
+    cooldown:  envDuration("PDF_BREAKER_COOLDOWN", 30*time.Second),
+}
+
+// allow returns errCircuitOpen while the breaker is open, except for one
+// trial call once the cooldown has passed.
+func (b *circuitBreaker) allow() error {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if !b.open {
+        return nil
+    }
+    if time.Since(b.openedAt) < b.cooldown || b.trial {
+        return errCircuitOpen
+    }
+    b.trial = true
+    return nil
+}
+
+// record feeds the outcome of an allowed call back into the breaker
+func (b *circuitBreaker) record(err error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    wasTrial := b.trial
+    b.trial = false
+    if err == nil {
+        if b.open {
+            log.Printf("Circuit %s closed", b.name)
+        }
+        b.failures, b.open, b.lastErr = 0, false, ""
+        return
+    }
+    b.failures++
+    b.lastErr = err.Error()
+    if wasTrial || (!b.open && b.failures >= b.threshold) {
+        if !b.open {
+            log.Printf("Circuit %s opened after %d consecutive failures: %v", b.name, b.failures, err)
+        }
+        b.open, b.openedAt = true, time.Now()
+    }
+}
+
+// abandon ends an allowed call without an outcome, as when its client went
+// away: it counts neither way, but a half-open trial no longer blocks the
+// next one
+func (b *circuitBreaker) abandon() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    b.trial = false
+}
+
+// retryAfter is how long until the breaker lets a trial through
+func (b *circuitBreaker) retryAfter() time.Duration {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if !b.open {
+        return 0
+    }
+    if d := b.cooldown - time.Since(b.openedAt); d > 0 {
+        return d
+    }
+    return 0
+}
+
+// check reports the breaker for /health/ready
+func (b *circuitBreaker) check(ctx context.Context) (string, error) {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.open {
+        return "", fmt.Errorf("open after %d consecutive failures, last: %s", b.failures, b.lastErr)
+    }
+    return fmt.Sprintf("closed (%d recent failures)", b.failures), nil
+}
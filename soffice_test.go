@@ -0,0 +1,32 @@
+package main
+
+import (
+    "errors"
+    "testing"
+    "time"
+)
+
+func TestCircuitBreakerAbandonedTrial(t *testing.T) {
+    b := &circuitBreaker{name: "test", threshold: 1, cooldown: time.Millisecond}
+    b.record(errors.New("soffice crashed"))
+    if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+        t.Fatalf("allowed during the cooldown: %v", err)
+    }
+    time.Sleep(2 * time.Millisecond)
+    if err := b.allow(); err != nil {
+        t.Fatalf("no trial after the cooldown: %v", err)
+    }
+    if err := b.allow(); !errors.Is(err, errCircuitOpen) {
+        t.Fatalf("second call during the trial: %v", err)
+    }
+
+    // the trial's client hangs up; the next call gets to try
+    b.abandon()
+    if err := b.allow(); err != nil {
+        t.Fatalf("still open after an abandoned trial: %v", err)
+    }
+    b.record(nil)
+    if err := b.allow(); err != nil || b.open {
+        t.Errorf("not closed after a good trial: %v", err)
+    }
+}
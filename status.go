@@ -0,0 +1,149 @@
+package main
+
+import (
+    "encoding/json"
+    "net/http"
+    "time"
+)
+
+/* ===============
+   Timecard status
+   =============== */
+
+// GET /api/timecards/{id}/status tells the app how far a kept card has got,
+// so it can show progress instead of guessing:
+//
+//   {"timecard_id": "...", "revision": 1, "current": "emailed", "stages": [
+//     {"name": "generated", "state": "done", "at": "..."},
+//     {"name": "converted", "state": "pending"},
+//     ...]}
+//
+// The stages are always generated, converted, emailed, delivered and
+// approved, in that order. A stage is done, pending, queued (a fax the
+// provider has yet to send), failed, rejected (by the manager, with the
+// comment as detail) or skipped (approved, when no manager was asked).
+// Everything is read off the record: its kind, delivery receipts and
+// approval. current is the last stage that is done.
+
+// StatusStage is one step of a card's lifecycle
+type StatusStage struct {
+    Name   string     `json:"name"`
+    State  string     `json:"state"`
+    At     *time.Time `json:"at,omitempty"`
+    Detail string     `json:"detail,omitempty"`
+}
+
+// TimecardStatus is the body of GET /api/timecards/{id}/status
+type TimecardStatus struct {
+    TimecardID string        `json:"timecard_id"`
+    Revision   int           `json:"revision"`
+    Current    string        `json:"current"`
+    Stages     []StatusStage `json:"stages"`
+}
+
+func timecardStatus(w http.ResponseWriter, r *http.Request, id string) {
+    rec, ok := timecards.Get(id)
+    if !ok {
+        httpError(w, r, "timecard not found", http.StatusNotFound)
+        return
+    }
+    writeJSON(w, http.StatusOK, recordStatus(rec))
+}
+
+// recordStatus works out rec's stages
+func recordStatus(rec TimecardRecord) TimecardStatus {
+    created := rec.CreatedAt
+    done := func(name string, at time.Time, detail string) StatusStage {
+        return StatusStage{Name: name, State: "done", At: &at, Detail: detail}
+    }
+    pending := func(name string) StatusStage { return StatusStage{Name: name, State: "pending"} }
+
+    generated := done("generated", created, rec.Kind)
+
+    converted := pending("converted")
+    if rec.Kind == "pdf" || rec.Kind == "bundle" {
+        converted = done("converted", created, "")
+    } else if d, ok := firstReceipt(rec, func(d DeliveryReceipt) bool {
+        ch, ok := delivererFor(d.Channel)
+        return ok && ch.Format() == "pdf" && d.Status != "failed"
+    }); ok {
+        converted = done("converted", d.At, "for "+d.Channel)
+    }
+
+    emailed, delivered := pending("emailed"), pending("delivered")
+    if rec.Kind == "email" {
+        to := emailedTo(rec)
+        emailed = done("emailed", created, to)
+        delivered = done("delivered", created, "email to "+to)
+    }
+    if emailed.State != "done" {
+        emailed = receiptStage("emailed", rec, func(d DeliveryReceipt) bool { return d.Channel == "email" })
+    }
+    if delivered.State != "done" {
+        delivered = receiptStage("delivered", rec, func(DeliveryReceipt) bool { return true })
+    }
+
+    approved := StatusStage{Name: "approved", State: "skipped"}
+    if a := rec.Approval; a != nil {
+        switch a.Status {
+        case approvalApproved:
+            approved = StatusStage{Name: "approved", State: "done", At: a.DecidedAt, Detail: a.Manager}
+        case approvalRejected:
+            approved = StatusStage{Name: "approved", State: "rejected", At: a.DecidedAt, Detail: a.Comment}
+        default:
+            approved = StatusStage{Name: "approved", State: "pending", Detail: "awaiting " + a.Manager}
+        }
+    }
+
+    st := TimecardStatus{
+        TimecardID: rec.ID,
+        Revision:   rec.revision(),
+        Stages:     []StatusStage{generated, converted, emailed, delivered, approved},
+    }
+    for _, s := range st.Stages {
+        if s.State == "done" {
+            st.Current = s.Name
+        }
+    }
+    return st
+}
+
+// receiptStage is done with the first receipt matching match that went
+// out, queued if the best so far is queued, failed if every attempt failed
+func receiptStage(name string, rec TimecardRecord, match func(DeliveryReceipt) bool) StatusStage {
+    stage := StatusStage{Name: name, State: "pending"}
+    for _, d := range rec.Deliveries {
+        if !match(d) {
+            continue
+        }
+        at := d.At
+        switch {
+        case d.Status == "sent":
+            return StatusStage{Name: name, State: "done", At: &at, Detail: d.Channel + " to " + d.To}
+        case d.Status == "queued" && stage.State != "queued":
+            stage = StatusStage{Name: name, State: "queued", At: &at, Detail: d.Channel + " to " + d.To}
+        case d.Status == "failed" && stage.State == "pending":
+            stage = StatusStage{Name: name, State: "failed", At: &at, Detail: d.Error}
+        }
+    }
+    return stage
+}
+
+// firstReceipt returns rec's first delivery receipt matching match
+func firstReceipt(rec TimecardRecord, match func(DeliveryReceipt) bool) (DeliveryReceipt, bool) {
+    for _, d := range rec.Deliveries {
+        if match(d) {
+            return d, true
+        }
+    }
+    return DeliveryReceipt{}, false
+}
+
+// emailedTo is the recipient an "email" record was sent to
+func emailedTo(rec TimecardRecord) string {
+    var p struct {
+        To string `json:"to"`
+    }
+    _ = json.Unmarshal(rec.Payload, &p)
+    return p.To
+}
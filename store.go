@@ -0,0 +1,110 @@
+package main
+
+import (
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+    "sync"
+)
+
+/* ===========================
+   Persistence (JSON on disk)
+   =========================== */
+
+// dataDir is where server-side state lives (DATA_DIR, default "data").
+// Each collection is a single JSON file rewritten atomically on change,
+// which is plenty for the record counts one contractor produces.
+func dataDir() string {
+    return envOr("DATA_DIR", "data")
+}
+
+type collection[T any] struct {
+    mu    sync.RWMutex
+    path  string
+    items map[string]T
+}
+
+// openCollection loads (or creates) the named collection under dataDir
+func openCollection[T any](name string) *collection[T] {
+    c := &collection[T]{
+        path:  filepath.Join(dataDir(), name+".json"),
+        items: make(map[string]T),
+    }
+    data, err := os.ReadFile(c.path)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Printf("Warning: could not read %s: %v", c.path, err)
+        }
+        return c
+    }
+    if err := json.Unmarshal(data, &c.items); err != nil {
+        log.Printf("Warning: could not parse %s: %v", c.path, err)
+    }
+    return c
+}
+
+func (c *collection[T]) Get(id string) (T, bool) {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    v, ok := c.items[id]
+    return v, ok
+}
+
+// List returns all items ordered by id
+func (c *collection[T]) List() []T {
+    c.mu.RLock()
+    defer c.mu.RUnlock()
+    ids := make([]string, 0, len(c.items))
+    for id := range c.items {
+        ids = append(ids, id)
+    }
+    sort.Strings(ids)
+    out := make([]T, 0, len(ids))
+    for _, id := range ids {
+        out = append(out, c.items[id])
+    }
+    return out
+}
+
+func (c *collection[T]) Put(id string, v T) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.items[id] = v
+    return c.saveLocked()
+}
+
+func (c *collection[T]) Delete(id string) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    delete(c.items, id)
+    return c.saveLocked()
+}
+
+func (c *collection[T]) saveLocked() error {
+    data, err := json.MarshalIndent(c.items, "", "  ")
+    if err != nil {
+        return err
+    }
+    if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+        return fmt.Errorf("create data dir: %w", err)
+    }
+    tmp := c.path + ".tmp"
+    if err := os.WriteFile(tmp, data, 0o600); err != nil {
+        return fmt.Errorf("write %s: %w", tmp, err)
+    }
+    return os.Rename(tmp, c.path)
+}
+
+// newID returns a random 16-hex-character identifier
+func newID() string {
+    b := make([]byte, 8)
+    if _, err := rand.Read(b); err != nil {
+        panic(err)
+    }
+    return hex.EncodeToString(b)
+}
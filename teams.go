@@ -0,0 +1,112 @@
+package main
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "io"
+    "net/http"
+    "os"
+    "strings"
+    "time"
+)
+
+/* ===================
+   Teams notifications
+   =================== */
+
+// With TEAMS_WEBHOOK_URL set (an incoming webhook or a Workflows "post to
+// channel" URL), every generated or emailed card is posted to Teams as an
+// Adaptive Card with the employee, period and regular/OT totals, plus an
+// Open button when the stored file has a share link.
+
+type teamsNotifier struct {
+    webhookURL string
+    client     *http.Client
+}
+
+func init() {
+    u := os.Getenv("TEAMS_WEBHOOK_URL")
+    if u == "" {
+        return
+    }
+    t := teamsNotifier{webhookURL: u, client: &http.Client{Timeout: 10 * time.Second}}
+    subscribeEvents(queuedSubscriber("teams", t.notify))
+}
+
+func (t teamsNotifier) notify(ev webhookEvent) error {
+    card := teamsCard(ev)
+    if card == nil {
+        return nil
+    }
+    body, err := json.Marshal(map[string]interface{}{
+        "type": "message",
+        "attachments": []interface{}{map[string]interface{}{
+            "contentType": "application/vnd.microsoft.card.adaptive",
+            "content":     card,
+        }},
+    })
+    if err != nil {
+        return err
+    }
+    resp, err := t.client.Post(t.webhookURL, "application/json", bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+        return fmt.Errorf("teams returned %s: %s", resp.Status, strings.TrimSpace(string(data)))
+    }
+    return nil
+}
+
+// teamsCard is the Adaptive Card for ev, or nil for events Teams doesn't get
+func teamsCard(ev webhookEvent) map[string]interface{} {
+    data, _ := ev.Data.(map[string]interface{})
+    var title string
+    switch ev.Type {
+    case "timecard.generated":
+        title = "Timecard submitted"
+    case "email.sent":
+        title = "Timecard emailed"
+    default:
+        return nil
+    }
+
+    fact := func(k, v string) map[string]string { return map[string]string{"title": k, "value": v} }
+    regular, _ := data["regular_hours"].(float64)
+    overtime, _ := data["overtime_hours"].(float64)
+    total, _ := data["total_hours"].(float64)
+    facts := []map[string]string{
+        fact("Employee", fmt.Sprint(data["employee"])),
+        fact("Pay period", fmt.Sprintf("PP %v / %v", data["pay_period_num"], data["year"])),
+        fact("Regular", formatHours(regular)+" h"),
+        fact("Overtime", formatHours(overtime)+" h"),
+        fact("Total", formatHours(total)+" h"),
+    }
+    if ev.Type == "email.sent" {
+        facts = append(facts, fact("Sent to", fmt.Sprint(data["to"])))
+    }
+    if t := lookupTenant(ev.Tenant); ev.Tenant != "" && ev.Tenant != defaultTenantID && t.Name != "" {
+        facts = append(facts, fact("Company", t.Name))
+    }
+
+    card := map[string]interface{}{
+        "$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+        "type":    "AdaptiveCard",
+        "version": "1.4",
+        "body": []interface{}{
+            map[string]interface{}{"type": "TextBlock", "size": "Medium", "weight": "Bolder", "text": title},
+            map[string]interface{}{"type": "FactSet", "facts": facts},
+        },
+    }
+    if id, ok := data["timecard_id"].(string); ok {
+        if link := timecardLink(id); link != "" {
+            card["actions"] = []interface{}{
+                map[string]interface{}{"type": "Action.OpenUrl", "title": "Open", "url": link},
+            }
+        }
+    }
+    return card
+}
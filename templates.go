@@ -0,0 +1,608 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "regexp"
+    "sort"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* =========================
+   Templates & cell mapping
+   ========================= */
+
+const (
+    defaultTemplateName = "default"
+    bundleFormatVersion = 1
+)
+
+// CellMap tells fillWeekSheet where things live in a template. The default
+// map describes the bundled template.xlsx.
+type CellMap struct {
+    EmployeeName  string `json:"employee_name"`
+    EmployeeID    string `json:"employee_id,omitempty"`
+    PayrollNumber string `json:"payroll_number,omitempty"`
+    PayPeriod     string `json:"pay_period"`
+    Year          string `json:"year"`
+    WeekStart     string `json:"week_start"`
+    WeekLabel     string `json:"week_label"`
+    Revision      string `json:"revision"` // "Rev. N" on resubmitted cards
+
+    RegularHeaderRow  int `json:"regular_header_row"`
+    RegularFirstRow   int `json:"regular_first_row"`
+    OvertimeHeaderRow int `json:"overtime_header_row"`
+    OvertimeFirstRow  int `json:"overtime_first_row"`
+    // RegularCostRow and OvertimeCostRow take each column's phase and cost
+    // code (costcodes.go)
+    RegularCostRow  int `json:"regular_cost_row,omitempty"`
+    OvertimeCostRow int `json:"overtime_cost_row,omitempty"`
+
+    DateColumn     string   `json:"date_column"`
+    DayLabelColumn string   `json:"day_label_column"`
+    CodeColumns    []string `json:"code_columns"` // labour codes
+    JobColumns     []string `json:"job_columns"`  // job numbers, paired with CodeColumns
+
+    // NightPremiumColumn holds each day's night premium hours, on the
+    // regular table's rows, and NightPremiumTotal the week's (nightdiff.go)
+    NightPremiumColumn string `json:"night_premium_column,omitempty"`
+    NightPremiumTotal  string `json:"night_premium_total,omitempty"`
+
+    // NotesColumn holds each day's entry notes; without it they are cell
+    // comments (notes.go)
+    NotesColumn string `json:"notes_column,omitempty"`
+
+    // Expenses is the area of each week sheet for the week's expense
+    // lines; without it they get a sheet of their own (expenses.go)
+    Expenses *ExpenseArea `json:"expenses,omitempty"`
+
+    // Travel is the area of each week sheet for the week's trips; without
+    // it they get a sheet of their own (travel.go)
+    Travel *TravelArea `json:"travel,omitempty"`
+
+    EmployeeSignature   SignatureCells `json:"employee_signature"`
+    SupervisorSignature SignatureCells `json:"supervisor_signature"`
+    // Supervisor and ApprovedAt are the approval block's name and date
+    // cells (signature.go)
+    Supervisor string `json:"supervisor,omitempty"`
+    ApprovedAt string `json:"approved_at,omitempty"`
+
+    // Labels are the static captions rewritten in bilingual mode. An
+    // imported template has only the ones its cellmap.json lists.
+    Labels []dualLabel `json:"labels,omitempty"`
+
+    // Print is how the week sheets print (printconfig.go)
+    Print *PrintConfig `json:"print,omitempty"`
+}
+
+// StyleRules are applied after filling each week sheet
+type StyleRules struct {
+    Borders []borderRule `json:"borders,omitempty"`
+}
+
+type borderRule struct {
+    From  string `json:"from"`
+    To    string `json:"to"`
+    Style int    `json:"style"` // excelize border style, 1 = thin
+    Color string `json:"color"`
+}
+
+// templateDef is a resolved template: workbook on disk plus its mapping
+type templateDef struct {
+    Name    string
+    Path    string
+    CellMap CellMap
+    Style   StyleRules
+    Sample  *TimecardRequest
+}
+
+func defaultCellMap() CellMap {
+    return CellMap{
+        EmployeeName:        "M2",
+        PayPeriod:           "AJ2",
+        Year:                "AJ3",
+        WeekStart:           "B4",
+        WeekLabel:           "AJ4",
+        Revision:            "AI1",
+        NightPremiumTotal:   "AI13",
+        RegularHeaderRow:    4,
+        RegularFirstRow:     5,
+        OvertimeHeaderRow:   15,
+        OvertimeFirstRow:    16,
+        DateColumn:          "B",
+        DayLabelColumn:      "A",
+        CodeColumns:         []string{"C", "E", "G", "I", "K", "M", "O", "Q", "S", "U", "W", "Y", "AA", "AC", "AE", "AG"},
+        JobColumns:          []string{"D", "F", "H", "J", "L", "N", "P", "R", "T", "V", "X", "Z", "AB", "AD", "AF", "AH"},
+        EmployeeSignature:   SignatureCells{Image: "C25", Text: "M25"},
+        SupervisorSignature: SignatureCells{Image: "C24", Text: "M24"},
+        Labels:              templateLabels,
+    }
+}
+
+// importedCellMap is what an imported template's cellmap.json is laid
+// over: the default's cells, but none of its captions, which needn't be
+// where the default template has them
+func importedCellMap() CellMap {
+    cm := defaultCellMap()
+    cm.Labels = nil
+    return cm
+}
+
+func defaultStyleRules() StyleRules {
+    return StyleRules{Borders: []borderRule{
+        // Regular Time table
+        {From: "A4", To: "AJ12", Style: 1, Color: "000000"},
+        // Overtime table
+        {From: "A15", To: "AJ24", Style: 1, Color: "000000"},
+    }}
+}
+
+func (m CellMap) validate() error {
+    if len(m.CodeColumns) == 0 || len(m.CodeColumns) != len(m.JobColumns) {
+        return fmt.Errorf("cell map: code_columns and job_columns must be non-empty and the same length")
+    }
+    if m.RegularFirstRow <= 0 || m.OvertimeFirstRow <= 0 || m.RegularHeaderRow <= 0 || m.OvertimeHeaderRow <= 0 {
+        return fmt.Errorf("cell map: header and first rows must be positive")
+    }
+    if m.DateColumn == "" {
+        return fmt.Errorf("cell map: date_column is required")
+    }
+    if m.Print != nil {
+        if err := m.Print.validate(); err != nil {
+            return fmt.Errorf("cell map: print: %w", err)
+        }
+    }
+    return nil
+}
+
+// templatesMu keeps imports from swapping a template directory out from
+// under a request that is opening it (which used to fall back to the
+// basic workbook mid-import).
+var templatesMu sync.RWMutex
+
+// templateBytes caches template files in memory by path. Each generation
+// gets its own excelize.File parsed from the cached bytes, so nothing
+// mutable is shared; an entry is reused only while the file's size and
+// modification time are unchanged, so edits on disk are picked up.
+var (
+    templateCacheMu sync.Mutex
+    templateCache   = map[string]cachedTemplate{}
+)
+
+type cachedTemplate struct {
+    data    []byte
+    size    int64
+    modTime time.Time
+    footers map[string]excelize.HeaderFooterOptions // by sheet, for stampArtifactRef
+}
+
+// readTemplateBytes returns the file's contents, from the cache when fresh.
+// Callers hold templatesMu.
+func readTemplateBytes(path string) ([]byte, error) {
+    info, err := os.Stat(path)
+    if err != nil {
+        return nil, err
+    }
+    templateCacheMu.Lock()
+    c, ok := templateCache[path]
+    templateCacheMu.Unlock()
+    if ok && c.size == info.Size() && c.modTime.Equal(info.ModTime()) {
+        return c.data, nil
+    }
+
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return nil, err
+    }
+    footers, err := readHeaderFooters(data)
+    if err != nil {
+        log.Printf("Warning: could not read page footers of %s: %v", path, err)
+    }
+    templateCacheMu.Lock()
+    templateCache[path] = cachedTemplate{data: data, size: info.Size(), modTime: info.ModTime(), footers: footers}
+    templateCacheMu.Unlock()
+    return data, nil
+}
+
+// invalidateTemplateCache drops cached files under dir (or everything for "")
+func invalidateTemplateCache(dir string) {
+    templateCacheMu.Lock()
+    defer templateCacheMu.Unlock()
+    for path := range templateCache {
+        if dir == "" || strings.HasPrefix(path, dir+string(filepath.Separator)) {
+            delete(templateCache, path)
+        }
+    }
+}
+
+// templateFooters returns the template's page headers/footers by sheet
+// name, as last loaded by openTemplate.
+func templateFooters(t *templateDef) map[string]excelize.HeaderFooterOptions {
+    templateCacheMu.Lock()
+    defer templateCacheMu.Unlock()
+    return templateCache[t.Path].footers
+}
+
+// openTemplate opens a private excelize.File for one generation
+func openTemplate(t *templateDef) (*excelize.File, error) {
+    templatesMu.RLock()
+    defer templatesMu.RUnlock()
+    data, err := readTemplateBytes(t.Path)
+    if err != nil {
+        return nil, err
+    }
+    return excelize.OpenReader(bytes.NewReader(data))
+}
+
+// templatesDir holds imported bundles, one unpacked directory per template.
+// Bundles imported for the default tenant sit at the top and are shared
+// with every tenant; other tenants' sit under "@<tenant>", a name no
+// template can have, and only that tenant sees them.
+func templatesDir() string {
+    return envOr("TEMPLATES_DIR", filepath.Join(dataDir(), "templates"))
+}
+
+// tenantTemplatesDir is where tenant's own bundles are installed
+func tenantTemplatesDir(tenant string) string {
+    if tenant == "" || tenant == defaultTenantID {
+        return templatesDir()
+    }
+    return filepath.Join(templatesDir(), "@"+pathSegment(tenant))
+}
+
+var templateNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// resolveTemplate returns tenant's named template (its own before the shared
+// ones), or the bundled default for ""
+func resolveTemplate(tenant, name string) (*templateDef, error) {
+    if name == "" || name == defaultTemplateName {
+        return &templateDef{
+            Name:    defaultTemplateName,
+            Path:    "template.xlsx",
+            CellMap: defaultCellMap(),
+            Style:   defaultStyleRules(),
+        }, nil
+    }
+    if !templateNamePattern.MatchString(name) {
+        return nil, fmt.Errorf("invalid template name %q", name)
+    }
+
+    dir := filepath.Join(tenantTemplatesDir(tenant), name)
+    if _, err := os.Stat(filepath.Join(dir, "template.xlsx")); err != nil {
+        dir = filepath.Join(templatesDir(), name)
+    }
+    t := &templateDef{Name: name, Path: filepath.Join(dir, "template.xlsx")}
+    if _, err := os.Stat(t.Path); err != nil {
+        return nil, fmt.Errorf("unknown template %q", name)
+    }
+    t.CellMap = importedCellMap()
+    if err := readJSONFile(filepath.Join(dir, "cellmap.json"), &t.CellMap); err != nil {
+        return nil, err
+    }
+    t.Style = defaultStyleRules()
+    if err := readJSONFile(filepath.Join(dir, "style.json"), &t.Style); err != nil {
+        return nil, err
+    }
+    var sample TimecardRequest
+    if err := readJSONFile(filepath.Join(dir, "sample.json"), &sample); err == nil && sample.EmployeeName != "" {
+        t.Sample = &sample
+    }
+    return t, nil
+}
+
+// readJSONFile decodes path into v; a missing file leaves v untouched
+func readJSONFile(path string, v interface{}) error {
+    data, err := os.ReadFile(path)
+    if os.IsNotExist(err) {
+        return nil
+    }
+    if err != nil {
+        return err
+    }
+    if err := json.Unmarshal(data, v); err != nil {
+        return fmt.Errorf("parse %s: %w", filepath.Base(path), err)
+    }
+    return nil
+}
+
+/* ----- bundle archive -----
+
+   A template bundle is a zip containing:
+     manifest.json   {"name", "description", "format_version", "exported_at"}
+     template.xlsx   the workbook
+     cellmap.json    CellMap
+     style.json      StyleRules (optional)
+     sample.json     a TimecardRequest that renders nicely (optional)
+*/
+
+type bundleManifest struct {
+    Name          string    `json:"name"`
+    Description   string    `json:"description,omitempty"`
+    FormatVersion int       `json:"format_version"`
+    ExportedAt    time.Time `json:"exported_at"`
+}
+
+const maxBundleSize = 20 << 20
+
+func exportBundle(t *templateDef) ([]byte, error) {
+    xlsx, err := os.ReadFile(t.Path)
+    if err != nil {
+        return nil, fmt.Errorf("read workbook: %w", err)
+    }
+
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    add := func(name string, data []byte) error {
+        w, err := zw.Create(name)
+        if err != nil {
+            return err
+        }
+        _, err = w.Write(data)
+        return err
+    }
+    addJSON := func(name string, v interface{}) error {
+        data, err := json.MarshalIndent(v, "", "  ")
+        if err != nil {
+            return err
+        }
+        return add(name, data)
+    }
+
+    manifest := bundleManifest{Name: t.Name, FormatVersion: bundleFormatVersion, ExportedAt: time.Now().UTC()}
+    if err := addJSON("manifest.json", manifest); err != nil {
+        return nil, err
+    }
+    if err := add("template.xlsx", xlsx); err != nil {
+        return nil, err
+    }
+    if err := addJSON("cellmap.json", t.CellMap); err != nil {
+        return nil, err
+    }
+    if err := addJSON("style.json", t.Style); err != nil {
+        return nil, err
+    }
+    if t.Sample != nil {
+        if err := addJSON("sample.json", t.Sample); err != nil {
+            return nil, err
+        }
+    }
+    if err := zw.Close(); err != nil {
+        return nil, err
+    }
+    return buf.Bytes(), nil
+}
+
+// importBundle validates a bundle archive and installs it for tenant. name
+// overrides the manifest name when non-empty.
+func importBundle(data []byte, name, tenant string, lg *requestLogger) (*templateDef, error) {
+    zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+    if err != nil {
+        return nil, fmt.Errorf("not a zip archive: %w", err)
+    }
+
+    files := map[string][]byte{}
+    for _, zf := range zr.File {
+        switch zf.Name {
+        case "manifest.json", "template.xlsx", "cellmap.json", "style.json", "sample.json":
+        default:
+            continue
+        }
+        rc, err := zf.Open()
+        if err != nil {
+            return nil, fmt.Errorf("open %s: %w", zf.Name, err)
+        }
+        b, err := io.ReadAll(io.LimitReader(rc, maxBundleSize))
+        rc.Close()
+        if err != nil {
+            return nil, fmt.Errorf("read %s: %w", zf.Name, err)
+        }
+        files[zf.Name] = b
+    }
+
+    var manifest bundleManifest
+    if b, ok := files["manifest.json"]; ok {
+        if err := json.Unmarshal(b, &manifest); err != nil {
+            return nil, fmt.Errorf("parse manifest.json: %w", err)
+        }
+    }
+    if manifest.FormatVersion > bundleFormatVersion {
+        return nil, fmt.Errorf("bundle format %d is newer than supported (%d)", manifest.FormatVersion, bundleFormatVersion)
+    }
+    if name == "" {
+        name = manifest.Name
+    }
+    if name == defaultTemplateName || !templateNamePattern.MatchString(name) {
+        return nil, fmt.Errorf("invalid template name %q", name)
+    }
+    if files["template.xlsx"] == nil || files["cellmap.json"] == nil {
+        return nil, fmt.Errorf("bundle must contain template.xlsx and cellmap.json")
+    }
+
+    cm := importedCellMap()
+    if err := json.Unmarshal(files["cellmap.json"], &cm); err != nil {
+        return nil, fmt.Errorf("parse cellmap.json: %w", err)
+    }
+    if err := cm.validate(); err != nil {
+        return nil, err
+    }
+    if b, ok := files["style.json"]; ok {
+        var st StyleRules
+        if err := json.Unmarshal(b, &st); err != nil {
+            return nil, fmt.Errorf("parse style.json: %w", err)
+        }
+    }
+    wb, err := excelize.OpenReader(bytes.NewReader(files["template.xlsx"]))
+    if err != nil {
+        return nil, fmt.Errorf("template.xlsx: %w", err)
+    }
+    sheetCount := len(wb.GetSheetList())
+    _ = wb.Close()
+    if sheetCount == 0 {
+        return nil, fmt.Errorf("template.xlsx has no sheets")
+    }
+
+    delete(files, "manifest.json")
+    keep, restore, err := installTemplate(tenant, name, files)
+    if err != nil {
+        return nil, err
+    }
+
+    t, err := resolveTemplate(tenant, name)
+    if err != nil {
+        restore()
+        return nil, err
+    }
+
+    // A bundle that ships sample data must be able to render it; one that
+    // can't is taken out again and the template it replaced put back
+    if t.Sample != nil {
+        sample := *t.Sample
+        sample.Template = name
+        if _, err := generateExcelFile(newGenContext(context.Background(), sample, lookupTenant(tenant), lg)); err != nil {
+            lg.Printf("Template %s: sample render failed: %v", name, err)
+            restore()
+            return nil, fmt.Errorf("sample.json does not render: %w", err)
+        }
+    }
+    keep()
+    lg.Printf("Imported template %q (%d sheets)", name, sheetCount)
+    return t, nil
+}
+
+// installTemplate writes a template's files to a staging dir, then swaps it
+// in for tenant's template name. The template it replaces is set aside
+// until the caller either keeps the new one or restores the old.
+func installTemplate(tenant, name string, files map[string][]byte) (keep, restore func(), err error) {
+    dir := filepath.Join(tenantTemplatesDir(tenant), name)
+    staging := dir + ".importing"
+    previous := dir + ".previous"
+    _ = os.RemoveAll(staging)
+    if err := os.MkdirAll(staging, 0o755); err != nil {
+        return nil, nil, fmt.Errorf("create template dir: %w", err)
+    }
+    for fn, b := range files {
+        if err := os.WriteFile(filepath.Join(staging, fn), b, 0o644); err != nil {
+            _ = os.RemoveAll(staging)
+            return nil, nil, fmt.Errorf("write %s: %w", fn, err)
+        }
+    }
+
+    // putBack moves the set-aside template (if there was one) back to dir
+    putBack := func() {
+        _ = os.RemoveAll(dir)
+        if err := os.Rename(previous, dir); err != nil && !os.IsNotExist(err) {
+            log.Printf("Warning: could not restore template %s: %v", dir, err)
+        }
+        invalidateTemplateCache(dir)
+        renders().purge()
+    }
+
+    templatesMu.Lock()
+    _ = os.RemoveAll(previous)
+    err = os.Rename(dir, previous)
+    if err == nil || os.IsNotExist(err) {
+        if err = os.Rename(staging, dir); err != nil {
+            putBack()
+        }
+    }
+    invalidateTemplateCache(dir)
+    renders().purge()
+    templatesMu.Unlock()
+    if err != nil {
+        _ = os.RemoveAll(staging)
+        return nil, nil, fmt.Errorf("install template: %w", err)
+    }
+    keep = func() { _ = os.RemoveAll(previous) }
+    restore = func() {
+        templatesMu.Lock()
+        defer templatesMu.Unlock()
+        putBack()
+    }
+    return keep, restore, nil
+}
+
+/* ----- API ----- */
+
+// templatesHandler serves:
+//   GET  /api/templates
+//   POST /api/templates/import[?name=...]   (body: bundle zip)
+//   GET  /api/templates/{name}/export
+func templatesHandler(w http.ResponseWriter, r *http.Request) {
+    rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/templates"), "/")
+    parts := strings.Split(rest, "/")
+
+    switch {
+    case rest == "" && r.Method == http.MethodGet:
+        writeJSON(w, http.StatusOK, listTemplates(tenantFor(r).ID))
+
+    case rest == "import" && r.Method == http.MethodPost:
+        data, err := io.ReadAll(io.LimitReader(r.Body, maxBundleSize+1))
+        if err != nil {
+            httpError(w, r, fmt.Sprintf("invalid request: %v", err), http.StatusBadRequest)
+            return
+        }
+        if len(data) > maxBundleSize {
+            httpError(w, r, "bundle too large", http.StatusRequestEntityTooLarge)
+            return
+        }
+        t, err := importBundle(data, r.URL.Query().Get("name"), tenantFor(r).ID, loggerFrom(r.Context()))
+        if err != nil {
+            loggerFrom(r.Context()).Printf("template import error: %v", err)
+            httpError(w, r, fmt.Sprintf("invalid bundle: %v", err), http.StatusBadRequest)
+            return
+        }
+        writeJSON(w, http.StatusCreated, map[string]string{"status": "success", "name": t.Name})
+
+    case len(parts) == 2 && parts[1] == "export" && r.Method == http.MethodGet:
+        t, err := resolveTemplate(tenantFor(r).ID, parts[0])
+        if err != nil {
+            httpError(w, r, err.Error(), http.StatusNotFound)
+            return
+        }
+        data, err := exportBundle(t)
+        if err != nil {
+            loggerFrom(r.Context()).Printf("template export error: %v", err)
+            httpError(w, r, fmt.Sprintf("error exporting template: %v", err), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/zip")
+        w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"template_%s.zip\"", t.Name))
+        w.WriteHeader(http.StatusOK)
+        _, _ = w.Write(data)
+
+    default:
+        httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+// listTemplates names the templates tenant can use, the default first
+func listTemplates(tenant string) []string {
+    seen := map[string]bool{defaultTemplateName: true}
+    names := []string{defaultTemplateName}
+    for _, dir := range []string{tenantTemplatesDir(tenant), templatesDir()} {
+        entries, err := os.ReadDir(dir)
+        if err != nil {
+            continue
+        }
+        for _, e := range entries {
+            if e.IsDir() && templateNamePattern.MatchString(e.Name()) && !seen[e.Name()] {
+                seen[e.Name()] = true
+                names = append(names, e.Name())
+            }
+        }
+    }
+    sort.Strings(names[1:])
+    return names
+}
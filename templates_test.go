@@ -0,0 +1,201 @@
+package main
+
+import (
+    "archive/zip"
+    "bytes"
+    "context"
+    "os"
+    "path/filepath"
+    "reflect"
+    "strings"
+    "testing"
+)
+
+// testLogger logs like a request without an ID
+var testLogger = loggerFrom(context.Background())
+
+// bundleZip builds a bundle archive of the given files
+func bundleZip(t *testing.T, files map[string][]byte) []byte {
+    t.Helper()
+    var buf bytes.Buffer
+    zw := zip.NewWriter(&buf)
+    for name, data := range files {
+        w, err := zw.Create(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if _, err := w.Write(data); err != nil {
+            t.Fatal(err)
+        }
+    }
+    if err := zw.Close(); err != nil {
+        t.Fatal(err)
+    }
+    return buf.Bytes()
+}
+
+// testBundleFiles are the files of a valid bundle, built on template.xlsx
+func testBundleFiles(t *testing.T) map[string][]byte {
+    t.Helper()
+    xlsx, err := os.ReadFile("template.xlsx")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return map[string][]byte{
+        "manifest.json": []byte(`{"name": "crew", "format_version": 1}`),
+        "template.xlsx": xlsx,
+        "cellmap.json":  []byte(`{"employee_name": "B2", "date_column": "C", "code_columns": ["D"], "job_columns": ["E"]}`),
+        "style.json":    []byte(`{"borders": [{"from": "A1", "to": "B2", "style": 1, "color": "FF0000"}]}`),
+    }
+}
+
+func TestTemplateBundleRoundTrip(t *testing.T) {
+    t.Setenv("TEMPLATES_DIR", t.TempDir())
+    imported, err := importBundle(bundleZip(t, testBundleFiles(t)), "", "", testLogger)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if imported.Name != "crew" {
+        t.Errorf("name = %q, want the manifest's", imported.Name)
+    }
+    // the cell map is laid over the default one, less its captions
+    if imported.CellMap.EmployeeName != "B2" || imported.CellMap.PayPeriod != defaultCellMap().PayPeriod {
+        t.Errorf("cell map = %+v", imported.CellMap)
+    }
+    if len(imported.CellMap.Labels) != 0 {
+        t.Errorf("imported the default template's captions %+v", imported.CellMap.Labels)
+    }
+    if resolved, err := resolveTemplate("", "crew"); err != nil || len(resolved.CellMap.Labels) != 0 {
+        t.Errorf("resolved captions %+v, %v", resolved, err)
+    }
+    if len(imported.Style.Borders) != 1 || imported.Style.Borders[0].Color != "FF0000" {
+        t.Errorf("style = %+v", imported.Style)
+    }
+    if got := listTemplates(""); !reflect.DeepEqual(got, []string{"default", "crew"}) {
+        t.Errorf("templates = %q", got)
+    }
+
+    // an exported bundle imports again as the same template
+    data, err := exportBundle(imported)
+    if err != nil {
+        t.Fatal(err)
+    }
+    again, err := importBundle(data, "crew-copy", "", testLogger)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !reflect.DeepEqual(again.CellMap, imported.CellMap) || !reflect.DeepEqual(again.Style, imported.Style) {
+        t.Errorf("re-imported %+v, want %+v", again, imported)
+    }
+
+    // captions the bundle lists are kept
+    files := testBundleFiles(t)
+    files["cellmap.json"] = []byte(`{"employee_name": "B2", "labels": [{"cell": "A1", "en": "Employee", "fr": "Employé(e)"}]}`)
+    labelled, err := importBundle(bundleZip(t, files), "labelled", "", testLogger)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if want := []dualLabel{{"A1", "Employee", "Employé(e)"}}; !reflect.DeepEqual(labelled.CellMap.Labels, want) {
+        t.Errorf("captions %+v, want %+v", labelled.CellMap.Labels, want)
+    }
+}
+
+func TestImportBundleRejects(t *testing.T) {
+    with := func(name string, data []byte) map[string][]byte {
+        files := testBundleFiles(t)
+        if data == nil {
+            delete(files, name)
+        } else {
+            files[name] = data
+        }
+        return files
+    }
+    tests := []struct {
+        name  string
+        files map[string][]byte
+        as    string
+        want  string
+    }{
+        {"newer format", with("manifest.json", []byte(`{"name": "crew", "format_version": 2}`)), "",
+            "bundle format 2 is newer than supported"},
+        {"no name", with("manifest.json", nil), "", `invalid template name ""`},
+        {"bad name", testBundleFiles(t), "../crew", `invalid template name "../crew"`},
+        {"the default's name", testBundleFiles(t), "default", `invalid template name "default"`},
+        {"no cell map", with("cellmap.json", nil), "", "must contain template.xlsx and cellmap.json"},
+        {"no workbook", with("template.xlsx", nil), "", "must contain template.xlsx and cellmap.json"},
+        {"unbalanced columns", with("cellmap.json", []byte(`{"code_columns": ["D"], "job_columns": []}`)), "",
+            "code_columns and job_columns"},
+        {"bad style", with("style.json", []byte(`{"borders": 1}`)), "", "parse style.json"},
+        {"not a workbook", with("template.xlsx", []byte("plain text")), "", "template.xlsx"},
+    }
+    for _, tt := range tests {
+        t.Run(tt.name, func(t *testing.T) {
+            dir := t.TempDir()
+            t.Setenv("TEMPLATES_DIR", dir)
+            _, err := importBundle(bundleZip(t, tt.files), tt.as, "", testLogger)
+            if err == nil || !strings.Contains(err.Error(), tt.want) {
+                t.Fatalf("err = %v, want %q", err, tt.want)
+            }
+            if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+                t.Errorf("%s was installed", filepath.Join(dir, entries[0].Name()))
+            }
+        })
+    }
+
+    if _, err := importBundle([]byte("not a zip"), "crew", "", testLogger); err == nil || !strings.Contains(err.Error(), "not a zip archive") {
+        t.Errorf("not a zip: %v", err)
+    }
+}
+
+func TestTenantTemplates(t *testing.T) {
+    t.Setenv("TEMPLATES_DIR", t.TempDir())
+    bundle := bundleZip(t, testBundleFiles(t))
+    for _, imp := range []struct{ name, tenant string }{{"shared", ""}, {"crew", "acme"}, {"night", "globex"}} {
+        if _, err := importBundle(bundle, imp.name, imp.tenant, testLogger); err != nil {
+            t.Fatal(err)
+        }
+    }
+    tests := []struct {
+        tenant string
+        want   []string
+    }{
+        {"", []string{"default", "shared"}},
+        {"acme", []string{"default", "crew", "shared"}},
+        {"globex", []string{"default", "night", "shared"}},
+    }
+    for _, tt := range tests {
+        if got := listTemplates(tt.tenant); !reflect.DeepEqual(got, tt.want) {
+            t.Errorf("%q's templates = %q, want %q", tt.tenant, got, tt.want)
+        }
+    }
+    if _, err := resolveTemplate("globex", "crew"); err == nil {
+        t.Error("globex can use acme's template")
+    }
+    if _, err := resolveTemplate("acme", "shared"); err != nil {
+        t.Errorf("acme can't use the shared template: %v", err)
+    }
+}
+
+func TestInstallTemplateRestore(t *testing.T) {
+    t.Setenv("TEMPLATES_DIR", t.TempDir())
+    keep, _, err := installTemplate("", "crew", map[string][]byte{"cellmap.json": []byte("v1")})
+    if err != nil {
+        t.Fatal(err)
+    }
+    keep()
+    _, restore, err := installTemplate("", "crew", map[string][]byte{"cellmap.json": []byte("v2")})
+    if err != nil {
+        t.Fatal(err)
+    }
+    path := filepath.Join(templatesDir(), "crew", "cellmap.json")
+    if b, _ := os.ReadFile(path); string(b) != "v2" {
+        t.Fatalf("installed %q", b)
+    }
+    restore()
+    if b, _ := os.ReadFile(path); string(b) != "v1" {
+        t.Errorf("restored %q", b)
+    }
+    if entries, _ := os.ReadDir(templatesDir()); len(entries) != 1 {
+        t.Errorf("left %d entries behind", len(entries))
+    }
+}
@@ -0,0 +1,314 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "text/template"
+)
+
+/* =======================
+   Tenants (company config)
+   ======================= */
+
+const defaultTenantID = "default"
+
+// Tenant is one company's server-side configuration, loaded from
+// TENANTS_FILE (default "tenants.json") keyed by tenant id.
+type Tenant struct {
+    ID       string           `json:"id"`
+    Name     string           `json:"name"`
+    Defaults TimecardDefaults `json:"defaults"`
+    Theme    *Theme           `json:"theme,omitempty"`
+    // Timezone is the IANA zone payroll works in (e.g. "America/Toronto");
+    // it decides which calendar day a timestamp falls on. Default UTC.
+    Timezone string           `json:"timezone,omitempty"`
+    // PayCalendar numbers pay periods (and fiscal years) server-side;
+    // without it the client's pay_period_num and year are used as sent.
+    // One set through /api/pay-calendar takes precedence (payCalendar).
+    PayCalendar *PayCalendar `json:"pay_calendar,omitempty"`
+    // WebDAV enables the read-only artifact share at /dav/
+    WebDAV *WebDAVCredentials `json:"webdav,omitempty"`
+    // DriveFolderID overrides DRIVE_FOLDER_ID for this tenant's uploads
+    DriveFolderID string `json:"drive_folder_id,omitempty"`
+    // SharePoint overrides SHAREPOINT_DRIVE_ID/SHAREPOINT_FOLDER for this tenant
+    SharePoint *SharePointLocation `json:"sharepoint,omitempty"`
+    // Dropbox uploads this tenant's files to its own Dropbox account
+    Dropbox *DropboxTarget `json:"dropbox,omitempty"`
+    // QuickBooks maps job numbers to customers for the QuickBooks export
+    QuickBooks *QuickBooksSettings `json:"quickbooks,omitempty"`
+    // ADP holds the company codes and file numbers for the ADP export
+    ADP *ADPSettings `json:"adp,omitempty"`
+    // Sage maps employees, jobs and pay types for the Sage 300 CRE export
+    Sage *SageSettings `json:"sage,omitempty"`
+    // PayrollSchedule emails closed pay periods to payroll automatically
+    PayrollSchedule *PayrollSchedule `json:"payroll_schedule,omitempty"`
+    // Reminders emails employees whose card is late, and their managers
+    Reminders *ReminderSchedule `json:"reminders,omitempty"`
+    // APIKeys ("name:key") lock the tenant to callers presenting one (auth.go)
+    APIKeys []string `json:"api_keys,omitempty"`
+    // SMTP sends this tenant's mail through its own server instead of SMTP_*
+    SMTP *SMTPSettings `json:"smtp,omitempty"`
+    // Branding is the tenant's own card, logo and email footer; one set
+    // through /api/branding takes precedence (branding.go)
+    Branding *Branding `json:"branding,omitempty"`
+    // OvertimeThresholds are what the overtime report flags (otreport.go)
+    OvertimeThresholds *OvertimeThresholds `json:"overtime_thresholds,omitempty"`
+    // JobCost prices labour codes for the job cost report (jobcost.go)
+    JobCost *JobCostSettings `json:"job_cost,omitempty"`
+    // Holidays lists stat holidays and where stat pay goes (holidays.go)
+    Holidays *HolidayCalendar `json:"holidays,omitempty"`
+    // LeaveCodes books vacation, sick and bereavement entries (leave.go)
+    LeaveCodes map[string]LeaveCode `json:"leave_codes,omitempty"`
+    // LeaveAccrual is the vacation and sick hours earned per pay period (balances.go)
+    LeaveAccrual *LeaveHours `json:"leave_accrual,omitempty"`
+    // BreakRules deduct unpaid breaks from long days (breaks.go)
+    BreakRules []BreakRule `json:"break_rules,omitempty"`
+    // NightDifferential works out night shift premium hours (nightdiff.go)
+    NightDifferential *NightDifferential `json:"night_differential,omitempty"`
+    // OvertimeRules tier worked hours into overtime and double time (otrules.go)
+    OvertimeRules *OvertimeRules `json:"overtime_rules,omitempty"`
+    // PerDiem is paid for per diem expense lines without an amount (expenses.go)
+    PerDiem float64 `json:"per_diem,omitempty"`
+    // WeekStart is the day the payroll week starts on, default Sunday (weekstart.go)
+    WeekStart string `json:"week_start,omitempty"`
+    // PDFPassword names the card field every PDF is locked with (pdfpassword.go)
+    PDFPassword string `json:"pdf_password,omitempty"`
+    // PDFA exports every PDF as PDF/A at this level (pdfa.go)
+    PDFA string `json:"pdf_a,omitempty"`
+    // PageSetup is how every card's sheets print (pagesetup.go)
+    PageSetup *PageSetup `json:"page_setup,omitempty"`
+}
+
+// TimecardDefaults are merged into incoming requests before validation so
+// the app only has to send what actually varies per card.
+type TimecardDefaults struct {
+    CC string `json:"cc,omitempty"`
+    // SubjectTemplate is a text/template rendered against the request,
+    // e.g. "Timecard {{.EmployeeName}} PP{{.PayPeriodNum}} {{.Year}}"
+    SubjectTemplate string   `json:"subject_template,omitempty"`
+    WeekLabels      []string `json:"week_labels,omitempty"` // by week index, e.g. ["Week #1", "Week #2"]
+    Jobs            []Job    `json:"jobs,omitempty"`
+}
+
+var (
+    tenantsOnce sync.Once
+    tenants     map[string]*Tenant
+)
+
+func loadTenants() map[string]*Tenant {
+    tenantsOnce.Do(func() {
+        tenants = make(map[string]*Tenant)
+        path := envOr("TENANTS_FILE", "tenants.json")
+        data, err := os.ReadFile(path)
+        if err != nil {
+            if !os.IsNotExist(err) {
+                log.Printf("Warning: could not read %s: %v", path, err)
+            }
+            return
+        }
+        if err := json.Unmarshal(data, &tenants); err != nil {
+            log.Printf("Warning: could not parse %s: %v", path, err)
+            return
+        }
+        for id, t := range tenants {
+            t.ID = id
+            if t.PayCalendar != nil {
+                if err := t.PayCalendar.validate(); err != nil {
+                    log.Printf("Warning: tenant %s: %v; ignoring its pay calendar", id, err)
+                    t.PayCalendar = nil
+                }
+            }
+            if t.Holidays != nil {
+                if err := t.Holidays.validate(); err != nil {
+                    log.Printf("Warning: tenant %s: %v; ignoring its holidays", id, err)
+                    t.Holidays = nil
+                }
+            }
+            if err := validateLeaveCodes(t.LeaveCodes); err != nil {
+                log.Printf("Warning: tenant %s: %v; ignoring its leave codes", id, err)
+                t.LeaveCodes = nil
+            }
+            if err := validateBreakRules(t.BreakRules); err != nil {
+                log.Printf("Warning: tenant %s: %v; ignoring its break rules", id, err)
+                t.BreakRules = nil
+            }
+            if t.NightDifferential != nil {
+                if err := t.NightDifferential.validate(); err != nil {
+                    log.Printf("Warning: tenant %s: %v; ignoring its night differential", id, err)
+                    t.NightDifferential = nil
+                }
+            }
+            if t.OvertimeRules != nil {
+                if err := t.OvertimeRules.validate(); err != nil {
+                    log.Printf("Warning: tenant %s: %v; ignoring its overtime rules", id, err)
+                    t.OvertimeRules = nil
+                }
+            }
+            if t.WeekStart != "" {
+                if err := validateWeekStart(t); err != nil {
+                    log.Printf("Warning: tenant %s: %v; weeks start on Sunday", id, err)
+                    t.WeekStart = ""
+                }
+            }
+            if t.PDFPassword != "" {
+                if err := validatePDFPassword(t.PDFPassword); err != nil {
+                    log.Printf("Warning: tenant %s: %v; ignoring its PDF password", id, err)
+                    t.PDFPassword = ""
+                }
+            }
+            if t.PDFA != "" {
+                if err := validatePDFA(t.PDFA); err != nil {
+                    log.Printf("Warning: tenant %s: %v; PDFs are not PDF/A", id, err)
+                    t.PDFA = ""
+                } else if t.PDFPassword != "" {
+                    log.Printf("Warning: tenant %s: pdf_a and pdf_password can't both be set; PDFs are not PDF/A", id)
+                    t.PDFA = ""
+                }
+            }
+            if t.PageSetup != nil {
+                if err := t.PageSetup.validate(); err != nil {
+                    log.Printf("Warning: tenant %s: %v; ignoring its page setup", id, err)
+                    t.PageSetup = nil
+                }
+            }
+            if t.PayrollSchedule != nil {
+                if err := t.PayrollSchedule.validate(t); err != nil {
+                    log.Printf("Warning: tenant %s: %v; ignoring its payroll schedule", id, err)
+                    t.PayrollSchedule = nil
+                }
+            }
+            if t.Reminders != nil {
+                if err := t.Reminders.validate(t); err != nil {
+                    log.Printf("Warning: tenant %s: %v; ignoring its reminders", id, err)
+                    t.Reminders = nil
+                }
+            }
+        }
+        log.Printf("Loaded %d tenant(s) from %s", len(tenants), path)
+    })
+    return tenants
+}
+
+// anyTenant reports whether some configured tenant satisfies f
+func anyTenant(f func(*Tenant) bool) bool {
+    for _, t := range loadTenants() {
+        if f(t) {
+            return true
+        }
+    }
+    return false
+}
+
+type tenantKey struct{}
+
+// withTenant marks ctx as working for t, for code that has no request
+// (background jobs) and for what sendEmail picks per tenant
+func withTenant(ctx context.Context, t *Tenant) context.Context {
+    return context.WithValue(ctx, tenantKey{}, t)
+}
+
+// tenantFrom returns the tenant ctx works for, or nil
+func tenantFrom(ctx context.Context) *Tenant {
+    t, _ := ctx.Value(tenantKey{}).(*Tenant)
+    return t
+}
+
+// tenantFor resolves the tenant of a request: the one tenantMiddleware
+// authorized, else the X-Tenant-ID header, falling back to the "default"
+// tenant (or an empty config).
+func tenantFor(r *http.Request) *Tenant {
+    if t := tenantFrom(r.Context()); t != nil {
+        return t
+    }
+    if id := strings.TrimSpace(r.Header.Get("X-Tenant-ID")); id != "" {
+        if t, ok := loadTenants()[id]; ok {
+            return t
+        }
+        loggerFrom(r.Context()).Printf("Unknown tenant %q, using default", id)
+    }
+    return lookupTenant(defaultTenantID)
+}
+
+// lookupTenant returns the tenant with id, falling back like tenantFor
+func lookupTenant(id string) *Tenant {
+    all := loadTenants()
+    if t, ok := all[id]; ok {
+        return t
+    }
+    if t, ok := all[defaultTenantID]; ok {
+        return t
+    }
+    return &Tenant{ID: defaultTenantID}
+}
+
+// applyTimecardDefaults fills in what the client left out; values sent by
+// the client always win.
+func applyTimecardDefaults(req *TimecardRequest, t *Tenant) {
+    d := t.Defaults
+    applyEmployee(req, t)
+    applyPayCalendar(req, t)
+    applyPunches(req, t)
+    applyExpenses(req, t)
+    applyEntryTypes(req, t)
+    deductBreaks(req, t)
+    applyCostCodes(req, t)
+    applyOvertimeRules(req, t)
+
+    known := make(map[string]bool, len(req.Jobs))
+    for _, j := range req.Jobs {
+        known[j.JobCode] = true
+    }
+    for _, j := range d.Jobs {
+        if !known[j.JobCode] {
+            req.Jobs = append(req.Jobs, j)
+            known[j.JobCode] = true
+        }
+    }
+
+    for i := range req.Weeks {
+        if req.Weeks[i].WeekLabel == "" && i < len(d.WeekLabels) {
+            req.Weeks[i].WeekLabel = d.WeekLabels[i]
+        }
+    }
+    if req.WeekNumberLabel == "" && len(d.WeekLabels) > 0 {
+        req.WeekNumberLabel = d.WeekLabels[0]
+    }
+    if req.Template == "" {
+        req.Template = t.branding().Template
+    }
+    assignRevision(req, t)
+}
+
+func applyEmailDefaults(req *EmailTimecardRequest, t *Tenant) {
+    d := t.Defaults
+    applyTimecardDefaults(&req.TimecardRequest, t)
+
+    if (req.CC == nil || *req.CC == "") && d.CC != "" {
+        cc := d.CC
+        req.CC = &cc
+    }
+    if strings.TrimSpace(req.Body) == "" {
+        req.Body = hoursSummaryText(req.TimecardRequest, cardLocation(req.TimecardRequest, t))
+    } else if req.IncludeSummary {
+        req.Body = strings.TrimRight(req.Body, "\r\n") + "\r\n\r\n" + hoursSummaryText(req.TimecardRequest, cardLocation(req.TimecardRequest, t))
+    }
+    if req.Subject == "" && d.SubjectTemplate != "" {
+        tmpl, err := template.New("subject").Parse(d.SubjectTemplate)
+        if err != nil {
+            log.Printf("Warning: bad subject template: %v", err)
+            return
+        }
+        var buf bytes.Buffer
+        if err := tmpl.Execute(&buf, req.TimecardRequest); err != nil {
+            log.Printf("Warning: subject template: %v", err)
+            return
+        }
+        req.Subject = buf.String()
+    }
+}
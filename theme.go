@@ -0,0 +1,150 @@
+package main
+
+import (
+    "fmt"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==============
+   Tenant themes
+   ============== */
+
+// Theme restyles every cell the server writes so documents follow a
+// company's branding. Zero values leave the template's own styling alone.
+type Theme struct {
+    FontFamily      string  `json:"font_family,omitempty"`
+    FontSize        float64 `json:"font_size,omitempty"`
+    FontColor       string  `json:"font_color,omitempty"`        // hex RGB, e.g. "1F2937"
+    HeaderFill      string  `json:"header_fill,omitempty"`       // hex RGB fill for header cells
+    HeaderFontColor string  `json:"header_font_color,omitempty"` // hex RGB
+    HeaderBold      bool    `json:"header_bold,omitempty"`
+    BorderStyle     int     `json:"border_style,omitempty"` // excelize border style: 1 thin, 2 medium, 5 thick
+    BorderColor     string  `json:"border_color,omitempty"`
+}
+
+// theme returns the tenant's theme, or nil when none is configured
+func (t *Tenant) theme() *Theme {
+    if t == nil {
+        return nil
+    }
+    return t.Theme
+}
+
+// borders applies the theme's border weight/color on top of a template rule
+func (th *Theme) borders(rule borderRule) borderRule {
+    if th == nil {
+        return rule
+    }
+    if th.BorderStyle != 0 {
+        rule.Style = th.BorderStyle
+    }
+    if th.BorderColor != "" {
+        rule.Color = th.BorderColor
+    }
+    return rule
+}
+
+// themer merges theme fonts/fills into existing cell styles, so number
+// formats, alignment and borders from the template survive. Derived styles
+// are cached per source style to keep the workbook's style table small.
+type themer struct {
+    f     *excelize.File
+    theme *Theme
+    cache map[[2]int]int
+}
+
+func newThemer(f *excelize.File, theme *Theme) *themer {
+    return &themer{f: f, theme: theme, cache: make(map[[2]int]int)}
+}
+
+func (t *themer) apply(sheet, cell string, header bool) error {
+    if t.theme == nil {
+        return nil
+    }
+    base, err := t.f.GetCellStyle(sheet, cell)
+    if err != nil {
+        return err
+    }
+    key := [2]int{base, 0}
+    if header {
+        key[1] = 1
+    }
+    id, ok := t.cache[key]
+    if !ok {
+        st, err := t.f.GetStyle(base)
+        if err != nil {
+            return err
+        }
+        t.merge(st, header)
+        if id, err = t.f.NewStyle(st); err != nil {
+            return err
+        }
+        t.cache[key] = id
+    }
+    return t.f.SetCellStyle(sheet, cell, cell, id)
+}
+
+func (t *themer) merge(st *excelize.Style, header bool) {
+    th := t.theme
+    if st.Font == nil {
+        st.Font = &excelize.Font{}
+    }
+    if th.FontFamily != "" {
+        st.Font.Family = th.FontFamily
+    }
+    if th.FontSize > 0 {
+        st.Font.Size = th.FontSize
+    }
+    if th.FontColor != "" {
+        st.Font.Color = th.FontColor
+    }
+    if !header {
+        return
+    }
+    if th.HeaderBold {
+        st.Font.Bold = true
+    }
+    if th.HeaderFontColor != "" {
+        st.Font.Color = th.HeaderFontColor
+    }
+    if th.HeaderFill != "" {
+        st.Fill = excelize.Fill{Type: "pattern", Pattern: 1, Color: []string{th.HeaderFill}}
+    }
+}
+
+// applyThemeToWeekSheet styles the cells fillWeekSheet writes: the mapped
+// header cells and job/code header rows as headers, dates and hours as body.
+func applyThemeToWeekSheet(f *excelize.File, sheet string, cm CellMap, theme *Theme, lg *requestLogger) {
+    if theme == nil {
+        return
+    }
+    t := newThemer(f, theme)
+    try := func(cell string, header bool) {
+        if cell == "" {
+            return
+        }
+        if err := t.apply(sheet, cell, header); err != nil {
+            lg.Printf("Warning: theme %s!%s: %v", sheet, cell, err)
+        }
+    }
+
+    for _, cell := range []string{cm.EmployeeName, cm.EmployeeID, cm.PayrollNumber, cm.PayPeriod, cm.Year, cm.WeekStart, cm.WeekLabel} {
+        try(cell, true)
+    }
+    for _, row := range []int{cm.RegularHeaderRow, cm.OvertimeHeaderRow} {
+        for i := range cm.CodeColumns {
+            try(fmt.Sprintf("%s%d", cm.CodeColumns[i], row), true)
+            try(fmt.Sprintf("%s%d", cm.JobColumns[i], row), true)
+        }
+    }
+    for _, first := range []int{cm.RegularFirstRow, cm.OvertimeFirstRow} {
+        for d := 0; d < 7; d++ {
+            row := first + d
+            try(fmt.Sprintf("%s%d", cm.DateColumn, row), false)
+            for _, col := range cm.CodeColumns {
+                try(fmt.Sprintf("%s%d", col, row), false)
+            }
+        }
+    }
+}
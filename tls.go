@@ -0,0 +1,159 @@
+package main
+
+import (
+    "context"
+    "crypto/tls"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "golang.org/x/crypto/acme/autocert"
+)
+
+/* ==================
+   Serving (HTTP/TLS)
+   ================== */
+
+// serve starts the HTTP server in one of three modes, picked from the environment:
+//   - AUTOCERT_DOMAINS set: HTTPS on HTTPS_PORT (default 443) with Let's Encrypt
+//     certificates, plus HTTP on HTTP_PORT (default 80) for ACME challenges and redirects
+//   - TLS_CERT_FILE and TLS_KEY_FILE set: HTTPS on PORT with the given certificate
+//   - otherwise: plain HTTP on PORT (TLS terminated by the platform, e.g. Render)
+//
+// It blocks until ctx is cancelled, then drains in-flight requests.
+func serve(ctx context.Context, port string, handler http.Handler) error {
+    if domains := splitList(os.Getenv("AUTOCERT_DOMAINS")); len(domains) > 0 {
+        return serveAutocert(ctx, domains, handler)
+    }
+
+    certFile := os.Getenv("TLS_CERT_FILE")
+    keyFile := os.Getenv("TLS_KEY_FILE")
+    if certFile != "" || keyFile != "" {
+        if certFile == "" || keyFile == "" {
+            return fmt.Errorf("TLS_CERT_FILE and TLS_KEY_FILE must both be set")
+        }
+        srv := newServer(":"+port, handler)
+        srv.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+        log.Printf("Server starting on :%s (TLS, cert=%s) ...", port, certFile)
+        return runServers(ctx, srv, func() error { return srv.ListenAndServeTLS(certFile, keyFile) })
+    }
+
+    srv := newServer(":"+port, handler)
+    log.Printf("Server starting on :%s ...", port)
+    return runServers(ctx, srv, srv.ListenAndServe)
+}
+
+func serveAutocert(ctx context.Context, domains []string, handler http.Handler) error {
+    cacheDir := os.Getenv("AUTOCERT_CACHE_DIR")
+    if cacheDir == "" {
+        cacheDir = "certs"
+    }
+    httpsPort := envOr("HTTPS_PORT", "443")
+    httpPort := envOr("HTTP_PORT", "80")
+
+    m := &autocert.Manager{
+        Prompt:     autocert.AcceptTOS,
+        HostPolicy: autocert.HostWhitelist(domains...),
+        Cache:      autocert.DirCache(cacheDir),
+        Email:      os.Getenv("AUTOCERT_EMAIL"),
+    }
+
+    // Port 80 answers HTTP-01 challenges and redirects everything else to HTTPS
+    challenge := newServer(":"+httpPort, m.HTTPHandler(nil))
+    go func() {
+        log.Printf("ACME challenge listener on :%s ...", httpPort)
+        if err := challenge.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+            log.Printf("ACME challenge listener error: %v", err)
+        }
+    }()
+
+    srv := newServer(":"+httpsPort, handler)
+    srv.TLSConfig = m.TLSConfig()
+    srv.TLSConfig.MinVersion = tls.VersionTLS12
+    log.Printf("Server starting on :%s (autocert for %s) ...", httpsPort, strings.Join(domains, ", "))
+    return runServers(ctx, srv, func() error { return srv.ListenAndServeTLS("", "") }, challenge)
+}
+
+// runServers runs listen until it fails or ctx is cancelled; on cancel every
+// server is shut down gracefully within SHUTDOWN_TIMEOUT (default 30s).
+func runServers(ctx context.Context, main *http.Server, listen func() error, others ...*http.Server) error {
+    errc := make(chan error, 1)
+    go func() { errc <- listen() }()
+
+    select {
+    case err := <-errc:
+        return err
+    case <-ctx.Done():
+    }
+
+    timeout := envDuration("SHUTDOWN_TIMEOUT", 30*time.Second)
+    log.Printf("Shutting down (waiting up to %s for in-flight requests) ...", timeout)
+    shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+    defer cancel()
+    for _, s := range others {
+        _ = s.Shutdown(shutdownCtx)
+    }
+    if err := main.Shutdown(shutdownCtx); err != nil {
+        return fmt.Errorf("shutdown: %w", err)
+    }
+    if err := <-errc; err != nil && err != http.ErrServerClosed {
+        return err
+    }
+    log.Printf("Server stopped")
+    return nil
+}
+
+func newServer(addr string, handler http.Handler) *http.Server {
+    return &http.Server{
+        Addr:              addr,
+        Handler:           handler,
+        ReadHeaderTimeout: 10 * time.Second,
+    }
+}
+
+func envOr(key, fallback string) string {
+    if v := os.Getenv(key); v != "" {
+        return v
+    }
+    return fallback
+}
+
+// envDuration parses a Go duration ("30s", "2m") from the environment
+func envDuration(key string, fallback time.Duration) time.Duration {
+    v := os.Getenv(key)
+    if v == "" {
+        return fallback
+    }
+    d, err := time.ParseDuration(v)
+    if err != nil {
+        log.Printf("Warning: bad %s=%q, using %s", key, v, fallback)
+        return fallback
+    }
+    return d
+}
+
+// envInt reads a positive integer from the environment
+func envInt(key string, fallback int) int {
+    if v := os.Getenv(key); v != "" {
+        if n, err := strconv.Atoi(v); err == nil && n > 0 {
+            return n
+        }
+        log.Printf("Warning: bad %s=%q, using %d", key, v, fallback)
+    }
+    return fallback
+}
+
+// splitList splits a comma-separated value, trimming blanks
+func splitList(s string) []string {
+    var out []string
+    for _, p := range strings.Split(s, ",") {
+        if p = strings.TrimSpace(p); p != "" {
+            out = append(out, p)
+        }
+    }
+    return out
+}
@@ -0,0 +1,410 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ==================================
+   Tracing (OpenTelemetry, OTLP/HTTP)
+   ================================== */
+
+// A deliberately small span recorder that speaks the OTLP/HTTP JSON wire
+// format, so any OpenTelemetry collector (or Jaeger/Tempo/Honeycomb) can
+// ingest it without pulling the full SDK into this binary. Configured with
+// the standard variables:
+//   OTEL_EXPORTER_OTLP_TRACES_ENDPOINT  full URL, e.g. http://otel:4318/v1/traces
+//   OTEL_EXPORTER_OTLP_ENDPOINT         base URL; "/v1/traces" is appended
+//   OTEL_EXPORTER_OTLP_HEADERS          "key=value,key2=value2"
+//   OTEL_SERVICE_NAME                   defaults to "timecard-api"
+// With no endpoint configured, spans are no-ops.
+
+const (
+    spanKindInternal = 1
+    spanKindServer   = 2
+    spanKindClient   = 3
+)
+
+type span struct {
+    TraceID  [16]byte
+    SpanID   [8]byte
+    ParentID [8]byte
+    Name     string
+    Kind     int
+    Start    time.Time
+    EndTime  time.Time
+    Attrs    map[string]interface{}
+    ErrMsg   string
+
+    mu    sync.Mutex
+    ended bool
+}
+
+type spanKey struct{}
+
+func spanFrom(ctx context.Context) *span {
+    s, _ := ctx.Value(spanKey{}).(*span)
+    return s
+}
+
+// startSpan starts a child of the span in ctx (or a new trace). The
+// returned span is nil when tracing is disabled; all methods accept nil.
+func startSpan(ctx context.Context, name string, kind int) (context.Context, *span) {
+    if tracer() == nil {
+        return ctx, nil
+    }
+    s := &span{Name: name, Kind: kind, Start: time.Now(), Attrs: map[string]interface{}{}}
+    if parent := spanFrom(ctx); parent != nil {
+        s.TraceID = parent.TraceID
+        s.ParentID = parent.SpanID
+    } else if remote, ok := ctx.Value(remoteParentKey{}).(traceParent); ok {
+        s.TraceID = remote.traceID
+        s.ParentID = remote.spanID
+    } else {
+        _, _ = rand.Read(s.TraceID[:])
+    }
+    _, _ = rand.Read(s.SpanID[:])
+    return context.WithValue(ctx, spanKey{}, s), s
+}
+
+func (s *span) SetAttr(key string, value interface{}) {
+    if s == nil {
+        return
+    }
+    s.mu.Lock()
+    s.Attrs[key] = value
+    s.mu.Unlock()
+}
+
+func (s *span) RecordError(err error) {
+    if s == nil || err == nil {
+        return
+    }
+    s.mu.Lock()
+    s.ErrMsg = err.Error()
+    s.mu.Unlock()
+}
+
+func (s *span) End() {
+    if s == nil {
+        return
+    }
+    s.mu.Lock()
+    if s.ended {
+        s.mu.Unlock()
+        return
+    }
+    s.ended = true
+    s.EndTime = time.Now()
+    s.mu.Unlock()
+    tracer().enqueue(s)
+}
+
+// traceIDFrom returns the trace ID of the span in ctx, or ""
+func traceIDFrom(ctx context.Context) string {
+    return spanFrom(ctx).traceIDHex()
+}
+
+func (s *span) traceIDHex() string {
+    if s == nil {
+        return ""
+    }
+    return hex.EncodeToString(s.TraceID[:])
+}
+
+/* ----- W3C trace context ----- */
+
+type traceParent struct {
+    traceID [16]byte
+    spanID  [8]byte
+}
+
+type remoteParentKey struct{}
+
+// parseTraceParent reads "00-<32 hex>-<16 hex>-<2 hex>"
+func parseTraceParent(h string) (traceParent, bool) {
+    var tp traceParent
+    parts := strings.Split(strings.TrimSpace(h), "-")
+    if len(parts) != 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+        return tp, false
+    }
+    if _, err := hex.Decode(tp.traceID[:], []byte(parts[1])); err != nil {
+        return tp, false
+    }
+    if _, err := hex.Decode(tp.spanID[:], []byte(parts[2])); err != nil {
+        return tp, false
+    }
+    return tp, tp.traceID != [16]byte{}
+}
+
+// statusRecorder captures the status code and body size a handler wrote
+type statusRecorder struct {
+    http.ResponseWriter
+    status int
+    bytes  int
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+    if r.status == 0 {
+        r.status = code
+    }
+    r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+    if r.status == 0 {
+        r.status = http.StatusOK
+    }
+    n, err := r.ResponseWriter.Write(b)
+    r.bytes += n
+    return n, err
+}
+
+func (r *statusRecorder) Flush() {
+    if f, ok := r.ResponseWriter.(http.Flusher); ok {
+        f.Flush()
+    }
+}
+
+// tracingMiddleware opens the server span for a route, continuing the
+// caller's trace when a traceparent header is present.
+func tracingMiddleware(route string, next http.HandlerFunc) http.HandlerFunc {
+    return func(w http.ResponseWriter, r *http.Request) {
+        if tracer() == nil {
+            next(w, r)
+            return
+        }
+        ctx := r.Context()
+        if tp, ok := parseTraceParent(r.Header.Get("traceparent")); ok {
+            ctx = context.WithValue(ctx, remoteParentKey{}, tp)
+        }
+        ctx, s := startSpan(ctx, r.Method+" "+route, spanKindServer)
+        s.SetAttr("http.method", r.Method)
+        s.SetAttr("http.route", route)
+        s.SetAttr("http.request_content_length", r.ContentLength)
+        if id := requestIDFrom(ctx); id != "" {
+            s.SetAttr("request.id", id)
+        }
+        w.Header().Set("traceparent", fmt.Sprintf("00-%s-%s-01", s.traceIDHex(), hex.EncodeToString(s.SpanID[:])))
+
+        rec := &statusRecorder{ResponseWriter: w}
+        defer func() {
+            if rec.status == 0 {
+                rec.status = http.StatusOK
+            }
+            s.SetAttr("http.status_code", rec.status)
+            s.SetAttr("http.response_content_length", rec.bytes)
+            if rec.status >= 500 {
+                s.RecordError(fmt.Errorf("HTTP %d", rec.status))
+            }
+            s.End()
+        }()
+        next(rec, r.WithContext(ctx))
+    }
+}
+
+/* ----- exporter ----- */
+
+type spanExporter struct {
+    endpoint string
+    headers  map[string]string
+    service  string
+    client   *http.Client
+    queue    chan *span
+    flushReq chan chan struct{}
+}
+
+var (
+    tracerOnce sync.Once
+    theTracer  *spanExporter
+)
+
+// tracer returns the process exporter, or nil when tracing is not configured
+func tracer() *spanExporter {
+    tracerOnce.Do(func() {
+        endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+        if endpoint == "" {
+            if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+                endpoint = strings.TrimRight(base, "/") + "/v1/traces"
+            }
+        }
+        if endpoint == "" {
+            return
+        }
+        headers := map[string]string{}
+        for _, kv := range splitList(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS")) {
+            if k, v, ok := strings.Cut(kv, "="); ok {
+                headers[strings.TrimSpace(k)] = strings.TrimSpace(v)
+            }
+        }
+        theTracer = &spanExporter{
+            endpoint: endpoint,
+            headers:  headers,
+            service:  envOr("OTEL_SERVICE_NAME", "timecard-api"),
+            client:   &http.Client{Timeout: 10 * time.Second},
+            queue:    make(chan *span, 2048),
+            flushReq: make(chan chan struct{}),
+        }
+        go theTracer.loop()
+        log.Printf("Tracing enabled, exporting to %s", endpoint)
+    })
+    return theTracer
+}
+
+func (e *spanExporter) enqueue(s *span) {
+    select {
+    case e.queue <- s:
+    default:
+        // never block a request on telemetry
+    }
+}
+
+func (e *spanExporter) loop() {
+    const maxBatch = 256
+    ticker := time.NewTicker(5 * time.Second)
+    defer ticker.Stop()
+    var batch []*span
+    send := func() {
+        if len(batch) > 0 {
+            e.export(batch)
+            batch = nil
+        }
+    }
+    for {
+        select {
+        case s := <-e.queue:
+            batch = append(batch, s)
+            if len(batch) >= maxBatch {
+                send()
+            }
+        case <-ticker.C:
+            send()
+        case done := <-e.flushReq:
+            for drained := false; !drained; {
+                select {
+                case s := <-e.queue:
+                    batch = append(batch, s)
+                default:
+                    drained = true
+                }
+            }
+            send()
+            close(done)
+        }
+    }
+}
+
+// flushTraces exports everything queued; called during shutdown
+func flushTraces(timeout time.Duration) {
+    e := tracer()
+    if e == nil {
+        return
+    }
+    done := make(chan struct{})
+    select {
+    case e.flushReq <- done:
+        select {
+        case <-done:
+        case <-time.After(timeout):
+        }
+    case <-time.After(timeout):
+    }
+}
+
+type otlpKeyValue struct {
+    Key   string                 `json:"key"`
+    Value map[string]interface{} `json:"value"`
+}
+
+func otlpAttrs(m map[string]interface{}) []otlpKeyValue {
+    out := make([]otlpKeyValue, 0, len(m))
+    for k, v := range m {
+        var val map[string]interface{}
+        switch x := v.(type) {
+        case string:
+            val = map[string]interface{}{"stringValue": x}
+        case bool:
+            val = map[string]interface{}{"boolValue": x}
+        case int:
+            val = map[string]interface{}{"intValue": strconv.Itoa(x)}
+        case int64:
+            val = map[string]interface{}{"intValue": strconv.FormatInt(x, 10)}
+        case float64:
+            val = map[string]interface{}{"doubleValue": x}
+        default:
+            val = map[string]interface{}{"stringValue": fmt.Sprint(x)}
+        }
+        out = append(out, otlpKeyValue{Key: k, Value: val})
+    }
+    return out
+}
+
+func (e *spanExporter) export(batch []*span) {
+    spans := make([]map[string]interface{}, 0, len(batch))
+    for _, s := range batch {
+        s.mu.Lock()
+        js := map[string]interface{}{
+            "traceId":           hex.EncodeToString(s.TraceID[:]),
+            "spanId":            hex.EncodeToString(s.SpanID[:]),
+            "name":              s.Name,
+            "kind":              s.Kind,
+            "startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+            "endTimeUnixNano":   strconv.FormatInt(s.EndTime.UnixNano(), 10),
+            "attributes":        otlpAttrs(s.Attrs),
+        }
+        if s.ParentID != [8]byte{} {
+            js["parentSpanId"] = hex.EncodeToString(s.ParentID[:])
+        }
+        if s.ErrMsg != "" {
+            js["status"] = map[string]interface{}{"code": 2, "message": s.ErrMsg}
+        }
+        s.mu.Unlock()
+        spans = append(spans, js)
+    }
+
+    payload := map[string]interface{}{
+        "resourceSpans": []interface{}{map[string]interface{}{
+            "resource": map[string]interface{}{
+                "attributes": otlpAttrs(map[string]interface{}{"service.name": e.service}),
+            },
+            "scopeSpans": []interface{}{map[string]interface{}{
+                "scope": map[string]string{"name": "timecard-api"},
+                "spans": spans,
+            }},
+        }},
+    }
+    body, err := json.Marshal(payload)
+    if err != nil {
+        log.Printf("trace export: %v", err)
+        return
+    }
+    req, err := http.NewRequest(http.MethodPost, e.endpoint, bytes.NewReader(body))
+    if err != nil {
+        log.Printf("trace export: %v", err)
+        return
+    }
+    req.Header.Set("Content-Type", "application/json")
+    for k, v := range e.headers {
+        req.Header.Set(k, v)
+    }
+    resp, err := e.client.Do(req)
+    if err != nil {
+        log.Printf("trace export: %v", err)
+        return
+    }
+    resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        log.Printf("trace export: collector returned %s", resp.Status)
+    }
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+    "encoding/json"
+    "errors"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "sync"
+    "testing"
+    "time"
+)
+
+// OTLP/HTTP JSON as a collector reads it (the proto3 JSON mapping: hex
+// IDs, 64-bit integers as strings)
+type otlpExport struct {
+    ResourceSpans []struct {
+        Resource struct {
+            Attributes []otlpTestAttr `json:"attributes"`
+        } `json:"resource"`
+        ScopeSpans []struct {
+            Scope struct {
+                Name string `json:"name"`
+            } `json:"scope"`
+            Spans []otlpTestSpan `json:"spans"`
+        } `json:"scopeSpans"`
+    } `json:"resourceSpans"`
+}
+
+type otlpTestSpan struct {
+    TraceID           string         `json:"traceId"`
+    SpanID            string         `json:"spanId"`
+    ParentSpanID      string         `json:"parentSpanId"`
+    Name              string         `json:"name"`
+    Kind              int            `json:"kind"`
+    StartTimeUnixNano string         `json:"startTimeUnixNano"`
+    EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+    Attributes        []otlpTestAttr `json:"attributes"`
+    Status            *struct {
+        Code    int    `json:"code"`
+        Message string `json:"message"`
+    } `json:"status"`
+}
+
+type otlpTestAttr struct {
+    Key   string `json:"key"`
+    Value struct {
+        StringValue *string  `json:"stringValue"`
+        IntValue    *string  `json:"intValue"`
+        BoolValue   *bool    `json:"boolValue"`
+        DoubleValue *float64 `json:"doubleValue"`
+    } `json:"value"`
+}
+
+// attr renders an attribute's value as its type and value, e.g. "int 500"
+func (s otlpTestSpan) attr(key string) string {
+    for _, a := range s.Attributes {
+        if a.Key != key {
+            continue
+        }
+        switch v := a.Value; {
+        case v.StringValue != nil:
+            return "string " + *v.StringValue
+        case v.IntValue != nil:
+            return "int " + *v.IntValue
+        case v.BoolValue != nil:
+            return "bool " + strconv.FormatBool(*v.BoolValue)
+        case v.DoubleValue != nil:
+            return "double " + strconv.FormatFloat(*v.DoubleValue, 'g', -1, 64)
+        }
+    }
+    return ""
+}
+
+// fakeCollector records the OTLP exports posted to it
+type fakeCollector struct {
+    mu      sync.Mutex
+    exports []otlpExport
+    headers []http.Header
+}
+
+func (c *fakeCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    var e otlpExport
+    if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+        http.Error(w, "not an OTLP/HTTP JSON export", http.StatusBadRequest)
+        return
+    }
+    if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+    c.mu.Lock()
+    c.exports = append(c.exports, e)
+    c.headers = append(c.headers, r.Header.Clone())
+    c.mu.Unlock()
+}
+
+// withTracer turns tracing on for the test, exporting to a fake collector
+// configured through the standard variables
+func withTracer(t *testing.T) *fakeCollector {
+    t.Helper()
+    c := &fakeCollector{}
+    ts := httptest.NewServer(c)
+    t.Cleanup(ts.Close)
+    t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+    t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", ts.URL+"/")
+    t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "x-api-key = secret, x-team=payroll")
+    t.Setenv("OTEL_SERVICE_NAME", "timecard-test")
+
+    saved := theTracer
+    tracerOnce = sync.Once{}
+    if tracer() == nil {
+        t.Fatal("tracing not enabled")
+    }
+    t.Cleanup(func() { theTracer = saved })
+    return c
+}
+
+func TestTracingExportsOTLP(t *testing.T) {
+    c := withTracer(t)
+    handler := tracingMiddleware("/api/generate", func(w http.ResponseWriter, r *http.Request) {
+        _, sp := startSpan(r.Context(), "excel.generate", spanKindInternal)
+        sp.SetAttr("weeks", 2)
+        sp.SetAttr("excel.bytes", int64(1<<20))
+        sp.SetAttr("draft", true)
+        sp.SetAttr("scale", 0.5)
+        sp.RecordError(errors.New("template not found"))
+        sp.End()
+        sp.End() // ending twice exports once
+        http.Error(w, "failed", http.StatusInternalServerError)
+    })
+
+    const caller = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+    r := httptest.NewRequest(http.MethodPost, "/api/generate", nil)
+    r.Header.Set("traceparent", caller)
+    w := httptest.NewRecorder()
+    handler(w, r)
+    flushTraces(5 * time.Second)
+
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if len(c.exports) != 1 {
+        t.Fatalf("%d exports, want 1", len(c.exports))
+    }
+    if h := c.headers[0]; h.Get("X-Api-Key") != "secret" || h.Get("X-Team") != "payroll" {
+        t.Errorf("OTEL_EXPORTER_OTLP_HEADERS not sent: %v", h)
+    }
+    rs := c.exports[0].ResourceSpans
+    if len(rs) != 1 || len(rs[0].ScopeSpans) != 1 {
+        t.Fatalf("export = %+v", c.exports[0])
+    }
+    if a := rs[0].Resource.Attributes; len(a) != 1 || a[0].Key != "service.name" || *a[0].Value.StringValue != "timecard-test" {
+        t.Errorf("resource = %+v", rs[0].Resource)
+    }
+    spans := rs[0].ScopeSpans[0].Spans
+    if len(spans) != 2 {
+        t.Fatalf("%d spans, want 2", len(spans))
+    }
+    child, server := spans[0], spans[1] // in the order they ended
+
+    // The server span continues the caller's trace; the child is its own
+    if server.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || server.ParentSpanID != "00f067aa0ba902b7" {
+        t.Errorf("server span %s/%s, parent %s", server.TraceID, server.SpanID, server.ParentSpanID)
+    }
+    if child.TraceID != server.TraceID || child.ParentSpanID != server.SpanID || len(child.SpanID) != 16 {
+        t.Errorf("child span %s/%s, parent %s", child.TraceID, child.SpanID, child.ParentSpanID)
+    }
+    if got, want := w.Header().Get("traceparent"), "00-"+server.TraceID+"-"+server.SpanID+"-01"; got != want {
+        t.Errorf("traceparent = %s, want %s", got, want)
+    }
+
+    if server.Name != "POST /api/generate" || server.Kind != spanKindServer || child.Kind != spanKindInternal {
+        t.Errorf("names and kinds: %s %d, %s %d", server.Name, server.Kind, child.Name, child.Kind)
+    }
+    start, err1 := strconv.ParseInt(server.StartTimeUnixNano, 10, 64)
+    end, err2 := strconv.ParseInt(server.EndTimeUnixNano, 10, 64)
+    if err1 != nil || err2 != nil || end < start || start == 0 {
+        t.Errorf("times %s..%s", server.StartTimeUnixNano, server.EndTimeUnixNano)
+    }
+
+    for _, tt := range []struct {
+        span      otlpTestSpan
+        key, want string
+    }{
+        {server, "http.method", "string POST"},
+        {server, "http.route", "string /api/generate"},
+        {server, "http.status_code", "int 500"},
+        {child, "weeks", "int 2"},
+        {child, "excel.bytes", "int 1048576"},
+        {child, "draft", "bool true"},
+        {child, "scale", "double 0.5"},
+    } {
+        if got := tt.span.attr(tt.key); got != tt.want {
+            t.Errorf("%s %s = %q, want %q", tt.span.Name, tt.key, got, tt.want)
+        }
+    }
+    if server.Status == nil || server.Status.Code != 2 || server.Status.Message != "HTTP 500" {
+        t.Errorf("server status = %+v", server.Status)
+    }
+    if child.Status == nil || child.Status.Message != "template not found" {
+        t.Errorf("child status = %+v", child.Status)
+    }
+}
+
+func TestParseTraceParent(t *testing.T) {
+    tests := []struct {
+        in string
+        ok bool
+    }{
+        {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", true},
+        {" 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00 ", true},
+        {"00-00000000000000000000000000000000-00f067aa0ba902b7-01", false},
+        {"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01", false},
+        {"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7", false},
+        {"00-4bf92f3577b34da6a3ce929d0e0e47zz-00f067aa0ba902b7-01", false},
+        {"", false},
+    }
+    for _, tt := range tests {
+        tp, ok := parseTraceParent(tt.in)
+        if ok != tt.ok {
+            t.Errorf("parseTraceParent(%q) ok = %v, want %v", tt.in, ok, tt.ok)
+        }
+        if ok && (tp.traceID[0] != 0x4b || tp.spanID[7] != 0xb7) {
+            t.Errorf("parseTraceParent(%q) = %x", tt.in, tp)
+        }
+    }
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+    "fmt"
+    "sort"
+    "strings"
+    "time"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ==========
+   Travel log
+   ========== */
+
+// The drives to and between sites go on the card instead of a paper log:
+//
+//   "travel": [{"date": "2025-01-06T00:00:00Z", "job_code": "29699", "from": "Yard",
+//               "to": "Kelowna GH", "kilometres": 142, "hours": 1.5}]
+//
+// Travel hours are logged apart from the card's hours; when they are paid
+// they are booked as entries too, like any other time. A template can map
+// an area of each week sheet for the week's trips,
+//
+//   "travel": {"first_row": 40, "rows": 5, "date_column": "B", "job_column": "C",
+//              "from_column": "D", "to_column": "E", "kilometres_column": "G",
+//              "hours_column": "H", "kilometres_total": "G45", "hours_total": "H45"}
+//
+// and when it maps none, or the trips don't fit it, they are all listed on
+// a "Travel" sheet added to the workbook instead. The summary report adds
+// up the kilometres and travel hours per employee and job.
+
+// TravelLine is one trip on a card
+type TravelLine struct {
+    Date       string  `json:"date"`
+    JobCode    string  `json:"job_code,omitempty"` // JOB NUMBER the trip was for
+    From       string  `json:"from,omitempty"`
+    To         string  `json:"to,omitempty"`
+    Kilometres float64 `json:"kilometres,omitempty"`
+    Hours      float64 `json:"hours,omitempty"`
+}
+
+// TravelArea is where a template takes each week's trips
+type TravelArea struct {
+    FirstRow         int    `json:"first_row"`
+    Rows             int    `json:"rows"`
+    DateColumn       string `json:"date_column,omitempty"`
+    JobColumn        string `json:"job_column,omitempty"`
+    FromColumn       string `json:"from_column,omitempty"`
+    ToColumn         string `json:"to_column,omitempty"`
+    KilometresColumn string `json:"kilometres_column,omitempty"`
+    HoursColumn      string `json:"hours_column,omitempty"`
+    KilometresTotal  string `json:"kilometres_total,omitempty"` // the week's totals
+    HoursTotal       string `json:"hours_total,omitempty"`
+}
+
+const travelSheet = "Travel"
+
+// checkTravel reports trips the card can't carry
+func checkTravel(v *validationResult, req TimecardRequest, t *Tenant) {
+    loc := cardLocation(req, t)
+    for i, l := range req.Travel {
+        label := fmt.Sprintf("travel[%d]", i)
+        if _, err := parseCalendarDate(l.Date, loc); err != nil {
+            v.errorf("%s: date %q is not an RFC 3339 date", label, l.Date)
+        }
+        switch {
+        case l.Kilometres < 0 || l.Hours < 0:
+            v.errorf("%s: kilometres and hours must not be negative", label)
+        case l.Hours > 24:
+            v.errorf("%s: %.2f travel hours in one day", label, l.Hours)
+        case l.Kilometres == 0 && l.Hours == 0:
+            v.errorf("%s: kilometres or hours is required", label)
+        }
+    }
+}
+
+// travelSums are the trips of one row of a report
+type travelSums struct {
+    Kilometres  float64 `json:"kilometres,omitempty"`
+    TravelHours float64 `json:"travel_hours,omitempty"`
+}
+
+func (s *travelSums) addTravel(l TravelLine) {
+    s.Kilometres += l.Kilometres
+    s.TravelHours += l.Hours
+}
+
+func (s travelSums) roundedTravel() travelSums {
+    return travelSums{Kilometres: roundAmount(s.Kilometres), TravelHours: roundHours(s.TravelHours)}
+}
+
+// weekTravel are the card's trips dated in the week from weekStart
+func weekTravel(req TimecardRequest, loc *time.Location, weekStart time.Time) []TravelLine {
+    var out []TravelLine
+    for _, l := range req.Travel {
+        day, err := parseCalendarDate(l.Date, loc)
+        if err != nil || day.Before(weekStart) || !day.Before(weekStart.AddDate(0, 0, 7)) {
+            continue
+        }
+        out = append(out, l)
+    }
+    return out
+}
+
+// placeTravel writes the card's trips into the template's area of its week
+// sheets, or on a travel sheet when they don't fit there
+func placeTravel(gc *genContext, f *excelize.File, sheets []string) {
+    if len(gc.req.Travel) == 0 {
+        return
+    }
+    if !travelFitsTemplate(gc, len(sheets)) {
+        if err := addTravelSheet(gc, f); err != nil {
+            gc.lg.Printf("Warning: travel sheet: %v", err)
+        }
+        return
+    }
+    for i, wk := range gc.req.Weeks {
+        if i >= len(sheets) {
+            break
+        }
+        start, _ := parseCalendarDate(wk.WeekStartDate, gc.loc)
+        writeWeekTravel(gc, f, sheets[i], start)
+    }
+}
+
+// travelFitsTemplate tells whether every trip has a place in the template's
+// area of the card's week sheets
+func travelFitsTemplate(gc *genContext, sheets int) bool {
+    area := gc.tpl.CellMap.Travel
+    if area == nil || area.Rows <= 0 {
+        return false
+    }
+    placed := 0
+    for i, wk := range gc.req.Weeks {
+        if i >= sheets {
+            break
+        }
+        start, err := parseCalendarDate(wk.WeekStartDate, gc.loc)
+        if err != nil {
+            return false
+        }
+        n := len(weekTravel(gc.req, gc.loc, start))
+        if n > area.Rows {
+            return false
+        }
+        placed += n
+    }
+    return placed == len(gc.req.Travel)
+}
+
+// writeWeekTravel fills the template's travel area with one week's trips
+func writeWeekTravel(gc *genContext, f *excelize.File, sheet string, weekStart time.Time) {
+    area := gc.tpl.CellMap.Travel
+    var total travelSums
+    for i, l := range weekTravel(gc.req, gc.loc, weekStart) {
+        row := area.FirstRow + i
+        day, _ := parseCalendarDate(l.Date, gc.loc)
+        cell := func(col string) string {
+            if col == "" {
+                return ""
+            }
+            return fmt.Sprintf("%s%d", col, row)
+        }
+        setMapped(f, sheet, cell(area.DateColumn), timeToExcelDate(day))
+        setMapped(f, sheet, cell(area.JobColumn), l.JobCode)
+        setMapped(f, sheet, cell(area.FromColumn), l.From)
+        setMapped(f, sheet, cell(area.ToColumn), l.To)
+        setMapped(f, sheet, cell(area.KilometresColumn), l.Kilometres)
+        setMapped(f, sheet, cell(area.HoursColumn), l.Hours)
+        total.addTravel(l)
+    }
+    total = total.roundedTravel()
+    setMapped(f, sheet, area.KilometresTotal, total.Kilometres)
+    setMapped(f, sheet, area.HoursTotal, total.TravelHours)
+}
+
+// addTravelSheet lists all the card's trips on a sheet of their own
+func addTravelSheet(gc *genContext, f *excelize.File) error {
+    if _, err := f.NewSheet(travelSheet); err != nil {
+        return err
+    }
+    header := []interface{}{"Date", "Job number", "From", "To", "Kilometres", "Hours"}
+    if gc.req.Bilingual {
+        header = []interface{}{bilingual("Date", "Date"), bilingual("Job number", "Numéro de projet"),
+            bilingual("From", "De"), bilingual("To", "À"), bilingual("Kilometres", "Kilomètres"), bilingual("Hours", "Heures")}
+    }
+    if err := f.SetSheetRow(travelSheet, "A1", &header); err != nil {
+        return err
+    }
+    lines := append([]TravelLine(nil), gc.req.Travel...)
+    sort.SliceStable(lines, func(a, b int) bool { return lines[a].Date < lines[b].Date })
+    var total travelSums
+    for i, l := range lines {
+        day, _ := parseCalendarDate(l.Date, gc.loc)
+        row := []interface{}{timeToExcelDate(day), l.JobCode, l.From, l.To, l.Kilometres, l.Hours}
+        if err := f.SetSheetRow(travelSheet, fmt.Sprintf("A%d", i+2), &row); err != nil {
+            return err
+        }
+        total.addTravel(l)
+    }
+    total = total.roundedTravel()
+    last := len(lines) + 2
+    totalRow := []interface{}{"Total", nil, nil, nil, total.Kilometres, total.TravelHours}
+    if err := f.SetSheetRow(travelSheet, fmt.Sprintf("A%d", last), &totalRow); err != nil {
+        return err
+    }
+
+    bold, err := f.NewStyle(&excelize.Style{Font: &excelize.Font{Bold: true}})
+    if err != nil {
+        return err
+    }
+    date, err := f.NewStyle(&excelize.Style{NumFmt: 14}) // m/d/yyyy
+    if err != nil {
+        return err
+    }
+    _ = f.SetCellStyle(travelSheet, "A1", "F1", bold)
+    _ = f.SetCellStyle(travelSheet, fmt.Sprintf("A%d", last), fmt.Sprintf("F%d", last), bold)
+    _ = f.SetCellStyle(travelSheet, "A2", fmt.Sprintf("A%d", last-1), date)
+    _ = f.SetColWidth(travelSheet, "A", "B", 14)
+    _ = f.SetColWidth(travelSheet, "C", "D", 24)
+    _ = f.SetColWidth(travelSheet, "E", "F", 12)
+    gc.lg.Printf("  %d trip(s) on the %s sheet, %.1f km, %.2f h", len(lines), travelSheet, total.Kilometres, total.TravelHours)
+    return nil
+}
+
+// travelSummaryLine is the card's travel for the body of its email
+func travelSummaryLine(req TimecardRequest) string {
+    var s travelSums
+    for _, l := range req.Travel {
+        s.addTravel(l)
+    }
+    s = s.roundedTravel()
+    var parts []string
+    if s.Kilometres > 0 {
+        parts = append(parts, fmt.Sprintf("%g km", s.Kilometres))
+    }
+    if s.TravelHours > 0 {
+        parts = append(parts, fmt.Sprintf("%.2f h", s.TravelHours))
+    }
+    return "Travel: " + strings.Join(parts, ", ")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+    "context"
+    "strings"
+    "testing"
+
+    "github.com/xuri/excelize/v2"
+)
+
+func TestCheckTravel(t *testing.T) {
+    tenant := &Tenant{ID: "travel-test"}
+    const mon = "2025-01-06T00:00:00Z"
+    tests := []struct {
+        name string
+        l    TravelLine
+        want string
+    }{
+        {"drive", TravelLine{Date: mon, JobCode: "A", Kilometres: 142, Hours: 1.5}, ""},
+        {"hours only", TravelLine{Date: mon, Hours: 2}, ""},
+        {"bad date", TravelLine{Date: "Jan 6", Kilometres: 10}, `date "Jan 6"`},
+        {"negative", TravelLine{Date: mon, Kilometres: -10}, "must not be negative"},
+        {"long day", TravelLine{Date: mon, Hours: 25}, "25.00 travel hours"},
+        {"empty", TravelLine{Date: mon}, "kilometres or hours is required"},
+    }
+    for _, tt := range tests {
+        var v validationResult
+        checkTravel(&v, TimecardRequest{Travel: []TravelLine{tt.l}}, tenant)
+        got := strings.Join(v.Errors, "; ")
+        if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+            t.Errorf("%s: errors %q, want %q", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestSummarizeTravel(t *testing.T) {
+    bob := TimecardRequest{EmployeeName: "Bob Smith", Entries: []Entry{worked("Mon", "A", 8)}, Travel: []TravelLine{
+        {Date: "2025-01-06T00:00:00Z", JobCode: "A", Kilometres: 142, Hours: 1.5},
+        {Date: "2025-01-07T00:00:00Z", JobCode: "B", Kilometres: 30.4, Hours: 0.5},
+        {Date: "2025-01-08T00:00:00Z", Kilometres: 12},
+    }}
+    rep := summarize(&Tenant{ID: "travel-test"}, []TimecardRequest{bob})
+    if rep.Kilometres != 184.4 || rep.TravelHours != 2 {
+        t.Errorf("totals = %+v", rep.travelSums)
+    }
+    if rep.Employees[0].Kilometres != 184.4 {
+        t.Errorf("Bob = %+v", rep.Employees[0].travelSums)
+    }
+    // B has travel but no hours
+    if len(rep.Jobs) != 2 || rep.Jobs[1].JobNumber != "B" || rep.Jobs[1].Kilometres != 30.4 || rep.Jobs[1].Total != 0 {
+        t.Errorf("jobs = %+v", rep.Jobs)
+    }
+    if got := travelSummaryLine(bob); got != "Travel: 184.4 km, 2.00 h" {
+        t.Errorf("summary line = %q", got)
+    }
+}
+
+func TestPlaceTravel(t *testing.T) {
+    req := TimecardRequest{
+        EmployeeName: "Bob Smith",
+        Weeks:        []WeekData{{WeekStartDate: "2025-01-05T00:00:00Z"}, {WeekStartDate: "2025-01-12T00:00:00Z"}},
+        Travel: []TravelLine{
+            {Date: "2025-01-07T00:00:00Z", JobCode: "A", From: "Yard", To: "Site", Kilometres: 40, Hours: 0.5},
+            {Date: "2025-01-06T00:00:00Z", JobCode: "A", Kilometres: 142, Hours: 1.5},
+            {Date: "2025-01-13T00:00:00Z", JobCode: "B", Kilometres: 12},
+        },
+    }
+    render := func(area *TravelArea) *excelize.File {
+        f := excelize.NewFile()
+        _, _ = f.NewSheet("Week 2")
+        gc := newGenContext(context.Background(), req, &Tenant{ID: "travel-test"}, loggerFrom(context.Background()))
+        cm := defaultCellMap()
+        cm.Travel = area
+        gc.tpl = &templateDef{CellMap: cm}
+        placeTravel(gc, f, []string{"Sheet1", "Week 2"})
+        return f
+    }
+    cell := func(f *excelize.File, sheet, c string) string {
+        v, _ := f.GetCellValue(sheet, c)
+        return v
+    }
+
+    // no area: everything on the travel sheet, in date order
+    f := render(nil)
+    if cell(f, travelSheet, "E2") != "142" || cell(f, travelSheet, "C3") != "Yard" || cell(f, travelSheet, "E5") != "194" {
+        rows, _ := f.GetRows(travelSheet)
+        t.Errorf("travel sheet = %q", rows)
+    }
+
+    // an area that fits: each week's trips on its sheet
+    area := &TravelArea{FirstRow: 40, Rows: 2, ToColumn: "E", KilometresColumn: "G", HoursColumn: "H", KilometresTotal: "G42", HoursTotal: "H42"}
+    f = render(area)
+    if i, _ := f.GetSheetIndex(travelSheet); i != -1 {
+        t.Errorf("travel sheet added though the trips fit")
+    }
+    if cell(f, "Sheet1", "E40") != "Site" || cell(f, "Sheet1", "G42") != "182" || cell(f, "Sheet1", "H42") != "2" || cell(f, "Week 2", "G40") != "12" {
+        t.Errorf("week 1 %q %q %q, week 2 %q", cell(f, "Sheet1", "E40"), cell(f, "Sheet1", "G42"), cell(f, "Sheet1", "H42"), cell(f, "Week 2", "G40"))
+    }
+
+    // too small: back to the sheet
+    area.Rows = 1
+    f = render(area)
+    if i, _ := f.GetSheetIndex(travelSheet); i == -1 || cell(f, "Sheet1", "G40") != "" {
+        t.Errorf("trips that don't fit should all go on the travel sheet")
+    }
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+    "fmt"
+    "net/http"
+    "net/mail"
+    "strings"
+)
+
+/* ==========
+   Validation
+   ========== */
+
+// validationResult separates problems that make a card unusable (Errors)
+// from things a human should look at but which don't block generation (Warnings).
+type validationResult struct {
+    Errors   []string `json:"errors,omitempty"`
+    Warnings []string `json:"warnings,omitempty"`
+}
+
+// Error lets exporters return a validationResult for the 400 response
+func (v validationResult) Error() string {
+    return strings.Join(v.Errors, "; ")
+}
+
+func (v *validationResult) errorf(format string, args ...interface{}) {
+    v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+}
+
+func (v *validationResult) warnf(format string, args ...interface{}) {
+    v.Warnings = append(v.Warnings, fmt.Sprintf(format, args...))
+}
+
+// allEntries returns the entries that will actually be written: the
+// per-week entries when weeks are present, otherwise the flat list.
+func allEntries(req TimecardRequest) []Entry {
+    if len(req.Weeks) == 0 {
+        return req.Entries
+    }
+    var out []Entry
+    for _, wk := range req.Weeks {
+        out = append(out, wk.Entries...)
+    }
+    return out
+}
+
+// hourTotals sums the regular and overtime hours that will be written
+func hourTotals(req TimecardRequest) (regular, overtime float64) {
+    for _, e := range allEntries(req) {
+        if e.Overtime {
+            overtime += e.Hours
+        } else {
+            regular += e.Hours
+        }
+    }
+    return regular, overtime
+}
+
+func validateTimecard(req TimecardRequest, t *Tenant) validationResult {
+    var v validationResult
+    loc := cardLocation(req, t)
+
+    if _, err := resolveTemplate(t.ID, req.Template); err != nil {
+        v.errorf("%v", err)
+    }
+
+    warnedLeave := make(map[string]bool)
+    for _, e := range allEntries(req) {
+        day, err := parseCalendarDate(e.Date, loc)
+        if err != nil {
+            continue
+        }
+        date := day.Format("2006-01-02")
+        if e.Hours == 0 || !e.worked() || warnedLeave[date] {
+            continue
+        }
+        if a, ok := approvedAbsenceOn(t.ID, req.EmployeeName, date); ok {
+            warnedLeave[date] = true
+            v.warnf("%s: hours entered on an approved %s day", date, a.Kind)
+        }
+    }
+    if req.ManagerEmail != "" {
+        if _, err := mail.ParseAddress(req.ManagerEmail); err != nil {
+            v.errorf("manager_email %q is not an email address", req.ManagerEmail)
+        }
+    }
+    checkEmployee(&v, req, t)
+    checkJobCodes(&v, req, t)
+    checkSignatures(&v, req)
+    checkPayCalendar(&v, req, t)
+    checkWeeks(&v, req, t)
+    checkEntryTypes(&v, req, t)
+    checkHolidays(&v, req, t)
+    checkLeaveBalance(&v, req, t)
+    checkEntryStarts(&v, req)
+    checkPunches(&v, req, t)
+    checkExpenses(&v, req, t)
+    checkTravel(&v, req, t)
+    checkCostCodes(&v, req)
+    checkPDFPassword(&v, req, t)
+    checkPDFA(&v, req, t)
+    checkPageSetup(&v, req)
+    checkPDFLayout(&v, req)
+    checkPDFSigning(&v, req, t)
+    noteBreaks(&v, req)
+    return v
+}
+
+// checkTimecard validates req and reports the outcome on w. It returns false
+// (after writing a 400) when the card has errors; warnings are surfaced in
+// the X-Timecard-Warnings header so binary responses can carry them too.
+func checkTimecard(w http.ResponseWriter, r *http.Request, req TimecardRequest) (validationResult, bool) {
+    auditCardTouched(r.Context(), "", req.EmployeeName, req.PayPeriodNum, req.Year)
+    v := validateTimecard(req, tenantFor(r))
+    if len(v.Errors) > 0 {
+        auditError(r.Context(), "validation failed: "+strings.Join(v.Errors, "; "))
+        finishProgress(r.Context(), fmt.Errorf("validation failed: %s", strings.Join(v.Errors, "; ")))
+        writeJSON(w, http.StatusBadRequest, v)
+        return v, false
+    }
+    if len(v.Warnings) > 0 {
+        w.Header().Set("X-Timecard-Warnings", strings.Join(v.Warnings, "; "))
+    }
+    return v, true
+}
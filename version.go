@@ -0,0 +1,96 @@
+package main
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "net/http"
+    "os"
+    "os/exec"
+    "runtime"
+    "runtime/debug"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ===================
+   Version / build info
+   =================== */
+
+// Set at build time:
+//   go build -ldflags "-X main.gitSHA=$(git rev-parse HEAD) -X main.buildTime=$(date -u +%FT%TZ)"
+var (
+    gitSHA    = ""
+    buildTime = ""
+)
+
+var (
+    sofficeVersionOnce sync.Once
+    sofficeVersion     string
+)
+
+// detectSofficeVersion runs `soffice --version` once per process
+func detectSofficeVersion() string {
+    sofficeVersionOnce.Do(func() {
+        ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+        defer cancel()
+        out, err := exec.CommandContext(ctx, "soffice", "--version").Output()
+        if err != nil {
+            sofficeVersion = "unavailable: " + err.Error()
+            return
+        }
+        sofficeVersion = strings.TrimSpace(string(out))
+    })
+    return sofficeVersion
+}
+
+// buildRevision falls back to the VCS stamp Go embeds when ldflags weren't set
+func buildRevision() (sha, built string) {
+    sha, built = gitSHA, buildTime
+    if info, ok := debug.ReadBuildInfo(); ok {
+        for _, s := range info.Settings {
+            switch s.Key {
+            case "vcs.revision":
+                if sha == "" {
+                    sha = s.Value
+                }
+            case "vcs.time":
+                if built == "" {
+                    built = s.Value
+                }
+            }
+        }
+    }
+    if sha == "" {
+        sha = "unknown"
+    }
+    if built == "" {
+        built = "unknown"
+    }
+    return sha, built
+}
+
+func fileChecksum(path string) string {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return "unavailable: " + err.Error()
+    }
+    sum := sha256.Sum256(data)
+    return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func versionHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    sha, built := buildRevision()
+    writeJSON(w, http.StatusOK, map[string]string{
+        "git_sha":           sha,
+        "build_time":        built,
+        "go_version":        runtime.Version(),
+        "template_checksum": fileChecksum("template.xlsx"),
+        "libreoffice":       detectSofficeVersion(),
+    })
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+    "strings"
+
+    "github.com/xuri/excelize/v2"
+)
+
+/* ===============
+   Draft watermark
+   =============== */
+
+// A preview copy is sent with
+//
+//   "draft": true
+//
+// and comes back marked so it can't pass for the submitted card: the PDF
+// has "DRAFT — NOT FOR PAYROLL" across every page, and each sheet of the
+// workbook has it in red in the middle of its print header (which the PDF
+// shows too). A draft is not kept as a timecard record, so it never
+// becomes a revision of the card.
+
+const draftWatermark = "DRAFT — NOT FOR PAYROLL"
+
+// stampDraft puts the draft banner in the centre of each sheet's header.
+// footers holds the template's headers and footers (see stampArtifactRef);
+// the returned copy has the banner in them, for the stamps after it.
+func stampDraft(gc *genContext, f *excelize.File, footers map[string]excelize.HeaderFooterOptions) map[string]excelize.HeaderFooterOptions {
+    if !gc.req.Draft {
+        return footers
+    }
+    out := make(map[string]excelize.HeaderFooterOptions, len(footers))
+    for _, sheet := range f.GetSheetList() {
+        hf := footers[sheet]
+        hf.OddHeader = draftHeader(hf.OddHeader)
+        if hf.DifferentOddEven {
+            hf.EvenHeader = draftHeader(hf.EvenHeader)
+        }
+        if hf.DifferentFirst {
+            hf.FirstHeader = draftHeader(hf.FirstHeader)
+        }
+        if err := f.SetHeaderFooter(sheet, &hf); err != nil {
+            gc.lg.Printf("Warning: no room for the draft banner in %s header: %v", sheet, err)
+            continue
+        }
+        out[sheet] = hf
+    }
+    return out
+}
+
+// draftHeader replaces the centre section of a header with the banner,
+// keeping its left and right sections
+func draftHeader(header string) string {
+    left, right := header, ""
+    if i := strings.Index(left, "&R"); i >= 0 {
+        left, right = left[:i], left[i:]
+    }
+    if i := strings.Index(left, "&C"); i >= 0 {
+        left = left[:i]
+    }
+    return left + `&C&"-,Bold"&14&KFF0000` + draftWatermark + right
+}
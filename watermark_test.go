@@ -0,0 +1,34 @@
+package main
+
+import (
+    "context"
+    "strings"
+    "testing"
+
+    "github.com/xuri/excelize/v2"
+)
+
+func TestDraftHeader(t *testing.T) {
+    for _, tt := range []struct{ header, want string }{
+        {"", `&C&"-,Bold"&14&KFF0000` + draftWatermark},
+        {"&LAcme&CTimecard&RPage &P", `&LAcme&C&"-,Bold"&14&KFF0000` + draftWatermark + "&RPage &P"},
+        {"&RPage &P", `&C&"-,Bold"&14&KFF0000` + draftWatermark + "&RPage &P"},
+    } {
+        if got := draftHeader(tt.header); got != tt.want {
+            t.Errorf("draftHeader(%q) = %q, want %q", tt.header, got, tt.want)
+        }
+    }
+
+    req := TimecardRequest{EmployeeName: "Bob Smith", Draft: true}
+    gc := newGenContext(context.Background(), req, &Tenant{ID: "draft-test"}, loggerFrom(context.Background()))
+    footers := stampDraft(gc, excelize.NewFile(), map[string]excelize.HeaderFooterOptions{"Sheet1": {OddFooter: "&LPage &P"}})
+    if hf := footers["Sheet1"]; !strings.Contains(hf.OddHeader, draftWatermark) || hf.OddFooter != "&LPage &P" {
+        t.Errorf("footers = %+v", hf)
+    }
+    if got := req.pdfOptions(nil).exportFilter(); !strings.Contains(got, `"Watermark":{"type":"string","value":"DRAFT — NOT FOR PAYROLL"}`) {
+        t.Errorf("filter = %q", got)
+    }
+    if id := keepTimecardRecord(context.Background(), &Tenant{ID: "draft-test"}, "xlsx", []byte(`{}`), req); id != "" {
+        t.Errorf("draft kept as record %s", id)
+    }
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+    "context"
+    "net/http"
+    "net/url"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "golang.org/x/crypto/bcrypt"
+    "golang.org/x/net/webdav"
+)
+
+/* ================
+   WebDAV (read-only)
+   ================ */
+
+// /dav/ serves a tenant's artifacts as a read-only WebDAV share, so payroll
+// can mount it as a network drive. Each tenant that wants one sets
+//
+//   "webdav": {"username": "payroll", "password_hash": "<bcrypt>"}
+//
+// in tenants.json (e.g. from `htpasswd -nbBC 10 payroll secret`). The
+// share root is that tenant's directory under artifactsDir().
+
+// WebDAVCredentials are a tenant's Basic-auth login for /dav/
+type WebDAVCredentials struct {
+    Username     string `json:"username"`
+    PasswordHash string `json:"password_hash"`
+}
+
+// readOnlyFS refuses everything but reads
+type readOnlyFS struct {
+    webdav.Dir
+}
+
+func (readOnlyFS) Mkdir(context.Context, string, os.FileMode) error { return os.ErrPermission }
+func (readOnlyFS) RemoveAll(context.Context, string) error          { return os.ErrPermission }
+func (readOnlyFS) Rename(context.Context, string, string) error     { return os.ErrPermission }
+
+func (fs readOnlyFS) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+    if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+        return nil, os.ErrPermission
+    }
+    return fs.Dir.OpenFile(ctx, name, flag, perm)
+}
+
+// webdavTenant finds the tenant whose WebDAV login matches r
+func webdavTenant(r *http.Request) (*Tenant, bool) {
+    user, pass, ok := r.BasicAuth()
+    if !ok {
+        return nil, false
+    }
+    for _, t := range loadTenants() {
+        if t.WebDAV == nil || t.WebDAV.Username != user {
+            continue
+        }
+        hash := strings.Replace(t.WebDAV.PasswordHash, "$2y$", "$2a$", 1) // htpasswd writes $2y$
+        if bcrypt.CompareHashAndPassword([]byte(hash), []byte(pass)) == nil {
+            return t, true
+        }
+    }
+    return nil, false
+}
+
+func webdavHandler(w http.ResponseWriter, r *http.Request) {
+    t, ok := webdavTenant(r)
+    if !ok {
+        w.Header().Set("WWW-Authenticate", `Basic realm="timecards"`)
+        http.Error(w, "unauthorized", http.StatusUnauthorized)
+        return
+    }
+    setAccessIdentity(r.Context(), "webdav:"+t.ID)
+
+    switch r.Method {
+    case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+    default:
+        // Without LOCK, Office opens files read-only, which is what we want
+        w.Header().Set("Allow", "OPTIONS, GET, HEAD, PROPFIND")
+        http.Error(w, "read-only share", http.StatusMethodNotAllowed)
+        return
+    }
+
+    root := filepath.Join(artifactsDir(), pathSegment(t.ID))
+    if err := os.MkdirAll(root, 0o755); err != nil {
+        httpError(w, r, "share unavailable", http.StatusInternalServerError)
+        return
+    }
+    h := &webdav.Handler{
+        Prefix:     "/dav",
+        FileSystem: readOnlyFS{webdav.Dir(root)},
+        LockSystem: webdav.NewMemLS(), // required, never used read-only
+        Logger: func(r *http.Request, err error) {
+            if err != nil && !os.IsNotExist(err) {
+                loggerFrom(r.Context()).Printf("WebDAV %s %s: %v", r.Method, r.URL.Path, err)
+            }
+        },
+    }
+    h.ServeHTTP(w, r)
+}
+
+// timecardLink is the share URL of a record's latest stored file, or ""
+// when nothing is stored or PUBLIC_BASE_URL (the server's external
+// address) isn't set.
+func timecardLink(id string) string {
+    base := os.Getenv("PUBLIC_BASE_URL")
+    rec, ok := timecards.Get(id)
+    if base == "" || !ok || len(rec.Artifacts) == 0 {
+        return ""
+    }
+    // The share is rooted at the tenant's own directory
+    segments := strings.Split(rec.Artifacts[len(rec.Artifacts)-1], "/")[1:]
+    for i, s := range segments {
+        segments[i] = url.PathEscape(s)
+    }
+    return strings.TrimRight(base, "/") + "/dav/" + strings.Join(segments, "/")
+}
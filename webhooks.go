@@ -0,0 +1,283 @@
+package main
+
+import (
+    "bytes"
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "sync"
+    "time"
+)
+
+/* ========
+   Webhooks
+   ======== */
+
+// Activity is POSTed as signed JSON events to every URL in WEBHOOK_URLS, so
+// the internal dashboard doesn't have to poll:
+//
+//   timecard.generated   a workbook, PDF or bundle was returned
+//   email.sent           a card was emailed
+//   email.failed         sending it failed (data.error says why)
+//   conversion.failed    LibreOffice could not produce a PDF
+//   timecard.approved    a manager approved a card (see approval.go)
+//   timecard.rejected    a manager sent a card back (data.comment says why)
+//
+// WEBHOOK_EVENTS limits which types are sent. With WEBHOOK_SECRET set, every
+// delivery carries
+//
+//   X-Timecard-Signature: t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">
+//
+// which receivers should recompute, rejecting stale timestamps. Delivery is
+// best effort: three attempts with backoff, never blocking a request, and
+// whatever is still pending a few seconds into shutdown is dropped.
+
+type webhookEvent struct {
+    ID        string      `json:"id"`
+    Type      string      `json:"type"`
+    CreatedAt time.Time   `json:"created_at"`
+    RequestID string      `json:"request_id,omitempty"`
+    Tenant    string      `json:"tenant,omitempty"`
+    Data      interface{} `json:"data"`
+}
+
+type webhookDelivery struct {
+    url     string
+    event   webhookEvent
+    body    []byte
+    attempt int
+}
+
+type webhookDispatcher struct {
+    urls    []string
+    events  map[string]bool // nil sends every type
+    secret  []byte
+    client  *http.Client
+    queue   chan *webhookDelivery
+    pending sync.WaitGroup // queued, in flight or waiting to retry
+}
+
+const webhookAttempts = 3
+
+var (
+    webhooksOnce   sync.Once
+    theWebhooks    *webhookDispatcher
+    webhookBackoff = []time.Duration{2 * time.Second, 30 * time.Second}
+)
+
+// webhooks returns the dispatcher, or nil when no URLs are configured
+func webhooks() *webhookDispatcher {
+    webhooksOnce.Do(func() {
+        urls := splitList(os.Getenv("WEBHOOK_URLS"))
+        if len(urls) == 0 {
+            return
+        }
+        d := &webhookDispatcher{
+            urls:   urls,
+            secret: []byte(os.Getenv("WEBHOOK_SECRET")),
+            client: &http.Client{Timeout: 10 * time.Second},
+            queue:  make(chan *webhookDelivery, 1024),
+        }
+        if types := splitList(os.Getenv("WEBHOOK_EVENTS")); len(types) > 0 {
+            d.events = map[string]bool{}
+            for _, t := range types {
+                d.events[t] = true
+            }
+        }
+        for i := 0; i < 2; i++ {
+            go d.loop()
+        }
+        theWebhooks = d
+        log.Printf("Webhooks enabled for %d URL(s)", len(urls))
+    })
+    return theWebhooks
+}
+
+var (
+    eventSubscribersMu sync.Mutex
+    eventSubscribers   []func(webhookEvent)
+)
+
+// subscribeEvents adds an in-process consumer of every event (chat
+// notifiers and the like). It is called from the request goroutine, so it
+// must hand the event off rather than do network work itself.
+func subscribeEvents(fn func(webhookEvent)) {
+    eventSubscribersMu.Lock()
+    defer eventSubscribersMu.Unlock()
+    eventSubscribers = append(eventSubscribers, fn)
+}
+
+// emitEvent passes an event of type typ to subscribers and queues it for
+// every webhook URL
+func emitEvent(ctx context.Context, typ, tenant string, data interface{}) {
+    ev := webhookEvent{
+        ID:        newID(),
+        Type:      typ,
+        CreatedAt: now().UTC(),
+        RequestID: requestIDFrom(ctx),
+        Tenant:    tenant,
+        Data:      data,
+    }
+    eventSubscribersMu.Lock()
+    subs := eventSubscribers
+    eventSubscribersMu.Unlock()
+    for _, fn := range subs {
+        fn(ev)
+    }
+
+    d := webhooks()
+    if d == nil || (d.events != nil && !d.events[typ]) {
+        return
+    }
+    body, err := json.Marshal(ev)
+    if err != nil {
+        log.Printf("webhook %s: %v", typ, err)
+        return
+    }
+    for _, u := range d.urls {
+        d.pending.Add(1)
+        d.enqueue(&webhookDelivery{url: u, event: ev, body: body})
+    }
+}
+
+// enqueue hands a pending delivery to the workers, or drops it
+func (d *webhookDispatcher) enqueue(dl *webhookDelivery) {
+    select {
+    case d.queue <- dl:
+    default:
+        log.Printf("webhook queue full, dropping %s %s", dl.event.Type, dl.event.ID)
+        d.pending.Done()
+    }
+}
+
+func (d *webhookDispatcher) loop() {
+    for dl := range d.queue {
+        dl.attempt++
+        err := d.post(dl)
+        if err == nil {
+            statWebhooksSent.Add(1)
+            d.pending.Done()
+            continue
+        }
+        if dl.attempt >= webhookAttempts {
+            statWebhooksFailed.Add(1)
+            log.Printf("webhook %s %s to %s failed after %d attempts: %v", dl.event.Type, dl.event.ID, dl.url, dl.attempt, err)
+            d.pending.Done()
+            continue
+        }
+        dl := dl
+        time.AfterFunc(webhookBackoff[dl.attempt-1], func() { d.enqueue(dl) })
+    }
+}
+
+// post makes one delivery attempt; anything but a 2xx is a failure
+func (d *webhookDispatcher) post(dl *webhookDelivery) error {
+    req, err := http.NewRequest(http.MethodPost, dl.url, bytes.NewReader(dl.body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    req.Header.Set("User-Agent", "timecard-api-webhooks")
+    req.Header.Set("X-Timecard-Event", dl.event.Type)
+    req.Header.Set("X-Timecard-Delivery", dl.event.ID)
+    if len(d.secret) > 0 {
+        req.Header.Set("X-Timecard-Signature", signWebhook(d.secret, time.Now(), dl.body))
+    }
+    resp, err := d.client.Do(req)
+    if err != nil {
+        return err
+    }
+    resp.Body.Close()
+    if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+        return fmt.Errorf("receiver returned %s", resp.Status)
+    }
+    return nil
+}
+
+// signWebhook is the X-Timecard-Signature value for body sent at t
+func signWebhook(secret []byte, t time.Time, body []byte) string {
+    ts := fmt.Sprint(t.Unix())
+    mac := hmac.New(sha256.New, secret)
+    mac.Write([]byte(ts + "."))
+    mac.Write(body)
+    return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}
+
+// flushWebhooks waits up to timeout for pending deliveries at shutdown
+func flushWebhooks(timeout time.Duration) {
+    d := webhooks()
+    if d == nil {
+        return
+    }
+    done := make(chan struct{})
+    go func() {
+        d.pending.Wait()
+        close(done)
+    }()
+    select {
+    case <-done:
+    case <-time.After(timeout):
+        log.Printf("Shutting down with webhook deliveries still pending")
+    }
+}
+
+/* ----- event payloads ----- */
+
+// timecardEventData describes a card that was generated or sent
+func timecardEventData(id string, req TimecardRequest, format string, size int) map[string]interface{} {
+    regular, overtime := hourTotals(req)
+    data := map[string]interface{}{
+        "employee":       req.EmployeeName,
+        "pay_period_num": req.PayPeriodNum,
+        "year":           req.Year,
+        "format":         format,
+        "bytes":          size,
+        "regular_hours":  regular,
+        "overtime_hours": overtime,
+        "total_hours":    regular + overtime,
+    }
+    if id != "" {
+        data["timecard_id"] = id
+    }
+    return data
+}
+
+// emailEventData adds the recipients (and the error, if any) to a card's event data
+func emailEventData(id string, req EmailTimecardRequest, size int, sendErr error) map[string]interface{} {
+    data := timecardEventData(id, req.TimecardRequest, "xlsx", size)
+    data["to"] = strings.TrimSpace(req.To)
+    if req.CC != nil && *req.CC != "" {
+        data["cc"] = *req.CC
+    }
+    if sendErr != nil {
+        data["error"] = sendErr.Error()
+    }
+    return data
+}
+
+// queuedSubscriber runs send for each event on a goroutine of its own,
+// dropping events while more than 256 are waiting.
+func queuedSubscriber(name string, send func(webhookEvent) error) func(webhookEvent) {
+    queue := make(chan webhookEvent, 256)
+    go func() {
+        for ev := range queue {
+            if err := send(ev); err != nil {
+                log.Printf("%s notification for %s %s: %v", name, ev.Type, ev.ID, err)
+            }
+        }
+    }()
+    return func(ev webhookEvent) {
+        select {
+        case queue <- ev:
+        default:
+            log.Printf("%s notification queue full, dropping %s %s", name, ev.Type, ev.ID)
+        }
+    }
+}
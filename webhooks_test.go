@@ -0,0 +1,115 @@
+package main
+
+import (
+    "context"
+    "crypto/hmac"
+    "encoding/json"
+    "io"
+    "net/http"
+    "net/http/httptest"
+    "strconv"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+)
+
+func TestSignWebhook(t *testing.T) {
+    at := time.Date(2025, 1, 13, 9, 30, 0, 0, time.UTC)
+    got := signWebhook([]byte("whsec_test"), at, []byte(`{"id":"1"}`))
+    want := "t=1736760600,v1=383e3cb8d4f169ea062c444955b4fd9797bee98a0aa7fb216ad52af1c091a452"
+    if got != want {
+        t.Errorf("got %s, want %s", got, want)
+    }
+}
+
+// webhookReceiver records deliveries, failing the first fail of them
+type webhookReceiver struct {
+    mu       sync.Mutex
+    fail     int
+    attempts int
+    events   []webhookEvent
+    headers  []http.Header
+    bodies   [][]byte
+}
+
+func (rc *webhookReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+    body, _ := io.ReadAll(r.Body)
+    rc.mu.Lock()
+    defer rc.mu.Unlock()
+    rc.attempts++
+    if rc.attempts <= rc.fail {
+        w.WriteHeader(http.StatusServiceUnavailable)
+        return
+    }
+    var ev webhookEvent
+    _ = json.Unmarshal(body, &ev)
+    rc.events = append(rc.events, ev)
+    rc.headers = append(rc.headers, r.Header.Clone())
+    rc.bodies = append(rc.bodies, body)
+}
+
+// withWebhooks sends the test's webhooks to rc, signed with secret
+func withWebhooks(t *testing.T, rc *webhookReceiver, secret, events string) {
+    t.Helper()
+    ts := httptest.NewServer(rc)
+    t.Cleanup(ts.Close)
+    t.Setenv("WEBHOOK_URLS", ts.URL)
+    t.Setenv("WEBHOOK_SECRET", secret)
+    t.Setenv("WEBHOOK_EVENTS", events)
+    savedBackoff := webhookBackoff
+    webhookBackoff = []time.Duration{time.Millisecond, time.Millisecond}
+    webhooksOnce, theWebhooks = sync.Once{}, nil
+    t.Cleanup(func() {
+        webhookBackoff = savedBackoff
+        webhooksOnce, theWebhooks = sync.Once{}, nil
+    })
+}
+
+func TestWebhookDelivery(t *testing.T) {
+    rc := &webhookReceiver{fail: 1}
+    withWebhooks(t, rc, "whsec_test", "email.sent, email.failed")
+
+    req := TimecardRequest{EmployeeName: "Bob Smith", PayPeriodNum: 3, Year: 2025}
+    emitEvent(context.Background(), "timecard.generated", "acme", timecardEventData("abc", req, "xlsx", 10))
+    emitEvent(context.Background(), "email.sent", "acme", timecardEventData("abc", req, "xlsx", 10))
+    flushWebhooks(5 * time.Second)
+
+    rc.mu.Lock()
+    defer rc.mu.Unlock()
+    // the filtered type isn't sent; the other is retried past the 503
+    if rc.attempts != 2 || len(rc.events) != 1 {
+        t.Fatalf("%d attempts, %d delivered; want 2 and 1", rc.attempts, len(rc.events))
+    }
+    ev, h := rc.events[0], rc.headers[0]
+    if ev.Type != "email.sent" || ev.Tenant != "acme" || h.Get("X-Timecard-Event") != "email.sent" || h.Get("X-Timecard-Delivery") != ev.ID {
+        t.Errorf("event %+v with headers %v", ev, h)
+    }
+    if data, _ := ev.Data.(map[string]interface{}); data["employee"] != "Bob Smith" || data["timecard_id"] != "abc" {
+        t.Errorf("data = %v", ev.Data)
+    }
+
+    // the signature is the receiver's to recompute
+    sig := h.Get("X-Timecard-Signature")
+    ts, _, _ := strings.Cut(strings.TrimPrefix(sig, "t="), ",")
+    sec, err := strconv.ParseInt(ts, 10, 64)
+    if err != nil {
+        t.Fatalf("signature %q", sig)
+    }
+    if want := signWebhook([]byte("whsec_test"), time.Unix(sec, 0), rc.bodies[0]); !hmac.Equal([]byte(sig), []byte(want)) {
+        t.Errorf("signature %s, want %s", sig, want)
+    }
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+    rc := &webhookReceiver{fail: 100}
+    withWebhooks(t, rc, "", "")
+    emitEvent(context.Background(), "conversion.failed", "", map[string]interface{}{"error": "soffice exited"})
+    flushWebhooks(5 * time.Second)
+
+    rc.mu.Lock()
+    defer rc.mu.Unlock()
+    if rc.attempts != webhookAttempts {
+        t.Errorf("%d attempts, want %d", rc.attempts, webhookAttempts)
+    }
+}
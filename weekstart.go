@@ -0,0 +1,83 @@
+package main
+
+import (
+    "fmt"
+    "time"
+)
+
+/* =============
+   Payroll weeks
+   ============= */
+
+// A card's weeks are the tenant's payroll weeks, seven days from the day
+// they start on:
+//
+//   "week_start": "monday"
+//
+// Sunday when left out. The CSV import and crew cards start their weeks
+// on it, weekly overtime (otrules.go) and the overtime report's
+// employee-weeks (otreport.go) count by it, and a card's week_start_date
+// must fall on it once a tenant sets it (semi-monthly and monthly pay
+// periods aside, whose weeks run from the 1st and the 16th). Every entry of
+// a week must be dated inside that week, or it would have no row on the
+// sheet.
+
+func validateWeekStart(t *Tenant) error {
+    first, ok := parseWeekday(t.WeekStart)
+    if !ok {
+        return fmt.Errorf("week_start %q is not a day of the week", t.WeekStart)
+    }
+    if cal := t.PayCalendar; cal != nil && !cal.byMonth() {
+        if anchor, err := time.Parse("2006-01-02", cal.Anchor); err == nil && anchor.Weekday() != first {
+            return fmt.Errorf("week_start is %s but the pay calendar's anchor %s is a %s", first, cal.Anchor, anchor.Weekday())
+        }
+    }
+    return nil
+}
+
+// weekStartDay is the day the tenant's payroll week starts on
+func (t *Tenant) weekStartDay() time.Weekday {
+    if t == nil || t.WeekStart == "" {
+        return time.Sunday
+    }
+    first, _ := parseWeekday(t.WeekStart)
+    return first
+}
+
+// startOfWeek is the first day of the week day falls in, for weeks
+// starting on first
+func startOfWeek(day time.Time, first time.Weekday) time.Time {
+    back := (int(day.Weekday()) - int(first) + 7) % 7
+    return day.AddDate(0, 0, -back)
+}
+
+// checkWeeks rejects week starts off the tenant's payroll week and entries
+// dated outside the week they were sent in
+func checkWeeks(v *validationResult, req TimecardRequest, t *Tenant) {
+    loc := cardLocation(req, t)
+    first := t.weekStartDay()
+    // Semi-monthly and monthly periods start their weeks on the 1st and 16th
+    checkDay := t != nil && t.WeekStart != ""
+    if cal := t.payCalendar(); cal != nil && cal.byMonth() {
+        checkDay = false
+    }
+    for i, wk := range req.Weeks {
+        start, err := parseCalendarDate(wk.WeekStartDate, loc)
+        if err != nil {
+            continue
+        }
+        if checkDay && start.Weekday() != first {
+            v.errorf("weeks[%d]: week_start_date %s is a %s; the payroll week starts on %s",
+                i, start.Format("2006-01-02"), start.Weekday(), first)
+        }
+        end := start.AddDate(0, 0, wk.days())
+        for _, e := range wk.Entries {
+            day, err := parseCalendarDate(e.Date, loc)
+            if err != nil || (!day.Before(start) && day.Before(end)) {
+                continue
+            }
+            v.errorf("weeks[%d]: entry %s on job %s is outside the week of %s to %s",
+                i, day.Format("2006-01-02"), e.JobCode, start.Format("2006-01-02"), end.AddDate(0, 0, -1).Format("2006-01-02"))
+        }
+    }
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+    "reflect"
+    "strings"
+    "testing"
+    "time"
+)
+
+func TestStartOfWeek(t *testing.T) {
+    wed := time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC)
+    for _, tt := range []struct {
+        day   time.Time
+        first time.Weekday
+        want  string
+    }{
+        {wed, time.Sunday, "2025-01-05"},
+        {wed, time.Monday, "2025-01-06"},
+        {wed, time.Wednesday, "2025-01-08"},
+        {wed, time.Thursday, "2025-01-02"},
+        {testWeek, time.Monday, "2024-12-30"}, // a Sunday ends a Monday week
+    } {
+        if got := startOfWeek(tt.day, tt.first).Format("2006-01-02"); got != tt.want {
+            t.Errorf("startOfWeek(%s, %s) = %s, want %s", tt.day.Format("Mon 2006-01-02"), tt.first, got, tt.want)
+        }
+    }
+}
+
+func TestCheckWeeks(t *testing.T) {
+    monday := &Tenant{ID: "week-test", WeekStart: "monday"}
+    card := func(start string, entries ...Entry) TimecardRequest {
+        return TimecardRequest{Weeks: []WeekData{{WeekStartDate: start, Entries: entries}}}
+    }
+    for _, tt := range []struct {
+        name   string
+        tenant *Tenant
+        req    TimecardRequest
+        want   string
+    }{
+        {"default Sunday", &Tenant{ID: "week-test"}, card(testDate("Sun"), worked("Sat", "A", 8)), ""},
+        {"Monday week", monday, card(testDate("Mon"), worked("+Sun", "A", 8)), ""},
+        {"wrong start day", monday, card(testDate("Sun"), worked("Mon", "A", 8)),
+            "week_start_date 2025-01-05 is a Sunday; the payroll week starts on Monday"},
+        {"unset start day allows any", &Tenant{ID: "week-test"}, card(testDate("Mon"), worked("Tue", "A", 8)), ""},
+        {"entry before the week", &Tenant{ID: "week-test"}, card(testDate("Mon"), worked("Sun", "A", 8)),
+            "entry 2025-01-05 on job A is outside the week of 2025-01-06 to 2025-01-12"},
+        {"entry after the week", monday, card(testDate("Mon"), worked("+Mon", "A", 8)), "entry 2025-01-13"},
+    } {
+        var v validationResult
+        checkWeeks(&v, tt.req, tt.tenant)
+        got := strings.Join(v.Errors, "; ")
+        if tt.want == "" && got != "" || !strings.Contains(got, tt.want) {
+            t.Errorf("%s: errors %q, want %q", tt.name, got, tt.want)
+        }
+    }
+
+    if err := validateWeekStart(&Tenant{WeekStart: "mon", PayCalendar: &PayCalendar{Anchor: "2025-01-05"}}); err == nil {
+        t.Error("a Monday week on a calendar anchored on a Sunday")
+    }
+    if err := validateWeekStart(&Tenant{WeekStart: "weekday"}); err == nil {
+        t.Error("week_start weekday accepted")
+    }
+}
+
+func TestMondayWeeks(t *testing.T) {
+    monday := &Tenant{ID: "week-test", WeekStart: "monday"}
+
+    // an import starts the card on the Monday of its first entry
+    req := TimecardRequest{Entries: []Entry{worked("Wed", "A", 8), worked("+Sun", "A", 8)}}
+    if !importWeeks(&req, time.UTC, time.Time{}, monday.weekStartDay(), nil) {
+        t.Fatal("entries don't fit")
+    }
+    if req.Weeks[0].WeekStartDate != testDate("Mon") || len(req.Weeks[0].Entries) != 2 {
+        t.Errorf("week 1 from %s with %d entries", req.Weeks[0].WeekStartDate, len(req.Weeks[0].Entries))
+    }
+
+    // weekly overtime restarts on Monday
+    req = TimecardRequest{Entries: []Entry{worked("Sun", "A", 30), worked("Mon", "A", 30), worked("Tue", "A", 15)}}
+    monday.OvertimeRules = &OvertimeRules{WeeklyOvertimeAfter: 40}
+    applyOvertimeRules(&req, monday)
+    want := []string{"Sun A 30", "Mon A 30", "Tue A 10", "Tue A 5 ot"}
+    if got := describeEntries(req.Entries); !reflect.DeepEqual(got, want) {
+        t.Errorf("got  %q\nwant %q", got, want)
+    }
+}